@@ -32,6 +32,32 @@ var (
 		Name:        "start",
 		Description: "`build` and `run` the specified agent (see COMMANDS)",
 	}
+
+	lspCmd = cli.Command{
+		Name:            "lsp",
+		Description:     "`build` and `run` the " + sublime.AgentName + " agent as a Language Server Protocol server on stdin/stdout",
+		Action:          lspAction,
+		SkipFlagParsing: true,
+		SkipArgReorder:  true,
+	}
+
+	replayCmd = cli.Command{
+		Name:            "replay",
+		Usage:           "replay <file>",
+		Description:     "`build` and `run` the " + sublime.AgentName + " agent, feeding it the requests in a session recording (see -record)",
+		Action:          replayAction,
+		SkipFlagParsing: true,
+		SkipArgReorder:  true,
+	}
+
+	schemaCmd = cli.Command{
+		Name:            "schema",
+		Usage:           "schema [--codec json]",
+		Description:     "`build` and `run` the " + sublime.AgentName + " agent, writing a JSON Schema describing its IPC protocol, including all registered actions and client actions, to stdout",
+		Action:          schemaAction,
+		SkipFlagParsing: true,
+		SkipArgReorder:  true,
+	}
 )
 
 func init() {
@@ -63,6 +89,11 @@ func Main() {
 		buildCmd,
 		runCmd,
 		startCmd,
+		lspCmd,
+		replayCmd,
+		schemaCmd,
+		statusCmd,
+		profileCmd,
 		devCmd,
 		ciCmd,
 	}
@@ -77,7 +108,26 @@ func appendSubCmd(cmd *cli.Command, cmds mgcli.Commands, subCmd cli.Command) {
 }
 
 func startAction(cx *cli.Context) error {
-	mc := cmdMap[cx.Command.Name]
+	return startAgent(cx, cmdMap[cx.Command.Name], cx.Args())
+}
+
+func lspAction(cx *cli.Context) error {
+	return startAgent(cx, cmdMap[sublime.AgentName], append([]string{"-lsp"}, cx.Args()...))
+}
+
+func replayAction(cx *cli.Context) error {
+	args := cx.Args()
+	if len(args) == 0 {
+		return mgcli.Error("replay", fmt.Errorf("missing session recording file name"))
+	}
+	return startAgent(cx, cmdMap[sublime.AgentName], append([]string{"-replay", args[0]}, args[1:]...))
+}
+
+func schemaAction(cx *cli.Context) error {
+	return startAgent(cx, cmdMap[sublime.AgentName], append([]string{"-schema"}, cx.Args()...))
+}
+
+func startAgent(cx *cli.Context, mc mgcli.Commands, args []string) error {
 	app := &mgcli.NewApp().App
 	app.Name = mc.Name
 	newCtx := func(args []string) *cli.Context {
@@ -94,7 +144,7 @@ func startAction(cx *cli.Context) error {
 		}
 	}
 	if mc.Run != nil {
-		return mc.Run.Run(newCtx(cx.Args()))
+		return mc.Run.Run(newCtx(args))
 	}
 	return nil
 }
@@ -0,0 +1,51 @@
+package margo
+
+import (
+	"fmt"
+	"github.com/dustin/go-humanize"
+	"github.com/urfave/cli"
+	"margo.sh/mg"
+	"margo.sh/mgcli"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+var profileCmd = cli.Command{
+	Name:        "profile",
+	Description: "profile connects to a running agent started with -listen and prints the time taken by each reducer, slowest first",
+	Flags:       queryFlags,
+	Action: mgcli.Action(func(cx *cli.Context) error {
+		if cx.String("addr") == "" {
+			return fmt.Errorf("the -addr flag is required")
+		}
+		rp, err := mg.QueryReducerProfile(cx.String("addr"), cx.String("token"), cx.String("fingerprint"), cx.String("codec"), cx.Duration("timeout"))
+		if err != nil {
+			return err
+		}
+		printProfile(rp)
+		return nil
+	}),
+}
+
+func printProfile(rp mg.ReducerProfile) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	defer w.Flush()
+
+	fmt.Fprintf(w, "Reducer profile since %s (%s ago):\n\n", rp.Since.Format("15:04:05"), time.Since(rp.Since).Round(time.Second))
+	fmt.Fprintf(w, "Total\tMax\tAvg\tCalls\tAllocs\t  Reducer\n")
+	for _, rt := range rp.Reducers {
+		avg := time.Duration(0)
+		if rt.Calls > 0 {
+			avg = rt.Total / time.Duration(rt.Calls)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t  %s\n",
+			rt.Total.Round(time.Microsecond),
+			rt.Max.Round(time.Microsecond),
+			avg.Round(time.Microsecond),
+			rt.Calls,
+			humanize.IBytes(rt.AllocBytes),
+			rt.Label,
+		)
+	}
+}
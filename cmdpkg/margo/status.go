@@ -0,0 +1,92 @@
+package margo
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"margo.sh/mg"
+	"margo.sh/mgcli"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// queryFlags are the flags of the commands that connect to a running agent
+var queryFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "addr",
+		Usage: "The agent's -listen `network:address` e.g. unix:/tmp/margo.sock, tcp:127.0.0.1:9000 or tls:127.0.0.1:9000",
+	},
+	cli.StringFlag{
+		Name:   "token",
+		EnvVar: "MARGO_IPC_TOKEN",
+		Usage:  "The agent's token, as printed in its log when it started",
+	},
+	cli.StringFlag{
+		Name:   "fingerprint",
+		EnvVar: "MARGO_IPC_FINGERPRINT",
+		Usage:  "The fingerprint of the agent's certificate, as printed in its log when it started. Required for tls addresses",
+	},
+	cli.StringFlag{
+		Name:  "codec",
+		Value: mg.DefaultCodec,
+		Usage: fmt.Sprintf("The agent's IPC codec: %s", mg.CodecNamesStr),
+	},
+	cli.DurationFlag{
+		Name:  "timeout",
+		Value: 10 * time.Second,
+		Usage: "Give up if the agent doesn't respond within this long",
+	},
+}
+
+var statusCmd = cli.Command{
+	Name:        "status",
+	Description: "status connects to a running agent started with -listen and prints a summary of its health",
+	Flags:       queryFlags,
+	Action: mgcli.Action(func(cx *cli.Context) error {
+		if cx.String("addr") == "" {
+			return fmt.Errorf("the -addr flag is required")
+		}
+		as, err := mg.QueryAgentStatus(cx.String("addr"), cx.String("token"), cx.String("fingerprint"), cx.String("codec"), cx.Duration("timeout"))
+		if err != nil {
+			return err
+		}
+		printStatus(as)
+		return nil
+	}),
+}
+
+func printStatus(as mg.AgentStatus) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintf(w, "Agent:\t%s\n", as.Name)
+	fmt.Fprintf(w, "Uptime:\t%s\n", time.Since(as.Started).Round(time.Second))
+	fmt.Fprintf(w, "Clients:\t%d\n", as.Clients)
+	fmt.Fprintf(w, "Requests:\t%d (%d in flight)\n", as.Requests, as.InFlight)
+	fmt.Fprintf(w, "Cache entries:\t%d\n", as.CacheEntries)
+	if len(as.SubAgents) != 0 {
+		fmt.Fprintf(w, "Sub-agents:\t%s\n", strings.Join(as.SubAgents, ", "))
+	}
+
+	fmt.Fprintf(w, "\nReducers (%d):\n", len(as.Reducers))
+	for _, s := range as.Reducers {
+		fmt.Fprintf(w, "  %s\n", s)
+	}
+	if len(as.DisabledReducers) != 0 {
+		fmt.Fprintf(w, "\nDisabled reducers (%d):\n", len(as.DisabledReducers))
+		for _, s := range as.DisabledReducers {
+			fmt.Fprintf(w, "  %s\n", s)
+		}
+	}
+
+	fmt.Fprintf(w, "\nTasks (%d):\n", len(as.Tasks))
+	for _, t := range as.Tasks {
+		fmt.Fprintf(w, "  %s\t%s\t%s\n", t.ID, time.Since(t.Start).Round(time.Millisecond), t.Title)
+	}
+
+	fmt.Fprintf(w, "\nRecent errors (%d):\n", len(as.Errors))
+	for _, e := range as.Errors {
+		fmt.Fprintf(w, "  %s\t%s\n", e.Time.Format("15:04:05"), e.Message)
+	}
+}
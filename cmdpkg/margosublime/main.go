@@ -4,13 +4,24 @@ import (
 	"fmt"
 	"github.com/urfave/cli"
 	"margo.sh/mg"
+	"margo.sh/mg/lsp"
 	"margo.sh/mgcli"
 	"margo.sh/sublime"
+	"os"
+	"time"
 )
 
 var (
 	margoExt    mg.MargoFunc = sublime.Margo
 	agentConfig              = mg.AgentConfig{AgentName: sublime.AgentName}
+	lspMode     bool
+	replayFile  string
+	schemaMode  bool
+	subAgents   bool
+	pluginPaths cli.StringSlice
+	pluginSrcs  cli.StringSlice
+	hotReload   time.Duration
+	historySize int
 )
 
 func Main() {
@@ -22,21 +33,190 @@ func Main() {
 			Destination: &agentConfig.Codec,
 			Usage:       fmt.Sprintf("The IPC codec: %s (default %s)", mg.CodecNamesStr, mg.DefaultCodec),
 		},
+		cli.StringFlag{
+			Name:        "listen",
+			Value:       agentConfig.Listen,
+			Destination: &agentConfig.Listen,
+			Usage:       "Listen for a client connection on `network:address` e.g. tcp:127.0.0.1:0, tls:127.0.0.1:0 (tcp over TLS), ws:127.0.0.1:0 (WebSocket), wss:127.0.0.1:0, unix:/path/to/sock or npipe:\\\\.\\pipe\\margo (Windows) instead of using stdin/stdout",
+		},
+		cli.StringFlag{
+			Name:        "token",
+			EnvVar:      "MARGO_IPC_TOKEN",
+			Value:       agentConfig.Token,
+			Destination: &agentConfig.Token,
+			Usage:       "The token clients must send when connecting to the -listen address (default: random)",
+		},
+		cli.StringFlag{
+			Name:        "compression",
+			Value:       agentConfig.Compression,
+			Destination: &agentConfig.Compression,
+			Usage:       "The IPC compression to use if requested by the client: gzip, zstd or none (default none)",
+		},
+		cli.IntFlag{
+			Name:        "workers",
+			Value:       agentConfig.Workers,
+			Destination: &agentConfig.Workers,
+			Usage:       "The number of requests whose actions are decoded, and responses encoded, concurrently; reductions are serialized (default 1)",
+		},
+		cli.IntFlag{
+			Name:        "queue-depth",
+			Value:       agentConfig.QueueDepth,
+			Destination: &agentConfig.QueueDepth,
+			Usage:       "The maximum number of requests waiting to be handled before stale ones are dropped (default 0 i.e. unlimited)",
+		},
+		cli.IntFlag{
+			Name:        "max-request-size",
+			Value:       agentConfig.MaxRequestSize,
+			Destination: &agentConfig.MaxRequestSize,
+			Usage:       "The maximum size, in bytes, of a request. Larger requests are rejected with an error (default 0 i.e. unlimited)",
+		},
+		cli.IntFlag{
+			Name:        "max-action-data-size",
+			Value:       agentConfig.MaxActionDataSize,
+			Destination: &agentConfig.MaxActionDataSize,
+			Usage:       "The maximum size, in bytes, of the data of each action in a request (default 0 i.e. unlimited)",
+		},
+		cli.DurationFlag{
+			Name:        "heartbeat",
+			Value:       agentConfig.HeartbeatTimeout,
+			Destination: &agentConfig.HeartbeatTimeout,
+			Usage:       "Disconnect clients that send no requests for this long, pinging them at half that time (default 0 i.e. disabled)",
+		},
+		cli.DurationFlag{
+			Name:        "idle",
+			Value:       agentConfig.IdleTimeout,
+			Destination: &agentConfig.IdleTimeout,
+			Usage:       "Dispatch an Idle action, letting reducers release resources, when no requests are received for this long (default 0 i.e. disabled)",
+		},
+		cli.DurationFlag{
+			Name:        "restart-on-rebuild",
+			Value:       agentConfig.RestartOnRebuild,
+			Destination: &agentConfig.RestartOnRebuild,
+			Usage:       "Check the agent executable for changes at this interval, restarting the agent when it's rebuilt (default 0 i.e. disabled)",
+		},
+		cli.DurationFlag{
+			Name:        "write-timeout",
+			Value:       agentConfig.WriteTimeout,
+			Destination: &agentConfig.WriteTimeout,
+			Usage:       "Disconnect clients, or shut down if using stdin/stdout, when a response can't be written for this long because the client stopped reading (default 0 i.e. disabled)",
+		},
+		cli.DurationFlag{
+			Name:        "reconnect",
+			Value:       agentConfig.ReconnectTimeout,
+			Destination: &agentConfig.ReconnectTimeout,
+			Usage:       "With -listen, keep running for this long after the last client disconnects, waiting for a client to reconnect (default 0 i.e. exit immediately)",
+		},
+		cli.StringFlag{
+			Name:        "state-dir",
+			Value:       agentConfig.StateDir,
+			Destination: &agentConfig.StateDir,
+			Usage:       "Save parts of the state of each project e.g. issues, in this `dir` when the agent shuts down, and restore them when it restarts",
+		},
+		cli.IntFlag{
+			Name:        "history",
+			Destination: &historySize,
+			Usage:       "Keep this many dispatched actions, and the resulting states, for QueryHistory and RestoreSnapshot (default 0 i.e. disabled)",
+		},
+		cli.StringFlag{
+			Name:        "debug-addr",
+			Value:       agentConfig.DebugAddr,
+			Destination: &agentConfig.DebugAddr,
+			Usage:       "Serve pprof profiles, expvar stats and the current state over HTTP on this localhost `address` e.g. 127.0.0.1:6060",
+		},
+		cli.StringFlag{
+			Name:        "protocol",
+			Value:       agentConfig.Protocol,
+			Destination: &agentConfig.Protocol,
+			Usage:       "The IPC protocol to use: margo or jsonrpc (Content-Length framed JSON-RPC 2.0, requires -codec json) (default margo)",
+		},
+		cli.StringFlag{
+			Name:        "record",
+			Value:       agentConfig.RecordTo,
+			Destination: &agentConfig.RecordTo,
+			Usage:       "Record all requests and responses to this file, for use with `margo.sh replay`",
+		},
+		cli.StringFlag{
+			Name:        "replay",
+			Destination: &replayFile,
+			Usage:       "Feed the requests in this session recording to the agent, writing its responses to stdout",
+		},
+		cli.BoolFlag{
+			Name:        "schema",
+			Destination: &schemaMode,
+			Usage:       "Write a JSON Schema describing the IPC protocol for the -codec to stdout, and exit",
+		},
+		cli.StringSliceFlag{
+			Name:  "plugin",
+			Value: &pluginPaths,
+			Usage: "Load reducers from the Go plugin at this `path`, built using go build -buildmode=plugin. May be repeated",
+		},
+		cli.StringSliceFlag{
+			Name:  "plugin-src",
+			Value: &pluginSrcs,
+			Usage: "Build and load the Go plugin whose main package is in this `dir`. May be repeated",
+		},
+		cli.DurationFlag{
+			Name:        "hot-reload",
+			Destination: &hotReload,
+			Usage:       "Check the sources of -plugin-src plugins for changes at this interval, rebuilding them and swapping in their new reducers (default 0 i.e. disabled)",
+		},
+		cli.BoolFlag{
+			Name:        "sub-agents",
+			Destination: &subAgents,
+			Usage:       "Allow requests to name a sub-agent, with its own state and caches, to handle them e.g. one per workspace folder",
+		},
+		cli.BoolFlag{
+			Name:        "lsp",
+			Destination: &lspMode,
+			Usage:       "Speak the Language Server Protocol on stdin/stdout instead of the margo protocol",
+		},
 	}
 	app.Action = func(ctx *cli.Context) error {
 		if ctx.Args().Present() {
 			return cli.ShowAppHelp(ctx)
 		}
 
+		if subAgents {
+			agentConfig.SetupSubAgent = setupAgent
+		}
+
+		if lspMode {
+			if err := lsp.Serve(os.Stdin, os.Stdout, agentConfig, setupAgent); err != nil {
+				return mgcli.Error("lsp server failed:", err)
+			}
+			return nil
+		}
+
+		if schemaMode {
+			ag, err := mg.NewAgent(agentConfig)
+			if err != nil {
+				return mgcli.Error("schema failed:", err)
+			}
+			// actions registered by extensions are part of the protocol
+			setupAgent(ag)
+			if err := mg.WriteProtocolSchema(os.Stdout, agentConfig.Codec); err != nil {
+				return mgcli.Error("schema failed:", err)
+			}
+			return nil
+		}
+
+		if replayFile != "" {
+			f, err := os.Open(replayFile)
+			if err != nil {
+				return mgcli.Error("replay failed:", err)
+			}
+			defer f.Close()
+			if err := mg.Replay(f, os.Stdout, agentConfig, setupAgent); err != nil {
+				return mgcli.Error("replay failed:", err)
+			}
+			return nil
+		}
+
 		ag, err := mg.NewAgent(agentConfig)
 		if err != nil {
 			return mgcli.Error("agent creation failed:", err)
 		}
-		mg.SetMemoryLimit(ag.Log, mg.DefaultMemoryLimit)
-		ag.Store.SetBaseConfig(sublime.DefaultConfig)
-		if margoExt != nil {
-			margoExt(ag.Args())
-		}
+		setupAgent(ag)
 
 		if err := ag.Run(); err != nil {
 			return mgcli.Error("agent failed:", err)
@@ -45,3 +225,22 @@ func Main() {
 	}
 	app.RunAndExitOnError()
 }
+
+func setupAgent(ag *mg.Agent) {
+	mg.SetMemoryLimit(ag.Log, mg.DefaultMemoryLimit)
+	ag.Store.SetBaseConfig(sublime.DefaultConfig)
+	ag.Store.SetHistorySize(historySize)
+	if margoExt != nil {
+		margoExt(ag.Args())
+	}
+	for _, fn := range pluginPaths {
+		if err := ag.LoadPlugin(fn); err != nil {
+			ag.Log.Println(err)
+		}
+	}
+	for _, dir := range pluginSrcs {
+		if _, err := ag.HotReload(dir, hotReload); err != nil {
+			ag.Log.Println(err)
+		}
+	}
+}
@@ -77,6 +77,15 @@ func Margo(m mg.Args) {
 		// golang.GoFmt,
 		// or
 		// golang.GoImports,
+		// or, to group your organisation's imports separately
+		// &golang.GoImporter{Local: []string{"example.com/corp"}},
+		// or, to use gofmt -s, gofumpt or another command, possibly per-project
+		// &golang.GoFmter{
+		// 	Backend: golang.GofmtSimplifyBackend,
+		// 	Projects: map[string]golang.GoFmtBackend{
+		// 		"/path/to/project": golang.GofumptBackend,
+		// 	},
+		// },
 
 		// Configure general auto-completion behaviour
 		&golang.MarGocodeCtl{
@@ -120,6 +129,10 @@ func Margo(m mg.Args) {
 		// gs: by default `goto.definition` is bound to ctrl+.,ctrl+g or cmd+.,cmd+g
 		&golang.Guru{},
 
+		// alternatively, use gopls for completion, tooltips, goto-definition and diagnostics
+		// it replaces golang.Gocode, golang.Guru and golang.TypeCheck, so remove them if it's enabled
+		// &golang.Gopls{},
+
 		// add some default context aware-ish snippets
 		// gs: this replaces the `autocomplete_snippets` and `default_snippets` settings
 		golang.Snippets,
@@ -144,9 +157,27 @@ func Margo(m mg.Args) {
 			BenchArgs: []string{"-benchmem"},
 		},
 
+		// TestRunner adds gutter markers and `go.test.func` commands to run individual
+		// test, benchmark and example functions, reporting failures as issues
+		// &golang.TestRunner{
+		// 	BenchArgs: []string{"-benchmem"},
+		// },
+
+		// TestCoverage adds the command `go.test.cover`, and shows the coverage reported by
+		// `go test -coverprofile` in the open views, and its percentage in the status bar
+		// &golang.TestCoverage{},
+
 		// GoGenerate adds a UserCmd that calls `go generate` in go packages and sub-dirs
 		&golang.GoGenerate{Args: []string{"-v", "-x"}},
 
+		// GoModCmds adds the commands `mod.require`, `mod.drop`, `mod.replace` and `mod.tidy`
+		// that edit the current module's go.mod file, and report resolution errors as issues
+		// &golang.GoModCmds{},
+
+		// GoModDiagnostics checks go.mod and go.sum when they're opened or changed,
+		// reporting unknown modules, missing sums, retracted or deprecated versions and available upgrades
+		// &golang.GoModDiagnostics{},
+
 		// run `go install -i` on save
 		// golang.GoInstall("-i"),
 		// or
@@ -164,6 +195,12 @@ func Margo(m mg.Args) {
 		// run `go test -race` on save
 		// golang.GoTest("-race"),
 
+		// run staticcheck as you type, including unsaved changes
+		// &golang.StaticCheck{},
+
+		// run golangci-lint on save, using the project's .golangci.yml
+		// &golang.GolangCILint{},
+
 		// run `golint` on save
 		// &golang.Linter{Name: "golint", Label: "Go/Lint"},
 
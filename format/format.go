@@ -42,7 +42,24 @@ func (ff FmtFunc) Reduce(mx *mg.Ctx) *mg.State {
 		return mx.State
 	}
 
-	src, err = ff.Fmt(mx, src)
+	// fmt in the background so that we can give up, leaving the view unchanged,
+	// if the Ctx is cancelled e.g. because the action's deadline passed
+	type fmtResult struct {
+		src []byte
+		err error
+	}
+	resC := make(chan fmtResult, 1)
+	go func() {
+		src, err := ff.Fmt(mx, src)
+		resC <- fmtResult{src, err}
+	}()
+	select {
+	case res := <-resC:
+		src, err = res.src, res.err
+	case <-mx.Done():
+		mx.Log.Printf("fmt %s abandoned: %s\n", fn, mx.Err())
+		return mx.State
+	}
 	if err != nil {
 		return mx.AddErrorf("failed to fmt %s: %s\n", fn, err)
 	}
@@ -63,6 +80,10 @@ type FmtCmd struct {
 	// Env is a map of additional env vars to pass to the command.
 	Env mg.EnvMap
 
+	// Dir is the directory in which the command is run.
+	// If it's empty, the command is run in the agent's working directory.
+	Dir string
+
 	// Langs is the list of languages in which the reducer should run
 	Langs []mg.Lang
 
@@ -73,14 +94,16 @@ type FmtCmd struct {
 
 // Reduce implements the FmtCmd reducer.
 func (fc FmtCmd) Reduce(mx *mg.Ctx) *mg.State {
-	return FmtFunc{Fmt: fc.fmt, Langs: fc.Langs, Actions: fc.Actions}.Reduce(mx)
+	return FmtFunc{Fmt: fc.Fmt, Langs: fc.Langs, Actions: fc.Actions}.Reduce(mx)
 }
 
-func (fc FmtCmd) fmt(mx *mg.Ctx, src []byte) ([]byte, error) {
+// Fmt returns src formatted by the command. It's the FmtFunc.Fmt function of the reducer
+func (fc FmtCmd) Fmt(mx *mg.Ctx, src []byte) ([]byte, error) {
 	stdin := bytes.NewReader(src)
 	stdout := bytes.NewBuffer(nil)
 	stderr := bytes.NewBuffer(nil)
-	cmd := exec.Command(fc.Name, fc.Args...)
+	cmd := exec.CommandContext(mx, fc.Name, fc.Args...)
+	cmd.Dir = fc.Dir
 	cmd.Env = mx.Env.Merge(fc.Env).Environ()
 	cmd.Stdin = stdin
 	cmd.Stdout = stdout
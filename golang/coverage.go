@@ -0,0 +1,428 @@
+package golang
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"margo.sh/mg"
+	"margo.sh/mgutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// coverProfileLinePat matches the blocks of a cover profile e.g. `example.com/m/x.go:12.34,15.2 3 1`
+	coverProfileLinePat = regexp.MustCompile(`^(.+):(\d+)\.(\d+),(\d+)\.(\d+) (\d+) (\d+)$`)
+)
+
+// CoverProfileWritten is the event published, using mg.Store.Publish,
+// when a cover profile was written by `go test -coverprofile` e.g. when it's run through the `go` command.
+//
+// TestCoverage listens for it, to show the coverage in the open views.
+type CoverProfileWritten struct {
+	// Dir is the directory in which `go test` was run
+	Dir string
+
+	// Filename is the absolute name of the profile
+	Filename string
+}
+
+// TestCoverage shows the test coverage of Go files, as reported by `go test -coverprofile`.
+//
+// It adds the command `go.test.cover`, and the UserCmd `Go Test Coverage`, which run the tests of the current package,
+// then load the cover profile they wrote.
+// Profiles written when `go test -coverprofile=...` is run through the `go` command are loaded as well.
+//
+// When a profile is loaded, the CoverageOverlay client action is sent for each open view that it covers,
+// and the percentage of statements covered in the current view's package is shown in the status bar.
+// When a view is modified, its overlay is cleared, because its rows are no longer accurate.
+type TestCoverage struct {
+	mg.ReducerType
+
+	// Args is a list of extra arguments to pass to `go test` e.g. `-covermode=count`
+	Args []string
+
+	mu       sync.Mutex
+	files    map[string]*coverFile
+	views    map[string]*mg.View
+	unlisten func()
+}
+
+// coverBlock is a block of statements, as listed in a cover profile
+type coverBlock struct {
+	StartLine, StartCol int
+	EndLine, EndCol     int
+	NumStmt, Count      int
+}
+
+// coverFile is the coverage of a file
+type coverFile struct {
+	// Covered and Uncovered are the ranges of rows whose statements were, or weren't, run
+	Covered, Uncovered []mg.CoverageRange
+	// Stmts is the number of statements in the file, and Run the number of them that were run
+	Stmts, Run int
+	// Stale is true if the file was modified after the profile was loaded
+	Stale bool
+}
+
+func (tc *TestCoverage) RCond(mx *mg.Ctx) bool {
+	return mx.LangIs(mg.Go)
+}
+
+func (tc *TestCoverage) RMount(mx *mg.Ctx) {
+	tc.files = map[string]*coverFile{}
+	tc.views = map[string]*mg.View{}
+	jobs := mx.Jobs
+	tc.unlisten = mg.Listen(mx.Store, func(ev CoverProfileWritten) {
+		jobs.Submit("Loading cover profile", func(jx *mg.JobCtx) []mg.Action {
+			if err := tc.load(jx.Ctx, ev.Dir, ev.Filename); err != nil {
+				jx.Log.Println("TestCoverage:", err)
+				return nil
+			}
+			return []mg.Action{mg.Render}
+		})
+	})
+}
+
+func (tc *TestCoverage) RUnmount(mx *mg.Ctx) {
+	tc.unlisten()
+}
+
+func (tc *TestCoverage) RViewClosed(mx *mg.Ctx) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	delete(tc.views, mx.View.Filename())
+}
+
+func (tc *TestCoverage) Reduce(mx *mg.Ctx) *mg.State {
+	switch act := mx.Action.(type) {
+	case mg.ViewActivated:
+		tc.activated(mx)
+	case mg.ViewModified:
+		tc.modified(mx)
+	case mg.QueryUserCmds:
+		return mx.AddUserCmds(mg.UserCmd{
+			Title: "Go Test Coverage",
+			Name:  "go.test.cover",
+		})
+	case mg.RunCmd:
+		if act.Name == "go.test.cover" {
+			return mx.AddBuiltinCmds(mg.BuiltinCmd{
+				Name: act.Name,
+				Desc: "go.test.cover [ARGS...] runs `go test -coverprofile` in the current package, and shows the coverage in the open views",
+				Run:  tc.runCmd,
+			})
+		}
+	}
+
+	if pct, ok := tc.percent(mx.View.Dir()); ok {
+		return mx.AddStatusf("Cover %.1f%%", pct)
+	}
+	return mx.State
+}
+
+// activated records the view, and sends its overlay, if its file is covered
+func (tc *TestCoverage) activated(mx *mg.Ctx) {
+	v := mx.View
+	fn := v.Filename()
+	tc.mu.Lock()
+	tc.views[fn] = v
+	cf := tc.files[fn]
+	show := cf != nil && !cf.Stale
+	tc.mu.Unlock()
+
+	if show {
+		mx.Store.Notify(cf.overlay(v))
+	}
+}
+
+// modified clears the overlay of the view, if its file is covered
+func (tc *TestCoverage) modified(mx *mg.Ctx) {
+	v := mx.View
+	fn := v.Filename()
+	tc.mu.Lock()
+	tc.views[fn] = v
+	cf := tc.files[fn]
+	stale := cf != nil && cf.Stale
+	if cf != nil {
+		cf.Stale = true
+	}
+	tc.mu.Unlock()
+
+	if cf != nil && !stale {
+		mx.Store.Notify(mg.CoverageOverlay{Path: v.Path, Name: v.Name})
+	}
+}
+
+// percent returns the percentage of statements run in the package in dir
+func (tc *TestCoverage) percent(dir string) (float64, bool) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	cf := coverFile{}
+	for fn, f := range tc.files {
+		if filepath.Dir(fn) == dir {
+			cf.Stmts += f.Stmts
+			cf.Run += f.Run
+		}
+	}
+	return cf.percent(), cf.Stmts != 0
+}
+
+func (tc *TestCoverage) runCmd(cx *mg.CmdCtx) *mg.State {
+	dir := cx.View.Dir()
+	cx.Jobs.Submit("go.test.cover", func(jx *mg.JobCtx) []mg.Action {
+		defer cx.Output.Close()
+
+		if err := tc.run(jx, cx, dir); err != nil {
+			fmt.Fprintln(cx.Output, "go.test.cover:", err)
+			return nil
+		}
+		return []mg.Action{mg.Render}
+	})
+	return cx.State
+}
+
+// run runs `go test -coverprofile` in dir, streaming its output to cx.Output, then loads the profile
+func (tc *TestCoverage) run(jx *mg.JobCtx, cx *mg.CmdCtx, dir string) error {
+	tDir, err := mg.MkTempDir("go.test.cover")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tDir)
+
+	fn := filepath.Join(tDir, "cover.out")
+	args := append([]string{"test", "-coverprofile=" + fn}, tc.Args...)
+	args = append(args, cx.Args...)
+	args = append(args, ".")
+	if cx.Verbose {
+		fmt.Fprintln(cx.Output, "#", mgutil.QuoteCmd("go", args...))
+	}
+	cmd := exec.CommandContext(jx, "go", args...)
+	cmd.Dir = dir
+	cmd.Env = cx.Env.Environ()
+	cmd.Stdout = cx.Output
+	cmd.Stderr = cx.Output
+	// the profile is written even if tests fail, so only give up if there's none
+	if err := cmd.Run(); err != nil {
+		if _, e := os.Stat(fn); e != nil {
+			return err
+		}
+	}
+	return tc.load(jx.Ctx, dir, fn)
+}
+
+// load loads the cover profile fn, written by `go test` in dir, and sends the overlays of the open views that it covers
+func (tc *TestCoverage) load(mx *mg.Ctx, dir, fn string) error {
+	src, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return err
+	}
+	prof, err := parseCoverProfile(src)
+	if err != nil {
+		return fmt.Errorf("%s: %s", fn, err)
+	}
+	files, err := coverFiles(mx, dir, prof)
+	if err != nil {
+		return err
+	}
+
+	var acts []mg.CoverageOverlay
+	tc.mu.Lock()
+	for nm, cf := range files {
+		tc.files[nm] = cf
+		if v := tc.views[nm]; v != nil {
+			acts = append(acts, cf.overlay(v))
+		}
+	}
+	tc.mu.Unlock()
+
+	for _, act := range acts {
+		mx.Store.Notify(act)
+	}
+	return nil
+}
+
+// coverFiles returns the coverage of each file in profile prof, written by `go test` in dir, by absolute file name
+func coverFiles(mx *mg.Ctx, dir string, prof map[string][]coverBlock) (map[string]*coverFile, error) {
+	pkgDirs := map[string]string{}
+	var pkgs []string
+	for nm := range prof {
+		pkg := path.Dir(nm)
+		switch {
+		case filepath.IsAbs(nm):
+		case strings.HasPrefix(pkg, "_/"):
+			// the import path of a package outside GOPATH is its directory, prefixed with `_`
+			pkgDirs[pkg] = filepath.FromSlash(pkg[1:])
+		default:
+			if _, ok := pkgDirs[pkg]; !ok {
+				pkgDirs[pkg] = ""
+				pkgs = append(pkgs, pkg)
+			}
+		}
+	}
+
+	if len(pkgs) != 0 {
+		args := append([]string{"list", "-e", "-f", "{{.ImportPath}}\t{{.Dir}}"}, pkgs...)
+		stderr := &bytes.Buffer{}
+		cmd := exec.CommandContext(mx, "go", args...)
+		cmd.Dir = dir
+		cmd.Env = mx.Env.Environ()
+		cmd.Stderr = stderr
+		out, err := cmd.Output()
+		if err != nil {
+			if msg := bytes.TrimSpace(stderr.Bytes()); len(msg) != 0 {
+				return nil, fmt.Errorf("%s", msg)
+			}
+			return nil, fmt.Errorf("`%s` failed: %s", mgutil.QuoteCmd("go", args...), err)
+		}
+		sc := bufio.NewScanner(bytes.NewReader(out))
+		for sc.Scan() {
+			if l := strings.SplitN(sc.Text(), "\t", 2); len(l) == 2 && l[1] != "" {
+				pkgDirs[l[0]] = l[1]
+			}
+		}
+	}
+
+	files := map[string]*coverFile{}
+	for nm, blocks := range prof {
+		fn := nm
+		if !filepath.IsAbs(fn) {
+			pkgDir := pkgDirs[path.Dir(nm)]
+			if pkgDir == "" {
+				continue
+			}
+			fn = filepath.Join(pkgDir, path.Base(nm))
+		}
+		files[fn] = newCoverFile(blocks)
+	}
+	return files, nil
+}
+
+// parseCoverProfile parses the cover profile src, returning the blocks of each file, by the name listed in the profile.
+// Blocks listed more than once e.g. when the profile of several packages were merged, are merged as well.
+func parseCoverProfile(src []byte) (map[string][]coverBlock, error) {
+	type blockKey struct {
+		nm  string
+		pos [4]int
+	}
+	prof := map[string][]coverBlock{}
+	index := map[blockKey]int{}
+	for i, ln := range strings.Split(string(src), "\n") {
+		ln = strings.TrimSpace(ln)
+		if ln == "" || strings.HasPrefix(ln, "mode:") {
+			continue
+		}
+		m := coverProfileLinePat.FindStringSubmatch(ln)
+		if m == nil {
+			return nil, fmt.Errorf("line %d: cannot parse `%s`", i+1, ln)
+		}
+		n := make([]int, 6)
+		for j := range n {
+			n[j], _ = strconv.Atoi(m[j+2])
+		}
+		b := coverBlock{
+			StartLine: n[0], StartCol: n[1],
+			EndLine: n[2], EndCol: n[3],
+			NumStmt: n[4], Count: n[5],
+		}
+
+		k := blockKey{nm: m[1], pos: [4]int{b.StartLine, b.StartCol, b.EndLine, b.EndCol}}
+		if j, ok := index[k]; ok {
+			prof[k.nm][j].Count += b.Count
+			continue
+		}
+		index[k] = len(prof[k.nm])
+		prof[k.nm] = append(prof[k.nm], b)
+	}
+	return prof, nil
+}
+
+// newCoverFile returns the coverage of a file whose blocks are blocks.
+//
+// A row is covered if any of the blocks on it were run, so the row of e.g. `if x {` is covered
+// if the `if` statement was run, even if its body wasn't.
+func newCoverFile(blocks []coverBlock) *coverFile {
+	cf := &coverFile{}
+	rows := map[int]bool{}
+	last := 0
+	for _, b := range blocks {
+		if b.NumStmt == 0 {
+			continue
+		}
+		cf.Stmts += b.NumStmt
+		if b.Count > 0 {
+			cf.Run += b.NumStmt
+		}
+		for row := b.StartLine - 1; row < b.EndLine; row++ {
+			rows[row] = rows[row] || b.Count > 0
+		}
+		if b.EndLine > last {
+			last = b.EndLine
+		}
+	}
+
+	for row := 0; row < last; row++ {
+		covered, ok := rows[row]
+		if !ok {
+			continue
+		}
+		l := &cf.Uncovered
+		if covered {
+			l = &cf.Covered
+		}
+		if n := len(*l); n != 0 && (*l)[n-1].EndRow == row-1 {
+			(*l)[n-1].EndRow = row
+		} else {
+			*l = append(*l, mg.CoverageRange{Row: row, EndRow: row})
+		}
+	}
+	return cf
+}
+
+// percent returns the percentage of statements that were run
+func (cf *coverFile) percent() float64 {
+	if cf.Stmts == 0 {
+		return 0
+	}
+	return 100 * float64(cf.Run) / float64(cf.Stmts)
+}
+
+// overlay returns the CoverageOverlay of view v
+func (cf *coverFile) overlay(v *mg.View) mg.CoverageOverlay {
+	return mg.CoverageOverlay{
+		Path:      v.Path,
+		Name:      v.Name,
+		Covered:   cf.Covered,
+		Uncovered: cf.Uncovered,
+		Percent:   cf.percent(),
+	}
+}
+
+// coverProfileArg returns the value of the -coverprofile flag in the arguments args of `go test`, if any
+func coverProfileArg(args []string) string {
+	for i, s := range args {
+		if s == "-args" || s == "--args" {
+			break
+		}
+		if !strings.HasPrefix(s, "-") {
+			continue
+		}
+		nm := strings.TrimPrefix(strings.TrimLeft(s, "-"), "test.")
+		switch {
+		case strings.HasPrefix(nm, "coverprofile="):
+			return strings.TrimPrefix(nm, "coverprofile=")
+		case nm == "coverprofile" && i+1 < len(args):
+			return args[i+1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,66 @@
+package golang
+
+import (
+	"margo.sh/mg"
+	"reflect"
+	"testing"
+)
+
+func TestParseCoverProfile(t *testing.T) {
+	src := `mode: set
+example.com/m/x.go:3.13,5.12 2 1
+example.com/m/x.go:5.12,7.3 1 0
+example.com/m/x.go:9.2,9.10 1 1
+example.com/m/x.go:10.2,11.3 0 0
+example.com/m/x.go:12.14,14.2 1 0
+mode: set
+example.com/m/x.go:5.12,7.3 1 1
+`
+	prof, err := parseCoverProfile([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := prof["example.com/m/x.go"]
+	if len(prof) != 1 || len(blocks) != 5 {
+		t.Fatalf("parseCoverProfile() = %+v, want 5 blocks of example.com/m/x.go", prof)
+	}
+	if b := blocks[1]; b.Count != 1 {
+		t.Errorf("block %+v listed twice has Count %d, want 1", b, b.Count)
+	}
+
+	cf := newCoverFile(blocks)
+	want := &coverFile{
+		Covered:   []mg.CoverageRange{{Row: 2, EndRow: 6}, {Row: 8, EndRow: 8}},
+		Uncovered: []mg.CoverageRange{{Row: 11, EndRow: 13}},
+		Stmts:     5,
+		Run:       4,
+	}
+	if !reflect.DeepEqual(cf, want) {
+		t.Errorf("newCoverFile() = %+v, want %+v", cf, want)
+	}
+	if pct := cf.percent(); pct != 80 {
+		t.Errorf("percent() = %v, want 80", pct)
+	}
+
+	if _, err := parseCoverProfile([]byte("mode: set\nx.go:1.1 1 1\n")); err == nil {
+		t.Errorf("parseCoverProfile(invalid): expected an error")
+	}
+}
+
+func TestCoverProfileArg(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-coverprofile=c.out", "."}, "c.out"},
+		{[]string{"-v", "--coverprofile", "/tmp/c.out"}, "/tmp/c.out"},
+		{[]string{"-test.coverprofile=c.out"}, "c.out"},
+		{[]string{"-cover", "./..."}, ""},
+		{[]string{".", "-args", "-coverprofile=c.out"}, ""},
+	}
+	for _, tc := range tests {
+		if got := coverProfileArg(tc.args); got != tc.want {
+			t.Errorf("coverProfileArg(%q) = %q, want %q", tc.args, got, tc.want)
+		}
+	}
+}
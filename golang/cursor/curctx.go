@@ -84,7 +84,7 @@ func NewCurCtx(mx *mg.Ctx, src []byte, pos int) *CurCtx {
 }
 
 func cachedCx(mx *mg.Ctx, k interface{}) *CurCtx {
-	cx, _ := mx.Get(k).(*CurCtx)
+	cx, _ := mg.StoreValue[*CurCtx](mx, k)
 	if cx == nil {
 		return nil
 	}
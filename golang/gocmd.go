@@ -93,6 +93,16 @@ func (gc *GoCmd) goTool(bx *mg.CmdCtx) {
 	gx := newGoCmdCtx(gc, bx, "go.builtin", "", "", "", bx.View, len(bx.Args) > 0 && bx.Args[0] == "test")
 	defer gx.Output.Close()
 	gx.run(gx.View)
+
+	if len(bx.Args) > 0 && bx.Args[0] == "test" {
+		if fn := coverProfileArg(bx.Args[1:]); fn != "" {
+			dir := gx.Wd(gx.View)
+			if !filepath.IsAbs(fn) {
+				fn = filepath.Join(dir, fn)
+			}
+			gx.Store.Publish(CoverProfileWritten{Dir: dir, Filename: fn})
+		}
+	}
 }
 
 func (gc *GoCmd) playTool(bx *mg.CmdCtx, cancelID string) {
@@ -78,12 +78,23 @@ func (gr *gocodeReq) reduce() *mg.State {
 		st = gr.addUnimportedPkg(st, sugg.unimported)
 	}
 
+	partial, selector := "", false
+	if gr.g.FuzzyMatch {
+		partial, selector = gr.gx.fuzzyPartial()
+		if partial != "" && !selector {
+			sugg.candidates = append(sugg.candidates, gr.gx.qualifiedCandidates()...)
+		}
+	}
+
 	gr.mx.Profile.Push("gocodeReq.finalize").Pop()
 	for _, v := range sugg.candidates {
 		if c, ok := gr.g.completion(gr.mx, gr.gx, v); ok {
 			completions = append(completions, c)
 		}
 	}
+	if gr.g.FuzzyMatch {
+		completions = fuzzyFilter(partial, completions)
+	}
 
 	return st.AddCompletions(completions...)
 }
@@ -96,6 +107,12 @@ type Gocode struct {
 	ShowFuncParams           bool
 	ShowFuncResultNames      bool
 
+	// FuzzyMatch filters and sorts the completions by how well they match the partial identifier before the cursor,
+	// its characters appearing in order, but not necessarily next to each other, e.g. `hrw` matches `http.ResponseWriter`.
+	// The members of the imported packages are proposed, qualified by the package name.
+	// The positions of the matched characters are set in Completion.Matches so clients can highlight them.
+	FuzzyMatch bool
+
 	// The following fields are deprecated
 
 	// Consider using MarGocodeCtl.Debug instead, it has more useful output
@@ -175,6 +192,9 @@ func (g *Gocode) Reduce(mx *mg.Ctx) *mg.State {
 	case <-time.After(qTimeout):
 		mx.Log.Println("gocode didn't accept the request after", mgpf.D(time.Since(start)))
 		return st
+	case <-mx.Done():
+		mx.Log.Println("gocode request abandoned before it was accepted:", mx.Err())
+		return st
 	}
 
 	pTimeout := 150 * time.Millisecond
@@ -185,6 +205,9 @@ func (g *Gocode) Reduce(mx *mg.Ctx) *mg.State {
 	select {
 	case st := <-gr.res:
 		return st
+	case <-mx.Done():
+		mx.Log.Println("gocode request abandoned after", mgpf.Since(start), mx.Err())
+		return st
 	case <-time.After(pTimeout):
 		go func() {
 			<-gr.res
@@ -101,7 +101,7 @@ func (gc *GocodeCalltips) process(act gocodeCtAct) {
 	defer func() { recover() }()
 
 	if s := gc.processStatus(act); s != act.status {
-		act.mx.Store.Dispatch(gocodeCtAct{status: s})
+		act.mx.Store.DispatchPriority(gocodeCtAct{status: s}, mg.PriorityInteractive)
 	}
 }
 
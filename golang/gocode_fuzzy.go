@@ -0,0 +1,228 @@
+package golang
+
+import (
+	"go/types"
+	"kuroku.io/margocode/suggest"
+	"margo.sh/mg"
+	"margo.sh/mgutil"
+	"sort"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// fuzzyBoundaryBonus is added for each character matched at the start of a word e.g. the W in ResponseWriter
+	fuzzyBoundaryBonus = 8
+	// fuzzyAdjacentBonus is added for each character matched right after the previous one
+	fuzzyAdjacentBonus = 4
+	// fuzzyCaseBonus is added for each character matched with the same case
+	fuzzyCaseBonus = 1
+)
+
+// fuzzyMatch reports whether the characters of pattern appear, in order and ignoring case, in s
+// e.g. "hrw" matches "http.ResponseWriter".
+//
+// If they do, score rates the match, preferring characters matched at the start of words,
+// or next to each other, and matches holds the byte offsets in s of the matched characters.
+// If pattern is empty, it matches everything with a score of 0.
+func fuzzyMatch(pattern, s string) (score int, matches []int, ok bool) {
+	if pattern == "" {
+		return 0, nil, true
+	}
+
+	pat := []rune(pattern)
+	str := []rune(s)
+	if len(pat) > len(str) {
+		return 0, nil, false
+	}
+	offs := make([]int, len(str))
+	bonus := make([]int, len(str))
+	off := 0
+	for j, r := range str {
+		offs[j] = off
+		off += utf8.RuneLen(r)
+		if j == 0 || fuzzyBoundary(str[j-1], r) {
+			bonus[j] = fuzzyBoundaryBonus
+		}
+	}
+
+	// best[i][j] is the best score of the matches of pat[:i+1] with pat[i] matched at str[j], or none if there isn't one.
+	// prev[i][j] is the position of the match of pat[i-1] that led to it.
+	const none = -1 << 31
+	best := make([][]int, len(pat))
+	prev := make([][]int, len(pat))
+	for i, pr := range pat {
+		best[i] = make([]int, len(str))
+		prev[i] = make([]int, len(str))
+		for j, sr := range str {
+			best[i][j] = none
+			if unicode.ToLower(pr) != unicode.ToLower(sr) {
+				continue
+			}
+			gain := 1 + bonus[j]
+			if pr == sr {
+				gain += fuzzyCaseBonus
+			}
+			if i == 0 {
+				best[i][j] = gain
+				continue
+			}
+			for k := i - 1; k < j; k++ {
+				if best[i-1][k] == none {
+					continue
+				}
+				sc := best[i-1][k] + gain
+				if k == j-1 {
+					sc += fuzzyAdjacentBonus
+				} else {
+					sc -= j - k - 1
+				}
+				if sc > best[i][j] {
+					best[i][j] = sc
+					prev[i][j] = k
+				}
+			}
+		}
+	}
+
+	last := len(pat) - 1
+	end := -1
+	for j, sc := range best[last] {
+		if sc != none && (end < 0 || sc > best[last][end]) {
+			end = j
+		}
+	}
+	if end < 0 {
+		return 0, nil, false
+	}
+
+	matches = make([]int, len(pat))
+	for i, j := last, end; i >= 0; i-- {
+		matches[i] = offs[j]
+		j = prev[i][j]
+	}
+	return best[last][end], matches, true
+}
+
+// fuzzyBoundary reports whether r starts a word, given that it follows p
+func fuzzyBoundary(p, r rune) bool {
+	switch {
+	case !IsLetter(p):
+		return true
+	case p == '_' && r != '_':
+		return true
+	case unicode.IsLower(p) && unicode.IsUpper(r):
+		return true
+	case unicode.IsLetter(p) && unicode.IsDigit(r):
+		return true
+	}
+	return false
+}
+
+// fuzzyFilter returns the completions in l whose Query matches pattern, best matches first,
+// with their Completion.Matches set. Completions that match equally well keep their order.
+func fuzzyFilter(pattern string, l []mg.Completion) []mg.Completion {
+	if pattern == "" {
+		return l
+	}
+
+	type match struct {
+		c     mg.Completion
+		score int
+	}
+	ml := make([]match, 0, len(l))
+	for _, c := range l {
+		score, matches, ok := fuzzyMatch(pattern, c.Query)
+		if !ok {
+			continue
+		}
+		c.Matches = matches
+		ml = append(ml, match{c: c, score: score})
+	}
+	sort.SliceStable(ml, func(i, j int) bool { return ml[i].score > ml[j].score })
+
+	res := make([]mg.Completion, len(ml))
+	for i, m := range ml {
+		res[i] = m.c
+	}
+	return res
+}
+
+// fuzzyPartial returns the partial identifier before the cursor, and whether it follows a selector e.g. `x.`
+func (gx *gocodeCtx) fuzzyPartial() (partial string, selector bool) {
+	start := mgutil.RepositionLeft(gx.src, gx.pos, func(r rune) bool { return IsLetter(r) || unicode.IsDigit(r) })
+	return string(gx.src[start:gx.pos]), start > 0 && gx.src[start-1] == '.'
+}
+
+// qualifiedCandidates returns candidates for the exported members of the packages imported by the file,
+// qualified by the package name e.g. http.ResponseWriter, so they can be matched by fuzzyFilter
+func (gx *gocodeCtx) qualifiedCandidates() []suggest.Candidate {
+	if gx.AstFile == nil {
+		return nil
+	}
+
+	gsu := gx.gsu
+	gsu.Lock()
+	defer gsu.Unlock()
+
+	var l []suggest.Candidate
+	dir := gx.mx.View.Dir()
+	for _, spec := range gx.AstFile.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		pkg, _ := gsu.imp.ImportFrom(path, dir, 0)
+		if pkg == nil {
+			continue
+		}
+		name := pkg.Name()
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		if name == "_" || name == "." {
+			continue
+		}
+		qual := func(p *types.Package) string {
+			if p == pkg {
+				return name
+			}
+			return p.Name()
+		}
+		scope := pkg.Scope()
+		for _, id := range scope.Names() {
+			obj := scope.Lookup(id)
+			if !obj.Exported() {
+				continue
+			}
+			l = append(l, gx.qualifiedCandidate(name+"."+id, obj, qual))
+		}
+	}
+	return l
+}
+
+// qualifiedCandidate returns the candidate for obj, named name
+func (gx *gocodeCtx) qualifiedCandidate(name string, obj types.Object, qual types.Qualifier) suggest.Candidate {
+	c := suggest.Candidate{Name: name, PkgPath: obj.Pkg().Path()}
+	switch obj.(type) {
+	case *types.Const:
+		c.Class = "const"
+	case *types.Func:
+		c.Class = "func"
+	case *types.TypeName:
+		c.Class = "type"
+	default:
+		c.Class = "var"
+	}
+	if c.Class == "type" {
+		switch t := obj.Type().Underlying().(type) {
+		case *types.Struct:
+			c.Type = "struct"
+		case *types.Interface:
+			c.Type = "interface"
+		default:
+			c.Type = types.TypeString(t, qual)
+		}
+		return c
+	}
+	c.Type = types.TypeString(obj.Type(), qual)
+	return c
+}
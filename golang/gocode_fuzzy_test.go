@@ -0,0 +1,43 @@
+package golang
+
+import (
+	"margo.sh/mg"
+	"reflect"
+	"testing"
+)
+
+func TestFuzzyMatch(t *testing.T) {
+	cases := []struct {
+		pattern, s string
+		matches    []int
+		ok         bool
+	}{
+		{"hrw", "http.ResponseWriter", []int{0, 5, 13}, true},
+		{"rw", "ResponseWriter", []int{0, 8}, true},
+		{"Buf", "bytes.Buffer", []int{6, 7, 8}, true},
+		{"nwr", "NewReader", []int{0, 2, 3}, true},
+		{"πx", "aπbx", []int{1, 4}, true},
+		{"wrh", "http.ResponseWriter", nil, false},
+		{"", "x", nil, true},
+	}
+	for _, c := range cases {
+		_, matches, ok := fuzzyMatch(c.pattern, c.s)
+		if ok != c.ok || !reflect.DeepEqual(matches, c.matches) {
+			t.Errorf("fuzzyMatch(%q, %q) = (%v, %v), expected (%v, %v)", c.pattern, c.s, matches, ok, c.matches, c.ok)
+		}
+	}
+
+	l := fuzzyFilter("rw", []mg.Completion{
+		{Query: "ReadWriteCloser"},
+		{Query: "fmt.Errorf"},
+		{Query: "rawWrite"},
+		{Query: "ResponseWriter"},
+	})
+	var queries []string
+	for _, c := range l {
+		queries = append(queries, c.Query)
+	}
+	if want := []string{"rawWrite", "ReadWriteCloser", "ResponseWriter"}; !reflect.DeepEqual(queries, want) {
+		t.Errorf("fuzzyFilter returned %v, expected %v", queries, want)
+	}
+}
@@ -13,6 +13,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 var (
@@ -52,6 +54,27 @@ func (gsu *gcSuggest) noPartialsPos(src []byte, pos int) int {
 	return mgutil.RepositionLeft(src, pos, IsLetter)
 }
 
+// gsuSelectedName returns the identifier at pos, if it follows a selector e.g. `Mar` in `json.Mar|`
+func gsuSelectedName(src []byte, pos int) string {
+	if pos < 0 || pos > len(src) {
+		return ""
+	}
+	isIdent := func(r rune) bool { return IsLetter(r) || unicode.IsDigit(r) }
+	start := mgutil.RepositionLeft(src, pos, isIdent)
+	if start == 0 || src[start-1] != '.' {
+		return ""
+	}
+	end := pos
+	for end < len(src) {
+		r, n := utf8.DecodeRune(src[end:])
+		if !isIdent(r) {
+			break
+		}
+		end += n
+	}
+	return string(src[start:end])
+}
+
 func (gsu *gcSuggest) suggestions(mx *mg.Ctx, src []byte, pos int) suggestions {
 	defer mx.Profile.Push("suggestions").Pop()
 
@@ -80,8 +103,9 @@ func (gsu *gcSuggest) suggestions(mx *mg.Ctx, src []byte, pos int) suggestions {
 	}
 	if !gsu.cfg.NoUnimportedPackages {
 		srcDir := mx.View.Dir()
+		sym := gsuSelectedName(src, pos)
 		cfg.UnimportedPackage = func(nm string) *types.Package {
-			pkg, pth := gsu.imp.importFromName(nm, srcDir)
+			pkg, pth := gsu.imp.importFromName(nm, sym, srcDir)
 			if pkg != nil {
 				sugg.unimported.Name = nm
 				sugg.unimported.Path = pth
@@ -135,8 +159,10 @@ func (gi *gsuImporter) Import(path string) (*types.Package, error) {
 	return gi.ImportFrom(path, ".", 0)
 }
 
-func (gi *gsuImporter) importFromName(pkgName, srcDir string) (pkg *types.Package, impPath string) {
-	impPath = mctl.importPathByName(pkgName, srcDir)
+// importFromName imports a package named pkgName, preferring those that export a name starting with sym.
+// See marGocodeCtl.importPathByName
+func (gi *gsuImporter) importFromName(pkgName, sym, srcDir string) (pkg *types.Package, impPath string) {
+	impPath = mctl.importPathByName(gi.mx, pkgName, sym, srcDir)
 	if impPath == "" {
 		return nil, ""
 	}
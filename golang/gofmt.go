@@ -5,11 +5,24 @@ import (
 	mgformat "margo.sh/format"
 	"margo.sh/mg"
 	"margo.sh/sublime"
+	"path/filepath"
+	"strings"
 )
 
 var (
-	GoFmt     mg.Reducer = mg.NewReducer(goFmt)
-	GoImports mg.Reducer = mg.NewReducer(goImports)
+	// GoFmt is a GoFmter that formats all Go files using GofmtBackend
+	GoFmt mg.Reducer = &GoFmter{}
+	// GoImports is a GoImporter that formats Go files using goimports' default grouping
+	GoImports mg.Reducer = &GoImporter{}
+
+	// GofmtBackend formats the source in-process, like gofmt
+	GofmtBackend = GoFmtBackend{}
+
+	// GofmtSimplifyBackend formats the source using `gofmt -s`, which also simplifies the code
+	GofmtSimplifyBackend = GoFmtBackend{Name: "gofmt", Args: []string{"-s"}}
+
+	// GofumptBackend formats the source using gofumpt, a stricter gofmt. See https://github.com/mvdan/gofumpt
+	GofumptBackend = GoFmtBackend{Name: "gofumpt"}
 
 	commonFmtLangs   = []mg.Lang{mg.Go}
 	commonFmtActions = []mg.Action{
@@ -35,17 +48,97 @@ func (ff FmtFunc) Reduce(mx *mg.Ctx) *mg.State {
 	}.Reduce(mx))
 }
 
-func goFmt(mx *mg.Ctx) *mg.State {
-	return FmtFunc(func(_ *mg.Ctx, src []byte) ([]byte, error) {
+// GoFmtBackend is a formatter used by GoFmter.
+//
+// If Name is empty, the source is formatted in-process using go/format.
+// Otherwise, the command Name is run with Args in the view's directory using format.FmtCmd,
+// and the source is passed on its stdin.
+type GoFmtBackend struct {
+	Name string
+	Args []string
+}
+
+// Fmt returns src formatted by the backend
+func (fb GoFmtBackend) Fmt(mx *mg.Ctx, src []byte) ([]byte, error) {
+	if fb.Name == "" {
 		return format.Source(src)
-	}).Reduce(mx)
+	}
+	return mgformat.FmtCmd{Name: fb.Name, Args: fb.Args, Dir: mx.View.Dir()}.Fmt(mx, src)
 }
 
-func goImports(mx *mg.Ctx) *mg.State {
-	return disableGsFmt(mgformat.FmtCmd{
-		Name:    "goimports",
-		Args:    []string{"-srcdir", mx.View.Filename()},
-		Langs:   commonFmtLangs,
-		Actions: commonFmtActions,
-	}.Reduce(mx))
+// GoFmter is a reducer that formats Go files, when they're saved or fmt'ed,
+// using the backend selected for the view's project e.g.
+//
+//	&golang.GoFmter{
+//		Backend: golang.GofmtSimplifyBackend,
+//		Projects: map[string]golang.GoFmtBackend{
+//			"/src/team-x": golang.GofumptBackend,
+//		},
+//	}
+type GoFmter struct {
+	mg.ReducerType
+
+	// Backend is the backend used for files that aren't in any of the Projects.
+	// If it's empty, GofmtBackend is used.
+	Backend GoFmtBackend
+
+	// Projects maps directories to the backend used for the files in them, and their sub-directories.
+	// If several directories contain the view, the longest one is used.
+	Projects map[string]GoFmtBackend
+}
+
+// backend returns the backend used for files in directory dir
+func (gf *GoFmter) backend(dir string) GoFmtBackend {
+	fb, match := gf.Backend, ""
+	for pd, b := range gf.Projects {
+		pd = filepath.Clean(pd)
+		if len(pd) <= len(match) {
+			continue
+		}
+		if dir == pd || strings.HasPrefix(dir, pd+string(filepath.Separator)) {
+			fb, match = b, pd
+		}
+	}
+	return fb
+}
+
+func (gf *GoFmter) Reduce(mx *mg.Ctx) *mg.State {
+	return FmtFunc(gf.backend(mx.View.Dir()).Fmt).Reduce(mx)
+}
+
+// GoImporter is a reducer that formats Go files using goimports, when they're saved or fmt'ed e.g.
+//
+//	&golang.GoImporter{
+//		Local:  []string{"example.com/corp"},
+//		Groups: []string{"std", "default", "example.com/corp", "example.com/corp/team"},
+//	}
+type GoImporter struct {
+	mg.ReducerType
+
+	// Local is the list of import path prefixes, passed to goimports' -local flag,
+	// whose imports are put in a group after third-party imports
+	Local []string
+
+	// Groups, if set, orders the import groups after goimports has run.
+	//
+	// Each entry is either "std", the standard library, "default", the imports not matched by other entries,
+	// or an import path prefix. Imports are put in the group of the longest matching prefix.
+	// If "default" isn't listed, those imports are put last.
+	Groups []string
+}
+
+func (gi *GoImporter) Reduce(mx *mg.Ctx) *mg.State {
+	return FmtFunc(gi.fmt).Reduce(mx)
+}
+
+func (gi *GoImporter) fmt(mx *mg.Ctx, src []byte) ([]byte, error) {
+	args := []string{"-srcdir", mx.View.Filename()}
+	if len(gi.Local) != 0 {
+		args = append(args, "-local", strings.Join(gi.Local, ","))
+	}
+	src, err := GoFmtBackend{Name: "goimports", Args: args}.Fmt(mx, src)
+	if err != nil || len(gi.Groups) == 0 {
+		return src, err
+	}
+	return regroupImports(mx.View.Filename(), src, gi.Groups)
 }
@@ -0,0 +1,70 @@
+package golang
+
+import (
+	"margo.sh/mg"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestGoFmterBackend(t *testing.T) {
+	gf := &GoFmter{
+		Backend: GofmtSimplifyBackend,
+		Projects: map[string]GoFmtBackend{
+			"/src/team-x":         GofumptBackend,
+			"/src/team-x/legacy/": GofmtBackend,
+		},
+	}
+	cases := []struct {
+		dir  string
+		want GoFmtBackend
+	}{
+		{dir: "/src/other", want: GofmtSimplifyBackend},
+		{dir: "/src/team-x", want: GofumptBackend},
+		{dir: "/src/team-x/pkg", want: GofumptBackend},
+		{dir: "/src/team-xy", want: GofmtSimplifyBackend},
+		{dir: "/src/team-x/legacy", want: GofmtBackend},
+		{dir: "/src/team-x/legacy/pkg", want: GofmtBackend},
+	}
+	for _, c := range cases {
+		if got := gf.backend(c.dir); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("backend(%q) = (%+v); want (%+v)", c.dir, got, c.want)
+		}
+	}
+
+	if got := (&GoFmter{}).backend("/src"); !reflect.DeepEqual(got, GofmtBackend) {
+		t.Errorf("backend() without a Backend = (%+v); want GofmtBackend", got)
+	}
+}
+
+func TestGoFmterFmt(t *testing.T) {
+	src := "package p\nfunc f( ) {}\n"
+	fn := filepath.Join(t.TempDir(), "p.go")
+
+	h := mg.NewHarness(t, &GoFmter{}).Open(fn, src, 0)
+	st := h.Dispatch(mg.ViewFmt{})
+	if want := "package p\n\nfunc f() {}\n"; string(st.View.Src) != want || len(st.Errors) != 0 {
+		t.Errorf("GofmtBackend: src = (%q), errors = (%q); want (%q) and no errors", st.View.Src, st.Errors, want)
+	}
+
+	if runtime.GOOS != "windows" {
+		pwd := GoFmtBackend{Name: "sh", Args: []string{"-c", "cat >/dev/null; pwd"}}
+		h = mg.NewHarness(t, &GoFmter{Backend: pwd}).Open(fn, src, 0)
+		st = h.Dispatch(mg.ViewFmt{})
+		if want := filepath.Dir(fn) + "\n"; string(st.View.Src) != want {
+			t.Errorf("command backend: src = (%q), errors = (%q); want it run in the view's directory (%q)", st.View.Src, st.Errors, want)
+		}
+	}
+
+	missing := GoFmtBackend{Name: "margo-test-no-such-fmt"}
+	h = mg.NewHarness(t, &GoFmter{Backend: missing}).Open(fn, src, 0)
+	st = h.Dispatch(mg.ViewFmt{})
+	if string(st.View.Src) != src {
+		t.Errorf("missing backend: src = (%q); want it unchanged", st.View.Src)
+	}
+	if len(st.Errors) != 1 || !strings.Contains(st.Errors[0], missing.Name) {
+		t.Errorf("missing backend: errors = (%q); want an error naming %s", st.Errors, missing.Name)
+	}
+}
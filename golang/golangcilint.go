@@ -0,0 +1,250 @@
+package golang
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"margo.sh/mg"
+	"margo.sh/mgutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// golangciLintConfigNames are the names of the config files searched for by GolangCILint, in order of preference
+	golangciLintConfigNames = []string{
+		".golangci.yml",
+		".golangci.yaml",
+		".golangci.toml",
+		".golangci.json",
+	}
+)
+
+// GolangCILint is a linter that runs golangci-lint (https://golangci-lint.run) on the package of the current view,
+// when it's activated or saved, so the results of CI linting are shown in the editor.
+//
+// The config file is found by searching the view's directory, and its parents, for a .golangci.{yml,yaml,toml,json} file.
+// The project's config selects the linters as usual, Enable, Disable and EnableOnly change that selection.
+//
+// Issues are labeled with the name of the linter that reported them,
+// and tagged using their severity: error, warning or info/notice. Issues without a severity are tagged using Tag.
+type GolangCILint struct {
+	mg.ReducerType
+
+	// Path is the name, or path, of the golangci-lint command. If it's empty, golangci-lint is found in $PATH
+	Path string
+
+	// V1 must be set when using golangci-lint v1, whose flags differ from those of v2
+	V1 bool
+
+	// Enable is the list of linters to enable, in addition to those enabled by the config
+	Enable []string
+
+	// Disable is the list of linters to disable
+	Disable []string
+
+	// EnableOnly disables the linters enabled by default, or by the config, so only those in Enable are run
+	EnableOnly bool
+
+	// Args are extra arguments passed to `golangci-lint run`, before the package
+	Args []string
+
+	// Tag is the tag of issues without a severity. If it's empty, mg.Warning is used
+	Tag mg.IssueTag
+
+	q *mgutil.ChanQ
+}
+
+// golangciLintKey is the IssueKey.Key of the issues of the package in Dir
+type golangciLintKey struct {
+	Dir string
+}
+
+// golangciLintReport is the part of golangci-lint's JSON output decoded by GolangCILint
+type golangciLintReport struct {
+	Issues []struct {
+		FromLinter string
+		Text       string
+		Severity   string
+		Pos        struct {
+			Filename string
+			Line     int
+			Column   int
+		}
+	}
+}
+
+func (gl *GolangCILint) RCond(mx *mg.Ctx) bool {
+	return mx.LangIs(mg.Go) && mx.View.Path != ""
+}
+
+func (gl *GolangCILint) RMount(mx *mg.Ctx) {
+	gl.q = mgutil.NewChanQ(1)
+	go gl.loop()
+}
+
+func (gl *GolangCILint) RUnmount(mx *mg.Ctx) {
+	gl.q.Close()
+}
+
+func (gl *GolangCILint) Reduce(mx *mg.Ctx) *mg.State {
+	switch mx.Action.(type) {
+	case mg.ViewActivated, mg.ViewSaved:
+		gl.q.Put(mx)
+	}
+	return mx.State
+}
+
+func (gl *GolangCILint) loop() {
+	for v := range gl.q.C() {
+		gl.lint(v.(*mg.Ctx))
+	}
+}
+
+// name returns the name of the golangci-lint command
+func (gl *GolangCILint) name() string {
+	if gl.Path != "" {
+		return gl.Path
+	}
+	return "golangci-lint"
+}
+
+// config returns the name of the config file of the view's project, or "" if there's none
+func (gl *GolangCILint) config(mx *mg.Ctx) string {
+	dir := mx.View.Dir()
+	for _, name := range golangciLintConfigNames {
+		if nd, _, err := mx.VFS.Poke(dir).Locate(name); err == nil {
+			return filepath.Join(nd.Parent().Path(), name)
+		}
+	}
+	return ""
+}
+
+// args returns the arguments passed to golangci-lint, using the config file cfg, if it's not empty
+func (gl *GolangCILint) args(cfg string) []string {
+	args := []string{"run", "--issues-exit-code=0"}
+	if gl.V1 {
+		args = append(args, "--out-format=json")
+	} else {
+		args = append(args, "--output.json.path=stdout")
+	}
+	if cfg != "" {
+		args = append(args, "--config="+cfg)
+	}
+	if gl.EnableOnly {
+		if gl.V1 {
+			args = append(args, "--disable-all")
+		} else {
+			args = append(args, "--default=none")
+		}
+	}
+	if len(gl.Enable) != 0 {
+		args = append(args, "--enable="+strings.Join(gl.Enable, ","))
+	}
+	if len(gl.Disable) != 0 {
+		args = append(args, "--disable="+strings.Join(gl.Disable, ","))
+	}
+	args = append(args, gl.Args...)
+	return append(args, ".")
+}
+
+func (gl *GolangCILint) lint(mx *mg.Ctx) {
+	v := mx.View
+	dir := v.Dir()
+	cfg := gl.config(mx)
+	name := gl.name()
+	args := gl.args(cfg)
+
+	defer mx.Begin(mg.Task{Title: "golangci-lint " + v.ShortFn(mx.Env)}).Done()
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	cmd.Env = mx.Env.Environ()
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		mx.Log.Printf("golangci-lint: `%s` failed: %s: %s\n", mgutil.QuoteCmd(name, args...), err, bytes.TrimSpace(stderr.Bytes()))
+		return
+	}
+
+	dirs := []string{dir}
+	if cfg != "" {
+		dirs = append(dirs, filepath.Dir(cfg))
+	}
+	issues, err := gl.issues(stdout, dirs)
+	if err != nil {
+		mx.Log.Println("golangci-lint:", err)
+		return
+	}
+	mx.Store.Dispatch(mg.StoreIssues{
+		IssueKey: mg.IssueKey{Key: golangciLintKey{Dir: dir}, Dir: dir},
+		Issues:   issues,
+	})
+}
+
+// issues converts the JSON output of golangci-lint read from r to issues.
+// Relative file names are resolved against the first directory in dirs in which the file exists.
+func (gl *GolangCILint) issues(r io.Reader, dirs []string) (mg.IssueSet, error) {
+	rep := golangciLintReport{}
+	if err := json.NewDecoder(r).Decode(&rep); err != nil {
+		return nil, fmt.Errorf("cannot decode report: %s", err)
+	}
+
+	issues := make(mg.IssueSet, 0, len(rep.Issues))
+	for _, gi := range rep.Issues {
+		isu := mg.Issue{
+			Path:    gl.path(gi.Pos.Filename, dirs),
+			Row:     gi.Pos.Line - 1,
+			Col:     gi.Pos.Column - 1,
+			Tag:     gl.tag(gi.Severity),
+			Label:   "Go/golangci-lint",
+			Message: gi.Text,
+		}
+		if gi.FromLinter != "" {
+			isu.Label += "/" + gi.FromLinter
+		}
+		if isu.Row < 0 {
+			isu.Row = 0
+		}
+		if isu.Col < 0 {
+			isu.Col = 0
+		}
+		issues = append(issues, isu)
+	}
+	return issues, nil
+}
+
+// path resolves the file name fn reported by golangci-lint. See issues
+func (gl *GolangCILint) path(fn string, dirs []string) string {
+	if fn == "" || filepath.IsAbs(fn) {
+		return fn
+	}
+	for _, dir := range dirs {
+		p := filepath.Join(dir, fn)
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return filepath.Join(dirs[0], fn)
+}
+
+// tag returns the tag of issues with severity sev
+func (gl *GolangCILint) tag(sev string) mg.IssueTag {
+	switch strings.ToLower(sev) {
+	case "error":
+		return mg.Error
+	case "warning":
+		return mg.Warning
+	case "info", "notice":
+		return mg.Notice
+	}
+	if gl.Tag != "" {
+		return gl.Tag
+	}
+	return mg.Warning
+}
@@ -0,0 +1,127 @@
+package golang
+
+import (
+	"io/ioutil"
+	"margo.sh/mg"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGolangCILintArgs(t *testing.T) {
+	cases := []struct {
+		name string
+		gl   GolangCILint
+		cfg  string
+		want string
+	}{
+		{
+			name: "default",
+			want: "run --issues-exit-code=0 --output.json.path=stdout .",
+		},
+		{
+			name: "v1",
+			gl:   GolangCILint{V1: true},
+			want: "run --issues-exit-code=0 --out-format=json .",
+		},
+		{
+			name: "config",
+			cfg:  "/p/.golangci.yml",
+			want: "run --issues-exit-code=0 --output.json.path=stdout --config=/p/.golangci.yml .",
+		},
+		{
+			name: "enable only",
+			gl:   GolangCILint{EnableOnly: true, Enable: []string{"govet", "errcheck"}},
+			want: "run --issues-exit-code=0 --output.json.path=stdout --default=none --enable=govet,errcheck .",
+		},
+		{
+			name: "v1 enable only",
+			gl:   GolangCILint{V1: true, EnableOnly: true, Enable: []string{"govet"}},
+			want: "run --issues-exit-code=0 --out-format=json --disable-all --enable=govet .",
+		},
+		{
+			name: "disable and args",
+			gl:   GolangCILint{Disable: []string{"unused"}, Args: []string{"--fast"}},
+			want: "run --issues-exit-code=0 --output.json.path=stdout --disable=unused --fast .",
+		},
+	}
+	for _, c := range cases {
+		if got := strings.Join(c.gl.args(c.cfg), " "); got != c.want {
+			t.Errorf("%s: args() = (%s); want (%s)", c.name, got, c.want)
+		}
+	}
+}
+
+func TestGolangCILintIssues(t *testing.T) {
+	pkgDir := t.TempDir()
+	cfgDir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(cfgDir, "b.go"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	dirs := []string{pkgDir, cfgDir}
+
+	cases := []struct {
+		name string
+		out  string
+		want mg.IssueSet
+		err  bool
+	}{
+		{
+			name: "no issues",
+			out:  `{"Issues":null}`,
+			want: mg.IssueSet{},
+		},
+		{
+			name: "issues",
+			out: `{"Issues":[
+				{"FromLinter":"govet","Text":"unreachable code","Severity":"error","Pos":{"Filename":"/abs/a.go","Line":4,"Column":2}},
+				{"FromLinter":"errcheck","Text":"error is not checked","Pos":{"Filename":"b.go","Line":0,"Column":0}},
+				{"Text":"missing doc","Severity":"info","Pos":{"Filename":"c.go","Line":1,"Column":1}}
+			]}`,
+			want: mg.IssueSet{
+				{Path: "/abs/a.go", Row: 3, Col: 1, Tag: mg.Error, Label: "Go/golangci-lint/govet", Message: "unreachable code"},
+				{Path: filepath.Join(cfgDir, "b.go"), Tag: mg.Warning, Label: "Go/golangci-lint/errcheck", Message: "error is not checked"},
+				{Path: filepath.Join(pkgDir, "c.go"), Tag: mg.Notice, Label: "Go/golangci-lint", Message: "missing doc"},
+			},
+		},
+		{
+			name: "malformed",
+			out:  `level=error msg="no go files to analyze"`,
+			err:  true,
+		},
+	}
+	gl := &GolangCILint{}
+	for _, c := range cases {
+		got, err := gl.issues(strings.NewReader(c.out), dirs)
+		if (err != nil) != c.err {
+			t.Errorf("%s: issues() error = (%v); want error: %v", c.name, err, c.err)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: issues() = %+v; want %+v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestGolangCILintTag(t *testing.T) {
+	cases := []struct {
+		sev  string
+		tag  mg.IssueTag
+		want mg.IssueTag
+	}{
+		{sev: "error", want: mg.Error},
+		{sev: "Warning", want: mg.Warning},
+		{sev: "info", want: mg.Notice},
+		{sev: "notice", want: mg.Notice},
+		{sev: "", want: mg.Warning},
+		{sev: "", tag: mg.Error, want: mg.Error},
+		{sev: "unknown", tag: mg.Notice, want: mg.Notice},
+		{sev: "error", tag: mg.Notice, want: mg.Error},
+	}
+	for _, c := range cases {
+		gl := &GolangCILint{Tag: c.tag}
+		if got := gl.tag(c.sev); got != c.want {
+			t.Errorf("GolangCILint{Tag: %q}.tag(%q) = (%s); want (%s)", c.tag, c.sev, got, c.want)
+		}
+	}
+}
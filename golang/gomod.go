@@ -0,0 +1,380 @@
+package golang
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"io"
+	"io/ioutil"
+	"margo.sh/golang/goutil"
+	"margo.sh/mg"
+	"margo.sh/mgutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// goModErrPat matches errors reported at a position in a go.mod file e.g. `/src/x/go.mod:3:2: unknown directive: x`
+	goModErrPat = regexp.MustCompile(`^(.*go\.mod):(\d+)(?::(\d+))?: (.+)$`)
+)
+
+// GoModCmds adds commands to edit the go.mod file of the module of the current view,
+// so dependencies can be managed without leaving the editor:
+//
+//   - mod.require PATH[@VERSION]... adds, or updates, the requirement of each module.
+//     If VERSION isn't a canonical version e.g. it's a branch name, or `latest` (the default),
+//     it's resolved using `go list -m`.
+//   - mod.drop PATH... removes the requirement of each module.
+//   - mod.replace OLD[@VERSION] NEW[@VERSION] replaces module OLD with module NEW,
+//     or the module in directory NEW if it's a path e.g. ../x.
+//   - mod.tidy runs `go mod tidy`.
+//
+// UserCmds are added for each command, prompting for their arguments.
+//
+// After go.mod is edited, the module graph is loaded using `go list -m all`,
+// and its errors e.g. unknown versions, are reported as issues in go.mod.
+//
+// The commands edit go.mod on disk, so they refuse to run from its view while it has unsaved changes.
+type GoModCmds struct {
+	mg.ReducerType
+}
+
+// goModCmdsKey is the IssueKey.Key of the issues of the go.mod file Fn
+type goModCmdsKey struct {
+	Fn string
+}
+
+func (gm *GoModCmds) RCond(mx *mg.Ctx) bool {
+	return mx.ActionIs(mg.QueryUserCmds{}, mg.RunCmd{})
+}
+
+func (gm *GoModCmds) Reduce(mx *mg.Ctx) *mg.State {
+	switch act := mx.Action.(type) {
+	case mg.QueryUserCmds:
+		if gm.modFile(mx) == "" {
+			return mx.State
+		}
+		return mx.AddUserCmds(gm.userCmds()...)
+	case mg.RunCmd:
+		if desc, ok := gm.cmds()[act.Name]; ok {
+			return mx.AddBuiltinCmds(mg.BuiltinCmd{Name: act.Name, Desc: desc, Run: gm.runCmd})
+		}
+	}
+	return mx.State
+}
+
+// cmds returns the descriptions of the commands, by name
+func (gm *GoModCmds) cmds() map[string]string {
+	return map[string]string{
+		"mod.require": "mod.require PATH[@VERSION]... adds, or updates, the requirement of each module in go.mod",
+		"mod.drop":    "mod.drop PATH... removes the requirement of each module from go.mod",
+		"mod.replace": "mod.replace OLD[@VERSION] NEW[@VERSION] replaces module OLD with module, or directory, NEW in go.mod",
+		"mod.tidy":    "mod.tidy runs `go mod tidy`",
+	}
+}
+
+func (gm *GoModCmds) userCmds() mg.UserCmdList {
+	return mg.UserCmdList{
+		{
+			Title:   "Go Mod: Require module",
+			Name:    "mod.require",
+			Prompts: []string{"module path[@version]"},
+		},
+		{
+			Title:   "Go Mod: Drop module requirement",
+			Name:    "mod.drop",
+			Prompts: []string{"module path"},
+		},
+		{
+			Title:   "Go Mod: Replace module",
+			Name:    "mod.replace",
+			Prompts: []string{"replaced module path[@version]", "replacement module path[@version], or directory"},
+		},
+		{
+			Title: "Go Mod: Tidy",
+			Name:  "mod.tidy",
+		},
+	}
+}
+
+// modFile returns the name of the go.mod file of the view's module, or "" if there's none
+func (gm *GoModCmds) modFile(mx *mg.Ctx) string {
+	if nd := goutil.ModFileNd(mx, mx.View.Dir()); nd != nil {
+		return nd.Path()
+	}
+	return ""
+}
+
+func (gm *GoModCmds) runCmd(cx *mg.CmdCtx) *mg.State {
+	fn := gm.modFile(cx.Ctx)
+	if fn == "" {
+		fmt.Fprintf(cx.Output, "%s: cannot find go.mod for %s\n", cx.Name, cx.View.Dir())
+		cx.Output.Close()
+		return cx.State
+	}
+	// the commands edit go.mod on disk, which would conflict with unsaved changes in the editor
+	if v := cx.View; v.Dirty && v.Filename() == fn {
+		fmt.Fprintf(cx.Output, "%s: %s has unsaved changes, save it first\n", cx.Name, mgutil.ShortFn(fn, cx.Env))
+		cx.Output.Close()
+		return cx.State
+	}
+
+	args := append([]string(nil), cx.Args...)
+	for _, s := range cx.Prompts {
+		args = append(args, strings.Fields(s)...)
+	}
+	title := strings.TrimSpace(cx.Name + " " + strings.Join(args, " "))
+	cx.Jobs.Submit(title, func(*mg.JobCtx) []mg.Action {
+		gm.run(cx, fn, args)
+		return nil
+	})
+	return cx.State
+}
+
+// run runs command cx.Name, with arguments args, on the go.mod file fn, and reports its errors as issues
+func (gm *GoModCmds) run(cx *mg.CmdCtx, fn string, args []string) {
+	defer cx.Output.Close()
+
+	dir := filepath.Dir(fn)
+	var err error
+	if cx.Name == "mod.tidy" {
+		err = gm.goCmd(cx, dir, "mod", "tidy")
+	} else {
+		err = gm.edit(cx, fn, args)
+	}
+	if err == nil {
+		err = gm.goCmd(cx, dir, "list", "-mod=mod", "-m", "all")
+	}
+
+	src, _ := ioutil.ReadFile(fn)
+	cx.Store.Dispatch(mg.StoreIssues{
+		IssueKey: mg.IssueKey{Key: goModCmdsKey{Fn: fn}, Dir: dir},
+		Issues:   goModIssues(fn, src, err),
+	})
+	cx.Store.Publish(goutil.ModGraphChanged{Dir: dir})
+
+	if err != nil {
+		fmt.Fprintf(cx.Output, "%s: %s\n", cx.Name, err)
+		return
+	}
+	fmt.Fprintf(cx.Output, "%s: %s updated\n", cx.Name, mgutil.ShortFn(fn, cx.Env))
+}
+
+// edit applies the edit of command cx.Name, with arguments args, to the go.mod file fn
+func (gm *GoModCmds) edit(cx *mg.CmdCtx, fn string, args []string) error {
+	fi, err := os.Stat(fn)
+	if err != nil {
+		return err
+	}
+	src, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return err
+	}
+	resolve := func(path, query string) (string, error) {
+		out := &bytes.Buffer{}
+		err := gm.goCmdOutput(cx, filepath.Dir(fn), out, "list", "-m", "-f", "{{.Version}}", path+"@"+query)
+		return strings.TrimSpace(out.String()), err
+	}
+	p, err := goModEdit(fn, src, cx.Name, args, resolve)
+	if err != nil {
+		return err
+	}
+	return goModWrite(fn, p, fi.Mode().Perm())
+}
+
+// goModWrite replaces the content of the file fn with p atomically,
+// so the go command, or the editor, never sees a partially written file
+func goModWrite(fn string, p []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(fn), ".tmp-go.mod-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if _, err := f.Write(p); err != nil {
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), fn)
+}
+
+// goCmd runs the go command with arguments args in dir, discarding its output
+func (gm *GoModCmds) goCmd(cx *mg.CmdCtx, dir string, args ...string) error {
+	return gm.goCmdOutput(cx, dir, ioutil.Discard, args...)
+}
+
+// goCmdOutput runs the go command with arguments args in dir, writing its output to stdout.
+// If it fails, the returned error's message is the command's stderr.
+func (gm *GoModCmds) goCmdOutput(cx *mg.CmdCtx, dir string, stdout io.Writer, args ...string) error {
+	if cx.Verbose {
+		fmt.Fprintln(cx.Output, "#", mgutil.QuoteCmd("go", args...))
+	}
+	stderr := &bytes.Buffer{}
+	cmd := exec.CommandContext(cx.Ctx, "go", args...)
+	cmd.Dir = dir
+	cmd.Env = cx.Env.Environ()
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		if msg := bytes.TrimSpace(stderr.Bytes()); len(msg) != 0 {
+			return errors.New(string(msg))
+		}
+		return fmt.Errorf("`%s` failed: %s", mgutil.QuoteCmd("go", args...), err)
+	}
+	return nil
+}
+
+// goModEdit returns the go.mod file fn, whose content is src, edited by command name with arguments args.
+// resolve is called to find the version of modules whose version isn't canonical e.g. `latest`.
+func goModEdit(fn string, src []byte, name string, args []string, resolve func(path, query string) (string, error)) ([]byte, error) {
+	f, err := modfile.Parse(fn, src, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	version := func(arg string) (path, vers string, err error) {
+		path, vers = goModSplitPathVersion(arg)
+		if vers == "" {
+			vers = "latest"
+		}
+		if module.CanonicalVersion(vers) == vers {
+			return path, vers, nil
+		}
+		vers, err = resolve(path, vers)
+		return path, vers, err
+	}
+
+	switch name {
+	case "mod.require":
+		if len(args) == 0 {
+			return nil, errors.New("usage: mod.require PATH[@VERSION]...")
+		}
+		for _, arg := range args {
+			path, vers, err := version(arg)
+			if err != nil {
+				return nil, err
+			}
+			if err := f.AddRequire(path, vers); err != nil {
+				return nil, err
+			}
+		}
+	case "mod.drop":
+		if len(args) == 0 {
+			return nil, errors.New("usage: mod.drop PATH...")
+		}
+		for _, arg := range args {
+			path, _ := goModSplitPathVersion(arg)
+			if !goModRequires(f, path) {
+				return nil, fmt.Errorf("%s is not required", path)
+			}
+			if err := f.DropRequire(path); err != nil {
+				return nil, err
+			}
+		}
+	case "mod.replace":
+		if len(args) != 2 {
+			return nil, errors.New("usage: mod.replace OLD[@VERSION] NEW[@VERSION]")
+		}
+		oldPath, oldVers := goModSplitPathVersion(args[0])
+		newPath, newVers := args[1], ""
+		if !modfile.IsDirectoryPath(newPath) {
+			newPath, newVers, err = version(newPath)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if err := f.AddReplace(oldPath, oldVers, newPath, newVers); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown command: %s", name)
+	}
+
+	f.Cleanup()
+	return f.Format()
+}
+
+// goModSplitPathVersion splits arg into a module path and version e.g. example.com/x@v1.2.3
+func goModSplitPathVersion(arg string) (path, vers string) {
+	if i := strings.LastIndexByte(arg, '@'); i >= 0 {
+		return arg[:i], arg[i+1:]
+	}
+	return arg, ""
+}
+
+// goModRequires reports whether module path is required by f
+func goModRequires(f *modfile.File, path string) bool {
+	for _, r := range f.Require {
+		if r.Mod.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// goModIssues converts the error err, reported after editing the go.mod file fn whose content is src, to issues.
+//
+// Errors reported at a position in fn are placed there.
+// Other errors are placed on the line of the module they mention, if any, or the first line of the file.
+func goModIssues(fn string, src []byte, err error) mg.IssueSet {
+	if err == nil {
+		return nil
+	}
+
+	f, _ := modfile.ParseLax(fn, src, nil)
+	rowOf := func(msg string) int {
+		row, plen := 0, 0
+		if f == nil {
+			return row
+		}
+		for _, r := range f.Require {
+			if p := r.Mod.Path; len(p) > plen && strings.Contains(msg, p) {
+				row, plen = r.Syntax.Start.Line-1, len(p)
+			}
+		}
+		return row
+	}
+
+	var issues mg.IssueSet
+	for _, line := range strings.Split(err.Error(), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line == "go: errors parsing go.mod:" {
+			continue
+		}
+		isu := mg.Issue{
+			Path:  fn,
+			Tag:   mg.Error,
+			Label: "Go/mod",
+		}
+		if m := goModErrPat.FindStringSubmatch(line); m != nil && (m[1] == fn || m[1] == "go.mod") {
+			isu.Row, _ = strconv.Atoi(m[2])
+			isu.Col, _ = strconv.Atoi(m[3])
+			isu.Row--
+			isu.Col--
+			isu.Message = m[4]
+		} else {
+			isu.Message = strings.TrimPrefix(line, "go: ")
+			isu.Row = rowOf(isu.Message)
+		}
+		if isu.Row < 0 {
+			isu.Row = 0
+		}
+		if isu.Col < 0 {
+			isu.Col = 0
+		}
+		issues = append(issues, isu)
+	}
+	return issues
+}
@@ -0,0 +1,150 @@
+package golang
+
+import (
+	"errors"
+	"io/ioutil"
+	"margo.sh/mg"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGoModEdit(t *testing.T) {
+	src := `module example.com/m
+
+go 1.21
+
+toolchain go1.22.0
+
+require (
+	example.com/a v1.0.0
+	example.com/b v1.1.0
+)
+`
+	resolve := func(path, query string) (string, error) {
+		if path == "example.com/c" && query == "latest" {
+			return "v1.3.0", nil
+		}
+		return "", errors.New("go: " + path + "@" + query + ": no matching versions")
+	}
+	tests := []struct {
+		name string
+		args []string
+		want string
+		err  bool
+	}{
+		{
+			name: "mod.require",
+			args: []string{"example.com/a@v1.0.1", "example.com/c"},
+			want: `module example.com/m
+
+go 1.21
+
+toolchain go1.22.0
+
+require (
+	example.com/a v1.0.1
+	example.com/b v1.1.0
+	example.com/c v1.3.0
+)
+`,
+		},
+		{
+			name: "mod.require",
+			args: []string{"example.com/d@master"},
+			err:  true,
+		},
+		{
+			name: "mod.drop",
+			args: []string{"example.com/b"},
+			want: `module example.com/m
+
+go 1.21
+
+toolchain go1.22.0
+
+require example.com/a v1.0.0
+`,
+		},
+		{
+			name: "mod.drop",
+			args: []string{"example.com/c"},
+			err:  true,
+		},
+		{
+			name: "mod.replace",
+			args: []string{"example.com/a", "../a"},
+			want: src + "\nreplace example.com/a => ../a\n",
+		},
+		{
+			name: "mod.replace",
+			args: []string{"example.com/a"},
+			err:  true,
+		},
+	}
+	for _, tc := range tests {
+		got, err := goModEdit("/m/go.mod", []byte(src), tc.name, tc.args, resolve)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s %q: expected an error, got:\n%s", tc.name, tc.args, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %q: %s", tc.name, tc.args, err)
+			continue
+		}
+		if string(got) != tc.want {
+			t.Errorf("%s %q: got:\n%s\nwant:\n%s", tc.name, tc.args, got, tc.want)
+		}
+	}
+}
+
+func TestGoModIssues(t *testing.T) {
+	src := []byte(`module example.com/m
+
+go 1.21
+
+require (
+	example.com/a v1.0.0
+	example.com/b v1.9.9
+)
+`)
+	err := errors.New("go: errors parsing go.mod:\n/m/go.mod:3:1: unknown directive: x\n" +
+		"go: example.com/b@v1.9.9: invalid version: unknown revision v1.9.9")
+	got := goModIssues("/m/go.mod", src, err)
+	want := mg.IssueSet{
+		{Path: "/m/go.mod", Row: 2, Col: 0, Tag: mg.Error, Label: "Go/mod", Message: "unknown directive: x"},
+		{Path: "/m/go.mod", Row: 6, Col: 0, Tag: mg.Error, Label: "Go/mod", Message: "example.com/b@v1.9.9: invalid version: unknown revision v1.9.9"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("goModIssues() = %+v, want %+v", got, want)
+	}
+	if l := goModIssues("/m/go.mod", src, nil); len(l) != 0 {
+		t.Fatalf("goModIssues(nil) = %+v, want none", l)
+	}
+}
+
+func TestGoModWrite(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "go.mod")
+	if err := ioutil.WriteFile(fn, []byte("module example.com/old\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	want := "module example.com/new\n"
+	if err := goModWrite(fn, []byte(want), 0640); err != nil {
+		t.Fatalf("goModWrite() = %v, want nil", err)
+	}
+	if got, _ := ioutil.ReadFile(fn); string(got) != want {
+		t.Errorf("go.mod = %q, want %q", got, want)
+	}
+	if fi, err := os.Stat(fn); err != nil {
+		t.Error(err)
+	} else if perm := fi.Mode().Perm(); perm != 0640 {
+		t.Errorf("go.mod mode = %v, want %v", perm, os.FileMode(0640))
+	}
+	if l, _ := ioutil.ReadDir(dir); len(l) != 1 {
+		t.Errorf("%d files in %s after goModWrite(), want only go.mod", len(l), dir)
+	}
+}
@@ -0,0 +1,407 @@
+package golang
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"io"
+	"io/ioutil"
+	"margo.sh/mg"
+	"margo.sh/mgutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// goModDiagTTL is the amount of time after which the results of unchanged go.mod and go.sum files are checked again,
+	// so new upgrades, retractions and deprecations are reported
+	goModDiagTTL = time.Hour
+)
+
+// GoModDiagnostics is a linter that checks the go.mod and go.sum files of a module,
+// when either of them is activated, modified or saved.
+//
+// It reports, as issues in go.mod:
+//
+//   - syntax errors
+//   - required modules that can't be loaded e.g. unknown modules or versions
+//   - requirements whose go.sum entries are missing
+//   - retracted versions, and deprecated modules
+//   - available upgrades of direct requirements
+//
+// Unsaved changes are checked by passing a copy of the files to the go command, using its -modfile flag.
+// Retractions, deprecations and upgrades are found using the module proxy (see `go help goproxy`).
+//
+// The tooltip of a requirement shows its details e.g. the available upgrade, or why its version was retracted.
+type GoModDiagnostics struct {
+	mg.ReducerType
+
+	// NoUpgrades disables checking for upgrades, and deprecated modules i.e. `go list -u` isn't used.
+	// Retracted versions are still reported.
+	NoUpgrades bool
+
+	q   *mgutil.ChanQ
+	mu  sync.Mutex
+	res map[string]*goModDiagRes
+}
+
+// goModDiagKey is the IssueKey.Key of the issues of the go.mod file Fn
+type goModDiagKey struct {
+	Fn string
+}
+
+// goModDiagRes is the result of checking a go.mod file
+type goModDiagRes struct {
+	// hash is the hash of the go.mod and go.sum files that were checked
+	hash string
+	// time is the time at which they were checked
+	time time.Time
+	// mods are the required modules, by path
+	mods map[string]goModListEnt
+	// issues are the issues that were found
+	issues mg.IssueSet
+}
+
+// goModListEnt is the part of a module listed by `go list -m -json` used by GoModDiagnostics
+type goModListEnt struct {
+	Path    string
+	Version string
+	Dir     string
+	Update  *struct {
+		Version string
+	}
+	Replace *struct {
+		Path    string
+		Version string
+	}
+	Retracted  []string
+	Deprecated string
+	Error      *struct {
+		Err string
+	}
+}
+
+func (gd *GoModDiagnostics) RCond(mx *mg.Ctx) bool {
+	return mx.LangIs(mg.GoMod, mg.GoSum) && filepath.IsAbs(mx.View.Dir())
+}
+
+func (gd *GoModDiagnostics) RMount(mx *mg.Ctx) {
+	gd.q = mgutil.NewChanQ(1)
+	gd.res = map[string]*goModDiagRes{}
+	go gd.loop()
+}
+
+func (gd *GoModDiagnostics) RUnmount(mx *mg.Ctx) {
+	gd.q.Close()
+}
+
+func (gd *GoModDiagnostics) Reduce(mx *mg.Ctx) *mg.State {
+	switch act := mx.Action.(type) {
+	case mg.ViewActivated, mg.ViewModified, mg.ViewSaved:
+		gd.q.Put(mx)
+	case mg.QueryTooltips:
+		if mx.LangIs(mg.GoMod) {
+			return gd.tooltips(mx, act)
+		}
+	}
+	return mx.State
+}
+
+func (gd *GoModDiagnostics) loop() {
+	for v := range gd.q.C() {
+		gd.check(v.(*mg.Ctx))
+	}
+}
+
+// read returns the contents of the go.mod and go.sum files in dir.
+// The contents of the view are used in place of the file it's editing.
+func (gd *GoModDiagnostics) read(mx *mg.Ctx, dir string) (mod, sum []byte) {
+	read := func(name string) []byte {
+		fn := filepath.Join(dir, name)
+		if v := mx.View; v.Filename() == fn {
+			src, _ := v.ReadAll()
+			return src
+		}
+		src, _ := ioutil.ReadFile(fn)
+		return src
+	}
+	return read("go.mod"), read("go.sum")
+}
+
+func (gd *GoModDiagnostics) check(mx *mg.Ctx) {
+	dir := mx.View.Dir()
+	fn := filepath.Join(dir, "go.mod")
+	modSrc, sumSrc := gd.read(mx, dir)
+	if len(modSrc) == 0 {
+		return
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%v %d %d\n", gd.NoUpgrades, len(modSrc), len(sumSrc))
+	h.Write(modSrc)
+	h.Write(sumSrc)
+	hash := fmt.Sprintf("%x", h.Sum(nil))
+
+	gd.mu.Lock()
+	res := gd.res[fn]
+	gd.mu.Unlock()
+	if res == nil || res.hash != hash || time.Since(res.time) >= goModDiagTTL {
+		res = gd.diagnose(mx, fn, modSrc, sumSrc)
+		res.hash = hash
+		gd.mu.Lock()
+		gd.res[fn] = res
+		gd.mu.Unlock()
+	}
+
+	mx.Store.Dispatch(mg.StoreIssues{
+		IssueKey: mg.IssueKey{Key: goModDiagKey{Fn: fn}, Dir: dir},
+		Issues:   res.issues,
+	})
+}
+
+// diagnose checks the go.mod file fn, whose content is modSrc, and its go.sum file, whose content is sumSrc
+func (gd *GoModDiagnostics) diagnose(mx *mg.Ctx, fn string, modSrc, sumSrc []byte) *goModDiagRes {
+	defer mx.Begin(mg.Task{Title: "Go/mod diagnostics " + mgutil.ShortFn(fn, mx.Env)}).Done()
+
+	res := &goModDiagRes{time: time.Now()}
+	f, err := modfile.Parse(fn, modSrc, nil)
+	if err != nil {
+		res.issues = goModIssues(fn, modSrc, err)
+		return res
+	}
+
+	res.issues = goModSumIssues(fn, f, sumSrc)
+	res.mods, err = gd.list(mx, f, modSrc, sumSrc)
+	if err != nil {
+		mx.Log.Println("GoModDiagnostics:", err)
+		res.issues = append(res.issues, goModIssues(fn, modSrc, err)...)
+	}
+	res.issues = append(res.issues, goModListIssues(fn, f, res.mods)...)
+	return res
+}
+
+// list returns the modules required by f, by path, as listed by `go list -m`.
+// The go command is passed copies of the go.mod and go.sum files, whose contents are modSrc and sumSrc.
+func (gd *GoModDiagnostics) list(mx *mg.Ctx, f *modfile.File, modSrc, sumSrc []byte) (map[string]goModListEnt, error) {
+	var paths []string
+	seen := map[string]bool{}
+	for _, r := range f.Require {
+		if p := r.Mod.Path; !seen[p] {
+			seen[p] = true
+			paths = append(paths, p)
+		}
+	}
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	tmpDir, err := mg.MkTempDir("go-mod-diagnostics")
+	if err != nil {
+		return nil, fmt.Errorf("cannot create temp dir: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	modFn := filepath.Join(tmpDir, "go.mod")
+	if err := ioutil.WriteFile(modFn, modSrc, 0600); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, "go.sum"), sumSrc, 0600); err != nil {
+		return nil, err
+	}
+
+	args := []string{"list", "-modfile=" + modFn, "-m", "-e", "-json"}
+	if gd.NoUpgrades {
+		args = append(args, "-retracted")
+	} else {
+		args = append(args, "-u")
+	}
+	args = append(args, paths...)
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd := exec.CommandContext(mx, "go", args...)
+	cmd.Dir = mx.View.Dir()
+	cmd.Env = mx.Env.Environ()
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err = cmd.Run()
+	if _, ok := err.(*exec.ExitError); err != nil && (!ok || stdout.Len() == 0) {
+		if msg := bytes.TrimSpace(stderr.Bytes()); len(msg) != 0 {
+			return nil, fmt.Errorf("%s", msg)
+		}
+		return nil, fmt.Errorf("`%s` failed: %s", mgutil.QuoteCmd("go", args...), err)
+	}
+
+	mods := map[string]goModListEnt{}
+	dec := json.NewDecoder(stdout)
+	for {
+		ent := goModListEnt{}
+		err := dec.Decode(&ent)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return mods, fmt.Errorf("cannot decode `go list` output: %s", err)
+		}
+		mods[ent.Path] = ent
+	}
+	return mods, nil
+}
+
+// tooltips returns the details of the module required on row qt.Row of the view
+func (gd *GoModDiagnostics) tooltips(mx *mg.Ctx, qt mg.QueryTooltips) *mg.State {
+	fn := mx.View.Filename()
+	src, err := mx.View.ReadAll()
+	if err != nil {
+		return mx.State
+	}
+	f, _ := modfile.ParseLax(fn, src, nil)
+	if f == nil {
+		return mx.State
+	}
+
+	gd.mu.Lock()
+	res := gd.res[fn]
+	gd.mu.Unlock()
+	if res == nil {
+		return mx.State
+	}
+	for _, r := range f.Require {
+		if r.Syntax.Start.Line-1 != qt.Row {
+			continue
+		}
+		if ent, ok := res.mods[r.Mod.Path]; ok {
+			return mx.State.AddTooltips(mg.Tooltip{Content: goModTooltip(ent)})
+		}
+	}
+	return mx.State
+}
+
+// goModRequirePos returns the position of requirement r
+func goModRequirePos(r *modfile.Require) (row, col int) {
+	row = r.Syntax.Start.Line - 1
+	col = r.Syntax.Start.LineRune - 1
+	if row < 0 {
+		row = 0
+	}
+	if col < 0 {
+		col = 0
+	}
+	return row, col
+}
+
+// goModSumIssues returns issues for the modules required by the go.mod file fn, parsed as f,
+// whose entries are missing from the go.sum file whose content is sum.
+// Modules replaced by a directory don't need an entry.
+func goModSumIssues(fn string, f *modfile.File, sum []byte) mg.IssueSet {
+	sums := map[string]bool{}
+	for _, ln := range strings.Split(string(sum), "\n") {
+		if l := strings.Fields(ln); len(l) >= 2 {
+			sums[l[0]+" "+l[1]] = true
+		}
+	}
+	replacement := func(m module.Version) module.Version {
+		for _, r := range f.Replace {
+			if r.Old.Path == m.Path && (r.Old.Version == "" || r.Old.Version == m.Version) {
+				return r.New
+			}
+		}
+		return m
+	}
+
+	var issues mg.IssueSet
+	for _, r := range f.Require {
+		m := replacement(r.Mod)
+		if m.Version == "" || sums[m.Path+" "+m.Version+"/go.mod"] {
+			continue
+		}
+		row, col := goModRequirePos(r)
+		issues = append(issues, mg.Issue{
+			Path:    fn,
+			Row:     row,
+			Col:     col,
+			Tag:     mg.Error,
+			Label:   "Go/mod",
+			Message: fmt.Sprintf("missing go.sum entry for %s@%s, run mod.tidy", m.Path, m.Version),
+		})
+	}
+	return issues
+}
+
+// goModListIssues returns issues for the modules required by the go.mod file fn, parsed as f,
+// given their details mods, as listed by `go list -m`
+func goModListIssues(fn string, f *modfile.File, mods map[string]goModListEnt) mg.IssueSet {
+	var issues mg.IssueSet
+	for _, r := range f.Require {
+		ent, ok := mods[r.Mod.Path]
+		if !ok {
+			continue
+		}
+		row, col := goModRequirePos(r)
+		add := func(tag mg.IssueTag, format string, a ...interface{}) {
+			issues = append(issues, mg.Issue{
+				Path:    fn,
+				Row:     row,
+				Col:     col,
+				Tag:     tag,
+				Label:   "Go/mod",
+				Message: fmt.Sprintf(format, a...),
+			})
+		}
+
+		if e := ent.Error; e != nil {
+			// missing sums are reported by goModSumIssues
+			switch {
+			case strings.Contains(e.Err, "missing go.sum entry"):
+			case strings.Contains(e.Err, ent.Path):
+				add(mg.Error, "%s", e.Err)
+			default:
+				add(mg.Error, "%s@%s: %s", ent.Path, ent.Version, e.Err)
+			}
+			continue
+		}
+		switch {
+		case len(ent.Retracted) != 0 && ent.Update != nil:
+			add(mg.Warning, "%s@%s is retracted: %s, upgrade to %s", ent.Path, ent.Version, strings.Join(ent.Retracted, "; "), ent.Update.Version)
+		case len(ent.Retracted) != 0:
+			add(mg.Warning, "%s@%s is retracted: %s", ent.Path, ent.Version, strings.Join(ent.Retracted, "; "))
+		case ent.Update != nil && !r.Indirect:
+			add(mg.Notice, "%s@%s is available", ent.Path, ent.Update.Version)
+		}
+		if ent.Deprecated != "" {
+			add(mg.Warning, "%s is deprecated: %s", ent.Path, ent.Deprecated)
+		}
+	}
+	return issues
+}
+
+// goModTooltip returns the content of the tooltip of module ent
+func goModTooltip(ent goModListEnt) string {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "%s %s\n", ent.Path, ent.Version)
+	if r := ent.Replace; r != nil {
+		fmt.Fprintf(buf, "Replaced by: %s\n", strings.TrimSpace(r.Path+" "+r.Version))
+	}
+	if u := ent.Update; u != nil {
+		fmt.Fprintf(buf, "Upgrade: %s\n", u.Version)
+	}
+	if len(ent.Retracted) != 0 {
+		fmt.Fprintf(buf, "Retracted: %s\n", strings.Join(ent.Retracted, "; "))
+	}
+	if ent.Deprecated != "" {
+		fmt.Fprintf(buf, "Deprecated: %s\n", ent.Deprecated)
+	}
+	if ent.Dir != "" {
+		fmt.Fprintf(buf, "Dir: %s\n", ent.Dir)
+	}
+	if e := ent.Error; e != nil {
+		fmt.Fprintf(buf, "Error: %s\n", e.Err)
+	}
+	return strings.TrimSpace(buf.String())
+}
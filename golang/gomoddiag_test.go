@@ -0,0 +1,67 @@
+package golang
+
+import (
+	"golang.org/x/mod/modfile"
+	"margo.sh/mg"
+	"reflect"
+	"testing"
+)
+
+func TestGoModDiagnosticsIssues(t *testing.T) {
+	fn := "/m/go.mod"
+	src := `module example.com/m
+
+go 1.21
+
+require (
+	example.com/a v1.0.0
+	example.com/b v1.1.0
+	example.com/c v1.2.0 // indirect
+	example.com/d v0.1.0
+	example.com/local v0.0.0
+)
+
+replace example.com/local => ../local
+`
+	sum := `example.com/a v1.0.0 h1:xxx=
+example.com/a v1.0.0/go.mod h1:xxx=
+example.com/b v1.1.0/go.mod h1:xxx=
+example.com/c v1.2.0/go.mod h1:xxx=
+`
+	f, err := modfile.Parse(fn, []byte(src), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upd := func(v string) *struct{ Version string } { return &struct{ Version string }{Version: v} }
+	mods := map[string]goModListEnt{
+		"example.com/a": {Path: "example.com/a", Version: "v1.0.0", Update: upd("v1.1.0")},
+		"example.com/b": {Path: "example.com/b", Version: "v1.1.0", Retracted: []string{"broken"}, Update: upd("v1.1.1"), Deprecated: "use example.com/b2"},
+		"example.com/c": {Path: "example.com/c", Version: "v1.2.0", Update: upd("v1.3.0")},
+		"example.com/d": {Path: "example.com/d", Version: "v0.1.0", Error: &struct{ Err string }{Err: "example.com/d@v0.1.0: missing go.sum entry for go.mod file"}},
+	}
+
+	isu := func(row int, tag mg.IssueTag, msg string) mg.Issue {
+		return mg.Issue{Path: fn, Row: row, Col: 1, Tag: tag, Label: "Go/mod", Message: msg}
+	}
+	want := mg.IssueSet{
+		isu(8, mg.Error, "missing go.sum entry for example.com/d@v0.1.0, run mod.tidy"),
+	}
+	if got := goModSumIssues(fn, f, []byte(sum)); !reflect.DeepEqual(got, want) {
+		t.Errorf("goModSumIssues() = %+v, want %+v", got, want)
+	}
+
+	want = mg.IssueSet{
+		isu(5, mg.Notice, "example.com/a@v1.1.0 is available"),
+		isu(6, mg.Warning, "example.com/b@v1.1.0 is retracted: broken, upgrade to v1.1.1"),
+		isu(6, mg.Warning, "example.com/b is deprecated: use example.com/b2"),
+	}
+	if got := goModListIssues(fn, f, mods); !reflect.DeepEqual(got, want) {
+		t.Errorf("goModListIssues() = %+v, want %+v", got, want)
+	}
+
+	tip := goModTooltip(mods["example.com/b"])
+	wantTip := "example.com/b v1.1.0\nUpgrade: v1.1.1\nRetracted: broken\nDeprecated: use example.com/b2"
+	if tip != wantTip {
+		t.Errorf("goModTooltip() = %q, want %q", tip, wantTip)
+	}
+}
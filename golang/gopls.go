@@ -0,0 +1,511 @@
+package golang
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"margo.sh/golang/goutil"
+	"margo.sh/mg"
+	"margo.sh/mg/lsp"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// goplsDefaultTimeout is the default value of Gopls.Timeout
+	goplsDefaultTimeout = 500 * time.Millisecond
+
+	// goplsRestartDelay is the minimum amount of time between restarts of gopls, if it dies
+	goplsRestartDelay = 10 * time.Second
+
+	// goplsInitTimeout is the amount of time gopls has to respond to initialization
+	goplsInitTimeout = 30 * time.Second
+)
+
+// Gopls is a reducer that delegates completion, tooltips, goto definition and diagnostics to gopls.
+//
+// gopls is started, in the background, the first time a Go view is seen,
+// and the contents of the Go views are sent to it as they change.
+// It's restarted if it dies, and stopped when the reducer is unmounted.
+//
+// It's an alternative to Gocode, Guru and TypeCheck, so they should not be used along with it,
+// unless the matching feature is disabled using the No* fields.
+type Gopls struct {
+	mg.ReducerType
+
+	// Path is the name, or path, of the gopls command. If it's empty, gopls is found in $PATH
+	Path string
+
+	// Args are the extra arguments passed to gopls e.g. -remote=auto
+	Args []string
+
+	// Timeout is the amount of time completion and tooltips queries can take.
+	// If it's zero, 500ms is used.
+	Timeout time.Duration
+
+	// NoCompletions disables completion
+	NoCompletions bool
+
+	// NoTooltips disables tooltips
+	NoTooltips bool
+
+	// NoDefinition disables goto.definition
+	NoDefinition bool
+
+	// NoDiagnostics disables the issues reported by gopls
+	NoDiagnostics bool
+
+	// Debug enables logging of gopls' stderr
+	Debug bool
+
+	mu       sync.Mutex
+	sto      *mg.Store
+	client   *goplsClient
+	starting bool
+	started  time.Time
+	docs     map[string]*goplsDoc
+	roots    map[string]bool
+}
+
+// goplsDoc is the state of a document opened in gopls
+type goplsDoc struct {
+	version int
+	hash    string
+	src     []byte
+}
+
+// goplsIssueKey is the mg.IssueKey.Key of the issues reported by gopls for file Path
+type goplsIssueKey struct {
+	Path string
+}
+
+func (gp *Gopls) RCond(mx *mg.Ctx) bool {
+	return mx.LangIs(mg.Go)
+}
+
+func (gp *Gopls) RMount(mx *mg.Ctx) {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+
+	gp.sto = mx.Store
+	gp.docs = map[string]*goplsDoc{}
+	gp.roots = map[string]bool{}
+}
+
+func (gp *Gopls) RUnmount(mx *mg.Ctx) {
+	gp.mu.Lock()
+	c := gp.client
+	gp.client = nil
+	gp.mu.Unlock()
+
+	if c != nil {
+		c.shutdown(time.Second)
+	}
+}
+
+func (gp *Gopls) RViewClosed(mx *mg.Ctx) {
+	fn := mx.View.Filename()
+
+	gp.mu.Lock()
+	c := gp.client
+	_, open := gp.docs[fn]
+	delete(gp.docs, fn)
+	gp.mu.Unlock()
+
+	if c != nil && open {
+		c.notify("textDocument/didClose", lsp.DidCloseTextDocumentParams{
+			TextDocument: lsp.TextDocumentIdentifier{URI: lsp.PathURI(fn)},
+		})
+	}
+}
+
+func (gp *Gopls) Reduce(mx *mg.Ctx) *mg.State {
+	c := gp.conn(mx)
+	if c == nil {
+		return mx.State
+	}
+
+	switch act := mx.Action.(type) {
+	case mg.QueryCompletions:
+		if !gp.NoCompletions {
+			return gp.completions(mx, c)
+		}
+	case mg.QueryTooltips:
+		if !gp.NoTooltips {
+			return gp.tooltips(mx, c, act)
+		}
+	case mg.QueryUserCmds:
+		if !gp.NoDefinition {
+			return mx.AddUserCmds(mg.UserCmd{
+				Title: "Gopls Definition",
+				Name:  "gopls.definition",
+				Desc:  "show declaration of selected identifier",
+			})
+		}
+	case mg.RunCmd:
+		if !gp.NoDefinition && (act.Name == "goto.definition" || act.Name == "gopls.definition") {
+			return mx.AddBuiltinCmds(mg.BuiltinCmd{Name: act.Name, Run: gp.runDef})
+		}
+	case mg.ViewSaved:
+		if _, err := gp.sync(mx, c); err == nil {
+			c.notify("textDocument/didSave", lsp.DidSaveTextDocumentParams{
+				TextDocument: lsp.TextDocumentIdentifier{URI: lsp.PathURI(mx.View.Filename())},
+			})
+		}
+	case mg.ViewActivated, mg.ViewModified, mg.ViewLoaded:
+		gp.sync(mx, c)
+	}
+	return mx.State
+}
+
+// conn returns the client connected to gopls, starting it in the background if necessary.
+// If gopls isn't ready, nil is returned.
+func (gp *Gopls) conn(mx *mg.Ctx) *goplsClient {
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+
+	if c := gp.client; c != nil {
+		err := c.closed()
+		if err == nil {
+			gp.addRoot(mx, c)
+			return c
+		}
+		mx.Log.Println("gopls: stopped:", err)
+		gp.client = nil
+		gp.docs = map[string]*goplsDoc{}
+		gp.roots = map[string]bool{}
+	}
+	if gp.starting || time.Since(gp.started) < goplsRestartDelay {
+		return nil
+	}
+	gp.starting = true
+	gp.started = time.Now()
+	go gp.start(mx)
+	return nil
+}
+
+// start starts gopls, and initializes it with the workspace folder of mx.View
+func (gp *Gopls) start(mx *mg.Ctx) {
+	c, err := gp.exec(mx)
+	if err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), goplsInitTimeout)
+		err = c.initialize(ctx, os.Getpid(), gp.root(mx))
+		cancel()
+		if err != nil {
+			c.close(err)
+		}
+	}
+
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+
+	gp.starting = false
+	if err != nil {
+		mx.Log.Println("gopls: cannot start:", err)
+		return
+	}
+	gp.client = c
+	gp.roots[gp.root(mx)] = true
+	mx.Log.Println("gopls: started")
+}
+
+// exec starts the gopls process, and returns a client connected to it
+func (gp *Gopls) exec(mx *mg.Ctx) (*goplsClient, error) {
+	name := gp.Path
+	if name == "" {
+		name = "gopls"
+	}
+	cmd := exec.Command(name, append([]string{"serve"}, gp.Args...)...)
+	cmd.Dir = mx.View.Dir()
+	cmd.Env = mx.Env.Environ()
+	if gp.Debug {
+		cmd.Stderr = mx.Log.Writer()
+	}
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	r, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	go cmd.Wait()
+
+	kill := func() {
+		w.Close()
+		cmd.Process.Kill()
+	}
+	return newGoplsClient(r, w, mx.Log, gp.onNote, kill), nil
+}
+
+// root returns the workspace folder of mx.View: its module root, or its directory
+func (gp *Gopls) root(mx *mg.Ctx) string {
+	dir := mx.View.Dir()
+	if nd := goutil.ModFileNd(mx, dir); nd != nil && nd.Parent() != nil {
+		return nd.Parent().Path()
+	}
+	return dir
+}
+
+// addRoot adds the workspace folder of mx.View to gopls, if it wasn't already added
+func (gp *Gopls) addRoot(mx *mg.Ctx, c *goplsClient) {
+	root := gp.root(mx)
+	if gp.roots[root] {
+		return
+	}
+	gp.roots[root] = true
+	c.notify("workspace/didChangeWorkspaceFolders", lsp.DidChangeWorkspaceFoldersParams{
+		Event: lsp.WorkspaceFoldersChangeEvent{
+			Added:   []lsp.WorkspaceFolder{{URI: lsp.PathURI(root), Name: filepath.Base(root)}},
+			Removed: []lsp.WorkspaceFolder{},
+		},
+	})
+}
+
+// sync sends the contents of mx.View to gopls, if they changed since they were last sent, and returns them
+func (gp *Gopls) sync(mx *mg.Ctx, c *goplsClient) ([]byte, error) {
+	v := mx.View
+	src, err := v.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	fn := v.Filename()
+	uri := lsp.PathURI(fn)
+
+	gp.mu.Lock()
+	defer gp.mu.Unlock()
+
+	doc := gp.docs[fn]
+	switch {
+	case doc == nil:
+		doc = &goplsDoc{version: 1, hash: v.Hash, src: src}
+		gp.docs[fn] = doc
+		return src, c.notify("textDocument/didOpen", lsp.DidOpenTextDocumentParams{
+			TextDocument: lsp.TextDocumentItem{URI: uri, LanguageID: "go", Version: doc.version, Text: string(src)},
+		})
+	case doc.hash == v.Hash && v.Hash != "":
+		return src, nil
+	default:
+		doc.version++
+		doc.hash = v.Hash
+		doc.src = src
+		return src, c.notify("textDocument/didChange", lsp.DidChangeTextDocumentParams{
+			TextDocument:   lsp.VersionedTextDocumentIdentifier{URI: uri, Version: doc.version},
+			ContentChanges: []lsp.TextDocumentContentChangeEvent{{Text: string(src)}},
+		})
+	}
+}
+
+// query syncs mx.View, then calls method with the position pos of the view's source, decoding its result into result
+func (gp *Gopls) query(ctx context.Context, mx *mg.Ctx, c *goplsClient, method string, pos func(src []byte) lsp.Position, result interface{}) error {
+	src, err := gp.sync(mx, c)
+	if err != nil {
+		return err
+	}
+	return c.call(ctx, method, lsp.TextDocumentPositionParams{
+		TextDocument: lsp.TextDocumentIdentifier{URI: lsp.PathURI(mx.View.Filename())},
+		Position:     pos(src),
+	}, result)
+}
+
+// timeout returns a context that's done when mx is, or gp.Timeout expires
+func (gp *Gopls) timeout(mx *mg.Ctx) (context.Context, context.CancelFunc) {
+	d := gp.Timeout
+	if d <= 0 {
+		d = goplsDefaultTimeout
+	}
+	return context.WithTimeout(mx, d)
+}
+
+func (gp *Gopls) completions(mx *mg.Ctx, c *goplsClient) *mg.State {
+	ctx, cancel := gp.timeout(mx)
+	defer cancel()
+
+	res := lsp.CompletionList{}
+	pos := func(src []byte) lsp.Position { return lspPos(src, mx.View.Pos) }
+	if err := gp.query(ctx, mx, c, "textDocument/completion", pos, &res); err != nil {
+		mx.Log.Println("gopls: completion failed:", err)
+		return mx.State
+	}
+
+	cl := make([]mg.Completion, 0, len(res.Items))
+	for _, it := range res.Items {
+		cl = append(cl, gp.completion(it))
+	}
+	return mx.State.AddCompletions(cl...)
+}
+
+func (gp *Gopls) completion(it lsp.CompletionItem) mg.Completion {
+	src := it.InsertText
+	if it.TextEdit != nil {
+		src = it.TextEdit.NewText
+	}
+	if src == "" {
+		src = it.Label
+	}
+	if it.InsertTextFormat != lsp.InsertTextFormatSnippet {
+		src = strings.NewReplacer(`\`, `\\`, `$`, `\$`).Replace(src)
+	}
+	query := it.FilterText
+	if query == "" {
+		query = it.Label
+	}
+	return mg.Completion{
+		Query: query,
+		Title: it.Detail,
+		Src:   src,
+		Tag:   gp.completionTag(it.Kind),
+	}
+}
+
+// completionTag returns the tag of LSP CompletionItemKind kind
+func (gp *Gopls) completionTag(kind int) mg.CompletionTag {
+	switch kind {
+	case 2, 3, 4: // Method, Function, Constructor
+		return mg.FunctionTag
+	case 5, 6, 10: // Field, Variable, Property
+		return mg.VariableTag
+	case 7, 8, 13, 22, 25: // Class, Interface, Enum, Struct, TypeParameter
+		return mg.TypeTag
+	case 9: // Module
+		return mg.PackageTag
+	case 12, 20, 21: // Value, EnumMember, Constant
+		return mg.ConstantTag
+	case 14, 15: // Keyword, Snippet
+		return mg.SnippetTag
+	default:
+		return mg.UnknownTag
+	}
+}
+
+func (gp *Gopls) tooltips(mx *mg.Ctx, c *goplsClient, qt mg.QueryTooltips) *mg.State {
+	ctx, cancel := gp.timeout(mx)
+	defer cancel()
+
+	res := lsp.Hover{}
+	pos := func(src []byte) lsp.Position { return lsp.ColPosition(string(src), qt.Row, qt.Col) }
+	if err := gp.query(ctx, mx, c, "textDocument/hover", pos, &res); err != nil {
+		mx.Log.Println("gopls: hover failed:", err)
+		return mx.State
+	}
+	if s := strings.TrimSpace(res.Contents.Value); s != "" {
+		return mx.State.AddTooltips(mg.Tooltip{Content: s})
+	}
+	return mx.State
+}
+
+func (gp *Gopls) runDef(cx *mg.CmdCtx) *mg.State {
+	c := gp.conn(cx.Ctx)
+	cx.Jobs.Submit("gopls definition", func(jx *mg.JobCtx) []mg.Action {
+		defer cx.Output.Close()
+
+		if c == nil {
+			fmt.Fprintln(cx.Output, "gopls isn't ready")
+			return nil
+		}
+		if err := gp.definition(jx, cx, c); err != nil {
+			fmt.Fprintln(cx.Output, "Error:", err)
+		}
+		return nil
+	})
+	return cx.State
+}
+
+func (gp *Gopls) definition(jx *mg.JobCtx, cx *mg.CmdCtx, c *goplsClient) error {
+	ctx, cancel := context.WithTimeout(jx, goplsInitTimeout)
+	defer cancel()
+
+	var locs lsp.Locations
+	pos := func(src []byte) lsp.Position { return lspPos(src, cx.View.Pos) }
+	if err := gp.query(ctx, cx.Ctx, c, "textDocument/definition", pos, &locs); err != nil {
+		return err
+	}
+	if len(locs) == 0 {
+		return fmt.Errorf("no definition found")
+	}
+
+	loc := locs[0]
+	fn := lsp.URIPath(loc.URI)
+	row, col := lspRowCol(gp.src(fn), loc.Range.Start)
+	cx.Store.DispatchPriority(mg.Activate{
+		Path: fn,
+		Row:  row,
+		Col:  col,
+	}, mg.PriorityInteractive)
+	return nil
+}
+
+// src returns the source of file fn: the contents last sent to gopls, if it's open, or the contents of the file
+func (gp *Gopls) src(fn string) []byte {
+	gp.mu.Lock()
+	doc := gp.docs[fn]
+	gp.mu.Unlock()
+
+	if doc != nil {
+		return doc.src
+	}
+	src, _ := ioutil.ReadFile(fn)
+	return src
+}
+
+// onNote handles the notifications sent by gopls
+func (gp *Gopls) onNote(method string, params json.RawMessage) {
+	if method != "textDocument/publishDiagnostics" || gp.NoDiagnostics {
+		return
+	}
+	p := lsp.PublishDiagnosticsParams{}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return
+	}
+	fn := lsp.URIPath(p.URI)
+	if fn == "" {
+		return
+	}
+
+	src := gp.src(fn)
+	issues := make(mg.IssueSet, 0, len(p.Diagnostics))
+	for _, d := range p.Diagnostics {
+		row, col := lspRowCol(src, d.Range.Start)
+		isu := mg.Issue{
+			Path:    fn,
+			Row:     row,
+			Col:     col,
+			Tag:     mg.Error,
+			Label:   "Go/gopls",
+			Message: d.Message,
+		}
+		if d.Source != "" && d.Source != "compiler" {
+			isu.Label += "/" + d.Source
+		}
+		switch d.Severity {
+		case lsp.SeverityError:
+		case lsp.SeverityWarning:
+			isu.Tag = mg.Warning
+		default:
+			isu.Tag = mg.Notice
+		}
+		if end, endCol := lspRowCol(src, d.Range.End); end == row {
+			isu.End = endCol
+		}
+		issues = append(issues, isu)
+	}
+
+	gp.mu.Lock()
+	sto := gp.sto
+	gp.mu.Unlock()
+
+	if sto != nil {
+		sto.Dispatch(mg.StoreIssues{
+			IssueKey: mg.IssueKey{Key: goplsIssueKey{Path: fn}, Dir: filepath.Dir(fn)},
+			Issues:   issues,
+		})
+	}
+}
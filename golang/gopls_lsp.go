@@ -0,0 +1,300 @@
+package golang
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"margo.sh/mg"
+	"margo.sh/mg/lsp"
+	"margo.sh/mg/lsp/jsonrpc"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// goplsWriteTimeout is the amount of time to wait for gopls to read a message, before giving up on it
+const goplsWriteTimeout = 5 * time.Second
+
+// lspPos returns the LSP position of the byte offset off in src.
+//
+// LSP positions count UTF-16 code units, margo offsets count bytes.
+func lspPos(src []byte, off int) lsp.Position {
+	if off > len(src) {
+		off = len(src)
+	}
+	line, start := 0, 0
+	for i, c := range src[:off] {
+		if c == '\n' {
+			line++
+			start = i + 1
+		}
+	}
+	units := 0
+	for _, r := range string(src[start:off]) {
+		units += utf16.RuneLen(r)
+	}
+	return lsp.Position{Line: line, Character: units}
+}
+
+// lspRowCol returns the row, and byte column, of the LSP position pos in src.
+// If src is empty, the character offset is returned as the column.
+func lspRowCol(src []byte, pos lsp.Position) (row, col int) {
+	if len(src) == 0 {
+		return pos.Line, pos.Character
+	}
+	s := src
+	for i := 0; i < pos.Line; i++ {
+		j := strings.IndexByte(string(s), '\n')
+		if j < 0 {
+			return pos.Line, pos.Character
+		}
+		s = s[j+1:]
+	}
+	units := 0
+	for units < pos.Character && col < len(s) && s[col] != '\n' {
+		r, n := utf8.DecodeRune(s[col:])
+		units += utf16.RuneLen(r)
+		col += n
+	}
+	return pos.Line, col
+}
+
+// goplsClient is a client of a language server, connected to it through r and w
+type goplsClient struct {
+	log    *mg.Logger
+	conn   *jsonrpc.Conn
+	onNote func(method string, params json.RawMessage)
+	kill   func()
+
+	mu      sync.Mutex
+	id      int
+	pending map[string]chan *jsonrpc.Message
+
+	out  chan *jsonrpc.Message
+	done chan struct{}
+	once sync.Once
+	err  error
+}
+
+// newGoplsClient returns a client that reads messages from r and writes them to w.
+// onNote is called, in the order they're received, with the notifications sent by the server.
+// kill, if not nil, is called when the client is closed e.g. to kill the server's process.
+func newGoplsClient(r io.Reader, w io.Writer, log *mg.Logger, onNote func(method string, params json.RawMessage), kill func()) *goplsClient {
+	c := &goplsClient{
+		log:     log,
+		conn:    jsonrpc.NewConn(r, w),
+		onNote:  onNote,
+		kill:    kill,
+		pending: map[string]chan *jsonrpc.Message{},
+		out:     make(chan *jsonrpc.Message, 64),
+		done:    make(chan struct{}),
+	}
+	go c.readLoop()
+	go c.writeLoop()
+	return c
+}
+
+// close stops the client, and fails the calls in progress with err
+func (c *goplsClient) close(err error) {
+	c.once.Do(func() {
+		c.mu.Lock()
+		c.err = err
+		c.mu.Unlock()
+
+		close(c.done)
+		if c.kill != nil {
+			c.kill()
+		}
+	})
+}
+
+// closed returns the reason the client was closed, or nil if it wasn't
+func (c *goplsClient) closed() error {
+	select {
+	case <-c.done:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.err
+	default:
+		return nil
+	}
+}
+
+func (c *goplsClient) readLoop() {
+	for {
+		msg, err := c.conn.Read()
+		if err != nil {
+			c.close(fmt.Errorf("gopls: cannot read message: %s", err))
+			return
+		}
+		switch {
+		case msg.IsRequest():
+			c.reply(msg)
+		case msg.ID != nil:
+			c.mu.Lock()
+			ch := c.pending[string(*msg.ID)]
+			delete(c.pending, string(*msg.ID))
+			c.mu.Unlock()
+			if ch != nil {
+				ch <- msg
+			}
+		case msg.Method != "" && c.onNote != nil:
+			c.onNote(msg.Method, msg.Params)
+		}
+	}
+}
+
+func (c *goplsClient) writeLoop() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case msg := <-c.out:
+			if err := c.conn.Write(msg); err != nil {
+				c.close(fmt.Errorf("gopls: cannot write message: %s", err))
+				return
+			}
+		}
+	}
+}
+
+// write queues msg to be written to the server
+func (c *goplsClient) write(msg *jsonrpc.Message) error {
+	select {
+	case c.out <- msg:
+		return nil
+	case <-c.done:
+		return c.closed()
+	case <-time.After(goplsWriteTimeout):
+		err := fmt.Errorf("gopls: didn't read messages after %s", goplsWriteTimeout)
+		c.close(err)
+		return err
+	}
+}
+
+// reply answers the request req sent by the server.
+// Only workspace/configuration expects a meaningful result: the default configuration is used.
+func (c *goplsClient) reply(req *jsonrpc.Message) {
+	var result interface{}
+	if req.Method == "workspace/configuration" {
+		p := struct{ Items []json.RawMessage }{}
+		json.Unmarshal(req.Params, &p)
+		result = make([]interface{}, len(p.Items))
+	}
+	res, err := jsonrpc.NewResponse(req, result, nil)
+	if err == nil {
+		err = c.write(res)
+	}
+	if err != nil {
+		c.log.Println("gopls: cannot reply to", req.Method, err)
+	}
+}
+
+// notify sends the notification method with params
+func (c *goplsClient) notify(method string, params interface{}) error {
+	msg, err := jsonrpc.NewMessage(nil, method, params)
+	if err != nil {
+		return err
+	}
+	return c.write(msg)
+}
+
+// call sends the request method with params, and decodes its result into result, if it's not nil.
+// If ctx is done before the response is received, the request is cancelled and ctx.Err() is returned.
+func (c *goplsClient) call(ctx context.Context, method string, params, result interface{}) error {
+	c.mu.Lock()
+	c.id++
+	id := json.RawMessage(strconv.Itoa(c.id))
+	ch := make(chan *jsonrpc.Message, 1)
+	c.pending[string(id)] = ch
+	c.mu.Unlock()
+
+	msg, err := jsonrpc.NewMessage(&id, method, params)
+	if err == nil {
+		err = c.write(msg)
+	}
+	if err != nil {
+		c.forget(id)
+		return err
+	}
+
+	select {
+	case res := <-ch:
+		if res.Error != nil {
+			return res.Error
+		}
+		if result == nil || len(res.Result) == 0 {
+			return nil
+		}
+		return json.Unmarshal(res.Result, result)
+	case <-c.done:
+		c.forget(id)
+		return c.closed()
+	case <-ctx.Done():
+		c.forget(id)
+		c.notify("$/cancelRequest", struct {
+			ID json.RawMessage `json:"id"`
+		}{ID: id})
+		return ctx.Err()
+	}
+}
+
+// forget stops waiting for the response to the request id
+func (c *goplsClient) forget(id json.RawMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.pending, string(id))
+}
+
+// initialize performs the initialization handshake, for the workspace folder root, if it's not empty
+func (c *goplsClient) initialize(ctx context.Context, pid int, root string) error {
+	params := map[string]interface{}{
+		"processId":  pid,
+		"clientInfo": map[string]string{"name": "margo"},
+		"rootUri":    nil,
+		"capabilities": map[string]interface{}{
+			"textDocument": map[string]interface{}{
+				"completion": map[string]interface{}{
+					"completionItem": map[string]interface{}{"snippetSupport": true},
+				},
+				"hover": map[string]interface{}{
+					"contentFormat": []string{"markdown", "plaintext"},
+				},
+				"publishDiagnostics": map[string]interface{}{},
+			},
+			"workspace": map[string]interface{}{
+				"configuration":    true,
+				"workspaceFolders": true,
+			},
+		},
+	}
+	if root != "" {
+		params["rootUri"] = lsp.PathURI(root)
+		params["workspaceFolders"] = []lsp.WorkspaceFolder{{URI: lsp.PathURI(root), Name: filepath.Base(root)}}
+	}
+	if err := c.call(ctx, "initialize", params, nil); err != nil {
+		return err
+	}
+	return c.notify("initialized", struct{}{})
+}
+
+// shutdown asks the server to exit, and closes the client
+func (c *goplsClient) shutdown(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if c.call(ctx, "shutdown", nil, nil) == nil {
+		c.notify("exit", nil)
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+		}
+	}
+	c.close(fmt.Errorf("gopls: the client was shut down"))
+}
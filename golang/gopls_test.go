@@ -0,0 +1,111 @@
+package golang
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"margo.sh/mg"
+	"margo.sh/mg/lsp"
+	"margo.sh/mg/lsp/jsonrpc"
+	"testing"
+	"time"
+)
+
+// fakeGopls is a language server that answers requests using handle
+func fakeGopls(r io.Reader, w io.Writer, handle func(msg *jsonrpc.Message) (result interface{}, notes []*jsonrpc.Message)) {
+	conn := jsonrpc.NewConn(r, w)
+	for {
+		msg, err := conn.Read()
+		if err != nil {
+			return
+		}
+		res, notes := handle(msg)
+		for _, note := range notes {
+			conn.Write(note)
+		}
+		if msg.ID != nil {
+			conn.Reply(msg, res, nil)
+		}
+	}
+}
+
+func TestGoplsClient(t *testing.T) {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	defer sw.Close()
+	defer cw.Close()
+
+	go fakeGopls(sr, sw, func(msg *jsonrpc.Message) (interface{}, []*jsonrpc.Message) {
+		switch msg.Method {
+		case "initialize":
+			return map[string]interface{}{"capabilities": map[string]interface{}{}}, nil
+		case "textDocument/didOpen":
+			p, _ := json.Marshal(lsp.PublishDiagnosticsParams{
+				URI: lsp.PathURI("/src/π.go"),
+				Diagnostics: []lsp.Diagnostic{{
+					Range:   lsp.Range{Start: lsp.Position{Line: 1, Character: 3}},
+					Message: "undefined: x",
+				}},
+			})
+			return nil, []*jsonrpc.Message{{Method: "textDocument/publishDiagnostics", Params: p}}
+		case "textDocument/hover":
+			// the deprecated MarkedString form of the contents
+			return map[string]interface{}{"contents": "func F()"}, nil
+		case "textDocument/definition":
+			return []lsp.Location{{URI: lsp.PathURI("/src/π.go")}}, nil
+		}
+		return nil, nil
+	})
+
+	notes := make(chan lsp.PublishDiagnosticsParams, 1)
+	onNote := func(method string, params json.RawMessage) {
+		p := lsp.PublishDiagnosticsParams{}
+		json.Unmarshal(params, &p)
+		notes <- p
+	}
+	c := newGoplsClient(cr, cw, mg.NewLogger(io.Discard), onNote, nil)
+	defer c.close(nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := c.initialize(ctx, 1, "/src"); err != nil {
+		t.Fatalf("initialize failed: %s", err)
+	}
+
+	hover := lsp.Hover{}
+	if err := c.call(ctx, "textDocument/hover", nil, &hover); err != nil || hover.Contents.Value != "func F()" {
+		t.Fatalf("hover returned (%q, %v), expected (%q, nil)", hover.Contents.Value, err, "func F()")
+	}
+
+	var locs lsp.Locations
+	if err := c.call(ctx, "textDocument/definition", nil, &locs); err != nil || len(locs) != 1 || lsp.URIPath(locs[0].URI) != "/src/π.go" {
+		t.Fatalf("definition returned (%v, %v), expected one location in /src/π.go", locs, err)
+	}
+
+	c.notify("textDocument/didOpen", nil)
+	select {
+	case p := <-notes:
+		if lsp.URIPath(p.URI) != "/src/π.go" || len(p.Diagnostics) != 1 {
+			t.Fatalf("publishDiagnostics received %+v, expected one diagnostic for /src/π.go", p)
+		}
+	case <-ctx.Done():
+		t.Fatal("publishDiagnostics wasn't received")
+	}
+}
+
+func TestGoplsPositions(t *testing.T) {
+	src := []byte("package p\nvar π, 𝛑, x = 1, 2, 3\n")
+	off := len("package p\nvar π, 𝛑, ")
+	pos := lspPos(src, off)
+	if want := (lsp.Position{Line: 1, Character: 11}); pos != want {
+		t.Errorf("lspPos(%d) = %+v, expected %+v", off, pos, want)
+	}
+	row, col := lspRowCol(src, pos)
+	if want := off - len("package p\n"); row != 1 || col != want {
+		t.Errorf("lspRowCol(%+v) = (%d, %d), expected (1, %d)", pos, row, col, want)
+	}
+	if got := lsp.ColPosition(string(src), 1, 10); got != pos {
+		t.Errorf("lsp.ColPosition(1, 10) = %+v, expected %+v", got, pos)
+	}
+}
@@ -161,6 +161,12 @@ func (tc *TestCmds) processIdent(cmds map[string]mg.UserCmdList, id *ast.Ident)
 }
 
 func (tc *TestCmds) splitName(nm string) (name, pfx, sfx string, ok bool) {
+	return splitTestFuncName(nm)
+}
+
+// splitTestFuncName splits the name nm of a Test, Benchmark or Example function into its prefix e.g. Test, and suffix.
+// ok is false if nm isn't the name of one of those functions.
+func splitTestFuncName(nm string) (name, pfx, sfx string, ok bool) {
 	if nm == "" {
 		return "", "", "", false
 	}
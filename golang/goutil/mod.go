@@ -11,6 +11,14 @@ const (
 	ModEnvVar = "GO111MODULE"
 )
 
+// ModGraphChanged is the event published, using mg.Store.Publish, when a go.mod or go.sum file is saved.
+//
+// Reducers that cache data derived from the module graph e.g. imported packages, should invalidate it.
+type ModGraphChanged struct {
+	// Dir is the directory of the module whose graph changed
+	Dir string
+}
+
 // ModEnabled returns true of Go modules are enabled in srcDir
 func ModEnabled(mx *mg.Ctx, srcDir string) bool {
 	// - If on Go <= go1.12 and inside GOPATH — defaults to old 1.10 behavior (ignoring modules)
@@ -30,7 +38,7 @@ func ModEnabled(mx *mg.Ctx, srcDir string) bool {
 	bctx := BuildContext(mx)
 	type K struct{ SrcDirKey }
 	k := K{MakeSrcDirKey(bctx, srcDir)}
-	if v, ok := mx.Get(k).(bool); ok {
+	if v, ok := mg.StoreValue[bool](mx, k); ok {
 		return v
 	}
 
@@ -53,10 +61,8 @@ func ModFileNd(mx *mg.Ctx, srcDir string) *vfs.Node {
 	bctx := BuildContext(mx)
 	type K struct{ SrcDirKey }
 	k := K{MakeSrcDirKey(bctx, srcDir)}
-	if v, ok := mx.Get(k).(*vfs.Node); ok {
-		return v
-	}
-	nd, _, _ := mx.VFS.Poke(k.SrcDir).Locate("go.mod")
-	mx.Put(k, nd)
-	return nd
+	return mg.Memo(mx, k, func() *vfs.Node {
+		nd, _, _ := mx.VFS.Poke(k.SrcDir).Locate("go.mod")
+		return nd
+	})
 }
@@ -55,7 +55,7 @@ func ParseFileWithMode(mx *mg.Ctx, fn string, src []byte, mode parser.Mode) *Par
 		mode parser.Mode
 	}
 	k := key{hash: mg.SrcHash(src), mode: mode}
-	if pf, ok := mx.Get(k).(*ParsedFile); ok {
+	if pf, ok := mg.StoreValue[*ParsedFile](mx, k); ok {
 		return pf
 	}
 
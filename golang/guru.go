@@ -87,13 +87,15 @@ func (g *Guru) runCmd(mx *mg.Ctx, rc mg.RunCmd) *mg.State {
 }
 
 func (g *Guru) runDef(cx *mg.CmdCtx) *mg.State {
-	go g.definition(cx)
+	cx.Jobs.Submit("guru definition", func(*mg.JobCtx) []mg.Action {
+		g.definition(cx)
+		return nil
+	})
 	return cx.State
 }
 
 func (g *Guru) definition(bx *mg.CmdCtx) {
 	defer bx.Output.Close()
-	defer bx.Begin(mg.Task{Title: "guru definition", ShowNow: true}).Done()
 
 	v := bx.View
 	dir := v.Dir()
@@ -159,11 +161,11 @@ func (g *Guru) definition(bx *mg.CmdCtx) {
 	if v.Path == "" && filepath.Base(fn) == v.Name {
 		fn = v.Name
 	}
-	bx.Store.Dispatch(mg.Activate{
+	bx.Store.DispatchPriority(mg.Activate{
 		Path: fn,
 		Row:  n(m[2]),
 		Col:  n(m[3]),
-	})
+	}, mg.PriorityInteractive)
 }
 
 func (g *Guru) wasmTags(mx *mg.Ctx) string {
@@ -6,6 +6,7 @@ import (
 	"go/parser"
 	"go/printer"
 	"go/token"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -243,3 +244,122 @@ func parseImportsOnly(fn string, src []byte) (*token.FileSet, *ast.File, error)
 	af, err := parser.ParseFile(fset, fn, src, parser.ParseComments|parser.ImportsOnly)
 	return fset, af, err
 }
+
+// importGroup returns the index, in groups, of the group of the import path p. See GoImporter.Groups
+func importGroup(groups []string, p string) int {
+	std := !strings.Contains(strings.SplitN(p, "/", 2)[0], ".")
+	dflt, stdGrp, grp, match := len(groups), -1, -1, ""
+	for i, g := range groups {
+		switch {
+		case g == "default":
+			dflt = i
+		case g == "std":
+			stdGrp = i
+		case strings.HasPrefix(p, g) && len(g) > len(match):
+			grp, match = i, g
+		}
+	}
+	switch {
+	case grp >= 0:
+		return grp
+	case std && stdGrp >= 0:
+		return stdGrp
+	}
+	return dflt
+}
+
+// regroupImports returns src with the imports, in each parenthesized import declaration, grouped in the order of groups.
+// See GoImporter.Groups
+//
+// Comments above and after each import are moved with it.
+// If a declaration holds comments that aren't attached to an import, or several imports on a line, it's left unchanged.
+func regroupImports(fn string, src []byte, groups []string) ([]byte, error) {
+	if len(groups) == 0 {
+		return src, nil
+	}
+	fset, af, err := parseImportsOnly(fn, src)
+	if err != nil {
+		return nil, err
+	}
+	tf := fset.File(af.Pos())
+
+	type entry struct {
+		path string
+		text []byte
+	}
+	type edit struct {
+		start, end int
+		text       []byte
+	}
+	var edits []edit
+	for _, decl := range af.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || gd.Tok != token.IMPORT || !gd.Lparen.IsValid() || len(gd.Specs) < 2 {
+			continue
+		}
+
+		lines := map[int]bool{}
+		attached := map[*ast.CommentGroup]bool{}
+		entries := make([]entry, 0, len(gd.Specs))
+		ok = true
+		for _, spec := range gd.Specs {
+			is := spec.(*ast.ImportSpec)
+			start, end := is.Pos(), is.End()
+			if is.Doc != nil {
+				start = is.Doc.Pos()
+				attached[is.Doc] = true
+			}
+			if is.Comment != nil {
+				end = is.Comment.End()
+				attached[is.Comment] = true
+			}
+			line := tf.Line(is.Pos())
+			if lines[line] {
+				ok = false
+				break
+			}
+			lines[line] = true
+
+			s := tf.LineStart(tf.Line(start))
+			e := tf.Offset(end)
+			entries = append(entries, entry{path: unquote(is.Path.Value), text: src[tf.Offset(s):e]})
+		}
+		for _, cg := range af.Comments {
+			if cg.Pos() > gd.Lparen && cg.End() < gd.Rparen && !attached[cg] {
+				ok = false
+			}
+		}
+		if !ok {
+			continue
+		}
+
+		sort.SliceStable(entries, func(i, j int) bool {
+			gi, gj := importGroup(groups, entries[i].path), importGroup(groups, entries[j].path)
+			if gi != gj {
+				return gi < gj
+			}
+			return entries[i].path < entries[j].path
+		})
+		buf := &bytes.Buffer{}
+		buf.WriteString("(\n")
+		for i, e := range entries {
+			if i > 0 && importGroup(groups, entries[i-1].path) != importGroup(groups, e.path) {
+				buf.WriteByte('\n')
+			}
+			buf.Write(e.text)
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(")")
+		edits = append(edits, edit{start: tf.Offset(gd.Lparen), end: tf.Offset(gd.Rparen) + 1, text: buf.Bytes()})
+	}
+
+	out := &bytes.Buffer{}
+	pos := 0
+	for _, e := range edits {
+		out.Write(src[pos:e.start])
+		out.Write(e.text)
+		pos = e.end
+	}
+	out.Write(src[pos:])
+	return out.Bytes(), nil
+}
@@ -0,0 +1,52 @@
+package golang
+
+import (
+	"testing"
+)
+
+func TestRegroupImports(t *testing.T) {
+	src := `package p
+
+import (
+	"fmt"
+	"os"
+
+	"example.com/corp/team/x"
+	"github.com/pkg/errors"
+	// y does things
+	"example.com/corp/y" // y
+	z "example.com/corp/z"
+)
+
+func f() {}
+`
+	want := `package p
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+
+	// y does things
+	"example.com/corp/y" // y
+	z "example.com/corp/z"
+
+	"example.com/corp/team/x"
+)
+
+func f() {}
+`
+	got, err := regroupImports("p.go", []byte(src), []string{"std", "default", "example.com/corp", "example.com/corp/team"})
+	if err != nil {
+		t.Fatalf("regroupImports failed: %s", err)
+	}
+	if string(got) != want {
+		t.Errorf("regroupImports returned:\n%s\nexpected:\n%s", got, want)
+	}
+
+	src = "package p\n\nimport (\n\t\"os\"\n\t// free-floating\n\n\t\"fmt\"\n)\n"
+	if got, _ := regroupImports("p.go", []byte(src), []string{"std"}); string(got) != src {
+		t.Errorf("regroupImports changed a declaration with a free-floating comment:\n%s", got)
+	}
+}
@@ -71,6 +71,9 @@ type marGocodeCtl struct {
 	logs   *log.Logger
 
 	plst pkglst.Cache
+	pidx pkgIndexer
+
+	unlisten func()
 }
 
 func (mgc *marGocodeCtl) importerFactories() (newDefaultImporter, newFallbackImporter importerFactory, srcMode bool) {
@@ -88,8 +91,17 @@ func (mgc *marGocodeCtl) importerFactories() (newDefaultImporter, newFallbackImp
 	}
 }
 
-// importPathByName returns an import path whose pkg's name is pkgName
-func (mgc *marGocodeCtl) importPathByName(pkgName, srcDir string) string {
+// importPathByName returns an import path whose pkg's name is pkgName.
+//
+// In a module whose package index was built, its packages are preferred,
+// with those exporting a name starting with sym (if it's not empty) chosen first.
+func (mgc *marGocodeCtl) importPathByName(mx *mg.Ctx, pkgName, sym, srcDir string) string {
+	if idx := mgc.pidx.lookup(mx, srcDir); idx != nil {
+		if s := idx.importPath(pkgName, sym, srcDir); s != "" {
+			return s
+		}
+	}
+
 	pkl := mgc.plst.View().ByName[pkgName]
 	switch len(pkl) {
 	case 0:
@@ -265,9 +277,23 @@ func (mgc *marGocodeCtl) RCond(mx *mg.Ctx) bool {
 }
 
 func (mgc *marGocodeCtl) RMount(mx *mg.Ctx) {
+	mgc.pidx.mount(mx.Store)
+	mgc.unlisten = mg.Listen(mx.Store, mgc.modGraphChanged)
 	mgc.initPlst(mx)
 }
 
+func (mgc *marGocodeCtl) RUnmount(mx *mg.Ctx) {
+	mgc.unlisten()
+}
+
+// modGraphChanged drops the packages that aren't in the stdlib from the cache,
+// because the versions of their dependencies might have changed, and the module's package index
+func (mgc *marGocodeCtl) modGraphChanged(ev goutil.ModGraphChanged) {
+	mgc.pidx.forget(ev.Dir)
+	ents := mgc.pkgs.pruneFunc(func(e mgcCacheEnt) bool { return !e.Key.Std })
+	mgc.dbgf("%s: go.mod changed, pruned %d entries\n", ev.Dir, len(ents))
+}
+
 func (mgc *marGocodeCtl) Reduce(mx *mg.Ctx) *mg.State {
 	switch mx.Action.(type) {
 	case mg.RunCmd:
@@ -277,16 +303,17 @@ func (mgc *marGocodeCtl) Reduce(mx *mg.Ctx) *mg.State {
 		// which results in an updated package.a file
 		mgc.mxQ.Put(mx)
 	}
+	if _, ok := mx.Action.(mg.ViewActivated); ok && !mgc.cfg().NoPreloading {
+		mgc.pidx.warm(mx)
+	}
 
 	return mx.State
 }
 
-func (mgc *marGocodeCtl) scanVFS(mx *mg.Ctx, rootName, rootDir string) {
+func (mgc *marGocodeCtl) scanVFS(jx *mg.JobCtx, mx *mg.Ctx, rootDir string) {
 	// TODO: (eventually) move this function into plst.Scan
 	// for now, the extra scan at the end is fast enough to not be worth the complexity
 	dir := filepath.Join(rootDir, "src")
-	tsk := mg.Task{Title: "VFS.Scan " + rootName + " ( " + mgutil.ShortFn(rootDir, mx.Env) + " )"}
-	defer mx.Begin(tsk).Done()
 
 	mu := sync.Mutex{}
 	pkgs := 0
@@ -297,6 +324,9 @@ func (mgc *marGocodeCtl) scanVFS(mx *mg.Ctx, rootName, rootDir string) {
 		}
 		mu.Lock()
 		pkgs++
+		if pkgs%100 == 0 {
+			jx.Progress("%d packages", pkgs)
+		}
 		mu.Unlock()
 	}
 	start := time.Now()
@@ -322,7 +352,7 @@ func (mgc *marGocodeCtl) scanVFS(mx *mg.Ctx, rootName, rootDir string) {
 	wg.Wait()
 	mgc.plst.Scan(mx, dir)
 	dur := mgpf.Since(start)
-	mx.Log.Printf("%s: %d packages preloaded in %s\n", tsk.Title, pkgs, dur)
+	mx.Log.Printf("%s: %d packages preloaded in %s\n", jx.Job.Name, pkgs, dur)
 }
 
 func (mgc *marGocodeCtl) initPlst(mx *mg.Ctx) {
@@ -334,9 +364,16 @@ func (mgc *marGocodeCtl) initPlst(mx *mg.Ctx) {
 		}),
 	))
 
-	go mgc.scanVFS(mx, "GOROOT", bctx.GOROOT)
+	scan := func(rootName, rootDir string) {
+		title := "VFS.Scan " + rootName + " ( " + mgutil.ShortFn(rootDir, mx.Env) + " )"
+		mx.Jobs.Submit(title, func(jx *mg.JobCtx) []mg.Action {
+			mgc.scanVFS(jx, mx, rootDir)
+			return nil
+		})
+	}
+	scan("GOROOT", bctx.GOROOT)
 	for _, root := range PathList(bctx.GOPATH) {
-		go mgc.scanVFS(mx, "GOPATH", root)
+		scan("GOPATH", root)
 	}
 }
 
@@ -579,6 +616,8 @@ type MarGocodeCtl struct {
 	AddUnimportedPackages bool
 
 	// Don't preload packages to speed up auto-completion, etc.
+	// This also disables building the index of the packages of the current module,
+	// used to find the packages imported by unimported-package completion and import path completion
 	NoPreloading bool
 
 	// Don't propose builtin types and functions
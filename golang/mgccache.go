@@ -70,6 +70,18 @@ func (mc *mgcCache) del(k mgcCacheKey) {
 }
 
 func (mc *mgcCache) prune(pats ...*regexp.Regexp) []mgcCacheEnt {
+	return mc.pruneFunc(func(e mgcCacheEnt) bool {
+		for _, pat := range pats {
+			if pat.MatchString(e.Key.Path) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// pruneFunc removes the entries for which f returns true, and returns them
+func (mc *mgcCache) pruneFunc(f func(mgcCacheEnt) bool) []mgcCacheEnt {
 	ents := []mgcCacheEnt{}
 	defer func() {
 		for _, e := range ents {
@@ -81,11 +93,9 @@ func (mc *mgcCache) prune(pats ...*regexp.Regexp) []mgcCacheEnt {
 	defer mc.Unlock()
 
 	for _, e := range mc.m {
-		for _, pat := range pats {
-			if pat.MatchString(e.Key.Path) {
-				ents = append(ents, e)
-				delete(mc.m, e.Key)
-			}
+		if f(e) {
+			ents = append(ents, e)
+			delete(mc.m, e.Key)
 		}
 	}
 
@@ -0,0 +1,24 @@
+package golang
+
+import (
+	"margo.sh/golang/goutil"
+	"margo.sh/mg"
+)
+
+func init() {
+	mg.DefaultReducers.Before(&modWatcher{})
+}
+
+// modWatcher publishes goutil.ModGraphChanged when a go.mod or go.sum file is saved
+type modWatcher struct {
+	mg.ReducerType
+}
+
+func (mw *modWatcher) RCond(mx *mg.Ctx) bool {
+	return mx.ActionIs(mg.ViewSaved{}) && mx.LangIs(mg.GoMod, mg.GoSum)
+}
+
+func (mw *modWatcher) Reduce(mx *mg.Ctx) *mg.State {
+	mx.Store.Publish(goutil.ModGraphChanged{Dir: mx.View.Dir()})
+	return mx.State
+}
@@ -0,0 +1,312 @@
+package golang
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"margo.sh/golang/gopkg"
+	"margo.sh/golang/goutil"
+	"margo.sh/mg"
+	"margo.sh/mgutil"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// pkgIndexKVName is the name of the Store.DiskKV in which module package indexes are saved
+	pkgIndexKVName = "golang-pkg-index"
+)
+
+func init() {
+	gob.Register(&pkgIndex{})
+}
+
+// pkgIndex lists the packages that can be imported in a module:
+// those of the standard library, of the module itself, and of its dependencies
+type pkgIndex struct {
+	// Pkgs is the list of packages, sorted by import path
+	Pkgs []pkgIndexEnt
+}
+
+// pkgIndexEnt describes a package in a pkgIndex
+type pkgIndexEnt struct {
+	gopkg.Pkg
+
+	// Exports is the sorted list of the package's exported top-level names
+	Exports []string
+}
+
+// exports reports whether the package exports a name starting with pfx
+func (ent *pkgIndexEnt) exports(pfx string) bool {
+	i := sort.SearchStrings(ent.Exports, pfx)
+	return i < len(ent.Exports) && strings.HasPrefix(ent.Exports[i], pfx)
+}
+
+// importable returns the packages that can be imported by the package in srcDir
+func (idx *pkgIndex) importable(srcDir string) []*pkgIndexEnt {
+	l := make([]*pkgIndexEnt, 0, len(idx.Pkgs))
+	for i := range idx.Pkgs {
+		if ent := &idx.Pkgs[i]; ent.Importable(srcDir) {
+			l = append(l, ent)
+		}
+	}
+	return l
+}
+
+// importPath returns the import path of a package named pkgName that can be imported by the package in srcDir,
+// or "" if there's none.
+//
+// If several packages are named pkgName, those that export a name starting with sym (if it's not empty)
+// are preferred, followed by those in the standard library.
+func (idx *pkgIndex) importPath(pkgName, sym, srcDir string) string {
+	var best *pkgIndexEnt
+	bestScore := -1
+	for i := range idx.Pkgs {
+		ent := &idx.Pkgs[i]
+		if ent.Name != pkgName || !ent.Importable(srcDir) {
+			continue
+		}
+		score := 0
+		if sym != "" && ent.exports(sym) {
+			score += 2
+		}
+		if ent.Goroot {
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = ent, score
+		}
+	}
+	if best == nil {
+		return ""
+	}
+	return best.ImportPath
+}
+
+// pkgIndexKey is the key of the pkgIndex of the module in Dir, whose go.mod and go.sum files hash to Hash
+type pkgIndexKey struct {
+	Dir  string
+	Hash string
+}
+
+// pkgIndexer builds and caches the pkgIndex of modules.
+//
+// An index is built in the background, by a job, when a view in the module is activated,
+// and is saved in Store.DiskKV so it's not rebuilt when the agent restarts.
+// It's rebuilt when the module's go.mod or go.sum file changes,
+// packages added to the module itself aren't listed until then.
+type pkgIndexer struct {
+	mu       sync.Mutex
+	kv       *mg.KVDisk
+	keys     map[string]pkgIndexKey
+	building map[pkgIndexKey]bool
+}
+
+// mount initializes the indexer, using sto to save the indexes
+func (pi *pkgIndexer) mount(sto *mg.Store) {
+	pi.mu.Lock()
+	defer pi.mu.Unlock()
+
+	pi.kv = sto.DiskKV(pkgIndexKVName)
+	pi.keys = map[string]pkgIndexKey{}
+	pi.building = map[pkgIndexKey]bool{}
+}
+
+// key returns the key of the index of the module containing srcDir.
+// ok is false if srcDir isn't in a module, or modules are disabled.
+func (pi *pkgIndexer) key(mx *mg.Ctx, srcDir string) (k pkgIndexKey, ok bool) {
+	if !filepath.IsAbs(srcDir) || !goutil.ModEnabled(mx, srcDir) {
+		return k, false
+	}
+	nd := goutil.ModFileNd(mx, srcDir)
+	if nd == nil {
+		return k, false
+	}
+	dir := nd.Parent().Path()
+
+	pi.mu.Lock()
+	defer pi.mu.Unlock()
+
+	if pi.keys == nil {
+		return k, false
+	}
+	if k, ok := pi.keys[dir]; ok {
+		return k, true
+	}
+	h := sha256.New()
+	for _, name := range []string{"go.mod", "go.sum"} {
+		p, _ := ioutil.ReadFile(filepath.Join(dir, name))
+		fmt.Fprintf(h, "%s %d\n", name, len(p))
+		h.Write(p)
+	}
+	k = pkgIndexKey{Dir: dir, Hash: fmt.Sprintf("%x", h.Sum(nil))}
+	pi.keys[dir] = k
+	return k, true
+}
+
+// lookup returns the index of the module containing srcDir, or nil if it wasn't built yet
+func (pi *pkgIndexer) lookup(mx *mg.Ctx, srcDir string) *pkgIndex {
+	k, ok := pi.key(mx, srcDir)
+	if !ok {
+		return nil
+	}
+	idx, _ := pi.kv.Get(k).(*pkgIndex)
+	return idx
+}
+
+// warm submits a job to build the index of the module containing the view, unless it's already built
+func (pi *pkgIndexer) warm(mx *mg.Ctx) {
+	k, ok := pi.key(mx, mx.View.Dir())
+	if !ok || pi.kv.Get(k) != nil {
+		return
+	}
+
+	pi.mu.Lock()
+	defer pi.mu.Unlock()
+
+	if pi.building[k] {
+		return
+	}
+	pi.building[k] = true
+
+	env := mx.Env
+	title := "Index packages ( " + mgutil.ShortFn(k.Dir, env) + " )"
+	mx.Jobs.Submit(title, func(jx *mg.JobCtx) []mg.Action {
+		defer func() {
+			pi.mu.Lock()
+			defer pi.mu.Unlock()
+			delete(pi.building, k)
+		}()
+
+		idx, err := buildPkgIndex(jx, env, k.Dir)
+		if err != nil {
+			jx.Log.Println("pkg index:", err)
+			return nil
+		}
+		pi.kv.Put(k, idx)
+		jx.Log.Printf("%s: %d packages indexed\n", jx.Job.Name, len(idx.Pkgs))
+		return nil
+	})
+}
+
+// forget drops the index of the module in dir, so it's rebuilt the next time a view in it is activated
+func (pi *pkgIndexer) forget(dir string) {
+	pi.mu.Lock()
+	defer pi.mu.Unlock()
+
+	k, ok := pi.keys[dir]
+	if !ok {
+		return
+	}
+	delete(pi.keys, dir)
+	pi.kv.Del(k)
+}
+
+// buildPkgIndex returns the index of the module in dir, using `go list` to find its packages
+func buildPkgIndex(jx *mg.JobCtx, env mg.EnvMap, dir string) (*pkgIndex, error) {
+	name := "go"
+	args := []string{"list", "-e", "-json=Dir,ImportPath,Name,GoFiles,Standard", "std", "all"}
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd := exec.CommandContext(jx, name, args...)
+	cmd.Dir = dir
+	cmd.Env = env.Environ()
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("`%s` failed: %s: %s", mgutil.QuoteCmd(name, args...), err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	idx := &pkgIndex{}
+	seen := map[string]bool{}
+	dec := json.NewDecoder(stdout)
+	for dec.More() {
+		if err := jx.Err(); err != nil {
+			return nil, err
+		}
+		p := struct {
+			Dir        string
+			ImportPath string
+			Name       string
+			GoFiles    []string
+			Standard   bool
+		}{}
+		if err := dec.Decode(&p); err != nil {
+			return nil, fmt.Errorf("cannot decode `go list` output: %s", err)
+		}
+		if p.Dir == "" || p.Name == "" || p.Name == "main" || seen[p.ImportPath] {
+			continue
+		}
+		seen[p.ImportPath] = true
+
+		ent := pkgIndexEnt{
+			Pkg: gopkg.Pkg{
+				Dir:        p.Dir,
+				Name:       p.Name,
+				ImportPath: p.ImportPath,
+				Goroot:     p.Standard,
+			},
+			Exports: pkgIndexExports(p.Dir, p.GoFiles),
+		}
+		ent.Finalize()
+		idx.Pkgs = append(idx.Pkgs, ent)
+		if n := len(idx.Pkgs); n%100 == 0 {
+			jx.Progress("%d packages", n)
+		}
+	}
+	sort.Slice(idx.Pkgs, func(i, j int) bool { return idx.Pkgs[i].ImportPath < idx.Pkgs[j].ImportPath })
+	return idx, nil
+}
+
+// pkgIndexExports returns the sorted list of the exported top-level names declared in the files fns in dir.
+// Files that can't be parsed are skipped.
+func pkgIndexExports(dir string, fns []string) []string {
+	fset := token.NewFileSet()
+	seen := map[string]bool{}
+	add := func(id *ast.Ident) {
+		if id.IsExported() {
+			seen[id.Name] = true
+		}
+	}
+	for _, fn := range fns {
+		af, err := parser.ParseFile(fset, filepath.Join(dir, fn), nil, parser.SkipObjectResolution)
+		if af == nil || err != nil {
+			continue
+		}
+		for _, decl := range af.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if d.Recv == nil {
+					add(d.Name)
+				}
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					switch s := spec.(type) {
+					case *ast.TypeSpec:
+						add(s.Name)
+					case *ast.ValueSpec:
+						for _, id := range s.Names {
+							add(id)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	l := make([]string, 0, len(seen))
+	for s := range seen {
+		l = append(l, s)
+	}
+	sort.Strings(l)
+	return l
+}
@@ -0,0 +1,72 @@
+package golang
+
+import (
+	"io/ioutil"
+	"margo.sh/golang/gopkg"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPkgIndexExports(t *testing.T) {
+	dir := t.TempDir()
+	src := `package p
+
+const MaxN, minN = 1, 2
+
+var (
+	Default = New()
+)
+
+type T struct{}
+
+type unexported int
+
+func New() *T { return nil }
+
+func (t *T) Method() {}
+
+func helper() {}
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "p.go"), []byte(src), 0600); err != nil {
+		t.Fatal(err)
+	}
+	got := pkgIndexExports(dir, []string{"p.go", "missing.go"})
+	want := []string{"Default", "MaxN", "New", "T"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("pkgIndexExports() = %q, want %q", got, want)
+	}
+}
+
+func TestPkgIndexImportPath(t *testing.T) {
+	ent := func(path, name string, std bool, exports ...string) pkgIndexEnt {
+		ent := pkgIndexEnt{
+			Pkg:     gopkg.Pkg{Dir: "/src/" + path, Name: name, ImportPath: path, Goroot: std},
+			Exports: exports,
+		}
+		ent.Finalize()
+		return ent
+	}
+	idx := &pkgIndex{Pkgs: []pkgIndexEnt{
+		ent("encoding/json", "json", true, "Marshal", "Unmarshal"),
+		ent("example.com/json", "json", false, "Parse"),
+		ent("example.com/mod/internal/x", "x", false, "X"),
+	}}
+
+	tests := []struct {
+		pkgName, sym, srcDir string
+		want                 string
+	}{
+		{"json", "", "/src/example.com/mod", "encoding/json"},
+		{"json", "Mar", "/src/example.com/mod", "encoding/json"},
+		{"json", "Par", "/src/example.com/mod", "example.com/json"},
+		{"x", "", "/src/example.com/mod/cmd", "example.com/mod/internal/x"},
+		{"x", "", "/src/example.com/other", ""},
+		{"yaml", "", "/src/example.com/mod", ""},
+	}
+	for _, tc := range tests {
+		if got := idx.importPath(tc.pkgName, tc.sym, tc.srcDir); got != tc.want {
+			t.Errorf("importPath(%q, %q, %q) = %q, want %q", tc.pkgName, tc.sym, tc.srcDir, got, tc.want)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package golang
 
 import (
 	"go/ast"
+	"margo.sh/golang/gopkg"
 	"margo.sh/golang/goutil"
 	"margo.sh/golang/snippets"
 	"margo.sh/mg"
@@ -93,13 +94,22 @@ func ImportPathSnippet(cx *CompletionCtx) []mg.Completion {
 		pfx = ""
 	}
 
-	pkl := mctl.plst.View().List
 	skip := map[string]bool{}
 	srcDir := cx.View.Dir()
 	for _, spec := range cx.AstFile.Imports {
 		skip[unquote(spec.Path.Value)] = true
 	}
 
+	// in a module, only the packages in its index can be imported
+	var pkl []*gopkg.Pkg
+	if idx := mctl.pidx.lookup(cx.Ctx, srcDir); idx != nil {
+		for _, ent := range idx.importable(srcDir) {
+			pkl = append(pkl, &ent.Pkg)
+		}
+	} else {
+		pkl = mctl.plst.View().List
+	}
+
 	cl := make([]mg.Completion, 0, len(pkl))
 	for _, p := range pkl {
 		if skip[p.ImportPath] || !p.Importable(srcDir) {
@@ -0,0 +1,281 @@
+package golang
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"margo.sh/mg"
+	"margo.sh/mgutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// staticCheckDocURL is the URL of the explanations of staticcheck's checks, followed by the check's code
+	staticCheckDocURL = "https://staticcheck.dev/docs/checks/#"
+
+	// staticCheckCacheSize is the number of packages whose results are cached
+	staticCheckCacheSize = 64
+)
+
+// StaticCheck is a linter that runs staticcheck (https://staticcheck.dev) on the package of the current view.
+//
+// Unsaved changes to the view are passed to it using an overlay (see `go help build`),
+// so it's run when the view is activated, modified or saved.
+// Each run is a job (see mg.Jobs) that's cancelled when a newer run starts,
+// when the reducer is unmounted or when the agent shuts down.
+// Issues are labeled with the code of the check that reported them e.g. SA4006,
+// and link to the check's explanation.
+//
+// The results are cached by the contents of the package's files, so unchanged packages aren't checked again.
+// Changes to its dependencies aren't detected until one of the package's files changes.
+type StaticCheck struct {
+	mg.ReducerType
+
+	// Path is the name, or path, of the staticcheck command. If it's empty, staticcheck is found in $PATH
+	Path string
+
+	// Checks is the list of checks to run, passed as the -checks flag e.g. "all,-ST1000".
+	// If it's empty, staticcheck's default, or the configuration in staticcheck.conf is used
+	Checks string
+
+	// Args are extra arguments passed to staticcheck, before the package
+	Args []string
+
+	mu    sync.Mutex
+	job   *mg.Job
+	cache *mg.KVLRU
+}
+
+// staticCheckKey is the key of the issues of a package, and of the results cached for it
+type staticCheckKey struct {
+	Dir  string
+	Hash string
+}
+
+// staticCheckDiag is a diagnostic written by staticcheck -f json
+type staticCheckDiag struct {
+	Code     string `json:"code"`
+	Severity string `json:"severity"`
+	Location struct {
+		File   string `json:"file"`
+		Line   int    `json:"line"`
+		Column int    `json:"column"`
+	} `json:"location"`
+	End struct {
+		Line   int `json:"line"`
+		Column int `json:"column"`
+	} `json:"end"`
+	Message string `json:"message"`
+}
+
+func (sc *StaticCheck) RCond(mx *mg.Ctx) bool {
+	return mx.LangIs(mg.Go) && filepath.IsAbs(mx.View.Dir())
+}
+
+func (sc *StaticCheck) RMount(mx *mg.Ctx) {
+	sc.cache = mg.NewKVLRU(staticCheckCacheSize, 0)
+	mx.Store.ReportKVStats("StaticCheck", sc.cache)
+}
+
+func (sc *StaticCheck) RUnmount(mx *mg.Ctx) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.job != nil {
+		sc.job.Cancel()
+		sc.job = nil
+	}
+}
+
+func (sc *StaticCheck) Reduce(mx *mg.Ctx) *mg.State {
+	switch mx.Action.(type) {
+	case mg.ViewActivated, mg.ViewModified, mg.ViewSaved:
+		sc.submit(mx)
+	}
+	return mx.State
+}
+
+// submit submits the job checking the package of mx.View, cancelling the previous one, whose results are stale
+func (sc *StaticCheck) submit(mx *mg.Ctx) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.job != nil {
+		sc.job.Cancel()
+	}
+	sc.job = mx.Jobs.Submit("staticcheck "+mx.View.ShortFn(mx.Env), func(jx *mg.JobCtx) []mg.Action {
+		return sc.check(jx, mx)
+	})
+}
+
+// check returns the action storing the issues of the package of mx.View
+func (sc *StaticCheck) check(jx *mg.JobCtx, mx *mg.Ctx) []mg.Action {
+	v := mx.View
+	dir := v.Dir()
+	src, err := v.ReadAll()
+	if err != nil {
+		return nil
+	}
+
+	key := staticCheckKey{Dir: dir, Hash: sc.hash(mx, src)}
+	issues, ok := sc.cache.Get(key).(mg.IssueSet)
+	if !ok {
+		issues, err = sc.run(jx, mx, src)
+		if err != nil {
+			if jx.Err() == nil {
+				jx.Log.Println("staticcheck:", err)
+			}
+			return nil
+		}
+		sc.cache.Put(key, issues)
+	}
+	return []mg.Action{mg.StoreIssues{
+		IssueKey: mg.IssueKey{Key: staticCheckKey{Dir: dir}, Dir: dir},
+		Issues:   issues,
+	}}
+}
+
+// hash returns a hash of the package of mx.View, with the view's contents replaced by src,
+// and the configuration of the check
+func (sc *StaticCheck) hash(mx *mg.Ctx, src []byte) string {
+	v := mx.View
+	fn := v.Filename()
+	h := sha256.New()
+	fmt.Fprintf(h, "%q %q %q %q\n", sc.Path, sc.Checks, sc.Args, mx.Env.Environ())
+
+	names := []string{fn}
+	l, _ := ioutil.ReadDir(v.Dir())
+	for _, fi := range l {
+		name := filepath.Join(v.Dir(), fi.Name())
+		if name != fn && (strings.HasSuffix(name, ".go") || fi.Name() == "staticcheck.conf") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p := src
+		if name != fn {
+			p, _ = ioutil.ReadFile(name)
+		}
+		fmt.Fprintf(h, "%s %d\n", name, len(p))
+		h.Write(p)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// run runs staticcheck on the package of mx.View, with the view's contents replaced by src.
+// The command is killed if jx is cancelled.
+func (sc *StaticCheck) run(jx *mg.JobCtx, mx *mg.Ctx, src []byte) (mg.IssueSet, error) {
+	v := mx.View
+	env := mx.Env
+	if v.Dirty || v.Path == "" {
+		tmpDir, err := mg.MkTempDir("staticcheck")
+		if err != nil {
+			return nil, fmt.Errorf("cannot create overlay dir: %s", err)
+		}
+		defer os.RemoveAll(tmpDir)
+
+		overlay, err := sc.writeOverlay(tmpDir, v.Filename(), src)
+		if err != nil {
+			return nil, fmt.Errorf("cannot write overlay: %s", err)
+		}
+		flags := strings.TrimSpace(env.Getenv("GOFLAGS", "") + " -overlay=" + overlay)
+		env = env.Add("GOFLAGS", flags)
+	}
+
+	name := sc.Path
+	if name == "" {
+		name = "staticcheck"
+	}
+	args := []string{"-f", "json"}
+	if sc.Checks != "" {
+		args = append(args, "-checks", sc.Checks)
+	}
+	args = append(args, sc.Args...)
+	args = append(args, ".")
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd := exec.CommandContext(jx, name, args...)
+	cmd.Dir = v.Dir()
+	cmd.Env = env.Environ()
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	if _, ok := err.(*exec.ExitError); err != nil && (!ok || stdout.Len() == 0) {
+		return nil, fmt.Errorf("`%s` failed: %s: %s", mgutil.QuoteCmd(name, args...), err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return staticCheckIssues(stdout)
+}
+
+// writeOverlay writes src to a file in dir, and the overlay file replacing file fn with it, returning the latter's name
+func (sc *StaticCheck) writeOverlay(dir, fn string, src []byte) (string, error) {
+	srcFn := filepath.Join(dir, filepath.Base(fn))
+	if err := ioutil.WriteFile(srcFn, src, 0600); err != nil {
+		return "", err
+	}
+	p, err := json.Marshal(map[string]map[string]string{
+		"Replace": {fn: srcFn},
+	})
+	if err != nil {
+		return "", err
+	}
+	overlay := filepath.Join(dir, "overlay.json")
+	return overlay, ioutil.WriteFile(overlay, p, 0600)
+}
+
+// staticCheckIssues converts the diagnostics written by staticcheck -f json to issues
+func staticCheckIssues(r io.Reader) (mg.IssueSet, error) {
+	var issues mg.IssueSet
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		d := staticCheckDiag{}
+		if err := json.Unmarshal(line, &d); err != nil {
+			return nil, fmt.Errorf("cannot decode diagnostic: %s", err)
+		}
+		if d.Severity == "ignored" {
+			continue
+		}
+
+		isu := mg.Issue{
+			Path:    d.Location.File,
+			Row:     d.Location.Line - 1,
+			Col:     d.Location.Column - 1,
+			Tag:     mg.Warning,
+			Label:   "Go/staticcheck",
+			Message: d.Message,
+		}
+		if d.End.Line == d.Location.Line && d.End.Column > d.Location.Column {
+			isu.End = d.End.Column - 1
+		}
+		if d.Severity == "error" {
+			isu.Tag = mg.Error
+		}
+		if d.Code != "" && d.Code != "compile" {
+			isu.Label += "/" + d.Code
+			isu.Message = fmt.Sprintf("%s (%s%s)", d.Message, staticCheckDocURL, d.Code)
+		}
+		if isu.Row < 0 {
+			isu.Row = 0
+		}
+		if isu.Col < 0 {
+			isu.Col = 0
+		}
+		issues = append(issues, isu)
+	}
+	return issues, sc.Err()
+}
@@ -0,0 +1,119 @@
+package golang
+
+import (
+	"io/ioutil"
+	"margo.sh/mg"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStaticCheckIssues(t *testing.T) {
+	cases := []struct {
+		name string
+		out  string
+		want mg.IssueSet
+		err  bool
+	}{
+		{
+			name: "warning",
+			out:  `{"code":"S1000","severity":"warning","location":{"file":"/p/a.go","line":3,"column":2},"end":{"line":3,"column":10},"message":"should use a simple channel send"}`,
+			want: mg.IssueSet{{
+				Path:    "/p/a.go",
+				Row:     2,
+				Col:     1,
+				End:     9,
+				Tag:     mg.Warning,
+				Label:   "Go/staticcheck/S1000",
+				Message: "should use a simple channel send (" + staticCheckDocURL + "S1000)",
+			}},
+		},
+		{
+			name: "compile error",
+			out:  `{"code":"compile","severity":"error","location":{"file":"/p/a.go","line":1,"column":1},"end":{"line":2,"column":1},"message":"undefined: x"}`,
+			want: mg.IssueSet{{
+				Path:    "/p/a.go",
+				Tag:     mg.Error,
+				Label:   "Go/staticcheck",
+				Message: "undefined: x",
+			}},
+		},
+		{
+			name: "ignored and blank lines",
+			out: `{"code":"U1000","severity":"ignored","location":{"file":"/p/a.go","line":5,"column":6},"message":"func f is unused"}
+
+{"code":"SA4006","severity":"error","location":{"file":"/p/b.go","line":0,"column":0},"message":"value is never used"}`,
+			want: mg.IssueSet{{
+				Path:    "/p/b.go",
+				Tag:     mg.Error,
+				Label:   "Go/staticcheck/SA4006",
+				Message: "value is never used (" + staticCheckDocURL + "SA4006)",
+			}},
+		},
+		{
+			name: "malformed",
+			out: `{"code":"S1000","severity":"warning","location":{"file":"/p/a.go","line":3,"column":2},"message":"ok"}
+/p/a.go:3:2: not json`,
+			err: true,
+		},
+	}
+	for _, c := range cases {
+		got, err := staticCheckIssues(strings.NewReader(c.out))
+		if (err != nil) != c.err {
+			t.Errorf("%s: staticCheckIssues() error = (%v); want error: %v", c.name, err, c.err)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: staticCheckIssues() = %+v; want %+v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestStaticCheckCancel(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake staticcheck is a shell script")
+	}
+	dir := t.TempDir()
+	started := filepath.Join(dir, "started")
+	script := filepath.Join(dir, "staticcheck")
+	err := ioutil.WriteFile(script, []byte("#!/bin/sh\ntouch "+started+"\nexec sleep 30\n"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sc := &StaticCheck{Path: script}
+	h := mg.NewHarness(t, sc).Open(filepath.Join(dir, "p.go"), "package p\n", 0)
+	job := func() *mg.Job {
+		sc.mu.Lock()
+		defer sc.mu.Unlock()
+		return sc.job
+	}
+	h.Dispatch(mg.ViewSaved{})
+	first := job()
+	for i := 0; i < 500; i++ {
+		if _, err := os.Stat(started); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := os.Stat(started); err != nil {
+		t.Fatalf("staticcheck wasn't started: %s", err)
+	}
+
+	h.Dispatch(mg.ViewModified{})
+	select {
+	case <-first.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the running staticcheck wasn't cancelled when a newer run was submitted")
+	}
+	second := job()
+	second.Cancel()
+	select {
+	case <-second.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the staticcheck job wasn't cancelled")
+	}
+}
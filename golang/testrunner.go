@@ -0,0 +1,404 @@
+package golang
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"margo.sh/mg"
+	"margo.sh/mgutil"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// testRunnerLogPat matches the lines logged by t.Error, t.Fatal, etc. e.g. `    x_test.go:12: got 1, want 2`
+	testRunnerLogPat = regexp.MustCompile(`^\s+([^\s:]+\.go):(\d+): (.*)$`)
+
+	// testRunnerBuildErrPat matches build errors e.g. `./x_test.go:12:3: undefined: x`
+	testRunnerBuildErrPat = regexp.MustCompile(`^([^\s:]+\.go):(\d+)(?::(\d+))?: (.*)$`)
+)
+
+// TestRunner runs the Test, Benchmark and Example functions of the current view, individually.
+//
+// It adds the command `go.test.func [NAME...]`, which runs the named functions, or the one enclosing the cursor,
+// and a UserCmd for each function in the view.
+// When a test file is activated or modified, the TestMarkers client action is sent,
+// so the client can show a "run test" marker next to each function.
+//
+// The output of `go test` is streamed to the command's output, and the job's title shows the test being run.
+// Failures are reported as issues, at the line of the failing assertion e.g. the call to t.Fatal,
+// or at the function if the line isn't known e.g. when an Example's output doesn't match.
+type TestRunner struct {
+	mg.ReducerType
+
+	// TestArgs is a list of extra arguments to pass to `go test` for tests and examples
+	TestArgs []string
+
+	// BenchArgs is a list of extra arguments to pass to `go test` for benchmarks
+	BenchArgs []string
+
+	mu      sync.Mutex
+	markers map[string]string
+}
+
+// testRunnerKey is the IssueKey.Key of the issues of the tests run in Dir
+type testRunnerKey struct {
+	Dir string
+}
+
+// testRunnerFunc is a Test, Benchmark or Example function
+type testRunnerFunc struct {
+	// Name is the function's name e.g. TestFoo
+	Name string
+	// Kind is the prefix of the function's name e.g. Test
+	Kind string
+	// Row is the row of the function's name
+	Row int
+	// Start and End are the offsets of the function's declaration
+	Start, End int
+}
+
+// testRunnerEvent is an event written by `go test -json`. See `go doc test2json`
+type testRunnerEvent struct {
+	Action string
+	Test   string
+	Output string
+}
+
+func (tr *TestRunner) RCond(mx *mg.Ctx) bool {
+	return mx.LangIs(mg.Go) && strings.HasSuffix(mx.View.Filename(), "_test.go")
+}
+
+func (tr *TestRunner) RMount(mx *mg.Ctx) {
+	tr.markers = map[string]string{}
+}
+
+func (tr *TestRunner) Reduce(mx *mg.Ctx) *mg.State {
+	switch act := mx.Action.(type) {
+	case mg.ViewActivated, mg.ViewModified, mg.ViewSaved:
+		tr.sendMarkers(mx)
+	case mg.QueryUserCmds:
+		return mx.AddUserCmds(tr.userCmds(mx)...)
+	case mg.RunCmd:
+		if act.Name == "go.test.func" {
+			return mx.AddBuiltinCmds(mg.BuiltinCmd{
+				Name: act.Name,
+				Desc: "go.test.func [NAME...] runs the named Test, Benchmark or Example functions, or the one enclosing the cursor",
+				Run:  tr.runCmd,
+			})
+		}
+	}
+	return mx.State
+}
+
+// funcs returns the Test, Benchmark and Example functions in the view
+func (tr *TestRunner) funcs(mx *mg.Ctx) []testRunnerFunc {
+	v := mx.View
+	src, err := v.ReadAll()
+	if err != nil {
+		return nil
+	}
+	pf := ParseFile(mx, v.Filename(), src)
+	if pf.AstFile == nil || pf.TokenFile == nil {
+		return nil
+	}
+
+	var l []testRunnerFunc
+	for _, d := range pf.AstFile.Decls {
+		fd, ok := d.(*ast.FuncDecl)
+		if !ok || fd.Recv != nil || fd.Name == nil {
+			continue
+		}
+		name, kind, _, ok := splitTestFuncName(fd.Name.Name)
+		if !ok {
+			continue
+		}
+		l = append(l, testRunnerFunc{
+			Name:  name,
+			Kind:  kind,
+			Row:   pf.TokenFile.Line(fd.Name.Pos()) - 1,
+			Start: pf.TokenFile.Offset(fd.Pos()),
+			End:   pf.TokenFile.Offset(fd.End()),
+		})
+	}
+	return l
+}
+
+// userCmd returns the UserCmd that runs function f
+func (tr *TestRunner) userCmd(f testRunnerFunc) mg.UserCmd {
+	return mg.UserCmd{
+		Title: "Run " + f.Name,
+		Name:  "go.test.func",
+		Args:  []string{f.Name},
+	}
+}
+
+func (tr *TestRunner) userCmds(mx *mg.Ctx) []mg.UserCmd {
+	var cl []mg.UserCmd
+	for _, f := range tr.funcs(mx) {
+		cl = append(cl, tr.userCmd(f))
+	}
+	return cl
+}
+
+// sendMarkers sends the TestMarkers of the view, if they changed since they were last sent
+func (tr *TestRunner) sendMarkers(mx *mg.Ctx) {
+	v := mx.View
+	tm := mg.TestMarkers{Path: v.Path, Name: v.Name}
+	sig := &strings.Builder{}
+	for _, f := range tr.funcs(mx) {
+		tm.Markers = append(tm.Markers, mg.TestMarker{Row: f.Row, Func: f.Name, Cmd: tr.userCmd(f)})
+		fmt.Fprintf(sig, "%s:%d\n", f.Name, f.Row)
+	}
+
+	fn := v.Filename()
+	tr.mu.Lock()
+	changed := tr.markers[fn] != sig.String() || mx.ActionIs(mg.ViewActivated{})
+	tr.markers[fn] = sig.String()
+	tr.mu.Unlock()
+	if changed {
+		mx.Store.Notify(tm)
+	}
+}
+
+func (tr *TestRunner) runCmd(cx *mg.CmdCtx) *mg.State {
+	funcs := tr.funcs(cx.Ctx)
+	names := cx.Args
+	if len(names) == 0 {
+		pos := cx.View.Pos
+		for _, f := range funcs {
+			if f.Start <= pos && pos <= f.End {
+				names = []string{f.Name}
+				break
+			}
+		}
+	}
+	if len(names) == 0 {
+		fmt.Fprintln(cx.Output, "go.test.func: the cursor isn't in a Test, Benchmark or Example function")
+		cx.Output.Close()
+		return cx.State
+	}
+
+	args, err := tr.args(names)
+	if err != nil {
+		fmt.Fprintln(cx.Output, "go.test.func:", err)
+		cx.Output.Close()
+		return cx.State
+	}
+	rows := map[string]int{}
+	for _, f := range funcs {
+		rows[f.Name] = f.Row
+	}
+	run := newTestRunnerRun(cx.View.Dir(), cx.View.Filename(), rows)
+	cx.Jobs.Submit("go.test.func "+strings.Join(names, " "), func(jx *mg.JobCtx) []mg.Action {
+		tr.run(jx, cx, run, args)
+		return nil
+	})
+	return cx.State
+}
+
+// args returns the arguments of `go test` to run the functions names
+func (tr *TestRunner) args(names []string) ([]string, error) {
+	var tests, benchs []string
+	for _, nm := range names {
+		_, kind, _, ok := splitTestFuncName(nm)
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("%s isn't a Test, Benchmark or Example function", nm)
+		case kind == "Benchmark":
+			benchs = append(benchs, regexp.QuoteMeta(nm))
+		default:
+			tests = append(tests, regexp.QuoteMeta(nm))
+		}
+	}
+
+	pat := func(l []string) string {
+		if len(l) == 0 {
+			return "^$"
+		}
+		return "^(" + strings.Join(l, "|") + ")$"
+	}
+	args := []string{"test", "-json", "-run", pat(tests)}
+	if len(benchs) != 0 {
+		args = append(args, "-bench", pat(benchs))
+		args = append(args, tr.BenchArgs...)
+	}
+	if len(tests) != 0 {
+		args = append(args, tr.TestArgs...)
+	}
+	return append(args, "."), nil
+}
+
+// run runs `go test` with arguments args, streaming its output to cx.Output, and reports the failures as issues
+func (tr *TestRunner) run(jx *mg.JobCtx, cx *mg.CmdCtx, run *testRunnerRun, args []string) {
+	defer cx.Output.Close()
+
+	if cx.Verbose {
+		fmt.Fprintln(cx.Output, "#", mgutil.QuoteCmd("go", args...))
+	}
+	stderr := &bytes.Buffer{}
+	cmd := exec.CommandContext(jx, "go", args...)
+	cmd.Dir = run.dir
+	cmd.Env = cx.Env.Environ()
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		fmt.Fprintln(cx.Output, "go.test.func:", err)
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintln(cx.Output, "go.test.func:", err)
+		return
+	}
+
+	sc := bufio.NewScanner(stdout)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		out, test := run.line(sc.Text())
+		if out != "" {
+			fmt.Fprint(cx.Output, out)
+		}
+		if test != "" {
+			jx.Progress("%s", test)
+		}
+	}
+	err = cmd.Wait()
+	for _, ln := range strings.Split(stderr.String(), "\n") {
+		if ln != "" {
+			run.buildError(ln)
+			fmt.Fprintln(cx.Output, ln)
+		}
+	}
+	if err != nil && len(run.issues) == 0 {
+		fmt.Fprintln(cx.Output, "go.test.func:", err)
+	}
+
+	cx.Store.Dispatch(mg.StoreIssues{
+		IssueKey: mg.IssueKey{Key: testRunnerKey{Dir: run.dir}, Dir: run.dir},
+		Issues:   run.issues,
+	})
+}
+
+// testRunnerRun collects the failures of a run of `go test -json`
+type testRunnerRun struct {
+	// dir is the directory of the package
+	dir string
+	// fn is the name of the view's file
+	fn string
+	// rows are the rows of the view's functions, by name
+	rows map[string]int
+	// logs are the lines logged by each test, by name
+	logs map[string][]string
+	// reported are the functions whose failures were reported
+	reported map[string]bool
+	// issues are the failures found so far
+	issues mg.IssueSet
+}
+
+func newTestRunnerRun(dir, fn string, rows map[string]int) *testRunnerRun {
+	return &testRunnerRun{
+		dir:      dir,
+		fn:       fn,
+		rows:     rows,
+		logs:     map[string][]string{},
+		reported: map[string]bool{},
+	}
+}
+
+// line handles a line written by `go test -json`,
+// returning the output to show, and the name of the test that started running, if any
+func (run *testRunnerRun) line(ln string) (output, test string) {
+	ev := testRunnerEvent{}
+	if !strings.HasPrefix(ln, "{") || json.Unmarshal([]byte(ln), &ev) != nil {
+		run.buildError(ln)
+		return ln + "\n", ""
+	}
+
+	switch ev.Action {
+	case "run":
+		return "", ev.Test
+	case "output", "build-output":
+		if ev.Test != "" {
+			run.logs[ev.Test] = append(run.logs[ev.Test], strings.TrimRight(ev.Output, "\n"))
+		} else if ev.Action == "build-output" {
+			run.buildError(strings.TrimRight(ev.Output, "\n"))
+		}
+		return ev.Output, ""
+	case "fail":
+		if ev.Test != "" {
+			run.fail(ev.Test)
+		}
+	}
+	return "", ""
+}
+
+// fail converts the lines logged by the failed test name to issues.
+// If it logged nothing, the failure is reported at its function, unless another of its failures was reported
+// e.g. a parent test fails when its subtests fail.
+func (run *testRunnerRun) fail(name string) {
+	fun := strings.SplitN(name, "/", 2)[0]
+	for _, ln := range run.logs[name] {
+		m := testRunnerLogPat.FindStringSubmatch(ln)
+		if m == nil {
+			continue
+		}
+		row, _ := strconv.Atoi(m[2])
+		run.issues = append(run.issues, mg.Issue{
+			Path:    run.path(m[1]),
+			Row:     row - 1,
+			Tag:     mg.Error,
+			Label:   "Go/test",
+			Message: name + ": " + m[3],
+		})
+		run.reported[fun] = true
+	}
+	delete(run.logs, name)
+
+	row, ok := run.rows[fun]
+	if !ok || run.reported[fun] {
+		return
+	}
+	run.issues = append(run.issues, mg.Issue{
+		Path:    run.fn,
+		Row:     row,
+		Tag:     mg.Error,
+		Label:   "Go/test",
+		Message: name + " failed",
+	})
+	run.reported[fun] = true
+}
+
+// buildError converts ln to an issue, if it's a build error
+func (run *testRunnerRun) buildError(ln string) {
+	m := testRunnerBuildErrPat.FindStringSubmatch(ln)
+	if m == nil {
+		return
+	}
+	row, _ := strconv.Atoi(m[2])
+	col, _ := strconv.Atoi(m[3])
+	if col > 0 {
+		col--
+	}
+	run.issues = append(run.issues, mg.Issue{
+		Path:    run.path(m[1]),
+		Row:     row - 1,
+		Col:     col,
+		Tag:     mg.Error,
+		Label:   "Go/test",
+		Message: m[4],
+	})
+}
+
+// path returns the absolute name of file fn, reported relative to the package's directory
+func (run *testRunnerRun) path(fn string) string {
+	if filepath.IsAbs(fn) {
+		return fn
+	}
+	return filepath.Join(run.dir, fn)
+}
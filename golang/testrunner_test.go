@@ -0,0 +1,72 @@
+package golang
+
+import (
+	"margo.sh/mg"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTestRunnerArgs(t *testing.T) {
+	tr := &TestRunner{BenchArgs: []string{"-benchmem"}}
+	tests := []struct {
+		names []string
+		want  []string
+	}{
+		{[]string{"TestA"}, []string{"test", "-json", "-run", "^(TestA)$", "."}},
+		{[]string{"TestA", "ExampleB"}, []string{"test", "-json", "-run", "^(TestA|ExampleB)$", "."}},
+		{[]string{"BenchmarkC"}, []string{"test", "-json", "-run", "^$", "-bench", "^(BenchmarkC)$", "-benchmem", "."}},
+	}
+	for _, tc := range tests {
+		got, err := tr.args(tc.names)
+		if err != nil {
+			t.Errorf("args(%q): %s", tc.names, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("args(%q) = %q, want %q", tc.names, got, tc.want)
+		}
+	}
+	if _, err := tr.args([]string{"helper"}); err == nil {
+		t.Errorf("args(helper): expected an error")
+	}
+}
+
+func TestTestRunnerRun(t *testing.T) {
+	run := newTestRunnerRun("/p", "/p/p_test.go", map[string]int{"TestA": 9, "TestB": 19, "ExampleC": 29})
+	out := `{"Action":"run","Test":"TestA"}
+{"Action":"output","Test":"TestA","Output":"=== RUN   TestA\n"}
+{"Action":"output","Test":"TestA","Output":"    p_test.go:12: got 1, want 2\n"}
+{"Action":"output","Test":"TestA","Output":"--- FAIL: TestA (0.00s)\n"}
+{"Action":"fail","Test":"TestA"}
+{"Action":"run","Test":"TestB"}
+{"Action":"run","Test":"TestB/sub"}
+{"Action":"output","Test":"TestB/sub","Output":"    p_test.go:22: sub failed\n"}
+{"Action":"fail","Test":"TestB/sub"}
+{"Action":"fail","Test":"TestB"}
+{"Action":"run","Test":"ExampleC"}
+{"Action":"output","Test":"ExampleC","Output":"--- FAIL: ExampleC (0.00s)\n"}
+{"Action":"fail","Test":"ExampleC"}
+{"Action":"fail"}`
+
+	var running []string
+	for _, ln := range strings.Split(out, "\n") {
+		if _, test := run.line(ln); test != "" {
+			running = append(running, test)
+		}
+	}
+	if want := []string{"TestA", "TestB", "TestB/sub", "ExampleC"}; !reflect.DeepEqual(running, want) {
+		t.Errorf("running = %q, want %q", running, want)
+	}
+
+	run.line("./p_test.go:5:2: undefined: x")
+	want := mg.IssueSet{
+		{Path: "/p/p_test.go", Row: 11, Tag: mg.Error, Label: "Go/test", Message: "TestA: got 1, want 2"},
+		{Path: "/p/p_test.go", Row: 21, Tag: mg.Error, Label: "Go/test", Message: "TestB/sub: sub failed"},
+		{Path: "/p/p_test.go", Row: 29, Tag: mg.Error, Label: "Go/test", Message: "ExampleC failed"},
+		{Path: "/p/p_test.go", Row: 4, Col: 1, Tag: mg.Error, Label: "Go/test", Message: "undefined: x"},
+	}
+	if !reflect.DeepEqual(run.issues, want) {
+		t.Errorf("issues = %+v, want %+v", run.issues, want)
+	}
+}
@@ -142,28 +142,31 @@ func (tc *typChk) handleRunCmd(mx *mg.Ctx, st *mg.State, rc mg.RunCmd) *mg.State
 	return st.AddBuiltinCmds(mg.BuiltinCmd{
 		Name: rc.Name,
 		Run: func(cx *mg.CmdCtx) *mg.State {
-			go tc.gotoDef(cx)
+			cx.Jobs.Submit("typecheck definition", func(*mg.JobCtx) []mg.Action {
+				return tc.gotoDef(cx)
+			})
 			return cx.State
 		}},
 	)
 }
 
-func (tc *typChk) gotoDef(cx *mg.CmdCtx) {
+// gotoDef returns the action that jumps to the definition of the identifier under the cursor
+func (tc *typChk) gotoDef(cx *mg.CmdCtx) []mg.Action {
 	defer cx.Output.Close()
 
 	// TODO: maybe make infProc handle this
 	ti, err := tc.info(cx.Ctx)
 	if err != nil {
 		fmt.Fprintf(cx.Output, "TypeCheck: %s\n", err)
-		return
+		return nil
 	}
 	tp, act, ok := tc.defAct(ti)
 	if !ok {
 		fmt.Fprintln(cx.Output, "TypeCheck: Declaration not found.")
-		return
+		return nil
 	}
 	fmt.Fprintf(cx.Output, "TypeCheck: Identifier: %s, Definition: %s", ti.Id, tp)
-	cx.Store.Dispatch(act)
+	return []mg.Action{act}
 }
 
 func (tc *typChk) isuProc(mx *mg.Ctx) {
@@ -254,7 +257,7 @@ func (tc *typChk) infProc(mx *mg.Ctx) {
 			MinDuration: 10 * time.Millisecond,
 		})
 	}()
-	defer mx.Store.Dispatch(mg.Render)
+	defer mx.Store.DispatchPriority(mg.Render, mg.PriorityInteractive)
 
 	ti, err := tc.info(mx)
 	tc.mu.Lock()
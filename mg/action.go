@@ -1,6 +1,7 @@
 package mg
 
 import (
+	"fmt"
 	"margo.sh/mg/actions"
 	"reflect"
 )
@@ -8,9 +9,20 @@ import (
 var (
 	ActionCreators = (&actions.Registry{}).
 		Register("Activate", Activate{}).
+		Register("Cancel", Cancel{}).
 		Register("QueryCompletions", QueryCompletions{}).
+		Register("CompletionAccepted", CompletionAccepted{}).
 		Register("QueryCmdCompletions", QueryCmdCompletions{}).
 		Register("QueryIssues", QueryIssues{}).
+		Register("QueryMetrics", QueryMetrics{}).
+		Register("QueryStatus", QueryStatus{}).
+		Register("QueryHistory", QueryHistory{}).
+		Register("RestoreSnapshot", RestoreSnapshot{}).
+		Register("QueryReducers", QueryReducers{}).
+		Register("QueryProfile", QueryProfile{}).
+		Register("EnableReducer", EnableReducer{}).
+		Register("DisableReducer", DisableReducer{}).
+		Register("Pong", Pong{}).
 		Register("Restart", Restart{}).
 		Register("Shutdown", Shutdown{}).
 		Register("ViewActivated", ViewActivated{}).
@@ -21,12 +33,52 @@ var (
 		Register("ViewPosChanged", ViewPosChanged{}).
 		Register("ViewPreSave", ViewPreSave{}).
 		Register("ViewSaved", ViewSaved{}).
+		Register("ViewClosed", ViewClosed{}).
 		Register("QueryUserCmds", QueryUserCmds{}).
 		Register("QueryTestCmds", QueryTestCmds{}).
 		Register("RunCmd", RunCmd{}).
 		Register("QueryTooltips", QueryTooltips{})
 )
 
+// RegisterAction registers zero as the action named name, that the client may send.
+//
+// When the client sends an action with that name, its data is decoded into a copy of zero, which is then dispatched.
+// This allows extensions to define new actions with typed data e.g.
+//
+//	type RunLinter struct {
+//		mg.ActionType
+//		Linter string
+//	}
+//
+//	mg.RegisterAction("RunLinter", RunLinter{})
+//
+// An error is returned if name is empty or an action with that name is already registered.
+func RegisterAction(name string, zero Action) error {
+	if zero == nil {
+		return fmt.Errorf("cannot register action %s: its zero value is nil", name)
+	}
+	return RegisterActionCreator(name, actions.MakeActionCreator(zero))
+}
+
+// RegisterActionCreator registers create as the function that creates the action named name, that the client may send.
+//
+// See RegisterAction
+func RegisterActionCreator(name string, create actions.ActionCreator) (err error) {
+	if name == "" {
+		return fmt.Errorf("cannot register action: its name is empty")
+	}
+	if create == nil {
+		return fmt.Errorf("cannot register action %s: its creator is nil", name)
+	}
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("cannot register action %s: %v", name, v)
+		}
+	}()
+	ActionCreators.RegisterCreator(name, create)
+	return nil
+}
+
 // initAction is dispatched to indicate the start of IPC communication.
 // It's the first action that is dispatched.
 type initAction struct{ ActionType }
@@ -56,6 +108,16 @@ func (a Activate) ClientAction() actions.ClientData {
 
 var Render Action = nil
 
+// Cancel is the action dispatched by the client to abort the in-flight request identified by Cookie.
+//
+// The request's Ctx is cancelled as soon as the Cancel action is received,
+// so reducers and commands should watch Ctx.Done() to stop early.
+type Cancel struct {
+	ActionType
+
+	Cookie string
+}
+
 type QueryCompletions struct{ ActionType }
 
 type QueryCmdCompletions struct {
@@ -70,6 +132,10 @@ type QueryCmdCompletions struct {
 type QueryIssues struct{ ActionType }
 
 // Restart is the action dispatched to initiate a graceful restart of the agent
+//
+// If the agent communicates with its client over stdin and stdout, it restarts itself,
+// handing off its state, including Store.KVMap values stored with a HandoffKey, to the new agent.
+// Otherwise the client is asked to restart the agent.
 type Restart struct{ ActionType }
 
 func (r Restart) ClientAction() actions.ClientData {
@@ -83,6 +149,34 @@ func (s Shutdown) ClientAction() actions.ClientData {
 	return actions.ClientData{Name: "Shutdown"}
 }
 
+// Ping is the client action dispatched to check that the client is still alive.
+// Clients should respond by sending a Pong action.
+//
+// See AgentConfig.HeartbeatTimeout
+type Ping struct{ ActionType }
+
+func (p Ping) ClientAction() actions.ClientData {
+	return actions.ClientData{Name: "Ping"}
+}
+
+// Pong is the action dispatched by the client in response to Ping
+type Pong struct{ ActionType }
+
+// QueueOverflow is the client action dispatched when the request identified by Cookie
+// is dropped because the request queue is full.
+//
+// See AgentConfig.QueueDepth
+type QueueOverflow struct {
+	ActionType
+
+	Cookie  string
+	Actions []string
+}
+
+func (qo QueueOverflow) ClientAction() actions.ClientData {
+	return actions.ClientData{Name: "QueueOverflow", Data: qo}
+}
+
 type QueryTooltips struct {
 	ActionType
 
@@ -104,6 +198,25 @@ type ViewSaved struct{ ActionType }
 
 type ViewLoaded struct{ ActionType }
 
+// ViewClosed is the action dispatched by the client when the view is closed.
+//
+// Reducers may release the resources they hold for the view in Reducer.RViewClosed.
+// After it's handled, the view's Store.ViewState is dropped.
+type ViewClosed struct{ ActionType }
+
+// ClientConnected is the action reduced when a client connects to the agent,
+// before the actions of its first request. See Reducer.RClientConnected
+type ClientConnected struct {
+	ActionType
+
+	// ClientID identifies the client in the agent's logs
+	ClientID int
+}
+
+// ConfigChanged is the action dispatched when State.Config changes, after it was first set.
+// See Reducer.RConfigChanged
+type ConfigChanged struct{ ActionType }
+
 type unmount struct{ ActionType }
 
 type ctxActs struct {
@@ -0,0 +1,45 @@
+package mg
+
+import (
+	"fmt"
+	"github.com/ugorji/go/codec"
+	"margo.sh/mg/actions"
+	"testing"
+	"time"
+)
+
+func TestRegisterAction(t *testing.T) {
+	type testCustomAction struct {
+		ActionType
+		Linter string
+	}
+	// the registry is global, so the name must be unique when the test is run several times
+	name := fmt.Sprintf("TestRegisterAction.Custom%d", time.Now().UnixNano())
+	if err := RegisterAction(name, testCustomAction{}); err != nil {
+		t.Fatalf("RegisterAction(): %s", err)
+	}
+	if err := RegisterAction(name, testCustomAction{}); err == nil {
+		t.Error("RegisterAction() of an already registered action succeeded")
+	}
+	if err := RegisterAction("", testCustomAction{}); err == nil {
+		t.Error("RegisterAction() of an action without a name succeeded")
+	}
+
+	ag := NewTestingAgent(nil, nil, nil)
+	var got Action
+	ag.Store.Use(NewReducer(func(mx *Ctx) *State {
+		if _, ok := mx.Action.(testCustomAction); ok {
+			got = mx.Action
+		}
+		return mx.State
+	}))
+	var data []byte
+	codec.NewEncoderBytes(&data, ag.handle).Encode(map[string]string{"Linter": "vet"})
+	rq := newAgentReq(ag.Store)
+	rq.Actions = []actions.ActionData{{Name: name, Data: data}}
+	rq.finalize(ag)
+	ag.Store.handleReq(rq)
+	if act, ok := got.(testCustomAction); !ok || act.Linter != "vet" {
+		t.Errorf("the reducer got action (%#v); want testCustomAction{Linter: vet}", got)
+	}
+}
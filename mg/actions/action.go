@@ -30,6 +30,21 @@ type ActionData struct {
 
 	// Handle is the handle to use for decoding Data
 	Handle codec.Handle
+
+	// Codec is the name of the codec used to encode Data, if it differs from the request's codec
+	// e.g. cbor, so large binary payloads can be sent as-is inside a request encoded with a text codec.
+	// If set, Data is a byte string, as encoded by the request's codec, holding the encoded value.
+	Codec string
+
+	// Deadline is the time, in UTC and in the same format as the request's Sent field
+	// i.e. `2006-01-02T15:04:05.000000`, by which the client wants the action to be handled.
+	// Once it passes, the Ctx is cancelled and reducers should return best-effort results.
+	Deadline string
+
+	// Async is set if the client wants the action to be handled separately from the rest of the request.
+	// The agent immediately acknowledges it with a token, and sends its result later,
+	// in the final response to a request whose Cookie is the token.
+	Async bool
 }
 
 // Decode decodes the encoded data into the action pointer p.
@@ -2,6 +2,7 @@ package actions
 
 import (
 	"reflect"
+	"sort"
 	"sync"
 )
 
@@ -22,6 +23,19 @@ func (r *Registry) Lookup(name string) ActionCreator {
 	return r.m[name]
 }
 
+// Names returns the sorted list of names of all registered action creators.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	l := make([]string, 0, len(r.m))
+	for k, _ := range r.m {
+		l = append(l, k)
+	}
+	sort.Strings(l)
+	return l
+}
+
 // Register is equivalent of RegisterCreator(name, MakeActionCreator(zero)).
 func (r *Registry) Register(name string, zero Action) *Registry {
 	return r.RegisterCreator(name, MakeActionCreator(zero))
@@ -1,7 +1,6 @@
 package mg
 
 import (
-	"bufio"
 	"fmt"
 	"github.com/ugorji/go/codec"
 	"io"
@@ -9,13 +8,21 @@ import (
 	"margo.sh/mgpf"
 	"margo.sh/mgutil"
 	"os"
+	"reflect"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 )
 
+const (
+	// ipcTimeLayout is the layout of times sent by the client e.g. agentReq.Sent
+	ipcTimeLayout = "2006-01-02T15:04:05.000000"
+)
+
 var (
+	errReqFinished = fmt.Errorf("the final response for this request was already sent")
+
 	// DefaultCodec is the name of the default codec used for IPC communication
 	DefaultCodec = "json"
 
@@ -74,39 +81,335 @@ type AgentConfig struct {
 	// Clients are encouraged to leave it open until the process exits
 	// to allow for logging to keep working during process shutdown
 	Stderr io.Writer
+
+	// Listen is the address on which to listen for client connections
+	// in the form `network:address` e.g. `tcp:127.0.0.1:0`
+	// If network is `ws`, clients connect using WebSocket e.g. from a browser,
+	// sending the Token in the `token` query parameter e.g. `ws://127.0.0.1:9000/?token=...`
+	// If network is `tls` or `wss`, tcp or WebSocket connections are encrypted using a self-signed certificate
+	// generated at startup, whose SHA-256 fingerprint is logged alongside the token so clients can verify it
+	// e.g. when connecting to an agent on a remote machine
+	// Other valid networks are `unix` e.g. `unix:/tmp/margo.sock`
+	// and, on Windows, `npipe` e.g. `npipe:\\.\pipe\margo-1234` which, unlike tcp, don't trigger firewall prompts
+	// If set, Stdin and Stdout are not used for IPC
+	// Several clients may be connected at once, sharing the Store and its caches.
+	// Agent.Run() returns when the last client disconnects (see ReconnectTimeout)
+	Listen string
+
+	// Token is the shared secret that clients must send before communication begins
+	// If empty and Listen is set, a random token is generated
+	Token string
+
+	// Compression is the name of the compression to use for IPC if the client requests it
+	// Valid values are gzip, zstd or none
+	// Default: none
+	Compression string
+
+	// Workers is the number of requests whose actions are decoded, and whose responses are encoded, concurrently
+	// Requests with the same Cookie are always handled, and responded to, in the order they were received
+	// Reductions of the same store are still serialized, so no state update is lost,
+	// and a slow reduction delays the reduction of the requests after it
+	// Default: 1 i.e. requests are handled sequentially
+	Workers int
+
+	// QueueDepth is the maximum number of requests that may be waiting to be handled
+	// When it's exceeded, a waiting request with the same actions from the same client is dropped,
+	// or if there is none, the oldest waiting request.
+	// The client is sent an error response for the dropped request, along with a QueueOverflow client action
+	// Default: 0 i.e. unlimited
+	QueueDepth int
+
+	// HeartbeatTimeout is the amount of time after which a client that sends no requests is considered dead
+	// After half that time without a request, a Ping client action is sent, to which clients should reply with a Pong action
+	// When a client is dead, its in-flight requests are cancelled and it's disconnected,
+	// or if it's communicating over Stdin and Stdout, Agent.Run() returns
+	// Default: 0 i.e. disabled
+	HeartbeatTimeout time.Duration
+
+	// RestartOnRebuild is the interval at which the agent checks whether its executable was rebuilt e.g. by `margo.sh build`
+	// When it is, the agent restarts itself (see Restart) once in-flight requests are finished,
+	// after sending the Restarting client action
+	// Default: 0 i.e. disabled
+	RestartOnRebuild time.Duration
+
+	// WriteTimeout is the amount of time a write to a client may be blocked, because it stopped reading, before it's considered stalled
+	// A stalled client is disconnected, or if it's communicating over Stdin and Stdout, the agent shuts down,
+	// instead of blocking every request waiting to send its response
+	// Default: 0 i.e. disabled
+	WriteTimeout time.Duration
+
+	// IdleTimeout is the amount of time without requests after which an Idle action is dispatched
+	// Reducers may use it to release resources, after which the agent returns unused memory to the OS
+	// Pong actions sent in response to heartbeats don't count as requests
+	// Default: 0 i.e. disabled
+	IdleTimeout time.Duration
+
+	// RecordTo is the name of a file to which all requests and responses are written
+	// The recording can be fed back into a new agent using `margo.sh replay $file` (see Replay)
+	// Default: "" i.e. disabled
+	RecordTo string
+
+	// ReconnectTimeout is the amount of time to wait for a client to connect when the last client disconnects
+	// It only applies when Listen is set, and allows e.g. a crashed editor plugin to reconnect
+	// to the same agent, with its Store and caches intact
+	// Default: 0 i.e. Agent.Run() returns as soon as the last client disconnects
+	ReconnectTimeout time.Duration
+
+	// DebugAddr is the localhost address e.g. `127.0.0.1:6060` on which to serve an HTTP server for debugging the agent
+	// It exposes net/http/pprof profiles, expvar variables including request and action stats,
+	// a JSON dump of the current State at /debug/margo/state
+	// and of the values of Store.KVMap whose types are registered using RegisterKVCodecType at /debug/margo/kv
+	// Default: "" i.e. disabled
+	DebugAddr string
+
+	// MaxRequestSize is the maximum size, in bytes, of a request after decompression
+	// A request that exceeds it is answered with an error response.
+	// With the jsonrpc protocol, the request is skipped. With the margo protocol, the stream
+	// can't be resynchronised, so the client is disconnected after the error response is sent
+	// Default: 0 i.e. unlimited
+	MaxRequestSize int
+
+	// MaxActionDataSize is the maximum size, in bytes, of the encoded Data of each action in a request
+	// Actions that exceed it are not created, and their error is reported in the response
+	// Default: 0 i.e. unlimited
+	MaxActionDataSize int
+
+	// Protocol is the name of the protocol used to frame requests and responses
+	// Valid values are margo or jsonrpc (see ProtocolJSONRPC). jsonrpc requires the json codec
+	// Default: margo
+	Protocol string
+
+	// StateDir is the directory in which parts of the state of each project are saved when the agent shuts down
+	// or the active view moves to another project. They're restored when the agent starts or the project is revisited,
+	// so a restarted agent doesn't start cold.
+	// The saved state includes the environment, the issues stored using StoreIssues
+	// and the Store.KVMap values stored using a HandoffKey.
+	// The stores returned by Store.DiskKV also save their values in it.
+	// Default: "" i.e. disabled
+	StateDir string
+
+	// SharedCache is the address of the unix socket through which agents share the values
+	// of Store.SharedKV e.g. `unix:/tmp/margo-cache.sock`, so agents started by several editor windows
+	// only compute and hold expensive, project-independent values, such as package indexes, once.
+	// The first agent to use it serves the values to the others.
+	// Default: "" i.e. values are not shared with other agents
+	SharedCache string
+
+	// SetupSubAgent is called to set up each sub-agent, as the agent itself was set up
+	// If set, a request may name, in its Agent field, a sub-agent to handle it e.g. one per workspace folder.
+	// Each sub-agent has its own Store and is started when the first request naming it is received.
+	// Its responses are sent over the same connection, with their Agent field set to its name
+	// Default: nil i.e. requests that name a sub-agent are rejected
+	SetupSubAgent func(*Agent)
 }
 
 type agentReq struct {
-	Cookie  string
-	Actions []actions.ActionData
-	Props   clientProps
-	Sent    string
-	Profile *mgpf.Profile
+	Cookie      string
+	Agent       string
+	TraceID     string
+	Actions     []actions.ActionData
+	Props       clientProps
+	Sent        string
+	Profile     *mgpf.Profile
+	Compression string
+	Hello       *Hello
+
+	doneC      chan struct{}
+	cancelOnce *sync.Once
+
+	// client is the client that sent the request
+	client *agentClient
+
+	// finished is set when the final response is sent. It's protected by agentClient.mu
+	finished bool
+
+	// compress is set if the request negotiated compression
+	compress bool
+
+	// hello is the agent's reply to Hello
+	hello *Hello
+
+	// rpcID is the JSON-RPC id of the request when using ProtocolJSONRPC
+	rpcID codec.Raw
+
+	// results holds the result of each action in Actions
+	results []actionResult
+
+	// resultIdx maps the index of each action that was created to its index in results,
+	// or -1 if it wasn't sent by the client
+	resultIdx []int
+
+	// deadlines holds the parsed Deadline of each action in Actions
+	deadlines []time.Time
+
+	// asyncTokens holds the token of each action in Actions that's handled asynchronously. See splitAsync
+	asyncTokens []string
 }
 
 func newAgentReq(kvs KVStore) *agentReq {
 	return &agentReq{
-		Props:   makeClientProps(kvs),
-		Profile: mgpf.NewProfile(""),
+		Props:      makeClientProps(kvs),
+		Profile:    mgpf.NewProfile(""),
+		doneC:      make(chan struct{}),
+		cancelOnce: &sync.Once{},
+	}
+}
+
+// key returns the key identifying the request's Cookie in its client's namespace
+func (rq *agentReq) key() string {
+	return reqKey(rq.client, rq.Cookie)
+}
+
+// cancel closes the done channel shared by all Ctxs created for the request
+func (rq *agentReq) cancel() {
+	rq.cancelOnce.Do(func() {
+		close(rq.doneC)
+	})
+}
+
+// setActionErrors sets the error of the i'th created action to the errors in after that are not in before
+func (rq *agentReq) setActionErrors(i int, before, after StrSet) {
+	j, ok := rq.resultIndex(i)
+	if !ok {
+		return
+	}
+	var errs []string
+	for _, e := range after {
+		if !before.Has(e) {
+			errs = append(errs, e)
+		}
 	}
+	rq.results[j].Error = strings.Join(errs, "\n")
+}
+
+// setActionStack sets the stack trace of the i'th created action, which panicked
+func (rq *agentReq) setActionStack(i int, stack []byte) {
+	if j, ok := rq.resultIndex(i); ok {
+		rq.results[j].Stack = string(stack)
+	}
+}
+
+// resultIndex returns the index in results of the i'th created action.
+// It returns false if the action wasn't sent by the client e.g. ClientConnected.
+func (rq *agentReq) resultIndex(i int) (int, bool) {
+	if i >= len(rq.resultIdx) || rq.resultIdx[i] < 0 {
+		return 0, false
+	}
+	return rq.resultIdx[i], true
 }
 
 func (rq *agentReq) finalize(ag *Agent) {
-	rq.Profile.SetName(rq.Cookie)
-	const layout = "2006-01-02T15:04:05.000000"
-	if t, err := time.ParseInLocation(layout, rq.Sent, time.UTC); err == nil {
+	if rq.TraceID == "" {
+		rq.TraceID = newTraceID()
+	}
+	rq.Profile.SetName(rq.Cookie + " " + tracePrefix + rq.TraceID)
+	if t, err := time.ParseInLocation(ipcTimeLayout, rq.Sent, time.UTC); err == nil {
 		rq.Profile.Sample("ipc|transport", time.Since(t))
 	}
 	rq.Props.finalize(ag)
-	for i, _ := range rq.Actions {
+	rq.deadlines = make([]time.Time, len(rq.Actions))
+	for i, ra := range rq.Actions {
 		rq.Actions[i].Handle = ag.handle
+		if ra.Deadline == "" {
+			continue
+		}
+		t, err := time.ParseInLocation(ipcTimeLayout, ra.Deadline, time.UTC)
+		if err != nil {
+			ag.Log.Printf("ipc: ignoring invalid deadline '%s' of action %s: %s\n", ra.Deadline, ra.Name, err)
+			continue
+		}
+		rq.deadlines[i] = t
+	}
+}
+
+// actionDeadline returns the deadline of the i'th created action, if it has one
+func (rq *agentReq) actionDeadline(i int) (time.Time, bool) {
+	if rq == nil {
+		return time.Time{}, false
 	}
+	j, ok := rq.resultIndex(i)
+	if !ok || j >= len(rq.deadlines) {
+		return time.Time{}, false
+	}
+	t := rq.deadlines[j]
+	return t, !t.IsZero()
 }
 
 type agentRes struct {
 	Cookie string
 	Error  string
 	State  *State
+
+	// Agent is the name of the sub-agent that sent the response, if any. See AgentConfig.SetupSubAgent
+	Agent string
+
+	// TraceID is the trace ID of the request, as sent by the client or generated by the agent.
+	// It's only set in the final response.
+	TraceID string
+
+	// Partial is true if this is an intermediate response sent using Ctx.Stream().
+	// The final response for the request will follow.
+	Partial bool
+
+	// Compression is set in the response to the request that negotiated compression.
+	// All data sent after this response is compressed.
+	Compression string
+
+	// Hello is the agent's Hello, set in response to a request that contained a Hello
+	Hello *Hello
+
+	// Actions holds the result of each action in the request, in the same order.
+	// It's only set in the final response.
+	Actions []actionResult
+
+	// Notifications holds the client actions pushed by the agent using Store.Notify.
+	// Responses that carry notifications have no Cookie and an empty State.
+	Notifications []actions.ClientData
+
+	// Delta is true if State only contains the fields that changed
+	// since the previous response sent to the client. See stateDelta
+	Delta bool
+
+	req *agentReq
+
+	// acts is the list of actions that resulted in the response
+	acts []Action
+
+	// fields is the list of State fields the client cares about. See clientProps.StateFields
+	fields StrSet
+}
+
+// actionResult is the result of handling an action sent by the client
+type actionResult struct {
+	_struct struct{} `codec:",omitempty"`
+
+	// Name is the name of the action
+	Name string
+
+	// Error is the list of errors, separated by newlines, reported while handling the action
+	// It's empty if the action was handled successfully
+	Error string
+
+	// Stack is the stack trace of the panic, if handling the action panicked
+	Stack string
+
+	// Token is set if the action is async. Its result is sent later,
+	// in the final response to a request whose Cookie is Token
+	Token string
+}
+
+// resState is the form in which State is sent to the client
+type resState struct {
+	_struct struct{} `codec:",omitempty"`
+	Profile,
+	Editor,
+	Env struct{}
+
+	State
+	Config        interface{}
+	ClientActions []actions.ClientData
+	Status        []string
+	Issues        IssueSet
 }
 
 func (rs agentRes) finalize() interface{} {
@@ -114,18 +417,7 @@ func (rs agentRes) finalize() interface{} {
 		_struct struct{} `codec:",omitempty"`
 
 		agentRes
-		State struct {
-			_struct struct{} `codec:",omitempty"`
-			Profile,
-			Editor,
-			Env struct{}
-
-			State
-			Config        interface{}
-			ClientActions []actions.ClientData
-			Status        []string
-			Issues        IssueSet
-		}
+		State resState
 	}{}
 
 	out.agentRes = rs
@@ -133,9 +425,17 @@ func (rs agentRes) finalize() interface{} {
 		return out
 	}
 
-	out.State.State = *rs.State
-	inSt := &out.State.State
-	outSt := &out.State
+	out.State = rs.finalizeState()
+	if out.Error == "" {
+		out.Error = strings.Join([]string(rs.State.Errors), "\n")
+	}
+	return out
+}
+
+// finalizeState returns rs.State in the form in which it's sent to the client
+func (rs agentRes) finalizeState() resState {
+	outSt := resState{State: *rs.State}
+	inSt := &outSt.State
 
 	outSt.Issues = outSt.Issues.merge(outSt.View, inSt.Issues...)
 
@@ -146,10 +446,6 @@ func (rs agentRes) finalize() interface{} {
 
 	outSt.ClientActions = inSt.clientActions
 
-	if out.Error == "" {
-		out.Error = strings.Join([]string(outSt.Errors), "\n")
-	}
-
 	if outSt.View.changed == 0 {
 		outSt.View = nil
 	}
@@ -158,7 +454,15 @@ func (rs agentRes) finalize() interface{} {
 		outSt.Config = ec.EditorConfig()
 	}
 
-	return out
+	if len(rs.fields) != 0 {
+		for _, f := range encodedFields(reflect.ValueOf(&outSt).Elem()) {
+			if f.name != "ClientActions" && !rs.fields.Has(f.name) {
+				f.val.Set(reflect.Zero(f.val.Type()))
+			}
+		}
+	}
+
+	return outSt
 }
 
 type Agent struct {
@@ -167,17 +471,45 @@ type Agent struct {
 	Log   *Logger
 	Store *Store
 
-	mu sync.Mutex
-
 	stdin  io.ReadCloser
 	stdout io.WriteCloser
 	stderr io.Writer
 
-	handle codec.Handle
-	enc    *codec.Encoder
-	encWr  *bufio.Writer
-	dec    *codec.Decoder
-	wg     sync.WaitGroup
+	listen            agentListener
+	compression       string
+	workers           int
+	queueDepth        int
+	heartbeatTimeout  time.Duration
+	idleTimeout       time.Duration
+	restartOnRebuild  time.Duration
+	writeTimeout      time.Duration
+	reconnectTimeout  time.Duration
+	debugAddr         string
+	protocol          string
+	maxRequestSize    int
+	maxActionDataSize int
+	stateDir          string
+	queue             *agentReqQueue `mg.Nillable:"true"`
+	reqs              agentReqs
+	handle            codec.Handle
+	client            *agentClient
+	recorder          *recorder `mg.Nillable:"true"`
+	logs              *logForwarder
+	clients           agentClients
+	wg                sync.WaitGroup
+
+	// lastActive is the time, in unix nanoseconds, the agent last received a request
+	lastActive int64
+
+	subs subAgents
+
+	// parent is the agent that started this sub-agent, and subName is its name
+	parent  *Agent `mg.Nillable:"true"`
+	subName string
+
+	// stdio is set if the agent communicates with its client over the process' stdin and stdout
+	stdio   bool
+	handoff agentHandoff
 
 	sd struct {
 		mu     sync.Mutex
@@ -190,70 +522,256 @@ type Agent struct {
 // Run starts the Agent's event loop. It returns immediately on the first error.
 func (ag *Agent) Run() error {
 	defer ag.shutdown()
-	return ag.communicate()
+	defer ag.guardCrash()
+
+	if ag.listen.network != "" {
+		return ag.serve()
+	}
+
+	defer ag.start()()
+	return ag.communicate(ag.client)
 }
 
-func (ag *Agent) communicate() error {
+// start mounts the store and starts handling requests.
+// The returned function must be called once all clients are done.
+func (ag *Agent) start() (stop func()) {
 	sto := ag.Store
 	unsub := sto.Subscribe(ag.sub)
-	defer unsub()
 
 	sto.mount()
+	stopDebug := ag.startDebugServer()
+	stopIdle := ag.monitorIdle()
+	stopRebuild := ag.watchRebuild()
+	stopSweep := ag.sweepKV()
+	stopMemPressure := ag.monitorMemPressure()
+
+	if ag.workers > 1 || ag.queueDepth > 0 {
+		workers := ag.workers
+		if workers < 1 {
+			workers = 1
+		}
+		ag.queue = newAgentReqQueue(workers, ag.queueDepth, ag.handleQueuedReq, ag.overflowReq)
+	}
+
+	return func() {
+		if ag.queue != nil {
+			ag.queue.close()
+		}
+		// wait for in-flight requests so their responses are still sent
+		ag.wg.Wait()
+		ag.subs.stop()
+		stopSweep()
+		stopMemPressure()
+		stopRebuild()
+		stopIdle()
+		stopDebug()
+		sto.sharedKV.close()
+		unsub()
+	}
+}
+
+// communicate reads and handles requests from client c until it disconnects
+// or stops responding to heartbeats.
+func (ag *Agent) communicate(c *agentClient) error {
+	defer ag.heartbeat(c)()
+	defer ag.monitorWrites(c)()
+
+	errC := make(chan error, 1)
+	go func() {
+		defer ag.guardCrash()
+		errC <- ag.readReqs(c)
+	}()
+	select {
+	case err := <-errC:
+		return err
+	case <-c.deadC:
+		return c.deadErr
+	}
+}
 
+// readReqs reads and handles requests from client c until it disconnects
+func (ag *Agent) readReqs(c *agentClient) error {
 	for {
-		rq := newAgentReq(sto)
-		if err := ag.dec.Decode(rq); err != nil {
+		rq := newAgentReq(ag.Store)
+		rq.client = c
+		if err := c.decodeReq(rq); err != nil {
 			if err == io.EOF {
 				return nil
 			}
+			if c.limRd.exceeded {
+				err = reqSizeError{max: ag.maxRequestSize}
+				ag.rejectReq(c, rq, err)
+			}
 			return fmt.Errorf("ipc.decode: %s", err)
 		}
+		if c.dead() {
+			return nil
+		}
+		c.seen()
+		if !rq.keepsAlive() {
+			ag.active()
+		}
+		c.setStateFields(rq.Props.StateFields)
+		c.setCapabilities(rq.Props.Capabilities)
+
+		if ag.handoffPending() {
+			ag.handoffRestart(c)
+		}
 
 		rq.finalize(ag)
-		ag.handleReq(rq)
+		if err := c.greet(rq); err != nil {
+			return err
+		}
+		c.negotiateCompression(rq)
+		async := ag.splitAsync(rq)
+		if len(async) != 0 && len(async) == len(rq.Actions) {
+			ag.ackAsync(rq)
+		} else {
+			ag.routeReq(rq)
+		}
+		for _, arq := range async {
+			ag.routeReq(arq)
+		}
+
+		if rq.compress {
+			if err := c.decompressRequests(); err != nil {
+				return err
+			}
+		}
 	}
 }
 
 func (ag *Agent) handleReq(rq *agentReq) {
 	rq.Profile.Push("queue.wait")
 	ag.wg.Add(1)
-	ag.Store.dsp.hi <- func() {
-		defer ag.wg.Done()
-		rq.Profile.Pop()
+	ag.cancelReqs(rq)
+	ag.reqs.track(rq)
+	if ag.queue != nil {
+		if !ag.queue.put(rq) {
+			ag.reqs.untrack(rq)
+			ag.wg.Done()
+		}
+		return
+	}
+	ag.Store.dsp.hi <- func() { ag.handleQueuedReq(rq) }
+}
+
+func (ag *Agent) handleQueuedReq(rq *agentReq) {
+	defer ag.wg.Done()
+	defer ag.reqs.untrack(rq)
+	defer func() {
+		if v := recover(); v != nil {
+			ag.reqPanicked(rq, newPanicError(v))
+		}
+	}()
+	rq.Profile.Pop()
+
+	ag.Store.handleReq(rq)
+}
 
-		ag.Store.handleReq(rq)
+// overflowReq finishes rq, which was dropped from the queue, with an error response
+func (ag *Agent) overflowReq(rq *agentReq) {
+	defer ag.wg.Done()
+	defer ag.reqs.untrack(rq)
+	rq.Profile.Pop()
+
+	qo := QueueOverflow{Cookie: rq.Cookie}
+	rq.results = make([]actionResult, len(rq.Actions))
+	for i, ra := range rq.Actions {
+		qo.Actions = append(qo.Actions, ra.Name)
+		rq.results[i] = actionResult{Name: ra.Name, Error: "dropped: request queue overflow"}
 	}
+	ag.Log.Printf("ipc.queue: overflow: dropped request %s with actions %v\n", rq.Cookie, qo.Actions)
+
+	mx := ag.Store.NewCtx(nil)
+	ag.send(agentRes{
+		Cookie: rq.Cookie,
+		Error:  "request dropped because the request queue is full",
+		State:  mx.addClientActions(qo),
+		req:    rq,
+	})
 }
 
-func (ag *Agent) createAction(d actions.ActionData) (Action, error) {
-	if create := ActionCreators.Lookup(d.Name); create != nil {
-		return create(d)
+func (ag *Agent) createAction(d actions.ActionData) (act Action, err error) {
+	create := ActionCreators.Lookup(d.Name)
+	if create == nil {
+		return nil, fmt.Errorf("Unknown action: %s", d.Name)
+	}
+	if err := ag.checkActionSize(d); err != nil {
+		return nil, err
 	}
-	return nil, fmt.Errorf("Unknown action: %s", d.Name)
+	d, err = unwrapActionData(d)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if v := recover(); v != nil {
+			act, err = nil, newPanicError(v)
+		}
+	}()
+	return create(d)
+}
+
+// unwrapActionData returns d with its Data replaced by the value encoded using d.Codec, if set
+func unwrapActionData(d actions.ActionData) (actions.ActionData, error) {
+	if d.Codec == "" {
+		return d, nil
+	}
+	h := codecHandles[d.Codec]
+	if h == nil {
+		return d, fmt.Errorf("Invalid action data codec '%s'. Expected %s", d.Codec, CodecNamesStr)
+	}
+	var p []byte
+	if err := codec.NewDecoderBytes(d.Data, d.Handle).Decode(&p); err != nil {
+		return d, fmt.Errorf("cannot decode %s action data: %s", d.Codec, err)
+	}
+	d.Data, d.Handle = p, h
+	return d, nil
 }
 
 func (ag *Agent) sub(mx *Ctx) {
-	err := ag.send(agentRes{
+	ag.Store.status.observeErrors(mx.State.Errors)
+	ag.send(agentRes{
 		State:  mx.State,
 		Cookie: mx.Cookie,
+		req:    mx.req,
+		acts:   mx.Acts.List(),
 	})
-	if err != nil {
-		ag.Log.Println("agent.send failed. shutting down ipc:", err)
-		go ag.shutdown()
-	}
 }
 
+// send sends res to the client that sent its request.
+// Responses that aren't part of a request are sent to all clients.
 func (ag *Agent) send(res agentRes) error {
-	ag.mu.Lock()
-	defer ag.mu.Unlock()
+	res.Agent = ag.subName
+	if rq := res.req; rq != nil {
+		c := rq.client
+		if c == nil {
+			c = ag.client
+		}
+		return ag.sendTo(c, res)
+	}
+	clients := &ag.clients
+	if ag.parent != nil {
+		clients = &ag.parent.clients
+	}
+	for _, c := range clients.list() {
+		ag.sendTo(c, res)
+	}
+	return nil
+}
 
-	defer ag.encWr.Flush()
-	return ag.enc.Encode(res.finalize())
+func (ag *Agent) sendTo(c *agentClient, res agentRes) error {
+	err := c.send(res)
+	if err != nil && err != errReqFinished && err != errClientStalled {
+		c.fail(err)
+	}
+	return err
 }
 
 // shutdown sequence:
 // * stop incoming requests
-// * wait for all reqs to complete
+// * cancel background jobs
+// * wait for all reqs and jobs to complete
 // * tell reducers to unmount
 // * stop outgoing responses
 // * tell the world we're done
@@ -267,11 +785,29 @@ func (ag *Agent) shutdown() {
 	}
 	sd.closed = true
 
+	clients := ag.clients.list()
+
 	// defers because we want *some* guarantee that all these steps will be taken
 	defer close(sd.done)
+	defer func() {
+		if ag.recorder != nil {
+			ag.recorder.close()
+		}
+	}()
 	defer ag.stdout.Close()
+	defer func() {
+		for _, c := range clients {
+			c.stdout.Close()
+		}
+	}()
 	defer ag.Store.unmount()
 	defer ag.wg.Wait()
+	defer ag.Store.jobs.shutdown()
+	defer func() {
+		for _, c := range clients {
+			c.stdin.Close()
+		}
+	}()
 	defer ag.stdin.Close()
 }
 
@@ -286,38 +822,35 @@ func NewAgent(cfg AgentConfig) (*Agent, error) {
 	var err error
 	done := make(chan struct{})
 	ag := &Agent{
-		Name:   cfg.AgentName,
-		Done:   done,
-		stdin:  cfg.Stdin,
-		stdout: cfg.Stdout,
-		stderr: cfg.Stderr,
-		handle: codecHandles[cfg.Codec],
+		Name:              cfg.AgentName,
+		Done:              done,
+		stderr:            cfg.Stderr,
+		handle:            codecHandles[cfg.Codec],
+		workers:           cfg.Workers,
+		queueDepth:        cfg.QueueDepth,
+		heartbeatTimeout:  cfg.HeartbeatTimeout,
+		idleTimeout:       cfg.IdleTimeout,
+		restartOnRebuild:  cfg.RestartOnRebuild,
+		writeTimeout:      cfg.WriteTimeout,
+		reconnectTimeout:  cfg.ReconnectTimeout,
+		maxRequestSize:    cfg.MaxRequestSize,
+		maxActionDataSize: cfg.MaxActionDataSize,
+		stateDir:          cfg.StateDir,
 	}
+	ag.subs.cfg = cfg
+	ag.subs.setup = cfg.SetupSubAgent
 	ag.sd.done = done
-	if ag.stdin == nil {
-		ag.stdin = os.Stdin
-	}
-	if ag.stdout == nil {
-		ag.stdout = os.Stdout
-	}
 	if ag.stderr == nil {
 		ag.stderr = os.Stderr
 	}
-	ag.stdin = &mgutil.IOWrapper{
-		Locker: &sync.Mutex{},
-		Reader: ag.stdin,
-		Closer: ag.stdin,
-	}
-	ag.stdout = &mgutil.IOWrapper{
-		Locker: &sync.Mutex{},
-		Writer: ag.stdout,
-		Closer: ag.stdout,
-	}
 	ag.stderr = &mgutil.IOWrapper{
 		Locker: &sync.Mutex{},
 		Writer: ag.stderr,
 	}
 	ag.Log = NewLogger(ag.stderr)
+	ag.logs = newLogForwarder(ag)
+	ag.Log.Logger.SetOutput(ag.logs.writer(ag.stderr, "info", ag.Log.Prefix()))
+	ag.Log.Dbg.SetOutput(ag.logs.writer(ag.stderr, "debug", ag.Log.Dbg.Prefix()))
 
 	ag.Store = newStore(ag, ag.sub)
 	dr := DefaultReducers
@@ -337,13 +870,98 @@ func NewAgent(cfg AgentConfig) (*Agent, error) {
 		err = fmt.Errorf("Invalid codec '%s'. Expected %s", cfg.Codec, CodecNamesStr)
 		ag.handle = codecHandles[DefaultCodec]
 	}
-	ag.encWr = bufio.NewWriter(ag.stdout)
-	ag.enc = codec.NewEncoder(ag.encWr, ag.handle)
-	ag.dec = codec.NewDecoder(bufio.NewReader(ag.stdin), ag.handle)
+
+	if name, e := parseCompression(cfg.Compression); e != nil {
+		if err == nil {
+			err = e
+		}
+	} else {
+		ag.compression = name
+	}
+
+	if addr, e := parseDebugAddr(cfg.DebugAddr); e != nil {
+		if err == nil {
+			err = e
+		}
+	} else {
+		ag.debugAddr = addr
+	}
+
+	if name, e := parseProtocol(cfg.Protocol, cfg.Codec); e != nil {
+		if err == nil {
+			err = e
+		}
+	} else {
+		ag.protocol = name
+	}
+
+	if cfg.RecordTo != "" {
+		codecName := cfg.Codec
+		if codecName == "" || codecHandles[codecName] != ag.handle {
+			codecName = DefaultCodec
+		}
+		rec, e := newRecorder(cfg.RecordTo, codecName, ag.protocol)
+		if e != nil && err == nil {
+			err = e
+		}
+		ag.recorder = rec
+	}
+
+	if addr, e := parseSharedCache(cfg.SharedCache); e != nil {
+		if err == nil {
+			err = e
+		}
+	} else {
+		ag.Store.sharedKV.addr = addr
+	}
+
+	if cfg.Listen != "" {
+		ln, e := newAgentListener(cfg.Listen, cfg.Token)
+		if e != nil && err == nil {
+			err = e
+		}
+		ag.listen = ln
+	}
+
+	stdin, stdout := cfg.Stdin, cfg.Stdout
+	if stdin == nil {
+		stdin = os.Stdin
+	}
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	ag.stdio = stdin == os.Stdin && stdout == os.Stdout && ag.listen.network == ""
+	if rd, e := ag.restoreHandoff(stdin); e != nil {
+		if err == nil {
+			err = e
+		}
+	} else {
+		stdin = rd
+	}
+	ag.stdin, ag.stdout = wrapIPC(stdin, stdout)
+	ag.client = newAgentClient(ag, ag.stdin, ag.stdout)
+	if ag.listen.network == "" {
+		ag.clients.add(ag.client)
+	}
 
 	return ag, err
 }
 
+// wrapIPC wraps the streams used to communicate with a client
+//
+// stdin is not locked because it's only read by one goroutine
+// and it must be possible to close it while a read is blocked.
+func wrapIPC(stdin io.ReadCloser, stdout io.WriteCloser) (io.ReadCloser, io.WriteCloser) {
+	return &mgutil.IOWrapper{
+		Reader: stdin,
+		Closer: stdin,
+	}, &mgutil.IOWrapper{
+		Locker: &sync.Mutex{},
+		Writer: stdout,
+		Closer: stdout,
+	}
+}
+
 // Args returns a new copy of agent's Args.
 func (ag *Agent) Args() Args {
 	return Args{
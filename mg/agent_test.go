@@ -1,7 +1,10 @@
 package mg
 
 import (
+	"bytes"
+	"github.com/ugorji/go/codec"
 	"io"
+	"margo.sh/mg/actions"
 	"margo.sh/mgutil"
 	"os"
 	"strings"
@@ -140,3 +143,90 @@ func TestAgentShutdown(t *testing.T) {
 		t.Error("ag.sd.closed = (true); want (false)")
 	}
 }
+
+func TestAgentActionResults(t *testing.T) {
+	out := &bytes.Buffer{}
+	ag, err := NewAgent(AgentConfig{
+		Stdin:  &mgutil.IOWrapper{},
+		Stdout: &mgutil.IOWrapper{Writer: out},
+		Stderr: &mgutil.IOWrapper{},
+		Codec:  "msgpack",
+	})
+	if err != nil {
+		t.Fatalf("agent creation failed: %s", err)
+	}
+	ag.Store.Subscribe(ag.sub)
+	ag.Store.Use(NewReducer(func(mx *Ctx) *State {
+		if _, ok := mx.Action.(QueryCompletions); ok {
+			return mx.AddErrorf("completion failed")
+		}
+		return mx.State
+	}))
+
+	rq := newAgentReq(ag.Store)
+	rq.Cookie = "batch"
+	rq.Actions = []actions.ActionData{
+		{Name: "QueryIssues"},
+		{Name: "NoSuchAction"},
+		{Name: "QueryCompletions"},
+	}
+	rq.finalize(ag)
+	ag.Store.handleReq(rq)
+
+	var res struct {
+		Actions []struct{ Name, Error string }
+	}
+	if err := codec.NewDecoder(out, ag.handle).Decode(&res); err != nil {
+		t.Fatalf("dec.Decode(): %s", err)
+	}
+	if len(res.Actions) != len(rq.Actions) {
+		t.Fatalf("len(res.Actions) = (%d); want (%d)", len(res.Actions), len(rq.Actions))
+	}
+	for i, ra := range res.Actions {
+		failed := ra.Error != ""
+		if ra.Name != rq.Actions[i].Name || failed != (i != 0) {
+			t.Errorf("res.Actions[%d] = (%+v); want Name (%s), failed (%v)", i, ra, rq.Actions[i].Name, i != 0)
+		}
+	}
+	if e := res.Actions[2].Error; e != "completion failed" {
+		t.Errorf("res.Actions[2].Error = (%s); want (completion failed)", e)
+	}
+}
+
+func TestAgentStateFields(t *testing.T) {
+	out := &bytes.Buffer{}
+	ag, err := NewAgent(AgentConfig{
+		Stdin:  &mgutil.IOWrapper{},
+		Stdout: &mgutil.IOWrapper{Writer: out},
+		Stderr: &mgutil.IOWrapper{},
+		Codec:  "msgpack",
+	})
+	if err != nil {
+		t.Fatalf("agent creation failed: %s", err)
+	}
+	c := ag.client
+	c.setStateFields([]string{"Status"})
+
+	st := ag.Store.NewCtx(nil).State.AddStatus("status").AddErrorf("error").addClientActions(Ping{})
+	if err := ag.sendTo(c, agentRes{State: st}); err != nil {
+		t.Fatalf("ag.sendTo() = (%v); want (nil)", err)
+	}
+	res := struct {
+		Error string
+		State map[string]interface{}
+	}{}
+	if err := codec.NewDecoder(out, ag.handle).Decode(&res); err != nil {
+		t.Fatalf("dec.Decode(): %s", err)
+	}
+	for _, k := range []string{"Status", "ClientActions"} {
+		if _, ok := res.State[k]; !ok {
+			t.Errorf("State.%s was not sent", k)
+		}
+	}
+	if _, ok := res.State["Errors"]; ok {
+		t.Errorf("State.Errors was sent; want it omitted")
+	}
+	if res.Error != "error" {
+		t.Errorf("res.Error = (%s); want (error)", res.Error)
+	}
+}
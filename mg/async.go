@@ -0,0 +1,63 @@
+package mg
+
+import (
+	"margo.sh/mg/actions"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// asyncSeq is used to generate unique tokens for async actions
+var asyncSeq uint64
+
+// splitAsync moves each action in rq that's marked Async into a request of its own,
+// so that it's handled, and responded to, independently of the rest of rq.
+//
+// The Cookie of each returned request is the token reported in the result of its action.
+func (ag *Agent) splitAsync(rq *agentReq) []*agentReq {
+	var l []*agentReq
+	for i, ra := range rq.Actions {
+		if !ra.Async {
+			continue
+		}
+		if rq.asyncTokens == nil {
+			rq.asyncTokens = make([]string, len(rq.Actions))
+		}
+		tok := rq.Cookie + "/async-" + strconv.FormatUint(atomic.AddUint64(&asyncSeq, 1), 10)
+		rq.asyncTokens[i] = tok
+
+		arq := newAgentReq(ag.Store)
+		arq.Cookie = tok
+		arq.Agent = rq.Agent
+		arq.TraceID = rq.TraceID
+		arq.Sent = rq.Sent
+		arq.client = rq.client
+		arq.Props = rq.Props
+		if v := rq.Props.View; v != nil {
+			// the view is finalized when the request is handled so it can't be shared
+			arq.Props.View = v.Copy()
+		}
+		arq.Actions = []actions.ActionData{ra}
+		arq.deadlines = []time.Time{rq.deadlines[i]}
+		arq.Profile.SetName(tok + " " + tracePrefix + rq.TraceID)
+		l = append(l, arq)
+	}
+	return l
+}
+
+// asyncToken returns the token of the i'th action in Actions if it's handled asynchronously
+func (rq *agentReq) asyncToken(i int) string {
+	if i < len(rq.asyncTokens) {
+		return rq.asyncTokens[i]
+	}
+	return ""
+}
+
+// ackAsync sends the final response to rq, all of whose actions are handled asynchronously
+func (ag *Agent) ackAsync(rq *agentReq) {
+	rq.results = make([]actionResult, len(rq.Actions))
+	for i, ra := range rq.Actions {
+		rq.results[i] = actionResult{Name: ra.Name, Token: rq.asyncToken(i)}
+	}
+	ag.send(agentRes{Cookie: rq.Cookie, req: rq})
+}
@@ -0,0 +1,74 @@
+package mg
+
+import (
+	"bytes"
+	"github.com/ugorji/go/codec"
+	"margo.sh/mg/actions"
+	"margo.sh/mgutil"
+	"testing"
+)
+
+func TestAgentAsyncActions(t *testing.T) {
+	out := &bytes.Buffer{}
+	ag, err := NewAgent(AgentConfig{
+		Stdin:  &mgutil.IOWrapper{},
+		Stdout: &mgutil.IOWrapper{Writer: out},
+		Stderr: &mgutil.IOWrapper{},
+		Codec:  "msgpack",
+	})
+	if err != nil {
+		t.Fatalf("agent creation failed: %s", err)
+	}
+	ag.Store.Subscribe(ag.sub)
+
+	type result struct {
+		Cookie  string
+		Actions []struct{ Name, Error, Token string }
+	}
+	dec := codec.NewDecoder(out, ag.handle)
+	decode := func() result {
+		var res result
+		if err := dec.Decode(&res); err != nil {
+			t.Fatalf("dec.Decode(): %s", err)
+		}
+		return res
+	}
+
+	rq := newAgentReq(ag.Store)
+	rq.Cookie = "mixed"
+	rq.Actions = []actions.ActionData{
+		{Name: "QueryIssues"},
+		{Name: "QueryCompletions", Async: true},
+	}
+	rq.finalize(ag)
+	async := ag.splitAsync(rq)
+	if len(async) != 1 {
+		t.Fatalf("len(splitAsync()) = (%d); want (1)", len(async))
+	}
+	ag.Store.handleReq(rq)
+	res := decode()
+	if res.Cookie != "mixed" || len(res.Actions) != 2 {
+		t.Fatalf("response = (%+v); want the results of both actions", res)
+	}
+	tok := res.Actions[1].Token
+	if res.Actions[0].Token != "" || tok == "" || tok != async[0].Cookie {
+		t.Fatalf("response.Actions = (%+v); want a token for the async action only", res.Actions)
+	}
+
+	ag.Store.handleReq(async[0])
+	res = decode()
+	if res.Cookie != tok || len(res.Actions) != 1 || res.Actions[0].Name != "QueryCompletions" || res.Actions[0].Token != "" {
+		t.Errorf("deferred response = (%+v); want the result of QueryCompletions with Cookie %s", res, tok)
+	}
+
+	rq = newAgentReq(ag.Store)
+	rq.Cookie = "async"
+	rq.Actions = []actions.ActionData{{Name: "QueryIssues", Async: true}}
+	rq.finalize(ag)
+	async = ag.splitAsync(rq)
+	ag.ackAsync(rq)
+	res = decode()
+	if res.Cookie != "async" || len(res.Actions) != 1 || res.Actions[0].Token != async[0].Cookie {
+		t.Errorf("ack = (%+v); want the token %s", res, async[0].Cookie)
+	}
+}
@@ -0,0 +1,51 @@
+package mg
+
+import (
+	"reflect"
+	"runtime"
+	"time"
+)
+
+// AsyncReduceFn is the body of a reducer created using AsyncReduce.
+// It returns the actions to dispatch once it's done.
+type AsyncReduceFn func(mx *Ctx) []Action
+
+// AsyncReduce returns a reducer that calls fn as a job (see Jobs.Submit), instead of blocking the reduction,
+// then dispatches the actions it returns.
+//
+// fn is called with a snapshot of the Ctx that isn't cancelled when the request that triggered it is done,
+// so it should only use the Ctx to read the state, and report its results through the returned actions.
+// The Ctx is cancelled if the job is cancelled e.g. when the agent shuts down.
+// The agent waits for pending calls to return before the Store is unmounted,
+// so fn may use resources released in RUnmount.
+// If fn panics, the panic is logged and no actions are dispatched.
+//
+// Each function in options is called on the returned RFunc e.g. to set its Cond or Label.
+// Its Func must not be changed.
+func AsyncReduce(fn AsyncReduceFn, options ...func(*RFunc)) *RFunc {
+	var rf *RFunc
+	rf = NewReducer(func(mx *Ctx) *State {
+		mx.Store.goAsync(mx, rf.Label, fn)
+		return mx.State
+	}, options...)
+	if rf.Label == "" {
+		nm := ""
+		if p := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()); p != nil {
+			nm = p.Name()
+		}
+		rf.Label = "mg.AsyncReduce(" + nm + ")"
+	}
+	return rf
+}
+
+// goAsync submits the job name, that calls fn with a snapshot of mx and dispatches the actions it returns
+func (sto *Store) goAsync(mx *Ctx, name string, fn AsyncReduceFn) {
+	mx = mx.Copy(func(mx *Ctx) {
+		mx.req, mx.deadline = nil, time.Time{}
+	})
+	mx.Jobs.Submit(name, func(jx *JobCtx) []Action {
+		return fn(mx.Copy(func(mx *Ctx) {
+			mx.doneC, mx.cancelOnce = jx.doneC, jx.cancelOnce
+		}))
+	})
+}
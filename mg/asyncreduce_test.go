@@ -0,0 +1,55 @@
+package mg
+
+import (
+	"margo.sh/mg/actions"
+	"testing"
+	"time"
+)
+
+func TestAsyncReduce(t *testing.T) {
+	type asyncDone struct {
+		ActionType
+		Cookie string
+	}
+	ag := NewTestingAgent(nil, nil, nil)
+	release := make(chan struct{})
+	var canceled bool
+	ag.Store.Use(AsyncReduce(func(mx *Ctx) []Action {
+		if !mx.ActionIs(QueryIssues{}) {
+			return nil
+		}
+		<-release
+		canceled = mx.Err() != nil
+		return []Action{asyncDone{Cookie: mx.Cookie}}
+	}))
+	doneC := make(chan asyncDone, 1)
+	ag.Store.Use(NewReducer(func(mx *Ctx) *State {
+		if act, ok := mx.Action.(asyncDone); ok {
+			doneC <- act
+		}
+		return mx.State
+	}))
+
+	rq := newAgentReq(ag.Store)
+	rq.Cookie = "async-reduce"
+	rq.Actions = []actions.ActionData{{Name: "QueryIssues"}}
+	rq.finalize(ag)
+	ag.Store.handleReq(rq)
+	// the reduction doesn't wait for the async reducer, and the request's end doesn't cancel its Ctx
+	rq.cancel()
+	close(release)
+	ag.wg.Wait()
+
+	select {
+	case f := <-ag.Store.dsp.lo:
+		f()
+	case <-time.After(5 * time.Second):
+		t.Fatal("the async reducer's action wasn't dispatched")
+	}
+	if act := <-doneC; act.Cookie != rq.Cookie {
+		t.Errorf("dispatched action = (%+v); want Cookie (%s)", act, rq.Cookie)
+	}
+	if canceled {
+		t.Error("the async reducer's Ctx was cancelled with its request")
+	}
+}
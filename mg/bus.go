@@ -0,0 +1,92 @@
+package mg
+
+import (
+	"reflect"
+	"sync"
+)
+
+// eventBus delivers the events published using Store.Publish to the listeners added using Listen
+type eventBus struct {
+	mu   sync.Mutex
+	subs []*eventSub
+}
+
+type eventSub struct {
+	typ reflect.Type
+	fn  func(interface{})
+}
+
+// accepts returns true if the listener should receive events of type typ
+func (es *eventSub) accepts(typ reflect.Type) bool {
+	if es.typ.Kind() == reflect.Interface {
+		return typ.Implements(es.typ)
+	}
+	return typ == es.typ
+}
+
+// Publish delivers the event ev to the listeners of its type, added using Listen.
+//
+// The event bus lets reducers announce events e.g. that the module graph changed,
+// to other reducers without knowing about them, or reaching into their data.
+// Events are usually structs defined in the package of the reducer that publishes them.
+//
+// Listeners are called synchronously, in the order they were added.
+// If a listener panics, the panic is logged and the other listeners are still called.
+func (sto *Store) Publish(ev interface{}) {
+	if ev == nil {
+		return
+	}
+	typ := reflect.TypeOf(ev)
+
+	bus := &sto.bus
+	bus.mu.Lock()
+	subs := bus.subs
+	bus.mu.Unlock()
+
+	for _, es := range subs {
+		if es.accepts(typ) {
+			sto.deliver(es, ev)
+		}
+	}
+}
+
+func (sto *Store) deliver(es *eventSub, ev interface{}) {
+	defer func() {
+		if v := recover(); v != nil {
+			pe := newPanicError(v)
+			sto.ag.Log.Printf("Listen(%s): %s\n%s", es.typ, pe, pe.stack)
+		}
+	}()
+	es.fn(ev)
+}
+
+// Listen arranges for fn to be called with each event of type T published using Store.Publish.
+// If T is an interface type, fn is called with each event whose type implements it.
+//
+// fn is called on the goroutine that published the event, so it should return quickly,
+// and be safe for concurrent use.
+// Reducers usually start listening in RMount and call the returned function, to stop listening, in RUnmount.
+func Listen[T any](sto *Store, fn func(T)) (unlisten func()) {
+	es := &eventSub{
+		typ: reflect.TypeOf((*T)(nil)).Elem(),
+		fn:  func(ev interface{}) { fn(ev.(T)) },
+	}
+
+	bus := &sto.bus
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+
+	bus.subs = append(bus.subs[:len(bus.subs):len(bus.subs)], es)
+	return func() {
+		bus.mu.Lock()
+		defer bus.mu.Unlock()
+
+		l := make([]*eventSub, 0, len(bus.subs))
+		for _, p := range bus.subs {
+			if p != es {
+				l = append(l, p)
+			}
+		}
+		bus.subs = l
+	}
+}
@@ -0,0 +1,28 @@
+package mg
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type testEvent struct{ Name string }
+
+func (te testEvent) String() string { return "event:" + te.Name }
+
+func TestEventBus(t *testing.T) {
+	sto := NewTestingStore()
+	var got []string
+	unlisten := Listen(sto, func(ev testEvent) { got = append(got, ev.Name) })
+	Listen(sto, func(ev testEvent) { panic("listener panicked") })
+	Listen(sto, func(ev fmt.Stringer) { got = append(got, ev.String()) })
+	Listen(sto, func(ev int) { got = append(got, fmt.Sprint("int:", ev)) })
+
+	sto.Publish(testEvent{Name: "a"})
+	unlisten()
+	sto.Publish(testEvent{Name: "b"})
+	sto.Publish(nil)
+	if s, want := strings.Join(got, " "), "a event:a event:b"; s != want {
+		t.Errorf("the listeners received (%s); want (%s)", s, want)
+	}
+}
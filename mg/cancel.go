@@ -0,0 +1,100 @@
+package mg
+
+import (
+	"sync"
+)
+
+// agentReqs tracks in-flight requests so they can be cancelled by the client
+type agentReqs struct {
+	mu sync.Mutex
+	m  map[string][]*agentReq
+
+	// total is the number of requests tracked so far
+	total int64
+}
+
+// track arranges for rq to be cancelled by a Cancel action with its Cookie from the same client
+func (ar *agentReqs) track(rq *agentReq) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	if ar.m == nil {
+		ar.m = map[string][]*agentReq{}
+	}
+	ar.m[rq.key()] = append(ar.m[rq.key()], rq)
+	ar.total++
+}
+
+// stats returns the number of in-flight requests and the total number of requests tracked so far
+func (ar *agentReqs) stats() (inFlight int, total int64) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	for _, l := range ar.m {
+		inFlight += len(l)
+	}
+	return inFlight, ar.total
+}
+
+// untrack removes rq from the list of in-flight requests
+func (ar *agentReqs) untrack(rq *agentReq) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	l := ar.m[rq.key()]
+	for i, q := range l {
+		if q == rq {
+			l = append(l[:i:i], l[i+1:]...)
+			break
+		}
+	}
+	if len(l) == 0 {
+		delete(ar.m, rq.key())
+	} else {
+		ar.m[rq.key()] = l
+	}
+}
+
+// cancel cancels all in-flight requests identified by key (see agentReq.key)
+func (ar *agentReqs) cancel(key string) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	for _, rq := range ar.m[key] {
+		rq.cancel()
+	}
+}
+
+// cancelClient cancels all in-flight requests sent by client c
+func (ar *agentReqs) cancelClient(c *agentClient) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	for _, l := range ar.m {
+		for _, rq := range l {
+			if rq.client == c {
+				rq.cancel()
+			}
+		}
+	}
+}
+
+// cancelReqs cancels the requests targeted by any Cancel actions in rq.
+//
+// It's called as soon as rq is received, so the cancellation
+// doesn't have to wait in the queue behind the requests it targets.
+func (ag *Agent) cancelReqs(rq *agentReq) {
+	for _, ra := range rq.Actions {
+		if ra.Name != "Cancel" {
+			continue
+		}
+		act := Cancel{}
+		if err := ra.Decode(&act); err != nil {
+			ag.Log.Println("ipc.cancel: cannot decode Cancel action:", err)
+			continue
+		}
+		if act.Cookie != "" && act.Cookie != rq.Cookie {
+			ag.reqs.cancel(reqKey(rq.client, act.Cookie))
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package mg
+
+import (
+	"github.com/ugorji/go/codec"
+	"margo.sh/mg/actions"
+	"margo.sh/mgutil"
+	"testing"
+)
+
+func TestAgentCancel(t *testing.T) {
+	ag, err := NewAgent(AgentConfig{
+		Stdin:  &mgutil.IOWrapper{},
+		Stdout: &mgutil.IOWrapper{},
+		Stderr: &mgutil.IOWrapper{},
+	})
+	if err != nil {
+		t.Fatalf("agent creation failed: %s", err)
+	}
+
+	target := newAgentReq(ag.Store)
+	target.Cookie = "target"
+	ag.reqs.track(target)
+
+	var data []byte
+	codec.NewEncoderBytes(&data, ag.handle).Encode(map[string]string{"Cookie": "target"})
+	rq := newAgentReq(ag.Store)
+	rq.Cookie = "canceller"
+	rq.Actions = []actions.ActionData{{Name: "Cancel", Data: data}}
+	rq.finalize(ag)
+	ag.cancelReqs(rq)
+
+	select {
+	case <-target.doneC:
+	default:
+		t.Fatal("target request was not cancelled")
+	}
+	select {
+	case <-rq.doneC:
+		t.Fatal("cancelling request was cancelled")
+	default:
+	}
+}
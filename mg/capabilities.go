@@ -0,0 +1,59 @@
+package mg
+
+// Capabilities that clients may declare in their requests. See clientProps.Capabilities
+const (
+	// CapAnnotations is declared by clients that can display annotations in the view
+	CapAnnotations = "Annotations"
+
+	// CapVirtualViews is declared by clients that can display views that aren't backed by a file
+	CapVirtualViews = "VirtualViews"
+
+	// CapProgress is declared by clients that can display the progress of tasks
+	CapProgress = "Progress"
+)
+
+// setCapabilities sets the list of features the client supports
+func (c *agentClient) setCapabilities(l []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.capabilities = StrSet(l)
+}
+
+// supports returns true if the client supports all the features in caps
+func (c *agentClient) supports(caps []string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, s := range caps {
+		if !c.capabilities.Has(s) {
+			return false
+		}
+	}
+	return true
+}
+
+// ClientSupports returns true if the client supports all the features in caps e.g. CapProgress.
+//
+// If the Ctx isn't part of a request, all connected clients must support them.
+// Reducers should use it to avoid sending client actions that older clients can't handle.
+func (mx *Ctx) ClientSupports(caps ...string) bool {
+	ag := mx.Store.ag
+	if ag == nil {
+		return false
+	}
+	if rq := mx.req; rq != nil {
+		c := rq.client
+		if c == nil {
+			c = ag.client
+		}
+		return c.supports(caps)
+	}
+	clients := ag.clients.list()
+	for _, c := range clients {
+		if !c.supports(caps) {
+			return false
+		}
+	}
+	return len(clients) != 0
+}
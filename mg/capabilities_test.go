@@ -0,0 +1,42 @@
+package mg
+
+import (
+	"margo.sh/mg/actions"
+	"margo.sh/mgutil"
+	"testing"
+)
+
+func TestCtxClientSupports(t *testing.T) {
+	ag, err := NewAgent(AgentConfig{
+		Stdin:  &mgutil.IOWrapper{},
+		Stdout: &mgutil.IOWrapper{},
+		Stderr: &mgutil.IOWrapper{},
+		Codec:  "msgpack",
+	})
+	if err != nil {
+		t.Fatalf("agent creation failed: %s", err)
+	}
+	var progress, annotations bool
+	ag.Store.Use(NewReducer(func(mx *Ctx) *State {
+		if _, ok := mx.Action.(QueryIssues); ok {
+			progress = mx.ClientSupports(CapProgress)
+			annotations = mx.ClientSupports(CapProgress, CapAnnotations)
+		}
+		return mx.State
+	}))
+
+	rq := newAgentReq(ag.Store)
+	rq.Cookie = "caps"
+	rq.Actions = []actions.ActionData{{Name: "QueryIssues"}}
+	rq.Props.Capabilities = []string{CapProgress}
+	rq.finalize(ag)
+	ag.client.setCapabilities(rq.Props.Capabilities)
+	ag.Store.handleReq(rq)
+
+	if !progress {
+		t.Errorf("mx.ClientSupports(%s) = (false); want (true)", CapProgress)
+	}
+	if annotations {
+		t.Errorf("mx.ClientSupports(%s, %s) = (true); want (false)", CapProgress, CapAnnotations)
+	}
+}
@@ -5,10 +5,27 @@ import (
 )
 
 var (
-	_ actions.ClientAction = CmdOutput{}
-	_ actions.ClientAction = Activate{}
-	_ actions.ClientAction = Restart{}
-	_ actions.ClientAction = Shutdown{}
+	// clientActions is the list of client actions the agent may send.
+	// It's used to describe them in the protocol schema. See ProtocolSchema
+	clientActions = []actions.ClientAction{
+		CmdOutput{},
+		Activate{},
+		DisplayIssues{},
+		Restart{},
+		Shutdown{},
+		Ping{},
+		QueueOverflow{},
+		Metrics{},
+		AgentStatus{},
+		LogMessage{},
+		Restarting{},
+		History{},
+		Reducers{},
+		ReducerProfile{},
+		PluginReloaded{},
+		TestMarkers{},
+		CoverageOverlay{},
+	}
 )
 
 type clientActionSupport struct{ ReducerType }
@@ -18,7 +35,13 @@ func (cas *clientActionSupport) Reduce(mx *Ctx) *State {
 		switch act := act.(type) {
 		case Activate:
 			mx.Log.Printf("client action Activate(%s:%d:%d) dispatched\n", act.Path, act.Row, act.Col)
-		case Restart, Shutdown:
+		case Restart:
+			if mx.Store.ag.requestHandoff() {
+				mx.Log.Println("restart: the agent will restart itself when the next request is received")
+				return mx.State
+			}
+			mx.Log.Printf("client action %s dispatched\n", act.ClientAction().Name)
+		case Shutdown:
 			mx.Log.Printf("client action %s dispatched\n", act.ClientAction().Name)
 		}
 		return mx.addClientActions(act)
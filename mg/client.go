@@ -0,0 +1,212 @@
+package mg
+
+import (
+	"bufio"
+	"github.com/ugorji/go/codec"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// agentClient is a connection to a client e.g. an editor window.
+//
+// Each client has its own encoder, decoder and Cookie namespace
+// while the Store, and therefore all caches, are shared by all clients.
+type agentClient struct {
+	ag *Agent
+	id int
+
+	// mu protects writes to the client and agentReq.finished
+	mu sync.Mutex
+
+	// remote is set if the client connected to the agent's listener
+	remote bool
+	addr   string
+
+	// lastSeen is the time, in unix nanoseconds, the client last sent a request
+	lastSeen int64
+
+	deadC    chan struct{}
+	deadOnce sync.Once
+	deadErr  error `mg.Nillable:"true"`
+
+	stdin       io.ReadCloser
+	stdout      io.WriteCloser
+	stallWr     *stallWriter `mg.Nillable:"true"`
+	compression agentCompression
+	written     *countWriter
+	enc         *codec.Encoder
+	encWr       *bufio.Writer
+	dec         *codec.Decoder
+	decRd       *bufio.Reader
+	limRd       *limitReader
+	recRd       *recordReader `mg.Nillable:"true"`
+
+	// stateFields is the list of State fields the client cares about
+	stateFields StrSet
+
+	// capabilities is the list of features the client supports
+	capabilities StrSet
+
+	// delta is set if the client opted in to state deltas
+	delta *stateDelta `mg.Nillable:"true"`
+
+	// logs is set, atomically, if the client opted in to LogMessage client actions
+	logs int32
+
+	// stalled is set, atomically, if the client stopped reading its responses
+	stalled int32
+
+	// connected is set, atomically, once ClientConnected was reduced for the client
+	connected int32
+
+	// lastReq is the data of the last request read, if the agent is recording or may restart itself
+	lastReq []byte
+}
+
+func newAgentClient(ag *Agent, stdin io.ReadCloser, stdout io.WriteCloser) *agentClient {
+	c := &agentClient{
+		ag:          ag,
+		stdin:       stdin,
+		stdout:      stdout,
+		compression: agentCompression{name: ag.compression},
+		deadC:       make(chan struct{}),
+	}
+	if ag.writeTimeout > 0 {
+		c.stallWr = newStallWriter(c.stdout)
+		c.stdout = c.stallWr
+	}
+	c.written = &countWriter{w: c.stdout}
+	c.encWr = bufio.NewWriter(c.written)
+	c.enc = codec.NewEncoder(c.encWr, ag.handle)
+	c.setReader(bufio.NewReader(c.stdin))
+	return c
+}
+
+// setReader sets the reader from which requests are decoded
+func (c *agentClient) setReader(rd *bufio.Reader) {
+	c.decRd = rd
+	var r byteReader = rd
+	if c.ag.recorder != nil || c.ag.stdio {
+		c.recRd = &recordReader{r: rd}
+		r = c.recRd
+	}
+	c.limRd = &limitReader{r: r, max: c.ag.maxRequestSize}
+	c.dec = codec.NewDecoder(c.limRd, c.ag.handle)
+}
+
+// record adds the message data to the session recording, if enabled
+func (c *agentClient) record(kind string, data []byte) {
+	if rec := c.ag.recorder; rec != nil {
+		rec.record(c, kind, data)
+	}
+}
+
+// send sends res to the client
+func (c *agentClient) send(res agentRes) error {
+	// don't wait for the lock, which might be held by a blocked write
+	if c.isStalled() {
+		return errClientStalled
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	res.fields = c.stateFields
+	compress := false
+	if rq := res.req; rq != nil {
+		if rq.finished {
+			return errReqFinished
+		}
+		rq.finished = !res.Partial
+		compress = rq.finished && rq.compress
+		if rq.finished {
+			res.Hello = rq.hello
+			res.Actions = rq.results
+			res.TraceID = rq.TraceID
+		}
+	}
+	if compress {
+		res.Compression = c.compression.name
+		defer c.compressResponses()
+	}
+
+	n := c.written.n
+	err := c.encodeRes(res)
+	c.flush()
+	c.ag.Store.metrics.observeResponse(res.acts, c.written.n-n)
+	return err
+}
+
+// setStateFields sets the list of State fields the client cares about
+func (c *agentClient) setStateFields(l []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stateFields = StrSet(l)
+}
+
+// fail handles an error sending data to the client.
+//
+// Remote clients are disconnected, other clients shut down the agent.
+func (c *agentClient) fail(err error) {
+	if !c.remote {
+		c.ag.Log.Println("agent.send failed. shutting down ipc:", err)
+		go c.ag.shutdown()
+		return
+	}
+	c.ag.Log.Printf("ipc.send: disconnecting client %d: %s\n", c.id, err)
+	c.stdin.Close()
+}
+
+// reqKey returns the key identifying requests with cookie sent by client c
+func reqKey(c *agentClient, cookie string) string {
+	if c == nil {
+		return "0:" + cookie
+	}
+	return strconv.Itoa(c.id) + ":" + cookie
+}
+
+// agentClients is the list of connected clients
+type agentClients struct {
+	mu     sync.Mutex
+	lastID int
+	l      []*agentClient
+}
+
+// add adds c to the list and assigns its id
+func (cs *agentClients) add(c *agentClient) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cs.lastID++
+	c.id = cs.lastID
+	cs.l = append(cs.l, c)
+}
+
+// drop removes c from the list and returns true, unless it's the last client
+//
+// The last client is kept so that responses sent during shutdown still reach it.
+func (cs *agentClients) drop(c *agentClient) bool {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if len(cs.l) <= 1 {
+		return false
+	}
+	for i, p := range cs.l {
+		if p == c {
+			cs.l = append(cs.l[:i:i], cs.l[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// list returns a copy of the list of clients
+func (cs *agentClients) list() []*agentClient {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	return append([]*agentClient(nil), cs.l...)
+}
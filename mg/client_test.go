@@ -0,0 +1,61 @@
+package mg
+
+import (
+	"fmt"
+	"github.com/ugorji/go/codec"
+	"net"
+	"testing"
+)
+
+func TestAgentMultiClient(t *testing.T) {
+	ag, addr, runErr := listenTestAgent(t)
+
+	type client struct {
+		conn net.Conn
+		dec  *codec.Decoder
+	}
+	clients := map[string]client{}
+	for _, cookie := range []string{"a", "b"} {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("net.Dial(%s): %s", addr, err)
+		}
+		fmt.Fprintln(conn, "secret")
+		clients[cookie] = client{conn: conn, dec: codec.NewDecoder(conn, ag.handle)}
+	}
+
+	// both clients use the same Cookie for their first request
+	for _, c := range clients {
+		if err := codec.NewEncoder(c.conn, ag.handle).Encode(map[string]string{"Cookie": "same"}); err != nil {
+			t.Fatalf("enc.Encode(): %s", err)
+		}
+	}
+	for cookie, c := range clients {
+		if err := codec.NewEncoder(c.conn, ag.handle).Encode(map[string]string{"Cookie": cookie}); err != nil {
+			t.Fatalf("enc.Encode(): %s", err)
+		}
+	}
+	for cookie, c := range clients {
+		want := []string{"same", cookie}
+		for len(want) != 0 {
+			var res struct{ Cookie string }
+			if err := c.dec.Decode(&res); err != nil {
+				t.Fatalf("client %s: dec.Decode(): %s", cookie, err)
+			}
+			switch res.Cookie {
+			case "":
+			case want[0]:
+				want = want[1:]
+			default:
+				t.Fatalf("client %s: res.Cookie = (%s); want (%s)", cookie, res.Cookie, want[0])
+			}
+		}
+	}
+
+	for _, c := range clients {
+		c.conn.Close()
+	}
+	if err := <-runErr; err != nil {
+		t.Fatalf("ag.Run() = (%#v); want (nil)", err)
+	}
+}
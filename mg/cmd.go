@@ -126,6 +126,9 @@ type CmdOut struct {
 	Fd       string
 	Dispatch Dispatcher
 
+	// TraceID is the trace ID of the request that started the command, if any
+	TraceID string
+
 	mu     sync.Mutex
 	buf    []byte
 	closed bool
@@ -186,7 +189,7 @@ func (w *CmdOut) Output() CmdOutput {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	out := CmdOutput{Fd: w.Fd, Output: w.buf, Close: w.closed}
+	out := CmdOutput{Fd: w.Fd, Output: w.buf, Close: w.closed, TraceID: w.TraceID}
 	w.buf = nil
 	return out
 }
@@ -197,6 +200,9 @@ type CmdOutput struct {
 	Fd     string
 	Output []byte
 	Close  bool
+
+	// TraceID is the trace ID of the request that started the command, if any
+	TraceID string
 }
 
 func (out CmdOutput) ClientAction() actions.ClientData {
@@ -218,7 +224,7 @@ func runCmd(mx *Ctx, rc RunCmd) *State {
 	cx := &CmdCtx{
 		Ctx:    mx,
 		RunCmd: rc,
-		Output: &CmdOut{Fd: rc.Fd, Dispatch: mx.Store.Dispatch},
+		Output: &CmdOut{Fd: rc.Fd, Dispatch: mx.Store.Dispatch, TraceID: mx.TraceID},
 	}
 	defer mx.Profile.Push(cx.Name).Pop()
 	return cx.Run()
@@ -411,10 +417,14 @@ func (p *Proc) start() error {
 func (p *Proc) dispatcher() {
 	defer p.task.Done()
 
+	cancelled := p.cx.Done()
 	for {
 		select {
 		case <-p.done:
 			return
+		case <-cancelled:
+			cancelled = nil
+			p.Cancel()
 		case <-time.After(OutputStreamFlushInterval):
 			p.cx.Output.Flush()
 		}
@@ -17,4 +17,8 @@ type Completion struct {
 	Title string
 	Src   string
 	Tag   CompletionTag
+
+	// Matches holds the byte offsets, in Query, of the characters matched by the text typed by the user,
+	// so clients can highlight them. It's empty if the reducer didn't match the completion itself.
+	Matches []int
 }
@@ -0,0 +1,134 @@
+package mg
+
+import (
+	"encoding/gob"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// completionRankHalfLife is the amount of time after which an accepted completion counts half as much
+	completionRankHalfLife = 7 * 24 * time.Hour
+
+	// completionRankLimit is the maximum number of accepted completions remembered per project
+	completionRankLimit = 500
+
+	// completionRankKVName is the name of the Store.DiskKV in which the accepted completions are saved
+	completionRankKVName = "completion-rank"
+)
+
+func init() {
+	gob.Register(completionUses{})
+}
+
+// CompletionAccepted is the action dispatched by the client when the user accepts a completion.
+//
+// Completions that were accepted recently and frequently, in the view's project, are ranked first
+// in the State.Completions of following QueryCompletions.
+// The accepted completions are saved if AgentConfig.StateDir is set.
+type CompletionAccepted struct {
+	ActionType
+
+	// Query is the Completion.Query of the completion that was accepted
+	Query string
+}
+
+// completionUse records the use of a completion
+type completionUse struct {
+	// Score is the decayed number of times the completion was accepted, as of Last
+	Score float64
+	// Last is the time at which the completion was last accepted
+	Last time.Time
+}
+
+// score returns the score of the completion at time now
+func (u completionUse) score(now time.Time) float64 {
+	return u.Score * math.Exp2(-float64(now.Sub(u.Last))/float64(completionRankHalfLife))
+}
+
+// completionUses records the completions accepted in a project, by Completion.Query
+type completionUses map[string]completionUse
+
+// completionRankKey is the key of the completionUses of project Project
+type completionRankKey struct {
+	Project string
+}
+
+// completionRanker sorts State.Completions by the recency and frequency of their acceptance. See CompletionAccepted
+type completionRanker struct {
+	ReducerType
+}
+
+func (cr *completionRanker) RCond(mx *Ctx) bool {
+	return mx.ActionIs(QueryCompletions{}, CompletionAccepted{})
+}
+
+func (cr *completionRanker) Reduce(mx *Ctx) *State {
+	switch act := mx.Action.(type) {
+	case CompletionAccepted:
+		cr.accept(mx, act.Query, time.Now())
+		return mx.State
+	case QueryCompletions:
+		return cr.rank(mx, time.Now())
+	}
+	return mx.State
+}
+
+// uses returns the completions accepted in the project of mx.View, and the key under which they're stored
+func (cr *completionRanker) uses(mx *Ctx) (completionUses, completionRankKey) {
+	k := completionRankKey{Project: projectDir(mx)}
+	cu, _ := mx.Store.DiskKV(completionRankKVName).Get(k).(completionUses)
+	return cu, k
+}
+
+// accept records that the completion query was accepted at time now
+func (cr *completionRanker) accept(mx *Ctx, query string, now time.Time) {
+	if query == "" {
+		return
+	}
+
+	old, k := cr.uses(mx)
+	cu := make(completionUses, len(old)+1)
+	for q, u := range old {
+		cu[q] = u
+	}
+	u := cu[query]
+	cu[query] = completionUse{Score: u.score(now) + 1, Last: now}
+
+	if len(cu) > completionRankLimit {
+		l := make([]string, 0, len(cu))
+		for q := range cu {
+			l = append(l, q)
+		}
+		sort.Slice(l, func(i, j int) bool { return cu[l[i]].score(now) < cu[l[j]].score(now) })
+		for _, q := range l[:len(cu)-completionRankLimit] {
+			delete(cu, q)
+		}
+	}
+	mx.Store.DiskKV(completionRankKVName).Put(k, cu)
+}
+
+// rank sorts State.Completions so the completions accepted most in the past come first.
+// The order of completions with the same score, including those that were never accepted, is preserved.
+func (cr *completionRanker) rank(mx *Ctx, now time.Time) *State {
+	cu, _ := cr.uses(mx)
+	if len(cu) == 0 || len(mx.State.Completions) < 2 {
+		return mx.State
+	}
+
+	l := append([]Completion(nil), mx.State.Completions...)
+	scores := make(map[string]float64, len(l))
+	for _, c := range l {
+		if u, ok := cu[c.Query]; ok {
+			scores[c.Query] = u.score(now)
+		}
+	}
+	if len(scores) == 0 {
+		return mx.State
+	}
+	sort.SliceStable(l, func(i, j int) bool { return scores[l[i].Query] > scores[l[j].Query] })
+	return mx.State.Copy(func(st *State) {
+		st.Completions = l
+	})
+}
@@ -0,0 +1,44 @@
+package mg
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompletionRanking(t *testing.T) {
+	h := NewHarness(t, NewReducer(func(mx *Ctx) *State {
+		if !mx.ActionIs(QueryCompletions{}) {
+			return mx.State
+		}
+		return mx.AddCompletions(Completion{Query: "a"}, Completion{Query: "b"}, Completion{Query: "c"}, Completion{Query: "d"})
+	}))
+	queries := func(st *State) string {
+		var l []string
+		for _, c := range st.Completions {
+			l = append(l, c.Query)
+		}
+		return strings.Join(l, " ")
+	}
+
+	h.Open("/p1/a.go", "package a", 0)
+	if s := queries(h.Dispatch(QueryCompletions{})); s != "a b c d" {
+		t.Errorf("completions = %q before any was accepted; want the original order", s)
+	}
+	h.Dispatch(CompletionAccepted{Query: "c"}, CompletionAccepted{Query: "d"}, CompletionAccepted{Query: "c"})
+	if s := queries(h.Dispatch(QueryCompletions{})); s != "c d a b" {
+		t.Errorf("completions = %q; want the accepted ones first, most accepted first", s)
+	}
+
+	h.Open("/p2/a.go", "package a", 0)
+	if s := queries(h.Dispatch(QueryCompletions{})); s != "a b c d" {
+		t.Errorf("completions = %q in another project; want the original order", s)
+	}
+
+	now := time.Now()
+	old := completionUse{Score: 2, Last: now.Add(-2 * completionRankHalfLife)}
+	recent := completionUse{Score: 1, Last: now}
+	if old.score(now) >= recent.score(now) {
+		t.Errorf("score of 2 uses two half-lives ago = %v; want less than that of 1 recent use (%v)", old.score(now), recent.score(now))
+	}
+}
@@ -0,0 +1,117 @@
+package mg
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ugorji/go/codec"
+	"io"
+)
+
+// agentCompression holds the state of IPC stream compression.
+//
+// Compression is negotiated separately by each client, in its first request:
+// * the client sets agentReq.Compression to the name of the compression it wants
+// * if it matches AgentConfig.Compression, the agent sets agentRes.Compression in its response
+// * all data sent after that request, and after that response, is compressed
+//
+// The client must therefore wait for the response to its first request before sending another.
+type agentCompression struct {
+	name       string
+	negotiated bool
+	zw         compressWriter `mg.Nillable:"true"`
+}
+
+// compressWriter is the interface implemented by the writers of each compression
+type compressWriter interface {
+	io.Writer
+	Flush() error
+}
+
+func parseCompression(s string) (string, error) {
+	switch s {
+	case "", "none":
+		return "", nil
+	case "gzip", "zstd":
+		return s, nil
+	}
+	return "", fmt.Errorf("Invalid compression '%s'. Expected gzip, zstd or none", s)
+}
+
+// newCompressReader returns a reader that decompresses r using compression name
+func newCompressReader(name string, r io.Reader) (io.Reader, error) {
+	if name == "zstd" {
+		// a single goroutine decodes the stream synchronously, so it doesn't read ahead of the requests
+		return zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	}
+	return gzip.NewReader(r)
+}
+
+// newCompressWriter returns a writer that compresses the data written to it, using compression name, to w
+func newCompressWriter(name string, w io.Writer) compressWriter {
+	if name == "zstd" {
+		// NewWriter only fails if the options are invalid
+		zw, _ := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		return zw
+	}
+	return gzip.NewWriter(w)
+}
+
+// negotiateCompression sets rq.compress if rq is the client's first request
+// and it asked for the configured compression.
+func (c *agentClient) negotiateCompression(rq *agentReq) {
+	cmp := &c.compression
+	if cmp.negotiated {
+		return
+	}
+	cmp.negotiated = true
+
+	if rq.Compression == "" || rq.Compression == "none" {
+		return
+	}
+	if rq.Compression != cmp.name {
+		c.ag.Log.Printf("ipc.compression: client requested '%s' but '%s' is configured; not compressing\n", rq.Compression, cmp.name)
+		return
+	}
+	rq.compress = true
+}
+
+// decompressRequests switches the decoder to decompress all future requests.
+//
+// It blocks until the client sends the compression header,
+// so it must be called after the negotiating request has been handed off.
+func (c *agentClient) decompressRequests() error {
+	zr, err := newCompressReader(c.compression.name, c.decRd)
+	if err != nil {
+		return fmt.Errorf("ipc.compression: %s", err)
+	}
+	c.setReader(bufio.NewReader(zr))
+	return nil
+}
+
+// compressResponses switches the encoder to compress all future responses.
+// It's called with agentClient.mu held, after the response that acknowledges compression is sent.
+//
+// The compressor writes to agentClient.written, so the size of responses counts the bytes actually sent.
+func (c *agentClient) compressResponses() {
+	c.compression.zw = newCompressWriter(c.compression.name, c.written)
+	c.encWr = bufio.NewWriter(c.compression.zw)
+	c.enc = codec.NewEncoder(c.encWr, c.ag.handle)
+}
+
+// flush flushes any buffered response data to the client
+func (c *agentClient) flush() error {
+	err := c.encWr.Flush()
+	if zw := c.compression.zw; zw != nil {
+		if e := zw.Flush(); err == nil {
+			err = e
+		}
+	}
+	if f, ok := c.stdout.(interface{ Flush() error }); ok {
+		if e := f.Flush(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
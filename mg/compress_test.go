@@ -0,0 +1,131 @@
+package mg
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ugorji/go/codec"
+	"io"
+	"io/ioutil"
+	"margo.sh/mgutil"
+	"testing"
+)
+
+func TestAgentCompression(t *testing.T) {
+	type compression struct {
+		name      string
+		newWriter func(w io.Writer) io.WriteCloser
+		newReader func(r io.Reader) (io.Reader, error)
+	}
+	compressions := []compression{
+		{
+			name:      "gzip",
+			newWriter: func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+			newReader: func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		},
+		{
+			name: "zstd",
+			newWriter: func(w io.Writer) io.WriteCloser {
+				zw, _ := zstd.NewWriter(w)
+				return zw
+			},
+			newReader: func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) },
+		},
+	}
+	for _, cmp := range compressions {
+		t.Run(cmp.name, func(t *testing.T) {
+			inR, inW := io.Pipe()
+			outR, outW := io.Pipe()
+			ag, err := NewAgent(AgentConfig{
+				Stdin:       inR,
+				Stdout:      outW,
+				Stderr:      &mgutil.IOWrapper{},
+				Codec:       "msgpack",
+				Compression: cmp.name,
+			})
+			if err != nil {
+				t.Fatalf("agent creation failed: %s", err)
+			}
+			runErr := make(chan error, 1)
+			go func() { runErr <- ag.Run() }()
+
+			type res struct {
+				Cookie      string
+				Compression string
+			}
+			waitFor := func(dec *codec.Decoder, cookie string) res {
+				for {
+					r := res{}
+					if err := dec.Decode(&r); err != nil {
+						t.Fatalf("dec.Decode(): %s", err)
+					}
+					if r.Cookie == cookie {
+						return r
+					}
+				}
+			}
+
+			outBuf := bufio.NewReader(outR)
+			err = codec.NewEncoder(inW, ag.handle).Encode(map[string]string{
+				"Cookie":      "first",
+				"Compression": cmp.name,
+			})
+			if err != nil {
+				t.Fatalf("enc.Encode(): %s", err)
+			}
+			if r := waitFor(codec.NewDecoder(outBuf, ag.handle), "first"); r.Compression != cmp.name {
+				t.Fatalf("res.Compression = (%s); want (%s)", r.Compression, cmp.name)
+			}
+
+			zw := cmp.newWriter(inW)
+			if err := codec.NewEncoder(zw, ag.handle).Encode(map[string]string{"Cookie": "second"}); err != nil {
+				t.Fatalf("enc.Encode(): %s", err)
+			}
+			zw.(interface{ Flush() error }).Flush()
+			zr, err := cmp.newReader(outBuf)
+			if err != nil {
+				t.Fatalf("newReader(): %s", err)
+			}
+			waitFor(codec.NewDecoder(zr, ag.handle), "second")
+
+			zw.Close()
+			inW.Close()
+			go io.Copy(ioutil.Discard, outR)
+			<-runErr
+		})
+	}
+}
+
+func TestCompressedResponseSize(t *testing.T) {
+	for _, name := range []string{"gzip", "zstd"} {
+		t.Run(name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			ag, err := NewAgent(AgentConfig{
+				Stdin:       &mgutil.IOWrapper{},
+				Stdout:      &mgutil.IOWrapper{Writer: out},
+				Stderr:      &mgutil.IOWrapper{},
+				Codec:       "msgpack",
+				Compression: name,
+			})
+			if err != nil {
+				t.Fatalf("agent creation failed: %s", err)
+			}
+			c := ag.client
+			c.mu.Lock()
+			c.compressResponses()
+			c.mu.Unlock()
+
+			st := ag.Store.NewCtx(nil).AddStatus(string(bytes.Repeat([]byte("compressible "), 1000)))
+			if err := c.send(agentRes{Cookie: "large", State: st}); err != nil {
+				t.Fatalf("c.send(): %s", err)
+			}
+			if n := int64(out.Len()); c.written.n != n {
+				t.Errorf("%d bytes were counted, but %d compressed bytes were written", c.written.n, n)
+			}
+			if out.Len() >= 13000 {
+				t.Errorf("the response is %d bytes; want it compressed", out.Len())
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package mg
+
+import (
+	"margo.sh/mg/actions"
+)
+
+// CoverageOverlay is the client action that shows the test coverage of a view,
+// as reported by `go test -coverprofile`, so the client can highlight the lines that were, or weren't, run.
+//
+// It's sent when a cover profile is loaded, for each open view it covers, and when such a view is activated.
+// When the view is modified, the rows are no longer accurate, so it's sent with no ranges, to clear the overlay.
+type CoverageOverlay struct {
+	ActionType
+
+	// Path is the View.Path of the view
+	Path string
+
+	// Name is the View.Name of the view
+	Name string
+
+	// Covered is the list of ranges of rows whose statements were run
+	Covered []CoverageRange
+
+	// Uncovered is the list of ranges of rows whose statements were not run
+	Uncovered []CoverageRange
+
+	// Percent is the percentage of the view's statements that were run
+	Percent float64
+}
+
+func (co CoverageOverlay) ClientAction() actions.ClientData {
+	return actions.ClientData{Name: "CoverageOverlay", Data: co}
+}
+
+// CoverageRange is a range of rows. See CoverageOverlay
+type CoverageRange struct {
+	// Row is the first row of the range
+	Row int
+
+	// EndRow is the last row of the range, inclusive
+	EndRow int
+}
@@ -0,0 +1,93 @@
+package mg
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// The slice fields of State whose additions share their backing array. See cowAppend
+const (
+	tailStatus = iota
+	tailErrors
+	tailCompletions
+	tailIssues
+	tailBuiltinCmds
+	tailUserCmds
+	tailTooltips
+	tailClientActions
+	nTails
+)
+
+// cowTail describes the backing array of a State slice field.
+//
+// The slices held by State always have a capacity equal to their length, so appending to them
+// directly always copies them. The spare capacity of the array is only known to the tail,
+// through which it's claimed by the State that holds the longest version of the slice.
+type cowTail struct {
+	// first is the address of the first element of the array, and cap is its capacity
+	first unsafe.Pointer
+	cap   int
+
+	// claimed is the number of elements of the array in use by some version of the slice
+	claimed int64
+}
+
+// cowAppend returns s with the items in l appended to it.
+//
+// If s is the latest version of the slice whose backing array is described by *tail,
+// and the array has enough spare capacity, the items are appended in place,
+// so States created by successive additions share a single array.
+// Otherwise, s is copied into a new, larger array and *tail is set to describe it.
+//
+// tail must belong to the State to which the result is assigned.
+func cowAppend[T any](tail **cowTail, s []T, l []T) []T {
+	if len(l) == 0 {
+		return s
+	}
+
+	n := len(s) + len(l)
+	if tl := *tail; tl != nil && len(s) != 0 && tl.first == unsafe.Pointer(&s[0]) && n <= tl.cap &&
+		atomic.CompareAndSwapInt64(&tl.claimed, int64(len(s)), int64(n)) {
+		a := unsafe.Slice((*T)(tl.first), tl.cap)
+		copy(a[len(s):n], l)
+		return a[:n:n]
+	}
+
+	c := n * 2
+	if c < 8 {
+		c = 8
+	}
+	a := make([]T, c)
+	copy(a, s)
+	copy(a[len(s):], l)
+	*tail = &cowTail{first: unsafe.Pointer(&a[0]), cap: c, claimed: int64(n)}
+	return a[:n:n]
+}
+
+// cowAddStrs returns s with the strings in l, that it doesn't already contain, appended to it
+func cowAddStrs(tail **cowTail, s StrSet, l []string) StrSet {
+	var add StrSet
+	for _, p := range l {
+		if !s.Has(p) && !add.Has(p) {
+			add = append(add, p)
+		}
+	}
+	return cowAppend(tail, s, add)
+}
+
+// cowAddIssues returns s with the issues in l, that it doesn't already contain, appended to it
+func cowAddIssues(tail **cowTail, s IssueSet, l []Issue) IssueSet {
+	seen := make(map[issueHash]bool, len(s)+len(l))
+	for i := range s {
+		seen[s[i].hash()] = true
+	}
+	var add IssueSet
+	for _, isu := range l {
+		ish := isu.hash()
+		if !seen[ish] {
+			seen[ish] = true
+			add = append(add, isu)
+		}
+	}
+	return cowAppend(tail, s, add)
+}
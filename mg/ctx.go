@@ -50,14 +50,29 @@ type Ctx struct {
 
 	Cookie string
 
+	// TraceID identifies the request being handled, if any, in logs, profiles and command output.
+	// Log records written using Log are prefixed with it.
+	TraceID string
+
 	Profile *mgpf.Profile
 
 	VFS *vfs.FS
 
+	// Jobs runs background work. See Jobs.Submit
+	Jobs *Jobs
+
 	doneC      chan struct{}
 	cancelOnce *sync.Once
+	req        *agentReq `mg.Nillable:"true"`
+	deadline   time.Time
 	handle     codec.Handle
 	defr       *redFns
+
+	// panicked is the last panic recovered from a reducer while handling Action
+	panicked *panicError `mg.Nillable:"true"`
+
+	// changes records the State fields modified by the reducers while handling Action
+	changes *stateChanges
 }
 
 // newCtx creates a new Ctx
@@ -86,16 +101,22 @@ func newCtx(sto *Store, st *State, acts *ctxActs, cookie string, p *mgpf.Profile
 		Cookie:     cookie,
 		Profile:    p,
 		VFS:        VFS,
+		Jobs:       sto.jobs,
 		doneC:      make(chan struct{}),
 		cancelOnce: &sync.Once{},
 		handle:     sto.ag.handle,
+		changes:    &stateChanges{},
 		defr:       &redFns{},
 	}
 }
 
 // Deadline implements context.Context.Deadline
-func (*Ctx) Deadline() (time.Time, bool) {
-	return time.Time{}, false
+//
+// The deadline is set by the client for each action (see actions.ActionData.Deadline).
+// When it passes, the Ctx is cancelled; reducers should then return
+// the best results they have instead of blocking the response.
+func (mx *Ctx) Deadline() (time.Time, bool) {
+	return mx.deadline, !mx.deadline.IsZero()
 }
 
 // Cancel cancels the ctx by arranging for the Ctx.Done() channel to be closed.
@@ -115,12 +136,40 @@ func (mx *Ctx) Done() <-chan struct{} {
 func (mx *Ctx) Err() error {
 	select {
 	case <-mx.Done():
+		if dl, ok := mx.Deadline(); ok && !time.Now().Before(dl) {
+			return context.DeadlineExceeded
+		}
 		return context.Canceled
 	default:
 		return nil
 	}
 }
 
+// withDeadline sets the deadline of mx and arranges for it to be cancelled
+// when the deadline passes or its parent is cancelled.
+// The returned function must be called once mx is no longer in use.
+func (mx *Ctx) withDeadline(dl time.Time) (stop func()) {
+	parent := mx.doneC
+	done := make(chan struct{})
+	once := &sync.Once{}
+	mx.deadline, mx.doneC, mx.cancelOnce = dl, done, once
+	cancel := func() {
+		once.Do(func() { close(done) })
+	}
+	tmr := time.AfterFunc(time.Until(dl), cancel)
+	go func() {
+		select {
+		case <-parent:
+			cancel()
+		case <-done:
+		}
+	}()
+	return func() {
+		tmr.Stop()
+		cancel()
+	}
+}
+
 // Value implements context.Context.Value() but always returns nil
 func (mx *Ctx) Value(k interface{}) interface{} {
 	return nil
@@ -189,6 +238,36 @@ func (mx *Ctx) Begin(t Task) *TaskTicket {
 	return mx.Store.Begin(t)
 }
 
+// Stream sends st to the client as a partial response to the current request.
+//
+// Partial responses have the same Cookie as the request and are always received
+// by the client before the final response.
+// This allows long-running reducers e.g. linters to report results incrementally.
+//
+// It returns false if the Ctx isn't part of a request from the client,
+// the final response was already sent, or the response couldn't be sent.
+func (mx *Ctx) Stream(st *State) bool {
+	if mx.req == nil || st == nil {
+		return false
+	}
+	err := mx.Store.ag.send(agentRes{
+		State:   st,
+		Cookie:  mx.Cookie,
+		Partial: true,
+		req:     mx.req,
+		acts:    mx.Acts.List(),
+	})
+	switch err {
+	case nil:
+		return true
+	case errReqFinished:
+		return false
+	default:
+		mx.Log.Println("Ctx.Stream failed:", err)
+		return false
+	}
+}
+
 func (mx *Ctx) Defer(f ReduceFn) *State {
 	mx.defr.prepend(f)
 	return mx.State
@@ -0,0 +1,100 @@
+package mg
+
+import (
+	"bytes"
+	"context"
+	"github.com/ugorji/go/codec"
+	"margo.sh/mg/actions"
+	"margo.sh/mgutil"
+	"testing"
+	"time"
+)
+
+func TestCtxStream(t *testing.T) {
+	out := &bytes.Buffer{}
+	ag, err := NewAgent(AgentConfig{
+		Stdin:  &mgutil.IOWrapper{},
+		Stdout: &mgutil.IOWrapper{Writer: out},
+		Stderr: &mgutil.IOWrapper{},
+		Codec:  "msgpack",
+	})
+	if err != nil {
+		t.Fatalf("agent creation failed: %s", err)
+	}
+	ag.Store.Subscribe(ag.sub)
+
+	var streamMx *Ctx
+	ag.Store.Use(NewReducer(func(mx *Ctx) *State {
+		streamMx = mx
+		if !mx.Stream(mx.AddStatus("partial")) {
+			t.Error("mx.Stream() = (false) during the request; want (true)")
+		}
+		return mx.AddStatus("final")
+	}))
+
+	rq := newAgentReq(ag.Store)
+	rq.Cookie = "stream"
+	rq.Actions = []actions.ActionData{{Name: "QueryIssues"}}
+	rq.finalize(ag)
+	ag.Store.handleReq(rq)
+
+	if streamMx.Stream(streamMx.State) {
+		t.Error("mx.Stream() = (true) after the final response; want (false)")
+	}
+
+	dec := codec.NewDecoder(out, ag.handle)
+	for _, partial := range []bool{true, false} {
+		var res struct {
+			Cookie  string
+			Partial bool
+		}
+		if err := dec.Decode(&res); err != nil {
+			t.Fatalf("dec.Decode(): %s", err)
+		}
+		if res.Cookie != rq.Cookie || res.Partial != partial {
+			t.Errorf("res = (%s, partial=%v); want (%s, partial=%v)", res.Cookie, res.Partial, rq.Cookie, partial)
+		}
+	}
+}
+
+func TestAgentActionDeadline(t *testing.T) {
+	ag, err := NewAgent(AgentConfig{
+		Stdin:  &mgutil.IOWrapper{},
+		Stdout: &mgutil.IOWrapper{},
+		Stderr: &mgutil.IOWrapper{},
+		Codec:  "msgpack",
+	})
+	if err != nil {
+		t.Fatalf("agent creation failed: %s", err)
+	}
+	var deadlineSet bool
+	var ctxErr error
+	ag.Store.Use(NewReducer(func(mx *Ctx) *State {
+		if _, ok := mx.Action.(QueryCompletions); ok {
+			_, deadlineSet = mx.Deadline()
+			select {
+			case <-mx.Done():
+				ctxErr = mx.Err()
+			case <-time.After(5 * time.Second):
+			}
+		}
+		return mx.State
+	}))
+
+	rq := newAgentReq(ag.Store)
+	dl := time.Now().Add(50 * time.Millisecond).UTC().Format(ipcTimeLayout)
+	rq.Actions = []actions.ActionData{{Name: "QueryCompletions", Deadline: dl}}
+	rq.finalize(ag)
+	start := time.Now()
+	ag.Store.handleReq(rq)
+
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("handleReq took %s; want it to return shortly after the deadline", d)
+	}
+	if !deadlineSet {
+		t.Errorf("mx.Deadline() was not set")
+	}
+	if ctxErr != context.DeadlineExceeded {
+		t.Errorf("mx.Err() = (%v); want (%v)", ctxErr, context.DeadlineExceeded)
+	}
+}
@@ -1,13 +1,22 @@
 package mg
 
 import (
+	"margo.sh/mgutil"
+	"reflect"
 	"sync"
+	"time"
 )
 
 var (
 	_ KVStore = (KVStores)(nil)
 	_ KVStore = (*Store)(nil)
 	_ KVStore = (*KVMap)(nil)
+	_ KVStore = (*KVLRU)(nil)
+	_ KVStore = (*KVDisk)(nil)
+	_ Tx      = (*kvTx)(nil)
+
+	// mgutil.Cache can be used with any KVStore
+	_ mgutil.KVStore = (KVStore)(nil)
 )
 
 // KVStore represents a generic key value store.
@@ -26,6 +35,34 @@ type KVStore interface {
 
 	// Del removes the value identified by key from the store
 	Del(key interface{})
+
+	// DelPrefix removes the values whose keys are strings that start with prefix e.g. "file:/src/main.go:"
+	// so all the values of a file, or reducer, can be invalidated at once, without keeping track of their keys.
+	//
+	// Keys stored through a namespace are matched as the namespace, a /, then the key
+	// e.g. DelPrefix("gocode/") removes all the values in namespace gocode.
+	// Calling DelPrefix on a namespace only matches the keys in it.
+	DelPrefix(prefix string)
+
+	// Namespace returns a view of the store in which keys are scoped to the namespace prefix,
+	// so they don't collide with the keys of other namespaces, or of the store itself.
+	//
+	// Reducers sharing a store e.g. mx.Store, should use a namespace named after themselves.
+	// Namespaces may be nested.
+	Namespace(prefix string) KVStore
+
+	// Range calls f for each key and value in the store, in no particular order, until f returns false.
+	//
+	// f is called with a snapshot of the values, so it may use the store e.g. to delete the values it's called with.
+	Range(f func(key, value interface{}) bool)
+
+	// PutBatch, GetBatch and DelBatch are the equivalent of calling Put, Get or Del for each value or key,
+	// but the store is only locked once e.g. to replace the per-file values after a workspace-wide operation.
+	//
+	// GetBatch returns the values in the same order as keys.
+	PutBatch(values map[interface{}]interface{})
+	GetBatch(keys []interface{}) []interface{}
+	DelBatch(keys []interface{})
 }
 
 // KVStores implements a KVStore that duplicates its operations on a list of k/v stores
@@ -43,6 +80,68 @@ func (kvl KVStores) Put(k, v interface{}) {
 	}
 }
 
+// Namespace implements KVStore.Namespace
+func (kvl KVStores) Namespace(prefix string) KVStore {
+	return newKVNamespace(kvl, prefix)
+}
+
+// PutBatch calls .PutBatch on each of k/v stores in the list
+func (kvl KVStores) PutBatch(vals map[interface{}]interface{}) {
+	for _, kvs := range kvl {
+		if kvs == nil {
+			continue
+		}
+		kvs.PutBatch(vals)
+	}
+}
+
+// GetBatch returns, for each key, the first value found in the list of k/v stores
+func (kvl KVStores) GetBatch(keys []interface{}) []interface{} {
+	vals := make([]interface{}, len(keys))
+	for _, kvs := range kvl {
+		if kvs == nil {
+			continue
+		}
+		for i, v := range kvs.GetBatch(keys) {
+			if vals[i] == nil {
+				vals[i] = v
+			}
+		}
+	}
+	return vals
+}
+
+// DelBatch calls .DelBatch on each of k/v stores in the list
+func (kvl KVStores) DelBatch(keys []interface{}) {
+	for _, kvs := range kvl {
+		if kvs == nil {
+			continue
+		}
+		kvs.DelBatch(keys)
+	}
+}
+
+// Range calls f for each key in the list of k/v stores, with the value returned by Get
+func (kvl KVStores) Range(f func(k, v interface{}) bool) {
+	seen := map[interface{}]bool{}
+	for _, kvs := range kvl {
+		if kvs == nil {
+			continue
+		}
+		more := true
+		kvs.Range(func(k, v interface{}) bool {
+			if !seen[k] {
+				seen[k] = true
+				more = f(k, v)
+			}
+			return more
+		})
+		if !more {
+			return
+		}
+	}
+}
+
 // Get returns the first value identified by k found in the list of k/v stores
 func (kvl KVStores) Get(k interface{}) interface{} {
 	for _, kvs := range kvl {
@@ -69,10 +168,26 @@ func (kvl KVStores) Del(k interface{}) {
 // KVMap implements a KVStore using a map.
 // The zero-value is safe for use with all operations.
 //
+// Values stored using PutTTL expire; expired values are treated as if they were deleted.
+//
 // NOTE: All operations are no-ops on a nil KVMap
 type KVMap struct {
 	vals map[interface{}]interface{}
 	mu   sync.Mutex
+
+	// exp holds the expiry time of the values stored using PutTTL
+	exp map[interface{}]time.Time
+
+	// watchers holds the functions passed to Watch
+	watchers map[interface{}][]*kvWatcher
+
+	// loads holds the loads in progress, and the errors of failed loads, of GetOrLoad
+	loads kvLoads
+
+	// locks holds the key-level locks of LockKey
+	locks kvKeyLocks
+
+	counters kvCounters
 }
 
 // Put implements KVStore.Put
@@ -81,6 +196,9 @@ func (m *KVMap) Put(k interface{}, v interface{}) {
 		return
 	}
 
+	var notes kvNotes
+	defer notes.deliver()
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -88,6 +206,9 @@ func (m *KVMap) Put(k interface{}, v interface{}) {
 		m.vals = map[interface{}]interface{}{}
 	}
 	m.vals[k] = v
+	delete(m.exp, k)
+	m.note(&notes, k, v)
+	m.counters.add(&m.counters.puts, 1)
 }
 
 // Get implements KVStore.Get
@@ -99,7 +220,12 @@ func (m *KVMap) Get(k interface{}) interface{} {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	return m.vals[k]
+	var v interface{}
+	if !m.expired(k, time.Now()) {
+		v = m.vals[k]
+	}
+	m.counters.get(v != nil)
+	return v
 }
 
 // Del implements KVStore.Del
@@ -108,10 +234,97 @@ func (m *KVMap) Del(k interface{}) {
 		return
 	}
 
+	var notes kvNotes
+	defer notes.deliver()
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	if _, ok := m.vals[k]; ok {
+		m.note(&notes, k, nil)
+		m.counters.add(&m.counters.dels, 1)
+	}
 	delete(m.vals, k)
+	delete(m.exp, k)
+}
+
+// Namespace implements KVStore.Namespace
+func (m *KVMap) Namespace(prefix string) KVStore {
+	return newKVNamespace(m, prefix)
+}
+
+// PutBatch implements KVStore.PutBatch
+func (m *KVMap) PutBatch(vals map[interface{}]interface{}) {
+	if m == nil || len(vals) == 0 {
+		return
+	}
+
+	var notes kvNotes
+	defer notes.deliver()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.vals == nil {
+		m.vals = make(map[interface{}]interface{}, len(vals))
+	}
+	for k, v := range vals {
+		m.vals[k] = v
+		delete(m.exp, k)
+		m.note(&notes, k, v)
+	}
+	m.counters.add(&m.counters.puts, len(vals))
+}
+
+// GetBatch implements KVStore.GetBatch
+func (m *KVMap) GetBatch(keys []interface{}) []interface{} {
+	vals := make([]interface{}, len(keys))
+	if m == nil {
+		return vals
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for i, k := range keys {
+		if !m.expired(k, now) {
+			vals[i] = m.vals[k]
+		}
+		m.counters.get(vals[i] != nil)
+	}
+	return vals
+}
+
+// DelBatch implements KVStore.DelBatch
+func (m *KVMap) DelBatch(keys []interface{}) {
+	if m == nil {
+		return
+	}
+
+	var notes kvNotes
+	defer notes.deliver()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, k := range keys {
+		if _, ok := m.vals[k]; ok {
+			m.note(&notes, k, nil)
+			m.counters.add(&m.counters.dels, 1)
+		}
+		delete(m.vals, k)
+		delete(m.exp, k)
+	}
+}
+
+// Range implements KVStore.Range
+func (m *KVMap) Range(f func(k, v interface{}) bool) {
+	for k, v := range m.Values() {
+		if !f(k, v) {
+			return
+		}
+	}
 }
 
 // Clear removes all values from the store
@@ -120,10 +333,44 @@ func (m *KVMap) Clear() {
 		return
 	}
 
+	var notes kvNotes
+	defer notes.deliver()
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	for k := range m.watchers {
+		if _, ok := m.vals[k]; ok {
+			m.note(&notes, k, nil)
+		}
+	}
+	m.counters.add(&m.counters.evictions, len(m.vals))
 	m.vals = nil
+	m.exp = nil
+}
+
+// Stats returns the map's counters
+func (m *KVMap) Stats() KVStats {
+	if m == nil {
+		return KVStats{}
+	}
+	return m.counters.Stats()
+}
+
+// Len returns the number of values stored
+func (m *KVMap) Len() int {
+	if m == nil {
+		return 0
+	}
+
+	var notes kvNotes
+	defer notes.deliver()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sweep(time.Now(), &notes)
+	return len(m.vals)
 }
 
 // Values returns a copy of all values stored
@@ -132,12 +379,234 @@ func (m *KVMap) Values() map[interface{}]interface{} {
 		return nil
 	}
 
+	var notes kvNotes
+	defer notes.deliver()
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	m.sweep(time.Now(), &notes)
 	vals := make(map[interface{}]interface{}, len(m.vals))
 	for k, v := range m.vals {
 		vals[k] = v
 	}
 	return vals
 }
+
+// Tx is a KVStore whose operations are part of a transaction. See KVMap.Update
+type Tx interface {
+	KVStore
+}
+
+// kvTx implements Tx for KVMap.Update.
+// Writes are buffered in puts and dels until the transaction is committed.
+type kvTx struct {
+	m    *KVMap
+	puts map[interface{}]interface{}
+	dels map[interface{}]bool
+	done bool
+}
+
+// Put implements KVStore.Put
+func (tx *kvTx) Put(k, v interface{}) {
+	tx.check()
+	if tx.puts == nil {
+		tx.puts = map[interface{}]interface{}{}
+	}
+	delete(tx.dels, k)
+	tx.puts[k] = v
+}
+
+// Get implements KVStore.Get
+func (tx *kvTx) Get(k interface{}) interface{} {
+	tx.check()
+	if v, ok := tx.puts[k]; ok {
+		return v
+	}
+	if tx.dels[k] || tx.m.expired(k, time.Now()) {
+		return nil
+	}
+	return tx.m.vals[k]
+}
+
+// Del implements KVStore.Del
+func (tx *kvTx) Del(k interface{}) {
+	tx.check()
+	if tx.dels == nil {
+		tx.dels = map[interface{}]bool{}
+	}
+	delete(tx.puts, k)
+	tx.dels[k] = true
+}
+
+// Namespace implements KVStore.Namespace
+func (tx *kvTx) Namespace(prefix string) KVStore {
+	return newKVNamespace(tx, prefix)
+}
+
+// PutBatch implements KVStore.PutBatch
+func (tx *kvTx) PutBatch(vals map[interface{}]interface{}) {
+	for k, v := range vals {
+		tx.Put(k, v)
+	}
+}
+
+// GetBatch implements KVStore.GetBatch
+func (tx *kvTx) GetBatch(keys []interface{}) []interface{} {
+	vals := make([]interface{}, len(keys))
+	for i, k := range keys {
+		vals[i] = tx.Get(k)
+	}
+	return vals
+}
+
+// DelBatch implements KVStore.DelBatch
+func (tx *kvTx) DelBatch(keys []interface{}) {
+	for _, k := range keys {
+		tx.Del(k)
+	}
+}
+
+// Range implements KVStore.Range
+func (tx *kvTx) Range(f func(k, v interface{}) bool) {
+	tx.check()
+	l := make(map[interface{}]interface{}, len(tx.m.vals)+len(tx.puts))
+	now := time.Now()
+	for k, v := range tx.m.vals {
+		if !tx.dels[k] && !tx.m.expired(k, now) {
+			l[k] = v
+		}
+	}
+	for k, v := range tx.puts {
+		l[k] = v
+	}
+	for k, v := range l {
+		if !f(k, v) {
+			return
+		}
+	}
+}
+
+func (tx *kvTx) check() {
+	if tx.done {
+		panic("mg: Tx used after KVMap.Update returned")
+	}
+}
+
+// commit applies the buffered writes to the map, adding the changes to notes. It's called with tx.m.mu held.
+func (tx *kvTx) commit(notes *kvNotes) {
+	m := tx.m
+	for k := range tx.dels {
+		if _, ok := m.vals[k]; ok {
+			m.note(notes, k, nil)
+			m.counters.add(&m.counters.dels, 1)
+		}
+		delete(m.vals, k)
+		delete(m.exp, k)
+	}
+	if len(tx.puts) != 0 && m.vals == nil {
+		m.vals = make(map[interface{}]interface{}, len(tx.puts))
+	}
+	for k, v := range tx.puts {
+		m.vals[k] = v
+		delete(m.exp, k)
+		m.note(notes, k, v)
+	}
+	m.counters.add(&m.counters.puts, len(tx.puts))
+}
+
+// Update calls f with a Tx through which it can read and write several values atomically
+// e.g. to check whether a value is set before setting it, without racing with other goroutines.
+//
+// The map is locked while f runs so f should be quick, and must not use the map other than through tx.
+// The writes made through tx are applied when f returns. If f panics, they're discarded.
+// tx must not be used after f returns.
+//
+// NOTE: Update is a no-op on a nil KVMap
+func (m *KVMap) Update(f func(tx Tx)) {
+	if m == nil {
+		return
+	}
+
+	var notes kvNotes
+	defer notes.deliver()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tx := &kvTx{m: m}
+	defer func() { tx.done = true }()
+	f(tx)
+	tx.commit(&notes)
+}
+
+// CAS stores the value new with identifier k, if the value currently stored is old, and reports whether it did.
+//
+// Values are compared using ==, so old must be comparable. An old value of nil means there must be no value.
+// If new is nil, the value is deleted.
+//
+// NOTE: CAS is a no-op on a nil KVMap
+func (m *KVMap) CAS(k, old, new interface{}) bool {
+	swapped := false
+	m.UpdateKey(k, func(cur interface{}) interface{} {
+		if (cur != nil || old != nil) && !sameValue(cur, old) {
+			return cur
+		}
+		swapped = true
+		return new
+	})
+	return swapped
+}
+
+// UpdateKey replaces the value identified by k with the value returned by f, and returns it
+// e.g. to increment a counter, without racing with other goroutines.
+//
+// f is called with the current value, or nil if there's none. If it returns nil, the value is deleted.
+// The map is locked while f runs so f should be quick, and must not use the map.
+// If f returns the current value, nothing is changed.
+// Otherwise, like Put, storing the value removes its expiry. See PutTTL
+//
+// NOTE: UpdateKey is a no-op on a nil KVMap
+func (m *KVMap) UpdateKey(k interface{}, f func(old interface{}) (new interface{})) interface{} {
+	if m == nil {
+		return nil
+	}
+
+	var notes kvNotes
+	defer notes.deliver()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var old interface{}
+	if !m.expired(k, time.Now()) {
+		old = m.vals[k]
+	}
+	v := f(old)
+	switch {
+	case v == nil && old == nil:
+	case v == nil:
+		delete(m.vals, k)
+		delete(m.exp, k)
+		m.note(&notes, k, nil)
+		m.counters.add(&m.counters.dels, 1)
+	case sameValue(v, old):
+	default:
+		if m.vals == nil {
+			m.vals = map[interface{}]interface{}{}
+		}
+		m.vals[k] = v
+		delete(m.exp, k)
+		m.note(&notes, k, v)
+		m.counters.add(&m.counters.puts, 1)
+	}
+	return v
+}
+
+// sameValue returns true if a and b are comparable and equal
+func sameValue(a, b interface{}) bool {
+	if a == nil || b == nil || !reflect.TypeOf(a).Comparable() || !reflect.TypeOf(b).Comparable() {
+		return false
+	}
+	return a == b
+}
@@ -0,0 +1,128 @@
+package mg
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestKVStoreRange(t *testing.T) {
+	rangeKeys := func(kvs KVStore) string {
+		var l []string
+		kvs.Range(func(k, v interface{}) bool {
+			l = append(l, fmt.Sprintf("%v=%v", k, v))
+			return true
+		})
+		sort.Strings(l)
+		return strings.Join(l, " ")
+	}
+
+	sto := NewTestingStore()
+	sto.Put("a", 1)
+	sto.Put("b", 2)
+	sto.Namespace("ns").Put("a", 3)
+	sto.PutTTL("expired", 4, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if s, want := rangeKeys(sto.Namespace("ns")), "a=3"; s != want {
+		t.Errorf("Range over the namespace returned (%s); want (%s)", s, want)
+	}
+	if s, want := rangeKeys(KVStores{&KVMap{}, sto.Namespace("ns"), sto}), "a=3 b=2 {ns a}=3"; s != want {
+		t.Errorf("Range over KVStores returned (%s); want (%s)", s, want)
+	}
+
+	sto.Range(func(k, v interface{}) bool {
+		if k == "b" {
+			sto.Del(k)
+		}
+		return true
+	})
+	sto.Update(func(tx Tx) {
+		tx.Put("c", 5)
+		tx.Del("a")
+		if s, want := rangeKeys(tx), "c=5 {ns a}=3"; s != want {
+			t.Errorf("Range in the transaction returned (%s); want (%s)", s, want)
+		}
+	})
+
+	n := 0
+	sto.Range(func(k, v interface{}) bool {
+		n++
+		return false
+	})
+	if n != 1 {
+		t.Errorf("Range called f %d times after it returned false; want 1", n)
+	}
+
+	c := NewKVLRU(0, 0)
+	c.Put("a", 1)
+	c.Put("b", 2)
+	if s, want := rangeKeys(c), "a=1 b=2"; s != want {
+		t.Errorf("Range over the KVLRU returned (%s); want (%s)", s, want)
+	}
+}
+
+func TestKVMapCAS(t *testing.T) {
+	m := &KVMap{}
+	if !m.CAS("k", nil, 1) || m.CAS("k", nil, 2) || m.Get("k") != 1 {
+		t.Fatalf("CAS(k, nil, ...) didn't only store the value when there was none")
+	}
+	if m.CAS("k", 2, 3) || !m.CAS("k", 1, 3) || m.Get("k") != 3 {
+		t.Errorf("CAS(k, old, ...) didn't only store the value when the current value was old")
+	}
+	if !m.CAS("k", 3, nil) || m.Get("k") != nil {
+		t.Errorf("CAS(k, old, nil) didn't delete the value")
+	}
+	if m.CAS("k", []int{}, 1) {
+		t.Errorf("CAS(k, []int{}, ...) matched a missing value")
+	}
+
+	var changes int64
+	m.Watch("n", func(KVChange) { atomic.AddInt64(&changes, 1) })
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				m.UpdateKey("n", func(old interface{}) interface{} {
+					n, _ := old.(int)
+					return n + 1
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	if n := m.Get("n"); n != 1000 || changes != 1000 {
+		t.Errorf("the counter is (%v) after 1000 concurrent increments, with %d changes; want 1000", n, changes)
+	}
+	m.UpdateKey("n", func(old interface{}) interface{} { return old })
+	if changes != 1000 {
+		t.Errorf("UpdateKey notified the watcher when the value didn't change")
+	}
+}
+
+func TestKVStoreBatch(t *testing.T) {
+	stores := map[string]KVStore{
+		"KVMap":     &KVMap{},
+		"KVLRU":     NewKVLRU(0, 0),
+		"KVDisk":    NewKVDisk(t.TempDir(), nil),
+		"Namespace": (&KVMap{}).Namespace("ns"),
+		"KVStores":  KVStores{&KVMap{}, &KVMap{}},
+		"KVShards":  NewKVShards(4),
+	}
+	for name, kvs := range stores {
+		kvs.Put("c", 3)
+		kvs.PutBatch(map[interface{}]interface{}{"a": 1, "b": 2})
+		if l := kvs.GetBatch([]interface{}{"c", "a", "missing", "b"}); len(l) != 4 || l[0] != 3 || l[1] != 1 || l[2] != nil || l[3] != 2 {
+			t.Errorf("%s: GetBatch(c, a, missing, b) = (%v); want (3, 1, nil, 2)", name, l)
+		}
+		kvs.DelBatch([]interface{}{"a", "c"})
+		if a, b, c := kvs.Get("a"), kvs.Get("b"), kvs.Get("c"); a != nil || b != 2 || c != nil {
+			t.Errorf("%s: Get(a, b, c) = (%v, %v, %v) after DelBatch(a, c); want (nil, 2, nil)", name, a, b, c)
+		}
+	}
+}
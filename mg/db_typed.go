@@ -0,0 +1,30 @@
+package mg
+
+// StoreValue returns the value of type T stored in kvs using identifier key.
+//
+// If there's no value, or the value isn't of type T, the zero value of T and false are returned.
+func StoreValue[T any](kvs KVStore, key interface{}) (T, bool) {
+	v, ok := kvs.Get(key).(T)
+	return v, ok
+}
+
+// PutValue stores v in kvs using identifier key.
+//
+// It's the equivalent of kvs.Put(key, v), with the type of v checked at compile time.
+func PutValue[T any](kvs KVStore, key interface{}, v T) {
+	kvs.Put(key, v)
+}
+
+// Memo returns the value of type T stored in kvs using identifier key.
+// If there's no such value, it's created by calling new and stored in kvs.
+//
+// new is not synchronised so it may be called concurrently for the same key;
+// the value stored by the last call wins.
+func Memo[T any](kvs KVStore, key interface{}, new func() T) T {
+	if v, ok := StoreValue[T](kvs, key); ok {
+		return v
+	}
+	v := new()
+	PutValue(kvs, key, v)
+	return v
+}
@@ -0,0 +1,29 @@
+package mg
+
+import (
+	"testing"
+)
+
+func TestTypedValues(t *testing.T) {
+	kvs := &KVMap{}
+	type K struct{}
+	if v, ok := StoreValue[int](kvs, K{}); ok || v != 0 {
+		t.Errorf("StoreValue() of a missing value = (%d, %v); want (0, false)", v, ok)
+	}
+	PutValue(kvs, K{}, "str")
+	if v, ok := StoreValue[int](kvs, K{}); ok || v != 0 {
+		t.Errorf("StoreValue() of a string = (%d, %v); want (0, false)", v, ok)
+	}
+	if v, ok := StoreValue[string](kvs, K{}); !ok || v != "str" {
+		t.Errorf("StoreValue() = (%s, %v); want (str, true)", v, ok)
+	}
+
+	calls := 0
+	new := func() []int { calls++; return []int{calls} }
+	type M struct{}
+	a := Memo(kvs, M{}, new)
+	b := Memo(kvs, M{}, new)
+	if calls != 1 || len(a) != 1 || len(b) != 1 || a[0] != 1 || b[0] != 1 {
+		t.Errorf("Memo() called new %d times and returned (%v, %v); want the first value to be cached", calls, a, b)
+	}
+}
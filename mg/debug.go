@@ -0,0 +1,139 @@
+package mg
+
+import (
+	"expvar"
+	"fmt"
+	"github.com/ugorji/go/codec"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"sync"
+)
+
+var (
+	// debugVars holds the agent whose stats are published by expvar as `margo`.
+	// expvar variables are global, so only the agent that most recently started a debug server is published.
+	debugVars struct {
+		sync.Mutex
+		once sync.Once
+		ag   *Agent
+	}
+)
+
+// debugStats is the form in which the agent's stats are published by expvar
+type debugStats struct {
+	Name     string
+	Clients  int
+	InFlight int
+	Requests int64
+	Metrics  Metrics
+}
+
+// parseDebugAddr validates the debug server address s.
+// If s has no host, the server listens on 127.0.0.1.
+func parseDebugAddr(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return "", fmt.Errorf("Invalid debug address '%s'. Expected e.g. 127.0.0.1:6060", s)
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return "", fmt.Errorf("Invalid debug address '%s'. The debug server only listens on localhost", s)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// startDebugServer starts the debug HTTP server, if configured, and returns a function that stops it.
+//
+// It serves:
+// * /debug/pprof/... the net/http/pprof profiles
+// * /debug/vars the expvar variables, including the agent's request and action stats as `margo`
+// * /debug/margo/state the current State, as it would be sent to the client, in JSON
+// * /debug/margo/kv the values of the Store's KVMap whose types are registered using RegisterKVCodecType, in JSON
+func (ag *Agent) startDebugServer() (stop func()) {
+	if ag.debugAddr == "" {
+		return func() {}
+	}
+
+	l, err := net.Listen("tcp", ag.debugAddr)
+	if err != nil {
+		ag.Log.Println("debug: cannot start server:", err)
+		return func() {}
+	}
+
+	debugVars.Lock()
+	debugVars.ag = ag
+	debugVars.Unlock()
+	debugVars.once.Do(func() {
+		expvar.Publish("margo", expvar.Func(func() interface{} {
+			debugVars.Lock()
+			defer debugVars.Unlock()
+
+			if ag := debugVars.ag; ag != nil {
+				return ag.debugStats()
+			}
+			return nil
+		}))
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/margo/state", ag.serveDebugState)
+	mux.HandleFunc("/debug/margo/kv", ag.serveDebugKV)
+
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(l)
+	ag.Log.Printf("debug: serving http://%s/debug/pprof/\n", l.Addr())
+
+	return func() {
+		srv.Close()
+
+		debugVars.Lock()
+		defer debugVars.Unlock()
+
+		if debugVars.ag == ag {
+			debugVars.ag = nil
+		}
+	}
+}
+
+func (ag *Agent) debugStats() debugStats {
+	inFlight, total := ag.reqs.stats()
+	return debugStats{
+		Name:     ag.Name,
+		Clients:  len(ag.clients.list()),
+		InFlight: inFlight,
+		Requests: total,
+		Metrics:  ag.Store.metrics.snapshot(),
+	}
+}
+
+func (ag *Agent) serveDebugState(w http.ResponseWriter, r *http.Request) {
+	sto := ag.Store
+	sto.mu.Lock()
+	st := sto.state
+	sto.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	res := agentRes{State: st}.finalize()
+	if err := codec.NewEncoder(w, codecHandles["json"]).Encode(res); err != nil {
+		ag.Log.Println("debug: cannot encode state:", err)
+	}
+}
+
+func (ag *Agent) serveDebugKV(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := ag.Store.KVMap.EncodeTo(codecHandles["json"], w); err != nil {
+		ag.Log.Println("debug: cannot encode kv:", err)
+	}
+}
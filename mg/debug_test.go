@@ -0,0 +1,80 @@
+package mg
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"margo.sh/mgutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAgentDebugServer(t *testing.T) {
+	if _, err := NewAgent(AgentConfig{DebugAddr: "0.0.0.0:6060", Stderr: &mgutil.IOWrapper{}}); err == nil {
+		t.Errorf("NewAgent(DebugAddr: 0.0.0.0:6060) = (nil); want an error because it's not a localhost address")
+	}
+
+	logR, logW := io.Pipe()
+	addrC := make(chan string, 1)
+	go func() {
+		scanner := bufio.NewScanner(logR)
+		for scanner.Scan() {
+			ln := scanner.Text()
+			if i := strings.Index(ln, "debug: serving http://"); i >= 0 {
+				addrC <- strings.TrimSuffix(ln[i+len("debug: serving http://"):], "/debug/pprof/")
+			}
+		}
+	}()
+
+	inR, inW := io.Pipe()
+	ag, err := NewAgent(AgentConfig{
+		Stdin:     inR,
+		Stdout:    &mgutil.IOWrapper{},
+		Stderr:    logW,
+		Codec:     "msgpack",
+		DebugAddr: "127.0.0.1:0",
+	})
+	if err != nil {
+		t.Fatalf("agent creation failed: %s", err)
+	}
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- ag.Run()
+		logW.Close()
+	}()
+	addr := <-addrC
+
+	get := func(path string) []byte {
+		res, err := http.Get("http://" + addr + path)
+		if err != nil {
+			t.Fatalf("GET %s: %s", path, err)
+		}
+		defer res.Body.Close()
+		p, _ := ioutil.ReadAll(res.Body)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: status = (%d); want (%d)", path, res.StatusCode, http.StatusOK)
+		}
+		return p
+	}
+
+	get("/debug/pprof/")
+	vars := struct{ Margo *debugStats }{}
+	if err := json.Unmarshal(get("/debug/vars"), &vars); err != nil || vars.Margo == nil {
+		t.Errorf("/debug/vars = (%+v, %v); want the margo stats", vars, err)
+	}
+	state := map[string]interface{}{}
+	if err := json.Unmarshal(get("/debug/margo/state"), &state); err != nil {
+		t.Errorf("/debug/margo/state: %s", err)
+	}
+	kv := struct{ Entries []interface{} }{}
+	if err := json.Unmarshal(get("/debug/margo/kv"), &kv); err != nil {
+		t.Errorf("/debug/margo/kv: %s", err)
+	}
+
+	inW.Close()
+	if err := <-runErr; err != nil {
+		t.Fatalf("ag.Run() = (%v); want (nil)", err)
+	}
+}
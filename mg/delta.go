@@ -0,0 +1,127 @@
+package mg
+
+import (
+	"bytes"
+	"github.com/ugorji/go/codec"
+	"reflect"
+	"strings"
+)
+
+const (
+	// featureStateDelta is the Hello feature through which a client opts in to state deltas
+	featureStateDelta = "StateDelta"
+)
+
+var (
+	// deltaEventFields are the State fields that describe something that happened
+	// rather than the state of the editor, so they're sent whenever they're set
+	deltaEventFields = map[string]bool{
+		"View":          true,
+		"ClientActions": true,
+	}
+)
+
+// stateDelta holds the state last sent to a client that opted in to state deltas.
+//
+// A client opts in by listing the feature `StateDelta` in its Hello.
+// All responses sent after that have agentRes.Delta set, and their State only contains
+// the fields whose value changed since the previous response.
+// A field that was cleared is sent with its empty value.
+// The client is expected to apply each State onto the one it already has.
+type stateDelta struct {
+	prev map[string][]byte
+}
+
+// diff returns the fields of st that changed since the last call
+func (sd *stateDelta) diff(h codec.Handle, st resState) map[string]interface{} {
+	first := sd.prev == nil
+	if first {
+		sd.prev = map[string][]byte{}
+	}
+
+	m := map[string]interface{}{}
+	for _, f := range encodedFields(reflect.ValueOf(st)) {
+		if deltaEventFields[f.name] {
+			if !f.val.IsZero() {
+				m[f.name] = f.val.Interface()
+			}
+			continue
+		}
+
+		var p []byte
+		if err := codec.NewEncoderBytes(&p, h).Encode(f.val.Interface()); err != nil {
+			m[f.name] = f.val.Interface()
+			continue
+		}
+		prev, seen := sd.prev[f.name]
+		sd.prev[f.name] = p
+		if seen && bytes.Equal(p, prev) {
+			continue
+		}
+		if !seen && first && f.val.IsZero() {
+			continue
+		}
+		m[f.name] = f.val.Interface()
+	}
+	return m
+}
+
+type encodedField struct {
+	name string
+	val  reflect.Value
+}
+
+// encodedFields returns the exported fields of the struct v, as seen by the encoder:
+// fields of embedded structs are promoted and shadowed by shallower fields of the same name,
+// and fields of type struct{} hide the promoted fields of the same name.
+func encodedFields(v reflect.Value) []encodedField {
+	var l []encodedField
+	seen := map[string]bool{}
+	hidden := reflect.TypeOf(struct{}{})
+	level := []reflect.Value{v}
+	for len(level) != 0 {
+		var next []reflect.Value
+		for _, v := range level {
+			t := v.Type()
+			for i := 0; i < t.NumField(); i++ {
+				sf := t.Field(i)
+				switch {
+				case sf.Anonymous && sf.Type.Kind() == reflect.Struct:
+					next = append(next, v.Field(i))
+				case sf.PkgPath != "" || strings.HasPrefix(sf.Name, "_") || seen[sf.Name]:
+				default:
+					seen[sf.Name] = true
+					if sf.Type != hidden {
+						l = append(l, encodedField{name: sf.Name, val: v.Field(i)})
+					}
+				}
+			}
+		}
+		level = next
+	}
+	return l
+}
+
+// finalizeRes returns res in the form in which it's sent to the client c.
+// It's called with agentClient.mu held.
+func (c *agentClient) finalizeRes(res agentRes) interface{} {
+	if c.delta == nil || res.State == nil {
+		return res.finalize()
+	}
+
+	out := struct {
+		_struct struct{} `codec:",omitempty"`
+
+		agentRes
+		State map[string]interface{}
+	}{}
+
+	out.agentRes = res
+	out.Delta = true
+	st := res.finalizeState()
+	if out.Error == "" {
+		out.Error = strings.Join([]string(res.State.Errors), "\n")
+	}
+	out.State = c.delta.diff(c.ag.handle, st)
+	return out
+}
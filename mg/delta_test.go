@@ -0,0 +1,57 @@
+package mg
+
+import (
+	"bytes"
+	"github.com/ugorji/go/codec"
+	"margo.sh/mgutil"
+	"testing"
+)
+
+func TestAgentStateDelta(t *testing.T) {
+	out := &bytes.Buffer{}
+	ag, err := NewAgent(AgentConfig{
+		Stdin:  &mgutil.IOWrapper{},
+		Stdout: &mgutil.IOWrapper{Writer: out},
+		Stderr: &mgutil.IOWrapper{},
+		Codec:  "msgpack",
+	})
+	if err != nil {
+		t.Fatalf("agent creation failed: %s", err)
+	}
+	c := ag.client
+	rq := newAgentReq(ag.Store)
+	rq.Hello = &Hello{Name: "test", Features: []string{featureStateDelta}}
+	if err := c.greet(rq); err != nil {
+		t.Fatalf("c.greet() = (%v); want (nil)", err)
+	}
+
+	st := ag.Store.NewCtx(nil).State
+	steps := []struct {
+		st     *State
+		status bool
+	}{
+		{st.AddStatus("a"), true},
+		{st.AddStatus("a"), false},
+		{st.AddStatus("b"), true},
+		{st, true},
+	}
+	dec := codec.NewDecoder(out, ag.handle)
+	for i, s := range steps {
+		if err := ag.sendTo(c, agentRes{State: s.st}); err != nil {
+			t.Fatalf("step %d: ag.sendTo() = (%v); want (nil)", i, err)
+		}
+		res := struct {
+			Delta bool
+			State map[string]interface{}
+		}{}
+		if err := dec.Decode(&res); err != nil {
+			t.Fatalf("step %d: dec.Decode(): %s", i, err)
+		}
+		if !res.Delta {
+			t.Errorf("step %d: res.Delta = (false); want (true)", i)
+		}
+		if _, ok := res.State["Status"]; ok != s.status {
+			t.Errorf("step %d: Status sent = (%v); want (%v)", i, ok, s.status)
+		}
+	}
+}
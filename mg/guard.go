@@ -0,0 +1,88 @@
+package mg
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// A Guard reports whether a reducer should handle the current action. See When
+type Guard func(mx *Ctx) bool
+
+// Guards is a list of guards that must all pass
+type Guards []Guard
+
+// When returns the list of guards that must all pass for a reducer to handle an action e.g.
+//
+//	sto.Use(mg.When(mg.LangIs(mg.Go), mg.PathMatches("*.go")).Reducer(r))
+func When(guards ...Guard) Guards {
+	return Guards(guards)
+}
+
+// Pass returns true if all the guards pass
+func (gs Guards) Pass(mx *Ctx) bool {
+	for _, g := range gs {
+		if !g(mx) {
+			return false
+		}
+	}
+	return true
+}
+
+// Reducer returns a reducer that calls r only when all the guards pass.
+//
+// The guards are checked in the reducer's RCond method so r is skipped before any of its methods is called.
+// r is still initialised, unmounted and its lifecycle hooks called, regardless of the guards.
+func (gs Guards) Reducer(r Reducer) Reducer {
+	return &guardedReducer{reducerWrapper: reducerWrapper{r: r}, guards: gs}
+}
+
+// LangIs returns a guard that passes if the view's language is one of langs. See View.LangIs
+func LangIs(langs ...Lang) Guard {
+	return func(mx *Ctx) bool { return mx.LangIs(langs...) }
+}
+
+// ActionIs returns a guard that passes if the action is one of acts. See Ctx.ActionIs
+func ActionIs(acts ...Action) Guard {
+	return func(mx *Ctx) bool { return mx.ActionIs(acts...) }
+}
+
+// PathMatches returns a guard that passes if the view's filename matches one of patterns.
+//
+// Patterns use the syntax of filepath.Match. Patterns without a path separator
+// are matched against the base name of the file e.g. `*.go`, others against its full path.
+func PathMatches(patterns ...string) Guard {
+	return func(mx *Ctx) bool {
+		fn := mx.View.Filename()
+		nm := filepath.Base(fn)
+		for _, pat := range patterns {
+			s := nm
+			if strings.ContainsAny(pat, `/\`) {
+				s = fn
+			}
+			if ok, _ := filepath.Match(pat, s); ok {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// guardedReducer implements Guards.Reducer
+type guardedReducer struct {
+	reducerWrapper
+	guards Guards
+}
+
+func (gr *guardedReducer) RLabel() string {
+	return "mg.When(" + ReducerLabel(gr.r) + ")"
+}
+
+// RCond always passes the lifecycle actions so r is initialised and its hooks are called,
+// even if the view isn't of interest
+func (gr *guardedReducer) RCond(mx *Ctx) bool {
+	return mx.ActionIs(initAction{}, ClientConnected{}, ViewClosed{}, ConfigChanged{}) || gr.guards.Pass(mx)
+}
+
+func (gr *guardedReducer) Reduce(mx *Ctx) *State {
+	return gr.call(mx)
+}
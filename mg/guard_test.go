@@ -0,0 +1,44 @@
+package mg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGuards(t *testing.T) {
+	h := NewHarness(t)
+	var seen []string
+	h.Store.Use(When(LangIs(Go), PathMatches("*.go")).Reducer(NewReducer(func(mx *Ctx) *State {
+		if mx.ActionIs(QueryIssues{}) {
+			seen = append(seen, mx.View.Name)
+		}
+		return mx.State
+	}, func(rf *RFunc) {
+		rf.Label = "lint"
+		rf.Init = func(*Ctx) { seen = append(seen, "init") }
+		rf.Unmount = func(*Ctx) { seen = append(seen, "unmount") }
+	})))
+
+	h.Open("/src/README.md", "# margo", 0).Dispatch(initAction{}, QueryIssues{})
+	h.Open("/src/main_test.go", "package main", 0).Dispatch(QueryIssues{})
+	h.SetView(&View{Path: "/src/main.go", Src: []byte("package main"), Lang: JSON}).Dispatch(QueryIssues{})
+	h.Open("/src/README.md", "# margo", 0).Dispatch(unmount{})
+	if s, want := strings.Join(seen, " "), "init main_test.go unmount"; s != want {
+		t.Errorf("the reducer saw (%s); want (%s)", s, want)
+	}
+
+	mx := NewTestingCtx(nil)
+	mx.View = mx.View.Copy(func(v *View) { v.Path = "/src/internal/x.go" })
+	for pat, want := range map[string]bool{
+		"*.go":              true,
+		"*.mod":             false,
+		"/src/internal/*":   true,
+		"/src/*/*_test.go":  false,
+		"internal/x.go":     false,
+		"/src/internal/x.*": true,
+	} {
+		if got := PathMatches(pat)(mx); got != want {
+			t.Errorf("PathMatches(%s) = %v; want %v", pat, got, want)
+		}
+	}
+}
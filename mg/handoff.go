@@ -0,0 +1,291 @@
+package mg
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"margo.sh/mgutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+const (
+	// handoffEnv is the environment variable through which the previous agent
+	// passes the name of its handoff file to the new agent
+	handoffEnv = "MARGO_HANDOFF"
+)
+
+// HandoffKey is a Store.KVMap key whose value survives a graceful restart.
+//
+// When the agent restarts itself (see Restart), values stored using a HandoffKey
+// are encoded using encoding/gob and restored in the new agent,
+// so their types must be registered using gob.Register.
+// They're also saved and restored with the rest of the project's state if AgentConfig.StateDir is set.
+// Values that can't be encoded, or decoded by the new agent, are dropped.
+//
+// NOTE: like all Store.KVMap values, they're cleared when the view changes.
+type HandoffKey string
+
+// handoffState is the state written to disk by an agent that's restarting itself
+// and restored by the new agent.
+type handoffState struct {
+	// Input is the data read from the client but not yet handled,
+	// starting with the request that triggered the restart
+	Input []byte
+
+	View     View
+	Env      EnvMap
+	Editor   EditorProps
+	CacheKey struct{ Name, Hash string }
+	KV       []handoffValue
+}
+
+type handoffValue struct {
+	Key   HandoffKey
+	Value []byte
+}
+
+// agentHandoff holds the state of a graceful restart.
+//
+// When Restart is dispatched and the agent is communicating with its client
+// over its own stdin and stdout, it restarts itself instead of asking the client to restart it:
+// * when the next request is received, it waits for in-flight requests to finish
+// * it writes the handoff state to a file
+// * it cancels jobs and unmounts the reducers, as it would when exiting, but leaves the client's connection open
+// * reducers stop their subprocesses e.g. gopls and commands, and the project's state is saved to AgentConfig.StateDir
+// * it exec's the (newly built) agent binary
+// * the new agent, which inherits stdin and stdout, restores the state and handles the request
+//
+// If that's not possible, the Restart client action is sent as usual.
+type agentHandoff struct {
+	mu      sync.Mutex
+	pending bool
+	failed  bool
+
+	// exec replaces the agent's process. If it's nil, execAgent is used.
+	exec func(exe string, args, env []string) error
+}
+
+// requestHandoff arranges for the agent to restart itself when the next request is received.
+// It returns false if the client must restart the agent instead.
+func (ag *Agent) requestHandoff() bool {
+	if ag == nil || !ag.stdio || !handoffSupported || ag.protocol != ProtocolMargo {
+		return false
+	}
+
+	ho := &ag.handoff
+	ho.mu.Lock()
+	defer ho.mu.Unlock()
+
+	if ho.failed {
+		return false
+	}
+	ho.pending = true
+	return true
+}
+
+func (ag *Agent) handoffPending() bool {
+	ho := &ag.handoff
+	ho.mu.Lock()
+	defer ho.mu.Unlock()
+
+	return ho.pending
+}
+
+// handoffRestart restarts the agent, handing the request last read from c to the new agent.
+// It only returns if the restart failed, in which case the client is asked to restart the agent.
+func (ag *Agent) handoffRestart(c *agentClient) {
+	ag.Log.Println("restart: waiting for in-flight requests before restarting")
+	ag.wg.Wait()
+
+	stopped, err := ag.execHandoff(c)
+
+	ho := &ag.handoff
+	ho.mu.Lock()
+	ho.pending = false
+	ho.failed = true
+	ho.mu.Unlock()
+
+	ag.Log.Println("restart: cannot restart in place, asking the client to restart the agent:", err)
+	if !stopped {
+		ag.Store.Dispatch(Restart{})
+		return
+	}
+	// the store is unmounted so it no longer handles actions, send Restart directly
+	ag.sendTo(c, agentRes{State: ag.Store.NewCtx(nil).addClientActions(Restart{})})
+}
+
+// execHandoff replaces the agent's process with the new agent.
+// It only returns if that fails, stopped reports whether the agent was already shut down.
+func (ag *Agent) execHandoff(c *agentClient) (stopped bool, err error) {
+	if c.compression.zw != nil {
+		return false, fmt.Errorf("IPC compression is enabled")
+	}
+
+	exe, err := ag.executable()
+	if err != nil {
+		return false, err
+	}
+
+	fn, err := ag.writeHandoff(c)
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(fn)
+
+	env := []string{handoffEnv + "=" + fn}
+	for _, s := range os.Environ() {
+		if !strings.HasPrefix(s, handoffEnv+"=") && !strings.HasPrefix(s, "MARGO_BUILD_ERROR=") {
+			env = append(env, s)
+		}
+	}
+	ag.Log.Println("restart: shutting down before handing off to", exe)
+	ag.shutdownForHandoff()
+
+	exec := ag.handoff.exec
+	if exec == nil {
+		exec = execAgent
+	}
+	return true, exec(exe, os.Args, env)
+}
+
+// shutdownForHandoff does the parts of Agent.shutdown that must happen before the agent's process is replaced.
+// Jobs are cancelled, then the store is unmounted so reducers release their resources in RUnmount.
+// The client's connection is left open because the new agent inherits it.
+func (ag *Agent) shutdownForHandoff() {
+	sto := ag.Store
+	sto.jobs.shutdown()
+	ag.wg.Wait()
+	sto.unmount()
+	sto.sharedKV.close()
+}
+
+// executable returns the name of the (newly built) agent executable
+func (ag *Agent) executable() (string, error) {
+	exe, err := exec.LookPath(ag.Name)
+	if err != nil {
+		exe, err = os.Executable()
+	}
+	if err != nil {
+		return "", fmt.Errorf("cannot find the agent executable: %s", err)
+	}
+	return exe, nil
+}
+
+// writeHandoff writes the handoff state to a new file and returns its name
+func (ag *Agent) writeHandoff(c *agentClient) (string, error) {
+	sto := ag.Store
+	hs := handoffState{}
+	hs.Input = append(hs.Input, c.lastReq...)
+	if p, err := c.decRd.Peek(c.decRd.Buffered()); err == nil {
+		hs.Input = append(hs.Input, p...)
+	}
+
+	sto.mu.Lock()
+	st := sto.state
+	sto.mu.Unlock()
+	if st.View != nil {
+		hs.View = *st.View
+	}
+	hs.Env = st.Env
+	hs.Editor = st.Editor
+
+	sto.cache.RLock()
+	hs.CacheKey.Name, hs.CacheKey.Hash = sto.cache.vName, sto.cache.vHash
+	sto.cache.RUnlock()
+
+	hs.KV = sto.handoffValues("restart")
+
+	f, err := ioutil.TempFile("", "margo-handoff-")
+	if err != nil {
+		return "", fmt.Errorf("cannot create handoff file: %s", err)
+	}
+	defer f.Close()
+
+	if err := gob.NewEncoder(f).Encode(hs); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("cannot write handoff file: %s", err)
+	}
+	return f.Name(), nil
+}
+
+// handoffValues returns the values stored in Store.KVMap using a HandoffKey, encoded using encoding/gob.
+// Values that can't be encoded are logged, prefixed with logPfx, and dropped
+func (sto *Store) handoffValues(logPfx string) []handoffValue {
+	var l []handoffValue
+	for k, v := range sto.KVMap.Values() {
+		hk, ok := k.(HandoffKey)
+		if !ok {
+			continue
+		}
+		buf := &bytes.Buffer{}
+		if err := gob.NewEncoder(buf).Encode(&v); err != nil {
+			sto.ag.Log.Printf("%s: cannot hand off %s: %s\n", logPfx, hk, err)
+			continue
+		}
+		l = append(l, handoffValue{Key: hk, Value: buf.Bytes()})
+	}
+	return l
+}
+
+// restoreHandoffValues stores the values in l, returned by handoffValues, in Store.KVMap.
+// Values that can't be decoded are logged, prefixed with logPfx, and dropped
+func (sto *Store) restoreHandoffValues(logPfx string, l []handoffValue) {
+	for _, hv := range l {
+		var v interface{}
+		if err := gob.NewDecoder(bytes.NewReader(hv.Value)).Decode(&v); err != nil {
+			sto.ag.Log.Printf("%s: cannot restore %s: %s\n", logPfx, hv.Key, err)
+			continue
+		}
+		sto.Put(hv.Key, v)
+	}
+}
+
+// restoreHandoff restores the state handed off by the previous agent, if any,
+// and returns stdin with the input it didn't handle prepended.
+func (ag *Agent) restoreHandoff(stdin io.ReadCloser) (io.ReadCloser, error) {
+	fn := os.Getenv(handoffEnv)
+	if fn == "" {
+		return stdin, nil
+	}
+	os.Unsetenv(handoffEnv)
+	defer os.Remove(fn)
+
+	f, err := os.Open(fn)
+	if err != nil {
+		return stdin, fmt.Errorf("restart: cannot open handoff file: %s", err)
+	}
+	defer f.Close()
+
+	hs := handoffState{}
+	if err := gob.NewDecoder(f).Decode(&hs); err != nil {
+		return stdin, fmt.Errorf("restart: cannot read handoff file: %s", err)
+	}
+
+	sto := ag.Store
+	sto.mu.Lock()
+	sto.state = sto.state.Copy(func(st *State) {
+		v := hs.View
+		v.kvs = sto
+		st.View = &v
+		st.Env = hs.Env
+		st.Editor = hs.Editor
+	})
+	sto.mu.Unlock()
+
+	sto.cache.Lock()
+	sto.cache.vName, sto.cache.vHash = hs.CacheKey.Name, hs.CacheKey.Hash
+	sto.cache.Unlock()
+
+	sto.restoreHandoffValues("restart", hs.KV)
+
+	ag.Log.Printf("restart: restored state from the previous agent: %d cached values\n", len(hs.KV))
+	return &mgutil.IOWrapper{
+		Reader: io.MultiReader(bytes.NewReader(hs.Input), stdin),
+		Closer: stdin,
+	}, nil
+}
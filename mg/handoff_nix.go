@@ -0,0 +1,16 @@
+// +build !windows
+
+package mg
+
+import (
+	"syscall"
+)
+
+const (
+	handoffSupported = true
+)
+
+// execAgent replaces the current process with the agent exe
+func execAgent(exe string, args, env []string) error {
+	return syscall.Exec(exe, args, env)
+}
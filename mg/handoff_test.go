@@ -0,0 +1,159 @@
+package mg
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ugorji/go/codec"
+	"io"
+	"io/ioutil"
+	"margo.sh/mgutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAgentHandoff(t *testing.T) {
+	newAgent := func(out *bytes.Buffer) *Agent {
+		ag, err := NewAgent(AgentConfig{
+			Stdin:  &mgutil.IOWrapper{},
+			Stdout: &mgutil.IOWrapper{Writer: out},
+			Stderr: &mgutil.IOWrapper{},
+			Codec:  "msgpack",
+		})
+		if err != nil {
+			t.Fatalf("agent creation failed: %s", err)
+		}
+		return ag
+	}
+
+	prev := newAgent(&bytes.Buffer{})
+	key := HandoffKey("handoff-test")
+	prev.Store.Put(key, "warm")
+	prev.Store.Put("not-handed-off", "cold")
+	prev.Store.state = prev.Store.state.Copy(func(st *State) {
+		st.View = st.View.Copy(func(v *View) { v.Path = "/handoff/main.go" })
+	})
+	codec.NewEncoderBytes(&prev.client.lastReq, prev.handle).Encode(map[string]string{"Cookie": "handoff"})
+
+	fn, err := prev.writeHandoff(prev.client)
+	if err != nil {
+		t.Fatalf("writeHandoff() = (%v); want (nil)", err)
+	}
+	defer os.Remove(fn)
+	os.Setenv(handoffEnv, fn)
+	defer os.Unsetenv(handoffEnv)
+
+	out := &bytes.Buffer{}
+	ag := newAgent(out)
+	if v := ag.Store.Get(key); v != "warm" {
+		t.Errorf("Store.Get(%s) = (%v); want (warm)", key, v)
+	}
+	if v := ag.Store.Get("not-handed-off"); v != nil {
+		t.Errorf("Store.Get(not-handed-off) = (%v); want (nil)", v)
+	}
+	if p := ag.Store.state.View.Path; p != "/handoff/main.go" {
+		t.Errorf("View.Path = (%s); want (/handoff/main.go)", p)
+	}
+	if _, err := os.Stat(fn); !os.IsNotExist(err) {
+		t.Errorf("handoff file %s was not removed", fn)
+	}
+
+	if err := ag.Run(); err != nil {
+		t.Fatalf("ag.Run() = (%v); want (nil)", err)
+	}
+	dec := codec.NewDecoder(out, ag.handle)
+	for {
+		var res struct{ Cookie string }
+		if err := dec.Decode(&res); err != nil {
+			t.Fatalf("no response to the handed off request: %s", err)
+		}
+		if res.Cookie == "handoff" {
+			break
+		}
+	}
+}
+
+func TestAgentRestartOnRebuild(t *testing.T) {
+	exe := filepath.Join(t.TempDir(), "margo.test-agent")
+	if err := ioutil.WriteFile(exe, []byte("v1"), 0755); err != nil {
+		t.Fatalf("cannot create executable: %s", err)
+	}
+	outR, outW := io.Pipe()
+	ag, err := NewAgent(AgentConfig{
+		Stdin:  &mgutil.IOWrapper{},
+		Stdout: outW,
+		Stderr: &mgutil.IOWrapper{},
+		Codec:  "msgpack",
+	})
+	if err != nil {
+		t.Fatalf("agent creation failed: %s", err)
+	}
+	ag.stdio = true
+
+	stop := ag.watchExe(exe, 10*time.Millisecond)
+	defer stop()
+	time.Sleep(30 * time.Millisecond)
+	if ag.handoffPending() {
+		t.Fatal("restart requested before the executable was rebuilt")
+	}
+
+	if err := ioutil.WriteFile(exe, []byte("v2 is larger"), 0755); err != nil {
+		t.Fatalf("cannot rebuild executable: %s", err)
+	}
+
+	var res struct {
+		Notifications []struct{ Name string }
+	}
+	if err := codec.NewDecoder(outR, ag.handle).Decode(&res); err != nil {
+		t.Fatalf("dec.Decode(): %s", err)
+	}
+	if len(res.Notifications) != 1 || res.Notifications[0].Name != "Restarting" {
+		t.Errorf("res.Notifications = (%+v); want Restarting", res.Notifications)
+	}
+	if !ag.handoffPending() {
+		t.Error("restart wasn't requested after the executable was rebuilt")
+	}
+}
+
+func TestAgentHandoffShutdown(t *testing.T) {
+	outR, outW := io.Pipe()
+	ag, err := NewAgent(AgentConfig{
+		Stdin:  &mgutil.IOWrapper{},
+		Stdout: outW,
+		Stderr: &mgutil.IOWrapper{},
+		Codec:  "msgpack",
+	})
+	if err != nil {
+		t.Fatalf("agent creation failed: %s", err)
+	}
+	unmounted := false
+	ag.Store.Use(&RFunc{
+		Func:    func(mx *Ctx) *State { return mx.State },
+		Unmount: func(mx *Ctx) { unmounted = true },
+	})
+	ag.Store.mount()
+	ag.Store.handleAct(Render, nil)
+
+	unmountedBeforeExec := false
+	ag.handoff.exec = func(exe string, args, env []string) error {
+		unmountedBeforeExec = unmounted
+		return fmt.Errorf("exec failed")
+	}
+	go ag.handoffRestart(ag.client)
+
+	var res struct {
+		State struct {
+			ClientActions []struct{ Name string }
+		}
+	}
+	if err := codec.NewDecoder(outR, ag.handle).Decode(&res); err != nil {
+		t.Fatalf("dec.Decode(): %s", err)
+	}
+	if !unmountedBeforeExec {
+		t.Error("the reducers weren't unmounted before the agent's process was replaced")
+	}
+	if l := res.State.ClientActions; len(l) != 1 || l[0].Name != "Restart" {
+		t.Errorf("res.State.ClientActions = (%+v); want Restart after the failed exec", l)
+	}
+}
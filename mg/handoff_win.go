@@ -0,0 +1,15 @@
+// +build windows
+
+package mg
+
+import (
+	"fmt"
+)
+
+const (
+	handoffSupported = false
+)
+
+func execAgent(exe string, args, env []string) error {
+	return fmt.Errorf("exec is not supported on windows")
+}
@@ -0,0 +1,252 @@
+package mg
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ugorji/go/codec"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+const (
+	// GoldenUpdateEnv is the environment variable that, when set to 1,
+	// makes Harness.Golden write the golden files instead of comparing against them
+	GoldenUpdateEnv = "MARGO_UPDATE_GOLDEN"
+)
+
+var (
+	// goldenHandle encodes golden states. Map keys are sorted so the output is stable.
+	goldenHandle = func() *codec.JsonHandle {
+		h := &codec.JsonHandle{Indent: 2}
+		h.Canonical = true
+		return h
+	}()
+)
+
+// TestingT is the subset of testing.TB used by Harness
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+	Fatalf(format string, args ...interface{})
+}
+
+// Harness feeds a scripted sequence of views and actions to a store, for testing reducers,
+// and records the resulting states.
+//
+//	h := mg.NewHarness(t, &MyReducer{})
+//	h.Open("/src/main.go", "package main\n", 0)
+//	h.Dispatch(mg.QueryCompletions{})
+//	h.Golden("completions", "Completions")
+type Harness struct {
+	// Store is the store the script is fed to
+	Store *Store
+
+	t      TestingT
+	states []*State
+}
+
+// NewHarness returns a new Harness whose store is NewTestingStore(), with reducers added using Store.Use
+//
+// initAction is dispatched so reducers are initialised as they are when the agent starts.
+func NewHarness(t TestingT, reducers ...Reducer) *Harness {
+	h := &Harness{
+		Store: NewTestingStore(),
+		t:     t,
+	}
+	h.Store.Use(reducers...)
+	h.Store.Subscribe(func(mx *Ctx) {
+		h.states = append(h.states, mx.State)
+	})
+	h.Store.handleAct(initAction{}, nil)
+	h.states = nil
+	return h
+}
+
+// Open makes the file at path, whose content is src, the current view, with the cursor at byte offset pos.
+//
+// The view's Lang is derived from path. Use SetView to set other properties.
+func (h *Harness) Open(path, src string, pos int) *Harness {
+	return h.SetView(&View{
+		Path: path,
+		Name: filepath.Base(path),
+		Src:  []byte(src),
+		Pos:  pos,
+		Lang: harnessLang(path),
+	})
+}
+
+// SetView makes a copy of v the current view, as if it was sent by the editor
+func (h *Harness) SetView(v *View) *Harness {
+	sto := h.Store
+	v = v.Copy(func(v *View) { v.kvs = sto })
+	if v.Name == "" {
+		v.Name = filepath.Base(v.Path)
+	}
+	v.finalize()
+
+	sto.mu.Lock()
+	defer sto.mu.Unlock()
+
+	sto.state = sto.state.SetView(v)
+	return h
+}
+
+// Dispatch reduces each action in acts, in order, and returns the resulting state
+func (h *Harness) Dispatch(acts ...Action) *State {
+	for _, act := range acts {
+		h.Store.handleAct(act, nil)
+	}
+	return h.State()
+}
+
+// Play runs script, in which each item is either an Action to Dispatch or a *View to SetView,
+// and returns the states resulting from the actions
+func (h *Harness) Play(script ...interface{}) []*State {
+	h.t.Helper()
+
+	n := len(h.states)
+	for i, v := range script {
+		switch v := v.(type) {
+		case *View:
+			h.SetView(v)
+		case Action:
+			h.Dispatch(v)
+		default:
+			h.t.Fatalf("harness: script item %d is %T, not an Action or *View", i, v)
+		}
+	}
+	return append([]*State(nil), h.states[n:]...)
+}
+
+// States returns the states resulting from all the actions dispatched, oldest first
+func (h *Harness) States() []*State {
+	return append([]*State(nil), h.states...)
+}
+
+// State returns the state resulting from the last action dispatched, or the initial state
+func (h *Harness) State() *State {
+	if n := len(h.states); n != 0 {
+		return h.states[n-1]
+	}
+
+	sto := h.Store
+	sto.mu.Lock()
+	defer sto.mu.Unlock()
+
+	return sto.state
+}
+
+// Golden compares the current state, as sent to the client, with the golden file testdata/$name.golden.
+//
+// Only the fields named in fields are compared, or if fields is empty,
+// all the fields that are set except Env and Config, which depend on the machine running the test.
+// If the environment variable GoldenUpdateEnv is set to 1, the golden file is written instead.
+func (h *Harness) Golden(name string, fields ...string) {
+	h.t.Helper()
+
+	got, err := goldenState(h.State(), fields)
+	if err != nil {
+		h.t.Fatalf("harness: cannot encode the state: %s", err)
+	}
+
+	fn := filepath.Join("testdata", name+".golden")
+	if os.Getenv(GoldenUpdateEnv) == "1" {
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			h.t.Fatalf("harness: %s", err)
+		}
+		if err := ioutil.WriteFile(fn, got, 0644); err != nil {
+			h.t.Fatalf("harness: %s", err)
+		}
+		return
+	}
+
+	want, err := ioutil.ReadFile(fn)
+	if err != nil {
+		h.t.Fatalf("harness: %s\nRun the test with %s=1 to create it", err, GoldenUpdateEnv)
+	}
+	if !bytes.Equal(got, want) {
+		h.t.Errorf("harness: the state doesn't match %s:\n%s\nRun the test with %s=1 to update it",
+			fn, goldenDiff(string(want), string(got)), GoldenUpdateEnv)
+	}
+}
+
+// goldenState returns the fields of st, in the form in which it's sent to the client, encoded as JSON
+func goldenState(st *State, fields []string) ([]byte, error) {
+	rs := agentRes{State: st}.finalizeState()
+	want := StrSet(fields)
+	m := map[string]interface{}{}
+	for _, f := range encodedFields(reflect.ValueOf(&rs).Elem()) {
+		switch {
+		case len(want) != 0:
+			if !want.Has(f.name) {
+				continue
+			}
+		case f.name == "Env" || f.name == "Config" || f.val.IsZero():
+			continue
+		}
+		m[f.name] = f.val.Interface()
+	}
+
+	var p []byte
+	if err := codec.NewEncoderBytes(&p, goldenHandle).Encode(m); err != nil {
+		return nil, err
+	}
+	return append(p, '\n'), nil
+}
+
+// goldenDiff returns the lines that differ between want and got,
+// prefixed with `-` if they're only in want, or `+` if they're only in got
+func goldenDiff(want, got string) string {
+	a := strings.Split(want, "\n")
+	b := strings.Split(got, "\n")
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			fmt.Fprintf(buf, "-%4d: %s\n", i+1, a[i])
+			i++
+		default:
+			fmt.Fprintf(buf, "+%4d: %s\n", j+1, b[j])
+			j++
+		}
+	}
+	return buf.String()
+}
+
+// harnessLang returns the Lang of the file at path, as an editor would report it
+func harnessLang(path string) Lang {
+	switch nm := filepath.Base(path); {
+	case nm == "go.mod":
+		return GoMod
+	case nm == "go.sum":
+		return GoSum
+	default:
+		return Lang(strings.TrimPrefix(filepath.Ext(nm), "."))
+	}
+}
@@ -0,0 +1,42 @@
+package mg
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHarness(t *testing.T) {
+	h := NewHarness(t, NewReducer(func(mx *Ctx) *State {
+		switch mx.Action.(type) {
+		case QueryCompletions:
+			return mx.AddCompletions(Completion{Query: mx.View.Name, Src: string(mx.View.Src[mx.View.Pos:])})
+		case QueryIssues:
+			return mx.AddStatus(fmt.Sprintf("%s:%d:%d", mx.View.Lang, mx.View.Row, mx.View.Col))
+		}
+		return mx.State
+	}))
+	states := h.Play(
+		&View{Path: "/src/main.go", Src: []byte("package main\nfunc main() {}\n"), Pos: 18, Lang: Go},
+		QueryCompletions{},
+		QueryIssues{},
+		QueryCompletions{},
+	)
+	if len(states) != 3 {
+		t.Fatalf("Play() returned %d states; want one per action", len(states))
+	}
+	if !states[1].Status.Has("go:1:5") {
+		t.Errorf("Status = (%q); want the position in main.go", states[1].Status)
+	}
+	h.Golden("harness", "Completions", "Status")
+
+	h.Open("/src/go.mod", "module margo.sh\n", 7)
+	if st := h.Dispatch(QueryIssues{}); !st.Status.Has("go.mod:0:7") {
+		t.Errorf("Status = (%q); want the position in go.mod", st.Status)
+	}
+
+	want := "{\n  \"Status\": [\n    \"a\"\n  ]\n}\n"
+	got := "{\n  \"Status\": [\n    \"b\"\n  ]\n}\n"
+	if s := goldenDiff(want, got); s != "-   3:     \"a\"\n+   3:     \"b\"\n" {
+		t.Errorf("goldenDiff() = (%q)", s)
+	}
+}
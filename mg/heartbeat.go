@@ -0,0 +1,78 @@
+package mg
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// heartbeat starts monitoring client c for activity.
+//
+// When c has sent no requests for half of AgentConfig.HeartbeatTimeout, a Ping is dispatched.
+// When it has sent no requests for the whole timeout, it's declared dead.
+//
+// The returned function stops monitoring.
+func (ag *Agent) heartbeat(c *agentClient) (stop func()) {
+	timeout := ag.heartbeatTimeout
+	if timeout <= 0 {
+		return func() {}
+	}
+
+	c.seen()
+	stopC := make(chan struct{})
+	go func() {
+		tick := time.NewTicker(timeout / 2)
+		defer tick.Stop()
+
+		for {
+			select {
+			case <-stopC:
+				return
+			case <-tick.C:
+			}
+
+			switch idle := c.idle(); {
+			case idle >= timeout:
+				c.die(fmt.Errorf("ipc.heartbeat: client %d sent nothing for %s", c.id, idle.Round(time.Millisecond)))
+				return
+			case idle >= timeout/2:
+				ag.Store.DispatchPriority(Ping{}, PriorityHousekeeping)
+			}
+		}
+	}()
+	return func() { close(stopC) }
+}
+
+// seen records that the client was seen alive
+func (c *agentClient) seen() {
+	atomic.StoreInt64(&c.lastSeen, time.Now().UnixNano())
+}
+
+// idle returns the amount of time since the client was last seen alive
+func (c *agentClient) idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&c.lastSeen)))
+}
+
+// die declares the client dead.
+//
+// Its in-flight requests are cancelled and it stops receiving requests.
+func (c *agentClient) die(err error) {
+	c.deadOnce.Do(func() {
+		c.ag.Log.Println(err)
+		c.deadErr = err
+		close(c.deadC)
+		c.ag.reqs.cancelClient(c)
+		c.ag.subs.cancelClient(c)
+		c.stdin.Close()
+	})
+}
+
+// dead returns true if the client was declared dead
+func (c *agentClient) dead() bool {
+	select {
+	case <-c.deadC:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,63 @@
+package mg
+
+import (
+	"github.com/ugorji/go/codec"
+	"io"
+	"margo.sh/mgutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAgentHeartbeat(t *testing.T) {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	ag, err := NewAgent(AgentConfig{
+		Stdin:            inR,
+		Stdout:           outW,
+		Stderr:           &mgutil.IOWrapper{},
+		Codec:            "msgpack",
+		HeartbeatTimeout: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("agent creation failed: %s", err)
+	}
+	runErr := make(chan error, 1)
+	go func() { runErr <- ag.Run() }()
+	defer inW.Close()
+
+	pinged := make(chan struct{})
+	go func() {
+		dec := codec.NewDecoder(outR, ag.handle)
+		for done := false; ; {
+			var res struct {
+				State struct {
+					ClientActions []struct{ Name string }
+				}
+			}
+			if err := dec.Decode(&res); err != nil {
+				return
+			}
+			for _, ca := range res.State.ClientActions {
+				if ca.Name == "Ping" && !done {
+					close(pinged)
+					done = true
+				}
+			}
+		}
+	}()
+
+	select {
+	case <-pinged:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no Ping was sent to the idle client")
+	}
+	select {
+	case err := <-runErr:
+		if err == nil || !strings.Contains(err.Error(), "heartbeat") {
+			t.Errorf("ag.Run() = (%v); want heartbeat error", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ag.Run() didn't return after the heartbeat timeout")
+	}
+}
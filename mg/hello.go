@@ -0,0 +1,129 @@
+package mg
+
+import (
+	"fmt"
+)
+
+const (
+	// IPCVersion is the version of the IPC protocol implemented by the agent.
+	// It's incremented when a change is made that older clients can't handle.
+	IPCVersion = 1
+
+	// MinIPCVersion is the oldest version of the IPC protocol the agent can still talk to
+	MinIPCVersion = 1
+)
+
+// Hello describes the capabilities of one side of the IPC connection.
+//
+// A client may send a Hello in a request (usually its first),
+// and the agent replies with its own Hello in the final response to that request.
+// This allows clients to e.g. avoid sending actions that an older agent doesn't know about.
+//
+// If the client's IPC version range doesn't overlap the agent's,
+// the agent responds with an error and disconnects the client.
+// Clients that don't send a Hello are assumed to be compatible.
+type Hello struct {
+	// Name is the name of the agent or client
+	Name string
+
+	// IPCVersion is the newest IPC version supported
+	IPCVersion int
+
+	// MinIPCVersion is the oldest IPC version supported
+	MinIPCVersion int
+
+	// Codecs is the list of supported codecs
+	Codecs []string
+
+	// Actions is the list of supported actions
+	Actions []string
+
+	// Features is the list of optional features that are supported
+	// e.g `Cancel`, `Stream`, `Compression:gzip` or `ActionCodec` (see ActionData.Codec)
+	// A client that lists `StateDelta` is sent state deltas (see agentRes.Delta)
+	// and a client that lists `LogMessage` is sent log records (see LogMessage)
+	Features []string
+}
+
+// Compatible returns an error if the IPC version ranges of hi and h don't overlap
+func (hi *Hello) Compatible(h *Hello) error {
+	if h.MinIPCVersion > hi.IPCVersion {
+		return fmt.Errorf("%s requires IPC version %d or newer, but %s only supports up to version %d",
+			h.Name, h.MinIPCVersion, hi.Name, hi.IPCVersion)
+	}
+	if h.IPCVersion != 0 && h.IPCVersion < hi.MinIPCVersion {
+		return fmt.Errorf("%s only supports up to IPC version %d, but %s requires version %d or newer",
+			h.Name, h.IPCVersion, hi.Name, hi.MinIPCVersion)
+	}
+	return nil
+}
+
+// HasFeature returns true if name is in the list of features
+func (hi *Hello) HasFeature(name string) bool {
+	for _, s := range hi.Features {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// hello returns the agent's Hello
+func (ag *Agent) hello() *Hello {
+	hi := &Hello{
+		Name:          ag.Name,
+		IPCVersion:    IPCVersion,
+		MinIPCVersion: MinIPCVersion,
+		Codecs:        CodecNames,
+		Actions:       ActionCreators.Names(),
+		Features:      []string{"Cancel", "Stream", featureStateDelta, featureLogMessage, "ActionCodec", "Async"},
+	}
+	if ag.compression != "" {
+		hi.Features = append(hi.Features, "Compression:"+ag.compression)
+	}
+	if ag.heartbeatTimeout > 0 {
+		hi.Features = append(hi.Features, "Ping")
+	}
+	if ag.subs.setup != nil {
+		hi.Features = append(hi.Features, "SubAgents")
+	}
+	if ag.workers > 1 {
+		hi.Features = append(hi.Features, "Workers")
+	}
+	return hi
+}
+
+// greet handles the Hello sent in rq, if any.
+//
+// If the client is compatible, the agent's Hello is attached to the final response of rq.
+// Otherwise the error is sent to the client and returned.
+func (c *agentClient) greet(rq *agentReq) error {
+	if rq.Hello == nil {
+		return nil
+	}
+
+	hi := c.ag.hello()
+	rq.hello = hi
+	err := hi.Compatible(rq.Hello)
+	if err == nil {
+		if rq.Hello.HasFeature(featureStateDelta) {
+			c.mu.Lock()
+			if c.delta == nil {
+				c.delta = &stateDelta{}
+			}
+			c.mu.Unlock()
+		}
+		if rq.Hello.HasFeature(featureLogMessage) {
+			c.ag.logs.enable(c)
+		}
+		return nil
+	}
+
+	err = fmt.Errorf("ipc.hello: incompatible client: %s", err)
+	c.ag.sendTo(c, agentRes{
+		Cookie: rq.Cookie,
+		Error:  err.Error(),
+		req:    rq,
+	})
+	return err
+}
@@ -0,0 +1,68 @@
+package mg
+
+import (
+	"github.com/ugorji/go/codec"
+	"io"
+	"io/ioutil"
+	"margo.sh/mgutil"
+	"strings"
+	"testing"
+)
+
+func TestAgentHello(t *testing.T) {
+	hello := func(client Hello) (res agentRes, runErr error) {
+		inR, inW := io.Pipe()
+		outR, outW := io.Pipe()
+		ag, err := NewAgent(AgentConfig{
+			AgentName: "test-agent",
+			Stdin:     inR,
+			Stdout:    outW,
+			Stderr:    &mgutil.IOWrapper{},
+			Codec:     "msgpack",
+		})
+		if err != nil {
+			t.Fatalf("agent creation failed: %s", err)
+		}
+		errC := make(chan error, 1)
+		go func() { errC <- ag.Run() }()
+
+		err = codec.NewEncoder(inW, ag.handle).Encode(map[string]interface{}{
+			"Cookie": "hello",
+			"Hello":  client,
+		})
+		if err != nil {
+			t.Fatalf("enc.Encode(): %s", err)
+		}
+		dec := codec.NewDecoder(outR, ag.handle)
+		for res.Cookie != "hello" {
+			res = agentRes{}
+			if err := dec.Decode(&res); err != nil {
+				t.Fatalf("dec.Decode(): %s", err)
+			}
+		}
+		inW.Close()
+		go io.Copy(ioutil.Discard, outR)
+		return res, <-errC
+	}
+
+	res, err := hello(Hello{Name: "test-client", IPCVersion: IPCVersion})
+	if err != nil {
+		t.Errorf("compatible client: ag.Run() = (%v); want (nil)", err)
+	}
+	switch hi := res.Hello; {
+	case res.Error != "":
+		t.Errorf("compatible client: res.Error = (%s); want ()", res.Error)
+	case hi == nil:
+		t.Error("compatible client: res.Hello = (nil); want (*Hello)")
+	case hi.Name != "test-agent" || hi.IPCVersion != IPCVersion || !hi.HasFeature("Cancel"):
+		t.Errorf("compatible client: res.Hello = (%+v); want agent's Hello", hi)
+	}
+
+	res, err = hello(Hello{Name: "test-client", IPCVersion: IPCVersion + 1, MinIPCVersion: IPCVersion + 1})
+	if err == nil {
+		t.Error("incompatible client: ag.Run() = (nil); want (error)")
+	}
+	if !strings.Contains(res.Error, "incompatible client") || res.Hello == nil {
+		t.Errorf("incompatible client: res = (Error=%q, Hello=%v); want error and agent's Hello", res.Error, res.Hello)
+	}
+}
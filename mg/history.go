@@ -0,0 +1,178 @@
+package mg
+
+import (
+	"margo.sh/mg/actions"
+	"sync"
+	"time"
+)
+
+// QueryHistory is the action dispatched by the client to request the list of recently dispatched actions.
+//
+// The agent responds with a History client action. See Store.SetHistorySize
+type QueryHistory struct {
+	ActionType
+
+	// States, if true, requests that the state resulting from each action be included in the response
+	States bool
+}
+
+// RestoreSnapshot is the action dispatched to restore the state that resulted from an action in the history.
+//
+// The state is restored as the result of this action, and the following actions are reduced as usual.
+type RestoreSnapshot struct {
+	ActionType
+
+	// ID is the HistoryEntry.ID of the action
+	ID int64
+}
+
+// History is the client action dispatched in response to QueryHistory
+type History struct {
+	ActionType
+
+	// Entries is the list of recently dispatched actions, oldest first
+	Entries []HistoryEntry
+}
+
+func (h History) ClientAction() actions.ClientData {
+	return actions.ClientData{Name: "History", Data: h}
+}
+
+// HistoryEntry describes an action in the history
+type HistoryEntry struct {
+	// ID identifies the entry for use with RestoreSnapshot
+	ID int64
+
+	// Time is the time at which the action was reduced
+	Time time.Time
+
+	// Action is the name of the action, as returned by ActionLabel
+	Action string
+
+	// Cookie and TraceID identify the request that dispatched the action, if any
+	Cookie  string
+	TraceID string
+
+	// State is the state resulting from the action, if requested using QueryHistory.States
+	State *State
+}
+
+// historyTracker keeps a ring buffer of the most recently reduced actions and resulting states
+type historyTracker struct {
+	ReducerType
+
+	mu      sync.Mutex
+	size    int
+	lastID  int64
+	next    int
+	entries []HistoryEntry
+}
+
+// SetHistorySize sets the number of actions, and the states resulting from them, kept in the history.
+//
+// The history is returned in response to QueryHistory
+// and the states may be restored using RestoreSnapshot, to help debug reducers.
+// The states are shared with reducers, so keeping them costs little memory beyond what they hold.
+// If n <= 0, the history is disabled and cleared.
+// Default: 0 i.e. disabled
+func (sto *Store) SetHistorySize(n int) *Store {
+	sto.history.resize(n)
+	return sto
+}
+
+// resize changes the number of entries kept, keeping the most recent ones
+func (ht *historyTracker) resize(n int) {
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+
+	l := ht.list()
+	if n <= 0 {
+		l = nil
+	} else if len(l) > n {
+		l = l[len(l)-n:]
+	}
+	ht.size = n
+	ht.entries = l
+	ht.next = 0
+}
+
+// record adds the action reduced by mx, and the resulting state, to the history
+func (ht *historyTracker) record(mx *Ctx) {
+	if _, ok := mx.Action.(QueryHistory); ok {
+		return
+	}
+
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+
+	if ht.size <= 0 {
+		return
+	}
+	ht.lastID++
+	he := HistoryEntry{
+		ID:      ht.lastID,
+		Time:    time.Now(),
+		Action:  ActionLabel(mx.Action),
+		Cookie:  mx.Cookie,
+		TraceID: mx.TraceID,
+		State:   mx.State,
+	}
+	if len(ht.entries) < ht.size {
+		ht.entries = append(ht.entries, he)
+		return
+	}
+	ht.entries[ht.next] = he
+	ht.next = (ht.next + 1) % ht.size
+}
+
+// list returns a copy of the entries, oldest first. ht.mu must be held
+func (ht *historyTracker) list() []HistoryEntry {
+	l := make([]HistoryEntry, 0, len(ht.entries))
+	l = append(l, ht.entries[ht.next:]...)
+	return append(l, ht.entries[:ht.next]...)
+}
+
+// snapshot returns the History client action. The states are included if states is true
+func (ht *historyTracker) snapshot(states bool) History {
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+
+	h := History{Entries: ht.list()}
+	if !states {
+		for i := range h.Entries {
+			h.Entries[i].State = nil
+		}
+	}
+	return h
+}
+
+// lookup returns the state resulting from the action with the specified entry id
+func (ht *historyTracker) lookup(id int64) (*State, bool) {
+	ht.mu.Lock()
+	defer ht.mu.Unlock()
+
+	for _, he := range ht.entries {
+		if he.ID == id {
+			return he.State, true
+		}
+	}
+	return nil, false
+}
+
+func (ht *historyTracker) Reduce(mx *Ctx) *State {
+	switch act := mx.Action.(type) {
+	case QueryHistory:
+		return mx.addClientActions(ht.snapshot(act.States))
+	case RestoreSnapshot:
+		st, ok := ht.lookup(act.ID)
+		if !ok {
+			return mx.AddErrorf("RestoreSnapshot: snapshot %d is not in the history", act.ID)
+		}
+		mx.Log.Printf("RestoreSnapshot: restoring the state of snapshot %d\n", act.ID)
+		return st.Copy(func(st *State) {
+			st.Errors = mx.Errors
+			st.clientActions = mx.clientActions
+		})
+	}
+	return mx.State
+}
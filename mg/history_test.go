@@ -0,0 +1,59 @@
+package mg
+
+import (
+	"github.com/ugorji/go/codec"
+	"margo.sh/mg/actions"
+	"testing"
+)
+
+func TestStoreHistory(t *testing.T) {
+	ag := NewTestingAgent(nil, nil, nil)
+	ag.Store.SetHistorySize(2)
+	ag.Store.Use(NewReducer(func(mx *Ctx) *State {
+		if act, ok := mx.Action.(RestoreSnapshot); ok {
+			return mx.AddStatusf("restoring %d", act.ID)
+		}
+		return mx.State.Copy(func(st *State) {
+			st.Env = st.Env.Add("LAST", ActionLabel(mx.Action))
+		})
+	}))
+
+	var last *Ctx
+	ag.Store.Subscribe(func(mx *Ctx) { last = mx })
+	handle := func(l ...actions.ActionData) *Ctx {
+		rq := newAgentReq(ag.Store)
+		rq.Cookie = "history"
+		rq.Actions = l
+		rq.finalize(ag)
+		ag.Store.handleReq(rq)
+		return last
+	}
+
+	handle(actions.ActionData{Name: "QueryIssues"}, actions.ActionData{Name: "QueryTooltips"}, actions.ActionData{Name: "QueryUserCmds"})
+	mx := handle(actions.ActionData{Name: "QueryHistory"})
+	var h History
+	for _, ca := range mx.clientActions {
+		if ca.Name == "History" {
+			h = ca.Data.(History)
+		}
+	}
+	if len(h.Entries) != 2 {
+		t.Fatalf("len(History.Entries) = (%d); want (2)", len(h.Entries))
+	}
+	if a := h.Entries[0].Action; a != "mg.QueryTooltips" {
+		t.Errorf("History.Entries[0].Action = (%s); want the oldest entry to be mg.QueryTooltips", a)
+	}
+	if h.Entries[0].State != nil {
+		t.Errorf("History.Entries[0].State is set; want no states unless requested")
+	}
+
+	if _, ok := ag.Store.history.lookup(h.Entries[0].ID); !ok {
+		t.Fatalf("history.lookup(%d) failed", h.Entries[0].ID)
+	}
+	var data []byte
+	codec.NewEncoderBytes(&data, ag.handle).Encode(RestoreSnapshot{ID: h.Entries[0].ID})
+	mx = handle(actions.ActionData{Name: "RestoreSnapshot", Data: data})
+	if v := mx.Env.Get("LAST", ""); v != "mg.QueryTooltips" {
+		t.Errorf("Env[LAST] = (%s); want the restored state (mg.QueryTooltips)", v)
+	}
+}
@@ -0,0 +1,240 @@
+package mg
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"margo.sh/mg/actions"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PluginReloaded is the client action dispatched when the reducers of a plugin were swapped for those of a new build.
+// See Agent.HotReload
+type PluginReloaded struct {
+	ActionType
+
+	// Dir is the directory of the plugin's sources
+	Dir string
+}
+
+func (pr PluginReloaded) ClientAction() actions.ClientData {
+	return actions.ClientData{Name: "PluginReloaded", Data: pr}
+}
+
+// pluginReducers is the list of reducers added to the store by a plugin
+type pluginReducers struct {
+	before, use, after reducerList
+}
+
+// reducersAddedBy calls f and returns the reducers it added to the store
+func (sto *Store) reducersAddedBy(f func()) pluginReducers {
+	sto.reducers.Lock()
+	prev := sto.reducers.storeReducers
+	sto.reducers.Unlock()
+
+	f()
+
+	sto.reducers.Lock()
+	cur := sto.reducers.storeReducers
+	sto.reducers.Unlock()
+
+	added := func(prev, cur reducerList) reducerList {
+		if len(cur) <= len(prev) {
+			return nil
+		}
+		return append(reducerList(nil), cur[len(prev):]...)
+	}
+	return pluginReducers{
+		before: added(prev.before, cur.before),
+		use:    added(prev.use, cur.use),
+		after:  added(prev.after, cur.after),
+	}
+}
+
+// removeReducers removes the reducers in pr from the store
+func (sto *Store) removeReducers(pr pluginReducers) *Store {
+	del := map[*ReducerType]bool{}
+	for _, rl := range []reducerList{pr.before, pr.use, pr.after} {
+		for _, r := range rl {
+			del[r.reducerType()] = true
+		}
+	}
+	filter := func(rl reducerList) reducerList {
+		l := make(reducerList, 0, len(rl))
+		for _, r := range rl {
+			if !del[r.reducerType()] {
+				l = append(l, r)
+			}
+		}
+		return l
+	}
+	return sto.updateReducers(func(sr *storeReducers) {
+		sr.before = filter(sr.before)
+		sr.use = filter(sr.use)
+		sr.after = filter(sr.after)
+	})
+}
+
+// lifecycle calls the reducers in pr, outside of the normal reduction, to handle the internal action act
+// e.g. to unmount them. The resulting state is discarded.
+func (sto *Store) lifecycle(pr pluginReducers, act Action) {
+	rl := reducerList(nil).Add(pr.before...).Add(pr.use...).Add(pr.after...)
+	if len(rl) == 0 {
+		return
+	}
+	mx := sto.NewCtx(act)
+	defer mx.Cancel()
+	rl.reduction(mx)
+}
+
+// swapPlugin replaces the reducers old, added by a previous build of a plugin, with those added by mf.
+//
+// The old reducers are unmounted and the new ones are initialised,
+// as if the agent had restarted, before any other action is handled.
+func (ag *Agent) swapPlugin(old pluginReducers, mf MargoFunc) pluginReducers {
+	sto := ag.Store
+	sto.removeReducers(old)
+	sto.lifecycle(old, unmount{})
+	cur := sto.reducersAddedBy(func() { mf(ag.Args()) })
+	sto.lifecycle(cur, initAction{})
+	return cur
+}
+
+// HotReload builds the Go plugin whose main package is in dir, and loads it like LoadPlugin.
+//
+// If interval is greater than zero, the plugin's sources are checked for changes at that interval.
+// When they change, the plugin is rebuilt and the reducers it added to the store are swapped for those of the new build:
+// the old reducers are unmounted (see Reducer.RUnmount) and the new ones are initialised (see Reducer.RInit)
+// without restarting the agent, after which the PluginReloaded client action is dispatched.
+// Values in Store.KVMap are kept so reducers may use them to hand over their state.
+// If the new build fails, the error is logged and the old reducers are kept.
+//
+// Only the plugin's main package may change: the Go runtime refuses to load a plugin
+// that includes a different build of a package that's already loaded e.g. margo.sh/mg.
+// Go plugins can't be unloaded, so each build stays in memory until the agent exits.
+//
+// The returned function stops watching.
+func (ag *Agent) HotReload(dir string, interval time.Duration) (stop func(), err error) {
+	hr := &hotReloader{ag: ag, dir: dir}
+	stamp, _ := pluginSrcStamp(dir)
+	mf, err := hr.build()
+	if err != nil {
+		return func() {}, err
+	}
+	hr.reducers = ag.Store.reducersAddedBy(func() { mf(ag.Args()) })
+	if interval <= 0 {
+		return func() {}, nil
+	}
+	return hr.watch(stamp, interval), nil
+}
+
+// hotReloader rebuilds and reloads a plugin when its sources change
+type hotReloader struct {
+	ag  *Agent
+	dir string
+
+	mu       sync.Mutex
+	builds   int
+	reducers pluginReducers
+}
+
+// build builds the plugin and returns its Margo function
+func (hr *hotReloader) build() (MargoFunc, error) {
+	hr.mu.Lock()
+	hr.builds++
+	n := hr.builds
+	hr.mu.Unlock()
+
+	tmpDir, err := MkTempDir("hot-reload")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// each build must have a unique path, otherwise it's considered already loaded
+	pkgPath := fmt.Sprintf("margo-hot-reload/%d.%d", time.Now().UnixNano(), n)
+	fn := filepath.Join(tmpDir, "plugin.so")
+	buf := &bytes.Buffer{}
+	cmd := exec.Command("go", "build", "-buildmode=plugin", "-ldflags=-pluginpath="+pkgPath, "-o", fn)
+	cmd.Dir = hr.dir
+	cmd.Stdout = buf
+	cmd.Stderr = buf
+	hr.ag.Log.Println("hot-reload: building", hr.dir)
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("cannot build plugin %s: %s\n%s", hr.dir, err, strings.TrimSpace(buf.String()))
+	}
+	return openPlugin(fn)
+}
+
+// reload rebuilds the plugin and swaps its reducers
+func (hr *hotReloader) reload() {
+	mf, err := hr.build()
+	if err != nil {
+		hr.ag.Log.Println("hot-reload:", err)
+		return
+	}
+
+	sto := hr.ag.Store
+	sto.schedule(func() {
+		hr.mu.Lock()
+		hr.reducers = hr.ag.swapPlugin(hr.reducers, mf)
+		hr.mu.Unlock()
+
+		hr.ag.Log.Println("hot-reload: reloaded", hr.dir)
+		sto.Notify(PluginReloaded{Dir: hr.dir})
+		sto.handleAct(Render, nil)
+	})
+}
+
+// watch checks the plugin's sources every interval and reloads it when they change.
+//
+// Changes are only acted on once they've settled for an interval, so partially saved files aren't built.
+func (hr *hotReloader) watch(orig string, interval time.Duration) (stop func()) {
+	stopC := make(chan struct{})
+	go func() {
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+
+		prev := orig
+		for {
+			select {
+			case <-stopC:
+				return
+			case <-tick.C:
+			}
+
+			cur, err := pluginSrcStamp(hr.dir)
+			switch {
+			case err != nil || cur == orig:
+			case cur != prev:
+				prev = cur
+			default:
+				orig = cur
+				hr.reload()
+			}
+		}
+	}()
+	return func() { close(stopC) }
+}
+
+// pluginSrcStamp returns a string that changes when any of the non-test .go files in dir changes
+func pluginSrcStamp(dir string) (string, error) {
+	l, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	buf := &bytes.Buffer{}
+	for _, fi := range l {
+		nm := fi.Name()
+		if fi.IsDir() || !strings.HasSuffix(nm, ".go") || strings.HasSuffix(nm, "_test.go") {
+			continue
+		}
+		fmt.Fprintf(buf, "%s:%d:%d\n", nm, fi.Size(), fi.ModTime().UnixNano())
+	}
+	return buf.String(), nil
+}
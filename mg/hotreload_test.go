@@ -0,0 +1,67 @@
+package mg
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAgentSwapPlugin(t *testing.T) {
+	ag := NewTestingAgent(nil, nil, nil)
+	var events []string
+	margo := func(name string) MargoFunc {
+		return func(ma Args) {
+			ma.Store.Use(NewReducer(func(mx *Ctx) *State {
+				if mx.ActionIs(QueryIssues{}) {
+					events = append(events, "reduce:"+name)
+				}
+				return mx.State
+			}, func(rf *RFunc) {
+				rf.Label = name
+				rf.Init = func(*Ctx) { events = append(events, "init:"+name) }
+				rf.Unmount = func(*Ctx) { events = append(events, "unmount:"+name) }
+			}))
+		}
+	}
+
+	v1 := ag.Store.reducersAddedBy(func() { margo("v1")(ag.Args()) })
+	if len(v1.use) != 1 || len(v1.before) != 0 || len(v1.after) != 0 {
+		t.Fatalf("reducersAddedBy() = (%+v); want the reducer added by the plugin", v1)
+	}
+	ag.Store.handleAct(initAction{}, nil)
+	ag.Store.handleAct(QueryIssues{}, nil)
+
+	v2 := ag.swapPlugin(v1, margo("v2"))
+	ag.Store.handleAct(QueryIssues{}, nil)
+	want := "init:v1 reduce:v1 unmount:v1 init:v2 reduce:v2"
+	if s := strings.Join(events, " "); s != want {
+		t.Errorf("events = (%s); want (%s)", s, want)
+	}
+	for _, s := range ag.Store.reducerLabels() {
+		if s == "v1" {
+			t.Error("the old reducer is still registered")
+		}
+	}
+	if len(v2.use) != 1 || ReducerLabel(v2.use[0]) != "v2" {
+		t.Errorf("swapPlugin() = (%+v); want the new reducer", v2)
+	}
+}
+
+func TestPluginSrcStamp(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "margo.go")
+	ioutil.WriteFile(fn, []byte("package main"), 0644)
+	a, err := pluginSrcStamp(dir)
+	if err != nil {
+		t.Fatalf("pluginSrcStamp(): %s", err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "margo_test.go"), []byte("package main"), 0644)
+	if b, _ := pluginSrcStamp(dir); b != a {
+		t.Error("the stamp changed when a test file was added")
+	}
+	ioutil.WriteFile(fn, []byte("package main // changed"), 0644)
+	if b, _ := pluginSrcStamp(dir); b == a {
+		t.Error("the stamp didn't change when a source file changed")
+	}
+}
@@ -0,0 +1,93 @@
+package mg
+
+import (
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+// Idle is the action dispatched when the agent has received no requests for AgentConfig.IdleTimeout.
+//
+// Reducers should use it to release resources that can be recreated later
+// e.g. clear caches and stop watch processes.
+// Once it's been handled, the agent returns as much memory as possible to the OS.
+//
+// It's dispatched once for each idle period.
+type Idle struct {
+	ActionType
+
+	// Duration is the amount of time since the last request
+	Duration time.Duration
+}
+
+// active records that the agent received a request
+func (ag *Agent) active() {
+	atomic.StoreInt64(&ag.lastActive, time.Now().UnixNano())
+}
+
+// idle returns the amount of time since the agent last received a request
+func (ag *Agent) idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&ag.lastActive)))
+}
+
+// monitorIdle starts dispatching Idle when the agent receives no requests for AgentConfig.IdleTimeout.
+//
+// The returned function stops monitoring.
+func (ag *Agent) monitorIdle() (stop func()) {
+	timeout := ag.idleTimeout
+	if timeout <= 0 {
+		return func() {}
+	}
+
+	ag.active()
+	stopC := make(chan struct{})
+	go func() {
+		tick := time.NewTicker(timeout / 4)
+		defer tick.Stop()
+
+		var dispatched int64
+		for {
+			select {
+			case <-stopC:
+				return
+			case <-tick.C:
+			}
+
+			last := atomic.LoadInt64(&ag.lastActive)
+			if last == dispatched {
+				continue
+			}
+			if idle := ag.idle(); idle >= timeout {
+				dispatched = last
+				ag.dispatchIdle(idle)
+			}
+		}
+	}()
+	return func() { close(stopC) }
+}
+
+// dispatchIdle dispatches Idle then frees memory once it's been handled
+func (ag *Agent) dispatchIdle(idle time.Duration) {
+	sto := ag.Store
+	c := sto.dsp.lo
+	f := func() {
+		sto.handleAct(Idle{Duration: idle}, nil)
+		debug.FreeOSMemory()
+	}
+	select {
+	case c <- f:
+	default:
+		go func() { c <- f }()
+	}
+}
+
+// keepsAlive returns true if the request only contains actions sent to keep the connection alive
+// i.e. Pong, so it doesn't count as activity
+func (rq *agentReq) keepsAlive() bool {
+	for _, ra := range rq.Actions {
+		if ra.Name != "Pong" {
+			return false
+		}
+	}
+	return len(rq.Actions) != 0
+}
@@ -0,0 +1,50 @@
+package mg
+
+import (
+	"margo.sh/mgutil"
+	"testing"
+	"time"
+)
+
+func TestAgentIdle(t *testing.T) {
+	ag, err := NewAgent(AgentConfig{
+		Stdin:       &mgutil.IOWrapper{},
+		Stdout:      &mgutil.IOWrapper{},
+		Stderr:      &mgutil.IOWrapper{},
+		IdleTimeout: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("agent creation failed: %s", err)
+	}
+	idleC := make(chan Idle, 1)
+	ag.Store.Use(NewReducer(func(mx *Ctx) *State {
+		if act, ok := mx.Action.(Idle); ok {
+			idleC <- act
+		}
+		return mx.State
+	}))
+	stop := ag.monitorIdle()
+	defer stop()
+
+	waitIdle := func() {
+		select {
+		case f := <-ag.Store.dsp.lo:
+			f()
+		case <-time.After(time.Second):
+			t.Fatal("Idle wasn't dispatched")
+		}
+		if act := <-idleC; act.Duration < ag.idleTimeout {
+			t.Errorf("Idle.Duration = (%s); want at least %s", act.Duration, ag.idleTimeout)
+		}
+	}
+	waitIdle()
+
+	select {
+	case <-ag.Store.dsp.lo:
+		t.Fatal("Idle was dispatched again without any activity")
+	case <-time.After(4 * ag.idleTimeout):
+	}
+
+	ag.active()
+	waitIdle()
+}
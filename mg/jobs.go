@@ -0,0 +1,180 @@
+package mg
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+var (
+	// DefaultJobLimit is the default number of jobs that may run concurrently. See Jobs.SetLimit
+	DefaultJobLimit = runtime.NumCPU()
+)
+
+// JobFunc is the body of a job submitted using Jobs.Submit.
+// It returns the actions to dispatch once it's done.
+type JobFunc func(jx *JobCtx) []Action
+
+// JobCtx is the Ctx passed to a JobFunc
+//
+// Its Ctx is based on the state of the Store when the job is submitted, and is cancelled when the job is.
+// Other data from the Ctx that submitted the job e.g. its Action, should be captured by the JobFunc.
+type JobCtx struct {
+	*Ctx
+
+	// Job is the job being run
+	Job *Job
+}
+
+// Progress reports the progress of the job, by setting its title in the task list to its name followed by the message.
+func (jx *JobCtx) Progress(format string, a ...interface{}) {
+	jx.Job.ticket.SetTitle(jx.Job.Name + ": " + fmt.Sprintf(format, a...))
+}
+
+// Job is a job submitted using Jobs.Submit
+type Job struct {
+	// Name identifies the job in the task list and in logs
+	Name string
+
+	fn     JobFunc
+	mx     *Ctx
+	ticket *TaskTicket
+	doneC  chan struct{}
+}
+
+// Cancel cancels the job's Ctx.
+//
+// If the job is queued, its function is not called.
+// Otherwise the actions it returns are not dispatched.
+func (jb *Job) Cancel() {
+	jb.mx.Cancel()
+}
+
+// Done returns a channel that's closed when the job is done, or was cancelled before it started
+func (jb *Job) Done() <-chan struct{} {
+	return jb.doneC
+}
+
+// Jobs runs background work for reducers, instead of each of them starting its own goroutines.
+//
+// Jobs are listed in the task list while they're queued or running (see Ctx.Begin), so they can be cancelled by the user.
+// When the agent shuts down, all jobs are cancelled, and the agent waits for the running jobs to return
+// before reducers are unmounted.
+type Jobs struct {
+	sto *Store
+
+	mu      sync.Mutex
+	limit   int
+	running int
+	queue   []*Job
+	active  map[*Job]bool
+	closed  bool
+}
+
+func newJobs(sto *Store) *Jobs {
+	return &Jobs{sto: sto, limit: DefaultJobLimit, active: map[*Job]bool{}}
+}
+
+// SetLimit sets the number of jobs that may run concurrently. If n is less than 1, it's set to 1.
+//
+// Jobs submitted while the limit is reached are queued,
+// and started in the order they were submitted.
+func (js *Jobs) SetLimit(n int) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	if n < 1 {
+		n = 1
+	}
+	js.limit = n
+	js.startQueued()
+}
+
+// Submit schedules fn to be called on its own goroutine as the job name,
+// then dispatches the actions it returns.
+//
+// If fn panics, the panic is logged and no actions are dispatched.
+// If the agent is shutting down, the job is cancelled and fn is not called.
+func (js *Jobs) Submit(name string, fn JobFunc) *Job {
+	jb := &Job{
+		Name:  name,
+		fn:    fn,
+		mx:    js.sto.NewCtx(nil),
+		doneC: make(chan struct{}),
+	}
+	jb.ticket = js.sto.Begin(Task{Title: name, Cancel: jb.Cancel})
+
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	if js.closed {
+		jb.Cancel()
+		jb.ticket.Done()
+		close(jb.doneC)
+		return jb
+	}
+	js.sto.ag.wg.Add(1)
+	js.queue = append(js.queue, jb)
+	js.startQueued()
+	return jb
+}
+
+// startQueued starts queued jobs until the limit is reached. js.mu must be held
+func (js *Jobs) startQueued() {
+	for len(js.queue) != 0 && js.running < js.limit {
+		jb := js.queue[0]
+		js.queue = js.queue[1:]
+		js.running++
+		js.active[jb] = true
+		go js.run(jb)
+	}
+}
+
+// run calls the job's function, unless it was cancelled, and dispatches the actions it returns
+func (js *Jobs) run(jb *Job) {
+	mx := jb.mx
+	defer js.sto.ag.wg.Done()
+	defer close(jb.doneC)
+	defer jb.ticket.Done()
+	defer func() {
+		js.mu.Lock()
+		defer js.mu.Unlock()
+
+		js.running--
+		delete(js.active, jb)
+		js.startQueued()
+	}()
+	defer func() {
+		if v := recover(); v != nil {
+			pe := newPanicError(v)
+			mx.Log.Printf("Job(%s): %s\n%s", jb.Name, pe, pe.stack)
+		}
+	}()
+
+	if mx.Err() != nil {
+		return
+	}
+	acts := jb.fn(&JobCtx{Ctx: mx, Job: jb})
+	if mx.Err() != nil {
+		return
+	}
+	for _, act := range acts {
+		js.sto.Dispatch(act)
+	}
+}
+
+// shutdown cancels all jobs and prevents new ones from being started.
+//
+// Queued jobs are started as running jobs return, so they're done once the agent's WaitGroup is.
+func (js *Jobs) shutdown() {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	js.closed = true
+	for _, jb := range js.queue {
+		jb.Cancel()
+	}
+	for jb := range js.active {
+		jb.Cancel()
+	}
+}
@@ -0,0 +1,91 @@
+package mg
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJobs(t *testing.T) {
+	type jobDone struct {
+		ActionType
+		Name string
+	}
+	ag := NewTestingAgent(nil, nil, nil)
+	js := ag.Store.jobs
+	js.SetLimit(1)
+	var done []string
+	ag.Store.Use(NewReducer(func(mx *Ctx) *State {
+		if act, ok := mx.Action.(jobDone); ok {
+			done = append(done, act.Name)
+		}
+		return mx.State
+	}))
+
+	release := make(chan struct{})
+	started := make(chan string, 3)
+	fn := func(jx *JobCtx) []Action {
+		started <- jx.Job.Name
+		jx.Progress("%d%%", 50)
+		select {
+		case <-release:
+		case <-jx.Done():
+		}
+		return []Action{jobDone{Name: jx.Job.Name}}
+	}
+	a := js.Submit("a", fn)
+	b := js.Submit("b", fn)
+	c := js.Submit("c", fn)
+	if nm := <-started; nm != "a" {
+		t.Fatalf("job %s started first; want a", nm)
+	}
+	for i := 0; i < 100; i++ {
+		if l := ag.Store.tasks.list(); len(l) == 3 && l[0].Title == "a: 50%" {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if l := ag.Store.tasks.list(); len(l) != 3 || l[0].Title != "a: 50%" {
+		t.Errorf("tasks = %+v; want 3 tasks, the first with the title `a: 50%%`", l)
+	}
+	select {
+	case nm := <-started:
+		t.Fatalf("job %s started while job a was running, with a limit of 1", nm)
+	default:
+	}
+
+	b.Cancel()
+	close(release)
+	<-a.Done()
+	<-b.Done()
+	if nm := <-started; nm != "c" {
+		t.Errorf("job %s started after job a; want c, since b was cancelled", nm)
+	}
+	<-c.Done()
+	for i := 0; i < 2; i++ {
+		(<-ag.Store.dsp.lo)()
+	}
+	if s, want := strings.Join(done, " "), "a c"; s != want {
+		t.Errorf("the jobs dispatched (%s); want (%s)", s, want)
+	}
+
+	block := js.Submit("d", func(jx *JobCtx) []Action {
+		<-jx.Done()
+		return []Action{jobDone{Name: "d"}}
+	})
+	js.shutdown()
+	<-block.Done()
+	late := js.Submit("e", fn)
+	<-late.Done()
+	ag.wg.Wait()
+	select {
+	case <-ag.Store.dsp.lo:
+		t.Error("an action was dispatched by a job cancelled at shutdown")
+	case nm := <-started:
+		t.Errorf("job %s started after shutdown", nm)
+	default:
+	}
+	if l := ag.Store.tasks.list(); len(l) != 0 {
+		t.Errorf("tasks = %+v after all jobs are done; want none", l)
+	}
+}
@@ -0,0 +1,157 @@
+package mg
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ugorji/go/codec"
+	"margo.sh/mg/lsp/jsonrpc"
+	"strings"
+)
+
+const (
+	// ProtocolMargo is the default protocol: a raw stream of encoded requests and responses
+	ProtocolMargo = "margo"
+
+	// ProtocolJSONRPC frames requests and responses as JSON-RPC 2.0 messages
+	// with a Content-Length header, as used by the Language Server Protocol.
+	//
+	// * requests are sent using the method `margo/request` with the request as params
+	// * the final response to a request with an id is sent as its result
+	// * all other responses e.g. partial responses and responses to dispatched actions
+	//   are sent as `margo/response` notifications
+	//
+	// If a request has no Cookie, its id is used as its Cookie.
+	ProtocolJSONRPC = "jsonrpc"
+
+	jsonrpcRequestMethod  = "margo/request"
+	jsonrpcResponseMethod = "margo/response"
+)
+
+// jsonrpcReq is the envelope of a JSON-RPC request
+type jsonrpcReq struct {
+	ID     codec.Raw `codec:"id"`
+	Method string    `codec:"method"`
+	Params *agentReq `codec:"params"`
+}
+
+func parseProtocol(s, codecName string) (string, error) {
+	switch s {
+	case "", ProtocolMargo:
+		return ProtocolMargo, nil
+	case ProtocolJSONRPC:
+		if codecName != "" && codecName != "json" {
+			return ProtocolMargo, fmt.Errorf("Protocol %s requires the json codec, not %s", s, codecName)
+		}
+		return s, nil
+	}
+	return ProtocolMargo, fmt.Errorf("Invalid protocol '%s'. Expected %s or %s", s, ProtocolMargo, ProtocolJSONRPC)
+}
+
+// decodeReq reads the next request from the client into rq
+func (c *agentClient) decodeReq(rq *agentReq) error {
+	if c.ag.protocol != ProtocolJSONRPC {
+		c.limRd.reset()
+		err := c.dec.Decode(rq)
+		if c.recRd != nil {
+			p := c.recRd.take()
+			if err == nil {
+				c.lastReq = p
+				c.record(recordRequest, c.lastReq)
+			}
+		}
+		return err
+	}
+
+	for {
+		body, err := c.readFrame()
+		if e, ok := err.(reqSizeError); ok {
+			c.ag.Log.Printf("jsonrpc: client %d: rejecting request: %s\n", c.id, e)
+			c.sendRPCError(nil, jsonrpc.CodeInvalidRequest, e.Error())
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		c.record(recordRequest, body)
+
+		env := jsonrpcReq{Params: rq}
+		if err := codec.NewDecoderBytes(body, c.ag.handle).Decode(&env); err != nil {
+			c.sendRPCError(nil, jsonrpc.CodeParseError, err.Error())
+			continue
+		}
+		if env.Method != jsonrpcRequestMethod {
+			c.sendRPCError(env.ID, jsonrpc.CodeMethodNotFound, "method not found: "+env.Method)
+			continue
+		}
+		rq.rpcID = env.ID
+		if rq.Cookie == "" {
+			rq.Cookie = strings.Trim(string(env.ID), `"`)
+		}
+		return nil
+	}
+}
+
+// readFrame reads the body of the next Content-Length framed message
+func (c *agentClient) readFrame() ([]byte, error) {
+	body, err := jsonrpc.ReadFrame(c.decRd, c.ag.maxRequestSize)
+	if e, ok := err.(*jsonrpc.FrameSizeError); ok {
+		return nil, reqSizeError{max: e.Max}
+	}
+	return body, err
+}
+
+// encodeRes writes res to the client. It's called with agentClient.mu held.
+func (c *agentClient) encodeRes(res agentRes) error {
+	if c.ag.protocol != ProtocolJSONRPC {
+		if c.ag.recorder == nil {
+			return c.enc.Encode(c.finalizeRes(res))
+		}
+		var p []byte
+		if err := codec.NewEncoderBytes(&p, c.ag.handle).Encode(c.finalizeRes(res)); err != nil {
+			return err
+		}
+		c.record(recordResponse, p)
+		_, err := c.encWr.Write(p)
+		return err
+	}
+
+	var body []byte
+	if err := codec.NewEncoderBytes(&body, c.ag.handle).Encode(c.finalizeRes(res)); err != nil {
+		return err
+	}
+	env := &bytes.Buffer{}
+	env.WriteString(`{"jsonrpc":"2.0",`)
+	if rq := res.req; rq != nil && !res.Partial && len(rq.rpcID) != 0 {
+		env.WriteString(`"id":`)
+		env.Write(rq.rpcID)
+		env.WriteString(`,"result":`)
+	} else {
+		env.WriteString(`"method":"` + jsonrpcResponseMethod + `","params":`)
+	}
+	env.Write(bytes.TrimSpace(body))
+	env.WriteString(`}`)
+	return c.writeFrame(env.Bytes())
+}
+
+// sendRPCError sends a JSON-RPC error response to the request id
+func (c *agentClient) sendRPCError(id codec.Raw, code int, msg string) {
+	if len(id) == 0 {
+		id = codec.Raw("null")
+	}
+	var m []byte
+	codec.NewEncoderBytes(&m, c.ag.handle).Encode(msg)
+	body := fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"error":{"code":%d,"message":%s}}`, id, code, bytes.TrimSpace(m))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	defer c.flush()
+	if err := c.writeFrame([]byte(body)); err != nil {
+		c.ag.Log.Println("jsonrpc: cannot send error response:", err)
+	}
+}
+
+func (c *agentClient) writeFrame(body []byte) error {
+	c.record(recordResponse, body)
+	return jsonrpc.WriteFrame(c.encWr, body)
+}
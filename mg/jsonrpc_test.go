@@ -0,0 +1,100 @@
+package mg
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"margo.sh/mgutil"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAgentJSONRPC(t *testing.T) {
+	type message struct {
+		ID     *int
+		Method string
+		Result *struct{ Cookie string }
+		Error  *struct{ Code int }
+	}
+	type rpcAgent struct {
+		send func(body string)
+		next func() message
+		stop func()
+	}
+	start := func(maxRequestSize int) rpcAgent {
+		inR, inW := io.Pipe()
+		outR, outW := io.Pipe()
+		ag, err := NewAgent(AgentConfig{
+			Stdin:          inR,
+			Stdout:         outW,
+			Stderr:         &mgutil.IOWrapper{},
+			Protocol:       ProtocolJSONRPC,
+			MaxRequestSize: maxRequestSize,
+		})
+		if err != nil {
+			t.Fatalf("agent creation failed: %s", err)
+		}
+		runErr := make(chan error, 1)
+		go func() { runErr <- ag.Run() }()
+
+		rd := textproto.NewReader(bufio.NewReader(outR))
+		return rpcAgent{
+			send: func(body string) {
+				if _, err := fmt.Fprintf(inW, "Content-Length: %d\r\n\r\n%s", len(body), body); err != nil {
+					t.Fatalf("send: %s", err)
+				}
+			},
+			next: func() message {
+				for {
+					hdr, err := rd.ReadMIMEHeader()
+					if err != nil {
+						t.Fatalf("ReadMIMEHeader(): %s", err)
+					}
+					n, _ := strconv.Atoi(hdr.Get("Content-Length"))
+					body := make([]byte, n)
+					if _, err := io.ReadFull(rd.R, body); err != nil {
+						t.Fatalf("io.ReadFull(): %s", err)
+					}
+					m := message{}
+					if err := json.Unmarshal(body, &m); err != nil {
+						t.Fatalf("json.Unmarshal(%s): %s", body, err)
+					}
+					if m.Method != jsonrpcResponseMethod {
+						return m
+					}
+				}
+			},
+			stop: func() {
+				inW.Close()
+				go io.Copy(ioutil.Discard, outR)
+				<-runErr
+			},
+		}
+	}
+
+	ra := start(0)
+	ra.send(`{"jsonrpc":"2.0","id":1,"method":"margo/unknown"}`)
+	if m := ra.next(); m.Error == nil || m.Error.Code != -32601 || m.ID == nil || *m.ID != 1 {
+		t.Errorf("unknown method response = %+v; want error -32601 for id 1", m)
+	}
+	ra.send(`{"jsonrpc":"2.0","id":7,"method":"margo/request","params":{}}`)
+	if m := ra.next(); m.Result == nil || m.Result.Cookie != "7" || m.ID == nil || *m.ID != 7 {
+		t.Errorf("request response = %+v; want result with Cookie 7 for id 7", m)
+	}
+	ra.stop()
+
+	ra = start(64)
+	ra.send(`{"jsonrpc":"2.0","id":2,"method":"margo/request","params":{"Cookie":"` + strings.Repeat("x", 64) + `"}}`)
+	if m := ra.next(); m.Error == nil || m.Error.Code != -32600 {
+		t.Errorf("large request response = %+v; want error -32600", m)
+	}
+	ra.send(`{"jsonrpc":"2.0","id":8,"method":"margo/request","params":{}}`)
+	if m := ra.next(); m.Result == nil || m.Result.Cookie != "8" || m.ID == nil || *m.ID != 8 {
+		t.Errorf("request response after a large request = %+v; want result with Cookie 8 for id 8", m)
+	}
+	ra.stop()
+}
@@ -0,0 +1,206 @@
+package mg
+
+import (
+	"fmt"
+	"github.com/ugorji/go/codec"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+)
+
+var (
+	// kvCodecTypes holds the types registered using RegisterKVCodecType, by name
+	kvCodecTypes = struct {
+		sync.RWMutex
+		byName map[string]reflect.Type
+		names  map[reflect.Type]string
+	}{
+		byName: map[string]reflect.Type{},
+		names:  map[reflect.Type]string{},
+	}
+)
+
+func init() {
+	for _, v := range []interface{}{
+		"", false,
+		int(0), int8(0), int16(0), int32(0), int64(0),
+		uint(0), uint8(0), uint16(0), uint32(0), uint64(0),
+		float32(0), float64(0),
+		[]byte{}, []string{}, map[string]string{},
+		time.Time{}, time.Duration(0),
+	} {
+		RegisterKVCodecType(v)
+	}
+}
+
+// RegisterKVCodecType registers the type of v, so keys and values of that type can be encoded by KVMap.EncodeTo
+// and decoded by KVMap.DecodeFrom. It should be called from an init function, like gob.Register.
+//
+// Strings, bools, numbers, []byte, []string, map[string]string, time.Time and time.Duration are registered by default.
+// The type must be encodable by the codecs e.g. a struct whose exported fields have registered types.
+func RegisterKVCodecType(v interface{}) {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		panic("mg.RegisterKVCodecType: cannot register nil")
+	}
+	name := t.String()
+	if t.Name() != "" && t.PkgPath() != "" {
+		name = t.PkgPath() + "." + t.Name()
+	}
+
+	kt := &kvCodecTypes
+	kt.Lock()
+	defer kt.Unlock()
+
+	if t2, ok := kt.byName[name]; ok && t2 != t {
+		panic(fmt.Sprintf("mg.RegisterKVCodecType: registering duplicate types for %s: %s != %s", name, t2, t))
+	}
+	kt.byName[name] = t
+	kt.names[t] = name
+}
+
+// kvCodecTypeName returns the name of the registered type of v, or "" if it's not registered
+func kvCodecTypeName(v interface{}) string {
+	kt := &kvCodecTypes
+	kt.RLock()
+	defer kt.RUnlock()
+
+	return kt.names[reflect.TypeOf(v)]
+}
+
+// kvCodecType returns the registered type named name, or nil if there's none
+func kvCodecType(name string) reflect.Type {
+	kt := &kvCodecTypes
+	kt.RLock()
+	defer kt.RUnlock()
+
+	return kt.byName[name]
+}
+
+// kvCodecDump is the form in which KVMap.EncodeTo encodes a map
+type kvCodecDump struct {
+	Entries []kvCodecEntry
+
+	// Skipped is the number of keys and values that weren't encoded, by the name of their unregistered type
+	Skipped map[string]int `codec:",omitempty"`
+}
+
+// kvCodecEntry is the form in which KVMap.EncodeTo encodes a key and its value
+type kvCodecEntry struct {
+	// Namespace is the namespace of keys stored through a namespace. See KVStore.Namespace
+	Namespace string `codec:",omitempty"`
+	KeyType   string
+	Key       interface{}
+	ValueType string
+	Value     interface{}
+	Expiry    *time.Time `codec:",omitempty"`
+}
+
+// kvCodecRawDump is the form in which KVMap.DecodeFrom decodes the map encoded as a kvCodecDump
+type kvCodecRawDump struct {
+	Entries []kvCodecRawEntry
+}
+
+// kvCodecRawEntry is a kvCodecEntry whose key and value are decoded after their types are known
+type kvCodecRawEntry struct {
+	Namespace string
+	KeyType   string
+	Key       codec.Raw
+	ValueType string
+	Value     codec.Raw
+	Expiry    *time.Time
+}
+
+// EncodeTo encodes the contents of the map to w, using the codec h e.g. to save the map, or inspect its values.
+//
+// Only the keys and values whose types are registered using RegisterKVCodecType are encoded,
+// the number of those skipped is recorded, by type, in the encoded form.
+// Keys stored through a namespace (see Namespace) are encoded if the type of their key is registered.
+// Expiry times (see PutTTL) are preserved.
+func (m *KVMap) EncodeTo(h codec.Handle, w io.Writer) error {
+	ks := m.Snapshot()
+	dump := kvCodecDump{Entries: make([]kvCodecEntry, 0, len(ks.Values))}
+	skip := func(v interface{}) {
+		if dump.Skipped == nil {
+			dump.Skipped = map[string]int{}
+		}
+		dump.Skipped[fmt.Sprintf("%T", v)]++
+	}
+	for k, v := range ks.Values {
+		e := kvCodecEntry{Key: k, Value: v}
+		if nk, ok := k.(kvNamespaceKey); ok {
+			e.Namespace, e.Key = nk.Namespace, nk.Key
+		}
+		if e.KeyType = kvCodecTypeName(e.Key); e.KeyType == "" {
+			skip(e.Key)
+			continue
+		}
+		if e.ValueType = kvCodecTypeName(v); e.ValueType == "" {
+			skip(v)
+			continue
+		}
+		if t, ok := ks.Expiry[k]; ok {
+			e.Expiry = &t
+		}
+		dump.Entries = append(dump.Entries, e)
+	}
+	return codec.NewEncoder(w, h).Encode(dump)
+}
+
+// DecodeFrom replaces the contents of the map with those encoded by EncodeTo, read from r using the codec h.
+//
+// Keys and values whose types aren't registered in this process are skipped, as are the values that already expired.
+// If the contents can't be decoded, the map is left unchanged and an error is returned.
+// Like Restore, the watchers of the keys whose values changed are notified (see Watch).
+func (m *KVMap) DecodeFrom(h codec.Handle, r io.Reader) error {
+	dump := kvCodecRawDump{}
+	if err := codec.NewDecoder(r, h).Decode(&dump); err != nil {
+		return err
+	}
+
+	ks := KVSnapshot{
+		Values: make(map[interface{}]interface{}, len(dump.Entries)),
+		Expiry: map[interface{}]time.Time{},
+	}
+	now := time.Now()
+	for _, e := range dump.Entries {
+		if e.Expiry != nil && !now.Before(*e.Expiry) {
+			continue
+		}
+		k, err := kvCodecDecode(h, e.KeyType, e.Key)
+		if err != nil {
+			return err
+		}
+		v, err := kvCodecDecode(h, e.ValueType, e.Value)
+		if err != nil {
+			return err
+		}
+		if k == nil || v == nil || !reflect.TypeOf(k).Comparable() {
+			continue
+		}
+		if e.Namespace != "" {
+			k = kvNamespaceKey{Namespace: e.Namespace, Key: k}
+		}
+		ks.Values[k] = v
+		if e.Expiry != nil {
+			ks.Expiry[k] = *e.Expiry
+		}
+	}
+	m.Restore(ks)
+	return nil
+}
+
+// kvCodecDecode decodes the value of the registered type named typ, encoded as p using h.
+// If the type isn't registered, nil is returned.
+func kvCodecDecode(h codec.Handle, typ string, p codec.Raw) (interface{}, error) {
+	t := kvCodecType(typ)
+	if t == nil {
+		return nil, nil
+	}
+	rv := reflect.New(t)
+	if err := codec.NewDecoderBytes(p, h).Decode(rv.Interface()); err != nil {
+		return nil, fmt.Errorf("cannot decode %s: %s", typ, err)
+	}
+	return rv.Elem().Interface(), nil
+}
@@ -0,0 +1,63 @@
+package mg
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testKVCodecValue struct {
+	Name  string
+	Names []string
+}
+
+func init() {
+	RegisterKVCodecType(testKVCodecValue{})
+}
+
+func TestKVMapCodec(t *testing.T) {
+	for _, name := range []string{"json", "msgpack", "cbor"} {
+		h := codecHandles[name]
+		m := &KVMap{}
+		m.Put("count", 3)
+		m.Put(int64(7), "seven")
+		m.Namespace("pkgs").Put("fmt", testKVCodecValue{Name: "fmt", Names: []string{"Println"}})
+		m.PutTTL("ttl", 1.5, time.Hour)
+		m.Put("unregistered", &KVMap{})
+
+		buf := &bytes.Buffer{}
+		if err := m.EncodeTo(h, buf); err != nil {
+			t.Fatalf("%s: EncodeTo: %s", name, err)
+		}
+		m2 := &KVMap{}
+		m2.Put("stale", 1)
+		if err := m2.DecodeFrom(h, buf); err != nil {
+			t.Fatalf("%s: DecodeFrom: %s", name, err)
+		}
+
+		if n := m2.Len(); n != 4 {
+			t.Errorf("%s: Len() = %d after DecodeFrom; want 4", name, n)
+		}
+		if v := m2.Get("count"); v != 3 {
+			t.Errorf("%s: Get(count) = %#v; want 3", name, v)
+		}
+		if v := m2.Get(int64(7)); v != "seven" {
+			t.Errorf("%s: Get(int64(7)) = %#v; want seven", name, v)
+		}
+		if v, _ := m2.Namespace("pkgs").Get("fmt").(testKVCodecValue); v.Name != "fmt" || len(v.Names) != 1 {
+			t.Errorf("%s: Namespace(pkgs).Get(fmt) = %#v; want the registered struct", name, m2.Namespace("pkgs").Get("fmt"))
+		}
+		if v, exp := m2.Get("ttl"), m2.Snapshot().Expiry["ttl"]; v != 1.5 || exp.IsZero() {
+			t.Errorf("%s: Get(ttl) = (%#v, expiry %v); want 1.5 with its expiry", name, v, exp)
+		}
+		if v := m2.Get("stale"); v != nil {
+			t.Errorf("%s: DecodeFrom kept the value of stale; want the contents replaced", name)
+		}
+	}
+
+	m := &KVMap{}
+	if err := m.DecodeFrom(codecHandles["json"], strings.NewReader("[")); err == nil {
+		t.Error("DecodeFrom(invalid json) succeeded; want an error")
+	}
+}
@@ -0,0 +1,130 @@
+package mg
+
+import (
+	"path/filepath"
+	"sync"
+)
+
+// KVFileDep identifies, as a dependency of a value, the file it names. See KVDeps
+type KVFileDep string
+
+// KVDeps tracks the dependencies of the values stored in a KVStore, so invalidating a file or key
+// also removes the values derived from it, and those derived from them e.g. when a file is saved,
+// its type info, and the completions and lint results computed from the type info.
+//
+// Values are read from the store directly. Values removed from the store by other means
+// e.g. Del or eviction, are ignored when their dependencies are invalidated.
+//
+// Dependencies are not reentrant: watchers of the store (see KVMap.Watch) must not call the KVDeps.
+//
+// NOTE: All operations are no-ops on a nil KVDeps
+type KVDeps struct {
+	kvs KVStore
+
+	mu sync.Mutex
+	// deps maps each key to its dependencies
+	deps map[interface{}][]interface{}
+	// rdeps maps each dependency to the keys that depend on it
+	rdeps map[interface{}]map[interface{}]struct{}
+}
+
+// NewKVDeps returns a new KVDeps that tracks the dependencies of the values stored in kvs
+func NewKVDeps(kvs KVStore) *KVDeps {
+	return &KVDeps{
+		kvs:   kvs,
+		deps:  map[interface{}][]interface{}{},
+		rdeps: map[interface{}]map[interface{}]struct{}{},
+	}
+}
+
+// Deps returns the KVDeps of the Store's KVMap.
+// The files saved by the user (see ViewSaved) are invalidated automatically.
+func (sto *Store) Deps() *KVDeps {
+	return sto.deps
+}
+
+// Put stores the value v identified by k, derived from deps, replacing the dependencies of any previous value.
+// deps may be files (see KVFileDep) or the keys of other values.
+func (d *KVDeps) Put(k, v interface{}, deps ...interface{}) {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.forget(k)
+	if len(deps) != 0 {
+		d.deps[k] = append([]interface{}(nil), deps...)
+	}
+	for _, dep := range deps {
+		s := d.rdeps[dep]
+		if s == nil {
+			s = map[interface{}]struct{}{}
+			d.rdeps[dep] = s
+		}
+		s[k] = struct{}{}
+	}
+	d.kvs.Put(k, v)
+}
+
+// forget removes the dependencies of k. d.mu must be held
+func (d *KVDeps) forget(k interface{}) {
+	for _, dep := range d.deps[k] {
+		s := d.rdeps[dep]
+		delete(s, k)
+		if len(s) == 0 {
+			delete(d.rdeps, dep)
+		}
+	}
+	delete(d.deps, k)
+}
+
+// Invalidate removes the values identified by deps, and those that depend on them, directly or indirectly.
+// It returns the number of values whose dependencies were invalidated.
+func (d *KVDeps) Invalidate(deps ...interface{}) int {
+	if d == nil {
+		return 0
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	seen := map[interface{}]bool{}
+	keys := []interface{}{}
+	q := append([]interface{}(nil), deps...)
+	for len(q) != 0 {
+		dep := q[0]
+		q = q[1:]
+		for k := range d.rdeps[dep] {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+				q = append(q, k)
+			}
+		}
+	}
+	for _, k := range keys {
+		d.forget(k)
+	}
+	d.kvs.DelBatch(append(keys, deps...))
+	return len(keys)
+}
+
+// InvalidateFile is the equivalent of calling Invalidate with the KVFileDep of the file named fn
+func (d *KVDeps) InvalidateFile(fn string) int {
+	return d.Invalidate(KVFileDep(filepath.Clean(fn)))
+}
+
+// kvDepsInvalidator invalidates the files saved by the user in the Store's KVDeps
+type kvDepsInvalidator struct {
+	ReducerType
+	deps *KVDeps
+}
+
+func (di *kvDepsInvalidator) Reduce(mx *Ctx) *State {
+	if _, ok := mx.Action.(ViewSaved); ok && mx.View.Path != "" {
+		di.deps.InvalidateFile(mx.View.Path)
+	}
+	return mx.State
+}
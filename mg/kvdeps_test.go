@@ -0,0 +1,36 @@
+package mg
+
+import (
+	"testing"
+)
+
+func TestKVDeps(t *testing.T) {
+	h := NewHarness(t)
+	sto := h.Store
+	d := sto.Deps()
+	d.Put("types:a.go", 1, KVFileDep("/src/a.go"))
+	d.Put("completions:a.go", 2, "types:a.go")
+	d.Put("lint:a.go", 3, KVFileDep("/src/a.go"), KVFileDep("/src/b.go"))
+	d.Put("types:b.go", 4, KVFileDep("/src/b.go"))
+	sto.Put("unrelated", 5)
+
+	if n := d.Invalidate("types:a.go"); n != 1 {
+		t.Errorf("Invalidate(types:a.go) = %d; want the 1 value derived from it", n)
+	}
+	if a, b := sto.Get("types:a.go"), sto.Get("completions:a.go"); a != nil || b != nil {
+		t.Errorf("Get() = (%v, %v) after Invalidate(types:a.go); want the key and its dependents removed", a, b)
+	}
+
+	d.Put("types:a.go", 1, KVFileDep("/src/a.go"))
+	d.Put("completions:a.go", 2, "types:a.go")
+	h.Open("/src/a.go", "package a", 0).Dispatch(ViewSaved{})
+	if a, b, c := sto.Get("types:a.go"), sto.Get("completions:a.go"), sto.Get("lint:a.go"); a != nil || b != nil || c != nil {
+		t.Errorf("Get() = (%v, %v, %v) after saving a.go; want the values derived from it removed", a, b, c)
+	}
+	if b, u := sto.Get("types:b.go"), sto.Get("unrelated"); b != 4 || u != 5 {
+		t.Errorf("Get() = (%v, %v) after saving a.go; want the other values kept", b, u)
+	}
+	if n := d.InvalidateFile("/src/b.go"); n != 1 {
+		t.Errorf("InvalidateFile(b.go) = %d; want 1, lint:a.go's dependencies having been forgotten", n)
+	}
+}
@@ -0,0 +1,276 @@
+package mg
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// kvDiskExt is the extension of the files holding the values of a KVDisk
+	kvDiskExt = ".kv.gob"
+)
+
+// KVDisk implements a KVStore whose values are saved as files in a directory,
+// so they survive restarts of the agent e.g. expensive indexes such as package symbol tables.
+//
+// Values are encoded using encoding/gob, so their types must be registered using gob.Register.
+// Keys are identified by their type and value, as formatted by fmt's %T and %+v verbs,
+// so they should be simple values that format the same way across restarts e.g. strings or structs of strings.
+// Values that can't be encoded are only kept in memory, and errors are written to the Logger, if any.
+//
+// Values are cached in memory once they're read or written, so the disk is only read once for each key.
+// Writes are done synchronously, so large values should be stored from a job, not a reducer. See Jobs.Submit
+//
+// NOTE: All operations are no-ops on a nil KVDisk
+type KVDisk struct {
+	dir      string
+	log      *Logger
+	mem      KVMap
+	counters kvCounters
+}
+
+// kvDiskFile is the content of the file holding a value of a KVDisk
+type kvDiskFile struct {
+	// Key is the description of the key, to detect collisions in file names
+	Key   string
+	Value interface{}
+
+	// Path and Partial describe the path of the key, to find the values removed by DelPrefix. See kvKeyPath
+	Path    string
+	Partial bool
+}
+
+// NewKVDisk returns a new KVDisk that saves its values in directory dir, created when the first value is stored.
+// If dir is empty, values are only kept in memory.
+// If log isn't nil, errors are written to it.
+func NewKVDisk(dir string, log *Logger) *KVDisk {
+	return &KVDisk{dir: dir, log: log}
+}
+
+// DiskKV returns the KVDisk named name, whose values are saved in the directory kv/<name> in AgentConfig.StateDir.
+//
+// Reducers sharing the same name share the same KVDisk.
+// If AgentConfig.StateDir isn't set, values are only kept in memory.
+func (sto *Store) DiskKV(name string) *KVDisk {
+	dkv := &sto.diskKV
+	dkv.Lock()
+	defer dkv.Unlock()
+
+	if kvd := dkv.m[name]; kvd != nil {
+		return kvd
+	}
+	dir := ""
+	if sto.ag.stateDir != "" {
+		dir = filepath.Join(sto.ag.stateDir, "kv", sanitizeDirNamePat.ReplaceAllString(name, "~"))
+	}
+	kvd := NewKVDisk(dir, sto.ag.Log)
+	sto.ReportKVStats("DiskKV("+name+")", kvd)
+	sto.RegisterShedder("DiskKV("+name+")", kvd)
+	if dkv.m == nil {
+		dkv.m = map[string]*KVDisk{}
+	}
+	dkv.m[name] = kvd
+	return kvd
+}
+
+// Put implements KVStore.Put
+func (kvd *KVDisk) Put(k interface{}, v interface{}) {
+	if kvd == nil {
+		return
+	}
+
+	kvd.counters.add(&kvd.counters.puts, 1)
+	kvd.mem.Put(k, v)
+	if kvd.dir == "" {
+		return
+	}
+	desc := persistedKeyDesc(k)
+	if err := kvd.write(desc, k, v); err != nil {
+		kvd.logf("KVDisk: cannot save %s: %s\n", desc, err)
+	}
+}
+
+// Get implements KVStore.Get
+func (kvd *KVDisk) Get(k interface{}) interface{} {
+	if kvd == nil {
+		return nil
+	}
+
+	v := kvd.get(k)
+	kvd.counters.get(v != nil)
+	return v
+}
+
+// get returns the value identified by k, from memory, or from disk if it wasn't used yet
+func (kvd *KVDisk) get(k interface{}) interface{} {
+	if v := kvd.mem.Get(k); v != nil || kvd.dir == "" {
+		return v
+	}
+	desc := persistedKeyDesc(k)
+	v, err := kvd.read(desc)
+	if err != nil {
+		kvd.logf("KVDisk: cannot load %s: %s\n", desc, err)
+		return nil
+	}
+	if v != nil {
+		kvd.mem.Update(func(tx Tx) {
+			if tx.Get(k) == nil {
+				tx.Put(k, v)
+			}
+		})
+	}
+	return v
+}
+
+// Del implements KVStore.Del
+func (kvd *KVDisk) Del(k interface{}) {
+	if kvd == nil {
+		return
+	}
+
+	existed := kvd.mem.Get(k) != nil
+	kvd.mem.Del(k)
+	if kvd.dir != "" {
+		err := os.Remove(kvd.fileName(persistedKeyDesc(k)))
+		existed = existed || err == nil
+		if err != nil && !os.IsNotExist(err) {
+			kvd.logf("KVDisk: %s\n", err)
+		}
+	}
+	if existed {
+		kvd.counters.add(&kvd.counters.dels, 1)
+	}
+}
+
+// Stats returns the store's counters.
+// Evictions is the number of values removed from disk by Clear.
+func (kvd *KVDisk) Stats() KVStats {
+	if kvd == nil {
+		return KVStats{}
+	}
+	return kvd.counters.Stats()
+}
+
+// Namespace implements KVStore.Namespace
+func (kvd *KVDisk) Namespace(prefix string) KVStore {
+	return newKVNamespace(kvd, prefix)
+}
+
+// PutBatch implements KVStore.PutBatch
+func (kvd *KVDisk) PutBatch(vals map[interface{}]interface{}) {
+	for k, v := range vals {
+		kvd.Put(k, v)
+	}
+}
+
+// GetBatch implements KVStore.GetBatch
+func (kvd *KVDisk) GetBatch(keys []interface{}) []interface{} {
+	vals := make([]interface{}, len(keys))
+	for i, k := range keys {
+		vals[i] = kvd.Get(k)
+	}
+	return vals
+}
+
+// DelBatch implements KVStore.DelBatch
+func (kvd *KVDisk) DelBatch(keys []interface{}) {
+	for _, k := range keys {
+		kvd.Del(k)
+	}
+}
+
+// Range implements KVStore.Range
+//
+// Only the values in memory i.e. those read or written since the KVDisk was created, are passed to f:
+// the keys of the values on disk aren't known until they're used.
+func (kvd *KVDisk) Range(f func(k, v interface{}) bool) {
+	if kvd == nil {
+		return
+	}
+	kvd.mem.Range(f)
+}
+
+// Clear removes all values from the store, and their files
+func (kvd *KVDisk) Clear() {
+	if kvd == nil {
+		return
+	}
+
+	kvd.mem.Clear()
+	if kvd.dir == "" {
+		return
+	}
+	l, _ := ioutil.ReadDir(kvd.dir)
+	for _, fi := range l {
+		if !strings.HasSuffix(fi.Name(), kvDiskExt) {
+			continue
+		}
+		if os.Remove(filepath.Join(kvd.dir, fi.Name())) == nil {
+			kvd.counters.add(&kvd.counters.evictions, 1)
+		}
+	}
+}
+
+// fileName returns the name of the file holding the value of the key described by desc
+func (kvd *KVDisk) fileName(desc string) string {
+	sum := sha256.Sum256([]byte(desc))
+	return filepath.Join(kvd.dir, fmt.Sprintf("%x%s", sum[:16], kvDiskExt))
+}
+
+// write saves v as the value of the key k, described by desc, replacing its file atomically.
+// If v can't be encoded, the file is removed so a stale value isn't loaded later
+func (kvd *KVDisk) write(desc string, k, v interface{}) error {
+	fn := kvd.fileName(desc)
+	if err := os.MkdirAll(kvd.dir, 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(kvd.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	kf := &kvDiskFile{Key: desc, Value: v}
+	kf.Path, kf.Partial = kvKeyPath(k)
+	if err := gob.NewEncoder(f).Encode(kf); err != nil {
+		os.Remove(fn)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), fn)
+}
+
+// read loads the value of the key described by desc. If there's no such value, nil is returned
+func (kvd *KVDisk) read(desc string) (interface{}, error) {
+	f, err := os.Open(kvd.fileName(desc))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	kf := kvDiskFile{}
+	if err := gob.NewDecoder(f).Decode(&kf); err != nil {
+		return nil, err
+	}
+	if kf.Key != desc {
+		return nil, nil
+	}
+	return kf.Value, nil
+}
+
+func (kvd *KVDisk) logf(format string, a ...interface{}) {
+	if kvd.log != nil {
+		kvd.log.Printf(format, a...)
+	}
+}
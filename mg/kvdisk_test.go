@@ -0,0 +1,44 @@
+package mg
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+type testKVDiskKey struct{ Pkg string }
+
+func TestKVDisk(t *testing.T) {
+	dir := t.TempDir()
+	logs := &bytes.Buffer{}
+	kvd := NewKVDisk(dir, NewLogger(logs))
+	kvd.Put(testKVDiskKey{"fmt"}, []string{"Println", "Sprintf"})
+	kvd.Put(testKVDiskKey{"os"}, []string{"Open"})
+	kvd.Put(testKVDiskKey{"func"}, func() {})
+	kvd.Del(testKVDiskKey{"os"})
+	if v := kvd.Get(testKVDiskKey{"func"}); v == nil {
+		t.Error("a value that can't be encoded wasn't kept in memory")
+	}
+	if !strings.Contains(logs.String(), "cannot save") {
+		t.Errorf("the encoding error wasn't logged: %q", logs.String())
+	}
+
+	kvd = NewKVDisk(dir, NewLogger(logs))
+	if v, _ := StoreValue[[]string](kvd, testKVDiskKey{"fmt"}); len(v) != 2 || v[1] != "Sprintf" {
+		t.Errorf("Get(fmt) = (%v) after a restart; want the saved value", v)
+	}
+	for _, k := range []string{"os", "func", "missing"} {
+		if v := kvd.Get(testKVDiskKey{k}); v != nil {
+			t.Errorf("Get(%s) = (%v) after a restart; want (nil)", k, v)
+		}
+	}
+	kvd.Clear()
+	if v := NewKVDisk(dir, nil).Get(testKVDiskKey{"fmt"}); v != nil {
+		t.Errorf("Get(fmt) = (%v) after Clear(); want (nil)", v)
+	}
+
+	ag := NewTestingAgent(nil, nil, nil)
+	if a, b := ag.Store.DiskKV("symbols"), ag.Store.DiskKV("symbols"); a != b || a.dir != "" {
+		t.Error("DiskKV didn't return the same memory-only store for the same name")
+	}
+}
@@ -0,0 +1,112 @@
+package mg
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// kvLoads holds the loads in progress, and the errors of failed loads, of KVMap.GetOrLoad
+type kvLoads struct {
+	mu    sync.Mutex
+	calls map[interface{}]*kvLoadCall
+	errs  map[interface{}]kvLoadErr
+}
+
+// kvLoadCall is a load in progress. done is closed when v and err are set
+type kvLoadCall struct {
+	done chan struct{}
+	v    interface{}
+	err  error
+}
+
+// kvLoadErr is the error of a failed load, cached until it expires
+type kvLoadErr struct {
+	err    error
+	expiry time.Time
+}
+
+// GetOrLoad returns the value identified by k.
+// If there's no such value, it's loaded by calling load, and stored unless load fails or returns nil.
+//
+// Concurrent calls for the same key share a single call of load, and its result.
+// If load fails, its error is returned, and the next call tries again unless errTTL > 0,
+// in which case calls return the same error, without loading, until errTTL elapses
+// e.g. so a package that fails to import isn't imported again on every keystroke.
+// If load panics, the panic is propagated to its caller, and the other callers get an error.
+//
+// NOTE: On a nil KVMap, load is called, and its results returned
+func (m *KVMap) GetOrLoad(k interface{}, errTTL time.Duration, load func() (interface{}, error)) (interface{}, error) {
+	if m == nil {
+		return load()
+	}
+	if v := m.Get(k); v != nil {
+		return v, nil
+	}
+
+	l := &m.loads
+	l.mu.Lock()
+	if e, ok := l.errs[k]; ok {
+		if time.Now().Before(e.expiry) {
+			l.mu.Unlock()
+			return nil, e.err
+		}
+		delete(l.errs, k)
+	}
+	if c := l.calls[k]; c != nil {
+		l.mu.Unlock()
+		<-c.done
+		return c.v, c.err
+	}
+	// the value may have been stored by a load that finished since we looked
+	if v := m.peek(k); v != nil {
+		l.mu.Unlock()
+		return v, nil
+	}
+	c := &kvLoadCall{
+		done: make(chan struct{}),
+		err:  fmt.Errorf("the load of %s panicked", persistedKeyDesc(k)),
+	}
+	if l.calls == nil {
+		l.calls = map[interface{}]*kvLoadCall{}
+	}
+	l.calls[k] = c
+	l.mu.Unlock()
+
+	defer func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		delete(l.calls, k)
+		if c.err != nil && errTTL > 0 {
+			if l.errs == nil {
+				l.errs = map[interface{}]kvLoadErr{}
+			}
+			l.errs[k] = kvLoadErr{err: c.err, expiry: time.Now().Add(errTTL)}
+		}
+		close(c.done)
+	}()
+
+	v, err := load()
+	if err == nil && v != nil {
+		m.Put(k, v)
+	}
+	c.v, c.err = v, err
+	return v, err
+}
+
+// peek returns the value identified by k, without counting it as a call of Get
+func (m *KVMap) peek(k interface{}) interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.expired(k, time.Now()) {
+		return nil
+	}
+	return m.vals[k]
+}
+
+// GetOrLoad is the equivalent of KVMap.GetOrLoad
+func (s *KVShards) GetOrLoad(k interface{}, errTTL time.Duration, load func() (interface{}, error)) (interface{}, error) {
+	return s.shard(k).GetOrLoad(k, errTTL, load)
+}
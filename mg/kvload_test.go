@@ -0,0 +1,55 @@
+package mg
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestKVMapGetOrLoad(t *testing.T) {
+	m := &KVMap{}
+	var calls int64
+	release := make(chan struct{})
+	load := func() (interface{}, error) {
+		atomic.AddInt64(&calls, 1)
+		<-release
+		return "loaded", nil
+	}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := m.GetOrLoad("k", 0, load); v != "loaded" || err != nil {
+				t.Errorf("GetOrLoad(k) = (%v, %v); want (loaded, nil)", v, err)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt64(&calls); n != 1 {
+		t.Errorf("load was called %d times; want concurrent loads to be deduplicated", n)
+	}
+
+	errLoad := fmt.Errorf("cannot import")
+	fails := 0
+	fail := func() (interface{}, error) {
+		fails++
+		return nil, errLoad
+	}
+	m.GetOrLoad("retry", 0, fail)
+	if _, err := m.GetOrLoad("retry", 0, fail); err != errLoad || fails != 2 {
+		t.Errorf("GetOrLoad(retry) = (%v) after %d loads; want failed loads to be retried", err, fails)
+	}
+	fails = 0
+	m.GetOrLoad("neg", time.Hour, fail)
+	if _, err := m.GetOrLoad("neg", time.Hour, fail); err != errLoad || fails != 1 {
+		t.Errorf("GetOrLoad(neg) = (%v) after %d loads; want the error to be cached", err, fails)
+	}
+	if v := m.Get("neg"); v != nil {
+		t.Errorf("Get(neg) = (%v); want failed loads to not be stored", v)
+	}
+}
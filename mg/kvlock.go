@@ -0,0 +1,75 @@
+package mg
+
+import (
+	"sync"
+)
+
+// kvKeyLocks holds the key-level locks of KVMap.LockKey
+type kvKeyLocks struct {
+	mu sync.Mutex
+	m  map[interface{}]*kvKeyLock
+}
+
+// kvKeyLock is the lock of a key, removed when the last goroutine using it unlocks it
+type kvKeyLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// LockKey locks the key k, waiting until it's unlocked if it's already locked, and returns the function that unlocks it.
+//
+// Key-level locks are independent of the values, and of the map's own lock, so goroutines coordinating
+// on a shared resource e.g. the GOPATH pkg directory or a generated file, can serialize access to it
+// using a key that identifies it, without a global mutex.
+// Like sync.Mutex, the locks are not reentrant: locking a key that the goroutine already locked deadlocks.
+//
+// NOTE: On a nil KVMap, keys are not locked
+func (m *KVMap) LockKey(k interface{}) (unlock func()) {
+	if m == nil {
+		return func() {}
+	}
+
+	kl := &m.locks
+	kl.mu.Lock()
+	l := kl.m[k]
+	if l == nil {
+		l = &kvKeyLock{}
+		if kl.m == nil {
+			kl.m = map[interface{}]*kvKeyLock{}
+		}
+		kl.m[k] = l
+	}
+	l.refs++
+	kl.mu.Unlock()
+
+	l.mu.Lock()
+	once := sync.Once{}
+	return func() {
+		once.Do(func() {
+			l.mu.Unlock()
+
+			kl.mu.Lock()
+			defer kl.mu.Unlock()
+
+			if l.refs--; l.refs == 0 {
+				delete(kl.m, k)
+			}
+		})
+	}
+}
+
+// WithLock calls fn with the key k locked. See LockKey
+func (m *KVMap) WithLock(k interface{}, fn func()) {
+	defer m.LockKey(k)()
+	fn()
+}
+
+// LockKey is the equivalent of KVMap.LockKey
+func (s *KVShards) LockKey(k interface{}) (unlock func()) {
+	return s.shard(k).LockKey(k)
+}
+
+// WithLock is the equivalent of KVMap.WithLock
+func (s *KVShards) WithLock(k interface{}, fn func()) {
+	s.shard(k).WithLock(k, fn)
+}
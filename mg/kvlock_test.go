@@ -0,0 +1,47 @@
+package mg
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestKVMapLockKey(t *testing.T) {
+	m := &KVMap{}
+	var inside, max int64
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m.WithLock("pkgdir", func() {
+				n := atomic.AddInt64(&inside, 1)
+				for {
+					old := atomic.LoadInt64(&max)
+					if n <= old || atomic.CompareAndSwapInt64(&max, old, n) {
+						break
+					}
+				}
+				time.Sleep(time.Millisecond)
+				atomic.AddInt64(&inside, -1)
+			})
+			// other keys aren't blocked
+			m.LockKey(i)()
+		}(i)
+	}
+	wg.Wait()
+	if max != 1 {
+		t.Errorf("%d goroutines held the lock of pkgdir at once; want 1", max)
+	}
+
+	unlock := m.LockKey("k")
+	unlock()
+	unlock()
+	m.locks.mu.Lock()
+	n := len(m.locks.m)
+	m.locks.mu.Unlock()
+	if n != 0 {
+		t.Errorf("%d key locks are left after they were all unlocked; want 0", n)
+	}
+}
@@ -0,0 +1,271 @@
+package mg
+
+import (
+	"container/list"
+	"sync"
+)
+
+// KVLRU implements a KVStore that holds a bounded number of values, or bytes,
+// evicting the least recently used values to make room for new ones.
+//
+// It's meant for caches that would otherwise grow with the size of the repository
+// e.g. the per-file results of type-checking.
+//
+// NOTE: All operations are no-ops on a nil KVLRU
+type KVLRU struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxBytes   uint64
+	bytes      uint64
+	ll         *list.List
+	items      map[interface{}]*list.Element
+	counters   kvCounters
+}
+
+// kvLRUEntry is the value of the list elements of KVLRU, most recently used first
+type kvLRUEntry struct {
+	k, v interface{}
+	size uint64
+}
+
+// NewKVLRU returns a new KVLRU that holds at most maxEntries values, whose size is at most maxBytes.
+//
+// The size of a value is estimated, when it's stored, by counting the memory reachable from it and its key,
+// unless the value implements KVMemSizer. The value must not be changed while it's stored.
+// Memory shared between values is counted for each of them.
+// If maxEntries or maxBytes is 0, the corresponding limit is disabled.
+func NewKVLRU(maxEntries int, maxBytes uint64) *KVLRU {
+	return &KVLRU{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ll:         list.New(),
+		items:      map[interface{}]*list.Element{},
+	}
+}
+
+// Put implements KVStore.Put
+//
+// The value becomes the most recently used, and the least recently used values are evicted
+// until the limits are respected. If the value alone is larger than the byte limit, it's not stored.
+func (c *KVLRU) Put(k interface{}, v interface{}) {
+	if c == nil {
+		return
+	}
+
+	size := c.sizeOf(k, v)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.put(k, v, size)
+}
+
+// Get implements KVStore.Get
+//
+// The value, if found, becomes the most recently used.
+func (c *KVLRU) Get(k interface{}) interface{} {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.get(k)
+}
+
+// Del implements KVStore.Del
+func (c *KVLRU) Del(k interface{}) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.del(k) {
+		c.counters.add(&c.counters.dels, 1)
+	}
+}
+
+// Namespace implements KVStore.Namespace
+func (c *KVLRU) Namespace(prefix string) KVStore {
+	return newKVNamespace(c, prefix)
+}
+
+// PutBatch implements KVStore.PutBatch
+func (c *KVLRU) PutBatch(vals map[interface{}]interface{}) {
+	if c == nil {
+		return
+	}
+
+	sizes := make(map[interface{}]uint64, len(vals))
+	for k, v := range vals {
+		sizes[k] = c.sizeOf(k, v)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k, v := range vals {
+		c.put(k, v, sizes[k])
+	}
+}
+
+// GetBatch implements KVStore.GetBatch
+func (c *KVLRU) GetBatch(keys []interface{}) []interface{} {
+	vals := make([]interface{}, len(keys))
+	if c == nil {
+		return vals
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, k := range keys {
+		vals[i] = c.get(k)
+	}
+	return vals
+}
+
+// DelBatch implements KVStore.DelBatch
+func (c *KVLRU) DelBatch(keys []interface{}) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, k := range keys {
+		if c.del(k) {
+			c.counters.add(&c.counters.dels, 1)
+		}
+	}
+}
+
+// Range implements KVStore.Range
+//
+// The values are passed to f most recently used first, and using them doesn't affect their order.
+func (c *KVLRU) Range(f func(k, v interface{}) bool) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	l := make([]*kvLRUEntry, 0, len(c.items))
+	for el := c.ll.Front(); el != nil; el = el.Next() {
+		l = append(l, el.Value.(*kvLRUEntry))
+	}
+	c.mu.Unlock()
+
+	for _, e := range l {
+		if !f(e.k, e.v) {
+			return
+		}
+	}
+}
+
+// Clear removes all values from the store
+func (c *KVLRU) Clear() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.counters.add(&c.counters.evictions, len(c.items))
+	c.ll.Init()
+	c.items = map[interface{}]*list.Element{}
+	c.bytes = 0
+}
+
+// Len returns the number of values stored
+func (c *KVLRU) Len() int {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.items)
+}
+
+// Bytes returns the estimated size of the values stored
+func (c *KVLRU) Bytes() uint64 {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.bytes
+}
+
+// Stats returns the store's counters.
+// Evictions includes the values that weren't stored because they were larger than the byte limit.
+func (c *KVLRU) Stats() KVStats {
+	if c == nil {
+		return KVStats{}
+	}
+	return c.counters.Stats()
+}
+
+// sizeOf returns the estimated size of the entry with key k and value v
+func (c *KVLRU) sizeOf(k, v interface{}) uint64 {
+	if c.maxBytes == 0 {
+		return 0
+	}
+	// the caller is handing v over to the store, so it's safe to follow its references
+	ms := &memSizer{seen: map[uintptr]bool{}, limit: kvMemSampleLimit, deep: true}
+	return ms.sizeOf(k) + ms.sizeOf(v)
+}
+
+// overLimit returns true if a limit is exceeded. c.mu must be held
+func (c *KVLRU) overLimit() bool {
+	return (c.maxEntries > 0 && len(c.items) > c.maxEntries) ||
+		(c.maxBytes > 0 && c.bytes > c.maxBytes)
+}
+
+// put stores the value v, of size size, identified by k, then evicts values until the limits are respected.
+// c.mu must be held
+func (c *KVLRU) put(k, v interface{}, size uint64) {
+	c.counters.add(&c.counters.puts, 1)
+	c.del(k)
+	if c.maxBytes > 0 && size > c.maxBytes {
+		c.counters.add(&c.counters.evictions, 1)
+		return
+	}
+	c.items[k] = c.ll.PushFront(&kvLRUEntry{k: k, v: v, size: size})
+	c.bytes += size
+	for c.overLimit() {
+		c.del(c.ll.Back().Value.(*kvLRUEntry).k)
+		c.counters.add(&c.counters.evictions, 1)
+	}
+}
+
+// get returns the value identified by k, making it the most recently used. c.mu must be held
+func (c *KVLRU) get(k interface{}) interface{} {
+	el, ok := c.items[k]
+	c.counters.get(ok)
+	if !ok {
+		return nil
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*kvLRUEntry).v
+}
+
+// del removes the value identified by k, and reports whether there was one. c.mu must be held
+func (c *KVLRU) del(k interface{}) bool {
+	el, ok := c.items[k]
+	if !ok {
+		return false
+	}
+	c.ll.Remove(el)
+	delete(c.items, k)
+	c.bytes -= el.Value.(*kvLRUEntry).size
+	return true
+}
@@ -0,0 +1,39 @@
+package mg
+
+import (
+	"testing"
+)
+
+func TestKVLRU(t *testing.T) {
+	c := NewKVLRU(2, 0)
+	c.Put("a", 1)
+	c.Put("b", 2)
+	c.Get("a")
+	c.Put("c", 3)
+	if v := c.Get("b"); v != nil {
+		t.Errorf(`Get("b") = (%v); want the least recently used value to be evicted`, v)
+	}
+	if a, c := c.Get("a"), c.Get("c"); a != 1 || c != 3 {
+		t.Errorf(`Get("a"), Get("c") = (%v, %v); want (1, 3)`, a, c)
+	}
+
+	c = NewKVLRU(0, 3<<10)
+	c.Put("a", make([]byte, 1<<10))
+	c.Put("b", make([]byte, 1<<10))
+	c.Put("c", make([]byte, 1<<10))
+	if n := c.Len(); n != 2 {
+		t.Errorf("Len() = %d; want 2 values of 1KiB, and their headers, to fit in 3KiB", n)
+	}
+	if b := c.Bytes(); b < 2<<10 || b > 3<<10 {
+		t.Errorf("Bytes() = %d; want between 2KiB and 3KiB", b)
+	}
+	c.Put("huge", make([]byte, 4<<10))
+	if v := c.Get("huge"); v != nil || c.Len() != 2 {
+		t.Errorf("a value larger than the byte limit was stored, or evicted other values")
+	}
+	c.Del("c")
+	c.Clear()
+	if n, b := c.Len(), c.Bytes(); n != 0 || b != 0 {
+		t.Errorf("Len(), Bytes() = (%d, %d) after Clear(); want (0, 0)", n, b)
+	}
+}
@@ -0,0 +1,234 @@
+package mg
+
+import (
+	"reflect"
+	"sort"
+	"unsafe"
+)
+
+const (
+	// kvMemSampleLimit is the maximum number of values visited when sampling the size of the KV entries,
+	// so a large or deeply linked value doesn't stall the dispatcher
+	kvMemSampleLimit = 1 << 20
+)
+
+// KVMemSizer is implemented by KV values that report their own size. See KVMemUsage
+type KVMemSizer interface {
+	// KVMemSize returns the number of bytes used by the value, including the memory it references
+	KVMemSize() int64
+}
+
+// KVMemUsage holds the estimated memory usage of the Store's KV entries with the same owner
+type KVMemUsage struct {
+	// Owner is the package-qualified name of the type of the entries' keys
+	// e.g. margo.sh/golang/goutil.modFileKey.
+	// Reducers usually key their entries with a type private to their package,
+	// so it identifies the feature responsible for them.
+	// For entries stored through a namespace (see KVStore.Namespace), it's the namespace's prefix.
+	Owner string
+
+	// Entries is the number of entries
+	Entries int
+
+	// Bytes is the estimated number of bytes used by the entries' keys and values.
+	//
+	// Values that implement KVMemSizer report their own size.
+	// The size of other values is a shallow estimate: it includes strings and the backing arrays of slices,
+	// but not the memory referenced by pointers, maps, channels or the elements of slices,
+	// because it may be changed by the entry's owner while it's sampled.
+	// Memory shared between entries is only counted once, for the first entry in which it's found.
+	Bytes uint64
+
+	// Shallow is the number of entries whose size is a shallow estimate. See Bytes
+	Shallow int
+
+	// Partial is true if sampling stopped before all the memory held by the entries was counted
+	Partial bool
+}
+
+// kvMemUsage samples the memory usage of the entries in m, largest owner first
+func kvMemUsage(m *KVMap) []KVMemUsage {
+	totals := map[string]*KVMemUsage{}
+	ms := &memSizer{seen: map[uintptr]bool{}, limit: kvMemSampleLimit}
+	for k, v := range m.Values() {
+		owner := kvOwner(k)
+		mu := totals[owner]
+		if mu == nil {
+			mu = &KVMemUsage{Owner: owner}
+			totals[owner] = mu
+		}
+		mu.Entries++
+		if _, ok := v.(KVMemSizer); !ok {
+			mu.Shallow++
+		}
+		mu.Bytes += ms.sizeOf(k) + ms.sizeOf(v)
+		mu.Partial = mu.Partial || ms.exhausted()
+	}
+
+	l := make([]KVMemUsage, 0, len(totals))
+	for _, mu := range totals {
+		l = append(l, *mu)
+	}
+	sort.Slice(l, func(i, j int) bool {
+		a, b := l[i], l[j]
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		return a.Owner < b.Owner
+	})
+	return l
+}
+
+// kvOwner returns the KVMemUsage.Owner of the entry with key k
+func kvOwner(k interface{}) string {
+	if nk, ok := k.(kvNamespaceKey); ok {
+		return nk.Namespace
+	}
+	t := reflect.TypeOf(k)
+	if t == nil {
+		return "nil"
+	}
+	for t.Kind() == reflect.Ptr && t.Name() == "" {
+		t = t.Elem()
+	}
+	if t.Name() == "" || t.PkgPath() == "" {
+		return t.String()
+	}
+	return t.PkgPath() + "." + t.Name()
+}
+
+// memSizer estimates the memory held by values, counting each block of memory once.
+//
+// Values that implement KVMemSizer report their own size.
+// For other values, only memory that can't be changed once the value is stored is counted:
+// the value itself, strings, the backing arrays of slices, and the fields and elements of structs and arrays.
+// If deep is true, the memory referenced by pointers, maps and the elements of slices is also counted,
+// which is only safe if no other goroutine may be changing it.
+type memSizer struct {
+	seen  map[uintptr]bool
+	n     int
+	limit int
+	deep  bool
+}
+
+// exhausted returns true if the limit of visited values was reached
+func (ms *memSizer) exhausted() bool {
+	return ms.n >= ms.limit
+}
+
+// sizeOf returns the size of v, including the memory it holds
+func (ms *memSizer) sizeOf(v interface{}) uint64 {
+	if v == nil {
+		return 0
+	}
+	if s, ok := v.(KVMemSizer); ok {
+		if n := s.KVMemSize(); n > 0 {
+			return uint64(n)
+		}
+		return 0
+	}
+	rv := reflect.ValueOf(v)
+	return uint64(rv.Type().Size()) + ms.indirect(rv)
+}
+
+// visit marks the memory at p as counted, returning false if it already was
+func (ms *memSizer) visit(p uintptr) bool {
+	if p == 0 || ms.seen[p] {
+		return false
+	}
+	ms.seen[p] = true
+	return true
+}
+
+// indirect returns the size of the memory held by v, excluding v itself. See memSizer
+func (ms *memSizer) indirect(v reflect.Value) uint64 {
+	if ms.exhausted() {
+		return 0
+	}
+	ms.n++
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !ms.deep || v.IsNil() || !ms.visit(v.Pointer()) {
+			return 0
+		}
+		e := v.Elem()
+		return uint64(e.Type().Size()) + ms.indirect(e)
+	case reflect.Interface:
+		if v.IsNil() {
+			return 0
+		}
+		e := v.Elem()
+		if e.Kind() == reflect.Ptr || e.Kind() == reflect.Map || e.Kind() == reflect.Chan || e.Kind() == reflect.Func {
+			return ms.indirect(e)
+		}
+		return uint64(e.Type().Size()) + ms.indirect(e)
+	case reflect.String:
+		if v.Len() == 0 || !ms.visit(uintptr(unsafe.Pointer(unsafe.StringData(v.String())))) {
+			return 0
+		}
+		return uint64(v.Len())
+	case reflect.Slice:
+		if v.Cap() == 0 || !ms.visit(v.Pointer()) {
+			return 0
+		}
+		n := uint64(v.Cap()) * uint64(v.Type().Elem().Size())
+		if ms.deep && hasPointers(v.Type().Elem()) {
+			for i := 0; i < v.Len() && !ms.exhausted(); i++ {
+				n += ms.indirect(v.Index(i))
+			}
+		}
+		return n
+	case reflect.Array:
+		var n uint64
+		if hasPointers(v.Type().Elem()) {
+			for i := 0; i < v.Len() && !ms.exhausted(); i++ {
+				n += ms.indirect(v.Index(i))
+			}
+		}
+		return n
+	case reflect.Map:
+		if !ms.deep || v.IsNil() || !ms.visit(v.Pointer()) {
+			return 0
+		}
+		t := v.Type()
+		// the entries are stored in buckets, along with about a byte of overhead each
+		n := uint64(v.Len()) * uint64(t.Key().Size()+t.Elem().Size()+1)
+		if hasPointers(t.Key()) || hasPointers(t.Elem()) {
+			it := v.MapRange()
+			for it.Next() && !ms.exhausted() {
+				n += ms.indirect(it.Key()) + ms.indirect(it.Value())
+			}
+		}
+		return n
+	case reflect.Chan:
+		if !ms.deep || v.IsNil() || !ms.visit(v.Pointer()) {
+			return 0
+		}
+		return uint64(v.Cap()) * uint64(v.Type().Elem().Size())
+	case reflect.Struct:
+		var n uint64
+		for i := 0; i < v.NumField() && !ms.exhausted(); i++ {
+			n += ms.indirect(v.Field(i))
+		}
+		return n
+	}
+	return 0
+}
+
+// hasPointers returns true if values of type t may reference other memory
+func hasPointers(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.String, reflect.Slice, reflect.Map, reflect.Chan:
+		return true
+	case reflect.Array:
+		return t.Len() != 0 && hasPointers(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if hasPointers(t.Field(i).Type) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package mg
+
+import (
+	"testing"
+)
+
+type testKVMemKey struct{ Name string }
+
+func TestReducerProfileKVMem(t *testing.T) {
+	ag := NewTestingAgent(nil, nil, nil)
+	big := make([]byte, 1<<20)
+	ag.Store.Put(testKVMemKey{"a"}, big)
+	ag.Store.Put(testKVMemKey{"b"}, &struct{ Data []byte }{big})
+	ag.Store.Put("small", "value")
+
+	var last *Ctx
+	ag.Store.Subscribe(func(mx *Ctx) { last = mx })
+	ag.Store.handleAct(QueryProfile{}, nil)
+
+	var rp ReducerProfile
+	for _, ca := range last.clientActions {
+		if p, ok := ca.Data.(ReducerProfile); ok {
+			rp = p
+		}
+	}
+	if len(rp.KVMem) != 2 {
+		t.Fatalf("KVMem = (%+v); want the usage of 2 owners", rp.KVMem)
+	}
+	mu := rp.KVMem[0]
+	if mu.Owner != "margo.sh/mg.testKVMemKey" || mu.Entries != 2 || mu.Shallow != 2 || mu.Partial {
+		t.Errorf("KVMem[0] = (%+v); want 2 shallow entries owned by margo.sh/mg.testKVMemKey first", mu)
+	}
+	if mu.Bytes < 1<<20 || mu.Bytes > 1<<20+1<<10 {
+		t.Errorf("KVMem[0].Bytes = %d; want the shared slice to be counted once", mu.Bytes)
+	}
+	if mu := rp.KVMem[1]; mu.Owner != "string" || mu.Entries != 1 {
+		t.Errorf("KVMem[1] = (%+v); want 1 entry owned by string", mu)
+	}
+}
+
+type testKVMemSized struct{ size int64 }
+
+func (ts testKVMemSized) KVMemSize() int64 { return ts.size }
+
+func TestKVMemUsageShallow(t *testing.T) {
+	m := &KVMap{}
+	owned := map[int]string{}
+	m.Put(testKVMemKey{"map"}, owned)
+	m.Put(testKVMemKey{"sized"}, testKVMemSized{size: 1 << 20})
+
+	// the owner of the map may change it at any time, so sampling mustn't read it
+	started := make(chan struct{})
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			owned[i%100] = "changed"
+			if i == 0 {
+				close(started)
+			}
+			select {
+			case <-stop:
+				return
+			default:
+			}
+		}
+	}()
+	<-started
+	var l []KVMemUsage
+	for i := 0; i < 100; i++ {
+		l = kvMemUsage(m)
+	}
+	close(stop)
+	<-done
+
+	if len(l) != 1 {
+		t.Fatalf("kvMemUsage() = (%+v); want the usage of 1 owner", l)
+	}
+	if mu := l[0]; mu.Entries != 2 || mu.Shallow != 1 || mu.Bytes < 1<<20 || mu.Bytes > 1<<20+1<<10 {
+		t.Errorf("kvMemUsage()[0] = (%+v); want 2 entries, 1 of them shallow, using about 1MiB", mu)
+	}
+}
@@ -0,0 +1,86 @@
+package mg
+
+var (
+	_ KVStore = (*kvNamespace)(nil)
+)
+
+// kvNamespace implements KVStore.Namespace by wrapping the keys of the underlying store
+type kvNamespace struct {
+	kvs    KVStore
+	prefix string
+}
+
+// kvNamespaceKey is the key in the underlying store of the value stored using Key in namespace Namespace
+type kvNamespaceKey struct {
+	Namespace string
+	Key       interface{}
+}
+
+// newKVNamespace returns a view of kvs in namespace prefix
+func newKVNamespace(kvs KVStore, prefix string) *kvNamespace {
+	return &kvNamespace{kvs: kvs, prefix: prefix}
+}
+
+func (ns *kvNamespace) key(k interface{}) kvNamespaceKey {
+	return kvNamespaceKey{Namespace: ns.prefix, Key: k}
+}
+
+// Put implements KVStore.Put
+func (ns *kvNamespace) Put(k, v interface{}) {
+	ns.kvs.Put(ns.key(k), v)
+}
+
+// Get implements KVStore.Get
+func (ns *kvNamespace) Get(k interface{}) interface{} {
+	return ns.kvs.Get(ns.key(k))
+}
+
+// Del implements KVStore.Del
+func (ns *kvNamespace) Del(k interface{}) {
+	ns.kvs.Del(ns.key(k))
+}
+
+// PutBatch implements KVStore.PutBatch
+func (ns *kvNamespace) PutBatch(vals map[interface{}]interface{}) {
+	l := make(map[interface{}]interface{}, len(vals))
+	for k, v := range vals {
+		l[ns.key(k)] = v
+	}
+	ns.kvs.PutBatch(l)
+}
+
+// GetBatch implements KVStore.GetBatch
+func (ns *kvNamespace) GetBatch(keys []interface{}) []interface{} {
+	return ns.kvs.GetBatch(ns.keys(keys))
+}
+
+// DelBatch implements KVStore.DelBatch
+func (ns *kvNamespace) DelBatch(keys []interface{}) {
+	ns.kvs.DelBatch(ns.keys(keys))
+}
+
+// keys returns the keys in the underlying store of keys
+func (ns *kvNamespace) keys(keys []interface{}) []interface{} {
+	l := make([]interface{}, len(keys))
+	for i, k := range keys {
+		l[i] = ns.key(k)
+	}
+	return l
+}
+
+// Range implements KVStore.Range.
+// Only the values in namespace ns are passed to f, with their keys as they were stored through ns.
+func (ns *kvNamespace) Range(f func(k, v interface{}) bool) {
+	ns.kvs.Range(func(k, v interface{}) bool {
+		if nk, ok := k.(kvNamespaceKey); ok && nk.Namespace == ns.prefix {
+			return f(nk.Key, v)
+		}
+		return true
+	})
+}
+
+// Namespace implements KVStore.Namespace.
+// The namespace is nested in ns, its prefix being joined to that of ns with a /
+func (ns *kvNamespace) Namespace(prefix string) KVStore {
+	return newKVNamespace(ns.kvs, ns.prefix+"/"+prefix)
+}
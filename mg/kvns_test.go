@@ -0,0 +1,25 @@
+package mg
+
+import (
+	"testing"
+)
+
+func TestKVNamespace(t *testing.T) {
+	sto := NewTestingStore()
+	a, b := sto.Namespace("a"), sto.Namespace("b")
+	a.Put("k", 1)
+	b.Put("k", 2)
+	sto.Put("k", 3)
+	ab := a.Namespace("b")
+	ab.Put("k", 4)
+	if l := []interface{}{a.Get("k"), b.Get("k"), sto.Get("k"), ab.Get("k")}; l[0] != 1 || l[1] != 2 || l[2] != 3 || l[3] != 4 {
+		t.Errorf("the values of k in (a, b, the store, a/b) are (%v); want (1, 2, 3, 4)", l)
+	}
+	a.Del("k")
+	if v := b.Get("k"); v != 2 {
+		t.Errorf("deleting k in namespace a deleted it in namespace b")
+	}
+	if s := kvOwner(kvNamespaceKey{Namespace: "a/b", Key: "k"}); s != "a/b" {
+		t.Errorf("the owner of a namespaced key is (%s); want (a/b)", s)
+	}
+}
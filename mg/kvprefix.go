@@ -0,0 +1,178 @@
+package mg
+
+import (
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// kvKeyPath returns the path of key k, matched against the prefix of DelPrefix:
+// the value of string keys, and for keys stored through a namespace, the namespace joined to the path of the key with a /.
+// If the path of k is only partially known e.g. it's a struct in a namespace, partial is true.
+func kvKeyPath(k interface{}) (path string, partial bool) {
+	switch k := k.(type) {
+	case string:
+		return k, false
+	case kvNamespaceKey:
+		p, partial := kvKeyPath(k.Key)
+		return k.Namespace + "/" + p, partial
+	}
+	if v := reflect.ValueOf(k); v.Kind() == reflect.String {
+		return v.String(), false
+	}
+	return "", true
+}
+
+// kvPathHasPrefix reports whether the key whose path is described by path and partial matches prefix.
+// Partial paths only match prefixes that don't extend past their known part.
+func kvPathHasPrefix(path string, partial bool, prefix string) bool {
+	if partial && len(prefix) > len(path) {
+		return false
+	}
+	return strings.HasPrefix(path, prefix)
+}
+
+// kvKeyHasPrefix reports whether key k matches prefix. See KVStore.DelPrefix
+func kvKeyHasPrefix(k interface{}, prefix string) bool {
+	path, partial := kvKeyPath(k)
+	return kvPathHasPrefix(path, partial, prefix)
+}
+
+// DelPrefix implements KVStore.DelPrefix
+func (m *KVMap) DelPrefix(prefix string) {
+	if m == nil {
+		return
+	}
+
+	var notes kvNotes
+	defer notes.deliver()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for k := range m.vals {
+		if !kvKeyHasPrefix(k, prefix) {
+			continue
+		}
+		m.note(&notes, k, nil)
+		m.counters.add(&m.counters.dels, 1)
+		delete(m.vals, k)
+		delete(m.exp, k)
+	}
+}
+
+// DelPrefix implements KVStore.DelPrefix
+func (tx *kvTx) DelPrefix(prefix string) {
+	tx.check()
+	for k := range tx.m.vals {
+		if kvKeyHasPrefix(k, prefix) {
+			tx.Del(k)
+		}
+	}
+	for k := range tx.puts {
+		if kvKeyHasPrefix(k, prefix) {
+			tx.Del(k)
+		}
+	}
+}
+
+// DelPrefix calls .DelPrefix on each of k/v stores in the list
+func (kvl KVStores) DelPrefix(prefix string) {
+	for _, kvs := range kvl {
+		if kvs == nil {
+			continue
+		}
+		kvs.DelPrefix(prefix)
+	}
+}
+
+// DelPrefix implements KVStore.DelPrefix. Only the keys in namespace ns, or namespaces nested in it, are matched
+func (ns *kvNamespace) DelPrefix(prefix string) {
+	ns.kvs.DelPrefix(ns.prefix + "/" + prefix)
+}
+
+// DelPrefix implements KVStore.DelPrefix
+func (c *KVLRU) DelPrefix(prefix string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k := range c.items {
+		if kvKeyHasPrefix(k, prefix) && c.del(k) {
+			c.counters.add(&c.counters.dels, 1)
+		}
+	}
+}
+
+// DelPrefix implements KVStore.DelPrefix. Each shard is locked in turn
+func (s *KVShards) DelPrefix(prefix string) {
+	if s == nil {
+		return
+	}
+
+	for i := range s.shards {
+		s.shards[i].DelPrefix(prefix)
+	}
+}
+
+// kvDiskFilePath is the part of kvDiskFile decoded by KVDisk.DelPrefix, so values needn't be decoded
+type kvDiskFilePath struct {
+	Path    string
+	Partial bool
+}
+
+// DelPrefix implements KVStore.DelPrefix
+//
+// The files of the values that aren't in memory are read to find their keys' paths,
+// so it should be called from a job, not a reducer. See Jobs.Submit
+func (kvd *KVDisk) DelPrefix(prefix string) {
+	if kvd == nil {
+		return
+	}
+
+	n := kvd.mem.Len()
+	kvd.mem.DelPrefix(prefix)
+	if kvd.dir == "" {
+		kvd.counters.add(&kvd.counters.dels, n-kvd.mem.Len())
+		return
+	}
+	l, _ := ioutil.ReadDir(kvd.dir)
+	for _, fi := range l {
+		fn := filepath.Join(kvd.dir, fi.Name())
+		if strings.HasSuffix(fn, kvDiskExt) && kvd.fileHasPrefix(fn, prefix) && os.Remove(fn) == nil {
+			kvd.counters.add(&kvd.counters.dels, 1)
+		}
+	}
+}
+
+// fileHasPrefix reports whether the key of the value saved in file fn matches prefix
+func (kvd *KVDisk) fileHasPrefix(fn, prefix string) bool {
+	f, err := os.Open(fn)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	kp := kvDiskFilePath{}
+	if err := gob.NewDecoder(f).Decode(&kp); err != nil {
+		// files saved before paths were recorded don't have them
+		return false
+	}
+	return kvPathHasPrefix(kp.Path, kp.Partial, prefix)
+}
+
+// DelPrefix implements KVStore.DelPrefix
+func (skv *SharedKV) DelPrefix(prefix string) {
+	if skv == nil {
+		return
+	}
+
+	skv.do(sharedKVReq{Op: sharedKVDelPrefix, Key: prefix})
+	skv.local.DelPrefix(prefix)
+}
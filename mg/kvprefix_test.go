@@ -0,0 +1,69 @@
+package mg
+
+import (
+	"testing"
+)
+
+func TestKVStoreDelPrefix(t *testing.T) {
+	type fileKey string
+	type structKey struct{ Name string }
+
+	dir := t.TempDir()
+	stores := map[string]KVStore{
+		"KVMap":     &KVMap{},
+		"KVLRU":     NewKVLRU(0, 0),
+		"KVDisk":    NewKVDisk(dir, nil),
+		"Namespace": (&KVMap{}).Namespace("ns"),
+		"KVStores":  KVStores{&KVMap{}, &KVMap{}},
+		"KVShards":  NewKVShards(4),
+		"SharedKV":  newSharedKV("", nil),
+	}
+	for name, kvs := range stores {
+		kvs.Put("file:a.go:types", 1)
+		kvs.Put("file:b.go:types", 2)
+		kvs.Put(fileKey("file:a.go:docs"), 3)
+		kvs.Put(structKey{"file:a.go"}, 4)
+		ns := kvs.Namespace("gocode")
+		ns.Put("file:a.go:types", 5)
+		ns.Put(structKey{"file:a.go"}, 6)
+		kvs.Namespace("gocode/sub").Put("x", 7)
+
+		kvs.DelPrefix("file:a.go:")
+		if a, b, c, d := kvs.Get("file:a.go:types"), kvs.Get("file:b.go:types"), kvs.Get(fileKey("file:a.go:docs")), kvs.Get(structKey{"file:a.go"}); a != nil || b != 2 || c != nil || d != 4 {
+			t.Errorf("%s: Get() = (%v, %v, %v, %v) after DelPrefix(file:a.go:); want (nil, 2, nil, 4)", name, a, b, c, d)
+		}
+		if v := ns.Get("file:a.go:types"); v != 5 {
+			t.Errorf("%s: DelPrefix(file:a.go:) removed the value of namespace gocode", name)
+		}
+
+		ns.DelPrefix("file:")
+		if a, b := ns.Get("file:a.go:types"), ns.Get(structKey{"file:a.go"}); a != nil || b != 6 {
+			t.Errorf("%s: ns.Get() = (%v, %v) after ns.DelPrefix(file:); want (nil, 6)", name, a, b)
+		}
+
+		kvs.DelPrefix("gocode/")
+		if a, b := ns.Get(structKey{"file:a.go"}), kvs.Namespace("gocode/sub").Get("x"); a != nil || b != nil {
+			t.Errorf("%s: Get() = (%v, %v) after DelPrefix(gocode/); want the namespace, and those nested in it, removed", name, a, b)
+		}
+		if v := kvs.Get("file:b.go:types"); v != 2 {
+			t.Errorf("%s: DelPrefix(gocode/) removed a value outside the namespace", name)
+		}
+	}
+
+	kvd := NewKVDisk(dir, nil)
+	kvd.DelPrefix("file:b.go:")
+	if v := NewKVDisk(dir, nil).Get("file:b.go:types"); v != nil {
+		t.Errorf("KVDisk.DelPrefix didn't remove the value saved on disk: %v", v)
+	}
+
+	m := &KVMap{}
+	m.Put("a:1", 1)
+	m.Update(func(tx Tx) {
+		tx.Put("a:2", 2)
+		tx.Put("b:1", 3)
+		tx.DelPrefix("a:")
+	})
+	if a1, a2, b1 := m.Get("a:1"), m.Get("a:2"), m.Get("b:1"); a1 != nil || a2 != nil || b1 != 3 {
+		t.Errorf("Get() = (%v, %v, %v) after Tx.DelPrefix(a:); want (nil, nil, 3)", a1, a2, b1)
+	}
+}
@@ -0,0 +1,218 @@
+package mg
+
+import (
+	"hash/maphash"
+	"time"
+)
+
+var (
+	_ KVStore = (*KVShards)(nil)
+)
+
+const (
+	// DefaultKVShards is the number of shards of a KVShards if none is specified. See NewKVShards
+	DefaultKVShards = 16
+)
+
+// KVShards implements a KVStore by spreading its values over several KVMaps, chosen by hashing their keys,
+// so goroutines using different keys e.g. parallel reducers and jobs, rarely wait for each other.
+//
+// It supports the operations of KVMap that involve a single key, and those of KVStore.
+// Transactions (see KVMap.Update) are not supported, as they would require locking all the shards.
+//
+// NOTE: All operations are no-ops on a nil KVShards
+type KVShards struct {
+	seed   maphash.Seed
+	shards []KVMap
+}
+
+// NewKVShards returns a new KVShards with n shards. If n <= 0, DefaultKVShards is used.
+func NewKVShards(n int) *KVShards {
+	if n <= 0 {
+		n = DefaultKVShards
+	}
+	return &KVShards{
+		seed:   maphash.MakeSeed(),
+		shards: make([]KVMap, n),
+	}
+}
+
+// shard returns the map holding the value identified by k
+func (s *KVShards) shard(k interface{}) *KVMap {
+	if s == nil {
+		return nil
+	}
+	return &s.shards[maphash.Comparable(s.seed, k)%uint64(len(s.shards))]
+}
+
+// Put implements KVStore.Put
+func (s *KVShards) Put(k interface{}, v interface{}) {
+	s.shard(k).Put(k, v)
+}
+
+// PutTTL is the equivalent of KVMap.PutTTL
+func (s *KVShards) PutTTL(k interface{}, v interface{}, d time.Duration) {
+	s.shard(k).PutTTL(k, v, d)
+}
+
+// Get implements KVStore.Get
+func (s *KVShards) Get(k interface{}) interface{} {
+	return s.shard(k).Get(k)
+}
+
+// Del implements KVStore.Del
+func (s *KVShards) Del(k interface{}) {
+	s.shard(k).Del(k)
+}
+
+// CAS is the equivalent of KVMap.CAS
+func (s *KVShards) CAS(k, old, new interface{}) bool {
+	return s.shard(k).CAS(k, old, new)
+}
+
+// UpdateKey is the equivalent of KVMap.UpdateKey
+func (s *KVShards) UpdateKey(k interface{}, f func(old interface{}) (new interface{})) interface{} {
+	return s.shard(k).UpdateKey(k, f)
+}
+
+// Watch is the equivalent of KVMap.Watch
+func (s *KVShards) Watch(k interface{}, fn func(KVChange)) (unwatch func()) {
+	return s.shard(k).Watch(k, fn)
+}
+
+// Namespace implements KVStore.Namespace
+func (s *KVShards) Namespace(prefix string) KVStore {
+	return newKVNamespace(s, prefix)
+}
+
+// Range implements KVStore.Range
+//
+// Each shard is snapshotted when f reaches it, so the values aren't a snapshot of the whole store.
+func (s *KVShards) Range(f func(k, v interface{}) bool) {
+	if s == nil {
+		return
+	}
+
+	more := true
+	for i := 0; i < len(s.shards) && more; i++ {
+		s.shards[i].Range(func(k, v interface{}) bool {
+			more = f(k, v)
+			return more
+		})
+	}
+}
+
+// PutBatch implements KVStore.PutBatch. Each shard is only locked once
+func (s *KVShards) PutBatch(vals map[interface{}]interface{}) {
+	if s == nil {
+		return
+	}
+
+	batches := map[*KVMap]map[interface{}]interface{}{}
+	for k, v := range vals {
+		m := s.shard(k)
+		if batches[m] == nil {
+			batches[m] = map[interface{}]interface{}{}
+		}
+		batches[m][k] = v
+	}
+	for m, l := range batches {
+		m.PutBatch(l)
+	}
+}
+
+// GetBatch implements KVStore.GetBatch. Each shard is only locked once
+func (s *KVShards) GetBatch(keys []interface{}) []interface{} {
+	vals := make([]interface{}, len(keys))
+	if s == nil {
+		return vals
+	}
+
+	batches := map[*KVMap][]int{}
+	for i, k := range keys {
+		m := s.shard(k)
+		batches[m] = append(batches[m], i)
+	}
+	for m, idx := range batches {
+		l := make([]interface{}, len(idx))
+		for j, i := range idx {
+			l[j] = keys[i]
+		}
+		for j, v := range m.GetBatch(l) {
+			vals[idx[j]] = v
+		}
+	}
+	return vals
+}
+
+// DelBatch implements KVStore.DelBatch. Each shard is only locked once
+func (s *KVShards) DelBatch(keys []interface{}) {
+	if s == nil {
+		return
+	}
+
+	batches := map[*KVMap][]interface{}{}
+	for _, k := range keys {
+		m := s.shard(k)
+		batches[m] = append(batches[m], k)
+	}
+	for m, l := range batches {
+		m.DelBatch(l)
+	}
+}
+
+// Sweep is the equivalent of KVMap.Sweep
+func (s *KVShards) Sweep() int {
+	if s == nil {
+		return 0
+	}
+
+	n := 0
+	for i := range s.shards {
+		n += s.shards[i].Sweep()
+	}
+	return n
+}
+
+// Clear removes all values from the store
+func (s *KVShards) Clear() {
+	if s == nil {
+		return
+	}
+
+	for i := range s.shards {
+		s.shards[i].Clear()
+	}
+}
+
+// Stats returns the sum of the counters of the shards
+func (s *KVShards) Stats() KVStats {
+	ks := KVStats{}
+	if s == nil {
+		return ks
+	}
+
+	for i := range s.shards {
+		st := s.shards[i].Stats()
+		ks.Hits += st.Hits
+		ks.Misses += st.Misses
+		ks.Puts += st.Puts
+		ks.Dels += st.Dels
+		ks.Evictions += st.Evictions
+		ks.Expirations += st.Expirations
+	}
+	return ks
+}
+
+// Len returns the number of values stored
+func (s *KVShards) Len() int {
+	if s == nil {
+		return 0
+	}
+
+	n := 0
+	for i := range s.shards {
+		n += s.shards[i].Len()
+	}
+	return n
+}
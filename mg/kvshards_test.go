@@ -0,0 +1,50 @@
+package mg
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestKVShards(t *testing.T) {
+	s := NewKVShards(4)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				s.Put(fmt.Sprint(i, "/", j), j)
+				s.UpdateKey("total", func(old interface{}) interface{} {
+					n, _ := old.(int)
+					return n + 1
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n, total := s.Len(), s.Get("total"); n != 801 || total != 800 {
+		t.Errorf("Len(), Get(total) = (%d, %v); want (801, 800)", n, total)
+	}
+	used := 0
+	for i := range s.shards {
+		if s.shards[i].Len() != 0 {
+			used++
+		}
+	}
+	if used != len(s.shards) {
+		t.Errorf("the values are stored in %d of the %d shards; want all of them", used, len(s.shards))
+	}
+	n := 0
+	s.Range(func(k, v interface{}) bool {
+		n++
+		return n < 10
+	})
+	if n != 10 {
+		t.Errorf("Range called f %d times; want it to stop after 10", n)
+	}
+	s.Clear()
+	if n := s.Len(); n != 0 {
+		t.Errorf("Len() = %d after Clear(); want 0", n)
+	}
+}
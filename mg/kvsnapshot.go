@@ -0,0 +1,84 @@
+package mg
+
+import (
+	"time"
+)
+
+// KVSnapshot is a copy of the contents of a KVMap. See KVMap.Snapshot
+type KVSnapshot struct {
+	// Values holds the values of the map
+	Values map[interface{}]interface{}
+
+	// Expiry holds the expiry time of the values stored using PutTTL
+	Expiry map[interface{}]time.Time
+}
+
+// Snapshot returns a copy of the contents of the map, taken atomically.
+//
+// Unlike Values, it includes the expiry times of the values stored using PutTTL,
+// so the map can later be restored exactly, using Restore e.g. in tests or to persist the map.
+// Expired values are not included.
+func (m *KVMap) Snapshot() KVSnapshot {
+	ks := KVSnapshot{
+		Values: map[interface{}]interface{}{},
+		Expiry: map[interface{}]time.Time{},
+	}
+	if m == nil {
+		return ks
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for k, v := range m.vals {
+		if !m.expired(k, now) {
+			ks.Values[k] = v
+		}
+	}
+	for k, t := range m.exp {
+		if now.Before(t) {
+			ks.Expiry[k] = t
+		}
+	}
+	return ks
+}
+
+// Restore replaces the contents of the map with those of ks, atomically.
+//
+// The maps of ks are copied, so it may be restored several times.
+// The watchers of the keys whose values changed are notified (see Watch), but the counters aren't changed (see Stats).
+func (m *KVMap) Restore(ks KVSnapshot) {
+	if m == nil {
+		return
+	}
+
+	var notes kvNotes
+	defer notes.deliver()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for k := range m.watchers {
+		old, had := m.vals[k]
+		v, has := ks.Values[k]
+		if had != has || (has && !sameValue(old, v)) {
+			m.note(&notes, k, v)
+		}
+	}
+
+	m.vals = make(map[interface{}]interface{}, len(ks.Values))
+	for k, v := range ks.Values {
+		m.vals[k] = v
+	}
+	m.exp = nil
+	for k, t := range ks.Expiry {
+		if _, ok := m.vals[k]; !ok {
+			continue
+		}
+		if m.exp == nil {
+			m.exp = map[interface{}]time.Time{}
+		}
+		m.exp[k] = t
+	}
+}
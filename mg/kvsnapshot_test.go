@@ -0,0 +1,37 @@
+package mg
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKVMapSnapshot(t *testing.T) {
+	m := &KVMap{}
+	m.Put("a", 1)
+	m.PutTTL("ttl", 2, time.Hour)
+	m.PutTTL("expired", 3, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	ks := m.Snapshot()
+	if len(ks.Values) != 2 || len(ks.Expiry) != 1 || ks.Values["a"] != 1 {
+		t.Fatalf("Snapshot() = (%+v); want the values a and ttl, and the expiry of ttl", ks)
+	}
+
+	var changes []string
+	m.Watch("a", func(c KVChange) { changes = append(changes, fmt.Sprint(c.Value)) })
+	m.Watch("ttl", func(c KVChange) { changes = append(changes, fmt.Sprint(c.Value)) })
+	m.Put("a", 4)
+	m.Put("b", 5)
+	m.Restore(ks)
+	m.Restore(ks)
+	if s, want := strings.Join(changes, " "), "4 1"; s != want {
+		t.Errorf("the watchers received (%s); want (%s)", s, want)
+	}
+	if a, b, ttl := m.Get("a"), m.Get("b"), m.Get("ttl"); a != 1 || b != nil || ttl != 2 {
+		t.Errorf("Get(a, b, ttl) = (%v, %v, %v) after Restore; want (1, nil, 2)", a, b, ttl)
+	}
+	if ks := m.Snapshot(); len(ks.Expiry) != 1 {
+		t.Errorf("Restore didn't restore the expiry of ttl")
+	}
+}
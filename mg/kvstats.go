@@ -0,0 +1,76 @@
+package mg
+
+import (
+	"sync/atomic"
+)
+
+// KVStats holds the counters of a KVStore, since it was created
+type KVStats struct {
+	// Hits and Misses are the number of times a value was, or wasn't, found by Get
+	Hits, Misses int64
+
+	// Puts is the number of values stored and Dels is the number of values deleted
+	Puts, Dels int64
+
+	// Evictions is the number of values removed to make room for other values, or when the store is cleared
+	Evictions int64
+
+	// Expirations is the number of values removed because they expired. See KVMap.PutTTL
+	Expirations int64
+}
+
+// HitRate returns the ratio of Hits to the number of calls to Get, or 0 if it wasn't called
+func (ks KVStats) HitRate() float64 {
+	n := ks.Hits + ks.Misses
+	if n == 0 {
+		return 0
+	}
+	return float64(ks.Hits) / float64(n)
+}
+
+// KVStatser is implemented by the KVStores that keep counters e.g. KVMap and KVLRU
+type KVStatser interface {
+	Stats() KVStats
+}
+
+// kvCounters implements the counters of KVStats
+type kvCounters struct {
+	hits, misses, puts, dels, evictions, expirations int64
+}
+
+// get records a call of Get that found, or didn't find, a value
+func (kc *kvCounters) get(found bool) {
+	if found {
+		atomic.AddInt64(&kc.hits, 1)
+	} else {
+		atomic.AddInt64(&kc.misses, 1)
+	}
+}
+
+// add adds n to the counter p
+func (kc *kvCounters) add(p *int64, n int) {
+	if n != 0 {
+		atomic.AddInt64(p, int64(n))
+	}
+}
+
+// Stats returns a snapshot of the counters
+func (kc *kvCounters) Stats() KVStats {
+	return KVStats{
+		Hits:        atomic.LoadInt64(&kc.hits),
+		Misses:      atomic.LoadInt64(&kc.misses),
+		Puts:        atomic.LoadInt64(&kc.puts),
+		Dels:        atomic.LoadInt64(&kc.dels),
+		Evictions:   atomic.LoadInt64(&kc.evictions),
+		Expirations: atomic.LoadInt64(&kc.expirations),
+	}
+}
+
+// ReportKVStats adds the counters of kvs to the Metrics returned in response to QueryMetrics, as name.
+//
+// The Store's own KVMap is reported as Store, the store returned by SharedKV as SharedKV
+// and the stores returned by DiskKV as DiskKV(<name>).
+// Reporting a store with the same name as another replaces it.
+func (sto *Store) ReportKVStats(name string, kvs KVStatser) {
+	sto.metrics.reportKV(name, kvs)
+}
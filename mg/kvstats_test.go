@@ -0,0 +1,63 @@
+package mg
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKVStats(t *testing.T) {
+	ag := NewTestingAgent(nil, nil, nil)
+	sto := ag.Store
+	base := sto.Stats()
+	sto.Put("a", 1)
+	sto.Get("a")
+	sto.Get("missing")
+	sto.Del("a")
+	sto.Del("a")
+	sto.PutTTL("ttl", 1, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	sto.Sweep()
+	ks := sto.Stats()
+	want := KVStats{Hits: 1, Misses: 1, Puts: 2, Dels: 1, Expirations: 1}
+	if d := (KVStats{
+		Hits:        ks.Hits - base.Hits,
+		Misses:      ks.Misses - base.Misses,
+		Puts:        ks.Puts - base.Puts,
+		Dels:        ks.Dels - base.Dels,
+		Evictions:   ks.Evictions - base.Evictions,
+		Expirations: ks.Expirations - base.Expirations,
+	}); d != want {
+		t.Errorf("the Store's counters changed by (%+v); want (%+v)", d, want)
+	}
+
+	lru := NewKVLRU(1, 0)
+	lru.Put("a", 1)
+	lru.Put("b", 2)
+	lru.Get("a")
+	lru.Get("b")
+	sto.ReportKVStats("lru", lru)
+	if ks, want := lru.Stats(), (KVStats{Hits: 1, Misses: 1, Puts: 2, Evictions: 1}); ks != want {
+		t.Errorf("KVLRU.Stats() = (%+v); want (%+v)", ks, want)
+	}
+
+	var last *Ctx
+	sto.Subscribe(func(mx *Ctx) { last = mx })
+	sto.handleAct(QueryMetrics{}, nil)
+	var m Metrics
+	for _, ca := range last.clientActions {
+		if p, ok := ca.Data.(Metrics); ok {
+			m = p
+		}
+	}
+	var names []string
+	for _, km := range m.KV {
+		names = append(names, km.Name)
+		if km.Name == "lru" && km.HitRate != 0.5 {
+			t.Errorf("the hit rate of lru is %v; want 0.5", km.HitRate)
+		}
+	}
+	if s, want := strings.Join(names, " "), "SharedKV Store lru"; s != want {
+		t.Errorf("the metrics include the stores (%s); want (%s)", s, want)
+	}
+}
@@ -0,0 +1,104 @@
+package mg
+
+import (
+	"time"
+)
+
+const (
+	// kvSweepInterval is the interval at which expired values are removed from the Store's KVMap
+	kvSweepInterval = time.Minute
+)
+
+// PutTTL stores the value in the map with identifier key, for duration d.
+//
+// Once d has passed, the value expires: Get returns nil and it's removed from the map.
+// It's meant for caches e.g. of package lists or doc lookups, that should not live until Clear is called.
+// Storing a value for the same key using Put removes its expiry.
+// If d <= 0, it's the equivalent of Put.
+func (m *KVMap) PutTTL(k interface{}, v interface{}, d time.Duration) {
+	if m == nil {
+		return
+	}
+	if d <= 0 {
+		m.Put(k, v)
+		return
+	}
+
+	var notes kvNotes
+	defer notes.deliver()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.vals == nil {
+		m.vals = map[interface{}]interface{}{}
+	}
+	if m.exp == nil {
+		m.exp = map[interface{}]time.Time{}
+	}
+	m.vals[k] = v
+	m.exp[k] = time.Now().Add(d)
+	m.note(&notes, k, v)
+	m.counters.add(&m.counters.puts, 1)
+}
+
+// Sweep removes the expired values from the map and returns the number of values removed.
+//
+// Expired values are never returned so calling it is only necessary to release their memory
+// and notify their watchers. See Watch
+// The Store's map is swept periodically while the agent is running.
+func (m *KVMap) Sweep() int {
+	if m == nil {
+		return 0
+	}
+
+	var notes kvNotes
+	defer notes.deliver()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.sweep(time.Now(), &notes)
+}
+
+// sweep removes the values that expired before now, adding the changes to notes. m.mu must be held
+func (m *KVMap) sweep(now time.Time, notes *kvNotes) int {
+	n := 0
+	for k, t := range m.exp {
+		if !now.Before(t) {
+			m.note(notes, k, nil)
+			delete(m.vals, k)
+			delete(m.exp, k)
+			n++
+		}
+	}
+	m.counters.add(&m.counters.expirations, n)
+	return n
+}
+
+// expired returns true if the value identified by k expired before now. m.mu must be held
+func (m *KVMap) expired(k interface{}, now time.Time) bool {
+	t, ok := m.exp[k]
+	return ok && !now.Before(t)
+}
+
+// sweepKV starts removing the expired values from the Store's KVMap every kvSweepInterval.
+//
+// The returned function stops sweeping.
+func (ag *Agent) sweepKV() (stop func()) {
+	stopC := make(chan struct{})
+	go func() {
+		tick := time.NewTicker(kvSweepInterval)
+		defer tick.Stop()
+
+		for {
+			select {
+			case <-stopC:
+				return
+			case <-tick.C:
+				ag.Store.KVMap.Sweep()
+			}
+		}
+	}()
+	return func() { close(stopC) }
+}
@@ -0,0 +1,42 @@
+package mg
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKVMapPutTTL(t *testing.T) {
+	m := &KVMap{}
+	m.PutTTL("short", 1, time.Millisecond)
+	m.PutTTL("long", 2, time.Hour)
+	m.PutTTL("forever", 3, 0)
+	m.PutTTL("reset", 4, time.Millisecond)
+	m.Put("reset", 5)
+	if v := m.Get("short"); v != 1 {
+		t.Fatalf(`Get("short") = (%v) before it expired; want (1)`, v)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	if v := m.Get("short"); v != nil {
+		t.Errorf(`Get("short") = (%v) after it expired; want (nil)`, v)
+	}
+	m.Update(func(tx Tx) {
+		if v := tx.Get("short"); v != nil {
+			t.Errorf(`tx.Get("short") = (%v) after it expired; want (nil)`, v)
+		}
+	})
+	for k, want := range map[string]int{"long": 2, "forever": 3, "reset": 5} {
+		if v := m.Get(k); v != want {
+			t.Errorf("Get(%q) = (%v); want (%v)", k, v, want)
+		}
+	}
+
+	m.PutTTL("short", 1, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if n := m.Sweep(); n != 1 {
+		t.Errorf("Sweep() = %d; want 1", n)
+	}
+	if n := m.Len(); n != 3 {
+		t.Errorf("Len() = %d after the sweep; want 3", n)
+	}
+}
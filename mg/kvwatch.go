@@ -0,0 +1,82 @@
+package mg
+
+// KVChange describes a change of the value stored in a KVMap, delivered to the functions passed to KVMap.Watch
+type KVChange struct {
+	// Key identifies the value that changed
+	Key interface{}
+
+	// Value is the new value, or nil if it was deleted, cleared or expired
+	Value interface{}
+}
+
+// kvWatcher is a function passed to KVMap.Watch
+type kvWatcher struct {
+	fn func(KVChange)
+}
+
+// kvNote is a change to deliver to the watchers of its key
+type kvNote struct {
+	change   KVChange
+	watchers []*kvWatcher
+}
+
+// kvNotes holds the changes to deliver once the map is unlocked
+type kvNotes []kvNote
+
+// deliver calls the watchers of each change. The map must not be locked
+func (notes *kvNotes) deliver() {
+	for _, n := range *notes {
+		for _, w := range n.watchers {
+			w.fn(n.change)
+		}
+	}
+}
+
+// Watch arranges for fn to be called each time the value identified by k is stored or removed,
+// so reducers can react to changes e.g. cache invalidations, instead of reading the value on every action.
+//
+// fn is called synchronously on the goroutine that made the change, after the map is unlocked,
+// so it may use the map, but should return quickly.
+// Expired values (see PutTTL) are reported when they're removed by Sweep, not when they expire.
+// Reducers usually start watching in RMount and call the returned function, to stop watching, in RUnmount.
+//
+// NOTE: on a nil KVMap, fn is never called
+func (m *KVMap) Watch(k interface{}, fn func(KVChange)) (unwatch func()) {
+	if m == nil {
+		return func() {}
+	}
+
+	w := &kvWatcher{fn: fn}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.watchers == nil {
+		m.watchers = map[interface{}][]*kvWatcher{}
+	}
+	l := m.watchers[k]
+	m.watchers[k] = append(l[:len(l):len(l)], w)
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		var l []*kvWatcher
+		for _, p := range m.watchers[k] {
+			if p != w {
+				l = append(l, p)
+			}
+		}
+		if len(l) == 0 {
+			delete(m.watchers, k)
+		} else {
+			m.watchers[k] = l
+		}
+	}
+}
+
+// note adds the change of the value identified by k to v, to notes, if it's watched. m.mu must be held
+func (m *KVMap) note(notes *kvNotes, k, v interface{}) {
+	if l := m.watchers[k]; len(l) != 0 {
+		*notes = append(*notes, kvNote{change: KVChange{Key: k, Value: v}, watchers: l})
+	}
+}
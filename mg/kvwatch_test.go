@@ -0,0 +1,33 @@
+package mg
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKVMapWatch(t *testing.T) {
+	m := &KVMap{}
+	var got []string
+	unwatch := m.Watch("env", func(c KVChange) {
+		// the map is unlocked while watchers are called
+		m.Get("env")
+		got = append(got, fmt.Sprintf("%v=%v", c.Key, c.Value))
+	})
+	m.Put("env", 1)
+	m.Put("other", 2)
+	m.Update(func(tx Tx) { tx.Put("env", 3) })
+	m.Del("env")
+	m.Del("env")
+	m.PutTTL("env", 4, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	m.Sweep()
+	m.Put("env", 5)
+	m.Clear()
+	unwatch()
+	m.Put("env", 6)
+	if s, want := strings.Join(got, " "), "env=1 env=3 env=<nil> env=4 env=<nil> env=5 env=<nil>"; s != want {
+		t.Errorf("the watcher received (%s); want (%s)", s, want)
+	}
+}
@@ -0,0 +1,276 @@
+package mg
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+var (
+	_ KVStore   = (*LoggingKVStore)(nil)
+	_ KVStore   = (*MetricsKVStore)(nil)
+	_ KVStore   = (*ReadOnlyKVStore)(nil)
+	_ KVStatser = (*MetricsKVStore)(nil)
+)
+
+const (
+	// kvLogValueLimit is the maximum length of the values written to the log by LoggingKVStore
+	kvLogValueLimit = 80
+)
+
+// kvWrapperFuncs are the prefixes of the functions skipped by kvCaller
+var kvWrapperFuncs = []string{
+	"margo.sh/mg.(*kvNamespace).",
+	"margo.sh/mg.(*LoggingKVStore).",
+	"margo.sh/mg.(*MetricsKVStore).",
+	"margo.sh/mg.(*ReadOnlyKVStore).",
+	"margo.sh/mg.KVStores.",
+}
+
+// kvCaller returns the function, and its position, that called into the wrapper that called kvCaller,
+// skipping namespaces and other wrappers, so wrappers can be composed
+func kvCaller() string {
+	pc := make([]uintptr, 16)
+	frames := runtime.CallersFrames(pc[:runtime.Callers(3, pc)])
+	for {
+		f, more := frames.Next()
+		wrapper := false
+		for _, s := range kvWrapperFuncs {
+			if strings.HasPrefix(f.Function, s) {
+				wrapper = true
+				break
+			}
+		}
+		if !wrapper {
+			return fmt.Sprintf("%s (%s:%d)", f.Function, filepath.Base(f.File), f.Line)
+		}
+		if !more {
+			return "?"
+		}
+	}
+}
+
+// LoggingKVStore implements a KVStore that writes the writes to the store it wraps to a Logger,
+// along with the function that made them e.g. to find which reducer wrote a bad value.
+//
+// Reads aren't logged. Values are formatted using fmt's %v verb, and truncated.
+type LoggingKVStore struct {
+	kvs  KVStore
+	log  *Logger
+	name string
+}
+
+// NewLoggingKVStore returns a new LoggingKVStore that wraps kvs, and writes to log with name as prefix
+func NewLoggingKVStore(kvs KVStore, log *Logger, name string) *LoggingKVStore {
+	return &LoggingKVStore{kvs: kvs, log: log, name: name}
+}
+
+// logf writes the operation op, and its caller, to the log
+func (l *LoggingKVStore) logf(op string, format string, a ...interface{}) {
+	l.log.Printf("%s: %s %s by %s\n", l.name, op, fmt.Sprintf(format, a...), kvCaller())
+}
+
+// value formats v for the log
+func (l *LoggingKVStore) value(v interface{}) string {
+	s := fmt.Sprintf("%v", v)
+	if len(s) > kvLogValueLimit {
+		s = s[:kvLogValueLimit] + "..."
+	}
+	return fmt.Sprintf("(%T) %s", v, s)
+}
+
+// Put implements KVStore.Put
+func (l *LoggingKVStore) Put(k, v interface{}) {
+	l.logf("Put", "%s = %s", persistedKeyDesc(k), l.value(v))
+	l.kvs.Put(k, v)
+}
+
+// Get implements KVStore.Get
+func (l *LoggingKVStore) Get(k interface{}) interface{} {
+	return l.kvs.Get(k)
+}
+
+// Del implements KVStore.Del
+func (l *LoggingKVStore) Del(k interface{}) {
+	l.logf("Del", "%s", persistedKeyDesc(k))
+	l.kvs.Del(k)
+}
+
+// DelPrefix implements KVStore.DelPrefix
+func (l *LoggingKVStore) DelPrefix(prefix string) {
+	l.logf("DelPrefix", "%q", prefix)
+	l.kvs.DelPrefix(prefix)
+}
+
+// Namespace implements KVStore.Namespace
+func (l *LoggingKVStore) Namespace(prefix string) KVStore {
+	return newKVNamespace(l, prefix)
+}
+
+// Range implements KVStore.Range
+func (l *LoggingKVStore) Range(f func(k, v interface{}) bool) {
+	l.kvs.Range(f)
+}
+
+// PutBatch implements KVStore.PutBatch
+func (l *LoggingKVStore) PutBatch(vals map[interface{}]interface{}) {
+	for k, v := range vals {
+		l.logf("PutBatch", "%s = %s", persistedKeyDesc(k), l.value(v))
+	}
+	l.kvs.PutBatch(vals)
+}
+
+// GetBatch implements KVStore.GetBatch
+func (l *LoggingKVStore) GetBatch(keys []interface{}) []interface{} {
+	return l.kvs.GetBatch(keys)
+}
+
+// DelBatch implements KVStore.DelBatch
+func (l *LoggingKVStore) DelBatch(keys []interface{}) {
+	for _, k := range keys {
+		l.logf("DelBatch", "%s", persistedKeyDesc(k))
+	}
+	l.kvs.DelBatch(keys)
+}
+
+// MetricsKVStore implements a KVStore that counts the operations on the store it wraps,
+// so stores that don't keep counters can be reported using Store.ReportKVStats.
+//
+// Evictions and Expirations aren't known, so they're always 0.
+type MetricsKVStore struct {
+	kvs      KVStore
+	counters kvCounters
+}
+
+// NewMetricsKVStore returns a new MetricsKVStore that wraps kvs
+func NewMetricsKVStore(kvs KVStore) *MetricsKVStore {
+	return &MetricsKVStore{kvs: kvs}
+}
+
+// Stats implements KVStatser
+func (m *MetricsKVStore) Stats() KVStats {
+	return m.counters.Stats()
+}
+
+// Put implements KVStore.Put
+func (m *MetricsKVStore) Put(k, v interface{}) {
+	m.counters.add(&m.counters.puts, 1)
+	m.kvs.Put(k, v)
+}
+
+// Get implements KVStore.Get
+func (m *MetricsKVStore) Get(k interface{}) interface{} {
+	v := m.kvs.Get(k)
+	m.counters.get(v != nil)
+	return v
+}
+
+// Del implements KVStore.Del
+func (m *MetricsKVStore) Del(k interface{}) {
+	m.counters.add(&m.counters.dels, 1)
+	m.kvs.Del(k)
+}
+
+// DelPrefix implements KVStore.DelPrefix. The values removed aren't known, so it's counted as a single deletion
+func (m *MetricsKVStore) DelPrefix(prefix string) {
+	m.counters.add(&m.counters.dels, 1)
+	m.kvs.DelPrefix(prefix)
+}
+
+// Namespace implements KVStore.Namespace
+func (m *MetricsKVStore) Namespace(prefix string) KVStore {
+	return newKVNamespace(m, prefix)
+}
+
+// Range implements KVStore.Range
+func (m *MetricsKVStore) Range(f func(k, v interface{}) bool) {
+	m.kvs.Range(f)
+}
+
+// PutBatch implements KVStore.PutBatch
+func (m *MetricsKVStore) PutBatch(vals map[interface{}]interface{}) {
+	m.counters.add(&m.counters.puts, len(vals))
+	m.kvs.PutBatch(vals)
+}
+
+// GetBatch implements KVStore.GetBatch
+func (m *MetricsKVStore) GetBatch(keys []interface{}) []interface{} {
+	vals := m.kvs.GetBatch(keys)
+	for _, v := range vals {
+		m.counters.get(v != nil)
+	}
+	return vals
+}
+
+// DelBatch implements KVStore.DelBatch
+func (m *MetricsKVStore) DelBatch(keys []interface{}) {
+	m.counters.add(&m.counters.dels, len(keys))
+	m.kvs.DelBatch(keys)
+}
+
+// ReadOnlyKVStore implements a KVStore that ignores writes to the store it wraps
+// e.g. to pass a cache to code that must not change it.
+//
+// If it has a Logger, the ignored writes are written to it, along with the function that made them.
+type ReadOnlyKVStore struct {
+	kvs KVStore
+	log *Logger
+}
+
+// NewReadOnlyKVStore returns a new ReadOnlyKVStore that wraps kvs. If log isn't nil, ignored writes are written to it
+func NewReadOnlyKVStore(kvs KVStore, log *Logger) *ReadOnlyKVStore {
+	return &ReadOnlyKVStore{kvs: kvs, log: log}
+}
+
+// ignore logs the ignored operation op
+func (r *ReadOnlyKVStore) ignore(op string) {
+	if r.log != nil {
+		r.log.Printf("ReadOnlyKVStore: %s ignored, by %s\n", op, kvCaller())
+	}
+}
+
+// Put implements KVStore.Put by ignoring the write
+func (r *ReadOnlyKVStore) Put(k, v interface{}) {
+	r.ignore("Put " + persistedKeyDesc(k))
+}
+
+// Get implements KVStore.Get
+func (r *ReadOnlyKVStore) Get(k interface{}) interface{} {
+	return r.kvs.Get(k)
+}
+
+// Del implements KVStore.Del by ignoring the write
+func (r *ReadOnlyKVStore) Del(k interface{}) {
+	r.ignore("Del " + persistedKeyDesc(k))
+}
+
+// DelPrefix implements KVStore.DelPrefix by ignoring the write
+func (r *ReadOnlyKVStore) DelPrefix(prefix string) {
+	r.ignore(fmt.Sprintf("DelPrefix %q", prefix))
+}
+
+// Namespace implements KVStore.Namespace
+func (r *ReadOnlyKVStore) Namespace(prefix string) KVStore {
+	return newKVNamespace(r, prefix)
+}
+
+// Range implements KVStore.Range
+func (r *ReadOnlyKVStore) Range(f func(k, v interface{}) bool) {
+	r.kvs.Range(f)
+}
+
+// PutBatch implements KVStore.PutBatch by ignoring the write
+func (r *ReadOnlyKVStore) PutBatch(vals map[interface{}]interface{}) {
+	r.ignore(fmt.Sprintf("PutBatch of %d values", len(vals)))
+}
+
+// GetBatch implements KVStore.GetBatch
+func (r *ReadOnlyKVStore) GetBatch(keys []interface{}) []interface{} {
+	return r.kvs.GetBatch(keys)
+}
+
+// DelBatch implements KVStore.DelBatch by ignoring the write
+func (r *ReadOnlyKVStore) DelBatch(keys []interface{}) {
+	r.ignore(fmt.Sprintf("DelBatch of %d keys", len(keys)))
+}
@@ -0,0 +1,40 @@
+package mg
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestKVStoreWrappers(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log := NewLogger(buf)
+	m := &KVMap{}
+	mkvs := NewMetricsKVStore(m)
+	kvs := NewLoggingKVStore(mkvs, log, "cache")
+
+	kvs.Namespace("ns").Put("a", 1)
+	kvs.Del("b")
+	if s := buf.String(); !strings.Contains(s, "cache: Put mg.kvNamespaceKey:{Namespace:ns Key:a} = (int) 1 by margo.sh/mg.TestKVStoreWrappers") ||
+		!strings.Contains(s, "cache: Del string:b by margo.sh/mg.TestKVStoreWrappers") {
+		t.Errorf("LoggingKVStore logged (%s); want the writes and their caller", s)
+	}
+
+	kvs.Namespace("ns").Get("a")
+	kvs.Get("missing")
+	if st := mkvs.Stats(); st.Puts != 1 || st.Dels != 1 || st.Hits != 1 || st.Misses != 1 {
+		t.Errorf("MetricsKVStore.Stats() = %+v; want 1 put, 1 del, 1 hit and 1 miss", st)
+	}
+
+	buf.Reset()
+	ro := NewReadOnlyKVStore(m, log)
+	ro.Put("c", 3)
+	ro.Namespace("ns").Del("a")
+	ro.DelPrefix("")
+	if v, c := ro.Namespace("ns").Get("a"), m.Get("c"); v != 1 || c != nil {
+		t.Errorf("Get() = (%v, %v) after writes to ReadOnlyKVStore; want (1, nil)", v, c)
+	}
+	if s := buf.String(); strings.Count(s, "ignored, by margo.sh/mg.TestKVStoreWrappers") != 3 {
+		t.Errorf("ReadOnlyKVStore logged (%s); want the 3 ignored writes", s)
+	}
+}
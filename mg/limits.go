@@ -0,0 +1,101 @@
+package mg
+
+import (
+	"fmt"
+	"io"
+	"margo.sh/mg/actions"
+)
+
+// reqSizeError is the error reported when a request exceeds AgentConfig.MaxRequestSize
+type reqSizeError struct {
+	max int
+}
+
+func (e reqSizeError) Error() string {
+	return fmt.Sprintf("request too large: it exceeds the limit of %d bytes", e.max)
+}
+
+// byteReader is the interface implemented by the readers that requests are decoded from
+type byteReader interface {
+	io.Reader
+	io.ByteScanner
+}
+
+// limitReader limits the number of bytes the decoder may consume while decoding a request.
+//
+// The decoder grows its buffers as it reads, so limiting the bytes it reads
+// also limits how much it allocates, regardless of the lengths claimed by the data.
+//
+// It implements io.ByteScanner so the decoder doesn't read ahead.
+type limitReader struct {
+	r   byteReader
+	max int
+	n   int
+
+	// exceeded is set when a read fails because the limit was reached
+	exceeded bool
+}
+
+func (lr *limitReader) Read(p []byte) (int, error) {
+	if lr.max > 0 {
+		rem := lr.max - lr.n
+		if rem <= 0 {
+			return 0, lr.fail()
+		}
+		if len(p) > rem {
+			p = p[:rem]
+		}
+	}
+	n, err := lr.r.Read(p)
+	lr.n += n
+	return n, err
+}
+
+func (lr *limitReader) ReadByte() (byte, error) {
+	if lr.max > 0 && lr.n >= lr.max {
+		return 0, lr.fail()
+	}
+	b, err := lr.r.ReadByte()
+	if err == nil {
+		lr.n++
+	}
+	return b, err
+}
+
+func (lr *limitReader) UnreadByte() error {
+	err := lr.r.UnreadByte()
+	if err == nil && lr.n > 0 {
+		lr.n--
+	}
+	return err
+}
+
+func (lr *limitReader) fail() error {
+	lr.exceeded = true
+	return reqSizeError{max: lr.max}
+}
+
+// reset starts counting the bytes of a new request
+func (lr *limitReader) reset() {
+	lr.n = 0
+	lr.exceeded = false
+}
+
+// rejectReq sends the error response for request rq, which exceeded AgentConfig.MaxRequestSize.
+//
+// The request is only partially decoded so its Cookie may be empty.
+func (ag *Agent) rejectReq(c *agentClient, rq *agentReq, err error) {
+	ag.Log.Printf("ipc.decode: client %d: rejecting request %s: %s\n", c.id, rq.Cookie, err)
+	ag.sendTo(c, agentRes{
+		Cookie: rq.Cookie,
+		Error:  err.Error(),
+	})
+}
+
+// checkActionSize returns an error if the Data of action d exceeds AgentConfig.MaxActionDataSize
+func (ag *Agent) checkActionSize(d actions.ActionData) error {
+	if max := ag.maxActionDataSize; max > 0 && len(d.Data) > max {
+		return fmt.Errorf("action data too large: %d bytes exceeds the limit of %d bytes", len(d.Data), max)
+	}
+	return nil
+}
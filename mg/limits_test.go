@@ -0,0 +1,78 @@
+package mg
+
+import (
+	"bytes"
+	"github.com/ugorji/go/codec"
+	"margo.sh/mg/actions"
+	"margo.sh/mgutil"
+	"strings"
+	"testing"
+)
+
+func TestAgentMaxRequestSize(t *testing.T) {
+	out := &bytes.Buffer{}
+	big := `{"Cookie":"big","Actions":[{"Name":"QueryIssues","Data":"` + strings.Repeat("x", 1<<10) + `"}]}`
+	ag, err := NewAgent(AgentConfig{
+		Stdin:          &mgutil.IOWrapper{Reader: strings.NewReader(`{"Cookie":"small"}` + "\n" + big)},
+		Stdout:         &mgutil.IOWrapper{Writer: out},
+		Stderr:         &mgutil.IOWrapper{},
+		MaxRequestSize: 512,
+	})
+	if err != nil {
+		t.Fatalf("agent creation failed: %s", err)
+	}
+	runErr := ag.Run()
+	if runErr == nil || !strings.Contains(runErr.Error(), "request too large") {
+		t.Errorf("ag.Run() = (%v); want request too large error", runErr)
+	}
+
+	var res struct{ Cookie, Error string }
+	dec := codec.NewDecoder(out, ag.handle)
+	for res.Cookie != "big" {
+		if err := dec.Decode(&res); err != nil {
+			t.Fatalf("no response to the large request: %s", err)
+		}
+	}
+	if !strings.Contains(res.Error, "request too large") {
+		t.Errorf("res.Error = (%s); want request too large error", res.Error)
+	}
+}
+
+func TestAgentMaxActionDataSize(t *testing.T) {
+	out := &bytes.Buffer{}
+	ag, err := NewAgent(AgentConfig{
+		Stdin:             &mgutil.IOWrapper{},
+		Stdout:            &mgutil.IOWrapper{Writer: out},
+		Stderr:            &mgutil.IOWrapper{},
+		Codec:             "msgpack",
+		MaxActionDataSize: 8,
+	})
+	if err != nil {
+		t.Fatalf("agent creation failed: %s", err)
+	}
+	ag.Store.Subscribe(ag.sub)
+
+	rq := newAgentReq(ag.Store)
+	rq.Actions = []actions.ActionData{
+		{Name: "QueryIssues", Data: codec.Raw(strings.Repeat("x", 64))},
+		{Name: "QueryIssues"},
+	}
+	rq.finalize(ag)
+	ag.Store.handleReq(rq)
+
+	var res struct {
+		Actions []struct{ Name, Error string }
+	}
+	if err := codec.NewDecoder(out, ag.handle).Decode(&res); err != nil {
+		t.Fatalf("dec.Decode(): %s", err)
+	}
+	if len(res.Actions) != 2 {
+		t.Fatalf("len(res.Actions) = (%d); want (2)", len(res.Actions))
+	}
+	if e := res.Actions[0].Error; !strings.Contains(e, "action data too large") {
+		t.Errorf("res.Actions[0].Error = (%s); want action data too large error", e)
+	}
+	if e := res.Actions[1].Error; e != "" {
+		t.Errorf("res.Actions[1].Error = (%s); want no error", e)
+	}
+}
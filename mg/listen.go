@@ -0,0 +1,349 @@
+package mg
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"margo.sh/mgutil"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ListenHandshakeTimeout is the amount of time a client has to send its token after connecting
+	ListenHandshakeTimeout = 10 * time.Second
+)
+
+// agentListener holds the configuration for listening for client connections
+// instead of communicating over stdin/stdout
+type agentListener struct {
+	network string
+	addr    string
+	token   string
+
+	// ws is set if clients connect using WebSocket
+	ws bool
+
+	// tls is set if connections are encrypted using TLS, with the certificate whose fingerprint is set when listening
+	tls         bool
+	fingerprint string
+}
+
+// newAgentListener parses the `network:address` string s and returns a listener config.
+// If token is empty, a random token is generated.
+func newAgentListener(s, token string) (agentListener, error) {
+	i := strings.IndexByte(s, ':')
+	if i <= 0 {
+		return agentListener{}, fmt.Errorf("Invalid listen address '%s'. Expected e.g. tcp:127.0.0.1:0", s)
+	}
+	ln := agentListener{network: s[:i], addr: s[i+1:], token: token}
+	switch ln.network {
+	case "tcp", "tcp4", "tcp6":
+	case "tls":
+		ln.network = "tcp"
+		ln.tls = true
+	case "ws":
+		ln.network = "tcp"
+		ln.ws = true
+	case "wss":
+		ln.network = "tcp"
+		ln.ws = true
+		ln.tls = true
+	case "unix":
+	case "npipe":
+		if !npipeSupported {
+			return agentListener{}, fmt.Errorf("Invalid listen network '%s'. Named pipes are only supported on windows", ln.network)
+		}
+	default:
+		return agentListener{}, fmt.Errorf("Invalid listen network '%s'. Expected tcp, tcp4, tcp6, tls, ws, wss, unix or npipe", ln.network)
+	}
+	if ln.token == "" {
+		p := make([]byte, 16)
+		if _, err := rand.Read(p); err != nil {
+			return agentListener{}, fmt.Errorf("Cannot generate listen token: %s", err)
+		}
+		ln.token = hex.EncodeToString(p)
+	}
+	return ln, nil
+}
+
+// listen starts listening on the configured address
+func (ln agentListener) listen() (net.Listener, error) {
+	if ln.network == "npipe" {
+		return listenPipe(ln.addr)
+	}
+	return net.Listen(ln.network, ln.addr)
+}
+
+// serve listens for client connections and handles their requests.
+//
+// The store is mounted when the first client connects,
+// and serve returns when there are no more connected clients
+// and none reconnects within AgentConfig.ReconnectTimeout.
+func (ag *Agent) serve() error {
+	ln := ag.listen
+	l, err := ln.listen()
+	if err != nil {
+		return fmt.Errorf("ipc.listen: %s", err)
+	}
+	defer l.Close()
+
+	addr := l.Addr()
+	network := addr.Network()
+	switch {
+	case ln.ws && ln.tls:
+		network = "wss"
+	case ln.ws:
+		network = "ws"
+	case ln.tls:
+		network = "tls"
+	}
+	if !ln.tls {
+		ag.Log.Printf("ipc.listen: %s:%s token:%s\n", network, addr, ln.token)
+	} else {
+		l, ag.listen.fingerprint, err = listenTLS(l)
+		if err != nil {
+			return fmt.Errorf("ipc.listen: %s", err)
+		}
+		ag.Log.Printf("ipc.listen: %s:%s token:%s fingerprint:%s\n", network, addr, ln.token, ag.listen.fingerprint)
+	}
+
+	clients, acceptErr := ag.acceptClients(l)
+	c, ok := <-clients
+	if !ok {
+		return acceptErr()
+	}
+	defer ag.start()()
+
+	// live is the number of connected clients.
+	// When the last client disconnects, it's kept in the list of clients as stale,
+	// so responses sent during shutdown still reach it, until another client connects.
+	var (
+		mu      sync.Mutex
+		live    int
+		stale   *agentClient
+		lastErr error
+		changed = make(chan struct{}, 1)
+		readers = sync.WaitGroup{}
+	)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	connect := func(c *agentClient) {
+		ag.clients.add(c)
+		mu.Lock()
+		live++
+		if p := stale; p != nil {
+			stale = nil
+			ag.clients.drop(p)
+			p.stdout.Close()
+		}
+		mu.Unlock()
+		notify()
+
+		ag.Log.Printf("ipc.accept: client %d: %s\n", c.id, c.addr)
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+
+			err := ag.communicate(c)
+			if err != nil {
+				ag.Log.Printf("ipc: client %d: %s\n", c.id, err)
+			}
+			c.stdin.Close()
+
+			mu.Lock()
+			live--
+			if ag.clients.drop(c) {
+				c.stdout.Close()
+			} else {
+				stale = c
+			}
+			lastErr = err
+			mu.Unlock()
+			notify()
+		}()
+	}
+
+	connect(c)
+	acceptDone := make(chan struct{})
+	go func() {
+		defer close(acceptDone)
+		for c := range clients {
+			connect(c)
+		}
+	}()
+
+	var expired <-chan time.Time
+	for done := false; !done; {
+		select {
+		case <-changed:
+		case <-expired:
+			done = true
+		}
+
+		mu.Lock()
+		idle := live == 0
+		err = lastErr
+		mu.Unlock()
+
+		switch {
+		case !idle:
+			done = false
+			expired = nil
+		case done || ag.reconnectTimeout <= 0:
+			done = true
+		case expired == nil:
+			ag.Log.Printf("ipc: no clients connected, waiting %s for a client to reconnect\n", ag.reconnectTimeout)
+			expired = time.After(ag.reconnectTimeout)
+		}
+	}
+
+	// make sure no more requests are received before the queue is stopped
+	l.Close()
+	<-acceptDone
+	for _, c := range ag.clients.list() {
+		c.stdin.Close()
+	}
+	readers.Wait()
+	return err
+}
+
+// acceptClients accepts connections on l until it's closed, and sends the authenticated clients on the returned channel.
+//
+// Each connection is authenticated on its own goroutine, within ListenHandshakeTimeout,
+// so a client that connects but never sends its token doesn't delay the others.
+// When l is closed, the connections still being authenticated are closed,
+// and the channel is closed once their handshakes returned. err then returns the error that stopped l.Accept.
+func (ag *Agent) acceptClients(l net.Listener) (clients <-chan *agentClient, err func() error) {
+	var (
+		ch        = make(chan *agentClient)
+		mu        sync.Mutex
+		acceptErr error
+		pending   = map[net.Conn]bool{}
+		wg        sync.WaitGroup
+	)
+	go func() {
+		defer close(ch)
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				mu.Lock()
+				acceptErr = fmt.Errorf("ipc.accept: %s", err)
+				for conn := range pending {
+					conn.Close()
+				}
+				mu.Unlock()
+				wg.Wait()
+				return
+			}
+
+			mu.Lock()
+			pending[conn] = true
+			mu.Unlock()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c, err := ag.authenticate(conn)
+				mu.Lock()
+				delete(pending, conn)
+				mu.Unlock()
+				if err != nil {
+					ag.Log.Printf("ipc.handshake: rejected %s: %s\n", conn.RemoteAddr(), err)
+					conn.Close()
+					return
+				}
+				ch <- c
+			}()
+		}
+	}()
+	return ch, func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		return acceptErr
+	}
+}
+
+// authenticate does the handshake of the connection conn, and returns its client
+func (ag *Agent) authenticate(conn net.Conn) (*agentClient, error) {
+	if ag.listen.ws {
+		ws, err := ag.handshakeWS(conn)
+		if err != nil {
+			return nil, err
+		}
+		stdin := &mgutil.IOWrapper{Reader: ws, Closer: readSideCloser(conn)}
+		stdout := &mgutil.IOWrapper{Locker: &sync.Mutex{}, Writer: ws, Closer: ws, Flusher: ws}
+		return ag.newRemoteClient(conn, stdin, stdout), nil
+	}
+
+	br, err := ag.handshake(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	// the read side is closed separately, so responses can still be sent during shutdown,
+	// and without a lock, so it can be closed while a read is blocked
+	stdin := &mgutil.IOWrapper{Reader: br, Closer: readSideCloser(conn)}
+	stdout := &mgutil.IOWrapper{Locker: &sync.Mutex{}, Writer: conn, Closer: conn}
+	return ag.newRemoteClient(conn, stdin, stdout), nil
+}
+
+// newRemoteClient returns a new client connected through conn
+func (ag *Agent) newRemoteClient(conn net.Conn, stdin io.ReadCloser, stdout io.WriteCloser) *agentClient {
+	c := newAgentClient(ag, stdin, stdout)
+	c.remote = true
+	c.addr = conn.RemoteAddr().String()
+	return c
+}
+
+// readCloser is implemented by connections whose read side can be closed separately e.g. tcp and unix sockets
+type readCloser interface {
+	CloseRead() error
+}
+
+// readSideCloser returns a closer that stops reads from conn, without preventing writes if possible
+func readSideCloser(conn net.Conn) io.Closer {
+	switch c := conn.(type) {
+	case readCloser:
+		return closerFunc(c.CloseRead)
+	case *tls.Conn:
+		// TLS can't close the read side alone, but a past deadline unblocks pending and future reads
+		return closerFunc(func() error { return c.SetReadDeadline(time.Unix(1, 0)) })
+	}
+	return conn
+}
+
+// closerFunc implements io.Closer by calling itself
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
+
+// handshake reads the token line sent by the client and verifies it.
+//
+// The returned reader should be used for all future reads
+// because it might hold data sent after the token.
+func (ag *Agent) handshake(conn net.Conn) (*bufio.Reader, error) {
+	conn.SetReadDeadline(time.Now().Add(ListenHandshakeTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	br := bufio.NewReader(conn)
+	line, err := br.ReadSlice('\n')
+	if err != nil {
+		return nil, err
+	}
+	tok := bytes.TrimSpace(line)
+	if subtle.ConstantTimeCompare(tok, []byte(ag.listen.token)) != 1 {
+		return nil, fmt.Errorf("invalid token")
+	}
+	return br, nil
+}
@@ -0,0 +1,221 @@
+package mg
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/ugorji/go/codec"
+	"io"
+	"io/ioutil"
+	"margo.sh/mgutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listenTestAgent starts an agent listening on a random local port
+// and returns it along with its address and channel for the result of Run
+func listenTestAgent(t *testing.T, updaters ...func(*AgentConfig)) (*Agent, string, <-chan error) {
+	logR, logW := io.Pipe()
+	addrC := make(chan string, 1)
+	go func() {
+		scanner := bufio.NewScanner(logR)
+		for scanner.Scan() {
+			ln := scanner.Text()
+			i := strings.Index(ln, "ipc.listen: ")
+			if i < 0 {
+				continue
+			}
+			ln = ln[i+len("ipc.listen: "):]
+			ln = ln[strings.IndexByte(ln, ':')+1:]
+			addrC <- strings.Fields(ln)[0]
+		}
+	}()
+
+	cfg := AgentConfig{
+		Stdin:  &mgutil.IOWrapper{},
+		Stdout: &mgutil.IOWrapper{},
+		Stderr: logW,
+		Codec:  "msgpack",
+		Listen: "tcp:127.0.0.1:0",
+		Token:  "secret",
+	}
+	for _, f := range updaters {
+		f(&cfg)
+	}
+	ag, err := NewAgent(cfg)
+	if err != nil {
+		t.Fatalf("agent creation failed: %s", err)
+	}
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- ag.Run()
+		logW.Close()
+	}()
+	return ag, <-addrC, runErr
+}
+
+func TestAgentListen(t *testing.T) {
+	ag, addr, runErr := listenTestAgent(t)
+
+	bad, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("net.Dial(%s): %s", addr, err)
+	}
+	fmt.Fprintln(bad, "wrong")
+	if _, err := bad.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("invalid token: bad.Read() = (%v); want (%v)", err, io.EOF)
+	}
+	bad.Close()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("net.Dial(%s): %s", addr, err)
+	}
+	fmt.Fprintln(conn, "secret")
+	if err := codec.NewEncoder(conn, ag.handle).Encode(map[string]string{"Cookie": "test-cookie"}); err != nil {
+		t.Fatalf("enc.Encode(): %s", err)
+	}
+	dec := codec.NewDecoder(conn, ag.handle)
+	for {
+		var res struct{ Cookie string }
+		if err := dec.Decode(&res); err != nil {
+			t.Fatalf("dec.Decode(): %s", err)
+		}
+		if res.Cookie == "test-cookie" {
+			break
+		}
+	}
+	conn.Close()
+
+	if err := <-runErr; err != nil {
+		t.Fatalf("ag.Run() = (%#v); want (nil)", err)
+	}
+}
+
+func TestAgentListenSilentClient(t *testing.T) {
+	defer func(d time.Duration) { ListenHandshakeTimeout = d }(ListenHandshakeTimeout)
+	ListenHandshakeTimeout = time.Minute
+
+	ag, addr, runErr := listenTestAgent(t)
+
+	// a client that connects but never sends its token mustn't block the others
+	silent, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("net.Dial(%s): %s", addr, err)
+	}
+	defer silent.Close()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("net.Dial(%s): %s", addr, err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintln(conn, "secret")
+	if err := codec.NewEncoder(conn, ag.handle).Encode(map[string]string{"Cookie": "not-blocked"}); err != nil {
+		t.Fatalf("enc.Encode(): %s", err)
+	}
+	dec := codec.NewDecoder(conn, ag.handle)
+	for {
+		var res struct{ Cookie string }
+		if err := dec.Decode(&res); err != nil {
+			t.Fatalf("dec.Decode(): %s", err)
+		}
+		if res.Cookie == "not-blocked" {
+			break
+		}
+	}
+	conn.Close()
+
+	// the pending handshake is abandoned when the agent stops
+	if err := <-runErr; err != nil {
+		t.Fatalf("ag.Run() = (%#v); want (nil)", err)
+	}
+	silent.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := silent.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("silent.Read() = (%v); want (%v)", err, io.EOF)
+	}
+}
+
+func TestAgentReconnect(t *testing.T) {
+	ag, addr, runErr := listenTestAgent(t, func(cfg *AgentConfig) {
+		cfg.ReconnectTimeout = 200 * time.Millisecond
+	})
+
+	for _, cookie := range []string{"first", "second"} {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("net.Dial(%s): %s", addr, err)
+		}
+		fmt.Fprintln(conn, "secret")
+		if err := codec.NewEncoder(conn, ag.handle).Encode(map[string]string{"Cookie": cookie}); err != nil {
+			t.Fatalf("enc.Encode(): %s", err)
+		}
+		dec := codec.NewDecoder(conn, ag.handle)
+		for {
+			var res struct{ Cookie string }
+			if err := dec.Decode(&res); err != nil {
+				t.Fatalf("client %s: dec.Decode(): %s", cookie, err)
+			}
+			if res.Cookie == cookie {
+				break
+			}
+		}
+		conn.Close()
+
+		select {
+		case err := <-runErr:
+			t.Fatalf("ag.Run() = (%v) after client %s disconnected; want it to wait for a reconnect", err, cookie)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	select {
+	case err := <-runErr:
+		if err != nil {
+			t.Fatalf("ag.Run() = (%v); want (nil)", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("ag.Run() didn't return after the reconnect timeout")
+	}
+}
+
+func TestAgentListenUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "margo-listen-")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(): %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	sock := filepath.Join(dir, "agent.sock")
+	ag, addr, runErr := listenTestAgent(t, func(cfg *AgentConfig) {
+		cfg.Listen = "unix:" + sock
+	})
+	if addr != sock {
+		t.Fatalf("listen address = (%s); want (%s)", addr, sock)
+	}
+	conn, err := net.Dial("unix", addr)
+	if err != nil {
+		t.Fatalf("net.Dial(%s): %s", addr, err)
+	}
+	fmt.Fprintln(conn, "secret")
+	if err := codec.NewEncoder(conn, ag.handle).Encode(map[string]string{"Cookie": "unix"}); err != nil {
+		t.Fatalf("enc.Encode(): %s", err)
+	}
+	dec := codec.NewDecoder(conn, ag.handle)
+	for {
+		var res struct{ Cookie string }
+		if err := dec.Decode(&res); err != nil {
+			t.Fatalf("dec.Decode(): %s", err)
+		}
+		if res.Cookie == "unix" {
+			break
+		}
+	}
+	conn.Close()
+	if err := <-runErr; err != nil {
+		t.Fatalf("ag.Run() = (%v); want (nil)", err)
+	}
+}
@@ -0,0 +1,125 @@
+package mg
+
+import (
+	"io"
+	"margo.sh/mg/actions"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// featureLogMessage is the Hello feature through which a client opts in to LogMessage client actions
+	featureLogMessage = "LogMessage"
+
+	// logForwardQueue is the number of log records waiting to be forwarded before new records are dropped
+	logForwardQueue = 256
+)
+
+// LogMessage is the client action through which log records are forwarded
+// to clients that list the feature `LogMessage` in their Hello.
+//
+// Records are still written to AgentConfig.Stderr.
+type LogMessage struct {
+	ActionType
+
+	// Level is the level of the record: info or debug
+	Level string
+
+	// Source is the file and line that logged the record e.g. `agent.go:123`
+	Source string
+
+	Message string
+	Time    time.Time
+
+	// TraceID is the trace ID of the request during which the record was logged, if any
+	TraceID string
+}
+
+func (lm LogMessage) ClientAction() actions.ClientData {
+	return actions.ClientData{Name: "LogMessage", Data: lm}
+}
+
+// logForwarder forwards log records to the clients that opted in.
+//
+// Records are sent from a separate goroutine because logging may happen
+// while a client is locked, and so that failing to send a record doesn't log recursively.
+type logForwarder struct {
+	ag      *Agent
+	once    sync.Once
+	enabled int32
+	c       chan LogMessage
+}
+
+func newLogForwarder(ag *Agent) *logForwarder {
+	return &logForwarder{ag: ag, c: make(chan LogMessage, logForwardQueue)}
+}
+
+// enable starts forwarding records to client c
+func (lf *logForwarder) enable(c *agentClient) {
+	atomic.StoreInt32(&c.logs, 1)
+	atomic.StoreInt32(&lf.enabled, 1)
+	lf.once.Do(func() { go lf.run() })
+}
+
+// writer returns a writer that writes to w and forwards each record with the specified level.
+// prefix is the logger's prefix, which is removed from forwarded records.
+func (lf *logForwarder) writer(w io.Writer, level, prefix string) io.Writer {
+	return &logWriter{lf: lf, w: w, level: level, prefix: prefix}
+}
+
+func (lf *logForwarder) forward(lm LogMessage) {
+	select {
+	case lf.c <- lm:
+	default:
+	}
+}
+
+func (lf *logForwarder) run() {
+	for {
+		var lm LogMessage
+		select {
+		case lm = <-lf.c:
+		case <-lf.ag.Done:
+			return
+		}
+		res := agentRes{Notifications: []actions.ClientData{lm.ClientAction()}}
+		for _, c := range lf.ag.clients.list() {
+			if atomic.LoadInt32(&c.logs) == 0 {
+				continue
+			}
+			if err := c.send(res); err != nil {
+				atomic.StoreInt32(&c.logs, 0)
+			}
+		}
+	}
+}
+
+// logWriter is the output of a log.Logger whose records are forwarded
+type logWriter struct {
+	lf     *logForwarder
+	w      io.Writer
+	level  string
+	prefix string
+}
+
+func (lw *logWriter) Write(p []byte) (int, error) {
+	n, err := lw.w.Write(p)
+	if atomic.LoadInt32(&lw.lf.enabled) != 0 {
+		lw.lf.forward(lw.record(string(p)))
+	}
+	return n, err
+}
+
+// record parses the log line s, in the form `[trace:$id ]$prefix$file:$line: $message`
+func (lw *logWriter) record(s string) LogMessage {
+	lm := LogMessage{Level: lw.level, Time: time.Now()}
+	lm.TraceID, s = splitTrace(s)
+	s = strings.TrimSuffix(strings.TrimPrefix(s, lw.prefix), "\n")
+	if i := strings.Index(s, ": "); i > 0 && strings.Contains(s[:i], ".go:") {
+		lm.Source, s = s[:i], s[i+2:]
+	}
+	lm.Message = s
+	return lm
+}
@@ -0,0 +1,48 @@
+package mg
+
+import (
+	"github.com/ugorji/go/codec"
+	"io"
+	"io/ioutil"
+	"margo.sh/mgutil"
+	"strings"
+	"testing"
+)
+
+func TestAgentLogMessage(t *testing.T) {
+	outR, outW := io.Pipe()
+	ag, err := NewAgent(AgentConfig{
+		Stdin:  &mgutil.IOWrapper{},
+		Stdout: outW,
+		Stderr: &mgutil.IOWrapper{},
+		Codec:  "msgpack",
+	})
+	if err != nil {
+		t.Fatalf("agent creation failed: %s", err)
+	}
+
+	rq := newAgentReq(ag.Store)
+	rq.Hello = &Hello{Name: "test", Features: []string{featureLogMessage}}
+	if err := ag.client.greet(rq); err != nil {
+		t.Fatalf("c.greet() = (%v); want (nil)", err)
+	}
+	ag.Log.Dbg.Println("forward me")
+
+	var res struct {
+		Notifications []struct {
+			Name string
+			Data LogMessage
+		}
+	}
+	if err := codec.NewDecoder(outR, ag.handle).Decode(&res); err != nil {
+		t.Fatalf("dec.Decode(): %s", err)
+	}
+	if len(res.Notifications) != 1 {
+		t.Fatalf("len(res.Notifications) = (%d); want (1)", len(res.Notifications))
+	}
+	n := res.Notifications[0]
+	if n.Name != "LogMessage" || n.Data.Level != "debug" || n.Data.Message != "forward me" || !strings.HasPrefix(n.Data.Source, "logfwd_test.go:") {
+		t.Errorf("notification = (%+v); want a debug LogMessage from logfwd_test.go", n)
+	}
+	go io.Copy(ioutil.Discard, outR)
+}
@@ -0,0 +1,152 @@
+package lsp
+
+import (
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// document is a text document opened by the client
+type document struct {
+	URI   string
+	Path  string
+	Lang  string
+	Text  string
+	Dirty bool
+}
+
+// offset returns the character (rune) offset of pos in the document.
+//
+// LSP positions count UTF-16 code units, margo positions count characters.
+func (d *document) offset(pos Position) int {
+	n := 0
+	s := d.Text
+	for line := 0; line < pos.Line; line++ {
+		i := strings.IndexByte(s, '\n')
+		if i < 0 {
+			return n + utf8.RuneCountInString(s)
+		}
+		n += utf8.RuneCountInString(s[:i+1])
+		s = s[i+1:]
+	}
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+	units := 0
+	for _, r := range s {
+		if units >= pos.Character {
+			break
+		}
+		units += utf16.RuneLen(r)
+		n++
+	}
+	return n
+}
+
+// position returns the LSP position of the character column col of row
+func (d *document) position(row, col int) Position {
+	return ColPosition(d.Text, row, col)
+}
+
+// ColPosition returns the LSP position of the character (rune) column col of row, in text
+func ColPosition(text string, row, col int) Position {
+	lines := strings.SplitN(text, "\n", row+2)
+	if row < 0 || row >= len(lines) {
+		return Position{Line: row, Character: col}
+	}
+	units := 0
+	for _, r := range lines[row] {
+		if col <= 0 {
+			break
+		}
+		units += utf16.RuneLen(r)
+		col--
+	}
+	return Position{Line: row, Character: units}
+}
+
+// end returns the position at the end of the document
+func (d *document) end() Position {
+	row := strings.Count(d.Text, "\n")
+	s := d.Text[strings.LastIndexByte(d.Text, '\n')+1:]
+	return Position{Line: row, Character: len(utf16.Encode([]rune(s)))}
+}
+
+// docStore holds the list of open documents
+type docStore struct {
+	mu   sync.Mutex
+	docs map[string]*document
+}
+
+func (ds *docStore) get(uri string) *document {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if d := ds.docs[uri]; d != nil {
+		x := *d
+		return &x
+	}
+	return nil
+}
+
+func (ds *docStore) put(d *document) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	if ds.docs == nil {
+		ds.docs = map[string]*document{}
+	}
+	ds.docs[d.URI] = d
+}
+
+func (ds *docStore) update(uri string, f func(d *document)) *document {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	d := ds.docs[uri]
+	if d == nil {
+		return nil
+	}
+	f(d)
+	x := *d
+	return &x
+}
+
+func (ds *docStore) del(uri string) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	delete(ds.docs, uri)
+}
+
+// byPath returns the open document with file name fn
+func (ds *docStore) byPath(fn string) *document {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	for _, d := range ds.docs {
+		if d.Path == fn {
+			x := *d
+			return &x
+		}
+	}
+	return nil
+}
+
+// URIPath returns the file name of a file:// uri, or an empty string if it's not a file:// uri
+func URIPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+// PathURI returns the file:// uri of file name fn
+func PathURI(fn string) string {
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(fn)}
+	return u.String()
+}
@@ -0,0 +1,191 @@
+// Package jsonrpc implements JSON-RPC 2.0 messages framed with a Content-Length header,
+// as used by the Language Server Protocol.
+//
+// It's shared by the agent's jsonrpc protocol, the LSP server in margo.sh/mg/lsp
+// and the gopls client in margo.sh/golang.
+package jsonrpc // import "margo.sh/mg/lsp/jsonrpc"
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInternalError  = -32603
+)
+
+// Message is a JSON-RPC 2.0 request, notification or response
+type Message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  json.RawMessage  `json:"result,omitempty"`
+	Error   *Error           `json:"error,omitempty"`
+}
+
+// IsRequest returns true if the message expects a response
+func (m *Message) IsRequest() bool {
+	return m.ID != nil && m.Method != ""
+}
+
+// Error is the error of a response
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("jsonrpc error %d: %s", e.Code, e.Message)
+}
+
+// FrameSizeError is returned by ReadFrame when the body of a message exceeds its limit
+type FrameSizeError struct {
+	Size int
+	Max  int
+}
+
+func (e *FrameSizeError) Error() string {
+	return fmt.Sprintf("message too large: %d bytes exceeds the limit of %d bytes", e.Size, e.Max)
+}
+
+// ReadFrame reads the body of the next message from r.
+//
+// If max is greater than zero and the body is larger than max bytes,
+// the body is discarded and a *FrameSizeError is returned, so the next message can still be read.
+// io.EOF is returned if r ends before the next message starts.
+func ReadFrame(r *bufio.Reader, max int) ([]byte, error) {
+	hdr, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		if err == io.ErrUnexpectedEOF && len(hdr) == 0 {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(hdr.Get("Content-Length")))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid Content-Length header: %q", hdr.Get("Content-Length"))
+	}
+	if max > 0 && n > max {
+		if _, err := io.CopyN(ioutil.Discard, r, int64(n)); err != nil {
+			return nil, err
+		}
+		return nil, &FrameSizeError{Size: n, Max: max}
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// WriteFrame writes body to w, preceded by its Content-Length header
+func WriteFrame(w io.Writer, body []byte) error {
+	if _, err := fmt.Fprintf(w, "Content-Length: %d\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err := w.Write(body)
+	return err
+}
+
+// Conn reads and writes messages.
+//
+// Writes are safe for concurrent use, reads must be done by a single goroutine.
+type Conn struct {
+	mu sync.Mutex
+	r  *bufio.Reader
+	w  io.Writer
+}
+
+// NewConn returns a Conn that reads messages from r and writes them to w
+func NewConn(r io.Reader, w io.Writer) *Conn {
+	return &Conn{r: bufio.NewReader(r), w: w}
+}
+
+// Read reads the next message.
+//
+// If the message can't be decoded, a *Error with code CodeParseError is returned.
+func (c *Conn) Read() (*Message, error) {
+	body, err := ReadFrame(c.r, 0)
+	if err != nil {
+		return nil, err
+	}
+	msg := &Message{}
+	if err := json.Unmarshal(body, msg); err != nil {
+		return nil, &Error{Code: CodeParseError, Message: err.Error()}
+	}
+	return msg, nil
+}
+
+// Write writes msg, setting its jsonrpc version
+func (c *Conn) Write(msg *Message) error {
+	msg.JSONRPC = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return WriteFrame(c.w, body)
+}
+
+// Reply sends the response to the request req.
+//
+// If err is not a *Error, it's sent with the code CodeInternalError.
+func (c *Conn) Reply(req *Message, result interface{}, err error) error {
+	res, err := NewResponse(req, result, err)
+	if err != nil {
+		return err
+	}
+	return c.Write(res)
+}
+
+// Notify sends the notification method with params
+func (c *Conn) Notify(method string, params interface{}) error {
+	msg, err := NewMessage(nil, method, params)
+	if err != nil {
+		return err
+	}
+	return c.Write(msg)
+}
+
+// NewMessage returns a request with the given id, or a notification if id is nil
+func NewMessage(id *json.RawMessage, method string, params interface{}) (*Message, error) {
+	p, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{ID: id, Method: method, Params: p}, nil
+}
+
+// NewResponse returns the response to the request req, with either result or err.
+//
+// If err is not a *Error, it's sent with the code CodeInternalError.
+func NewResponse(req *Message, result interface{}, err error) (*Message, error) {
+	res := &Message{ID: req.ID}
+	switch e := err.(type) {
+	case nil:
+		p, err := json.Marshal(result)
+		if err != nil {
+			return nil, err
+		}
+		res.Result = p
+	case *Error:
+		res.Error = e
+	default:
+		res.Error = &Error{Code: CodeInternalError, Message: e.Error()}
+	}
+	return res, nil
+}
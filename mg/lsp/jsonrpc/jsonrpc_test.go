@@ -0,0 +1,68 @@
+package jsonrpc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadFrame(t *testing.T) {
+	buf := &bytes.Buffer{}
+	for _, s := range []string{`{"id":1}`, `{"id":"too large"}`, `{"id":3}`} {
+		if err := WriteFrame(buf, []byte(s)); err != nil {
+			t.Fatalf("WriteFrame(%s): %s", s, err)
+		}
+	}
+
+	r := bufio.NewReader(buf)
+	if body, err := ReadFrame(r, 10); err != nil || string(body) != `{"id":1}` {
+		t.Errorf("ReadFrame() = (%s, %v); want (%s, nil)", body, err, `{"id":1}`)
+	}
+	if _, err := ReadFrame(r, 10); err == nil {
+		t.Errorf("ReadFrame() of a message larger than the limit: want a *FrameSizeError")
+	} else if e, ok := err.(*FrameSizeError); !ok || e.Size != 18 || e.Max != 10 {
+		t.Errorf("ReadFrame() = (%#v); want (&FrameSizeError{Size: 18, Max: 10})", err)
+	}
+	if body, err := ReadFrame(r, 10); err != nil || string(body) != `{"id":3}` {
+		t.Errorf("ReadFrame() after a large message = (%s, %v); want (%s, nil)", body, err, `{"id":3}`)
+	}
+	if _, err := ReadFrame(r, 10); err != io.EOF {
+		t.Errorf("ReadFrame() at the end = (%v); want (io.EOF)", err)
+	}
+
+	r = bufio.NewReader(strings.NewReader("Content-Length: x\r\n\r\n{}"))
+	if _, err := ReadFrame(r, 0); err == nil {
+		t.Errorf("ReadFrame() with an invalid Content-Length: want an error")
+	}
+}
+
+func TestConn(t *testing.T) {
+	buf := &bytes.Buffer{}
+	c := NewConn(buf, buf)
+	id := json.RawMessage("7")
+	req := &Message{ID: &id, Method: "ping"}
+	if err := c.Reply(req, []int{1}, nil); err != nil {
+		t.Fatalf("Reply(): %s", err)
+	}
+	if err := c.Reply(req, nil, io.EOF); err != nil {
+		t.Fatalf("Reply(): %s", err)
+	}
+	buf.WriteString("Content-Length: 1\r\n\r\n{")
+
+	msg, err := c.Read()
+	if err != nil || string(*msg.ID) != "7" || string(msg.Result) != "[1]" || msg.JSONRPC != "2.0" {
+		t.Errorf("Read() = (%+v, %v); want the result [1] to request 7", msg, err)
+	}
+	msg, err = c.Read()
+	if err != nil || msg.Error == nil || msg.Error.Code != CodeInternalError {
+		t.Errorf("Read() = (%+v, %v); want an error with code %d", msg, err, CodeInternalError)
+	}
+	if _, err := c.Read(); err == nil {
+		t.Errorf("Read() of invalid json: want an error")
+	} else if e, ok := err.(*Error); !ok || e.Code != CodeParseError {
+		t.Errorf("Read() of invalid json = (%v); want an error with code %d", err, CodeParseError)
+	}
+}
@@ -0,0 +1,520 @@
+// Package lsp implements a Language Server Protocol server backed by a margo agent.
+//
+// The server runs a normal mg.Agent in-process, with the same Store and reducers,
+// and translates between LSP messages and agent requests:
+//
+// * textDocument/didOpen, didChange and didSave dispatch ViewActivated, ViewModified and ViewSaved
+// * issues in agent responses are sent as textDocument/publishDiagnostics
+// * textDocument/completion dispatches QueryCompletions
+// * textDocument/hover dispatches QueryTooltips
+// * textDocument/formatting dispatches ViewFmt
+//
+// The protocol types it uses are exported for use by margo's clients of other language servers e.g. golang.Gopls,
+// and the framing of messages is implemented by the package margo.sh/mg/lsp/jsonrpc.
+package lsp // import "margo.sh/mg/lsp"
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ugorji/go/codec"
+	"io"
+	"margo.sh/mg"
+	"margo.sh/mg/lsp/jsonrpc"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Serve runs an LSP server that reads requests from in and writes responses to out.
+//
+// A new agent is created using cfg, its Stdin, Stdout and Codec are replaced
+// by in-process pipes. If setup is not nil, it's called with the agent before it starts
+// and can be used to configure its Store e.g. to add reducers.
+//
+// Serve returns when the client sends the exit notification, in is closed, or the agent stops.
+func Serve(in io.Reader, out io.Writer, cfg mg.AgentConfig, setup func(*mg.Agent)) error {
+	agIn, toAg := io.Pipe()
+	fromAg, agOut := io.Pipe()
+	cfg.Stdin = agIn
+	cfg.Stdout = agOut
+	cfg.Codec = "msgpack"
+	ag, err := mg.NewAgent(cfg)
+	if err != nil {
+		return err
+	}
+	if setup != nil {
+		setup(ag)
+	}
+
+	srv := &server{
+		lsp:     jsonrpc.NewConn(in, out),
+		log:     ag.Log,
+		handle:  &codec.MsgpackHandle{},
+		pending: map[string]chan *agentRes{},
+		pubs:    map[string]string{},
+	}
+	srv.enc = codec.NewEncoder(toAg, srv.handle)
+
+	agErr := make(chan error, 1)
+	go func() { agErr <- ag.Run() }()
+	go srv.readResponses(fromAg)
+
+	err = srv.serve()
+	toAg.Close()
+	if e := <-agErr; err == nil {
+		err = e
+	}
+	return err
+}
+
+// agentRes is the subset of the agent's response used by the server
+type agentRes struct {
+	Cookie  string
+	Error   string
+	Partial bool
+	State   struct {
+		View *struct {
+			Name string
+			Src  []byte
+		}
+		Issues      []mg.Issue
+		Completions []mg.Completion
+		Tooltips    []mg.Tooltip
+	}
+}
+
+type agentAction struct {
+	Name string
+	Data interface{}
+}
+
+type agentView struct {
+	Path  string
+	Wd    string
+	Name  string
+	Src   []byte
+	Pos   int
+	Dirty bool
+	Lang  mg.Lang
+}
+
+type server struct {
+	lsp    *jsonrpc.Conn
+	log    *mg.Logger
+	handle codec.Handle
+	docs   docStore
+	client string
+
+	mu      sync.Mutex
+	enc     *codec.Encoder
+	cookie  int
+	pending map[string]chan *agentRes
+	active  string
+	pubs    map[string]string
+}
+
+func (s *server) serve() error {
+	for {
+		msg, err := s.lsp.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if e, ok := err.(*jsonrpc.Error); ok {
+			s.lsp.Write(&jsonrpc.Message{Error: e})
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		if msg.IsRequest() {
+			go s.handleRequest(msg)
+		} else {
+			s.handleNotification(msg)
+		}
+	}
+}
+
+func (s *server) handleRequest(msg *jsonrpc.Message) {
+	var res interface{}
+	var err error
+	switch msg.Method {
+	case "initialize":
+		res, err = s.initialize(msg)
+	case "shutdown":
+	case "textDocument/completion":
+		res, err = s.completion(msg)
+	case "textDocument/hover":
+		res, err = s.hover(msg)
+	case "textDocument/formatting":
+		res, err = s.formatting(msg)
+	default:
+		err = &jsonrpc.Error{Code: jsonrpc.CodeMethodNotFound, Message: "method not found: " + msg.Method}
+	}
+	if e := s.lsp.Reply(msg, res, err); e != nil {
+		s.log.Println("lsp: cannot send response:", e)
+	}
+}
+
+func (s *server) handleNotification(msg *jsonrpc.Message) {
+	var err error
+	switch msg.Method {
+	case "textDocument/didOpen":
+		p := DidOpenTextDocumentParams{}
+		if err = json.Unmarshal(msg.Params, &p); err == nil {
+			td := p.TextDocument
+			d := &document{URI: td.URI, Path: URIPath(td.URI), Lang: td.LanguageID, Text: td.Text}
+			s.docs.put(d)
+			err = s.dispatch(d, 0, mg.ViewActivated{})
+		}
+	case "textDocument/didChange":
+		p := DidChangeTextDocumentParams{}
+		if err = json.Unmarshal(msg.Params, &p); err == nil && len(p.ContentChanges) != 0 {
+			text := p.ContentChanges[len(p.ContentChanges)-1].Text
+			d := s.docs.update(p.TextDocument.URI, func(d *document) {
+				d.Text = text
+				d.Dirty = true
+			})
+			if d != nil {
+				err = s.dispatch(d, 0, mg.ViewModified{})
+			}
+		}
+	case "textDocument/didSave":
+		p := DidSaveTextDocumentParams{}
+		if err = json.Unmarshal(msg.Params, &p); err == nil {
+			d := s.docs.update(p.TextDocument.URI, func(d *document) {
+				if p.Text != nil {
+					d.Text = *p.Text
+				}
+				d.Dirty = false
+			})
+			if d != nil {
+				err = s.dispatch(d, 0, mg.ViewSaved{})
+			}
+		}
+	case "textDocument/didClose":
+		p := DidCloseTextDocumentParams{}
+		if err = json.Unmarshal(msg.Params, &p); err == nil {
+			s.docs.del(p.TextDocument.URI)
+			s.publish(p.TextDocument.URI, nil)
+		}
+	}
+	if err != nil {
+		s.log.Printf("lsp: %s: %s\n", msg.Method, err)
+	}
+}
+
+func (s *server) initialize(msg *jsonrpc.Message) (interface{}, error) {
+	p := initializeParams{}
+	if err := json.Unmarshal(msg.Params, &p); err != nil {
+		return nil, err
+	}
+	if ci := p.ClientInfo; ci != nil {
+		s.client = ci.Name
+	}
+	res := initializeResult{ServerInfo: serverInfo{Name: "margo"}}
+	res.Capabilities.TextDocumentSync = textDocumentSyncOptions{
+		OpenClose: true,
+		Change:    SyncFull,
+		Save:      true,
+	}
+	res.Capabilities.HoverProvider = true
+	res.Capabilities.DocumentFormattingProvider = true
+	return res, nil
+}
+
+func (s *server) positionDoc(msg *jsonrpc.Message) (*document, Position, error) {
+	p := TextDocumentPositionParams{}
+	if err := json.Unmarshal(msg.Params, &p); err != nil {
+		return nil, Position{}, err
+	}
+	d := s.docs.get(p.TextDocument.URI)
+	if d == nil {
+		return nil, Position{}, fmt.Errorf("unknown document: %s", p.TextDocument.URI)
+	}
+	return d, p.Position, nil
+}
+
+func (s *server) completion(msg *jsonrpc.Message) (interface{}, error) {
+	d, pos, err := s.positionDoc(msg)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.query(d, d.offset(pos), mg.QueryCompletions{})
+	if err != nil {
+		return nil, err
+	}
+	cl := CompletionList{Items: make([]CompletionItem, 0, len(res.State.Completions))}
+	for _, c := range res.State.Completions {
+		cl.Items = append(cl.Items, CompletionItem{
+			Label:            c.Query,
+			Kind:             completionKind(c.Tag),
+			Detail:           c.Title,
+			InsertText:       c.Src,
+			FilterText:       c.Query,
+			InsertTextFormat: InsertTextFormatSnippet,
+		})
+	}
+	return cl, nil
+}
+
+func (s *server) hover(msg *jsonrpc.Message) (interface{}, error) {
+	d, pos, err := s.positionDoc(msg)
+	if err != nil {
+		return nil, err
+	}
+	off := d.offset(pos)
+	col := off - d.offset(Position{Line: pos.Line})
+	res, err := s.query(d, off, mg.QueryTooltips{Row: pos.Line, Col: col})
+	if err != nil {
+		return nil, err
+	}
+	l := make([]string, 0, len(res.State.Tooltips))
+	for _, t := range res.State.Tooltips {
+		l = append(l, t.Content)
+	}
+	if len(l) == 0 {
+		return nil, nil
+	}
+	return Hover{Contents: MarkupContent{
+		Kind:  MarkupKindMarkdown,
+		Value: strings.Join(l, "\n\n---\n\n"),
+	}}, nil
+}
+
+func (s *server) formatting(msg *jsonrpc.Message) (interface{}, error) {
+	p := DocumentFormattingParams{}
+	if err := json.Unmarshal(msg.Params, &p); err != nil {
+		return nil, err
+	}
+	d := s.docs.get(p.TextDocument.URI)
+	if d == nil {
+		return nil, fmt.Errorf("unknown document: %s", p.TextDocument.URI)
+	}
+	res, err := s.query(d, 0, mg.ViewFmt{})
+	if err != nil {
+		return nil, err
+	}
+	v := res.State.View
+	if v == nil || string(v.Src) == d.Text {
+		return []TextEdit{}, nil
+	}
+	return []TextEdit{{
+		Range:   Range{End: d.end()},
+		NewText: string(v.Src),
+	}}, nil
+}
+
+// dispatch sends act to the agent for document d with the cursor at pos
+func (s *server) dispatch(d *document, pos int, act mg.Action) error {
+	_, err := s.send(d, pos, act, nil)
+	return err
+}
+
+// query sends act to the agent for document d and waits for the final response
+func (s *server) query(d *document, pos int, act mg.Action) (*agentRes, error) {
+	c := make(chan *agentRes, 1)
+	if _, err := s.send(d, pos, act, c); err != nil {
+		return nil, err
+	}
+	res, ok := <-c
+	if !ok {
+		return nil, fmt.Errorf("the agent stopped before responding")
+	}
+	if res.Error != "" {
+		return res, fmt.Errorf("%s", res.Error)
+	}
+	return res, nil
+}
+
+func (s *server) send(d *document, pos int, act mg.Action, c chan *agentRes) (string, error) {
+	name := strings.TrimPrefix(fmt.Sprintf("%T", act), "mg.")
+	rq := struct {
+		Cookie  string
+		Actions []agentAction
+		Props   struct {
+			Editor struct {
+				Name   string
+				Client mg.EditorClientProps
+			}
+			Env  map[string]string
+			View agentView
+		}
+	}{
+		Actions: []agentAction{{Name: name, Data: act}},
+	}
+	rq.Props.Editor.Name = "lsp"
+	rq.Props.Editor.Client.Name = s.client
+	rq.Props.Env = environ()
+	rq.Props.View = agentView{
+		Path:  d.Path,
+		Wd:    filepath.Dir(d.Path),
+		Name:  filepath.Base(d.Path),
+		Src:   []byte(d.Text),
+		Pos:   pos,
+		Dirty: d.Dirty,
+		Lang:  mg.Lang(d.Lang),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cookie++
+	rq.Cookie = "lsp-" + strconv.Itoa(s.cookie)
+	s.active = d.URI
+	if c != nil {
+		s.pending[rq.Cookie] = c
+	}
+	if err := s.enc.Encode(rq); err != nil {
+		delete(s.pending, rq.Cookie)
+		return "", err
+	}
+	return rq.Cookie, nil
+}
+
+func (s *server) readResponses(r io.Reader) {
+	dec := codec.NewDecoder(r, s.handle)
+	for {
+		res := &agentRes{}
+		if err := dec.Decode(res); err != nil {
+			if err != io.EOF && err != io.ErrClosedPipe {
+				s.log.Println("lsp: cannot decode agent response:", err)
+			}
+			break
+		}
+		s.handleResponse(res)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, c := range s.pending {
+		close(c)
+		delete(s.pending, k)
+	}
+}
+
+func (s *server) handleResponse(res *agentRes) {
+	s.mu.Lock()
+	c := s.pending[res.Cookie]
+	if c != nil && !res.Partial {
+		delete(s.pending, res.Cookie)
+	}
+	active := s.active
+	s.mu.Unlock()
+
+	s.publishIssues(active, res.State.Issues)
+	if c != nil && !res.Partial {
+		c <- res
+	}
+}
+
+// publishIssues sends diagnostics for all open documents based on issues.
+// Issues without a path belong to the active document.
+func (s *server) publishIssues(active string, issues []mg.Issue) {
+	byURI := map[string][]mg.Issue{}
+	for _, isu := range issues {
+		uri := active
+		if isu.Path != "" {
+			d := s.docs.byPath(isu.Path)
+			if d == nil {
+				continue
+			}
+			uri = d.URI
+		}
+		byURI[uri] = append(byURI[uri], isu)
+	}
+
+	s.mu.Lock()
+	for uri := range s.pubs {
+		if _, ok := byURI[uri]; !ok {
+			byURI[uri] = nil
+		}
+	}
+	s.mu.Unlock()
+
+	for uri, l := range byURI {
+		s.publish(uri, l)
+	}
+}
+
+// publish sends the diagnostics for uri if they changed since they were last sent
+func (s *server) publish(uri string, issues []mg.Issue) {
+	d := s.docs.get(uri)
+	if d == nil {
+		d = &document{URI: uri}
+	}
+	diags := make([]Diagnostic, 0, len(issues))
+	for _, isu := range issues {
+		diags = append(diags, Diagnostic{
+			Range: Range{
+				Start: d.position(isu.Row, isu.Col),
+				End:   d.position(isu.Row, isu.Col),
+			},
+			Severity: diagnosticSeverity(isu.Tag),
+			Source:   isu.Label,
+			Message:  isu.Message,
+		})
+	}
+	p := PublishDiagnosticsParams{URI: uri, Diagnostics: diags}
+	k, _ := json.Marshal(p)
+
+	s.mu.Lock()
+	if s.pubs[uri] == string(k) {
+		s.mu.Unlock()
+		return
+	}
+	if len(diags) == 0 {
+		delete(s.pubs, uri)
+	} else {
+		s.pubs[uri] = string(k)
+	}
+	s.mu.Unlock()
+
+	if err := s.lsp.Notify("textDocument/publishDiagnostics", p); err != nil {
+		s.log.Println("lsp: cannot publish diagnostics:", err)
+	}
+}
+
+func diagnosticSeverity(tag mg.IssueTag) int {
+	switch tag {
+	case mg.Warning:
+		return SeverityWarning
+	case mg.Notice:
+		return SeverityInformation
+	default:
+		return SeverityError
+	}
+}
+
+func completionKind(tag mg.CompletionTag) int {
+	switch tag {
+	case mg.SnippetTag:
+		return CompletionKindSnippet
+	case mg.VariableTag:
+		return CompletionKindVariable
+	case mg.TypeTag:
+		return CompletionKindClass
+	case mg.ConstantTag:
+		return CompletionKindConstant
+	case mg.FunctionTag:
+		return CompletionKindFunction
+	case mg.PackageTag:
+		return CompletionKindModule
+	default:
+		return CompletionKindText
+	}
+}
+
+func environ() map[string]string {
+	m := map[string]string{}
+	for _, s := range os.Environ() {
+		if i := strings.IndexByte(s, '='); i > 0 {
+			m[s[:i]] = s[i+1:]
+		}
+	}
+	return m
+}
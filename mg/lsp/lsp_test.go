@@ -0,0 +1,113 @@
+package lsp
+
+import (
+	"encoding/json"
+	"io"
+	"margo.sh/mg"
+	"margo.sh/mg/lsp/jsonrpc"
+	"margo.sh/mgutil"
+	"testing"
+)
+
+func TestDocumentPositions(t *testing.T) {
+	d := &document{Text: "package main\n\nvar s = \"😀x\"\n"}
+	cases := []struct {
+		pos    Position
+		offset int
+	}{
+		{Position{Line: 0, Character: 0}, 0},
+		{Position{Line: 0, Character: 7}, 7},
+		{Position{Line: 2, Character: 0}, 14},
+		// the emoji is 2 UTF-16 code units, but 1 character
+		{Position{Line: 2, Character: 11}, 24},
+		{Position{Line: 9, Character: 0}, 27},
+	}
+	for _, c := range cases {
+		if got := d.offset(c.pos); got != c.offset {
+			t.Errorf("offset(%+v) = (%d); want (%d)", c.pos, got, c.offset)
+		}
+	}
+	if got, want := d.position(2, 10), (Position{Line: 2, Character: 11}); got != want {
+		t.Errorf("position(2, 10) = (%+v); want (%+v)", got, want)
+	}
+}
+
+func TestServe(t *testing.T) {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	cfg := mg.AgentConfig{Stderr: &mgutil.IOWrapper{}}
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve(inR, outW, cfg, func(ag *mg.Agent) {
+			ag.Store.Use(mg.NewReducer(func(mx *mg.Ctx) *mg.State {
+				st := mx.State
+				if mx.ActionIs(mg.QueryCompletions{}) {
+					st = st.AddCompletions(mg.Completion{Query: "Println", Src: "Println($1)"})
+				}
+				return st.AddIssues(mg.Issue{Path: mx.View.Path, Row: 1, Message: "test issue"})
+			}))
+		})
+	}()
+
+	client := jsonrpc.NewConn(outR, inW)
+	call := func(id int, method string, params interface{}) {
+		p, _ := json.Marshal(params)
+		rid := json.RawMessage(jsonID(id))
+		if err := client.Write(&jsonrpc.Message{ID: &rid, Method: method, Params: p}); err != nil {
+			t.Fatalf("client.Write(%s): %s", method, err)
+		}
+	}
+	// wait returns the response with the given id, or the first notification named method
+	wait := func(id int, method string) *jsonrpc.Message {
+		for {
+			msg, err := client.Read()
+			if err != nil {
+				t.Fatalf("client.Read(): %s", err)
+			}
+			if method != "" && msg.Method == method {
+				return msg
+			}
+			if msg.ID != nil && string(*msg.ID) == jsonID(id) {
+				return msg
+			}
+		}
+	}
+
+	call(1, "initialize", struct{}{})
+	if msg := wait(1, ""); msg.Error != nil {
+		t.Fatalf("initialize: %s", msg.Error)
+	}
+
+	uri := PathURI("/tmp/lsp/main.go")
+	client.Notify("textDocument/didOpen", DidOpenTextDocumentParams{TextDocument: TextDocumentItem{
+		URI:        uri,
+		LanguageID: "go",
+		Text:       "package main\n\nfunc main() {}\n",
+	}})
+	pub := PublishDiagnosticsParams{}
+	json.Unmarshal(wait(0, "textDocument/publishDiagnostics").Params, &pub)
+	if pub.URI != uri || len(pub.Diagnostics) != 1 || pub.Diagnostics[0].Message != "test issue" {
+		t.Errorf("publishDiagnostics = (%+v); want 1 diagnostic for %s", pub, uri)
+	}
+
+	call(2, "textDocument/completion", TextDocumentPositionParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Position:     Position{Line: 2, Character: 13},
+	})
+	msg := wait(2, "")
+	cl := CompletionList{}
+	json.Unmarshal(msg.Result, &cl)
+	if len(cl.Items) != 1 || cl.Items[0].Label != "Println" {
+		t.Errorf("completion = (%s); want a single Println item", msg.Result)
+	}
+
+	client.Notify("exit", nil)
+	if err := <-served; err != nil {
+		t.Errorf("Serve() = (%v); want (nil)", err)
+	}
+}
+
+func jsonID(id int) string {
+	b, _ := json.Marshal(id)
+	return string(b)
+}
@@ -0,0 +1,240 @@
+package lsp
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// The types in this file are the subset of the Language Server Protocol used by the Server,
+// and by margo's clients of other language servers e.g. golang.Gopls.
+// See https://microsoft.github.io/language-server-protocol/specification
+
+const (
+	SeverityError       = 1
+	SeverityWarning     = 2
+	SeverityInformation = 3
+
+	SyncFull = 1
+
+	CompletionKindText     = 1
+	CompletionKindFunction = 3
+	CompletionKindVariable = 6
+	CompletionKindClass    = 7
+	CompletionKindModule   = 9
+	CompletionKindSnippet  = 15
+	CompletionKindConstant = 21
+
+	InsertTextFormatSnippet = 2
+
+	MarkupKindMarkdown = "markdown"
+)
+
+type Position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+type Location struct {
+	URI   string `json:"uri"`
+	Range Range  `json:"range"`
+}
+
+// Locations is the result of textDocument/definition, which may be a Location or a list of them
+type Locations []Location
+
+func (l *Locations) UnmarshalJSON(p []byte) error {
+	if len(p) != 0 && p[0] == '[' {
+		return json.Unmarshal(p, (*[]Location)(l))
+	}
+	if string(p) == "null" {
+		return nil
+	}
+	loc := Location{}
+	if err := json.Unmarshal(p, &loc); err != nil {
+		return err
+	}
+	*l = Locations{loc}
+	return nil
+}
+
+type TextDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+type VersionedTextDocumentIdentifier struct {
+	URI     string `json:"uri"`
+	Version int    `json:"version"`
+}
+
+type TextDocumentItem struct {
+	URI        string `json:"uri"`
+	LanguageID string `json:"languageId"`
+	Version    int    `json:"version"`
+	Text       string `json:"text"`
+}
+
+type TextDocumentPositionParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Position     Position               `json:"position"`
+}
+
+type WorkspaceFolder struct {
+	URI  string `json:"uri"`
+	Name string `json:"name"`
+}
+
+type initializeParams struct {
+	ClientInfo *struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	} `json:"clientInfo"`
+}
+
+type initializeResult struct {
+	Capabilities serverCapabilities `json:"capabilities"`
+	ServerInfo   serverInfo         `json:"serverInfo"`
+}
+
+type serverInfo struct {
+	Name string `json:"name"`
+}
+
+type serverCapabilities struct {
+	TextDocumentSync           textDocumentSyncOptions `json:"textDocumentSync"`
+	CompletionProvider         struct{}                `json:"completionProvider"`
+	HoverProvider              bool                    `json:"hoverProvider"`
+	DocumentFormattingProvider bool                    `json:"documentFormattingProvider"`
+}
+
+type textDocumentSyncOptions struct {
+	OpenClose bool `json:"openClose"`
+	Change    int  `json:"change"`
+	Save      bool `json:"save"`
+}
+
+type DidOpenTextDocumentParams struct {
+	TextDocument TextDocumentItem `json:"textDocument"`
+}
+
+type DidChangeTextDocumentParams struct {
+	TextDocument   VersionedTextDocumentIdentifier  `json:"textDocument"`
+	ContentChanges []TextDocumentContentChangeEvent `json:"contentChanges"`
+}
+
+// TextDocumentContentChangeEvent is a change to a document.
+// If Range is nil, Text is the full content of the document.
+type TextDocumentContentChangeEvent struct {
+	Range *Range `json:"range,omitempty"`
+	Text  string `json:"text"`
+}
+
+type DidSaveTextDocumentParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Text         *string                `json:"text,omitempty"`
+}
+
+type DidCloseTextDocumentParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+type DidChangeWorkspaceFoldersParams struct {
+	Event WorkspaceFoldersChangeEvent `json:"event"`
+}
+
+type WorkspaceFoldersChangeEvent struct {
+	Added   []WorkspaceFolder `json:"added"`
+	Removed []WorkspaceFolder `json:"removed"`
+}
+
+type DocumentFormattingParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+type TextEdit struct {
+	Range   Range  `json:"range"`
+	NewText string `json:"newText"`
+}
+
+type Diagnostic struct {
+	Range    Range  `json:"range"`
+	Severity int    `json:"severity"`
+	Source   string `json:"source,omitempty"`
+	Message  string `json:"message"`
+}
+
+type PublishDiagnosticsParams struct {
+	URI         string       `json:"uri"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+type CompletionItem struct {
+	Label      string    `json:"label"`
+	Kind       int       `json:"kind,omitempty"`
+	Detail     string    `json:"detail,omitempty"`
+	InsertText string    `json:"insertText,omitempty"`
+	FilterText string    `json:"filterText,omitempty"`
+	TextEdit   *TextEdit `json:"textEdit,omitempty"`
+
+	InsertTextFormat int `json:"insertTextFormat,omitempty"`
+}
+
+type CompletionList struct {
+	IsIncomplete bool             `json:"isIncomplete"`
+	Items        []CompletionItem `json:"items"`
+}
+
+// UnmarshalJSON accepts either a CompletionList or a list of CompletionItem
+func (cl *CompletionList) UnmarshalJSON(p []byte) error {
+	if len(p) != 0 && p[0] == '[' {
+		return json.Unmarshal(p, &cl.Items)
+	}
+	type list CompletionList
+	return json.Unmarshal(p, (*list)(cl))
+}
+
+type MarkupContent struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+// UnmarshalJSON accepts MarkupContent, as well as the deprecated MarkedString and list of MarkedString.
+// The values of a list are joined by blank lines.
+func (mc *MarkupContent) UnmarshalJSON(p []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(p, &v); err != nil {
+		return err
+	}
+	var text func(v interface{}) string
+	text = func(v interface{}) string {
+		switch v := v.(type) {
+		case string:
+			return v
+		case map[string]interface{}:
+			s, _ := v["value"].(string)
+			return s
+		case []interface{}:
+			l := make([]string, 0, len(v))
+			for _, x := range v {
+				l = append(l, text(x))
+			}
+			return strings.Join(l, "\n\n")
+		}
+		return ""
+	}
+	*mc = MarkupContent{Kind: MarkupKindMarkdown, Value: text(v)}
+	if m, ok := v.(map[string]interface{}); ok {
+		if k, _ := m["kind"].(string); k != "" {
+			mc.Kind = k
+		}
+	}
+	return nil
+}
+
+type Hover struct {
+	Contents MarkupContent `json:"contents"`
+}
@@ -0,0 +1,218 @@
+package mg
+
+import (
+	"math"
+	"runtime"
+	"runtime/metrics"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultShedFraction is the fraction of their values that caches shed when the heap budget is exceeded.
+	// See Store.SetHeapBudget
+	DefaultShedFraction = 0.25
+
+	// memPressureInterval is the interval at which the size of the heap is compared to the heap budget
+	memPressureInterval = 10 * time.Second
+
+	// heapBytesMetric is the runtime metric used to measure the size of the heap
+	heapBytesMetric = "/memory/classes/heap/objects:bytes"
+)
+
+// Shedder is implemented by caches that can release some of their values when memory is low
+// e.g. KVMap, KVLRU and KVShards. See Store.RegisterShedder
+type Shedder interface {
+	// Shed removes about fraction, between 0 and 1, of the cache's values and returns the number removed
+	Shed(fraction float64) int
+}
+
+// memPressure holds the heap budget and the caches that shed values when it's exceeded
+type memPressure struct {
+	mu       sync.Mutex
+	budget   uint64
+	fraction float64
+	shedders map[string]Shedder
+}
+
+// SetHeapBudget sets the size of the heap, in bytes, above which the registered caches are asked
+// to shed fraction of their values (see RegisterShedder), so the agent stays usable on machines with little memory.
+//
+// The size of the heap is checked periodically while the agent is running.
+// If fraction <= 0, DefaultShedFraction is used. If n is 0, caches never shed values.
+// Default: 0 i.e. disabled
+func (sto *Store) SetHeapBudget(n uint64, fraction float64) *Store {
+	mp := &sto.memPressure
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	if fraction <= 0 {
+		fraction = DefaultShedFraction
+	}
+	mp.budget = n
+	mp.fraction = math.Min(fraction, 1)
+	return sto
+}
+
+// RegisterShedder adds the cache s, identified by name in logs, to the caches that shed values
+// when the heap budget is exceeded. See SetHeapBudget
+//
+// The Store's own KVMap, and the memory held by the stores returned by DiskKV, are registered automatically.
+// Registering a cache with the same name as another replaces it.
+// The returned function unregisters it.
+func (sto *Store) RegisterShedder(name string, s Shedder) (unregister func()) {
+	mp := &sto.memPressure
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	if mp.shedders == nil {
+		mp.shedders = map[string]Shedder{}
+	}
+	mp.shedders[name] = s
+	return func() {
+		mp.mu.Lock()
+		defer mp.mu.Unlock()
+
+		if mp.shedders[name] == s {
+			delete(mp.shedders, name)
+		}
+	}
+}
+
+// heapBytes returns the size of the heap objects, including those not yet collected
+func heapBytes() uint64 {
+	s := []metrics.Sample{{Name: heapBytesMetric}}
+	metrics.Read(s)
+	if s[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return s[0].Value.Uint64()
+}
+
+// shedIfOverBudget asks the registered caches to shed values if heap exceeds the heap budget,
+// and returns the number of values shed
+func (sto *Store) shedIfOverBudget(heap uint64) int {
+	mp := &sto.memPressure
+	mp.mu.Lock()
+	budget, fraction := mp.budget, mp.fraction
+	names := make([]string, 0, len(mp.shedders))
+	for name := range mp.shedders {
+		names = append(names, name)
+	}
+	shedders := make([]Shedder, len(names))
+	sort.Strings(names)
+	for i, name := range names {
+		shedders[i] = mp.shedders[name]
+	}
+	mp.mu.Unlock()
+
+	if budget == 0 || heap <= budget {
+		return 0
+	}
+	n := 0
+	for _, s := range shedders {
+		n += s.Shed(fraction)
+	}
+	sto.ag.Log.Printf("heap budget exceeded: heap=%dMiB, budget=%dMiB: shed %d values from %d caches (%v)\n",
+		heap>>20, budget>>20, n, len(names), names)
+	return n
+}
+
+// monitorMemPressure starts comparing the size of the heap to the heap budget every memPressureInterval.
+//
+// The returned function stops monitoring.
+func (ag *Agent) monitorMemPressure() (stop func()) {
+	stopC := make(chan struct{})
+	go func() {
+		tick := time.NewTicker(memPressureInterval)
+		defer tick.Stop()
+
+		for {
+			select {
+			case <-stopC:
+				return
+			case <-tick.C:
+			}
+
+			if ag.Store.shedIfOverBudget(heapBytes()) != 0 {
+				runtime.GC()
+			}
+		}
+	}()
+	return func() { close(stopC) }
+}
+
+// shedCount returns the number of values to shed out of n, for fraction
+func shedCount(n int, fraction float64) int {
+	if n == 0 || fraction <= 0 {
+		return 0
+	}
+	return int(math.Min(float64(n), math.Ceil(float64(n)*fraction)))
+}
+
+// Shed implements Shedder by removing arbitrary values.
+// The removed values are counted as evictions (see Stats), and their watchers are notified (see Watch)
+func (m *KVMap) Shed(fraction float64) int {
+	if m == nil {
+		return 0
+	}
+
+	var notes kvNotes
+	defer notes.deliver()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := shedCount(len(m.vals), fraction)
+	i := 0
+	for k := range m.vals {
+		if i == n {
+			break
+		}
+		m.note(&notes, k, nil)
+		delete(m.vals, k)
+		delete(m.exp, k)
+		i++
+	}
+	m.counters.add(&m.counters.evictions, n)
+	return n
+}
+
+// Shed implements Shedder by evicting the least recently used values
+func (c *KVLRU) Shed(fraction float64) int {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := shedCount(len(c.items), fraction)
+	for i := 0; i < n; i++ {
+		c.del(c.ll.Back().Value.(*kvLRUEntry).k)
+	}
+	c.counters.add(&c.counters.evictions, n)
+	return n
+}
+
+// Shed implements Shedder by removing values from each shard
+func (s *KVShards) Shed(fraction float64) int {
+	if s == nil {
+		return 0
+	}
+
+	n := 0
+	for i := range s.shards {
+		n += s.shards[i].Shed(fraction)
+	}
+	return n
+}
+
+// Shed implements Shedder by removing values from memory. They're still on disk, so they're reloaded when used.
+func (kvd *KVDisk) Shed(fraction float64) int {
+	if kvd == nil {
+		return 0
+	}
+	return kvd.mem.Shed(fraction)
+}
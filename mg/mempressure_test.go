@@ -0,0 +1,38 @@
+package mg
+
+import (
+	"testing"
+)
+
+func TestHeapBudget(t *testing.T) {
+	ag := NewTestingAgent(nil, nil, nil)
+	sto := ag.Store
+	lru := NewKVLRU(0, 0)
+	for i := 0; i < 10; i++ {
+		sto.Put(i, i)
+		lru.Put(i, i)
+	}
+	unregister := sto.RegisterShedder("lru", lru)
+
+	if n := sto.shedIfOverBudget(1 << 30); n != 0 {
+		t.Errorf("%d values were shed while the heap budget was disabled", n)
+	}
+	sto.SetHeapBudget(1<<20, 0.5)
+	if n := sto.shedIfOverBudget(1 << 19); n != 0 {
+		t.Errorf("%d values were shed while the heap was within budget", n)
+	}
+	if n := sto.shedIfOverBudget(1 << 21); n != 10 || sto.Len() != 5 || lru.Len() != 5 {
+		t.Errorf("%d values were shed (Store: %d, lru: %d left) when the heap exceeded the budget; want half of each", n, sto.Len(), lru.Len())
+	}
+	if v := lru.Get(9); v != 9 {
+		t.Error("the KVLRU didn't shed its least recently used values")
+	}
+	unregister()
+	sto.shedIfOverBudget(1 << 21)
+	if n := lru.Len(); n != 5 {
+		t.Errorf("the unregistered cache shed values")
+	}
+	if ks := lru.Stats(); ks.Evictions != 5 {
+		t.Errorf("the values shed by the KVLRU weren't counted as evictions: %+v", ks)
+	}
+}
@@ -0,0 +1,199 @@
+package mg
+
+import (
+	"io"
+	"margo.sh/mg/actions"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// metricsSamples is the number of latency samples kept per action
+	metricsSamples = 256
+)
+
+// QueryMetrics is the action dispatched by the client to request the agent's metrics.
+//
+// The agent responds with a Metrics client action.
+type QueryMetrics struct{ ActionType }
+
+// Metrics is the client action dispatched in response to QueryMetrics
+type Metrics struct {
+	ActionType
+
+	// Since is the time at which metrics collection started
+	Since time.Time
+
+	// Actions is the list of metrics for each action that was handled, sorted by name
+	Actions []ActionMetrics
+
+	// KV is the list of counters of the KVStores reported using Store.ReportKVStats, sorted by name
+	KV []KVMetrics
+}
+
+func (m Metrics) ClientAction() actions.ClientData {
+	return actions.ClientData{Name: "Metrics", Data: m}
+}
+
+// ActionMetrics holds the metrics for a single action
+type ActionMetrics struct {
+	// Name is the name of the action, as returned by ActionLabel
+	Name string
+
+	// Count is the number of times the action was handled
+	Count int
+
+	// PerSecond is the average number of times per second the action was handled
+	PerSecond float64
+
+	// P50 and P95 are the 50th and 95th percentile of the time taken to reduce the action.
+	// They're calculated using the most recent samples.
+	P50, P95 time.Duration
+
+	// Responses is the number of responses sent as a result of the action
+	// and ResponseBytes is their total encoded size
+	Responses     int
+	ResponseBytes int64
+}
+
+// KVMetrics holds the counters of a KVStore
+type KVMetrics struct {
+	// Name is the name with which the store was reported
+	Name string
+
+	KVStats
+
+	// HitRate is the ratio of hits to the number of calls to Get. See KVStats.HitRate
+	HitRate float64
+}
+
+type actionMetrics struct {
+	count     int
+	samples   []time.Duration
+	next      int
+	responses int
+	resBytes  int64
+}
+
+// metricsTracker records metrics for each action handled by the store
+type metricsTracker struct {
+	ReducerType
+
+	mu    sync.Mutex
+	since time.Time
+	m     map[string]*actionMetrics
+	kvs   map[string]KVStatser
+}
+
+func newMetricsTracker() *metricsTracker {
+	return &metricsTracker{
+		since: time.Now(),
+		m:     map[string]*actionMetrics{},
+		kvs:   map[string]KVStatser{},
+	}
+}
+
+// reportKV adds the counters of kvs to the metrics, as name
+func (mt *metricsTracker) reportKV(name string, kvs KVStatser) {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+
+	mt.kvs[name] = kvs
+}
+
+func (mt *metricsTracker) get(name string) *actionMetrics {
+	am := mt.m[name]
+	if am == nil {
+		am = &actionMetrics{}
+		mt.m[name] = am
+	}
+	return am
+}
+
+// observe records that the action name took d to reduce
+func (mt *metricsTracker) observe(name string, d time.Duration) {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+
+	am := mt.get(name)
+	am.count++
+	if len(am.samples) < metricsSamples {
+		am.samples = append(am.samples, d)
+	} else {
+		am.samples[am.next] = d
+		am.next = (am.next + 1) % metricsSamples
+	}
+}
+
+// observeResponse records that a response of size bytes was sent as a result of acts
+func (mt *metricsTracker) observeResponse(acts []Action, size int64) {
+	if len(acts) == 0 {
+		return
+	}
+
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+
+	for _, act := range acts {
+		am := mt.get(ActionLabel(act))
+		am.responses++
+		am.resBytes += size
+	}
+}
+
+// snapshot returns the current metrics
+func (mt *metricsTracker) snapshot() Metrics {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+
+	m := Metrics{Since: mt.since}
+	secs := time.Since(mt.since).Seconds()
+	for name, am := range mt.m {
+		s := append([]time.Duration(nil), am.samples...)
+		sort.Slice(s, func(i, j int) bool { return s[i] < s[j] })
+		m.Actions = append(m.Actions, ActionMetrics{
+			Name:          name,
+			Count:         am.count,
+			PerSecond:     float64(am.count) / secs,
+			P50:           percentile(s, 50),
+			P95:           percentile(s, 95),
+			Responses:     am.responses,
+			ResponseBytes: am.resBytes,
+		})
+	}
+	sort.Slice(m.Actions, func(i, j int) bool { return m.Actions[i].Name < m.Actions[j].Name })
+	for name, kvs := range mt.kvs {
+		ks := kvs.Stats()
+		m.KV = append(m.KV, KVMetrics{Name: name, KVStats: ks, HitRate: ks.HitRate()})
+	}
+	sort.Slice(m.KV, func(i, j int) bool { return m.KV[i].Name < m.KV[j].Name })
+	return m
+}
+
+// percentile returns the p'th percentile of the sorted list s
+func percentile(s []time.Duration, p int) time.Duration {
+	if len(s) == 0 {
+		return 0
+	}
+	return s[(len(s)-1)*p/100]
+}
+
+func (mt *metricsTracker) Reduce(mx *Ctx) *State {
+	if _, ok := mx.Action.(QueryMetrics); ok {
+		return mx.addClientActions(mt.snapshot())
+	}
+	return mx.State
+}
+
+// countWriter counts the number of bytes written to w
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,62 @@
+package mg
+
+import (
+	"bytes"
+	"github.com/ugorji/go/codec"
+	"margo.sh/mg/actions"
+	"margo.sh/mgutil"
+	"testing"
+)
+
+func TestAgentMetrics(t *testing.T) {
+	out := &bytes.Buffer{}
+	ag, err := NewAgent(AgentConfig{
+		Stdin:  &mgutil.IOWrapper{},
+		Stdout: &mgutil.IOWrapper{Writer: out},
+		Stderr: &mgutil.IOWrapper{},
+		Codec:  "msgpack",
+	})
+	if err != nil {
+		t.Fatalf("agent creation failed: %s", err)
+	}
+	ag.Store.Subscribe(ag.sub)
+
+	for _, name := range []string{"QueryIssues", "QueryIssues", "QueryMetrics"} {
+		rq := newAgentReq(ag.Store)
+		rq.Cookie = name
+		rq.Actions = []actions.ActionData{{Name: name}}
+		rq.finalize(ag)
+		ag.Store.handleReq(rq)
+	}
+
+	var res struct {
+		State struct {
+			ClientActions []struct {
+				Name string
+				Data struct {
+					Actions []ActionMetrics
+				}
+			}
+		}
+	}
+	dec := codec.NewDecoder(out, ag.handle)
+	var m *ActionMetrics
+	for m == nil {
+		if err := dec.Decode(&res); err != nil {
+			t.Fatalf("no Metrics client action received: %s", err)
+		}
+		for _, ca := range res.State.ClientActions {
+			if ca.Name != "Metrics" {
+				continue
+			}
+			for i, am := range ca.Data.Actions {
+				if am.Name == ActionLabel(QueryIssues{}) {
+					m = &ca.Data.Actions[i]
+				}
+			}
+		}
+	}
+	if m.Count != 2 || m.Responses != 2 || m.ResponseBytes <= 0 {
+		t.Errorf("QueryIssues metrics = (%+v); want Count (2), Responses (2), ResponseBytes > 0", *m)
+	}
+}
@@ -0,0 +1,61 @@
+package mg
+
+import (
+	"sync"
+)
+
+// Middleware wraps the dispatcher next, returning a dispatcher that's called instead of it.
+//
+// The returned dispatcher may pass the action to next as-is, rewrite it, drop it, or pass several actions to next,
+// so cross-cutting concerns like logging, rate limiting and metrics don't require changes to reducers.
+type Middleware func(next Dispatcher) Dispatcher
+
+// UseMiddleware adds middleware to the chain that actions go through before they're reduced.
+//
+// The chain applies to actions dispatched using Store.Dispatch and those sent by the client.
+// Middleware added first is called first.
+// Actions sent by the client are reduced as part of its request if they're passed to next
+// before the middleware returns, otherwise they're reduced as if dispatched by Store.Dispatch.
+func (sto *Store) UseMiddleware(l ...Middleware) *Store {
+	sto.mu.Lock()
+	defer sto.mu.Unlock()
+
+	sto.middleware = append(sto.middleware[:len(sto.middleware):len(sto.middleware)], l...)
+	return sto
+}
+
+// middlewareChain returns the dispatcher that passes actions through the middleware chain, then to final
+func (sto *Store) middlewareChain(final Dispatcher) Dispatcher {
+	sto.mu.Lock()
+	l := sto.middleware
+	sto.mu.Unlock()
+
+	d := final
+	for i := len(l) - 1; i >= 0; i-- {
+		d = l[i](d)
+	}
+	return d
+}
+
+// applyMiddleware passes act through the middleware chain, calling add for each action passed on before it returns.
+// Actions passed on after it returns are dispatched using Store.Dispatch, bypassing the middleware.
+func (sto *Store) applyMiddleware(act Action, add func(Action)) {
+	var (
+		mu       sync.Mutex
+		returned bool
+	)
+	sto.middlewareChain(func(act Action) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if returned {
+			sto.dispatch(act)
+		} else {
+			add(act)
+		}
+	})(act)
+
+	mu.Lock()
+	returned = true
+	mu.Unlock()
+}
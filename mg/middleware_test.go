@@ -0,0 +1,50 @@
+package mg
+
+import (
+	"margo.sh/mg/actions"
+	"strings"
+	"testing"
+)
+
+func TestStoreMiddleware(t *testing.T) {
+	ag := NewTestingAgent(nil, nil, nil)
+	var seen []string
+	ag.Store.UseMiddleware(func(next Dispatcher) Dispatcher {
+		return func(act Action) {
+			seen = append(seen, "mw:"+ActionLabel(act))
+			switch act.(type) {
+			case QueryTooltips:
+			case QueryIssues:
+				next(act)
+				next(QueryUserCmds{})
+			default:
+				next(act)
+			}
+		}
+	})
+	ag.Store.Use(NewReducer(func(mx *Ctx) *State {
+		switch mx.Action.(type) {
+		case QueryIssues, QueryTooltips, QueryUserCmds, QueryTestCmds:
+			seen = append(seen, ActionLabel(mx.Action))
+		}
+		return mx.State
+	}))
+
+	rq := newAgentReq(ag.Store)
+	rq.Cookie = "middleware"
+	rq.Actions = []actions.ActionData{{Name: "QueryTooltips"}, {Name: "QueryIssues"}}
+	rq.finalize(ag)
+	ag.Store.handleReq(rq)
+	want := "mw:mg.QueryTooltips mw:mg.QueryIssues mg.QueryIssues mg.QueryUserCmds"
+	if s := strings.Join(seen, " "); s != want {
+		t.Errorf("seen = (%s); want (%s)", s, want)
+	}
+
+	seen = nil
+	ag.Store.Dispatch(QueryTestCmds{})
+	(<-ag.Store.dsp.lo)()
+	want = "mw:mg.QueryTestCmds mg.QueryTestCmds"
+	if s := strings.Join(seen, " "); s != want {
+		t.Errorf("seen = (%s); want (%s)", s, want)
+	}
+}
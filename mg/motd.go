@@ -107,7 +107,7 @@ func (m *MOTD) Reduce(mx *Ctx) *State {
 }
 
 func (m *MOTD) motdSyncCmd(bx *CmdCtx) *State {
-	go func() {
+	bx.Jobs.Submit("motd.sync", func(*JobCtx) []Action {
 		defer bx.Output.Close()
 
 		err := m.sync(bx.Ctx)
@@ -118,7 +118,8 @@ func (m *MOTD) motdSyncCmd(bx *CmdCtx) *State {
 			fmt.Fprintln(bx.Output, "MOTD:", ms.Result.Message)
 			fmt.Fprintln(bx.Output, ms.Result.ANN.Content)
 		}
-	}()
+		return nil
+	})
 	return bx.State
 }
 
@@ -210,7 +211,7 @@ func (m *MOTD) dispatchMsg(mx *Ctx, ms motdState) {
 	case cliTag < srvTag:
 		act.msg = res.Message
 	}
-	mx.Store.Dispatch(act)
+	mx.Store.DispatchPriority(act, PriorityHousekeeping)
 }
 
 func (m *MOTD) proc(mx *Ctx) {
@@ -0,0 +1,27 @@
+package mg
+
+import (
+	"margo.sh/mg/actions"
+)
+
+// Notify pushes the client actions acts to all connected clients immediately.
+//
+// Unlike Dispatch, no reduction takes place: the response only has Notifications set,
+// with no Cookie and an empty State, so it can be sent from any goroutine
+// e.g. when a background task finishes or a file changes on disk,
+// without waiting for the client to send a request.
+func (sto *Store) Notify(acts ...actions.ClientAction) {
+	if len(acts) == 0 {
+		return
+	}
+	l := make([]actions.ClientData, len(acts))
+	for i, a := range acts {
+		l[i] = a.ClientAction()
+	}
+	sto.ag.send(agentRes{Notifications: l})
+}
+
+// Notify is an alias of mx.Store.Notify
+func (mx *Ctx) Notify(acts ...actions.ClientAction) {
+	mx.Store.Notify(acts...)
+}
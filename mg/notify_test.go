@@ -0,0 +1,46 @@
+package mg
+
+import (
+	"bytes"
+	"github.com/ugorji/go/codec"
+	"margo.sh/mg/actions"
+	"margo.sh/mgutil"
+	"testing"
+)
+
+type testNotice struct{ Msg string }
+
+func (n testNotice) ClientAction() actions.ClientData {
+	return actions.ClientData{Name: "TestNotice", Data: n}
+}
+
+func TestStoreNotify(t *testing.T) {
+	out := &bytes.Buffer{}
+	ag, err := NewAgent(AgentConfig{
+		Stdin:  &mgutil.IOWrapper{},
+		Stdout: &mgutil.IOWrapper{Writer: out},
+		Stderr: &mgutil.IOWrapper{},
+		Codec:  "msgpack",
+	})
+	if err != nil {
+		t.Fatalf("agent creation failed: %s", err)
+	}
+	ag.Store.Notify(testNotice{Msg: "cache warmed"})
+
+	var res struct {
+		Cookie        string
+		Notifications []struct {
+			Name string
+			Data struct{ Msg string }
+		}
+	}
+	if err := codec.NewDecoder(out, ag.handle).Decode(&res); err != nil {
+		t.Fatalf("dec.Decode(): %s", err)
+	}
+	if res.Cookie != "" {
+		t.Errorf("res.Cookie = (%s); want it empty", res.Cookie)
+	}
+	if l := res.Notifications; len(l) != 1 || l[0].Name != "TestNotice" || l[0].Data.Msg != "cache warmed" {
+		t.Errorf("res.Notifications = (%+v); want the TestNotice", l)
+	}
+}
@@ -0,0 +1,16 @@
+// +build !windows
+
+package mg
+
+import (
+	"fmt"
+	"net"
+)
+
+const (
+	npipeSupported = false
+)
+
+func listenPipe(name string) (net.Listener, error) {
+	return nil, fmt.Errorf("named pipes are only supported on windows")
+}
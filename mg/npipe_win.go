@@ -0,0 +1,127 @@
+// +build windows
+
+package mg
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	npipeSupported = true
+
+	pipeAccessDuplex       = 0x3
+	pipeTypeByte           = 0x0
+	pipeReadmodeByte       = 0x0
+	pipeWait               = 0x0
+	pipeRejectRemote       = 0x8
+	pipeUnlimitedInstances = 255
+	pipeBufferSize         = 64 << 10
+
+	errorPipeConnected = syscall.Errno(535)
+)
+
+var (
+	kernel32             = syscall.NewLazyDLL("kernel32.dll")
+	procCreateNamedPipeW = kernel32.NewProc("CreateNamedPipeW")
+	procConnectNamedPipe = kernel32.NewProc("ConnectNamedPipe")
+)
+
+// pipeAddr is the net.Addr of a named pipe
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "npipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+// pipeConn is a connection to a client over a named pipe
+type pipeConn struct {
+	*os.File
+	addr pipeAddr
+}
+
+func (pc *pipeConn) LocalAddr() net.Addr  { return pc.addr }
+func (pc *pipeConn) RemoteAddr() net.Addr { return pc.addr }
+
+// pipeListener implements net.Listener for named pipes.
+//
+// Each call to Accept creates a new instance of the pipe and waits for a client to connect to it.
+type pipeListener struct {
+	addr   pipeAddr
+	mu     sync.Mutex
+	closed bool
+}
+
+func listenPipe(name string) (net.Listener, error) {
+	pl := &pipeListener{addr: pipeAddr(name)}
+	// make sure the pipe can be created, so errors are reported immediately
+	h, err := pl.create()
+	if err != nil {
+		return nil, err
+	}
+	syscall.CloseHandle(h)
+	return pl, nil
+}
+
+func (pl *pipeListener) create() (syscall.Handle, error) {
+	name, err := syscall.UTF16PtrFromString(string(pl.addr))
+	if err != nil {
+		return syscall.InvalidHandle, err
+	}
+	r, _, e := procCreateNamedPipeW.Call(
+		uintptr(unsafe.Pointer(name)),
+		pipeAccessDuplex,
+		pipeTypeByte|pipeReadmodeByte|pipeWait|pipeRejectRemote,
+		pipeUnlimitedInstances,
+		pipeBufferSize,
+		pipeBufferSize,
+		0,
+		0,
+	)
+	if h := syscall.Handle(r); h != syscall.InvalidHandle {
+		return h, nil
+	}
+	return syscall.InvalidHandle, fmt.Errorf("CreateNamedPipe(%s): %s", pl.addr, e)
+}
+
+func (pl *pipeListener) Accept() (net.Conn, error) {
+	h, err := pl.create()
+	if err != nil {
+		return nil, err
+	}
+	r, _, e := procConnectNamedPipe.Call(uintptr(h), 0)
+	if r == 0 && e != errorPipeConnected {
+		syscall.CloseHandle(h)
+		return nil, fmt.Errorf("ConnectNamedPipe(%s): %s", pl.addr, e)
+	}
+
+	pl.mu.Lock()
+	closed := pl.closed
+	pl.mu.Unlock()
+	if closed {
+		syscall.CloseHandle(h)
+		return nil, fmt.Errorf("listener closed")
+	}
+	return &pipeConn{File: os.NewFile(uintptr(h), string(pl.addr)), addr: pl.addr}, nil
+}
+
+// Close stops the listener.
+// ConnectNamedPipe can't be interrupted, so a blocked Accept is woken up by connecting to the pipe.
+func (pl *pipeListener) Close() error {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+
+	if pl.closed {
+		return nil
+	}
+	pl.closed = true
+	if f, err := os.OpenFile(string(pl.addr), os.O_RDWR, 0); err == nil {
+		f.Close()
+	}
+	return nil
+}
+
+func (pl *pipeListener) Addr() net.Addr { return pl.addr }
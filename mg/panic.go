@@ -0,0 +1,62 @@
+package mg
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// panicError is the error reported when handling a request or action panics
+type panicError struct {
+	val   interface{}
+	stack []byte
+}
+
+func newPanicError(val interface{}) *panicError {
+	return &panicError{val: val, stack: debug.Stack()}
+}
+
+func (pe *panicError) Error() string {
+	return fmt.Sprintf("panic: %v", pe.val)
+}
+
+// safeReduction calls sr.reduction(mx), recovering from panics.
+//
+// If a reducer panics, the changes made to the state while handling the action are discarded
+// and the panic is reported as an error.
+func (sto *Store) safeReduction(sr storeReducers, mx *Ctx) (res *Ctx, pe *panicError) {
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		pe = newPanicError(v)
+		name := ActionLabel(mx.Action)
+		sto.ag.Log.Printf("%s while handling action %s\n%s", pe, name, pe.stack)
+		res = mx.SetState(mx.AddErrorf("%s while handling action %s", pe, name))
+	}()
+	return sr.reduction(mx), nil
+}
+
+// reqPanicked sends the final response to rq after handling it panicked outside of a reduction
+func (ag *Agent) reqPanicked(rq *agentReq, pe *panicError) {
+	msg := fmt.Sprintf("%s while handling request %s", pe, rq.Cookie)
+	ag.Log.Printf("%s\n%s", msg, pe.stack)
+
+	if len(rq.results) != len(rq.Actions) {
+		rq.results = make([]actionResult, len(rq.Actions))
+		for i, ra := range rq.Actions {
+			rq.results[i].Name = ra.Name
+		}
+	}
+	for i := range rq.results {
+		if rq.results[i].Error == "" {
+			rq.results[i].Error = msg
+			rq.results[i].Stack = string(pe.stack)
+		}
+	}
+	ag.send(agentRes{
+		Cookie: rq.Cookie,
+		Error:  msg,
+		req:    rq,
+	})
+}
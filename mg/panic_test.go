@@ -0,0 +1,55 @@
+package mg
+
+import (
+	"bytes"
+	"github.com/ugorji/go/codec"
+	"margo.sh/mg/actions"
+	"margo.sh/mgutil"
+	"strings"
+	"testing"
+)
+
+func TestAgentPanicRecovery(t *testing.T) {
+	out := &bytes.Buffer{}
+	ag, err := NewAgent(AgentConfig{
+		Stdin:  &mgutil.IOWrapper{},
+		Stdout: &mgutil.IOWrapper{Writer: out},
+		Stderr: &mgutil.IOWrapper{},
+		Codec:  "msgpack",
+	})
+	if err != nil {
+		t.Fatalf("agent creation failed: %s", err)
+	}
+	ag.Store.Subscribe(ag.sub)
+	ag.Store.Use(NewReducer(func(mx *Ctx) *State {
+		if _, ok := mx.Action.(QueryCompletions); ok {
+			panic("reducer exploded")
+		}
+		return mx.State
+	}))
+
+	rq := newAgentReq(ag.Store)
+	rq.Cookie = "panic"
+	rq.Actions = []actions.ActionData{
+		{Name: "QueryCompletions"},
+		{Name: "QueryIssues"},
+	}
+	rq.finalize(ag)
+	ag.Store.handleReq(rq)
+
+	var res struct {
+		Actions []struct{ Name, Error, Stack string }
+	}
+	if err := codec.NewDecoder(out, ag.handle).Decode(&res); err != nil {
+		t.Fatalf("dec.Decode(): %s", err)
+	}
+	if len(res.Actions) != len(rq.Actions) {
+		t.Fatalf("len(res.Actions) = (%d); want (%d)", len(res.Actions), len(rq.Actions))
+	}
+	if ra := res.Actions[0]; !strings.Contains(ra.Error, "reducer exploded") || ra.Stack == "" {
+		t.Errorf("res.Actions[0] = (%+v); want the panic and its stack trace", ra)
+	}
+	if ra := res.Actions[1]; ra.Error != "" || ra.Stack != "" {
+		t.Errorf("res.Actions[1] = (%+v); want no error", ra)
+	}
+}
@@ -0,0 +1,68 @@
+package mg
+
+import (
+	"margo.sh/mgpf"
+	"sync"
+)
+
+// parallelReduction calls the reductions of the reducers in rl concurrently, and merges the resulting states.
+//
+// Each reducer is passed the same Ctx. Of the changes they make to the state, only the status messages, errors,
+// issues, completions, tooltips, commands and client actions they add are kept. See Reducer.RParallel
+func parallelReduction(mx *Ctx, rl reducerList) *Ctx {
+	defer mx.Profile.Push("Parallel").Pop()
+
+	res := make([]*Ctx, len(rl))
+	wg := sync.WaitGroup{}
+	for i, r := range rl {
+		wg.Add(1)
+		go func(i int, r Reducer) {
+			defer wg.Done()
+			// Profile isn't safe for concurrent use
+			rmx := mx.Copy(func(x *Ctx) { x.Profile = mgpf.NewProfile("") })
+			res[i] = mx.Store.isolatedReduction(rmx, r)
+		}(i, r)
+	}
+	wg.Wait()
+
+	st := mx.State
+	var pe *panicError
+	for _, rmx := range res {
+		st = mergeParallelState(mx.State, st, rmx.State)
+		if rmx.panicked != nil {
+			pe = rmx.panicked
+		}
+	}
+	mx = mx.SetState(st)
+	if pe != nil {
+		mx.panicked = pe
+	}
+	return mx
+}
+
+// mergeParallelState returns st with the additions made to base by a parallel reducer, that returned res
+func mergeParallelState(base, st, res *State) *State {
+	if res == base {
+		return st
+	}
+	return st.Copy(func(st *State) {
+		st.Status = st.Status.Add(res.Status...)
+		st.Errors = st.Errors.Add(res.Errors...)
+		st.Issues = st.Issues.Add(res.Issues...)
+		if n := len(base.Completions); len(res.Completions) > n {
+			st.Completions = append(st.Completions[:len(st.Completions):len(st.Completions)], res.Completions[n:]...)
+		}
+		if n := len(base.Tooltips); len(res.Tooltips) > n {
+			st.Tooltips = append(st.Tooltips[:len(st.Tooltips):len(st.Tooltips)], res.Tooltips[n:]...)
+		}
+		if n := len(base.BuiltinCmds); len(res.BuiltinCmds) > n {
+			st.BuiltinCmds = append(st.BuiltinCmds[:len(st.BuiltinCmds):len(st.BuiltinCmds)], res.BuiltinCmds[n:]...)
+		}
+		if n := len(base.UserCmds); len(res.UserCmds) > n {
+			st.UserCmds = append(st.UserCmds[:len(st.UserCmds):len(st.UserCmds)], res.UserCmds[n:]...)
+		}
+		if n := len(base.clientActions); len(res.clientActions) > n {
+			st.clientActions = append(st.clientActions[:len(st.clientActions):len(st.clientActions)], res.clientActions[n:]...)
+		}
+	})
+}
@@ -0,0 +1,46 @@
+package mg
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreParallelReducers(t *testing.T) {
+	ag := NewTestingAgent(nil, nil, nil)
+	lint := func(name string) Reducer {
+		return NewReducer(func(mx *Ctx) *State {
+			if !mx.ActionIs(QueryIssues{}) {
+				return mx.State
+			}
+			time.Sleep(100 * time.Millisecond)
+			if name == "panics" {
+				panic("lint exploded")
+			}
+			return mx.AddStatus(name).AddIssues(Issue{Message: name}).SetEnv(EnvMap{"DISCARDED": name})
+		}, func(rf *RFunc) { rf.Label, rf.Parallel = name, true })
+	}
+	ag.Store.Use(lint("vet"), lint("lint"), lint("panics"))
+
+	var last *Ctx
+	ag.Store.Subscribe(func(mx *Ctx) { last = mx })
+	start := time.Now()
+	ag.Store.handleAct(QueryIssues{}, nil)
+	if d := time.Since(start); d >= 250*time.Millisecond {
+		t.Errorf("the reducers took %s; want them to run concurrently", d)
+	}
+
+	st := last.State
+	if !st.Status.Has("vet") || !st.Status.Has("lint") {
+		t.Errorf("Status = (%q); want the status of both reducers", st.Status)
+	}
+	if len(st.Issues) != 2 || st.Issues[0].Message != "vet" || st.Issues[1].Message != "lint" {
+		t.Errorf("Issues = (%v); want the issues of vet, then lint", st.Issues)
+	}
+	if len(st.Errors) != 1 || !strings.Contains(st.Errors[0], "lint exploded") {
+		t.Errorf("Errors = (%q); want the panic", st.Errors)
+	}
+	if v := st.Env.Get("DISCARDED", ""); v != "" {
+		t.Errorf("Env[DISCARDED] = (%s); want changes other than additions to be discarded", v)
+	}
+}
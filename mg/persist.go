@@ -0,0 +1,214 @@
+package mg
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// persistLastFn is the name of the file, in AgentConfig.StateDir, holding the directory of the last project
+	persistLastFn = "last-project"
+)
+
+// persistedState is the part of the state of a project written to disk by stateSupport
+type persistedState struct {
+	Project string
+	Env     EnvMap
+	Issues  []persistedIssues
+	KV      []handoffValue
+}
+
+// persistedIssues is the equivalent of StoreIssues with its IssueKey.Key replaced by a description of it
+type persistedIssues struct {
+	Key             string
+	Name, Path, Dir string
+	Issues          IssueSet
+}
+
+// persistedIssueKey is the IssueKey.Key of issues restored from disk.
+// Its value is the description of the original key, as returned by persistedKeyDesc
+type persistedIssueKey string
+
+// persistedKeyDesc returns a description of IssueKey.Key k that's stable across restarts
+func persistedKeyDesc(k interface{}) string {
+	if pk, ok := k.(persistedIssueKey); ok {
+		return string(pk)
+	}
+	return fmt.Sprintf("%T:%+v", k, k)
+}
+
+// stateSupport persists parts of the state of each project across restarts. See AgentConfig.StateDir
+//
+// When the agent shuts down, or the active view moves to another project,
+// the environment, the issues stored using StoreIssues and the Store.KVMap values stored using a HandoffKey
+// are written to a file named after the project's directory.
+// They're restored when the agent starts, for the last project, or when a view in the project is activated.
+type stateSupport struct {
+	ReducerType
+
+	dir     string
+	project string
+	issues  map[IssueKey]IssueSet
+}
+
+func (ss *stateSupport) RCond(mx *Ctx) bool {
+	return ss.dir != ""
+}
+
+func (ss *stateSupport) RMount(mx *Ctx) {
+	ss.issues = map[IssueKey]IssueSet{}
+}
+
+func (ss *stateSupport) RUnmount(mx *Ctx) {
+	ss.save(mx)
+}
+
+func (ss *stateSupport) Reduce(mx *Ctx) *State {
+	switch act := mx.Action.(type) {
+	case initAction:
+		if p, err := ioutil.ReadFile(filepath.Join(ss.dir, persistLastFn)); err == nil {
+			return ss.load(mx, string(p))
+		}
+		return mx.State
+	case StoreIssues:
+		ss.storeIssues(mx, act)
+	}
+
+	if proj := projectDir(mx); proj != "" && proj != ss.project {
+		ss.save(mx)
+		return ss.load(mx, proj)
+	}
+	return mx.State
+}
+
+// storeIssues records the issues stored by act.
+// If they replace issues restored from disk, those are cleared
+func (ss *stateSupport) storeIssues(mx *Ctx, act StoreIssues) {
+	if len(act.Issues) == 0 {
+		delete(ss.issues, act.IssueKey)
+	} else {
+		ss.issues[act.IssueKey] = act.Issues
+	}
+	if _, ok := act.Key.(persistedIssueKey); ok {
+		return
+	}
+	pk := act.IssueKey
+	pk.Key = persistedIssueKey(persistedKeyDesc(act.Key))
+	if _, ok := ss.issues[pk]; ok {
+		delete(ss.issues, pk)
+		mx.Store.Dispatch(StoreIssues{IssueKey: pk})
+	}
+}
+
+// save writes the state of the current project to disk
+func (ss *stateSupport) save(mx *Ctx) {
+	if ss.project == "" {
+		return
+	}
+
+	ps := persistedState{
+		Project: ss.project,
+		Env:     mx.Env,
+		KV:      mx.Store.handoffValues("persist"),
+	}
+	for k, l := range ss.issues {
+		ps.Issues = append(ps.Issues, persistedIssues{
+			Key:    persistedKeyDesc(k.Key),
+			Name:   k.Name,
+			Path:   k.Path,
+			Dir:    k.Dir,
+			Issues: l,
+		})
+	}
+
+	if err := ss.write(ss.fileName(ss.project), &ps); err != nil {
+		mx.Log.Println("persist:", err)
+		return
+	}
+	if err := ioutil.WriteFile(filepath.Join(ss.dir, persistLastFn), []byte(ss.project), 0600); err != nil {
+		mx.Log.Println("persist:", err)
+	}
+}
+
+// write encodes ps to the file fn, replacing it atomically
+func (ss *stateSupport) write(fn string, ps *persistedState) error {
+	if err := os.MkdirAll(ss.dir, 0700); err != nil {
+		return fmt.Errorf("cannot create state dir: %s", err)
+	}
+	f, err := ioutil.TempFile(ss.dir, ".tmp-")
+	if err != nil {
+		return fmt.Errorf("cannot create state file: %s", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if err := gob.NewEncoder(f).Encode(ps); err != nil {
+		return fmt.Errorf("cannot write state file: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("cannot write state file: %s", err)
+	}
+	return os.Rename(f.Name(), fn)
+}
+
+// load restores the state of project proj from disk, and makes it the current project
+func (ss *stateSupport) load(mx *Ctx, proj string) *State {
+	ss.project = proj
+
+	f, err := os.Open(ss.fileName(proj))
+	if err != nil {
+		return mx.State
+	}
+	defer f.Close()
+
+	ps := persistedState{}
+	if err := gob.NewDecoder(f).Decode(&ps); err != nil {
+		mx.Log.Printf("persist: cannot read the state of %s: %s\n", proj, err)
+		return mx.State
+	}
+
+	for _, pi := range ps.Issues {
+		mx.Store.DispatchPriority(StoreIssues{
+			IssueKey: IssueKey{
+				Key:  persistedIssueKey(pi.Key),
+				Name: pi.Name,
+				Path: pi.Path,
+				Dir:  pi.Dir,
+			},
+			Issues: pi.Issues,
+		}, PriorityHousekeeping)
+	}
+	mx.Store.restoreHandoffValues("persist", ps.KV)
+	mx.Log.Printf("persist: restored the state of %s: %d issue sets, %d cached values\n", proj, len(ps.Issues), len(ps.KV))
+
+	if len(mx.Env) != 0 || len(ps.Env) == 0 {
+		return mx.State
+	}
+	return mx.SetEnv(ps.Env)
+}
+
+// fileName returns the name of the file holding the state of project proj
+func (ss *stateSupport) fileName(proj string) string {
+	sum := sha256.Sum256([]byte(proj))
+	name := sanitizeDirNamePat.ReplaceAllString(filepath.Base(proj), "~")
+	return filepath.Join(ss.dir, fmt.Sprintf("%s-%x.gob", name, sum[:8]))
+}
+
+// projectDir returns the directory of the project that the view belongs to:
+// the closest directory containing a go.mod file or .git directory, or the view's directory
+func projectDir(mx *Ctx) string {
+	dir := mx.View.Dir()
+	if dir == "" || !filepath.IsAbs(dir) {
+		return ""
+	}
+	for _, name := range []string{"go.mod", ".git"} {
+		if nd, _, err := mx.VFS.Poke(dir).Locate(name); err == nil {
+			return nd.Parent().Path()
+		}
+	}
+	return dir
+}
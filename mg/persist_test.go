@@ -0,0 +1,73 @@
+package mg
+
+import (
+	"io/ioutil"
+	"margo.sh/mgutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAgentStateDir(t *testing.T) {
+	type lintKey struct{ Linter string }
+	dir := t.TempDir()
+	proj := filepath.Join(dir, "proj")
+	os.MkdirAll(filepath.Join(proj, "pkg"), 0700)
+	ioutil.WriteFile(filepath.Join(proj, "go.mod"), []byte("module proj\n"), 0600)
+	fn := filepath.Join(proj, "pkg", "a.go")
+	live := StoreIssues{
+		IssueKey: IssueKey{Key: lintKey{"vet"}, Path: fn},
+		Issues:   IssueSet{{Path: fn, Row: 1, Message: "unused"}},
+	}
+
+	newAgent := func() (*Agent, *stateSupport) {
+		ag, err := NewAgent(AgentConfig{
+			Stdin:    &mgutil.IOWrapper{},
+			Stdout:   &mgutil.IOWrapper{},
+			Stderr:   &mgutil.IOWrapper{},
+			StateDir: filepath.Join(dir, "state"),
+		})
+		if err != nil {
+			t.Fatalf("agent creation failed: %s", err)
+		}
+		for _, r := range ag.Store.reducers.after {
+			if ss, ok := r.(*stateSupport); ok {
+				return ag, ss
+			}
+		}
+		t.Fatal("stateSupport isn't registered")
+		return nil, nil
+	}
+
+	ag, _ := newAgent()
+	ag.Store.handleAct(initAction{}, nil)
+	rq := newAgentReq(ag.Store)
+	rq.Props.View.Path, rq.Props.View.Name = fn, "a.go"
+	rq.Props.Env = EnvMap{"GOFLAGS": "-mod=vendor"}
+	rq.finalize(ag)
+	ag.Store.handleReq(rq)
+	ag.Store.handleAct(live, nil)
+	ag.Store.handleAct(unmount{}, nil)
+
+	ag, ss := newAgent()
+	ag.Store.handleAct(initAction{}, nil)
+	if ss.project != proj {
+		t.Fatalf("project = (%s); want the last project (%s)", ss.project, proj)
+	}
+	if v := ag.Store.state.Env.Get("GOFLAGS", ""); v != "-mod=vendor" {
+		t.Errorf("Env[GOFLAGS] = (%s); want the restored env", v)
+	}
+	(<-ag.Store.dsp.housekeeping)()
+	restored := live.IssueKey
+	restored.Key = persistedIssueKey(persistedKeyDesc(live.Key))
+	if l := ss.issues[restored]; len(l) != 1 || l[0].Message != "unused" {
+		t.Fatalf("restored issues = (%v); want (%v)", l, live.Issues)
+	}
+
+	// the restored issues are replaced when the linter stores its issues
+	ag.Store.handleAct(live, nil)
+	(<-ag.Store.dsp.lo)()
+	if _, ok := ss.issues[restored]; ok {
+		t.Error("the restored issues weren't cleared when the live issues were stored")
+	}
+}
@@ -0,0 +1,84 @@
+package mg
+
+import (
+	"fmt"
+	"plugin"
+)
+
+// PluginAPIVersion is the version of the API used by plugins loaded with Agent.LoadPlugin.
+//
+// It's incremented when a change to the agent would break plugins built against an older version.
+const PluginAPIVersion = 1
+
+const (
+	// pluginAPISymbol is the name of the variable, of type int, that a plugin sets to PluginAPIVersion
+	pluginAPISymbol = "MargoPluginAPI"
+
+	// pluginMargoSymbol is the name of the function, of type func(mg.Args), called to set up a plugin
+	pluginMargoSymbol = "Margo"
+)
+
+// LoadPlugin loads the Go plugin at path and calls its Margo function to set up the agent.
+//
+// The plugin is built using `go build -buildmode=plugin` against the same margo.sh tree as the agent
+// and its main package is the equivalent of margo.go, but must also declare the API version it was built for:
+//
+//	var MargoPluginAPI = mg.PluginAPIVersion
+//
+//	func Margo(ma mg.Args) {
+//		ma.Store.Use(...)
+//	}
+//
+// Plugins declaring a different API version are rejected.
+// Go plugins can't be unloaded so a plugin loaded into several agents e.g. sub-agents, is only opened once.
+func (ag *Agent) LoadPlugin(path string) error {
+	mf, err := openPlugin(path)
+	if err != nil {
+		return err
+	}
+	ag.Log.Println("loading plugin", path)
+	mf(ag.Args())
+	return nil
+}
+
+// openPlugin opens the Go plugin at path and returns its Margo function
+func openPlugin(path string) (MargoFunc, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open plugin %s: %s", path, err)
+	}
+	mf, err := pluginMargoFunc(p.Lookup)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load plugin %s: %s", path, err)
+	}
+	return mf, nil
+}
+
+// pluginMargoFunc returns the Margo function of the plugin whose symbols are looked up using lookup,
+// after checking that it was built for PluginAPIVersion
+func pluginMargoFunc(lookup func(string) (plugin.Symbol, error)) (MargoFunc, error) {
+	sym, err := lookup(pluginAPISymbol)
+	if err != nil {
+		return nil, fmt.Errorf("it doesn't declare its API version: %s", err)
+	}
+	ver, ok := sym.(*int)
+	if !ok {
+		return nil, fmt.Errorf("%s is %T, not int", pluginAPISymbol, sym)
+	}
+	if *ver != PluginAPIVersion {
+		return nil, fmt.Errorf("it was built for API version %d, but the agent's API version is %d", *ver, PluginAPIVersion)
+	}
+
+	sym, err = lookup(pluginMargoSymbol)
+	if err != nil {
+		return nil, err
+	}
+	switch f := sym.(type) {
+	case func(Args):
+		return f, nil
+	case *MargoFunc:
+		return *f, nil
+	default:
+		return nil, fmt.Errorf("%s is %T, not func(mg.Args)", pluginMargoSymbol, sym)
+	}
+}
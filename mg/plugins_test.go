@@ -0,0 +1,41 @@
+package mg
+
+import (
+	"fmt"
+	"path/filepath"
+	"plugin"
+	"testing"
+)
+
+func TestAgentLoadPlugin(t *testing.T) {
+	ag := NewTestingAgent(nil, nil, nil)
+	if err := ag.LoadPlugin(filepath.Join(t.TempDir(), "missing.so")); err == nil {
+		t.Error("LoadPlugin() of a missing file succeeded; want an error")
+	}
+
+	called := false
+	margo := func(Args) { called = true }
+	lookup := func(ver int) func(string) (plugin.Symbol, error) {
+		return func(name string) (plugin.Symbol, error) {
+			switch name {
+			case pluginAPISymbol:
+				return &ver, nil
+			case pluginMargoSymbol:
+				return margo, nil
+			}
+			return nil, fmt.Errorf("symbol %s not found", name)
+		}
+	}
+
+	if _, err := pluginMargoFunc(lookup(PluginAPIVersion + 1)); err == nil {
+		t.Error("pluginMargoFunc() accepted a plugin built for another API version")
+	}
+	mf, err := pluginMargoFunc(lookup(PluginAPIVersion))
+	if err != nil {
+		t.Fatalf("pluginMargoFunc(): %s", err)
+	}
+	mf(ag.Args())
+	if !called {
+		t.Error("the plugin's Margo function wasn't returned")
+	}
+}
@@ -0,0 +1,76 @@
+package mg
+
+// Priority is the priority of a dispatched action. See Store.DispatchPriority
+//
+// Client requests are always handled before dispatched actions.
+// Pending dispatched actions are handled in order of priority,
+// and in the order they were dispatched within the same priority.
+type Priority int
+
+const (
+	// PriorityHousekeeping is the priority of actions that aren't time-sensitive e.g. heartbeats,
+	// status animations or restoring persisted data.
+	// They're only handled when no other actions are pending.
+	PriorityHousekeeping Priority = iota - 1
+
+	// PriorityNormal is the priority of actions dispatched using Store.Dispatch
+	PriorityNormal
+
+	// PriorityInteractive is the priority of actions whose results the user is waiting for
+	// e.g. a Render after the HUD was updated or a jump to a definition.
+	// They're handled before other dispatched actions.
+	PriorityInteractive
+)
+
+// DispatchPriority is like Store.Dispatch, but schedules the reduction of act with priority p
+func (sto *Store) DispatchPriority(act Action, p Priority) {
+	sto.middlewareChain(func(act Action) { sto.dispatchPriority(act, p) })(act)
+}
+
+// dispatchPriority schedules a new reduction with Action act and priority p, bypassing the middleware chain
+func (sto *Store) dispatchPriority(act Action, p Priority) {
+	sto.schedulePriority(func() { sto.handleAct(act, nil) }, p)
+}
+
+// queue returns the dispatch queue of actions with priority p
+func (sto *Store) queue(p Priority) chan dispatchHandler {
+	switch {
+	case p <= PriorityHousekeeping:
+		return sto.dsp.housekeeping
+	case p >= PriorityInteractive:
+		return sto.dsp.interactive
+	default:
+		return sto.dsp.lo
+	}
+}
+
+// nextDispatcher returns the next handler to call: the first pending one, in order of priority,
+// or if there are none, the first one to be scheduled
+func (sto *Store) nextDispatcher() dispatchHandler {
+	dsp := &sto.dsp
+	var h dispatchHandler
+scan:
+	for _, q := range [...]chan dispatchHandler{dsp.hi, dsp.interactive, dsp.lo, dsp.housekeeping} {
+		select {
+		case h = <-q:
+			break scan
+		default:
+		}
+	}
+	if h == nil {
+		select {
+		case h = <-dsp.hi:
+		case h = <-dsp.interactive:
+		case h = <-dsp.lo:
+		case h = <-dsp.housekeeping:
+		}
+	}
+
+	dsp.RLock()
+	defer dsp.RUnlock()
+
+	if dsp.unmounted {
+		return nil
+	}
+	return h
+}
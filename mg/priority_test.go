@@ -0,0 +1,33 @@
+package mg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDispatchPriority(t *testing.T) {
+	type prioAct struct {
+		ActionType
+		Name string
+	}
+	sto := NewTestingStore()
+	var got []string
+	sto.Use(NewReducer(func(mx *Ctx) *State {
+		if act, ok := mx.Action.(prioAct); ok {
+			got = append(got, act.Name)
+		}
+		return mx.State
+	}))
+
+	sto.DispatchPriority(prioAct{Name: "housekeeping"}, PriorityHousekeeping)
+	sto.Dispatch(prioAct{Name: "normal-1"})
+	sto.DispatchPriority(prioAct{Name: "interactive"}, PriorityInteractive)
+	sto.DispatchPriority(prioAct{Name: "normal-2"}, PriorityNormal)
+	sto.dsp.hi <- func() { got = append(got, "request") }
+	for i := 0; i < 5; i++ {
+		sto.nextDispatcher()()
+	}
+	if s, want := strings.Join(got, " "), "request interactive normal-1 normal-2 housekeeping"; s != want {
+		t.Errorf("the actions were handled in the order (%s); want (%s)", s, want)
+	}
+}
@@ -0,0 +1,133 @@
+package mg
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultReducerPanicLimit is the default number of consecutive panics after which a reducer is disabled.
+// See Store.SetReducerPanicLimit
+const DefaultReducerPanicLimit = 3
+
+// reducerQuarantine tracks the consecutive panics of each reducer and the reducers that were disabled
+type reducerQuarantine struct {
+	mu     sync.Mutex
+	panics map[*ReducerType]int
+	labels map[*ReducerType]string
+}
+
+// SetReducerPanicLimit sets the number of consecutive panics after which a reducer is disabled.
+//
+// Panics are recovered separately for each reducer, and reported in State.Errors with their stack.
+// When a reducer panics n times in a row, it's no longer called for the rest of the session
+// and a notice is added to State.Status.
+// If n <= 0, reducers are never disabled.
+// Default: DefaultReducerPanicLimit
+func (sto *Store) SetReducerPanicLimit(n int) *Store {
+	atomic.StoreInt64(&sto.reducerPanicLimit, int64(n))
+	return sto
+}
+
+// isolatedReduction calls the reduction of reducer r, recovering from panics.
+//
+// If r panics, the changes it made to the state are discarded and the following reducers are still called.
+func (sto *Store) isolatedReduction(mx *Ctx, r Reducer) (res *Ctx) {
+	if sto.rswitch.isDisabled(r) {
+		return mx
+	}
+	rt := r.reducerType()
+	if name, ok := sto.quarantine.disabled(rt); ok {
+		return mx.SetState(mx.AddStatus(quarantineNotice(name)))
+	}
+
+	stopProfile := sto.rprof.start(r)
+	defer func() {
+		stopProfile()
+		v := recover()
+		if v == nil {
+			sto.quarantine.reset(rt)
+			return
+		}
+		pe := newPanicError(v)
+		name := ReducerLabel(r)
+		act := ActionLabel(mx.Action)
+		sto.ag.Log.Printf("reducer %s: %s while handling action %s\n%s", name, pe, act, pe.stack)
+		res = mx.SetState(mx.AddErrorf("reducer %s: %s while handling action %s\n%s", name, pe, act, pe.stack))
+		res.panicked = pe
+
+		limit := int(atomic.LoadInt64(&sto.reducerPanicLimit))
+		if sto.quarantine.panicked(rt, name, limit) {
+			sto.ag.Log.Printf("reducer %s disabled after %d consecutive panics\n", name, limit)
+			res = res.SetState(res.AddStatus(quarantineNotice(name)))
+		}
+	}()
+	return timedReduction(mx, r)
+}
+
+// quarantineNotice returns the status message displayed while the reducer named name is disabled
+func quarantineNotice(name string) string {
+	return name + " disabled: it panicked too many times"
+}
+
+// panicked records a panic of rt and reports whether it was disabled as a result
+func (rq *reducerQuarantine) panicked(rt *ReducerType, name string, limit int) bool {
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
+
+	if rq.panics == nil {
+		rq.panics = map[*ReducerType]int{}
+		rq.labels = map[*ReducerType]string{}
+	}
+	rq.panics[rt]++
+	if limit <= 0 || rq.panics[rt] < limit {
+		return false
+	}
+	rq.labels[rt] = name
+	return true
+}
+
+// reset clears the count of consecutive panics of rt
+func (rq *reducerQuarantine) reset(rt *ReducerType) {
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
+
+	if rq.panics[rt] != 0 {
+		delete(rq.panics, rt)
+	}
+}
+
+// disabled returns the label of rt and whether it was disabled
+func (rq *reducerQuarantine) disabled(rt *ReducerType) (string, bool) {
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
+
+	name, ok := rq.labels[rt]
+	return name, ok
+}
+
+// release re-enables the reducers labelled name, and clears their count of consecutive panics
+func (rq *reducerQuarantine) release(name string) {
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
+
+	for rt, s := range rq.labels {
+		if s == name {
+			delete(rq.labels, rt)
+			delete(rq.panics, rt)
+		}
+	}
+}
+
+// list returns the sorted list of labels of the disabled reducers
+func (rq *reducerQuarantine) list() []string {
+	rq.mu.Lock()
+	defer rq.mu.Unlock()
+
+	l := make([]string, 0, len(rq.labels))
+	for _, name := range rq.labels {
+		l = append(l, name)
+	}
+	sort.Strings(l)
+	return l
+}
@@ -0,0 +1,55 @@
+package mg
+
+import (
+	"margo.sh/mg/actions"
+	"strings"
+	"testing"
+)
+
+func TestStoreReducerQuarantine(t *testing.T) {
+	ag := NewTestingAgent(nil, nil, nil)
+	ag.Store.SetReducerPanicLimit(2)
+	panics := 0
+	ag.Store.Use(NewReducer(func(mx *Ctx) *State {
+		if mx.ActionIs(QueryIssues{}) {
+			panics++
+			panic("reducer exploded")
+		}
+		return mx.State
+	}, func(rf *RFunc) { rf.Label = "buggy-reducer" }))
+	var last *State
+	ag.Store.Use(NewReducer(func(mx *Ctx) *State {
+		if mx.ActionIs(QueryIssues{}) {
+			last = mx.State
+		}
+		return mx.State
+	}))
+
+	for i := 1; i <= 3; i++ {
+		last = nil
+		rq := newAgentReq(ag.Store)
+		rq.Cookie = "quarantine"
+		rq.Actions = []actions.ActionData{{Name: "QueryIssues"}}
+		rq.finalize(ag)
+		ag.Store.handleReq(rq)
+
+		if last == nil {
+			t.Fatalf("request %d: the reducer after the panicking reducer wasn't called", i)
+		}
+		if i < 3 {
+			if len(last.Errors) != 1 || !strings.Contains(last.Errors[0], "reducer exploded") || !strings.Contains(last.Errors[0], "goroutine") {
+				t.Errorf("request %d: Errors = (%q); want the panic and its stack trace", i, last.Errors)
+			}
+		}
+	}
+
+	if panics != 2 {
+		t.Errorf("the reducer was called %d times; want 2", panics)
+	}
+	if want := quarantineNotice("buggy-reducer"); !last.Status.Has(want) {
+		t.Errorf("Status = (%q); want (%q)", last.Status, want)
+	}
+	if l := ag.status().DisabledReducers; len(l) != 1 || l[0] != "buggy-reducer" {
+		t.Errorf("DisabledReducers = (%q); want ([buggy-reducer])", l)
+	}
+}
@@ -0,0 +1,143 @@
+package mg
+
+import (
+	"sync"
+	"time"
+)
+
+// Debounce returns a reducer that delays calling r for the actions acts, until none was dispatched for duration d.
+// Only the last of those actions is passed to r. If acts is empty, it defaults to ViewModified.
+//
+// Other actions are passed to r immediately. If a delayed action is pending when ViewSaved,
+// Shutdown or the agent is shutting down, r is called for it first, so it's not lost.
+//
+// When the delay expires, r is called with the current state, as part of an internal action,
+// so the state it returns is sent to the client.
+func Debounce(d time.Duration, r Reducer, acts ...Action) Reducer {
+	return newRateLimiter("mg.Debounce", d, r, acts, true)
+}
+
+// Throttle returns a reducer that calls r at most once every duration d for the actions acts.
+// If acts is empty, it defaults to ViewModified.
+//
+// When an action is dropped because r was called too recently, r is called for the last dropped action
+// once d has passed, so r always sees the last of a burst of actions.
+// Other actions, and flushing, are handled like Debounce.
+func Throttle(d time.Duration, r Reducer, acts ...Action) Reducer {
+	return newRateLimiter("mg.Throttle", d, r, acts, false)
+}
+
+// rateLimitFlush is dispatched when the action delayed by rl should be passed to its reducer
+type rateLimitFlush struct {
+	ActionType
+
+	rl  *rateLimiter
+	gen int
+}
+
+// rateLimiter implements Debounce and Throttle
+type rateLimiter struct {
+	reducerWrapper
+
+	name     string
+	d        time.Duration
+	acts     []Action
+	debounce bool
+
+	mu      sync.Mutex
+	pending Action
+	timer   *time.Timer
+	last    time.Time
+
+	// gen identifies the last flush scheduled, so stale flushes are ignored
+	gen int
+}
+
+func newRateLimiter(name string, d time.Duration, r Reducer, acts []Action, debounce bool) *rateLimiter {
+	if len(acts) == 0 {
+		acts = []Action{ViewModified{}}
+	}
+	return &rateLimiter{reducerWrapper: reducerWrapper{r: r}, name: name, d: d, acts: acts, debounce: debounce}
+}
+
+func (rl *rateLimiter) RLabel() string {
+	return rl.name + "(" + ReducerLabel(rl.r) + ")"
+}
+
+// RUnmount passes the pending action, then the unmount action, to r
+func (rl *rateLimiter) RUnmount(mx *Ctx) {
+	mx = mx.SetState(rl.flush(mx, -1))
+	rl.call(mx)
+}
+
+func (rl *rateLimiter) Reduce(mx *Ctx) *State {
+	switch act := mx.Action.(type) {
+	case rateLimitFlush:
+		if act.rl != rl {
+			return mx.State
+		}
+		return rl.flush(mx, act.gen)
+	}
+
+	if mx.ActionIs(rl.acts...) {
+		if rl.delay(mx) {
+			return mx.State
+		}
+		return rl.call(mx)
+	}
+
+	if mx.ActionIs(ViewSaved{}, Shutdown{}) {
+		mx = mx.SetState(rl.flush(mx, -1))
+	}
+	return rl.call(mx)
+}
+
+// flush calls r for the pending action, if any.
+// If gen isn't negative, the action is only passed to r if it's the one scheduled with that gen.
+func (rl *rateLimiter) flush(mx *Ctx, gen int) *State {
+	rl.mu.Lock()
+	act := rl.pending
+	if act == nil || (gen >= 0 && gen != rl.gen) {
+		rl.mu.Unlock()
+		return mx.State
+	}
+	rl.pending = nil
+	rl.last = time.Now()
+	if rl.timer != nil {
+		rl.timer.Stop()
+	}
+	rl.mu.Unlock()
+
+	return rl.call(mx.Copy(func(mx *Ctx) { mx.Action = act }))
+}
+
+// delay returns true if mx.Action should be delayed instead of being passed to r now
+func (rl *rateLimiter) delay(mx *Ctx) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	if !rl.debounce && rl.pending == nil && now.Sub(rl.last) >= rl.d {
+		rl.last = now
+		return false
+	}
+
+	wait := rl.d
+	if !rl.debounce {
+		if rl.pending != nil {
+			// the trailing call is already scheduled
+			rl.pending = mx.Action
+			return true
+		}
+		wait = rl.last.Add(rl.d).Sub(now)
+	}
+
+	rl.pending = mx.Action
+	rl.gen++
+	if rl.timer != nil {
+		rl.timer.Stop()
+	}
+	sto, flush := mx.Store, rateLimitFlush{rl: rl, gen: rl.gen}
+	rl.timer = time.AfterFunc(wait, func() { sto.Dispatch(flush) })
+	return true
+}
@@ -0,0 +1,64 @@
+package mg
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDebounceThrottle(t *testing.T) {
+	newAgent := func(wrap func(Reducer) Reducer) (*Agent, *[]string) {
+		ag := NewTestingAgent(nil, nil, nil)
+		seen := &[]string{}
+		ag.Store.Use(wrap(NewReducer(func(mx *Ctx) *State {
+			switch mx.Action.(type) {
+			case ViewModified, ViewSaved, QueryIssues:
+				*seen = append(*seen, ActionLabel(mx.Action))
+			}
+			return mx.State
+		}, func(rf *RFunc) {
+			rf.Unmount = func(*Ctx) { *seen = append(*seen, "unmount") }
+		})))
+		ag.Store.handleAct(initAction{}, nil)
+		return ag, seen
+	}
+	check := func(name string, seen *[]string, want string) {
+		t.Helper()
+		if s := strings.Join(*seen, " "); s != want {
+			t.Errorf("%s: the reducer saw (%s); want (%s)", name, s, want)
+		}
+		*seen = nil
+	}
+
+	ag, seen := newAgent(func(r Reducer) Reducer { return Debounce(20*time.Millisecond, r) })
+	for i := 0; i < 3; i++ {
+		ag.Store.handleAct(ViewModified{}, nil)
+	}
+	ag.Store.handleAct(QueryIssues{}, nil)
+	check("Debounce before the delay", seen, "mg.QueryIssues")
+	(<-ag.Store.dsp.lo)()
+	check("Debounce after the delay", seen, "mg.ViewModified")
+	ag.Store.handleAct(ViewModified{}, nil)
+	ag.Store.handleAct(ViewSaved{}, nil)
+	check("Debounce flushed by ViewSaved", seen, "mg.ViewModified mg.ViewSaved")
+	time.Sleep(40 * time.Millisecond)
+	select {
+	case <-ag.Store.dsp.lo:
+		t.Error("Debounce: the flushed action was dispatched again")
+	default:
+	}
+	ag.Store.handleAct(ViewModified{}, nil)
+	ag.Store.handleAct(unmount{}, nil)
+	check("Debounce flushed by unmount", seen, "mg.ViewModified unmount")
+
+	ag, seen = newAgent(func(r Reducer) Reducer { return Throttle(20*time.Millisecond, r) })
+	for i := 0; i < 3; i++ {
+		ag.Store.handleAct(ViewModified{}, nil)
+	}
+	check("Throttle before the delay", seen, "mg.ViewModified")
+	(<-ag.Store.dsp.lo)()
+	check("Throttle after the delay", seen, "mg.ViewModified")
+	if s := ag.Store.reducerLabels(); !StrSet(s).Has("mg.Throttle(mg.Reduce(margo.sh/mg.TestDebounceThrottle.func1.1))") {
+		t.Errorf("reducer labels = (%q); want the Throttle wrapper's label", s)
+	}
+}
@@ -0,0 +1,89 @@
+package mg
+
+import (
+	"margo.sh/mg/actions"
+	"os"
+	"time"
+)
+
+// Restarting is the client action dispatched when the agent is about to restart itself
+// because its executable was rebuilt. See AgentConfig.RestartOnRebuild
+//
+// The new agent starts when the next request is received.
+// Clients should resync their views once it responds.
+type Restarting struct{ ActionType }
+
+func (r Restarting) ClientAction() actions.ClientData {
+	return actions.ClientData{Name: "Restarting"}
+}
+
+// exeStamp identifies a version of the agent's executable
+type exeStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+func statExe(fn string) (exeStamp, bool) {
+	fi, err := os.Stat(fn)
+	if err != nil {
+		return exeStamp{}, false
+	}
+	return exeStamp{size: fi.Size(), modTime: fi.ModTime()}, true
+}
+
+// watchRebuild starts watching the agent's executable and restarts the agent when it's rebuilt.
+//
+// The returned function stops watching.
+func (ag *Agent) watchRebuild() (stop func()) {
+	if ag.restartOnRebuild <= 0 {
+		return func() {}
+	}
+	exe, err := ag.executable()
+	if err != nil {
+		ag.Log.Println("restart-on-rebuild: disabled:", err)
+		return func() {}
+	}
+	return ag.watchExe(exe, ag.restartOnRebuild)
+}
+
+// watchExe checks the executable exe every interval and restarts the agent when it changes.
+//
+// Changes are only acted on once they've settled for an interval, so a partially written file isn't exec'd.
+func (ag *Agent) watchExe(exe string, interval time.Duration) (stop func()) {
+	stopC := make(chan struct{})
+	go func() {
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+
+		orig, _ := statExe(exe)
+		prev := orig
+		for {
+			select {
+			case <-stopC:
+				return
+			case <-tick.C:
+			}
+
+			cur, ok := statExe(exe)
+			switch {
+			case !ok || cur == orig:
+			case cur != prev:
+				prev = cur
+			default:
+				ag.Log.Println("restart-on-rebuild: the agent executable was rebuilt:", exe)
+				ag.restartRebuilt()
+				return
+			}
+		}
+	}()
+	return func() { close(stopC) }
+}
+
+// restartRebuilt restarts the agent after its executable was rebuilt
+func (ag *Agent) restartRebuilt() {
+	if ag.requestHandoff() {
+		ag.Store.Notify(Restarting{})
+		return
+	}
+	ag.Store.Dispatch(Restart{})
+}
@@ -0,0 +1,166 @@
+package mg
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"margo.sh/mgutil"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	recordHeader   = "header"
+	recordRequest  = "request"
+	recordResponse = "response"
+)
+
+// recordEntry is a single line in a session recording.
+//
+// The first entry is always a header, describing the codec and protocol of the session.
+// It's followed by the requests and responses, in the order they were received or sent.
+// Data is the message exactly as it was sent, after decompression.
+type recordEntry struct {
+	Kind     string
+	Time     time.Time
+	Client   int    `json:",omitempty"`
+	Codec    string `json:",omitempty"`
+	Protocol string `json:",omitempty"`
+	Data     []byte `json:",omitempty"`
+}
+
+// recorder writes a session recording to a file. See AgentConfig.RecordTo
+type recorder struct {
+	mu  sync.Mutex
+	f   *os.File
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func newRecorder(fn, codecName, protocol string) (*recorder, error) {
+	f, err := os.Create(fn)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot create session recording: %s", err)
+	}
+	rec := &recorder{f: f, w: bufio.NewWriter(f)}
+	rec.enc = json.NewEncoder(rec.w)
+	rec.write(recordEntry{Kind: recordHeader, Codec: codecName, Protocol: protocol})
+	return rec, nil
+}
+
+// record adds a message sent by, or to, client c to the recording
+func (rec *recorder) record(c *agentClient, kind string, data []byte) {
+	e := recordEntry{Kind: kind, Data: data}
+	if c != nil {
+		e.Client = c.id
+	}
+	rec.write(e)
+}
+
+func (rec *recorder) write(e recordEntry) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	e.Time = time.Now()
+	rec.enc.Encode(e)
+	rec.w.Flush()
+}
+
+func (rec *recorder) close() error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	rec.w.Flush()
+	return rec.f.Close()
+}
+
+// recordReader records the bytes consumed by a decoder
+// so that each request can be recorded exactly as it was sent
+//
+// It implements io.ByteScanner so the decoder doesn't read ahead.
+type recordReader struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+func (rr *recordReader) Read(p []byte) (int, error) {
+	n, err := rr.r.Read(p)
+	rr.buf = append(rr.buf, p[:n]...)
+	return n, err
+}
+
+func (rr *recordReader) ReadByte() (byte, error) {
+	b, err := rr.r.ReadByte()
+	if err == nil {
+		rr.buf = append(rr.buf, b)
+	}
+	return b, err
+}
+
+func (rr *recordReader) UnreadByte() error {
+	err := rr.r.UnreadByte()
+	if err == nil && len(rr.buf) != 0 {
+		rr.buf = rr.buf[:len(rr.buf)-1]
+	}
+	return err
+}
+
+// take returns the bytes consumed since the last call
+func (rr *recordReader) take() []byte {
+	p := rr.buf
+	rr.buf = nil
+	return p
+}
+
+// Replay feeds the requests in the session recording read from r into a new agent
+// and writes its responses to out.
+//
+// The agent is created using cfg, with the codec and protocol of the recorded session.
+// If setup is not nil, it's called before the agent is started.
+func Replay(r io.Reader, out io.Writer, cfg AgentConfig, setup func(*Agent)) error {
+	dec := json.NewDecoder(r)
+	hdr := recordEntry{}
+	if err := dec.Decode(&hdr); err != nil || hdr.Kind != recordHeader {
+		return fmt.Errorf("replay: invalid session recording header: %v", err)
+	}
+
+	inR, inW := io.Pipe()
+	cfg.Stdin = inR
+	cfg.Stdout = &mgutil.IOWrapper{Writer: out}
+	cfg.Codec = hdr.Codec
+	cfg.Protocol = hdr.Protocol
+	cfg.Listen = ""
+	cfg.RecordTo = ""
+	ag, err := NewAgent(cfg)
+	if err != nil {
+		return fmt.Errorf("replay: %s", err)
+	}
+	if setup != nil {
+		setup(ag)
+	}
+
+	go func() {
+		defer inW.Close()
+		for {
+			e := recordEntry{}
+			if err := dec.Decode(&e); err != nil {
+				if err != io.EOF {
+					ag.Log.Println("replay: cannot read session recording:", err)
+				}
+				return
+			}
+			if e.Kind != recordRequest {
+				continue
+			}
+			if hdr.Protocol == ProtocolJSONRPC {
+				fmt.Fprintf(inW, "Content-Length: %d\r\n\r\n", len(e.Data))
+			}
+			if _, err := inW.Write(e.Data); err != nil {
+				return
+			}
+		}
+	}()
+	return ag.Run()
+}
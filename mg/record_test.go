@@ -0,0 +1,84 @@
+package mg
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ugorji/go/codec"
+	"io"
+	"io/ioutil"
+	"margo.sh/mgutil"
+	"os"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	f, err := ioutil.TempFile("", "margo-record-")
+	if err != nil {
+		t.Fatalf("ioutil.TempFile(): %s", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	inR, inW := io.Pipe()
+	ag, err := NewAgent(AgentConfig{
+		Stdin:    inR,
+		Stdout:   &mgutil.IOWrapper{},
+		Stderr:   &mgutil.IOWrapper{},
+		Codec:    "msgpack",
+		RecordTo: f.Name(),
+	})
+	if err != nil {
+		t.Fatalf("agent creation failed: %s", err)
+	}
+	runErr := make(chan error, 1)
+	go func() { runErr <- ag.Run() }()
+
+	cookies := []string{"first", "second"}
+	enc := codec.NewEncoder(inW, ag.handle)
+	for _, cookie := range cookies {
+		if err := enc.Encode(map[string]string{"Cookie": cookie}); err != nil {
+			t.Fatalf("enc.Encode(): %s", err)
+		}
+	}
+	inW.Close()
+	if err := <-runErr; err != nil {
+		t.Fatalf("ag.Run() = (%v); want (nil)", err)
+	}
+
+	src, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatalf("ioutil.ReadFile(): %s", err)
+	}
+	counts := map[string]int{}
+	dec := json.NewDecoder(bytes.NewReader(src))
+	for {
+		e := recordEntry{}
+		if err := dec.Decode(&e); err != nil {
+			break
+		}
+		counts[e.Kind]++
+	}
+	if counts[recordHeader] != 1 || counts[recordRequest] != len(cookies) || counts[recordResponse] < len(cookies) {
+		t.Fatalf("recording entries = (%v); want 1 header, %d requests and their responses", counts, len(cookies))
+	}
+
+	out := &bytes.Buffer{}
+	err = Replay(bytes.NewReader(src), out, AgentConfig{Stderr: &mgutil.IOWrapper{}}, nil)
+	if err != nil {
+		t.Fatalf("Replay() = (%v); want (nil)", err)
+	}
+	seen := map[string]bool{}
+	resDec := codec.NewDecoder(out, ag.handle)
+	for {
+		var res struct{ Cookie string }
+		if err := resDec.Decode(&res); err != nil {
+			break
+		}
+		seen[res.Cookie] = true
+	}
+	for _, cookie := range cookies {
+		if !seen[cookie] {
+			t.Errorf("replay: no response for request %s", cookie)
+		}
+	}
+}
@@ -66,9 +66,15 @@ func (dr *defaultReducers) After(l ...Reducer) {
 //
 // The methods are called in the order listed below:
 //
+// * RRunAfter and RRunBefore
+//   these are called when the reducer is registered, to order it relative to other reducers
+//
 // * RInit
 //   this is called during the first action (initAction{} FKA Started{})
 //
+// * RClientConnected, RViewClosed and RConfigChanged
+//   these are called during the ClientConnected, ViewClosed and ConfigChanged actions respectively
+//
 // * RConfig
 //   this is called on each reduction
 //
@@ -165,6 +171,36 @@ type Reducer interface {
 	RUnmount(*Ctx)
 	ReducerUnmount(*Ctx)
 
+	// RClientConnected is called when a client connects to the agent (see ClientConnected)
+	// * like RInit, it's called before RConfig() and RCond()
+	RClientConnected(*Ctx)
+
+	// RViewClosed is called when the view mx.View is closed (see ViewClosed)
+	// * it can be used to release the resources held for the view
+	// * like RInit, it's called before RConfig() and RCond()
+	RViewClosed(*Ctx)
+
+	// RConfigChanged is called when State.Config changes (see ConfigChanged)
+	// * like RInit, it's called before RConfig() and RCond()
+	RConfigChanged(*Ctx)
+
+	// RRunAfter returns the labels of the reducers that must be called before this reducer
+	// if they're registered. See Store.Before for details about the order of reducers
+	RRunAfter() []string
+
+	// RRunBefore returns the labels of the reducers that must be called after this reducer
+	// if they're registered. See Store.Before for details about the order of reducers
+	RRunBefore() []string
+
+	// RParallel returns true if the reducer may be called concurrently with other such reducers
+	//
+	// The reducer should only read the state and add status messages, errors, issues, completions,
+	// tooltips, commands and client actions to it, e.g. a linter that returns its cached issues.
+	// Consecutive reducers (see Store.Before) that return true are passed the same Ctx
+	// and the additions they make to the state are merged, in the order the reducers are called.
+	// Other changes they make to the state are discarded.
+	RParallel() bool
+
 	reducerType() *ReducerType
 }
 
@@ -213,6 +249,24 @@ func (rt *ReducerType) RUnmount(mx *Ctx) { rt.r().ReducerUnmount(mx) }
 // ReducerUnmount implements Reducer.ReducerUnmount
 func (rt *ReducerType) ReducerUnmount(*Ctx) {}
 
+// RClientConnected implements Reducer.RClientConnected
+func (rt *ReducerType) RClientConnected(*Ctx) {}
+
+// RViewClosed implements Reducer.RViewClosed
+func (rt *ReducerType) RViewClosed(*Ctx) {}
+
+// RConfigChanged implements Reducer.RConfigChanged
+func (rt *ReducerType) RConfigChanged(*Ctx) {}
+
+// RRunAfter implements Reducer.RRunAfter
+func (rt *ReducerType) RRunAfter() []string { return nil }
+
+// RRunBefore implements Reducer.RRunBefore
+func (rt *ReducerType) RRunBefore() []string { return nil }
+
+// RParallel implements Reducer.RParallel
+func (rt *ReducerType) RParallel() bool { return false }
+
 func (rt *ReducerType) r() Reducer {
 	if rt.parent != nil {
 		return rt.parent
@@ -222,6 +276,42 @@ func (rt *ReducerType) r() Reducer {
 
 func (rt *ReducerType) reducerType() *ReducerType { return rt }
 
+// reducerLocks holds the mutexes used to serialize the reductions of each reducer of a store,
+// so reducers are never called concurrently, even when a reducer times out (see Store.SetReducerTimeout)
+// and the next reduction starts while it's still running.
+//
+// The mutexes are not stored in ReducerType because reducers are commonly copied by value.
+// They're dropped when the reducer is unmounted, and with the store.
+type reducerLocks struct {
+	mu sync.Mutex
+	m  map[*ReducerType]*sync.Mutex
+}
+
+// lock locks and returns the mutex of rt
+func (rl *reducerLocks) lock(rt *ReducerType) *sync.Mutex {
+	rl.mu.Lock()
+	mu := rl.m[rt]
+	if mu == nil {
+		if rl.m == nil {
+			rl.m = map[*ReducerType]*sync.Mutex{}
+		}
+		mu = &sync.Mutex{}
+		rl.m[rt] = mu
+	}
+	rl.mu.Unlock()
+
+	mu.Lock()
+	return mu
+}
+
+// drop forgets the mutex of rt
+func (rl *reducerLocks) drop(rt *ReducerType) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	delete(rl.m, rt)
+}
+
 func (rt *ReducerType) bootstrap(parent Reducer) {
 	switch {
 	case rt.parent == nil:
@@ -232,11 +322,20 @@ func (rt *ReducerType) bootstrap(parent Reducer) {
 }
 
 func (rt *ReducerType) reduction(mx *Ctx, r Reducer) *Ctx {
+	locks := &mx.Store.reducerLocks
+	mu := locks.lock(rt)
+	defer mu.Unlock()
+	if mx.ActionIs(unmount{}) {
+		// the reducer is being removed from the store, so its mutex won't be needed anymore
+		defer locks.drop(rt)
+	}
+
 	rt.bootstrap(r)
 
 	defer mx.Profile.Push(ReducerLabel(r)).Pop()
 
 	rt.init(mx)
+	rt.hooks(mx)
 
 	if c := rt.config(mx); c != nil {
 		mx = mx.SetState(mx.State.SetConfig(c))
@@ -266,6 +365,21 @@ func (rt *ReducerType) init(mx *Ctx) {
 	rt.r().RInit(mx)
 }
 
+// hooks calls the lifecycle hook corresponding to mx.Action, if any
+func (rt *ReducerType) hooks(mx *Ctx) {
+	switch mx.Action.(type) {
+	case ClientConnected:
+		defer mx.Profile.Push("ClientConnected").Pop()
+		rt.r().RClientConnected(mx)
+	case ViewClosed:
+		defer mx.Profile.Push("ViewClosed").Pop()
+		rt.r().RViewClosed(mx)
+	case ConfigChanged:
+		defer mx.Profile.Push("ConfigChanged").Pop()
+		rt.r().RConfigChanged(mx)
+	}
+}
+
 func (rt *ReducerType) config(mx *Ctx) EditorConfig {
 	defer mx.Profile.Push("Config").Pop()
 	return rt.r().RConfig(mx)
@@ -311,12 +425,50 @@ func (rl reducerList) Add(reducers ...Reducer) reducerList {
 type reducerList []Reducer
 
 func (rl reducerList) reduction(mx *Ctx) *Ctx {
-	for _, r := range rl {
-		mx = r.reducerType().reduction(mx, r)
+	for i := 0; i < len(rl); {
+		j := i + 1
+		if rl[i].RParallel() {
+			for j < len(rl) && rl[j].RParallel() {
+				j++
+			}
+		}
+		prev := mx.State
+		if j-i > 1 {
+			mx = parallelReduction(mx, rl[i:j])
+		} else {
+			mx = mx.Store.isolatedReduction(mx, rl[i])
+		}
+		mx.changes.observe(prev, mx.State)
+		i = j
 	}
 	return mx
 }
 
+// reducerWrapper is embedded by reducers that wrap another reducer r.
+//
+// r is called with its full lifecycle, as if it was registered with the store, when the wrapper calls it.
+type reducerWrapper struct {
+	ReducerType
+	r Reducer
+}
+
+// RRunAfter implements Reducer.RRunAfter, delegating to r
+func (rw *reducerWrapper) RRunAfter() []string { return rw.r.RRunAfter() }
+
+// RRunBefore implements Reducer.RRunBefore, delegating to r
+func (rw *reducerWrapper) RRunBefore() []string { return rw.r.RRunBefore() }
+
+// RParallel implements Reducer.RParallel, delegating to r
+func (rw *reducerWrapper) RParallel() bool { return rw.r.RParallel() }
+
+// RUnmount passes the unmount action to r, since Reduce isn't called for it
+func (rw *reducerWrapper) RUnmount(mx *Ctx) { rw.call(mx) }
+
+// call calls r to handle mx.Action
+func (rw *reducerWrapper) call(mx *Ctx) *State {
+	return rw.r.reducerType().reduction(mx, rw.r).State
+}
+
 // RFunc wraps a function to be used as a reducer
 // New instances should ideally be created using the global NewReducer() function
 type RFunc struct {
@@ -346,6 +498,24 @@ type RFunc struct {
 
 	// RUnount is the equivalent of Reducer.RUnmount
 	Unmount func(mx *Ctx)
+
+	// ClientConnected is the equivalent of Reducer.RClientConnected
+	ClientConnected func(mx *Ctx)
+
+	// ViewClosed is the equivalent of Reducer.RViewClosed
+	ViewClosed func(mx *Ctx)
+
+	// ConfigChanged is the equivalent of Reducer.RConfigChanged
+	ConfigChanged func(mx *Ctx)
+
+	// RunAfter is the equivalent of Reducer.RRunAfter
+	RunAfter []string
+
+	// RunBefore is the equivalent of Reducer.RRunBefore
+	RunBefore []string
+
+	// Parallel is the equivalent of Reducer.RParallel
+	Parallel bool
 }
 
 // ReduceFunc is an alias for RFunc
@@ -409,6 +579,36 @@ func (rf *RFunc) RUnmount(mx *Ctx) {
 	}
 }
 
+// RClientConnected delegates to RFunc.ClientConnected if it's not nil
+func (rf *RFunc) RClientConnected(mx *Ctx) {
+	if rf.ClientConnected != nil {
+		rf.ClientConnected(mx)
+	}
+}
+
+// RViewClosed delegates to RFunc.ViewClosed if it's not nil
+func (rf *RFunc) RViewClosed(mx *Ctx) {
+	if rf.ViewClosed != nil {
+		rf.ViewClosed(mx)
+	}
+}
+
+// RConfigChanged delegates to RFunc.ConfigChanged if it's not nil
+func (rf *RFunc) RConfigChanged(mx *Ctx) {
+	if rf.ConfigChanged != nil {
+		rf.ConfigChanged(mx)
+	}
+}
+
+// RRunAfter returns RFunc.RunAfter
+func (rf *RFunc) RRunAfter() []string { return rf.RunAfter }
+
+// RRunBefore returns RFunc.RunBefore
+func (rf *RFunc) RRunBefore() []string { return rf.RunBefore }
+
+// RParallel returns RFunc.Parallel
+func (rf *RFunc) RParallel() bool { return rf.Parallel }
+
 // Reduce implements the Reducer interface, delegating to RFunc.Func if it's not nil
 func (rf *RFunc) Reduce(mx *Ctx) *State {
 	if rf.Func != nil {
@@ -0,0 +1,58 @@
+package mg
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testEditorConfig string
+
+func (tc testEditorConfig) EditorConfig() interface{} { return string(tc) }
+
+func (tc testEditorConfig) EnabledForLangs(...Lang) EditorConfig { return tc }
+
+func TestLifecycleHooks(t *testing.T) {
+	cfg := testEditorConfig("a")
+	var seen []string
+	h := NewHarness(t, NewReducer(nil, func(rf *RFunc) {
+		rf.Config = func(*Ctx) EditorConfig { return cfg }
+		rf.ClientConnected = func(mx *Ctx) {
+			seen = append(seen, fmt.Sprintf("connected:%d", mx.Action.(ClientConnected).ClientID))
+		}
+		rf.ViewClosed = func(mx *Ctx) { seen = append(seen, "closed:"+mx.View.Name) }
+		rf.ConfigChanged = func(mx *Ctx) { seen = append(seen, "config:"+string(mx.Config.(testEditorConfig))) }
+	}))
+	sto := h.Store
+
+	h.Dispatch(ClientConnected{ClientID: 1})
+	h.Open("/src/main.go", "package main", 0)
+	sto.ViewState("main.go").Put("k", "v")
+	sto.ViewState("other.go").Put("k", "v")
+	h.Dispatch(ViewClosed{})
+	if v := sto.ViewState("main.go").Get("k"); v != nil {
+		t.Errorf("ViewState(main.go) was not dropped after ViewClosed: k = %v", v)
+	}
+	if v := sto.ViewState("other.go").Get("k"); v != "v" {
+		t.Errorf("ViewState(other.go) was dropped after ViewClosed: k = %v", v)
+	}
+
+	select {
+	case <-sto.dsp.lo:
+		t.Error("an action was dispatched although the config didn't change")
+	default:
+	}
+	cfg = "b"
+	h.Dispatch(Render)
+	select {
+	case f := <-sto.dsp.lo:
+		f()
+	case <-time.After(time.Second):
+		t.Fatal("ConfigChanged was not dispatched after the config changed")
+	}
+
+	if s, want := strings.Join(seen, " "), "connected:1 closed:main.go config:b"; s != want {
+		t.Errorf("the hooks saw (%s); want (%s)", s, want)
+	}
+}
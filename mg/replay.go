@@ -0,0 +1,144 @@
+package mg
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ugorji/go/codec"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultReplayLogSize is the default number of actions kept in the replay log. See Store.SetReplayLogSize
+	DefaultReplayLogSize = 64
+)
+
+var (
+	// replayHandle encodes the actions in the replay log
+	replayHandle = &codec.JsonHandle{}
+)
+
+// replayEntry describes an action in the replay log
+type replayEntry struct {
+	time    time.Time
+	act     Action
+	cookie  string
+	traceID string
+}
+
+// replayLog keeps a ring buffer of the most recently dispatched actions,
+// so they can be reported if the agent crashes
+type replayLog struct {
+	mu      sync.Mutex
+	size    int
+	next    int
+	entries []replayEntry
+}
+
+// SetReplayLogSize sets the number of actions kept in the replay log.
+//
+// When the agent crashes, the replay log, including the name and payload of each action,
+// is written to its log along with the stack trace, so the failure can be reproduced.
+// The actions are only encoded when the log is written, so keeping them costs little.
+// If n <= 0, the replay log is disabled and cleared.
+// Default: DefaultReplayLogSize
+func (sto *Store) SetReplayLogSize(n int) *Store {
+	rl := &sto.replay
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	l := rl.list()
+	if n <= 0 {
+		l = nil
+	} else if len(l) > n {
+		l = l[len(l)-n:]
+	}
+	rl.size = n
+	rl.entries = l
+	rl.next = 0
+	return sto
+}
+
+// record adds the action about to be reduced by mx to the log
+func (rl *replayLog) record(mx *Ctx) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if rl.size <= 0 {
+		return
+	}
+	re := replayEntry{
+		time:    time.Now(),
+		act:     mx.Action,
+		cookie:  mx.Cookie,
+		traceID: mx.TraceID,
+	}
+	if len(rl.entries) < rl.size {
+		rl.entries = append(rl.entries, re)
+		return
+	}
+	rl.entries[rl.next] = re
+	rl.next = (rl.next + 1) % rl.size
+}
+
+// list returns the entries, oldest first. rl.mu must be held
+func (rl *replayLog) list() []replayEntry {
+	l := make([]replayEntry, 0, len(rl.entries))
+	l = append(l, rl.entries[rl.next:]...)
+	l = append(l, rl.entries[:rl.next]...)
+	return l
+}
+
+// dump writes the actions in the log, oldest first, to w
+func (rl *replayLog) dump(w io.Writer) {
+	rl.mu.Lock()
+	l := rl.list()
+	rl.mu.Unlock()
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "the last %d actions, oldest first:\n", len(l))
+	for _, re := range l {
+		fmt.Fprintf(buf, "%s %s", re.time.Format("15:04:05.000"), ActionLabel(re.act))
+		if re.cookie != "" {
+			fmt.Fprintf(buf, " Cookie=%s", re.cookie)
+		}
+		if re.traceID != "" {
+			fmt.Fprintf(buf, " TraceID=%s", re.traceID)
+		}
+		fmt.Fprintf(buf, " %s\n", encodeReplayAction(re.act))
+	}
+	w.Write(buf.Bytes())
+}
+
+// encodeReplayAction returns act encoded as JSON, or a description of the error if it can't be encoded
+func encodeReplayAction(act Action) (s string) {
+	defer func() {
+		if v := recover(); v != nil {
+			s = fmt.Sprintf("<cannot encode: panic: %v>", v)
+		}
+	}()
+
+	var p []byte
+	if err := codec.NewEncoderBytes(&p, replayHandle).Encode(act); err != nil {
+		return fmt.Sprintf("<cannot encode: %s>", err)
+	}
+	return string(p)
+}
+
+// guardCrash must be deferred at the start of the goroutines started by the agent to handle requests and actions.
+//
+// If the goroutine panics, the stack trace and the replay log are written to the agent's log,
+// then the panic continues, crashing the agent.
+func (ag *Agent) guardCrash() {
+	v := recover()
+	if v == nil {
+		return
+	}
+	pe := newPanicError(v)
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "the agent crashed: %s\n%s\n", pe, pe.stack)
+	ag.Store.replay.dump(buf)
+	ag.Log.Print(buf.String())
+	panic(v)
+}
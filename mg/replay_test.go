@@ -0,0 +1,51 @@
+package mg
+
+import (
+	"bytes"
+	"margo.sh/mgutil"
+	"strings"
+	"testing"
+)
+
+func TestReplayLog(t *testing.T) {
+	type replayAct struct {
+		ActionType
+		N int
+	}
+	logs := &bytes.Buffer{}
+	ag, err := NewAgent(AgentConfig{
+		Stdin:  &mgutil.IOWrapper{},
+		Stdout: &mgutil.IOWrapper{},
+		Stderr: &mgutil.IOWrapper{Writer: logs},
+	})
+	if err != nil {
+		t.Fatalf("agent creation failed: %s", err)
+	}
+	ag.Store.SetReplayLogSize(3)
+	for i := 1; i <= 4; i++ {
+		ag.Store.handleAct(replayAct{N: i}, nil)
+	}
+
+	func() {
+		defer func() {
+			if v := recover(); v != "dispatcher crashed" {
+				t.Errorf("guardCrash recovered (%v); want it to continue the panic", v)
+			}
+		}()
+		defer ag.guardCrash()
+		panic("dispatcher crashed")
+	}()
+
+	s := logs.String()
+	for _, want := range []string{"the agent crashed: panic: dispatcher crashed", "TestReplayLog", "the last 3 actions", `{"N":2}`, `{"N":4}`} {
+		if !strings.Contains(s, want) {
+			t.Errorf("the crash log doesn't contain (%s):\n%s", want, s)
+		}
+	}
+	if strings.Contains(s, `{"N":1}`) {
+		t.Errorf("the crash log contains the action that was dropped from the replay log:\n%s", s)
+	}
+	if i, j := strings.Index(s, `{"N":2}`), strings.Index(s, `{"N":4}`); i > j {
+		t.Errorf("the crash log isn't ordered oldest first:\n%s", s)
+	}
+}
@@ -0,0 +1,141 @@
+package mg
+
+import (
+	"sync"
+)
+
+// agentReqQueue handles requests concurrently using a pool of workers.
+//
+// Requests with the same Cookie, from the same client, are handled one at a time,
+// in the order they were received, so their responses are sent in that order.
+//
+// If depth is greater than zero, at most depth requests wait to be handled.
+// When a new request would exceed it, the oldest waiting request with the same actions
+// from the same client is dropped, so the new request supersedes it.
+// If there is no such request, the oldest waiting request is dropped.
+type agentReqQueue struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	waiting  []*agentReq
+	running  map[string]bool
+	closed   bool
+	depth    int
+	handle   func(*agentReq)
+	overflow func(*agentReq)
+}
+
+// newAgentReqQueue creates a new queue and starts the workers that call handle.
+// overflow is called for each request that's dropped.
+func newAgentReqQueue(workers, depth int, handle, overflow func(*agentReq)) *agentReqQueue {
+	q := &agentReqQueue{
+		running:  map[string]bool{},
+		depth:    depth,
+		handle:   handle,
+		overflow: overflow,
+	}
+	q.cond = sync.NewCond(&q.mu)
+	for i := 0; i < workers; i++ {
+		go q.worker()
+	}
+	return q
+}
+
+// put schedules rq to be handled.
+// If another request with the same Cookie and client is being handled, rq is handled after it.
+// It returns false if the queue is closed.
+func (q *agentReqQueue) put(rq *agentReq) bool {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return false
+	}
+	q.waiting = append(q.waiting, rq)
+	var dropped *agentReq
+	if q.depth > 0 && len(q.waiting) > q.depth {
+		dropped = q.drop(rq)
+	}
+	q.cond.Signal()
+	q.mu.Unlock()
+
+	if dropped != nil && q.overflow != nil {
+		q.overflow(dropped)
+	}
+	return true
+}
+
+// drop removes and returns the waiting request superseded by rq. It's called with q.mu held.
+func (q *agentReqQueue) drop(rq *agentReq) *agentReq {
+	i := -1
+	for j, p := range q.waiting {
+		if p != rq && p.client == rq.client && sameActions(p, rq) {
+			i = j
+			break
+		}
+	}
+	if i < 0 {
+		i = 0
+	}
+	p := q.waiting[i]
+	q.waiting = append(q.waiting[:i:i], q.waiting[i+1:]...)
+	return p
+}
+
+// sameActions returns true if a and b contain the same list of actions
+func sameActions(a, b *agentReq) bool {
+	if len(a.Actions) != len(b.Actions) {
+		return false
+	}
+	for i, ra := range a.Actions {
+		if ra.Name != b.Actions[i].Name {
+			return false
+		}
+	}
+	return true
+}
+
+// next waits for, and returns, the oldest waiting request whose key isn't being handled.
+// It returns nil if the queue is closed and there are no more requests.
+// It's called with q.mu held.
+func (q *agentReqQueue) next() *agentReq {
+	for {
+		for i, rq := range q.waiting {
+			if key := rq.key(); !q.running[key] {
+				q.running[key] = true
+				q.waiting = append(q.waiting[:i:i], q.waiting[i+1:]...)
+				return rq
+			}
+		}
+		if q.closed && len(q.waiting) == 0 {
+			return nil
+		}
+		q.cond.Wait()
+	}
+}
+
+// close stops the workers once all scheduled requests have been handled
+func (q *agentReqQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+func (q *agentReqQueue) worker() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		rq := q.next()
+		if rq == nil {
+			return
+		}
+
+		q.mu.Unlock()
+		q.handle(rq)
+		q.mu.Lock()
+
+		delete(q.running, rq.key())
+		q.cond.Broadcast()
+	}
+}
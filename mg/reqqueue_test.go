@@ -0,0 +1,105 @@
+package mg
+
+import (
+	"margo.sh/mg/actions"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAgentReqQueueOrdering(t *testing.T) {
+	var (
+		mu  sync.Mutex
+		got = map[string][]int{}
+		wg  sync.WaitGroup
+		seq = map[*agentReq]int{}
+	)
+	q := newAgentReqQueue(4, 0, func(rq *agentReq) {
+		defer wg.Done()
+		if rq.Cookie == "slow" {
+			time.Sleep(time.Millisecond)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		got[rq.Cookie] = append(got[rq.Cookie], seq[rq])
+	}, nil)
+
+	cookies := []string{"slow", "a", "b"}
+	reqs := make([]*agentReq, 30)
+	for i := range reqs {
+		reqs[i] = &agentReq{Cookie: cookies[i%len(cookies)]}
+		seq[reqs[i]] = i
+	}
+	for _, rq := range reqs {
+		wg.Add(1)
+		q.put(rq)
+	}
+	wg.Wait()
+	q.close()
+
+	for cookie, l := range got {
+		if len(l) != 10 {
+			t.Errorf("cookie %s: got %d requests; want 10", cookie, len(l))
+		}
+		for i := 1; i < len(l); i++ {
+			if l[i] < l[i-1] {
+				t.Errorf("cookie %s: requests handled out of order: %v", cookie, l)
+				break
+			}
+		}
+	}
+}
+
+func TestAgentReqQueueOverflow(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		handled []string
+		dropped []string
+		wg      sync.WaitGroup
+		started = make(chan struct{})
+		release = make(chan struct{})
+	)
+	q := newAgentReqQueue(1, 2, func(rq *agentReq) {
+		defer wg.Done()
+		if rq.Cookie == "block" {
+			close(started)
+			<-release
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		handled = append(handled, rq.Cookie)
+	}, func(rq *agentReq) {
+		defer wg.Done()
+		mu.Lock()
+		defer mu.Unlock()
+		dropped = append(dropped, rq.Cookie)
+	})
+
+	req := func(cookie, action string) *agentReq {
+		return &agentReq{Cookie: cookie, Actions: []actions.ActionData{{Name: action}}}
+	}
+	wg.Add(1)
+	q.put(req("block", "QueryIssues"))
+	<-started
+
+	for _, rq := range []*agentReq{
+		req("a1", "QueryCompletions"),
+		req("b", "ViewSaved"),
+		req("a2", "QueryCompletions"),
+		req("c", "ViewFmt"),
+	} {
+		wg.Add(1)
+		q.put(rq)
+	}
+	close(release)
+	wg.Wait()
+	q.close()
+
+	if s := strings.Join(dropped, ","); s != "a1,b" {
+		t.Errorf("dropped = (%s); want (a1,b)", s)
+	}
+	if s := strings.Join(handled, ","); s != "block,a2,c" {
+		t.Errorf("handled = (%s); want (block,a2,c)", s)
+	}
+}
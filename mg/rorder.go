@@ -0,0 +1,106 @@
+package mg
+
+import (
+	"fmt"
+	"strings"
+)
+
+// sortReducers returns the reducers in lists, in the order they should be called.
+//
+// Reducers are called in the order of lists, and the order they appear in each list,
+// except where this conflicts with the constraints declared using Reducer.RRunAfter and Reducer.RRunBefore.
+// The constraints refer to reducers by label, so a constraint applies to all reducers with that label,
+// and labels of reducers that aren't registered are ignored.
+//
+// The index of the list each reducer came from is returned in from.
+//
+// If the constraints can't be satisfied, the reducers involved are kept in registration order
+// and an error naming them is returned.
+func sortReducers(lists ...reducerList) (sorted reducerList, from []int, err error) {
+	var all reducerList
+	var list []int
+	for i, rl := range lists {
+		all = append(all, rl...)
+		for range rl {
+			list = append(list, i)
+		}
+	}
+
+	labels := make([]string, len(all))
+	byLabel := map[string][]int{}
+	constrained := false
+	for i, r := range all {
+		labels[i] = ReducerLabel(r)
+		byLabel[labels[i]] = append(byLabel[labels[i]], i)
+		if len(r.RRunAfter()) != 0 || len(r.RRunBefore()) != 0 {
+			constrained = true
+		}
+	}
+	if !constrained {
+		return all, list, nil
+	}
+
+	// after[i] is the set of reducers that must be called after reducer i
+	after := make([]map[int]bool, len(all))
+	deps := make([]int, len(all))
+	addEdge := func(first, then int) {
+		if first == then {
+			return
+		}
+		if after[first] == nil {
+			after[first] = map[int]bool{}
+		}
+		if !after[first][then] {
+			after[first][then] = true
+			deps[then]++
+		}
+	}
+	for i, r := range all {
+		for _, lbl := range r.RRunAfter() {
+			for _, j := range byLabel[lbl] {
+				addEdge(j, i)
+			}
+		}
+		for _, lbl := range r.RRunBefore() {
+			for _, j := range byLabel[lbl] {
+				addEdge(i, j)
+			}
+		}
+	}
+
+	// pick the first reducer, in registration order, whose dependencies were all picked
+	sorted = make(reducerList, 0, len(all))
+	from = make([]int, 0, len(all))
+	picked := make([]bool, len(all))
+	for len(sorted) < len(all) {
+		next := -1
+		for i := range all {
+			if !picked[i] && deps[i] == 0 {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			break
+		}
+		picked[next] = true
+		sorted = append(sorted, all[next])
+		from = append(from, list[next])
+		for j := range after[next] {
+			deps[j]--
+		}
+	}
+	if len(sorted) == len(all) {
+		return sorted, from, nil
+	}
+
+	var cycle []string
+	for i, r := range all {
+		if !picked[i] {
+			sorted = append(sorted, r)
+			from = append(from, list[i])
+			cycle = append(cycle, labels[i])
+		}
+	}
+	return sorted, from, fmt.Errorf("reducers: the RunAfter/RunBefore constraints of these reducers conflict: %s", strings.Join(cycle, ", "))
+}
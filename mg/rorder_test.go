@@ -0,0 +1,81 @@
+package mg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSortReducers(t *testing.T) {
+	r := func(label string, after, before []string) Reducer {
+		return NewReducer(nil, func(rf *RFunc) {
+			rf.Label, rf.RunAfter, rf.RunBefore = label, after, before
+		})
+	}
+	labels := func(rl reducerList) string {
+		l := make([]string, len(rl))
+		for i, r := range rl {
+			l[i] = ReducerLabel(r)
+		}
+		return strings.Join(l, " ")
+	}
+
+	cases := []struct {
+		name  string
+		lists []reducerList
+		want  string
+		err   bool
+	}{
+		{
+			name:  "registration order",
+			lists: []reducerList{{r("a", nil, nil)}, {r("b", nil, nil), r("c", nil, nil)}},
+			want:  "a b c",
+		},
+		{
+			name:  "run after",
+			lists: []reducerList{{r("a", []string{"c"}, nil), r("b", nil, nil)}, {r("c", nil, nil)}},
+			want:  "b c a",
+		},
+		{
+			name:  "run before",
+			lists: []reducerList{{r("a", nil, nil)}, {r("b", nil, nil)}, {r("c", nil, []string{"a", "missing"})}},
+			want:  "b c a",
+		},
+		{
+			name:  "conflict",
+			lists: []reducerList{{r("a", []string{"b"}, nil), r("b", []string{"a"}, nil), r("c", nil, nil)}},
+			want:  "c a b",
+			err:   true,
+		},
+	}
+	for _, c := range cases {
+		rl, _, err := sortReducers(c.lists...)
+		if got := labels(rl); got != c.want {
+			t.Errorf("%s: order = (%s); want (%s)", c.name, got, c.want)
+		}
+		if (err != nil) != c.err {
+			t.Errorf("%s: err = (%v); want error: %v", c.name, err, c.err)
+		}
+	}
+
+	ag := NewTestingAgent(nil, nil, nil)
+	ag.Store.Before(r("late", []string{"early"}, nil))
+	ag.Store.After(r("early", nil, nil))
+	l := ag.Store.reducerLabels()
+	if strings.Join(l, " ") == "" || l[len(l)-1] != "late" {
+		t.Errorf("reducerLabels() = (%q); want late to be called last", l)
+	}
+
+	sr := storeReducers{
+		before: reducerList{r("b1", nil, nil), r("b2", []string{"u1"}, nil)},
+		use:    reducerList{r("u1", nil, nil)},
+		after:  reducerList{r("a1", nil, nil)},
+	}.Copy()
+	var secs []string
+	for _, sec := range sr.sections {
+		secs = append(secs, sec.name+": "+labels(sec.reducers))
+	}
+	want := "Before: b1, Use: u1, Before: b2, After: a1"
+	if got := strings.Join(secs, ", "); got != want {
+		t.Errorf("sections = (%s); want (%s)", got, want)
+	}
+}
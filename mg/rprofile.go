@@ -0,0 +1,135 @@
+package mg
+
+import (
+	"margo.sh/mg/actions"
+	"runtime/metrics"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// allocBytesMetric is the runtime metric used to estimate the bytes allocated by reducers
+	allocBytesMetric = "/gc/heap/allocs:bytes"
+)
+
+// QueryProfile is the action dispatched by the client to request the profiling report of the reducers.
+//
+// The agent responds with a ReducerProfile client action. See QueryReducerProfile
+type QueryProfile struct{ ActionType }
+
+// ReducerProfile is the client action dispatched in response to QueryProfile
+type ReducerProfile struct {
+	ActionType
+
+	// Since is the time at which profiling started
+	Since time.Time
+
+	// Reducers is the list of totals for each reducer, slowest first i.e. sorted by Total
+	Reducers []ReducerTotals
+
+	// KVMem is the memory usage of the Store's KV entries, sampled when the report is made, largest first.
+	// Entries are grouped by the type of their key, which usually identifies the reducer that stored them.
+	KVMem []KVMemUsage
+}
+
+func (rp ReducerProfile) ClientAction() actions.ClientData {
+	return actions.ClientData{Name: "ReducerProfile", Data: rp}
+}
+
+// ReducerTotals holds the totals, over the session, for the reducers with the same label
+type ReducerTotals struct {
+	// Label is the reducer's label, as returned by ReducerLabel
+	Label string
+
+	// Calls is the number of actions reduced
+	Calls int64
+
+	// Total is the cumulative time taken and Max is the longest time taken to reduce an action
+	Total, Max time.Duration
+
+	// AllocBytes is the number of bytes allocated while reducing actions.
+	// It's an estimate: it includes the allocations made concurrently by other goroutines
+	AllocBytes uint64
+}
+
+// reducerProfiler accumulates the totals of each reducer
+type reducerProfiler struct {
+	ReducerType
+
+	mu     sync.Mutex
+	since  time.Time
+	totals map[string]*ReducerTotals
+}
+
+func newReducerProfiler() *reducerProfiler {
+	return &reducerProfiler{
+		since:  time.Now(),
+		totals: map[string]*ReducerTotals{},
+	}
+}
+
+// allocBytes returns the number of bytes allocated since the program started
+func allocBytes() uint64 {
+	s := []metrics.Sample{{Name: allocBytesMetric}}
+	metrics.Read(s)
+	if s[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return s[0].Value.Uint64()
+}
+
+// start returns a function that records a call of the reducer r when it's called
+func (rp *reducerProfiler) start(r Reducer) (stop func()) {
+	start, allocs := time.Now(), allocBytes()
+	return func() {
+		dur, allocs := time.Since(start), allocBytes()-allocs
+		label := ReducerLabel(r)
+
+		rp.mu.Lock()
+		defer rp.mu.Unlock()
+
+		rt := rp.totals[label]
+		if rt == nil {
+			rt = &ReducerTotals{Label: label}
+			rp.totals[label] = rt
+		}
+		rt.Calls++
+		rt.Total += dur
+		if dur > rt.Max {
+			rt.Max = dur
+		}
+		rt.AllocBytes += allocs
+	}
+}
+
+// report returns the ReducerProfile client action
+func (rp *reducerProfiler) report() ReducerProfile {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	p := ReducerProfile{
+		Since:    rp.since,
+		Reducers: make([]ReducerTotals, 0, len(rp.totals)),
+	}
+	for _, rt := range rp.totals {
+		p.Reducers = append(p.Reducers, *rt)
+	}
+	sort.Slice(p.Reducers, func(i, j int) bool {
+		a, b := p.Reducers[i], p.Reducers[j]
+		if a.Total != b.Total {
+			return a.Total > b.Total
+		}
+		return a.Label < b.Label
+	})
+	return p
+}
+
+func (rp *reducerProfiler) Reduce(mx *Ctx) *State {
+	if _, ok := mx.Action.(QueryProfile); ok {
+		p := rp.report()
+		p.KVMem = kvMemUsage(&mx.Store.KVMap)
+		return mx.addClientActions(p)
+	}
+	return mx.State
+}
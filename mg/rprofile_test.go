@@ -0,0 +1,36 @@
+package mg
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAgentReducerProfile(t *testing.T) {
+	ag := NewTestingAgent(nil, nil, nil)
+	ag.Store.Use(NewReducer(func(mx *Ctx) *State {
+		if mx.ActionIs(QueryIssues{}) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		return mx.State
+	}, func(rf *RFunc) { rf.Label = "slow-lint" }))
+
+	var last *Ctx
+	ag.Store.Subscribe(func(mx *Ctx) { last = mx })
+	ag.Store.handleAct(QueryIssues{}, nil)
+	ag.Store.handleAct(QueryIssues{}, nil)
+	ag.Store.handleAct(QueryProfile{}, nil)
+
+	var rp ReducerProfile
+	for _, ca := range last.clientActions {
+		if p, ok := ca.Data.(ReducerProfile); ok {
+			rp = p
+		}
+	}
+	if len(rp.Reducers) == 0 {
+		t.Fatal("QueryProfile didn't respond with the ReducerProfile")
+	}
+	rt := rp.Reducers[0]
+	if rt.Label != "slow-lint" || rt.Calls != 3 || rt.Max < 5*time.Millisecond || rt.Total < 10*time.Millisecond {
+		t.Errorf("Reducers[0] = (%+v); want slow-lint first, with 3 calls taking at least 10ms", rt)
+	}
+}
@@ -0,0 +1,139 @@
+package mg
+
+import (
+	"margo.sh/mg/actions"
+	"sync"
+)
+
+// EnableReducer is the action dispatched by the client to enable the reducers labelled Label.
+//
+// It enables reducers disabled using DisableReducer, and those disabled because they panicked too many times.
+type EnableReducer struct {
+	ActionType
+
+	Label string
+}
+
+// DisableReducer is the action dispatched by the client to disable the reducers labelled Label
+// for the rest of the session, or until EnableReducer is dispatched.
+type DisableReducer struct {
+	ActionType
+
+	Label string
+}
+
+// QueryReducers is the action dispatched by the client to request the list of reducers.
+//
+// The agent responds with a Reducers client action.
+type QueryReducers struct{ ActionType }
+
+// Reducers is the client action dispatched in response to QueryReducers
+type Reducers struct {
+	ActionType
+
+	// Reducers is the list of reducers in the order they're called
+	Reducers []ReducerStatus
+}
+
+func (rs Reducers) ClientAction() actions.ClientData {
+	return actions.ClientData{Name: "Reducers", Data: rs}
+}
+
+// ReducerStatus describes a reducer
+type ReducerStatus struct {
+	// Label is the reducer's label, as returned by ReducerLabel
+	Label string
+
+	// Disabled is true if the reducer was disabled using DisableReducer
+	Disabled bool
+
+	// Quarantined is true if the reducer was disabled because it panicked too many times.
+	// See Store.SetReducerPanicLimit
+	Quarantined bool
+}
+
+// reducerSwitch tracks the labels of the reducers disabled by the client
+// and handles the EnableReducer, DisableReducer and QueryReducers actions.
+//
+// It can't be disabled itself.
+type reducerSwitch struct {
+	ReducerType
+
+	mu       sync.Mutex
+	disabled map[string]bool
+}
+
+// isDisabled returns true if reducer r was disabled using DisableReducer
+func (rs *reducerSwitch) isDisabled(r Reducer) bool {
+	if r == Reducer(rs) {
+		return false
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	return len(rs.disabled) != 0 && rs.disabled[ReducerLabel(r)]
+}
+
+func (rs *reducerSwitch) setDisabled(label string, disabled bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if disabled {
+		if rs.disabled == nil {
+			rs.disabled = map[string]bool{}
+		}
+		rs.disabled[label] = true
+	} else {
+		delete(rs.disabled, label)
+	}
+}
+
+// status returns the list of reducers of sto and their status
+func (rs *reducerSwitch) status(sto *Store) Reducers {
+	sto.reducers.Lock()
+	sr := sto.reducers.storeReducers
+	sto.reducers.Unlock()
+
+	l := Reducers{Reducers: make([]ReducerStatus, len(sr.sorted))}
+	for i, r := range sr.sorted {
+		_, quarantined := sto.quarantine.disabled(r.reducerType())
+		l.Reducers[i] = ReducerStatus{
+			Label:       ReducerLabel(r),
+			Disabled:    rs.isDisabled(r),
+			Quarantined: quarantined,
+		}
+	}
+	return l
+}
+
+// lookup returns true if a reducer labelled label, other than rs, is registered with sto
+func (rs *reducerSwitch) lookup(sto *Store, label string) bool {
+	for _, s := range rs.status(sto).Reducers {
+		if s.Label == label && s.Label != ReducerLabel(rs) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rs *reducerSwitch) Reduce(mx *Ctx) *State {
+	switch act := mx.Action.(type) {
+	case QueryReducers:
+		return mx.addClientActions(rs.status(mx.Store))
+	case EnableReducer:
+		if !rs.lookup(mx.Store, act.Label) {
+			return mx.AddErrorf("EnableReducer: there is no reducer labelled %s", act.Label)
+		}
+		rs.setDisabled(act.Label, false)
+		mx.Store.quarantine.release(act.Label)
+		mx.Log.Printf("reducer %s enabled\n", act.Label)
+	case DisableReducer:
+		if !rs.lookup(mx.Store, act.Label) {
+			return mx.AddErrorf("DisableReducer: there is no reducer labelled %s", act.Label)
+		}
+		rs.setDisabled(act.Label, true)
+		mx.Log.Printf("reducer %s disabled\n", act.Label)
+	}
+	return mx.State
+}
@@ -0,0 +1,56 @@
+package mg
+
+import (
+	"testing"
+)
+
+func TestStoreEnableDisableReducer(t *testing.T) {
+	ag := NewTestingAgent(nil, nil, nil)
+	calls := 0
+	ag.Store.Use(NewReducer(func(mx *Ctx) *State {
+		if mx.ActionIs(QueryIssues{}) {
+			calls++
+		}
+		return mx.State
+	}, func(rf *RFunc) { rf.Label = "lint" }))
+
+	var last *Ctx
+	ag.Store.Subscribe(func(mx *Ctx) { last = mx })
+
+	ag.Store.handleAct(DisableReducer{Label: "lint"}, nil)
+	ag.Store.handleAct(QueryIssues{}, nil)
+	if calls != 0 {
+		t.Errorf("the disabled reducer was called %d times", calls)
+	}
+
+	ag.Store.handleAct(QueryReducers{}, nil)
+	found := false
+	for _, ca := range last.clientActions {
+		rs, ok := ca.Data.(Reducers)
+		if !ok {
+			continue
+		}
+		for _, s := range rs.Reducers {
+			if s.Label == "lint" {
+				found = true
+				if !s.Disabled || s.Quarantined {
+					t.Errorf("ReducerStatus = (%+v); want it disabled", s)
+				}
+			}
+		}
+	}
+	if !found {
+		t.Error("QueryReducers didn't list the reducer")
+	}
+
+	ag.Store.handleAct(EnableReducer{Label: "lint"}, nil)
+	ag.Store.handleAct(QueryIssues{}, nil)
+	if calls != 1 {
+		t.Errorf("the enabled reducer was called %d times; want 1", calls)
+	}
+
+	ag.Store.handleAct(DisableReducer{Label: "missing"}, nil)
+	if len(last.Errors) == 0 {
+		t.Error("DisableReducer of an unknown reducer didn't report an error")
+	}
+}
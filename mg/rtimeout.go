@@ -0,0 +1,55 @@
+package mg
+
+import (
+	"margo.sh/mgpf"
+	"sync/atomic"
+	"time"
+)
+
+// SetReducerTimeout sets the amount of time each reducer may take to handle an action.
+//
+// When a reducer exceeds it, the Ctx passed to it is cancelled and, once it returns,
+// a warning issue naming it is added to the state.
+// Reducers aren't interrupted so they should return as soon as their Ctx is done.
+// If d <= 0, the timeout is disabled, which is the default.
+func (sto *Store) SetReducerTimeout(d time.Duration) *Store {
+	atomic.StoreInt64(&sto.reducerTimeout, int64(d))
+	return sto
+}
+
+// timedReduction calls the reduction of reducer r, enforcing the timeout set using Store.SetReducerTimeout
+func timedReduction(mx *Ctx, r Reducer) *Ctx {
+	d := time.Duration(atomic.LoadInt64(&mx.Store.reducerTimeout))
+	if d <= 0 {
+		return r.reducerType().reduction(mx, r)
+	}
+
+	start := time.Now()
+	dl := start.Add(d)
+	if t, ok := mx.Deadline(); ok && t.Before(dl) {
+		dl = t
+	}
+	rmx := mx.Copy()
+	stop := rmx.withDeadline(dl)
+	defer stop()
+	res := r.reducerType().reduction(rmx, r)
+
+	// the reducer's Ctx is done, but the Ctx passed to the following reducers isn't
+	res = res.Copy(func(x *Ctx) {
+		x.doneC, x.cancelOnce, x.deadline = mx.doneC, mx.cancelOnce, mx.deadline
+	})
+	if dur := time.Since(start); dur >= d {
+		name := ReducerLabel(r)
+		mx.Log.Printf("reducer %s took %s handling %s, exceeding the timeout of %s\n", name, dur, ActionLabel(mx.Action), d)
+		isu := Issue{
+			Tag:     Warning,
+			Label:   name,
+			Message: "reducer timed out after " + mgpf.D(dur).String() + " (limit " + d.String() + ")",
+		}
+		if v := res.View; v != nil {
+			isu.Path, isu.Name, isu.Row = v.Path, v.Name, v.Row
+		}
+		res = res.SetState(res.AddIssues(isu))
+	}
+	return res
+}
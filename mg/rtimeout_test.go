@@ -0,0 +1,48 @@
+package mg
+
+import (
+	"margo.sh/mg/actions"
+	"testing"
+	"time"
+)
+
+func TestStoreReducerTimeout(t *testing.T) {
+	ag := NewTestingAgent(nil, nil, nil)
+	ag.Store.SetReducerTimeout(20 * time.Millisecond)
+	ag.Store.Use(NewReducer(func(mx *Ctx) *State {
+		if mx.ActionIs(QueryIssues{}) {
+			<-mx.Done()
+		}
+		return mx.State
+	}, func(rf *RFunc) { rf.Label = "slow-reducer" }))
+	var issues IssueSet
+	var nextErr error
+	ag.Store.Use(NewReducer(func(mx *Ctx) *State {
+		if mx.ActionIs(QueryIssues{}) {
+			issues, nextErr = mx.Issues, mx.Err()
+		}
+		return mx.State
+	}))
+
+	rq := newAgentReq(ag.Store)
+	rq.Cookie = "timeout"
+	rq.Actions = []actions.ActionData{{Name: "QueryIssues"}}
+	rq.finalize(ag)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ag.Store.handleReq(rq)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the slow reducer's Ctx wasn't cancelled")
+	}
+
+	if nextErr != nil {
+		t.Errorf("the next reducer's mx.Err() = (%v); want (nil)", nextErr)
+	}
+	if len(issues) != 1 || issues[0].Tag != Warning || issues[0].Label != "slow-reducer" {
+		t.Errorf("issues = (%+v); want a warning for slow-reducer", issues)
+	}
+}
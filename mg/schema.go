@@ -0,0 +1,300 @@
+package mg
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ugorji/go/codec"
+	"io"
+	"margo.sh/mg/actions"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+	jsonSchemaDraft = "http://json-schema.org/draft-07/schema#"
+)
+
+var (
+	rawType          = reflect.TypeOf(codec.Raw(nil))
+	timeType         = reflect.TypeOf(time.Time{})
+	actionDataType   = reflect.TypeOf(actions.ActionData{})
+	clientDataType   = reflect.TypeOf(actions.ClientData{})
+	hiddenFieldType  = reflect.TypeOf(struct{}{})
+	schemaTagKeys    = []string{"codec", "json"}
+	schemaByteFormat = map[string]string{
+		"json": "base64",
+	}
+)
+
+// JSONSchema is the subset of JSON Schema (draft-07) used to describe the IPC protocol
+type JSONSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Ref                  string                 `json:"$ref,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Const                string                 `json:"const,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	AdditionalProperties *JSONSchema            `json:"additionalProperties,omitempty"`
+	OneOf                []*JSONSchema          `json:"oneOf,omitempty"`
+	Definitions          map[string]*JSONSchema `json:"definitions,omitempty"`
+}
+
+// ProtocolSchema returns a JSON Schema describing the requests and responses
+// exchanged with the agent when using the codec named codecName.
+//
+// The definitions `Request` and `Response` describe the messages.
+// The Data of each action registered in ActionCreators, and of each client action the agent may send,
+// is described by a definition named `Action.$Name` or `ClientAction.$Name`, respectively.
+// For codecs other than json, the schema describes the same data model
+// but binary data is sent as-is, instead of as a base64 string.
+func ProtocolSchema(codecName string) (*JSONSchema, error) {
+	if codecName == "" {
+		codecName = DefaultCodec
+	}
+	if codecHandles[codecName] == nil {
+		return nil, fmt.Errorf("Invalid codec '%s'. Expected %s", codecName, CodecNamesStr)
+	}
+
+	sg := &schemaGen{codec: codecName, defs: map[string]*JSONSchema{}}
+	for _, name := range ActionCreators.Names() {
+		sg.defs["Action."+name] = sg.actionSchema(name)
+	}
+	for _, ca := range clientActions {
+		cd := ca.ClientAction()
+		sg.defs["ClientAction."+cd.Name] = sg.clientActionSchema(cd)
+	}
+	sg.defs["Request"] = sg.dataSchema(reflect.TypeOf(agentReq{}))
+	sg.defs["Response"] = sg.dataSchema(reflect.TypeOf(agentRes{}.finalize()))
+
+	return &JSONSchema{
+		Schema:      jsonSchemaDraft,
+		Title:       "margo.sh IPC protocol",
+		Description: "Messages exchanged with the agent using the " + codecName + " codec",
+		OneOf: []*JSONSchema{
+			{Ref: "#/definitions/Request"},
+			{Ref: "#/definitions/Response"},
+		},
+		Definitions: sg.defs,
+	}, nil
+}
+
+// WriteProtocolSchema writes the result of ProtocolSchema(codecName), encoded as indented JSON, to w
+func WriteProtocolSchema(w io.Writer, codecName string) error {
+	s, err := ProtocolSchema(codecName)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+// schemaGen builds the schema of Go types as seen by the codec
+type schemaGen struct {
+	codec string
+	defs  map[string]*JSONSchema
+}
+
+// actionSchema returns the schema of the Data of the action registered as name
+func (sg *schemaGen) actionSchema(name string) (s *JSONSchema) {
+	defer func() {
+		if v := recover(); v != nil {
+			s = &JSONSchema{Description: "The action couldn't be created"}
+		}
+	}()
+	act, err := ActionCreators.Lookup(name)(actions.ActionData{Name: name})
+	if err != nil || act == nil {
+		return &JSONSchema{Description: "The action couldn't be created"}
+	}
+	return sg.dataSchema(reflect.TypeOf(act))
+}
+
+// clientActionSchema returns the schema of the Data of client action cd
+func (sg *schemaGen) clientActionSchema(cd actions.ClientData) *JSONSchema {
+	if cd.Data == nil {
+		return &JSONSchema{Type: "null"}
+	}
+	return sg.dataSchema(reflect.TypeOf(cd.Data))
+}
+
+// dataSchema returns the schema of type t, without adding it to the definitions
+func (sg *schemaGen) dataSchema(t reflect.Type) *JSONSchema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Struct {
+		return sg.objectSchema(t)
+	}
+	return sg.typeSchema(t)
+}
+
+// envelopeSchema returns the schema of ActionData or ClientData,
+// with one alternative for each definition named prefix.$Name
+func (sg *schemaGen) envelopeSchema(prefix string, names []string, extra map[string]*JSONSchema) *JSONSchema {
+	s := &JSONSchema{}
+	for _, name := range names {
+		props := map[string]*JSONSchema{
+			"Name": {Type: "string", Const: name},
+			"Data": {Ref: "#/definitions/" + prefix + "." + name},
+		}
+		for k, v := range extra {
+			props[k] = v
+		}
+		s.OneOf = append(s.OneOf, &JSONSchema{
+			Type:       "object",
+			Properties: props,
+			Required:   []string{"Name"},
+		})
+	}
+	return s
+}
+
+func (sg *schemaGen) typeSchema(t reflect.Type) *JSONSchema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t {
+	case rawType:
+		return &JSONSchema{Description: "A value encoded using the same codec as the message"}
+	case timeType:
+		return &JSONSchema{Type: "string", Format: "date-time"}
+	case actionDataType:
+		if sg.defs["ActionData"] == nil {
+			sg.defs["ActionData"] = sg.envelopeSchema("Action", ActionCreators.Names(), map[string]*JSONSchema{
+				"Deadline": {Type: "string", Description: "The time, in UTC, by which the action should be handled e.g. `" + ipcTimeLayout + "`"},
+				"Codec":    {Type: "string", Description: "The codec used to encode Data, if it differs from the message's codec. Data is then a byte string holding the encoded value"},
+				"Async":    {Type: "boolean", Description: "If true, the action is acknowledged with a Token and its result is sent in the final response to a request whose Cookie is the Token"},
+			})
+		}
+		return &JSONSchema{Ref: "#/definitions/ActionData"}
+	case clientDataType:
+		if sg.defs["ClientData"] == nil {
+			names := make([]string, len(clientActions))
+			for i, ca := range clientActions {
+				names[i] = ca.ClientAction().Name
+			}
+			sg.defs["ClientData"] = sg.envelopeSchema("ClientAction", names, nil)
+		}
+		return &JSONSchema{Ref: "#/definitions/ClientData"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &JSONSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: "number"}
+	case reflect.String:
+		return &JSONSchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &JSONSchema{Type: "string", Format: schemaByteFormat[sg.codec]}
+		}
+		return &JSONSchema{Type: "array", Items: sg.typeSchema(t.Elem())}
+	case reflect.Map:
+		return &JSONSchema{Type: "object", AdditionalProperties: sg.typeSchema(t.Elem())}
+	case reflect.Struct:
+		return sg.structSchema(t)
+	}
+	return &JSONSchema{}
+}
+
+// structSchema returns the schema of struct type t.
+// Named types are added to the definitions and referenced by name.
+func (sg *schemaGen) structSchema(t reflect.Type) *JSONSchema {
+	name := ""
+	if t.Name() != "" {
+		name = path.Base(t.PkgPath()) + "." + t.Name()
+		if _, seen := sg.defs[name]; seen {
+			return &JSONSchema{Ref: "#/definitions/" + name}
+		}
+		// reserve the name so recursive types refer to it
+		sg.defs[name] = &JSONSchema{}
+	}
+
+	s := sg.objectSchema(t)
+	if name == "" {
+		return s
+	}
+	sg.defs[name] = s
+	return &JSONSchema{Ref: "#/definitions/" + name}
+}
+
+// objectSchema returns the schema of the fields of struct type t
+func (sg *schemaGen) objectSchema(t reflect.Type) *JSONSchema {
+	s := &JSONSchema{Type: "object", Properties: map[string]*JSONSchema{}}
+	for _, sf := range schemaFields(t) {
+		switch sf.typ.Kind() {
+		case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+			continue
+		}
+		s.Properties[sf.name] = sg.typeSchema(sf.typ)
+	}
+	return s
+}
+
+type schemaField struct {
+	name string
+	typ  reflect.Type
+}
+
+// schemaFields returns the fields of the struct type t, as seen by the encoder.
+// It follows the same rules as encodedFields, using the names set in `codec` or `json` tags.
+func schemaFields(t reflect.Type) []schemaField {
+	var l []schemaField
+	seen := map[string]bool{}
+	level := []reflect.Type{t}
+	for len(level) != 0 {
+		var next []reflect.Type
+		for _, t := range level {
+			for i := 0; i < t.NumField(); i++ {
+				sf := t.Field(i)
+				name, skip := schemaFieldName(sf)
+				ft := sf.Type
+				for ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				switch {
+				case skip:
+				case sf.Anonymous && ft.Kind() == reflect.Struct && name == sf.Name:
+					next = append(next, ft)
+				case sf.PkgPath != "" || strings.HasPrefix(sf.Name, "_") || seen[name]:
+				default:
+					seen[name] = true
+					if sf.Type != hiddenFieldType {
+						l = append(l, schemaField{name: name, typ: sf.Type})
+					}
+				}
+			}
+		}
+		level = next
+	}
+	return l
+}
+
+// schemaFieldName returns the name of field sf as set in its tags, and whether the field is skipped
+func schemaFieldName(sf reflect.StructField) (name string, skip bool) {
+	for _, k := range schemaTagKeys {
+		tag, ok := sf.Tag.Lookup(k)
+		if !ok {
+			continue
+		}
+		if tag == "-" {
+			return "", true
+		}
+		if s := strings.Split(tag, ",")[0]; s != "" {
+			return s, false
+		}
+		break
+	}
+	return sf.Name, false
+}
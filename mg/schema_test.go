@@ -0,0 +1,85 @@
+package mg
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ugorji/go/codec"
+	"margo.sh/mg/actions"
+	"margo.sh/mgutil"
+	"testing"
+)
+
+func TestProtocolSchema(t *testing.T) {
+	if _, err := ProtocolSchema("invalidcodec"); err == nil {
+		t.Error("ProtocolSchema(invalidcodec) = (nil); want (error)")
+	}
+
+	buf := &bytes.Buffer{}
+	if err := WriteProtocolSchema(buf, "json"); err != nil {
+		t.Fatalf("WriteProtocolSchema() = (%v); want (nil)", err)
+	}
+	s := JSONSchema{}
+	if err := json.Unmarshal(buf.Bytes(), &s); err != nil {
+		t.Fatalf("json.Unmarshal(): %s", err)
+	}
+	for _, name := range []string{"Request", "Response", "Action.QueryCompletions", "Action.RunCmd", "ClientAction.Ping", "ClientAction.CmdOutput"} {
+		if s.Definitions[name] == nil {
+			t.Errorf("definition %s is missing", name)
+		}
+	}
+
+	if p := s.Definitions["Action.RunCmd"].Properties; p["Args"] == nil || p["Args"].Type != "array" {
+		t.Errorf("Action.RunCmd.Args = (%+v); want an array", p["Args"])
+	}
+	if d := s.Definitions["ClientAction.CmdOutput"].Properties["Output"]; d == nil || d.Format != "base64" {
+		t.Errorf("ClientAction.CmdOutput.Output = (%+v); want a base64 string", d)
+	}
+
+	st := s.Definitions["mg.resState"]
+	if st == nil {
+		t.Fatal("definition mg.resState is missing")
+	}
+	for _, name := range []string{"Status", "Issues", "ClientActions", "Config"} {
+		if st.Properties[name] == nil {
+			t.Errorf("State.%s is missing", name)
+		}
+	}
+	for _, name := range []string{"Env", "Editor", "Profile"} {
+		if st.Properties[name] != nil {
+			t.Errorf("State.%s is present; want it hidden", name)
+		}
+	}
+}
+
+func TestAgentActionCodec(t *testing.T) {
+	ag, err := NewAgent(AgentConfig{
+		Stdin:  &mgutil.IOWrapper{},
+		Stdout: &mgutil.IOWrapper{},
+		Stderr: &mgutil.IOWrapper{},
+	})
+	if err != nil {
+		t.Fatalf("agent creation failed: %s", err)
+	}
+
+	var blob []byte
+	if err := codec.NewEncoderBytes(&blob, codecHandles["cbor"]).Encode(QueryTooltips{Row: 3, Col: 7}); err != nil {
+		t.Fatalf("cbor encode: %s", err)
+	}
+	var data []byte
+	if err := codec.NewEncoderBytes(&data, ag.handle).Encode(blob); err != nil {
+		t.Fatalf("json encode: %s", err)
+	}
+	d := actions.ActionData{Name: "QueryTooltips", Data: data, Handle: ag.handle, Codec: "cbor"}
+	act, err := ag.createAction(d)
+	if err != nil {
+		t.Fatalf("createAction() = (%v); want (nil)", err)
+	}
+	if qt, ok := act.(QueryTooltips); !ok || qt.Row != 3 || qt.Col != 7 {
+		t.Errorf("createAction() = (%#v); want QueryTooltips{Row: 3, Col: 7}", act)
+	}
+
+	d.Codec = "invalidcodec"
+	if _, err := ag.createAction(d); err == nil {
+		t.Error("createAction() with an invalid codec = (nil); want (error)")
+	}
+}
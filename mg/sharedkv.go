@@ -0,0 +1,463 @@
+package mg
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	_ KVStore = (*SharedKV)(nil)
+)
+
+const (
+	// sharedKVDialTimeout is the amount of time to wait when connecting to the agent serving a SharedKV
+	sharedKVDialTimeout = time.Second
+
+	// sharedKVTimeout is the amount of time a request to the agent serving a SharedKV may take
+	sharedKVTimeout = 10 * time.Second
+
+	// sharedKVRetryInterval is the amount of time to wait before connecting again after a SharedKV failed to connect
+	sharedKVRetryInterval = 30 * time.Second
+)
+
+// SharedKV implements a KVStore whose values are shared by all the agents configured with the same
+// AgentConfig.SharedCache address e.g. those of several editor windows, so expensive, project-independent values
+// such as the index of the standard library's packages are only computed, and held in memory, once.
+//
+// The first agent to use the store serves the values to the others over a unix socket.
+// When it exits, its values are lost, and the next agent to use the store takes its place.
+//
+// Values are encoded using encoding/gob, so their types must be registered using gob.Register.
+// Each call of Get returns a new copy of the value, so it should be kept for as long as it's needed
+// instead of calling Get repeatedly.
+// Keys are identified in the same way as by KVDisk.
+// Values that can't be encoded, or that are stored while the server can't be reached, are only kept in memory,
+// and errors are written to the Logger, if any.
+//
+// NOTE: All operations are no-ops on a nil SharedKV
+type SharedKV struct {
+	addr     string
+	log      *Logger
+	local    KVMap
+	counters kvCounters
+
+	mu    sync.Mutex
+	conn  net.Conn
+	enc   *gob.Encoder
+	dec   *gob.Decoder
+	srv   *sharedKVServer
+	retry time.Time
+}
+
+// sharedKVOp is the operation of a request to the agent serving a SharedKV
+type sharedKVOp uint8
+
+const (
+	sharedKVGet sharedKVOp = iota + 1
+	sharedKVPut
+	sharedKVDel
+	sharedKVRange
+	sharedKVDelPrefix
+)
+
+// sharedKVReq is a request to the agent serving a SharedKV
+type sharedKVReq struct {
+	Op    sharedKVOp
+	Key   string
+	Value []byte
+
+	// Path and Partial describe the path of the key of the value stored by sharedKVPut. See kvKeyPath
+	Path    string
+	Partial bool
+}
+
+// sharedKVRes is the response to a sharedKVReq
+type sharedKVRes struct {
+	Found  bool
+	Value  []byte
+	Keys   []string
+	Values [][]byte
+}
+
+// sharedKVValue is the encoded form of a value of a SharedKV
+type sharedKVValue struct {
+	Value interface{}
+}
+
+// newSharedKV returns a new SharedKV shared using the unix socket addr.
+// If addr is empty, values are only kept in memory.
+func newSharedKV(addr string, log *Logger) *SharedKV {
+	return &SharedKV{addr: addr, log: log}
+}
+
+// parseSharedCache parses the `unix:path` address s and returns the path
+func parseSharedCache(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	network, addr, _ := strings.Cut(s, ":")
+	if network != "unix" || addr == "" {
+		return "", fmt.Errorf("Invalid shared cache address '%s'. Expected e.g. unix:/tmp/margo-cache.sock", s)
+	}
+	return addr, nil
+}
+
+// SharedKV returns the SharedKV shared with other agents configured with the same AgentConfig.SharedCache.
+// If it isn't set, the values are only shared by the reducers of this agent.
+func (sto *Store) SharedKV() *SharedKV {
+	return sto.sharedKV
+}
+
+// logf writes an error to the Logger, if any
+func (skv *SharedKV) logf(format string, a ...interface{}) {
+	if skv.log != nil {
+		skv.log.Printf("SharedKV: "+format, a...)
+	}
+}
+
+// connect connects to the agent serving the store, or starts serving it if there's none,
+// and reports whether it succeeded. skv.mu must be held.
+func (skv *SharedKV) connect() bool {
+	if skv.conn != nil || skv.srv != nil {
+		return true
+	}
+	if skv.addr == "" || time.Now().Before(skv.retry) {
+		return false
+	}
+
+	c, err := net.DialTimeout("unix", skv.addr, sharedKVDialTimeout)
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		// the socket was left behind by an agent that didn't exit cleanly
+		os.Remove(skv.addr)
+	}
+	if err != nil {
+		srv, e := listenSharedKV(skv.addr)
+		if e == nil {
+			skv.srv = srv
+			return true
+		}
+		// another agent might have started serving since we tried to connect
+		c, err = net.DialTimeout("unix", skv.addr, sharedKVDialTimeout)
+		if err != nil {
+			skv.logf("cannot connect to, or serve, %s: %s\n", skv.addr, e)
+			skv.retry = time.Now().Add(sharedKVRetryInterval)
+			return false
+		}
+	}
+	skv.conn = c
+	skv.enc = gob.NewEncoder(c)
+	skv.dec = gob.NewDecoder(c)
+	return true
+}
+
+// disconnect closes the connection to the agent serving the store. skv.mu must be held.
+func (skv *SharedKV) disconnect() {
+	if skv.conn != nil {
+		skv.conn.Close()
+	}
+	skv.conn, skv.enc, skv.dec = nil, nil, nil
+}
+
+// close disconnects, and stops serving the store, if this agent is serving it
+func (skv *SharedKV) close() {
+	if skv == nil {
+		return
+	}
+
+	skv.mu.Lock()
+	defer skv.mu.Unlock()
+
+	skv.disconnect()
+	if skv.srv != nil {
+		skv.srv.close()
+		skv.srv = nil
+	}
+}
+
+// do sends req to the agent serving the store and returns its response.
+// If the server can't be reached, ok is false.
+func (skv *SharedKV) do(req sharedKVReq) (res sharedKVRes, ok bool) {
+	skv.mu.Lock()
+	defer skv.mu.Unlock()
+
+	// if the server exited, the next agent to connect takes its place, so try again once
+	for i := 0; i < 2; i++ {
+		if !skv.connect() {
+			return res, false
+		}
+		if skv.srv != nil {
+			return skv.srv.handle(req), true
+		}
+
+		skv.conn.SetDeadline(time.Now().Add(sharedKVTimeout))
+		err := skv.enc.Encode(req)
+		if err == nil {
+			err = skv.dec.Decode(&res)
+		}
+		if err == nil {
+			skv.conn.SetDeadline(time.Time{})
+			return res, true
+		}
+		skv.logf("request to %s failed: %s\n", skv.addr, err)
+		skv.disconnect()
+		res = sharedKVRes{}
+	}
+	return res, false
+}
+
+// encode returns the gob encoding of v
+func (skv *SharedKV) encode(v interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	err := gob.NewEncoder(buf).Encode(sharedKVValue{Value: v})
+	return buf.Bytes(), err
+}
+
+// decode decodes the value p returned by encode
+func (skv *SharedKV) decode(p []byte) (interface{}, error) {
+	sv := sharedKVValue{}
+	err := gob.NewDecoder(bytes.NewReader(p)).Decode(&sv)
+	return sv.Value, err
+}
+
+// Put implements KVStore.Put
+func (skv *SharedKV) Put(k interface{}, v interface{}) {
+	if skv == nil {
+		return
+	}
+
+	skv.counters.add(&skv.counters.puts, 1)
+	key := persistedKeyDesc(k)
+	p, err := skv.encode(v)
+	if err != nil {
+		skv.logf("cannot encode %s: %s\n", key, err)
+		skv.local.Put(k, v)
+		return
+	}
+	req := sharedKVReq{Op: sharedKVPut, Key: key, Value: p}
+	req.Path, req.Partial = kvKeyPath(k)
+	if _, ok := skv.do(req); !ok {
+		skv.local.Put(k, v)
+		return
+	}
+	skv.local.Del(k)
+}
+
+// Get implements KVStore.Get
+func (skv *SharedKV) Get(k interface{}) interface{} {
+	if skv == nil {
+		return nil
+	}
+
+	key := persistedKeyDesc(k)
+	if res, ok := skv.do(sharedKVReq{Op: sharedKVGet, Key: key}); ok && res.Found {
+		v, err := skv.decode(res.Value)
+		if err == nil {
+			skv.counters.get(true)
+			return v
+		}
+		skv.logf("cannot decode %s: %s\n", key, err)
+	}
+	v := skv.local.Get(k)
+	skv.counters.get(v != nil)
+	return v
+}
+
+// Del implements KVStore.Del
+func (skv *SharedKV) Del(k interface{}) {
+	if skv == nil {
+		return
+	}
+
+	skv.counters.add(&skv.counters.dels, 1)
+	skv.do(sharedKVReq{Op: sharedKVDel, Key: persistedKeyDesc(k)})
+	skv.local.Del(k)
+}
+
+// Namespace implements KVStore.Namespace
+func (skv *SharedKV) Namespace(prefix string) KVStore {
+	return newKVNamespace(skv, prefix)
+}
+
+// Range implements KVStore.Range
+//
+// The keys of the shared values are passed to f as their descriptions (see KVDisk), not their original values,
+// followed by the values only kept in memory.
+func (skv *SharedKV) Range(f func(k, v interface{}) bool) {
+	if skv == nil {
+		return
+	}
+
+	res, _ := skv.do(sharedKVReq{Op: sharedKVRange})
+	for i, key := range res.Keys {
+		v, err := skv.decode(res.Values[i])
+		if err != nil {
+			skv.logf("cannot decode %s: %s\n", key, err)
+			continue
+		}
+		if !f(key, v) {
+			return
+		}
+	}
+	skv.local.Range(f)
+}
+
+// PutBatch implements KVStore.PutBatch
+func (skv *SharedKV) PutBatch(vals map[interface{}]interface{}) {
+	for k, v := range vals {
+		skv.Put(k, v)
+	}
+}
+
+// GetBatch implements KVStore.GetBatch
+func (skv *SharedKV) GetBatch(keys []interface{}) []interface{} {
+	vals := make([]interface{}, len(keys))
+	for i, k := range keys {
+		vals[i] = skv.Get(k)
+	}
+	return vals
+}
+
+// DelBatch implements KVStore.DelBatch
+func (skv *SharedKV) DelBatch(keys []interface{}) {
+	for _, k := range keys {
+		skv.Del(k)
+	}
+}
+
+// Stats returns the counters of the store, as seen by this agent
+func (skv *SharedKV) Stats() KVStats {
+	if skv == nil {
+		return KVStats{}
+	}
+	return skv.counters.Stats()
+}
+
+// Serving reports whether this agent is serving the store to the other agents
+func (skv *SharedKV) Serving() bool {
+	if skv == nil {
+		return false
+	}
+
+	skv.mu.Lock()
+	defer skv.mu.Unlock()
+
+	return skv.srv != nil
+}
+
+// sharedKVServer serves the encoded values of a SharedKV to the other agents
+type sharedKVServer struct {
+	ln net.Listener
+
+	mu    sync.Mutex
+	vals  map[string]sharedKVEntry
+	conns map[net.Conn]struct{}
+}
+
+// sharedKVEntry is a value stored by sharedKVServer
+type sharedKVEntry struct {
+	value   []byte
+	path    string
+	partial bool
+}
+
+// listenSharedKV starts serving a SharedKV on the unix socket addr
+func listenSharedKV(addr string) (*sharedKVServer, error) {
+	ln, err := net.Listen("unix", addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := &sharedKVServer{
+		ln:    ln,
+		vals:  map[string]sharedKVEntry{},
+		conns: map[net.Conn]struct{}{},
+	}
+	go srv.serve()
+	return srv, nil
+}
+
+// serve accepts connections until the server is closed
+func (srv *sharedKVServer) serve() {
+	for {
+		c, err := srv.ln.Accept()
+		if err != nil {
+			return
+		}
+		srv.mu.Lock()
+		srv.conns[c] = struct{}{}
+		srv.mu.Unlock()
+		go srv.serveConn(c)
+	}
+}
+
+// serveConn handles the requests sent over c until it's closed
+func (srv *sharedKVServer) serveConn(c net.Conn) {
+	defer func() {
+		srv.mu.Lock()
+		delete(srv.conns, c)
+		srv.mu.Unlock()
+		c.Close()
+	}()
+
+	enc := gob.NewEncoder(c)
+	dec := gob.NewDecoder(c)
+	for {
+		req := sharedKVReq{}
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		if err := enc.Encode(srv.handle(req)); err != nil {
+			return
+		}
+	}
+}
+
+// handle returns the response to req
+func (srv *sharedKVServer) handle(req sharedKVReq) sharedKVRes {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	res := sharedKVRes{}
+	switch req.Op {
+	case sharedKVGet:
+		e, found := srv.vals[req.Key]
+		res.Value, res.Found = e.value, found
+	case sharedKVPut:
+		srv.vals[req.Key] = sharedKVEntry{value: req.Value, path: req.Path, partial: req.Partial}
+	case sharedKVDel:
+		delete(srv.vals, req.Key)
+	case sharedKVDelPrefix:
+		for k, e := range srv.vals {
+			if kvPathHasPrefix(e.path, e.partial, req.Key) {
+				delete(srv.vals, k)
+			}
+		}
+	case sharedKVRange:
+		res.Keys = make([]string, 0, len(srv.vals))
+		res.Values = make([][]byte, 0, len(srv.vals))
+		for k, e := range srv.vals {
+			res.Keys = append(res.Keys, k)
+			res.Values = append(res.Values, e.value)
+		}
+	}
+	return res
+}
+
+// close stops serving, and disconnects the other agents
+func (srv *sharedKVServer) close() {
+	srv.ln.Close()
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	for c := range srv.conns {
+		c.Close()
+	}
+}
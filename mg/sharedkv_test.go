@@ -0,0 +1,64 @@
+package mg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSharedKV(t *testing.T) {
+	if _, err := parseSharedCache("tcp:127.0.0.1:0"); err == nil {
+		t.Error("parseSharedCache(tcp:127.0.0.1:0) succeeded; want an error")
+	}
+
+	dir, err := os.MkdirTemp("", "mgskv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	addr, err := parseSharedCache("unix:" + filepath.Join(dir, "cache.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := newSharedKV(addr, nil)
+	b := newSharedKV(addr, nil)
+	defer a.close()
+	defer b.close()
+
+	a.Put("stdlib", []string{"fmt", "os"})
+	if !a.Serving() {
+		t.Fatal("the first agent to use the store isn't serving it")
+	}
+	if v, _ := b.Get("stdlib").([]string); len(v) != 2 || v[1] != "os" {
+		t.Fatalf("b.Get(stdlib) = %#v; want the value stored by a", b.Get("stdlib"))
+	}
+	if b.Serving() {
+		t.Error("b is serving the store; want it to connect to a")
+	}
+
+	b.Del("stdlib")
+	if v := a.Get("stdlib"); v != nil {
+		t.Errorf("a.Get(stdlib) = %#v after b.Del; want nil", v)
+	}
+
+	b.Put("local", func() {})
+	if v := b.Get("local"); v == nil {
+		t.Error("b.Get(local) = nil; want values that can't be encoded to be kept in memory")
+	}
+	if v := a.Get("local"); v != nil {
+		t.Errorf("a.Get(local) = %#v; want values that can't be encoded to not be shared", v)
+	}
+
+	a.close()
+	b.Put("after", 1)
+	if !b.Serving() {
+		t.Error("b isn't serving the store after a stopped serving it")
+	}
+	if v := b.Get("after"); v != 1 {
+		t.Errorf("b.Get(after) = %#v; want 1", v)
+	}
+	if st := b.Stats(); st.Hits != 3 || st.Puts != 2 {
+		t.Errorf("b.Stats() = %+v; want 3 hits and 2 puts", st)
+	}
+}
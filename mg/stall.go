@@ -0,0 +1,105 @@
+package mg
+
+import (
+	"fmt"
+	"io"
+	"margo.sh/mgutil"
+	"sync/atomic"
+	"time"
+)
+
+// errClientStalled is returned when sending to a client that stopped reading its responses
+var errClientStalled = fmt.Errorf("the client stopped reading responses")
+
+// stallWriter records when a write to the client starts, so a write that blocks
+// because the client stopped reading can be detected. See AgentConfig.WriteTimeout
+type stallWriter struct {
+	io.WriteCloser
+
+	// abort closes the underlying output without waiting for locks held by the blocked write
+	abort io.Closer
+
+	// since is the time, in unix nanoseconds, the pending write started or 0 if there is none
+	since int64
+}
+
+func newStallWriter(w io.WriteCloser) *stallWriter {
+	sw := &stallWriter{WriteCloser: w, abort: w}
+	if iow, ok := w.(*mgutil.IOWrapper); ok && iow.Closer != nil {
+		sw.abort = iow.Closer
+	}
+	return sw
+}
+
+func (sw *stallWriter) Write(p []byte) (int, error) {
+	atomic.StoreInt64(&sw.since, time.Now().UnixNano())
+	defer atomic.StoreInt64(&sw.since, 0)
+
+	return sw.WriteCloser.Write(p)
+}
+
+func (sw *stallWriter) Flush() error {
+	f, ok := sw.WriteCloser.(interface{ Flush() error })
+	if !ok {
+		return nil
+	}
+	atomic.StoreInt64(&sw.since, time.Now().UnixNano())
+	defer atomic.StoreInt64(&sw.since, 0)
+
+	return f.Flush()
+}
+
+// blocked returns the amount of time the pending write has been blocked, or 0 if there is none
+func (sw *stallWriter) blocked() time.Duration {
+	if t := atomic.LoadInt64(&sw.since); t != 0 {
+		return time.Since(time.Unix(0, t))
+	}
+	return 0
+}
+
+// monitorWrites starts monitoring writes to client c.
+//
+// When a write is blocked for AgentConfig.WriteTimeout, the client is declared stalled:
+// its output is closed, which unblocks the write, and it's declared dead.
+// Any responses sent to it afterwards are dropped.
+//
+// The returned function stops monitoring.
+func (ag *Agent) monitorWrites(c *agentClient) (stop func()) {
+	sw := c.stallWr
+	timeout := ag.writeTimeout
+	if sw == nil || timeout <= 0 {
+		return func() {}
+	}
+
+	stopC := make(chan struct{})
+	go func() {
+		tick := time.NewTicker(timeout / 4)
+		defer tick.Stop()
+
+		for {
+			select {
+			case <-stopC:
+				return
+			case <-tick.C:
+			}
+
+			if d := sw.blocked(); d >= timeout {
+				c.stall(fmt.Errorf("ipc.send: client %d stopped reading: a write has been blocked for %s", c.id, d.Round(time.Millisecond)))
+				return
+			}
+		}
+	}()
+	return func() { close(stopC) }
+}
+
+// stall handles a client that stopped reading its responses
+func (c *agentClient) stall(err error) {
+	atomic.StoreInt32(&c.stalled, 1)
+	c.stallWr.abort.Close()
+	c.die(err)
+}
+
+// isStalled returns true if the client was declared stalled
+func (c *agentClient) isStalled() bool {
+	return atomic.LoadInt32(&c.stalled) != 0
+}
@@ -0,0 +1,46 @@
+package mg
+
+import (
+	"io"
+	"margo.sh/mgutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAgentWriteTimeout(t *testing.T) {
+	_, outW := io.Pipe()
+	ag, err := NewAgent(AgentConfig{
+		Stdin:        &mgutil.IOWrapper{},
+		Stdout:       outW,
+		Stderr:       &mgutil.IOWrapper{},
+		Codec:        "msgpack",
+		WriteTimeout: 40 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("agent creation failed: %s", err)
+	}
+	c := ag.client
+	stop := ag.monitorWrites(c)
+	defer stop()
+
+	// nothing reads the output so the write blocks until the client is declared stalled
+	sendErr := make(chan error, 1)
+	go func() { sendErr <- c.send(agentRes{Cookie: "blocked"}) }()
+	select {
+	case <-c.deadC:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stalled client wasn't declared dead")
+	}
+	select {
+	case <-sendErr:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the blocked c.send() didn't return")
+	}
+	if !strings.Contains(c.deadErr.Error(), "stopped reading") {
+		t.Errorf("c.deadErr = (%v); want stopped reading error", c.deadErr)
+	}
+	if err := c.send(agentRes{Cookie: "dropped"}); err != errClientStalled {
+		t.Errorf("c.send() after stalling = (%v); want (%v)", err, errClientStalled)
+	}
+}
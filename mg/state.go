@@ -142,6 +142,9 @@ type State struct {
 
 	// clientActions is a list of client actions to dispatch in the editor
 	clientActions []actions.ClientData
+
+	// tails describes the backing arrays of the slice fields, so additions can share them. See cowAppend
+	tails [nTails]*cowTail
 }
 
 // ActionLabel returns a label for the actions act.
@@ -189,7 +192,7 @@ func (st *State) AddTooltips(l ...Tooltip) *State {
 		return st
 	}
 	return st.Copy(func(st *State) {
-		st.Tooltips = append(st.Tooltips[:len(st.Tooltips):len(st.Tooltips)], l...)
+		st.Tooltips = cowAppend(&st.tails[tailTooltips], st.Tooltips, l)
 	})
 }
 
@@ -204,7 +207,7 @@ func (st *State) AddStatus(l ...string) *State {
 		return st
 	}
 	return st.Copy(func(st *State) {
-		st.Status = st.Status.Add(l...)
+		st.Status = cowAddStrs(&st.tails[tailStatus], st.Status, l)
 	})
 }
 
@@ -219,11 +222,13 @@ func (st *State) AddError(l ...error) *State {
 		return st
 	}
 	return st.Copy(func(st *State) {
+		el := make([]string, 0, len(l))
 		for _, e := range l {
 			if e != nil {
-				st.Errors = st.Errors.Add(e.Error())
+				el = append(el, e.Error())
 			}
 		}
+		st.Errors = cowAddStrs(&st.tails[tailErrors], st.Errors, el)
 	})
 }
 
@@ -265,7 +270,7 @@ func (st *State) AddCompletions(l ...Completion) *State {
 		return st
 	}
 	return st.Copy(func(st *State) {
-		st.Completions = append(st.Completions[:len(st.Completions):len(st.Completions)], l...)
+		st.Completions = cowAppend(&st.tails[tailCompletions], st.Completions, l)
 	})
 }
 
@@ -275,7 +280,7 @@ func (st *State) AddIssues(l ...Issue) *State {
 		return st
 	}
 	return st.Copy(func(st *State) {
-		st.Issues = st.Issues.Add(l...)
+		st.Issues = cowAddIssues(&st.tails[tailIssues], st.Issues, l)
 	})
 }
 
@@ -285,7 +290,7 @@ func (st *State) AddBuiltinCmds(l ...BuiltinCmd) *State {
 		return st
 	}
 	return st.Copy(func(st *State) {
-		st.BuiltinCmds = append(st.BuiltinCmds[:len(st.BuiltinCmds):len(st.BuiltinCmds)], l...)
+		st.BuiltinCmds = cowAppend(&st.tails[tailBuiltinCmds], st.BuiltinCmds, l)
 	})
 }
 
@@ -295,7 +300,7 @@ func (st *State) AddUserCmds(l ...UserCmd) *State {
 		return st
 	}
 	return st.Copy(func(st *State) {
-		st.UserCmds = append(st.UserCmds[:len(st.UserCmds):len(st.UserCmds)], l...)
+		st.UserCmds = cowAppend(&st.tails[tailUserCmds], st.UserCmds, l)
 	})
 }
 
@@ -305,12 +310,11 @@ func (st *State) addClientActions(l ...actions.ClientAction) *State {
 		return st
 	}
 	return st.Copy(func(st *State) {
-		el := make([]actions.ClientData, 0, len(st.clientActions)+len(l))
-		el = append(el, st.clientActions...)
-		for _, ca := range l {
-			el = append(el, ca.ClientAction())
+		el := make([]actions.ClientData, len(l))
+		for i, ca := range l {
+			el[i] = ca.ClientAction()
 		}
-		st.clientActions = el
+		st.clientActions = cowAppend(&st.tails[tailClientActions], st.clientActions, el)
 	})
 }
 
@@ -321,6 +325,17 @@ type clientProps struct {
 	}
 	Env  EnvMap
 	View *View
+
+	// StateFields is the list of State fields the client cares about e.g. `Status` and `Issues`.
+	// Other fields are omitted from all responses sent to the client, except ClientActions,
+	// which are always sent. If empty, all fields are sent.
+	// The list sent in the latest request applies.
+	StateFields []string
+
+	// Capabilities is the list of features the client supports e.g. CapAnnotations.
+	// Reducers should check it, using Ctx.ClientSupports, before using features
+	// that older clients can't handle. The list sent in the latest request applies.
+	Capabilities []string
 }
 
 func (cp *clientProps) finalize(ag *Agent) {
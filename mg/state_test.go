@@ -2,6 +2,7 @@ package mg
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -58,3 +59,44 @@ func TestNewAgentNillableFields(t *testing.T) {
 		})
 	}
 }
+
+func TestStateStructuralSharing(t *testing.T) {
+	st := NewTestingStore().state.new()
+	st1 := st.AddStatus("a").AddIssues(Issue{Message: "a"})
+	st2 := st1.AddStatus("b", "a").AddIssues(Issue{Message: "b"})
+	st3 := st1.AddStatus("c").AddIssues(Issue{Message: "c"})
+
+	if &st2.Issues[0] != &st1.Issues[0] {
+		t.Error("the issues added to st1 were copied; want them to share st1's array")
+	}
+	if &st3.Issues[0] == &st1.Issues[0] {
+		t.Error("the issues added to an older version of st1 share its array; want them to be copied")
+	}
+	for _, c := range []struct {
+		st   *State
+		want string
+	}{
+		{st1, "a"},
+		{st2, "a b"},
+		{st3, "a c"},
+	} {
+		msgs := []string{}
+		for _, isu := range c.st.Issues {
+			msgs = append(msgs, isu.Message)
+		}
+		if s := strings.Join(msgs, " "); s != c.want {
+			t.Errorf("Issues = (%s); want (%s)", s, c.want)
+		}
+		if s := strings.Join(c.st.Status, " "); s != c.want {
+			t.Errorf("Status = (%s); want (%s)", s, c.want)
+		}
+	}
+
+	l := append(st2.Status, "raw")
+	if cap(st2.Status) != len(st2.Status) || &l[0] == &st2.Status[0] {
+		t.Error("appending to State.Status directly didn't copy it")
+	}
+	if st4 := st2.AddStatus("d"); strings.Join(st4.Status, " ") != "a b d" {
+		t.Errorf("Status = (%q); want (a b d)", st4.Status)
+	}
+}
@@ -0,0 +1,133 @@
+package mg
+
+import (
+	"reflect"
+	"sync"
+)
+
+// StateDiff returns the names of the top-level fields of State that differ between a and b
+// e.g. `Status` or `View`. The client actions are reported as `ClientActions`.
+//
+// Fields are compared shallowly: slices, maps and pointers are considered changed
+// if they refer to different data, even if their contents are equal.
+// This makes it cheap enough to call after every reducer.
+func StateDiff(a, b *State) StrSet {
+	if a == b {
+		return nil
+	}
+	if a == nil || b == nil {
+		return stateFieldNames()
+	}
+
+	var l StrSet
+	af := encodedFields(reflect.ValueOf(a).Elem())
+	bf := encodedFields(reflect.ValueOf(b).Elem())
+	for i, f := range af {
+		if !shallowEqual(f.val, bf[i].val) {
+			l = append(l, f.name)
+		}
+	}
+	if !shallowEqual(reflect.ValueOf(a.clientActions), reflect.ValueOf(b.clientActions)) {
+		l = append(l, "ClientActions")
+	}
+	return l
+}
+
+// stateFieldNames returns the names of all the fields reported by StateDiff
+func stateFieldNames() StrSet {
+	var l StrSet
+	for _, f := range encodedFields(reflect.ValueOf(State{})) {
+		l = append(l, f.name)
+	}
+	return append(l, "ClientActions")
+}
+
+// shallowEqual returns true if a and b, which are of the same type, hold the same value.
+// Slices, maps and pointers are compared by identity.
+func shallowEqual(a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		return a.Pointer() == b.Pointer()
+	case reflect.Slice:
+		return a.Len() == b.Len() && (a.Len() == 0 || a.Pointer() == b.Pointer())
+	case reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		if a.Elem().Type() != b.Elem().Type() {
+			return false
+		}
+		return shallowEqual(a.Elem(), b.Elem())
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if !shallowEqual(a.Field(i), b.Field(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Array:
+		for i := 0; i < a.Len(); i++ {
+			if !shallowEqual(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a.Equal(b)
+	}
+}
+
+// stateChanges records the State fields modified by the reducers while handling an action.
+// It's shared by all copies of the Ctx created for the action.
+type stateChanges struct {
+	mu     sync.Mutex
+	fields StrSet
+}
+
+// observe records the fields that differ between the states before and after a reduction
+func (sc *stateChanges) observe(before, after *State) {
+	if sc == nil || before == after {
+		return
+	}
+	l := StateDiff(before, after)
+	if len(l) == 0 {
+		return
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.fields = sc.fields.Add(l...)
+}
+
+func (sc *stateChanges) list() StrSet {
+	if sc == nil {
+		return nil
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	return append(StrSet(nil), sc.fields...)
+}
+
+// ChangedFields returns the names of the top-level State fields modified by the reducers
+// that have handled mx.Action so far. See StateDiff for details about how fields are compared.
+func (mx *Ctx) ChangedFields() StrSet {
+	return mx.changes.list()
+}
+
+// Changed returns true if any of the top-level State fields named in names
+// was modified by the reducers that have handled mx.Action so far.
+//
+// Reducers that only depend on some parts of the state may use it to skip work
+// e.g. `if !mx.Changed("View", "Env") { return mx.State }`
+func (mx *Ctx) Changed(names ...string) bool {
+	l := mx.changes.list()
+	for _, s := range names {
+		if l.Has(s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package mg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStateDiff(t *testing.T) {
+	st := NewTestingStore().state.new()
+	if l := StateDiff(st, st); len(l) != 0 {
+		t.Errorf("StateDiff(st, st) = (%q); want no changes", l)
+	}
+	st2 := st.AddStatus("building").addClientActions(Activate{})
+	if l, want := strings.Join(StateDiff(st, st2), " "), "Status ClientActions"; l != want {
+		t.Errorf("StateDiff() = (%s); want (%s)", l, want)
+	}
+	if l := StateDiff(st, st.Copy()); len(l) != 0 {
+		t.Errorf("StateDiff() of a copy = (%q); want no changes", l)
+	}
+}
+
+func TestCtxChanged(t *testing.T) {
+	ag := NewTestingAgent(nil, nil, nil)
+	var changed StrSet
+	var skipped bool
+	ag.Store.Use(
+		NewReducer(func(mx *Ctx) *State {
+			if mx.ActionIs(QueryIssues{}) {
+				return mx.AddStatus("linting")
+			}
+			return mx.State
+		}),
+		NewReducer(func(mx *Ctx) *State {
+			if mx.ActionIs(QueryIssues{}) {
+				changed = mx.ChangedFields()
+				skipped = !mx.Changed("View", "Env")
+			}
+			return mx.State
+		}),
+	)
+	ag.Store.handleAct(QueryIssues{}, nil)
+	if !changed.Has("Status") || changed.Has("View") {
+		t.Errorf("ChangedFields() = (%q); want (Status)", changed)
+	}
+	if !skipped {
+		t.Error("Changed(View, Env) = true; want false")
+	}
+}
@@ -0,0 +1,267 @@
+package mg
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"github.com/ugorji/go/codec"
+	"io"
+	"margo.sh/mg/actions"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// statusErrors is the number of recent errors reported in AgentStatus
+	statusErrors = 16
+
+	statusCookie = "margo.status"
+)
+
+// QueryStatus is the action dispatched by the client to request a summary of the agent's health.
+//
+// The agent responds with an AgentStatus client action. See QueryAgentStatus
+type QueryStatus struct{ ActionType }
+
+// AgentStatus is the client action dispatched in response to QueryStatus
+type AgentStatus struct {
+	ActionType
+
+	// Name is the name of the agent
+	Name string
+
+	// Started is the time at which the agent started
+	Started time.Time
+
+	// Clients is the number of connected clients
+	Clients int
+
+	// InFlight is the number of requests being handled and Requests is the total number of requests received
+	InFlight int
+	Requests int64
+
+	// SubAgents is the sorted list of names of the running sub-agents
+	SubAgents []string
+
+	// Reducers is the list of labels of the reducers in the order they're called
+	Reducers []string
+
+	// DisabledReducers is the sorted list of labels of the reducers disabled because they panicked too many times
+	DisabledReducers []string
+
+	// CacheEntries is the number of values stored in Store.KVMap
+	CacheEntries int
+
+	// Tasks is the list of active tasks
+	Tasks []TaskStatus
+
+	// Errors is the list of most recent errors reported by reducers, oldest first
+	Errors []ErrorStatus
+}
+
+func (as AgentStatus) ClientAction() actions.ClientData {
+	return actions.ClientData{Name: "AgentStatus", Data: as}
+}
+
+// TaskStatus describes an active task
+type TaskStatus struct {
+	ID    string
+	Title string
+	Start time.Time
+}
+
+// ErrorStatus describes an error reported by reducers
+type ErrorStatus struct {
+	// Time is the last time the error was reported
+	Time time.Time
+
+	Message string
+}
+
+// statusTracker records the recent errors reported by reducers and responds to QueryStatus
+//
+// Errors are recorded when the final state of each reduction is sent to the clients,
+// so errors reported by reducers that run after it are not missed.
+type statusTracker struct {
+	ReducerType
+
+	mu      sync.Mutex
+	started time.Time
+	errors  []ErrorStatus
+}
+
+func newStatusTracker() *statusTracker {
+	return &statusTracker{started: time.Now()}
+}
+
+// observeErrors records errs, moving errors that were already recorded to the end of the list
+func (stt *statusTracker) observeErrors(errs StrSet) {
+	if len(errs) == 0 {
+		return
+	}
+
+	stt.mu.Lock()
+	defer stt.mu.Unlock()
+
+	now := time.Now()
+	for _, e := range errs {
+		l := stt.errors[:0]
+		for _, p := range stt.errors {
+			if p.Message != e {
+				l = append(l, p)
+			}
+		}
+		stt.errors = append(l, ErrorStatus{Time: now, Message: e})
+	}
+	if n := len(stt.errors) - statusErrors; n > 0 {
+		stt.errors = append([]ErrorStatus(nil), stt.errors[n:]...)
+	}
+}
+
+func (stt *statusTracker) Reduce(mx *Ctx) *State {
+	if _, ok := mx.Action.(QueryStatus); ok {
+		return mx.addClientActions(mx.Store.ag.status())
+	}
+	return mx.State
+}
+
+// status returns the current status of the agent
+func (ag *Agent) status() AgentStatus {
+	sto := ag.Store
+	inFlight, total := ag.reqs.stats()
+	as := AgentStatus{
+		Name:             ag.Name,
+		Clients:          len(ag.clients.list()),
+		InFlight:         inFlight,
+		Requests:         total,
+		SubAgents:        ag.subs.names(),
+		Reducers:         sto.reducerLabels(),
+		DisabledReducers: sto.quarantine.list(),
+		CacheEntries:     sto.KVMap.Len(),
+		Tasks:            sto.tasks.list(),
+	}
+
+	stt := sto.status
+	stt.mu.Lock()
+	defer stt.mu.Unlock()
+
+	as.Started = stt.started
+	as.Errors = append([]ErrorStatus(nil), stt.errors...)
+	return as
+}
+
+// QueryAgentStatus connects to the agent listening on addr and returns its status.
+//
+// addr is the agent's AgentConfig.Listen address e.g. `unix:/tmp/margo.sock`
+// and token is the token it was started with. WebSocket addresses are not supported.
+// fingerprint is the fingerprint of the agent's certificate, as printed in its log, and is required for tls addresses.
+// codecName is the name of the agent's codec. If empty, DefaultCodec is used.
+func QueryAgentStatus(addr, token, fingerprint, codecName string, timeout time.Duration) (AgentStatus, error) {
+	as := AgentStatus{}
+	err := queryAgent(addr, token, fingerprint, codecName, timeout, "QueryStatus", "AgentStatus", &as)
+	return as, err
+}
+
+// QueryReducerProfile connects to the agent listening on addr and returns the profiling report of its reducers.
+//
+// The arguments are the same as for QueryAgentStatus
+func QueryReducerProfile(addr, token, fingerprint, codecName string, timeout time.Duration) (ReducerProfile, error) {
+	rp := ReducerProfile{}
+	err := queryAgent(addr, token, fingerprint, codecName, timeout, "QueryProfile", "ReducerProfile", &rp)
+	return rp, err
+}
+
+// queryAgent connects to the agent listening on addr, dispatches the action named actName
+// and decodes the data of the client action named caName, sent in response, into out
+func queryAgent(addr, token, fingerprint, codecName string, timeout time.Duration, actName, caName string, out interface{}) error {
+	h := codecHandles[codecName]
+	if h == nil {
+		return fmt.Errorf("Invalid codec '%s'. Expected %s", codecName, CodecNamesStr)
+	}
+	if token == "" {
+		return fmt.Errorf("the agent's token is required")
+	}
+	ln, err := newAgentListener(addr, token)
+	if err != nil {
+		return err
+	}
+	if ln.ws {
+		return fmt.Errorf("Invalid address '%s'. WebSocket is not supported", addr)
+	}
+	if ln.tls && fingerprint == "" {
+		return fmt.Errorf("the agent's certificate fingerprint is required")
+	}
+	ln.fingerprint = fingerprint
+
+	conn, err := dialAgent(ln, timeout)
+	if err != nil {
+		return fmt.Errorf("cannot connect to the agent: %s", err)
+	}
+	defer conn.Close()
+	if timeout > 0 {
+		if dc, ok := conn.(interface{ SetDeadline(time.Time) error }); ok {
+			dc.SetDeadline(time.Now().Add(timeout))
+		}
+	}
+
+	if _, err := io.WriteString(conn, ln.token+"\n"); err != nil {
+		return err
+	}
+	rq := struct {
+		Cookie  string
+		Actions []struct{ Name string }
+	}{
+		Cookie:  statusCookie,
+		Actions: []struct{ Name string }{{Name: actName}},
+	}
+	if err := codec.NewEncoder(conn, h).Encode(rq); err != nil {
+		return err
+	}
+
+	dec := codec.NewDecoder(bufio.NewReader(conn), h)
+	for {
+		res := struct {
+			Cookie  string
+			Error   string
+			Partial bool
+			State   struct {
+				ClientActions []struct {
+					Name string
+					Data codec.Raw
+				}
+			}
+		}{}
+		if err := dec.Decode(&res); err != nil {
+			return fmt.Errorf("cannot read response: %s", err)
+		}
+		if res.Cookie != statusCookie {
+			continue
+		}
+		for _, ca := range res.State.ClientActions {
+			if ca.Name == caName {
+				return codec.NewDecoderBytes(ca.Data, h).Decode(out)
+			}
+		}
+		if res.Partial {
+			continue
+		}
+		if res.Error != "" {
+			return fmt.Errorf("the agent responded with an error: %s", res.Error)
+		}
+		return fmt.Errorf("the agent didn't respond with the %s client action", caName)
+	}
+}
+
+// dialAgent connects to the agent listening on ln
+func dialAgent(ln agentListener, timeout time.Duration) (io.ReadWriteCloser, error) {
+	if ln.network == "npipe" {
+		return os.OpenFile(ln.addr, os.O_RDWR, 0)
+	}
+	if ln.tls {
+		d := &net.Dialer{Timeout: timeout}
+		return tls.DialWithDialer(d, ln.network, ln.addr, dialTLSConfig(ln.fingerprint))
+	}
+	return net.DialTimeout(ln.network, ln.addr, timeout)
+}
@@ -0,0 +1,51 @@
+package mg
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestQueryAgentStatus(t *testing.T) {
+	ag, addr, runErr := listenTestAgent(t)
+	ag.Store.Use(NewReducer(func(mx *Ctx) *State {
+		if _, ok := mx.Action.(QueryStatus); ok {
+			return mx.AddErrorf("status error")
+		}
+		return mx.State
+	}))
+
+	// keep a client connected so the agent doesn't exit between queries
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("net.Dial(%s): %s", addr, err)
+	}
+	fmt.Fprintln(conn, "secret")
+	go io.Copy(ioutil.Discard, conn)
+
+	// the error reported while handling the first query is only recorded once its response is sent
+	if _, err := QueryAgentStatus("tcp:"+addr, "secret", "", "msgpack", 5*time.Second); err != nil {
+		t.Fatalf("QueryAgentStatus() = (%v); want (nil)", err)
+	}
+	as, err := QueryAgentStatus("tcp:"+addr, "secret", "", "msgpack", 5*time.Second)
+	if err != nil {
+		t.Fatalf("QueryAgentStatus() = (%v); want (nil)", err)
+	}
+	if as.Started.IsZero() || as.Requests < 2 || len(as.Reducers) == 0 {
+		t.Errorf("status = (%+v); want Started, Requests >= 2 and Reducers", as)
+	}
+	if len(as.Errors) != 1 || as.Errors[0].Message != "status error" {
+		t.Errorf("status.Errors = (%+v); want the status error", as.Errors)
+	}
+
+	if _, err := QueryAgentStatus("tcp:"+addr, "wrong", "", "msgpack", 5*time.Second); err == nil {
+		t.Error("QueryAgentStatus() with an invalid token = (nil); want (error)")
+	}
+	conn.Close()
+	if err := <-runErr; err != nil {
+		t.Fatalf("ag.Run() = (%v); want (nil)", err)
+	}
+}
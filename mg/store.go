@@ -1,11 +1,15 @@
 package mg
 
 import (
+	"fmt"
 	"margo.sh/mgpf"
 	yotsuba "margo.sh/why_would_you_make_yotsuba_cry"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var _ Dispatcher = (&Store{}).Dispatch
@@ -22,18 +26,29 @@ type storeReducers struct {
 	before reducerList
 	use    reducerList
 	after  reducerList
+
+	// sorted is the list of all reducers in the order they're called. See sortReducers
+	sorted reducerList
+
+	// sections splits sorted into runs of reducers from the same list,
+	// so each run is profiled under the name of its list
+	sections []storeReducersSection
+
+	// orderErr is the error reported if the order constraints of the reducers can't be satisfied
+	orderErr error `mg.Nillable:"true"`
+}
+
+type storeReducersSection struct {
+	name     string
+	reducers reducerList
 }
 
 func (sr storeReducers) reduction(mx *Ctx) *Ctx {
-	mx.Profile.Do("Before", func() {
-		mx = sr.before.reduction(mx)
-	})
-	mx.Profile.Do("Use", func() {
-		mx = sr.use.reduction(mx)
-	})
-	mx.Profile.Do("After", func() {
-		mx = sr.after.reduction(mx)
-	})
+	for _, sec := range sr.sections {
+		mx.Profile.Do(sec.name, func() {
+			mx = sec.reducers.reduction(mx)
+		})
+	}
 	return mx.defr.reduction(mx)
 }
 
@@ -41,6 +56,21 @@ func (sr storeReducers) Copy(updaters ...func(*storeReducers)) storeReducers {
 	for _, f := range updaters {
 		f(&sr)
 	}
+
+	names := []string{"Before", "Use", "After"}
+	sorted, from, err := sortReducers(sr.before, sr.use, sr.after)
+	sr.sorted, sr.orderErr, sr.sections = sorted, err, nil
+	for i := 0; i < len(sorted); {
+		j := i + 1
+		for j < len(sorted) && from[j] == from[i] {
+			j++
+		}
+		sr.sections = append(sr.sections, storeReducersSection{
+			name:     names[from[i]],
+			reducers: sorted[i:j:j],
+		})
+		i = j
+	}
 	return sr
 }
 
@@ -49,6 +79,8 @@ type Store struct {
 	// KVMap is an in-memory cache of data with automatic eviction.
 	// Eviction might happen if the active view changes.
 	//
+	// Store.Update can be used to read and write several values atomically.
+	//
 	// NOTE: it's not safe to store values with *Ctx objects here; use *Ctx.KVMap instead
 	KVMap
 
@@ -60,20 +92,70 @@ type Store struct {
 		sync.Mutex
 		storeReducers
 	}
-	cfg   EditorConfig `mg.Nillable:"true"`
-	ag    *Agent
-	tasks *taskTracker
+	cfg     EditorConfig `mg.Nillable:"true"`
+	ag      *Agent
+	tasks   *taskTracker
+	jobs    *Jobs
+	metrics *metricsTracker
+	status  *statusTracker
+	history *historyTracker
+
+	// middleware is protected by mu. See Store.UseMiddleware
+	middleware []Middleware
+
+	// reduceMu serializes reductions. See Store.handle
+	reduceMu sync.Mutex
+
+	// reducerLocks serializes the reductions of each reducer. See reducerLocks
+	reducerLocks reducerLocks
+
+	// reducerTimeout is accessed atomically. See Store.SetReducerTimeout
+	reducerTimeout int64
+
+	// reducerPanicLimit is accessed atomically. See Store.SetReducerPanicLimit
+	reducerPanicLimit int64
+	quarantine        reducerQuarantine
+	rswitch           *reducerSwitch
+	rprof             *reducerProfiler
+
+	viewStates viewStates
+
+	// bus is the event bus. See Store.Publish
+	bus eventBus
+
+	// replay is the log of recent actions written when the agent crashes. See Store.SetReplayLogSize
+	replay replayLog
+
+	// memPressure holds the heap budget. See Store.SetHeapBudget
+	memPressure memPressure
+
+	// deps is the KVDeps returned by Store.Deps
+	deps *KVDeps
+
+	// sharedKV is the store returned by Store.SharedKV
+	sharedKV *SharedKV
+
+	// diskKV holds the stores returned by Store.DiskKV
+	diskKV struct {
+		sync.Mutex
+		m map[string]*KVDisk
+	}
+
 	cache struct {
 		sync.RWMutex
 		vName string
 		vHash string
 	}
 
+	// dsp holds the dispatch queues: hi for client requests,
+	// then interactive, lo and housekeeping for dispatched actions of the corresponding Priority
 	dsp struct {
 		sync.RWMutex
-		lo        chan dispatchHandler
-		hi        chan dispatchHandler
-		unmounted bool
+		lo           chan dispatchHandler
+		hi           chan dispatchHandler
+		interactive  chan dispatchHandler
+		housekeeping chan dispatchHandler
+		unmounted    bool
 	}
 }
 
@@ -99,41 +181,58 @@ func (sto *Store) unmount() {
 	<-done
 }
 
-// Dispatch schedules a new reduction with Action act
+// Dispatch schedules a new reduction with Action act, with priority PriorityNormal
 //
 // * actions coming from the editor has a higher priority
 // * as a result, if Shutdown is dispatched, the action might be dropped
+// * to dispatch an action with a different priority, see Store.DispatchPriority
+//
+// act first goes through the middleware chain. See Store.UseMiddleware
 func (sto *Store) Dispatch(act Action) {
-	c := sto.dsp.lo
-	f := func() { sto.handleAct(act, nil) }
-	select {
-	case c <- f:
-	default:
-		go func() { c <- f }()
-	}
+	sto.middlewareChain(sto.dispatch)(act)
 }
 
-func (sto *Store) nextDispatcher() dispatchHandler {
-	var h dispatchHandler
-	select {
-	case h = <-sto.dsp.hi:
-	default:
-		select {
-		case h = <-sto.dsp.hi:
-		case h = <-sto.dsp.lo:
-		}
+// DispatchBatch schedules a single reduction of all the actions in l, in order
+//
+// Unlike calling Store.Dispatch for each action, the state is only sent to the client,
+// and subscribers notified, once all the actions are reduced.
+// Each action first goes through the middleware chain. See Store.UseMiddleware
+func (sto *Store) DispatchBatch(l ...Action) {
+	var acts []Action
+	for _, act := range l {
+		sto.applyMiddleware(act, func(act Action) {
+			acts = append(acts, act)
+		})
+	}
+	if len(acts) == 0 {
+		return
 	}
+	sto.schedule(func() { sto.handleActs(acts, nil) })
+}
 
-	sto.dsp.RLock()
-	defer sto.dsp.RUnlock()
+// dispatch schedules a new reduction with Action act, bypassing the middleware chain
+func (sto *Store) dispatch(act Action) {
+	sto.dispatchPriority(act, PriorityNormal)
+}
 
-	if sto.dsp.unmounted {
-		return nil
+// schedule schedules f to be called by the dispatcher with priority PriorityNormal
+func (sto *Store) schedule(f dispatchHandler) {
+	sto.schedulePriority(f, PriorityNormal)
+}
+
+// schedulePriority schedules f to be called by the dispatcher with priority p
+func (sto *Store) schedulePriority(f dispatchHandler, p Priority) {
+	c := sto.queue(p)
+	select {
+	case c <- f:
+	default:
+		go func() { c <- f }()
 	}
-	return h
 }
 
 func (sto *Store) dispatcher() {
+	defer sto.ag.guardCrash()
+
 	sto.ag.Log.Println("started")
 	sto.handleAct(initAction{}, nil)
 
@@ -147,66 +246,192 @@ func (sto *Store) dispatcher() {
 }
 
 func (sto *Store) handleReduction(mx *Ctx, cookie string, pf *mgpf.Profile) *Ctx {
+	sto.reducers.Lock()
+	sr := sto.reducers.storeReducers
+	sto.reducers.Unlock()
+
+	doneC, cancelOnce := mx.doneC, mx.cancelOnce
 	for mx.Acts.i = 0; mx.Acts.i < len(mx.Acts.l); mx.Acts.i++ {
+		i := mx.Acts.i
 		st := mx.State.new()
 		st.Errors = mx.State.Errors
+		prev := mx
 		mx = newCtx(sto, st, mx.Acts, cookie, pf, mx.KVMap)
-		mx.Profile.Do("action|"+ActionLabel(mx.Action), func() {
-			mx = sto.reducers.reduction(mx)
+		mx.doneC, mx.cancelOnce, mx.req = doneC, cancelOnce, prev.req
+		mx.TraceID, mx.Log = prev.TraceID, prev.Log
+		stop := func() {}
+		if dl, ok := mx.req.actionDeadline(i); ok {
+			stop = mx.withDeadline(dl)
+		}
+		sto.replay.record(mx)
+		name := ActionLabel(mx.Action)
+		start := time.Now()
+		var pe *panicError
+		mx.Profile.Do("action|"+name, func() {
+			mx, pe = sto.safeReduction(sr, mx)
+		})
+		if pe == nil {
+			pe = mx.panicked
+		}
+		sto.metrics.observe(name, time.Since(start))
+		sto.history.record(mx)
+		if _, ok := mx.Action.(ViewClosed); ok {
+			sto.viewStates.drop(mx.View.Name)
+		}
+		stop()
+		if rq := mx.req; rq != nil {
+			rq.setActionErrors(i, st.Errors, mx.State.Errors)
+			if pe != nil {
+				rq.setActionStack(i, pe.stack)
+			}
+		}
+	}
+	sto.viewStates.observe(mx.View.Name, time.Now())
+	if mx.doneC != doneC {
+		mx = mx.Copy(func(mx *Ctx) {
+			mx.doneC, mx.cancelOnce, mx.deadline = doneC, cancelOnce, time.Time{}
 		})
 	}
 	return mx
 }
 
-func (sto *Store) handle(h func() *Ctx, p *mgpf.Profile) {
+// handle calls h with a new state based on the current state and commits the resulting state.
+//
+// handle may be called concurrently, but reductions are serialized:
+// the snapshot, reduction and commit happen while sto.reduceMu is held,
+// so each reduction starts from the state committed by the previous one, and no update is lost.
+// Only the work done outside the reduction is concurrent e.g. creating the actions of a request,
+// and notifying the subscribers, which encode and send responses. See AgentConfig.Workers
+//
+// sto.mu is only held while taking the snapshot and committing the result,
+// so the state can still be read during a reduction.
+func (sto *Store) handle(h func(*State) *Ctx, p *mgpf.Profile) {
 	p.Push("handleRequest")
-	sto.mu.Lock()
 
-	mx := h()
-	sto.state = mx.State
-	subs := sto.subs
+	var prevCfg EditorConfig
+	var subs []*struct{ Subscriber }
+	mx := func() *Ctx {
+		sto.reduceMu.Lock()
+		defer sto.reduceMu.Unlock()
+
+		sto.mu.Lock()
+		prevCfg = sto.state.Config
+		st := sto.state.new()
+		if st.Config == nil {
+			st = st.SetConfig(sto.cfg)
+		}
+		sto.mu.Unlock()
+
+		mx := h(st)
+
+		sto.mu.Lock()
+		sto.state = mx.State
+		subs = sto.subs
+		sto.mu.Unlock()
+		return mx
+	}()
 
-	sto.mu.Unlock()
 	p.Pop()
 
 	for _, p := range subs {
 		p.Subscriber(mx)
 	}
+
+	if configChanged(prevCfg, mx.State.Config) && !mx.ActionIs(ConfigChanged{}) {
+		sto.Dispatch(ConfigChanged{})
+	}
+}
+
+// configChanged returns true if the config changed from prev, after it was first set
+func configChanged(prev, cur EditorConfig) bool {
+	if prev == nil || cur == nil {
+		return false
+	}
+	return !reflect.DeepEqual(prev.EditorConfig(), cur.EditorConfig())
 }
 
 func (sto *Store) handleAct(act Action, p *mgpf.Profile) {
+	sto.handleActs([]Action{act}, p)
+}
+
+// handleActs reduces the actions in l in a single reduction, so subscribers are only notified once
+func (sto *Store) handleActs(l []Action, p *mgpf.Profile) {
 	if p == nil {
 		p = mgpf.NewProfile("")
 	}
-	sto.handle(func() *Ctx {
-		mx := newCtx(sto, nil, &ctxActs{l: []Action{act}}, "", p, nil)
+	sto.handle(func(st *State) *Ctx {
+		mx := newCtx(sto, st, &ctxActs{l: l}, "", p, nil)
 		return sto.handleReduction(mx, "", p)
 	}, p)
 }
 
 func (sto *Store) handleReq(rq *agentReq) {
-	sto.handle(func() *Ctx {
-		mx := sto.handleReqInit(rq, newCtx(sto, nil, nil, rq.Cookie, rq.Profile, nil))
+	// create the actions before the reduction, so it's done concurrently when AgentConfig.Workers > 1
+	acts := sto.createReqActions(rq)
+	sto.handle(func(st *State) *Ctx {
+		mx := newCtx(sto, st, nil, rq.Cookie, rq.Profile, nil)
+		mx.doneC, mx.cancelOnce, mx.req = rq.doneC, rq.cancelOnce, rq
+		mx.TraceID, mx.Log = rq.TraceID, mx.Log.traced(rq.TraceID)
+		mx = sto.handleReqInit(rq, mx, acts)
 		return sto.handleReduction(mx, rq.Cookie, rq.Profile)
 	}, rq.Profile)
 }
 
-func (sto *Store) handleReqInit(rq *agentReq, mx *Ctx) *Ctx {
+// createReqActions creates the actions of rq.
+//
+// The action at index i is nil if the i'th action of rq is handled asynchronously,
+// or if it couldn't be created, in which case its result holds the error.
+func (sto *Store) createReqActions(rq *agentReq) []Action {
+	defer rq.Profile.Push("createActions").Pop()
+
+	acts := make([]Action, len(rq.Actions))
+	rq.results = make([]actionResult, len(rq.Actions))
+	for i, ra := range rq.Actions {
+		rq.results[i].Name = ra.Name
+		if tok := rq.asyncToken(i); tok != "" {
+			rq.results[i].Token = tok
+			continue
+		}
+		act, err := sto.ag.createAction(ra)
+		if err != nil {
+			rq.results[i].Error = fmt.Sprintf("createAction(%s): %s", ra.Name, err)
+			if pe, ok := err.(*panicError); ok {
+				rq.results[i].Stack = string(pe.stack)
+			}
+			continue
+		}
+		acts[i] = act
+	}
+	return acts
+}
+
+// handleReqInit initializes mx, the Ctx of the reduction of rq, whose actions were created by createReqActions
+func (sto *Store) handleReqInit(rq *agentReq, mx *Ctx, acts []Action) *Ctx {
 	defer mx.Profile.Push("init").Pop()
 
 	if mx.Acts == nil {
-		mx.Acts = &ctxActs{l: make([]Action, 0, len(rq.Actions))}
+		mx.Acts = &ctxActs{l: make([]Action, 0, len(acts))}
 	}
-	for _, ra := range rq.Actions {
-		act, err := sto.ag.createAction(ra)
-		if err != nil {
-			mx.State = mx.AddErrorf("createAction(%s): %s", ra.Name, err)
-		} else {
-			mx.Acts.l = append(mx.Acts.l, act)
+	if c := rq.client; c != nil && atomic.CompareAndSwapInt32(&c.connected, 0, 1) {
+		// reduce it before the client's first actions, so reducers see it first
+		mx.Acts.l = append(mx.Acts.l, ClientConnected{ClientID: c.id})
+		rq.resultIdx = append(rq.resultIdx, -1)
+	}
+	for i, act := range acts {
+		if act == nil {
+			if msg := rq.results[i].Error; msg != "" {
+				mx.State = mx.AddErrorf("%s", msg)
+			}
+			continue
 		}
+		i := i
+		sto.applyMiddleware(act, func(act Action) {
+			mx.Acts.l = append(mx.Acts.l, act)
+			rq.resultIdx = append(rq.resultIdx, i)
+		})
 	}
 
-	if cfg := sto.cfg; cfg != nil {
+	if cfg := sto.baseConfig(); cfg != nil {
 		mx.Config = cfg
 	}
 	props := rq.Props
@@ -252,18 +477,35 @@ func (sto *Store) NewCtx(act Action) *Ctx {
 
 func newStore(ag *Agent, sub Subscriber) *Store {
 	sto := &Store{
-		sub: sub,
-		ag:  ag,
+		sub:               sub,
+		ag:                ag,
+		reducerPanicLimit: DefaultReducerPanicLimit,
 	}
 	sto.state = &State{
 		StickyState: StickyState{View: newView(sto)},
 	}
+	sto.viewStates.ttl = DefaultViewStateTTL
+	sto.replay.size = DefaultReplayLogSize
 	sto.tasks = &taskTracker{}
-	sto.After(sto.tasks)
+	sto.jobs = newJobs(sto)
+	sto.metrics = newMetricsTracker()
+	sto.metrics.reportKV("Store", &sto.KVMap)
+	sto.RegisterShedder("Store", &sto.KVMap)
+	sto.sharedKV = newSharedKV("", ag.Log)
+	sto.metrics.reportKV("SharedKV", sto.sharedKV)
+	sto.status = newStatusTracker()
+	sto.history = &historyTracker{}
+	sto.rswitch = &reducerSwitch{}
+	sto.rprof = newReducerProfiler()
+	sto.deps = NewKVDeps(&sto.KVMap)
+	sto.Before(&kvDepsInvalidator{deps: sto.deps})
+	sto.After(&completionRanker{}, sto.tasks, sto.metrics, sto.status, sto.history, sto.rswitch, sto.rprof, &stateSupport{dir: ag.stateDir})
 
 	// 640 slots ought to be enough for anybody
 	sto.dsp.lo = make(chan dispatchHandler, 640)
 	sto.dsp.hi = make(chan dispatchHandler, 640)
+	sto.dsp.interactive = make(chan dispatchHandler, 640)
+	sto.dsp.housekeeping = make(chan dispatchHandler, 640)
 
 	return sto
 }
@@ -291,16 +533,66 @@ func (sto *Store) Subscribe(sub Subscriber) (unsubscribe func()) {
 	}
 }
 
+// SubscribeFunc arranges for cb to be called after a reduction takes place
+// if the slice of the state returned by selector changed since cb was last called.
+//
+// The slices are compared using reflect.DeepEqual, so selector should return
+// only the data cb depends on e.g. State.Issues or HUD, and not data that changes on each reduction.
+// cb is called after the first reduction, and isn't called concurrently.
+// The function returned can be used to unsubscribe from further notifications
+func (sto *Store) SubscribeFunc(selector func(*State) interface{}, cb func(mx *Ctx, selected interface{})) (unsubscribe func()) {
+	var (
+		mu     sync.Mutex
+		called bool
+		last   interface{}
+	)
+	return sto.Subscribe(func(mx *Ctx) {
+		v := selector(mx.State)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if called && reflect.DeepEqual(last, v) {
+			return
+		}
+		called, last = true, v
+		cb(mx, v)
+	})
+}
+
+// reducerLabels returns the labels of all reducers, in the order they're called
+func (sto *Store) reducerLabels() []string {
+	sto.reducers.Lock()
+	sr := sto.reducers.storeReducers
+	sto.reducers.Unlock()
+
+	l := make([]string, len(sr.sorted))
+	for i, r := range sr.sorted {
+		l[i] = ReducerLabel(r)
+	}
+	return l
+}
+
 func (sto *Store) updateReducers(updaters ...func(*storeReducers)) *Store {
 	sto.reducers.Lock()
 	defer sto.reducers.Unlock()
 
 	sto.reducers.storeReducers = sto.reducers.Copy(updaters...)
+	if err := sto.reducers.orderErr; err != nil && sto.ag != nil && sto.ag.Log != nil {
+		sto.ag.Log.Println(err)
+	}
 	return sto
 }
 
 // Before adds reducers to the list of reducers
 // they're are called before normal (Store.Use) reducers
+//
+// Reducers are called in the order they're registered, first those added with Before,
+// then with Use and finally with After,
+// except where they declare, using Reducer.RRunAfter and Reducer.RRunBefore,
+// the labels of reducers that must be called before or after them.
+// Reducers are only moved as needed to satisfy those constraints.
+// If they conflict, the reducers involved are called in registration order and an error is logged.
 func (sto *Store) Before(reducers ...Reducer) *Store {
 	return sto.updateReducers(func(sr *storeReducers) {
 		sr.before = sr.before.Add(reducers...)
@@ -336,6 +628,13 @@ func (sto *Store) SetBaseConfig(cfg EditorConfig) *Store {
 	return sto
 }
 
+func (sto *Store) baseConfig() EditorConfig {
+	sto.mu.Lock()
+	defer sto.mu.Unlock()
+
+	return sto.cfg
+}
+
 // Begin starts a new task and returns its ticket
 func (sto *Store) Begin(t Task) *TaskTicket {
 	return sto.tasks.Begin(t)
@@ -0,0 +1,192 @@
+package mg
+
+import (
+	"margo.sh/mg/actions"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type storeTestIncr struct{ ActionType }
+
+func TestStoreConcurrentReductions(t *testing.T) {
+	ag := NewTestingAgent(nil, nil, nil)
+	ag.Store.Use(NewReducer(func(mx *Ctx) *State {
+		if !mx.ActionIs(storeTestIncr{}) {
+			return mx.State
+		}
+		n, _ := strconv.Atoi(mx.Env.Get("N", "0"))
+		// give other reductions a chance to start from the same state
+		time.Sleep(time.Millisecond)
+		return mx.SetEnv(mx.Env.Add("N", strconv.Itoa(n+1)))
+	}))
+
+	const n = 50
+	wg := sync.WaitGroup{}
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ag.Store.handleAct(storeTestIncr{}, nil)
+		}()
+	}
+	wg.Wait()
+
+	ag.Store.mu.Lock()
+	st := ag.Store.state
+	ag.Store.mu.Unlock()
+	if got := st.Env.Get("N", ""); got != strconv.Itoa(n) {
+		t.Errorf("Env[N] = (%s) after %d concurrent reductions; want (%d)", got, n, n)
+	}
+}
+
+func TestStoreCreateActionsConcurrently(t *testing.T) {
+	created := make(chan struct{}, 2)
+	release := make(chan struct{})
+	name := "mg.storeTestSlowCreate"
+	err := RegisterActionCreator(name, func(actions.ActionData) (Action, error) {
+		created <- struct{}{}
+		<-release
+		return storeTestIncr{}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ag := NewTestingAgent(nil, nil, nil)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 2; i++ {
+		rq := newAgentReq(ag.Store)
+		rq.Actions = []actions.ActionData{{Name: name}}
+		rq.finalize(ag)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ag.Store.handleReq(rq)
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-created:
+		case <-time.After(5 * time.Second):
+			close(release)
+			t.Fatal("the actions of concurrent requests weren't created concurrently")
+		}
+	}
+	close(release)
+	wg.Wait()
+}
+
+func TestStoreReducerLocksDropped(t *testing.T) {
+	ag := NewTestingAgent(nil, nil, nil)
+	ag.Store.Use(NewReducer(func(mx *Ctx) *State { return mx.State }))
+	ag.Store.handleAct(storeTestIncr{}, nil)
+
+	locks := &ag.Store.reducerLocks
+	count := func() int {
+		locks.mu.Lock()
+		defer locks.mu.Unlock()
+		return len(locks.m)
+	}
+	if count() == 0 {
+		t.Fatal("no reducer locks were created")
+	}
+	ag.Store.handleAct(unmount{}, nil)
+	if n := count(); n != 0 {
+		t.Errorf("%d reducer locks remain after the reducers were unmounted; want 0", n)
+	}
+}
+
+func TestStoreSubscribeFunc(t *testing.T) {
+	ag := NewTestingAgent(nil, nil, nil)
+	ag.Store.Use(NewReducer(func(mx *Ctx) *State {
+		if act, ok := mx.Action.(RunCmd); ok {
+			return mx.AddStatus(act.Name)
+		}
+		return mx.AddStatus("idle")
+	}))
+
+	var got []string
+	ag.Store.SubscribeFunc(func(st *State) interface{} {
+		return st.Status
+	}, func(mx *Ctx, v interface{}) {
+		got = append(got, strings.Join(v.(StrSet), ","))
+	})
+
+	for _, act := range []Action{QueryIssues{}, QueryTooltips{}, RunCmd{Name: "build"}, RunCmd{Name: "build"}, QueryIssues{}} {
+		ag.Store.handleAct(act, nil)
+	}
+	if s, want := strings.Join(got, " "), "idle build idle"; s != want {
+		t.Errorf("the callback was called with (%s); want (%s)", s, want)
+	}
+}
+
+func TestStoreDispatchBatch(t *testing.T) {
+	ag := NewTestingAgent(nil, nil, nil)
+	var reduced []string
+	ag.Store.Use(NewReducer(func(mx *Ctx) *State {
+		switch mx.Action.(type) {
+		case QueryIssues, QueryTooltips, QueryUserCmds:
+			reduced = append(reduced, ActionLabel(mx.Action))
+			return mx.AddStatus(ActionLabel(mx.Action))
+		}
+		return mx.State
+	}))
+	renders := 0
+	ag.Store.Subscribe(func(*Ctx) { renders++ })
+
+	ag.Store.DispatchBatch(QueryIssues{}, QueryTooltips{}, QueryUserCmds{})
+	(<-ag.Store.dsp.lo)()
+	if s, want := strings.Join(reduced, " "), "mg.QueryIssues mg.QueryTooltips mg.QueryUserCmds"; s != want {
+		t.Errorf("reduced = (%s); want (%s)", s, want)
+	}
+	if renders != 1 {
+		t.Errorf("subscribers were notified %d times; want 1", renders)
+	}
+	select {
+	case <-ag.Store.dsp.lo:
+		t.Error("DispatchBatch scheduled more than one reduction")
+	default:
+	}
+}
+
+func TestStoreUpdate(t *testing.T) {
+	sto := NewTestingStore()
+	type K struct{ name string }
+	wg := sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sto.Update(func(tx Tx) {
+				n, _ := tx.Get(K{"n"}).(int)
+				tx.Put(K{"n"}, n+1)
+				tx.Put(K{"last"}, n+1)
+			})
+		}()
+	}
+	wg.Wait()
+	if n, _ := sto.Get(K{"n"}).(int); n != 50 {
+		t.Errorf("n = %d; want each update to see the previous one", n)
+	}
+
+	func() {
+		defer func() { recover() }()
+		sto.Update(func(tx Tx) {
+			tx.Del(K{"n"})
+			if v := tx.Get(K{"n"}); v != nil {
+				t.Errorf("Get() of a value deleted in the transaction = (%v); want nil", v)
+			}
+			tx.Put(K{"last"}, -1)
+			panic("aborted")
+		})
+	}()
+	if n, _ := sto.Get(K{"n"}).(int); n != 50 {
+		t.Errorf("n = %d after a panicking update; want its writes to be discarded", n)
+	}
+	if v, _ := sto.Get(K{"last"}).(int); v != 50 {
+		t.Errorf("last = %d after a panicking update; want its writes to be discarded", v)
+	}
+}
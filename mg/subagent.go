@@ -0,0 +1,121 @@
+package mg
+
+import (
+	"fmt"
+	"margo.sh/mgutil"
+	"sort"
+	"sync"
+)
+
+// subAgents is the set of sub-agents started by an agent. See AgentConfig.SetupSubAgent
+type subAgents struct {
+	mu    sync.Mutex
+	cfg   AgentConfig
+	setup func(*Agent)
+	m     map[string]*subAgent
+}
+
+// subAgent is an agent that handles the requests naming it in agentReq.Agent
+type subAgent struct {
+	*Agent
+
+	// stop stops handling requests. See Agent.start
+	stop func()
+}
+
+// get returns the sub-agent named name, starting it if necessary
+func (sa *subAgents) get(parent *Agent, name string) (*Agent, error) {
+	sa.mu.Lock()
+	defer sa.mu.Unlock()
+
+	if sa.setup == nil {
+		return nil, fmt.Errorf("sub-agents are not enabled")
+	}
+	if s := sa.m[name]; s != nil {
+		return s.Agent, nil
+	}
+
+	cfg := sa.cfg
+	cfg.Stdin = &mgutil.IOWrapper{}
+	cfg.Stdout = &mgutil.IOWrapper{}
+	cfg.Stderr = parent.stderr
+	cfg.Listen = ""
+	cfg.RecordTo = ""
+	cfg.DebugAddr = ""
+	cfg.SetupSubAgent = nil
+	ag, err := NewAgent(cfg)
+	if err != nil {
+		return nil, err
+	}
+	ag.parent = parent
+	ag.subName = name
+	sa.setup(ag)
+
+	if sa.m == nil {
+		sa.m = map[string]*subAgent{}
+	}
+	sa.m[name] = &subAgent{Agent: ag, stop: ag.start()}
+	parent.Log.Printf("started sub-agent %s\n", name)
+	return ag, nil
+}
+
+// names returns the sorted list of names of the running sub-agents
+func (sa *subAgents) names() []string {
+	sa.mu.Lock()
+	defer sa.mu.Unlock()
+
+	l := make([]string, 0, len(sa.m))
+	for name := range sa.m {
+		l = append(l, name)
+	}
+	sort.Strings(l)
+	return l
+}
+
+// cancelClient cancels all in-flight requests sent by client c to the sub-agents
+func (sa *subAgents) cancelClient(c *agentClient) {
+	sa.mu.Lock()
+	defer sa.mu.Unlock()
+
+	for _, s := range sa.m {
+		s.reqs.cancelClient(c)
+	}
+}
+
+// stop waits for the sub-agents to finish their in-flight requests then shuts them down
+func (sa *subAgents) stop() {
+	sa.mu.Lock()
+	l := sa.m
+	sa.m = nil
+	sa.mu.Unlock()
+
+	for _, s := range l {
+		s.stop()
+		s.shutdown()
+	}
+}
+
+// routeReq hands rq to the sub-agent named in rq.Agent, or handles it if none is named
+func (ag *Agent) routeReq(rq *agentReq) {
+	if rq.Agent == "" {
+		ag.handleReq(rq)
+		return
+	}
+
+	sub, err := ag.subs.get(ag, rq.Agent)
+	if err != nil {
+		msg := fmt.Sprintf("cannot start sub-agent %s: %s", rq.Agent, err)
+		rq.results = make([]actionResult, len(rq.Actions))
+		for i, ra := range rq.Actions {
+			rq.results[i] = actionResult{Name: ra.Name, Error: msg}
+		}
+		ag.send(agentRes{Cookie: rq.Cookie, Error: msg, req: rq})
+		return
+	}
+	if v := rq.Props.View; v != nil {
+		// the view's source is cached in the sub-agent's store
+		v.kvs = sub.Store
+	}
+	sub.active()
+	sub.handleReq(rq)
+}
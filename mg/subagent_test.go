@@ -0,0 +1,77 @@
+package mg
+
+import (
+	"bytes"
+	"github.com/ugorji/go/codec"
+	"margo.sh/mgutil"
+	"sync"
+	"testing"
+)
+
+func TestAgentSubAgents(t *testing.T) {
+	out := &bytes.Buffer{}
+	in := &bytes.Buffer{}
+	enc := codec.NewEncoder(in, codecHandles["msgpack"])
+	for _, rq := range []map[string]interface{}{
+		{"Cookie": "main", "Actions": []map[string]string{{"Name": "QueryIssues"}}},
+		{"Cookie": "sub", "Agent": "ws1", "Actions": []map[string]string{{"Name": "QueryIssues"}}},
+	} {
+		if err := enc.Encode(rq); err != nil {
+			t.Fatalf("enc.Encode(): %s", err)
+		}
+	}
+	var mu sync.Mutex
+	subs := map[*Store]string{}
+	ag, err := NewAgent(AgentConfig{
+		Stdin:  &mgutil.IOWrapper{Reader: in},
+		Stdout: &mgutil.IOWrapper{Writer: out},
+		Stderr: &mgutil.IOWrapper{},
+		Codec:  "msgpack",
+		SetupSubAgent: func(ag *Agent) {
+			ag.Store.Use(NewReducer(func(mx *Ctx) *State {
+				mu.Lock()
+				defer mu.Unlock()
+				subs[mx.Store] = ag.subName
+				return mx.State
+			}))
+		},
+	})
+	if err != nil {
+		t.Fatalf("agent creation failed: %s", err)
+	}
+	if err := ag.Run(); err != nil {
+		t.Fatalf("ag.Run() = (%v); want (nil)", err)
+	}
+
+	got := map[string]string{}
+	dec := codec.NewDecoder(out, ag.handle)
+	for {
+		var res struct{ Cookie, Agent, Error string }
+		if err := dec.Decode(&res); err != nil {
+			break
+		}
+		if res.Error != "" {
+			t.Errorf("response to %s has error: %s", res.Cookie, res.Error)
+		}
+		if res.Cookie != "" {
+			got[res.Cookie] = res.Agent
+		}
+	}
+	if a, ok := got["main"]; !ok || a != "" {
+		t.Errorf("response to main request = (%q, %v); want it from the main agent", a, ok)
+	}
+	if a := got["sub"]; a != "ws1" {
+		t.Errorf("response to sub request is from agent (%q); want (ws1)", a)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(subs) != 1 {
+		t.Fatalf("sub-agent reducers ran in %d stores; want 1", len(subs))
+	}
+	for sto, name := range subs {
+		if sto == ag.Store || name != "ws1" {
+			t.Errorf("sub-agent reducer ran in store of agent (%q); want sub-agent ws1 with its own store", name)
+		}
+	}
+}
@@ -31,6 +31,15 @@ func (ti *TaskTicket) Done() {
 	}
 }
 
+// SetTitle sets the title of the task, as displayed in the status and the task list
+func (ti *TaskTicket) SetTitle(title string) {
+	if tr := ti.tracker; tr != nil {
+		tr.mu.Lock()
+		defer tr.mu.Unlock()
+	}
+	ti.Title = title
+}
+
 func (ti *TaskTicket) Cancel() {
 	if f := ti.Task.Cancel; f != nil {
 		f()
@@ -56,7 +65,8 @@ func (tr *taskTracker) RInit(mx *Ctx) {
 	tr.mu.Lock()
 	defer tr.mu.Unlock()
 
-	tr.dispatch = mx.Store.Dispatch
+	sto := mx.Store
+	tr.dispatch = func(act Action) { sto.DispatchPriority(act, PriorityHousekeeping) }
 }
 
 func (tr *taskTracker) RUnmount(*Ctx) {
@@ -262,6 +272,18 @@ func (tr *taskTracker) done(id string) {
 	tr.tickets = l
 }
 
+// list returns the status of all active tasks
+func (tr *taskTracker) list() []TaskStatus {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	l := make([]TaskStatus, len(tr.tickets))
+	for i, t := range tr.tickets {
+		l[i] = TaskStatus{ID: t.ID, Title: t.Title, Start: t.Start}
+	}
+	return l
+}
+
 func (tr *taskTracker) Begin(o Task) *TaskTicket {
 	tr.mu.Lock()
 	defer tr.mu.Unlock()
@@ -0,0 +1,39 @@
+package mg
+
+import (
+	"margo.sh/mg/actions"
+)
+
+// TestMarkers is the client action listing the test functions of a view,
+// so the client can show a "run test" marker in the gutter, next to each of them.
+//
+// It's sent when a test file is activated, and when the list of its functions, or their position, changes.
+// When a marker is clicked, the client should run its Cmd as it would a UserCmd.
+type TestMarkers struct {
+	ActionType
+
+	// Path is the View.Path of the view
+	Path string
+
+	// Name is the View.Name of the view
+	Name string
+
+	// Markers is the list of markers, in the order of the functions in the view
+	Markers []TestMarker
+}
+
+func (tm TestMarkers) ClientAction() actions.ClientData {
+	return actions.ClientData{Name: "TestMarkers", Data: tm}
+}
+
+// TestMarker is a "run test" marker. See TestMarkers
+type TestMarker struct {
+	// Row is the row of the function's name
+	Row int
+
+	// Func is the name of the function e.g. TestFoo
+	Func string
+
+	// Cmd is the command that runs the function
+	Cmd UserCmd
+}
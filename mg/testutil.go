@@ -48,6 +48,8 @@ func NewTestingStore() *Store {
 
 // NewTestingCtx creates a new Ctx for testing
 // It's equivalent to NewTestingStore().NewCtx()
+//
+// To test how reducers handle a sequence of views and actions, see NewHarness
 func NewTestingCtx(act Action) *Ctx {
 	return NewTestingStore().NewCtx(act)
 }
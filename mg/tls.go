@@ -0,0 +1,81 @@
+package mg
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+)
+
+// listenTLS wraps l so that client connections are encrypted using a self-signed certificate generated for it.
+//
+// It returns the SHA-256 fingerprint of the certificate, which clients should use to verify it.
+func listenTLS(l net.Listener) (net.Listener, string, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot generate TLS key: %s", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot generate TLS certificate serial number: %s", err)
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "margo.sh"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot create TLS certificate: %s", err)
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		MinVersion:   tls.VersionTLS12,
+	}
+	return tls.NewListener(l, cfg), certFingerprint(der), nil
+}
+
+// certFingerprint returns the SHA-256 fingerprint of the DER-encoded certificate der
+// in the form `AB:CD:...`, as printed by `openssl x509 -fingerprint -sha256`
+func certFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	l := make([]string, len(sum))
+	for i, b := range sum {
+		l[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(l, ":")
+}
+
+// dialTLSConfig returns the config for connecting to an agent whose certificate has the given fingerprint.
+//
+// The certificate is self-signed so it's verified by its fingerprint instead of its chain of trust.
+func dialTLSConfig(fingerprint string) *tls.Config {
+	want := strings.ToUpper(strings.Replace(fingerprint, ":", "", -1))
+	return &tls.Config{
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(certs [][]byte, _ [][]*x509.Certificate) error {
+			if len(certs) == 0 {
+				return fmt.Errorf("the agent sent no certificate")
+			}
+			got := strings.Replace(certFingerprint(certs[0]), ":", "", -1)
+			if got != want {
+				return fmt.Errorf("the agent's certificate fingerprint %s doesn't match", certFingerprint(certs[0]))
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,44 @@
+package mg
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAgentListenTLS(t *testing.T) {
+	ag, addr, runErr := listenTestAgent(t, func(cfg *AgentConfig) {
+		cfg.Listen = "tls:127.0.0.1:0"
+	})
+	fp := ag.listen.fingerprint
+	if fp == "" {
+		t.Fatal("the agent's certificate fingerprint wasn't set")
+	}
+
+	if _, err := tls.Dial("tcp", addr, dialTLSConfig(strings.Repeat("00:", 31)+"00")); err == nil {
+		t.Error("tls.Dial() with the wrong fingerprint = (nil); want (error)")
+	}
+
+	conn, err := tls.Dial("tcp", addr, dialTLSConfig(fp))
+	if err != nil {
+		t.Fatalf("tls.Dial(%s): %s", addr, err)
+	}
+	fmt.Fprintln(conn, "secret")
+	go io.Copy(ioutil.Discard, conn)
+
+	if _, err := QueryAgentStatus("tls:"+addr, "secret", "", "msgpack", 5*time.Second); err == nil {
+		t.Error("QueryAgentStatus() without a fingerprint = (nil); want (error)")
+	}
+	if _, err := QueryAgentStatus("tls:"+addr, "secret", fp, "msgpack", 5*time.Second); err != nil {
+		t.Errorf("QueryAgentStatus() = (%v); want (nil)", err)
+	}
+
+	conn.Close()
+	if err := <-runErr; err != nil {
+		t.Fatalf("ag.Run() = (%v); want (nil)", err)
+	}
+}
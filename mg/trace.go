@@ -0,0 +1,53 @@
+package mg
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	// tracePrefix is the prefix of log records written by a Logger returned by Logger.traced
+	tracePrefix = "trace:"
+)
+
+// traceSeq is used to generate trace IDs if the random source fails
+var traceSeq uint64
+
+// newTraceID returns a new random ID used to identify a request in logs and profiles
+func newTraceID() string {
+	p := make([]byte, 8)
+	if _, err := rand.Read(p); err != nil {
+		return "seq" + strconv.FormatUint(atomic.AddUint64(&traceSeq, 1), 10)
+	}
+	return hex.EncodeToString(p)
+}
+
+// traced returns a copy of l whose records are prefixed with `trace:$id`.
+// If id is empty, l is returned.
+func (l *Logger) traced(id string) *Logger {
+	if id == "" {
+		return l
+	}
+	pfx := tracePrefix + id + " "
+	return &Logger{
+		Logger: log.New(l.Logger.Writer(), pfx+l.Logger.Prefix(), l.Logger.Flags()),
+		Dbg:    log.New(l.Dbg.Writer(), pfx+l.Dbg.Prefix(), l.Dbg.Flags()),
+	}
+}
+
+// splitTrace splits the log record s into its trace ID and the rest of the record
+func splitTrace(s string) (id, rest string) {
+	if !strings.HasPrefix(s, tracePrefix) {
+		return "", s
+	}
+	s = s[len(tracePrefix):]
+	i := strings.IndexByte(s, ' ')
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], s[i+1:]
+}
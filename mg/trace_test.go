@@ -0,0 +1,66 @@
+package mg
+
+import (
+	"bytes"
+	"github.com/ugorji/go/codec"
+	"margo.sh/mg/actions"
+	"margo.sh/mgutil"
+	"strings"
+	"testing"
+)
+
+func TestAgentTraceID(t *testing.T) {
+	out := &bytes.Buffer{}
+	logs := &bytes.Buffer{}
+	ag, err := NewAgent(AgentConfig{
+		Stdin:  &mgutil.IOWrapper{},
+		Stdout: &mgutil.IOWrapper{Writer: out},
+		Stderr: &mgutil.IOWrapper{Writer: logs},
+		Codec:  "msgpack",
+	})
+	if err != nil {
+		t.Fatalf("agent creation failed: %s", err)
+	}
+	ag.Store.Subscribe(ag.sub)
+	var traceID string
+	ag.Store.Use(NewReducer(func(mx *Ctx) *State {
+		if _, ok := mx.Action.(QueryIssues); ok {
+			traceID = mx.TraceID
+			mx.Log.Println("traced record")
+		}
+		return mx.State
+	}))
+
+	dec := codec.NewDecoder(out, ag.handle)
+	for _, id := range []string{"client-trace", ""} {
+		rq := newAgentReq(ag.Store)
+		rq.Cookie = "trace"
+		rq.TraceID = id
+		rq.Actions = []actions.ActionData{{Name: "QueryIssues"}}
+		rq.finalize(ag)
+		ag.Store.handleReq(rq)
+
+		if id == "" && rq.TraceID == "" {
+			t.Fatal("rq.TraceID wasn't generated")
+		}
+		if traceID != rq.TraceID {
+			t.Errorf("mx.TraceID = (%s); want (%s)", traceID, rq.TraceID)
+		}
+		var res struct{ TraceID string }
+		if err := dec.Decode(&res); err != nil {
+			t.Fatalf("dec.Decode(): %s", err)
+		}
+		if res.TraceID != rq.TraceID {
+			t.Errorf("res.TraceID = (%s); want (%s)", res.TraceID, rq.TraceID)
+		}
+		if s := tracePrefix + rq.TraceID + " "; !strings.Contains(logs.String(), s) {
+			t.Errorf("log output = (%q); want records prefixed with (%q)", logs.String(), s)
+		}
+	}
+
+	lw := &logWriter{prefix: "DBG: "}
+	lm := lw.record(tracePrefix + "abc DBG: agent_test.go:1: msg\n")
+	if lm.TraceID != "abc" || lm.Source != "agent_test.go:1" || lm.Message != "msg" {
+		t.Errorf("lw.record() = (%+v); want TraceID abc, Source agent_test.go:1 and Message msg", lm)
+	}
+}
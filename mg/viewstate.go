@@ -0,0 +1,131 @@
+package mg
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// maxViewStates is the number of views whose ViewState is kept.
+	// When it's exceeded, the state of the least recently used view is dropped.
+	maxViewStates = 256
+
+	// viewStateGCInterval is the minimum interval between checks for views that expired. See Store.SetViewStateTTL
+	viewStateGCInterval = time.Minute
+)
+
+var (
+	// DefaultViewStateTTL is the default duration for which the ViewState of a view is kept after it was last seen.
+	// See Store.SetViewStateTTL
+	DefaultViewStateTTL = 30 * time.Minute
+)
+
+// viewStates holds the ViewState of each view
+type viewStates struct {
+	mu     sync.Mutex
+	seq    uint64
+	m      map[string]*viewState
+	ttl    time.Duration
+	lastGC time.Time
+}
+
+type viewState struct {
+	kvs  *KVMap
+	used uint64
+
+	// seen is the time at which the view was last the subject of a reduction
+	seen time.Time
+}
+
+// ViewState returns the partition of the store holding the data of the view identified by viewID i.e. View.Name.
+//
+// Unlike Store.KVMap, which is cleared when the active view changes, it's kept while other views are active,
+// so reducers can cache per-view data e.g. issues or completions, without clobbering the data of other open files.
+// The state of a view is dropped when the view is closed, or it wasn't seen for a while (see Store.SetViewStateTTL).
+// The states of the least recently used views are also dropped when there are too many of them.
+//
+// If viewID is empty, nil is returned; operations on it are no-ops.
+func (sto *Store) ViewState(viewID string) *KVMap {
+	if viewID == "" {
+		return nil
+	}
+
+	vs := &sto.viewStates
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	vs.seq++
+	if st, ok := vs.m[viewID]; ok {
+		st.used = vs.seq
+		return st.kvs
+	}
+	if vs.m == nil {
+		vs.m = map[string]*viewState{}
+	}
+	if len(vs.m) >= maxViewStates {
+		vs.evict()
+	}
+	st := &viewState{kvs: &KVMap{}, used: vs.seq, seen: time.Now()}
+	vs.m[viewID] = st
+	return st.kvs
+}
+
+// evict drops the least recently used view state. vs.mu must be held
+func (vs *viewStates) evict() {
+	oldID, oldUsed := "", uint64(0)
+	for id, st := range vs.m {
+		if oldID == "" || st.used < oldUsed {
+			oldID, oldUsed = id, st.used
+		}
+	}
+	delete(vs.m, oldID)
+}
+
+// ViewState returns the partition of the store holding the data of the current view.
+// See Store.ViewState
+func (mx *Ctx) ViewState() *KVMap {
+	return mx.Store.ViewState(mx.View.Name)
+}
+
+// drop drops the state of the view identified by viewID
+func (vs *viewStates) drop(viewID string) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	delete(vs.m, viewID)
+}
+
+// SetViewStateTTL sets the duration for which the ViewState of a view is kept after the view was last seen
+// i.e. was the current view of a reduction. It defaults to DefaultViewStateTTL.
+//
+// Expired states are dropped during later reductions, so data cached for files that are no longer being edited
+// doesn't accumulate during long sessions. If d isn't greater than zero, states don't expire.
+func (sto *Store) SetViewStateTTL(d time.Duration) *Store {
+	vs := &sto.viewStates
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	vs.ttl = d
+	return sto
+}
+
+// observe records that the view identified by viewID was seen at time now,
+// and drops the states of the views that expired
+func (vs *viewStates) observe(viewID string, now time.Time) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	if st, ok := vs.m[viewID]; ok {
+		st.seen = now
+	}
+
+	if vs.ttl <= 0 || now.Sub(vs.lastGC) < viewStateGCInterval {
+		return
+	}
+	vs.lastGC = now
+	for id, st := range vs.m {
+		if now.Sub(st.seen) > vs.ttl {
+			delete(vs.m, id)
+		}
+	}
+}
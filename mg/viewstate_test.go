@@ -0,0 +1,64 @@
+package mg
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestStoreViewState(t *testing.T) {
+	ag := NewTestingAgent(nil, nil, nil)
+	sto := ag.Store
+	sto.ViewState("view-1").Put("k", 1)
+	sto.ViewState("view-2").Put("k", 2)
+	sto.initCache(&View{Name: "view-2"})
+
+	if v := sto.ViewState("view-1").Get("k"); v != 1 {
+		t.Errorf(`ViewState("view-1").Get("k") = (%v); want (1)`, v)
+	}
+	if v := sto.ViewState("view-2").Get("k"); v != 2 {
+		t.Errorf(`ViewState("view-2").Get("k") = (%v); want (2)`, v)
+	}
+	if kvs := sto.ViewState(""); kvs != nil {
+		t.Errorf(`ViewState("") = (%v); want (nil)`, kvs)
+	}
+
+	for i := 0; i < maxViewStates; i++ {
+		sto.ViewState("view-1")
+		sto.ViewState(fmt.Sprintf("other-%d", i))
+	}
+	if v := sto.ViewState("view-1").Get("k"); v != 1 {
+		t.Errorf(`ViewState("view-1") was evicted despite being used recently`)
+	}
+	if v := sto.ViewState("view-2").Get("k"); v != nil {
+		t.Errorf(`ViewState("view-2") wasn't evicted; want the least recently used view to be evicted`)
+	}
+}
+
+func TestStoreViewStateTTL(t *testing.T) {
+	sto := NewTestingStore().SetViewStateTTL(time.Hour)
+	vs := &sto.viewStates
+	sto.ViewState("view-1").Put("k", 1)
+	sto.ViewState("view-2").Put("k", 2)
+
+	now := time.Now()
+	vs.observe("view-1", now.Add(59*time.Minute+30*time.Second))
+	vs.observe("view-1", now.Add(60*time.Minute+10*time.Second))
+	if v := sto.ViewState("view-2").Get("k"); v != 2 {
+		t.Errorf(`ViewState("view-2") was dropped before the GC interval passed`)
+	}
+	vs.observe("view-1", now.Add(61*time.Minute))
+	if v := sto.ViewState("view-1").Get("k"); v != 1 {
+		t.Errorf(`ViewState("view-1") was dropped although it was seen recently`)
+	}
+	if v := sto.ViewState("view-2").Get("k"); v != nil {
+		t.Errorf(`ViewState("view-2") wasn't dropped after it expired`)
+	}
+
+	sto.SetViewStateTTL(0)
+	sto.ViewState("view-2").Put("k", 2)
+	vs.observe("view-1", now.Add(24*time.Hour))
+	if v := sto.ViewState("view-2").Get("k"); v != 2 {
+		t.Errorf(`ViewState("view-2") was dropped although states don't expire`)
+	}
+}
@@ -0,0 +1,226 @@
+package mg
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"github.com/ugorji/go/codec"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+// wsConn is a minimal server-side WebSocket (RFC 6455) connection.
+//
+// It's used when listening on a `ws:` address, so that browser-based editors can talk to the agent.
+// Each message sent by the client contains one or more encoded requests, which are read as a stream.
+// Each response is sent as a single message: text when using the json codec, binary otherwise.
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	op   byte
+
+	// mu protects writes to conn
+	mu     sync.Mutex
+	closed bool
+
+	// msg is the message being written. It's sent when Flush is called
+	msg bytes.Buffer
+
+	// the state of the frame being read
+	remaining uint64
+	masked    bool
+	mask      [4]byte
+	maskPos   int
+}
+
+// handshakeWS reads the client's WebSocket upgrade request, verifies its token
+// and completes the handshake.
+//
+// Since browsers can't set arbitrary headers, the token is sent in the `token` query parameter.
+func (ag *Agent) handshakeWS(conn net.Conn) (*wsConn, error) {
+	conn.SetReadDeadline(time.Now().Add(ListenHandshakeTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	br := bufio.NewReader(conn)
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return nil, err
+	}
+	reject := func(status int, msg string) error {
+		fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s",
+			status, http.StatusText(status), len(msg), msg)
+		return fmt.Errorf("%s", msg)
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if !strings.EqualFold(req.Header.Get("Upgrade"), "websocket") || key == "" {
+		return nil, reject(http.StatusBadRequest, "not a websocket upgrade request")
+	}
+	tok := req.URL.Query().Get("token")
+	if subtle.ConstantTimeCompare([]byte(tok), []byte(ag.listen.token)) != 1 {
+		return nil, reject(http.StatusForbidden, "invalid token")
+	}
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	_, err = fmt.Fprintf(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(sum[:]))
+	if err != nil {
+		return nil, err
+	}
+
+	ws := &wsConn{conn: conn, br: br, op: wsOpBinary}
+	if _, ok := ag.handle.(*codec.JsonHandle); ok {
+		ws.op = wsOpText
+	}
+	return ws, nil
+}
+
+// Read reads the payload of the messages sent by the client.
+// Control frames are handled transparently, and a close frame is reported as io.EOF.
+func (ws *wsConn) Read(p []byte) (int, error) {
+	for ws.remaining == 0 {
+		if err := ws.readHeader(); err != nil {
+			return 0, err
+		}
+	}
+
+	if uint64(len(p)) > ws.remaining {
+		p = p[:ws.remaining]
+	}
+	n, err := ws.br.Read(p)
+	ws.unmask(p[:n])
+	ws.remaining -= uint64(n)
+	return n, err
+}
+
+// readHeader reads the next frame header, and handles the frame if it's a control frame
+func (ws *wsConn) readHeader() error {
+	var hdr [2]byte
+	if _, err := io.ReadFull(ws.br, hdr[:]); err != nil {
+		return err
+	}
+	op := hdr[0] & 0x0f
+	ws.masked = hdr[1]&0x80 != 0
+	n := uint64(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err := io.ReadFull(ws.br, b[:]); err != nil {
+			return err
+		}
+		n = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err := io.ReadFull(ws.br, b[:]); err != nil {
+			return err
+		}
+		n = binary.BigEndian.Uint64(b[:])
+	}
+	ws.maskPos = 0
+	if ws.masked {
+		if _, err := io.ReadFull(ws.br, ws.mask[:]); err != nil {
+			return err
+		}
+	}
+
+	switch op {
+	case wsOpContinuation, wsOpText, wsOpBinary:
+		ws.remaining = n
+		return nil
+	}
+
+	if n > 125 {
+		return fmt.Errorf("websocket: control frame too large")
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(ws.br, payload); err != nil {
+		return err
+	}
+	ws.unmask(payload)
+	switch op {
+	case wsOpPing:
+		return ws.writeFrame(wsOpPong, payload)
+	case wsOpClose:
+		ws.writeFrame(wsOpClose, nil)
+		return io.EOF
+	}
+	return nil
+}
+
+func (ws *wsConn) unmask(p []byte) {
+	if !ws.masked {
+		return
+	}
+	for i := range p {
+		p[i] ^= ws.mask[ws.maskPos%4]
+		ws.maskPos++
+	}
+}
+
+// Write adds p to the message being written
+func (ws *wsConn) Write(p []byte) (int, error) {
+	return ws.msg.Write(p)
+}
+
+// Flush sends the message being written, if any
+func (ws *wsConn) Flush() error {
+	if ws.msg.Len() == 0 {
+		return nil
+	}
+	defer ws.msg.Reset()
+	return ws.writeFrame(ws.op, ws.msg.Bytes())
+}
+
+// Close sends a close frame and closes the connection
+func (ws *wsConn) Close() error {
+	ws.writeFrame(wsOpClose, nil)
+	return ws.conn.Close()
+}
+
+func (ws *wsConn) writeFrame(op byte, payload []byte) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if ws.closed {
+		return fmt.Errorf("websocket: connection closed")
+	}
+	ws.closed = op == wsOpClose
+
+	hdr := make([]byte, 2, 10)
+	hdr[0] = 0x80 | op
+	switch n := len(payload); {
+	case n < 126:
+		hdr[1] = byte(n)
+	case n <= 0xffff:
+		hdr[1] = 126
+		hdr = hdr[:4]
+		binary.BigEndian.PutUint16(hdr[2:], uint16(n))
+	default:
+		hdr[1] = 127
+		hdr = hdr[:10]
+		binary.BigEndian.PutUint64(hdr[2:], uint64(n))
+	}
+	if _, err := ws.conn.Write(hdr); err != nil {
+		return err
+	}
+	_, err := ws.conn.Write(payload)
+	return err
+}
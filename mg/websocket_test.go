@@ -0,0 +1,90 @@
+package mg
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/ugorji/go/codec"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestAgentWebSocket(t *testing.T) {
+	ag, addr, runErr := listenTestAgent(t, func(cfg *AgentConfig) {
+		cfg.Listen = "ws:127.0.0.1:0"
+	})
+
+	upgrade := func(token string) (net.Conn, *bufio.Reader, *http.Response) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("net.Dial(%s): %s", addr, err)
+		}
+		fmt.Fprintf(conn, "GET /?token=%s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+			"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n", token, addr)
+		br := bufio.NewReader(conn)
+		res, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("http.ReadResponse(): %s", err)
+		}
+		return conn, br, res
+	}
+
+	bad, _, res := upgrade("wrong")
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("upgrade with an invalid token: status = (%d); want (%d)", res.StatusCode, http.StatusForbidden)
+	}
+	bad.Close()
+
+	conn, br, res := upgrade("secret")
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("upgrade: status = (%d); want (%d)", res.StatusCode, http.StatusSwitchingProtocols)
+	}
+	if s := res.Header.Get("Sec-WebSocket-Accept"); s != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Sec-WebSocket-Accept = (%s); want (s3pPLMBiTxaQ9kYGzzhZRbK+xOo=)", s)
+	}
+
+	var req []byte
+	codec.NewEncoderBytes(&req, ag.handle).Encode(map[string]string{"Cookie": "ws"})
+	mask := []byte{1, 2, 3, 4}
+	frame := append([]byte{0x82, 0x80 | byte(len(req))}, mask...)
+	for i, b := range req {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatalf("conn.Write(): %s", err)
+	}
+
+	for {
+		hdr := make([]byte, 2)
+		if _, err := io.ReadFull(br, hdr); err != nil {
+			t.Fatalf("reading frame header: %s", err)
+		}
+		n := int(hdr[1] & 0x7f)
+		switch n {
+		case 126:
+			b := make([]byte, 2)
+			io.ReadFull(br, b)
+			n = int(b[0])<<8 | int(b[1])
+		case 127:
+			t.Fatalf("unexpectedly large frame")
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			t.Fatalf("reading frame payload: %s", err)
+		}
+		var res struct{ Cookie string }
+		if err := codec.NewDecoderBytes(payload, ag.handle).Decode(&res); err != nil {
+			t.Fatalf("frame doesn't contain a single response: %s", err)
+		}
+		if res.Cookie == "ws" {
+			break
+		}
+	}
+
+	conn.Write([]byte{0x88, 0x80, 0, 0, 0, 0})
+	conn.Close()
+	if err := <-runErr; err != nil {
+		t.Fatalf("ag.Run() = (%v); want (nil)", err)
+	}
+}
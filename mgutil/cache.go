@@ -0,0 +1,66 @@
+package mgutil
+
+// KVStore is the subset of mg.KVStore used by Cache.
+//
+// mg.KVStore and its implementations e.g. mg.Store, mg.KVMap and mg.KVLRU, satisfy it.
+type KVStore interface {
+	Put(key, value interface{})
+	Get(key interface{}) interface{}
+	Del(key interface{})
+}
+
+// cacheKey is the key in the underlying store of the value of Cache name identified by key
+type cacheKey[K comparable] struct {
+	name string
+	key  K
+}
+
+// Cache is a typed view of a KVStore, holding values of type V identified by keys of type K.
+//
+// Its keys don't collide with those of the store, or other caches with a different name or key type,
+// so the values can't be mixed up with those stored by other code.
+type Cache[K comparable, V any] struct {
+	kvs  KVStore
+	name string
+}
+
+// NewCache returns a new Cache named name, that stores its values in kvs
+func NewCache[K comparable, V any](kvs KVStore, name string) *Cache[K, V] {
+	return &Cache[K, V]{kvs: kvs, name: name}
+}
+
+func (c *Cache[K, V]) key(k K) cacheKey[K] {
+	return cacheKey[K]{name: c.name, key: k}
+}
+
+// Get returns the value identified by k.
+//
+// If there's no value, the zero value of V and false are returned.
+func (c *Cache[K, V]) Get(k K) (V, bool) {
+	v, ok := c.kvs.Get(c.key(k)).(V)
+	return v, ok
+}
+
+// Put stores the value v identified by k
+func (c *Cache[K, V]) Put(k K, v V) {
+	c.kvs.Put(c.key(k), v)
+}
+
+// GetOrCompute returns the value identified by k.
+// If there's no such value, it's created by calling compute and stored in the cache.
+//
+// compute is not synchronised so it may be called concurrently for the same key;
+// the value stored by the last call wins.
+func (c *Cache[K, V]) GetOrCompute(k K, compute func() V) V {
+	if v, ok := c.Get(k); ok {
+		return v
+	}
+	v := compute()
+	c.Put(k, v)
+	return v
+}
+
+// Invalidate removes the value identified by k
+func (c *Cache[K, V]) Invalidate(k K) {
+	c.kvs.Del(c.key(k))
+}
@@ -0,0 +1,68 @@
+package mgutil
+
+import (
+	"sync"
+	"testing"
+)
+
+type testKVStore struct {
+	mu   sync.Mutex
+	vals map[interface{}]interface{}
+}
+
+func (kvs *testKVStore) Put(k, v interface{}) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+
+	kvs.vals[k] = v
+}
+
+func (kvs *testKVStore) Get(k interface{}) interface{} {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+
+	return kvs.vals[k]
+}
+
+func (kvs *testKVStore) Del(k interface{}) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+
+	delete(kvs.vals, k)
+}
+
+func TestCache(t *testing.T) {
+	kvs := &testKVStore{vals: map[interface{}]interface{}{}}
+	docs := NewCache[string, []string](kvs, "docs")
+	lens := NewCache[string, int](kvs, "lens")
+
+	calls := 0
+	compute := func() []string {
+		calls++
+		return []string{"Println"}
+	}
+	if v := docs.GetOrCompute("fmt", compute); len(v) != 1 || v[0] != "Println" {
+		t.Errorf("GetOrCompute(fmt) = (%v); want the computed value", v)
+	}
+	docs.GetOrCompute("fmt", compute)
+	if calls != 1 {
+		t.Errorf("compute was called %d times; want the cached value to be reused", calls)
+	}
+
+	lens.Put("fmt", 1)
+	kvs.Put("fmt", "raw")
+	if n, ok := lens.Get("fmt"); !ok || n != 1 {
+		t.Errorf("lens.Get(fmt) = (%v, %v); want (1, true)", n, ok)
+	}
+	if v, ok := docs.Get("fmt"); !ok || len(v) != 1 {
+		t.Errorf("docs.Get(fmt) = (%v, %v); want the value to not collide with other caches", v, ok)
+	}
+
+	docs.Invalidate("fmt")
+	if v, ok := docs.Get("fmt"); ok || v != nil {
+		t.Errorf("docs.Get(fmt) = (%v, %v) after Invalidate; want (nil, false)", v, ok)
+	}
+	if _, ok := lens.Get("fmt"); !ok {
+		t.Error("docs.Invalidate(fmt) removed the value of lens")
+	}
+}